@@ -0,0 +1,78 @@
+package admin
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type actionsCmd struct {
+	ChatID string `long:"chat-id" description:"list actions in this chat only"`
+	UserID string `long:"user-id" description:"list actions on messages of this user only"`
+	Action string `long:"action" description:"list this action only, e.g. ban; noop lists messages let through"`
+	Days   int    `long:"days" default:"7" description:"list actions of this number of last days, 0 lists all"`
+	Limit  int    `long:"limit" default:"50" description:"number of actions listed"`
+}
+
+// Execute lists the messages the bot removed or failed to check, newest
+// first, or those of the action asked for
+func (c *actionsCmd) Execute([]string) error {
+	filter := storage.MessageFilter{ChatID: c.ChatID, SenderID: c.UserID, Action: e.ActionKind(c.Action)}
+	if c.Days > 0 {
+		filter.From = time.Now().AddDate(0, 0, -c.Days)
+	}
+	messages, err := run.db.ListMessages(run.ctx, filter)
+	if err != nil {
+		return fmt.Errorf("listing messages: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(run.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "time\tchat\tmessage\tuser\taction\tcategory\tnote\ttext")
+	listed := 0
+	for _, msg := range messages {
+		if listed == c.Limit {
+			break
+		}
+
+		action := ""
+		switch {
+		case msg.Action != nil:
+			action = string(*msg.Action)
+		case msg.Error != nil:
+			action = "error"
+		}
+		if action == "" || (c.Action == "" && action == string(e.ActionKindNoop)) {
+			continue
+		}
+
+		var category, note string
+		if msg.Category != nil {
+			category = string(*msg.Category)
+		}
+		switch {
+		case msg.ActionNote != nil:
+			note = *msg.ActionNote
+		case msg.Error != nil:
+			note = *msg.Error
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			msg.CreatedAt.Local().Format(time.DateTime), msg.Sender.ChatID, msg.ID, msg.Sender.ID,
+			action, category, oneLine(note, 40), oneLine(msg.Text, 60))
+		listed++
+	}
+	return tw.Flush()
+}
+
+// oneLine returns the text on one line, cut to n runes
+func oneLine(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
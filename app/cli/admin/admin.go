@@ -0,0 +1,87 @@
+// Package admin implements the admin command, a terminal alternative to the
+// bot's Telegram commands for operators: it shows, sets and resets user
+// scores, whitelists users as trusted, blacklists spam texts, edits per-chat
+// settings and lists recent actions. Changes are recorded in the audit log
+// with --actor as the actor.
+package admin
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/jessevdk/go-flags"
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/redis"
+)
+
+var opts struct {
+	cli.DBOptions
+	Actor    string `long:"actor" default:"admin" description:"actor recorded in the audit log for the changes"`
+	RedisURL string `long:"redis-url" env:"REDIS_URL" description:"redis url of the bot's shared scores, to drop the scores changed from it"`
+
+	Score     scoreCmd     `command:"score" description:"show, set or reset the score of a user"`
+	Whitelist whitelistCmd `command:"whitelist" description:"make a user trusted, so the bot doesn't check their messages"`
+	Blacklist blacklistCmd `command:"blacklist" description:"add, remove or list spam texts erased on sight"`
+	Settings  settingsCmd  `command:"settings" description:"show, replace, patch or delete the stored settings of a chat"`
+	Actions   actionsCmd   `command:"actions" description:"list the recent actions of the bot"`
+}
+
+// run is what the commands run with, set up before the command is executed
+var run struct {
+	ctx context.Context
+	db  storage.Store
+	out io.Writer
+
+	// redisScores are the bot's scores in Redis, nil without --redis-url
+	redisScores *storage.RedisScores
+}
+
+// Run runs the admin command, args being its name and options as in os.Args
+func Run(args []string) {
+	log := logger.NewLogger()
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	parser := cli.NewParser(&opts, cli.Name(args))
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		if cmd == nil {
+			return nil
+		}
+
+		db, err := storage.Open(ctx, opts.DBPath)
+		if err != nil {
+			log.Error("opening database", "error", err)
+			os.Exit(1)
+		}
+
+		run.ctx, run.db, run.out = ctx, db, os.Stdout
+		if opts.RedisURL != "" {
+			redisOpts, err := redis.ParseURL(opts.RedisURL)
+			if err != nil {
+				log.Error("parsing redis url", "error", err)
+				os.Exit(1)
+			}
+			redisClient := redis.NewClient(redisOpts)
+			defer func() { _ = redisClient.Close() }()
+			run.redisScores = storage.NewRedisScores(redisClient, db)
+		}
+
+		err = cmd.Execute(args)
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+		if err != nil {
+			log.Error("running command", "error", err)
+			os.Exit(1)
+		}
+		return nil
+	}
+
+	if _, err := parser.ParseArgs(args[1:]); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"fmt"
+	"text/tabwriter"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// blacklistCmd manages the spam fingerprints: a message whose normalized
+// text matches one is erased before any other check
+type blacklistCmd struct {
+	Add    blacklistAddCmd    `command:"add" description:"erase messages with this text on sight"`
+	Remove blacklistRemoveCmd `command:"remove" description:"stop erasing messages with this text"`
+	List   blacklistListCmd   `command:"list" description:"list the blacklisted texts, the most matched first"`
+}
+
+type blacklistAddCmd struct {
+	Category string `long:"category" default:"other" description:"spam category of the text"`
+
+	Args struct {
+		Text string `positional-arg-name:"text" required:"true"`
+	} `positional-args:"true"`
+}
+
+func (c *blacklistAddCmd) Execute([]string) error {
+	hash, added, err := run.db.AddFingerprint(run.ctx, c.Args.Text, e.SpamCategory(c.Category), opts.Actor)
+	if err != nil {
+		return fmt.Errorf("adding fingerprint: %w", err)
+	}
+	if !added {
+		return fmt.Errorf("the text is too short to be blacklisted")
+	}
+	fmt.Fprintf(run.out, "blacklisted %s\n", hash)
+	return nil
+}
+
+type blacklistRemoveCmd struct {
+	Args struct {
+		Text string `positional-arg-name:"text" required:"true"`
+	} `positional-args:"true"`
+}
+
+func (c *blacklistRemoveCmd) Execute([]string) error {
+	deleted, err := run.db.DeleteFingerprint(run.ctx, c.Args.Text)
+	if err != nil {
+		return fmt.Errorf("deleting fingerprint: %w", err)
+	}
+	if !deleted {
+		return fmt.Errorf("the text isn't blacklisted")
+	}
+	fmt.Fprintln(run.out, "removed")
+	return nil
+}
+
+type blacklistListCmd struct {
+	Category string `long:"category" description:"list texts of this spam category only"`
+	Limit    int    `long:"limit" default:"50" description:"number of texts listed, 0 lists all"`
+}
+
+func (c *blacklistListCmd) Execute([]string) error {
+	fingerprints, err := run.db.ListFingerprints(run.ctx, storage.FingerprintFilter{
+		Category: e.SpamCategory(c.Category),
+		Limit:    c.Limit,
+	})
+	if err != nil {
+		return fmt.Errorf("listing fingerprints: %w", err)
+	}
+
+	tw := tabwriter.NewWriter(run.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "hits\tlast seen\tcategory\tsource\ttext")
+	for _, fp := range fingerprints {
+		lastSeen := "-"
+		if !fp.LastSeenAt.IsZero() {
+			lastSeen = fp.LastSeenAt.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", fp.Hits, lastSeen, fp.Category, fp.Source, oneLine(fp.Sample, 60))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"fmt"
+	"strconv"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// defaultScore is the score of a user the bot knows nothing about
+const defaultScore = 0
+
+// userOpts are the chat and user a command changes. They are flags rather
+// than arguments as chat ids are negative.
+type userOpts struct {
+	ChatID string `long:"chat-id" required:"true" description:"chat of the user"`
+	UserID string `long:"user-id" required:"true" description:"user id"`
+}
+
+func (a userOpts) user() e.User {
+	return e.User{ID: a.UserID, ChatID: a.ChatID}
+}
+
+type scoreCmd struct {
+	Get   scoreGetCmd   `command:"get" description:"show the score and probation of a user"`
+	Set   scoreSetCmd   `command:"set" description:"set the score of a user"`
+	Reset scoreResetCmd `command:"reset" description:"set the score of a user back to the default and end their probation"`
+}
+
+type scoreGetCmd struct {
+	userOpts
+}
+
+func (c *scoreGetCmd) Execute([]string) error {
+	user := c.user()
+	score, err := run.db.GetScore(run.ctx, user, defaultScore)
+	if err != nil {
+		return fmt.Errorf("getting score: %w", err)
+	}
+	remaining, _, err := run.db.GetProbation(run.ctx, user)
+	if err != nil {
+		return fmt.Errorf("getting probation: %w", err)
+	}
+
+	fmt.Fprintf(run.out, "score %d\n", score)
+	if remaining > 0 {
+		fmt.Fprintf(run.out, "probation: %d messages left\n", remaining)
+	}
+	return nil
+}
+
+type scoreSetCmd struct {
+	userOpts
+	Score  int    `long:"score" required:"true" description:"new score"`
+	Reason string `long:"reason" description:"reason recorded in the audit log"`
+}
+
+func (c *scoreSetCmd) Execute([]string) error {
+	return setScore(c.user(), c.Score, c.Reason)
+}
+
+type scoreResetCmd struct {
+	Reason string `long:"reason" default:"reset" description:"reason recorded in the audit log"`
+	userOpts
+}
+
+func (c *scoreResetCmd) Execute([]string) error {
+	user := c.user()
+	if err := endProbation(user); err != nil {
+		return err
+	}
+	return setScore(user, defaultScore, c.Reason)
+}
+
+type whitelistCmd struct {
+	TrustedScore int    `long:"trusted-score" default:"6" description:"score of a trusted user in chats without their own in the stored settings, must match the bot"`
+	Reason       string `long:"reason" default:"whitelisted" description:"reason recorded in the audit log"`
+	userOpts
+}
+
+// Execute raises the user to the trusted score of the chat and ends their
+// probation, which trusted users are checked during
+func (c *whitelistCmd) Execute([]string) error {
+	user := c.user()
+
+	trusted := c.TrustedScore
+	settings, ok, err := run.db.GetChatSettings(run.ctx, user.ChatID)
+	if err != nil {
+		return fmt.Errorf("getting chat settings: %w", err)
+	}
+	if ok && settings.TrustedScore != nil {
+		trusted = *settings.TrustedScore
+	}
+
+	if err = endProbation(user); err != nil {
+		return err
+	}
+	return setScore(user, trusted, c.Reason)
+}
+
+// setScore stores the score of the user and records the change. The score
+// is dropped from the bot's scores in Redis, so the bot reads the new one.
+func setScore(user e.User, score int, reason string) error {
+	before, err := run.db.GetScore(run.ctx, user, defaultScore)
+	if err != nil {
+		return fmt.Errorf("getting score: %w", err)
+	}
+	if err = run.db.SetScore(run.ctx, user, score); err != nil {
+		return fmt.Errorf("setting score: %w", err)
+	}
+
+	err = run.db.AppendAudit(run.ctx, e.AuditEntry{
+		Kind:   e.AuditKindScore,
+		Actor:  opts.Actor,
+		ChatID: user.ChatID,
+		UserID: user.ID,
+		Before: strconv.Itoa(before),
+		After:  strconv.Itoa(score),
+		Reason: reason,
+	})
+	if err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+
+	fmt.Fprintf(run.out, "score %d -> %d\n", before, score)
+
+	if run.redisScores != nil {
+		if err = run.redisScores.DropScore(run.ctx, user); err != nil {
+			return fmt.Errorf("score set, but the bot keeps the old one until it expires in redis: %w", err)
+		}
+	}
+	return nil
+}
+
+// endProbation ends the probation of the user if they are on one
+func endProbation(user e.User) error {
+	remaining, _, err := run.db.GetProbation(run.ctx, user)
+	if err != nil {
+		return fmt.Errorf("getting probation: %w", err)
+	}
+	if remaining == 0 {
+		return nil
+	}
+	if err = run.db.SetProbation(run.ctx, user, 0); err != nil {
+		return fmt.Errorf("ending probation: %w", err)
+	}
+	return nil
+}
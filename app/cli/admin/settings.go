@@ -0,0 +1,174 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/settings"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type settingsCmd struct {
+	Get    settingsGetCmd    `command:"get" description:"show the stored settings of a chat"`
+	Set    settingsSetCmd    `command:"set" description:"replace the stored settings of a chat with a json document"`
+	Patch  settingsPatchCmd  `command:"patch" description:"change fields of the stored settings of a chat, null removes a field"`
+	Delete settingsDeleteCmd `command:"delete" description:"delete the stored settings of a chat, leaving it to the settings file"`
+}
+
+// chatOpts are the chat a command changes
+type chatOpts struct {
+	ChatID string `long:"chat-id" required:"true" description:"chat id"`
+}
+
+// documentArgs are the json document of settings
+type documentArgs struct {
+	Document string `positional-arg-name:"json" required:"true" description:"json document, - reads it from stdin"`
+}
+
+// read returns the document, read from stdin for -
+func (a documentArgs) read() ([]byte, error) {
+	if a.Document != "-" {
+		return []byte(a.Document), nil
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("reading stdin: %w", err)
+	}
+	return data, nil
+}
+
+// chatSettings returns the store of settings changes are audited through
+func chatSettings() *settings.DB {
+	return &settings.DB{Store: run.db, Audit: run.db}
+}
+
+type settingsGetCmd struct {
+	chatOpts
+}
+
+func (c *settingsGetCmd) Execute([]string) error {
+	stored, found, err := run.db.GetChatSettings(run.ctx, c.ChatID)
+	if err != nil {
+		return fmt.Errorf("getting settings: %w", err)
+	}
+	if !found {
+		fmt.Fprintln(run.out, "no stored settings, the chat has those of the settings file")
+		return nil
+	}
+
+	enc := json.NewEncoder(run.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(stored)
+}
+
+type settingsSetCmd struct {
+	chatOpts
+	Reason string       `long:"reason" description:"reason recorded in the audit log"`
+	Args   documentArgs `positional-args:"true"`
+}
+
+func (c *settingsSetCmd) Execute([]string) error {
+	data, err := c.Args.read()
+	if err != nil {
+		return err
+	}
+	s, err := decodeSettings(data)
+	if err != nil {
+		return err
+	}
+
+	if err = chatSettings().SetChatSettings(run.ctx, opts.Actor, c.ChatID, s, c.Reason); err != nil {
+		return fmt.Errorf("setting settings: %w", err)
+	}
+	fmt.Fprintln(run.out, "settings stored")
+	return nil
+}
+
+type settingsPatchCmd struct {
+	chatOpts
+	Reason string       `long:"reason" description:"reason recorded in the audit log"`
+	Args   documentArgs `positional-args:"true"`
+}
+
+func (c *settingsPatchCmd) Execute([]string) error {
+	data, err := c.Args.read()
+	if err != nil {
+		return err
+	}
+	var patch map[string]json.RawMessage
+	if err = json.Unmarshal(data, &patch); err != nil {
+		return fmt.Errorf("decoding patch: %w", err)
+	}
+
+	stored, _, err := run.db.GetChatSettings(run.ctx, c.ChatID)
+	if err != nil {
+		return fmt.Errorf("getting settings: %w", err)
+	}
+	fields, err := settingsFields(stored)
+	if err != nil {
+		return err
+	}
+	for name, value := range patch {
+		if string(value) == "null" {
+			delete(fields, name)
+			continue
+		}
+		fields[name] = value
+	}
+
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+	s, err := decodeSettings(merged)
+	if err != nil {
+		return err
+	}
+
+	if err = chatSettings().SetChatSettings(run.ctx, opts.Actor, c.ChatID, s, c.Reason); err != nil {
+		return fmt.Errorf("setting settings: %w", err)
+	}
+	fmt.Fprintln(run.out, "settings stored")
+	return nil
+}
+
+type settingsDeleteCmd struct {
+	chatOpts
+	Reason string `long:"reason" description:"reason recorded in the audit log"`
+}
+
+func (c *settingsDeleteCmd) Execute([]string) error {
+	if err := chatSettings().DeleteChatSettings(run.ctx, opts.Actor, c.ChatID, c.Reason); err != nil {
+		return fmt.Errorf("deleting settings: %w", err)
+	}
+	fmt.Fprintln(run.out, "settings deleted")
+	return nil
+}
+
+// decodeSettings decodes a json document of settings, rejecting unknown
+// fields, which are likely misspelled
+func decodeSettings(data []byte) (e.ChatSettings, error) {
+	var s e.ChatSettings
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return s, fmt.Errorf("decoding settings: %w", err)
+	}
+	return s, nil
+}
+
+// settingsFields returns the fields of the settings as json by name
+func settingsFields(s e.ChatSettings) (map[string]json.RawMessage, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, fmt.Errorf("encoding settings: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err = json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("decoding settings: %w", err)
+	}
+	return fields, nil
+}
@@ -0,0 +1,66 @@
+// Package backup implements the backup command, which makes a consistent
+// copy of the database with the SQLite online backup API, safe while the bot
+// is running, or restores the database from such a copy. Stop the bot before
+// restoring.
+package backup
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	File string `long:"file" required:"true" description:"backup file to write or to restore from"`
+
+	Args struct {
+		Command string `positional-arg-name:"command" choice:"create" choice:"restore" required:"true"`
+	} `positional-args:"true"`
+}
+
+// Run runs the backup command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.OpenUnmigrated(opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	switch opts.Args.Command {
+	case "create":
+		if err = db.Backup(ctx, opts.File); err != nil {
+			log.Error("backing up database", "error", err)
+			return
+		}
+		log.Info("database backed up", "file", opts.File)
+	case "restore":
+		if err = db.Restore(ctx, opts.File); err != nil {
+			log.Error("restoring database", "error", err)
+			return
+		}
+		// The backup may predate the current schema
+		if err = db.MigrateUp(ctx); err != nil {
+			log.Error("migrating restored database", "error", err)
+			return
+		}
+		log.Info("database restored", "file", opts.File)
+	}
+}
@@ -0,0 +1,362 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	_ "time/tzdata" // chat time zones must resolve without system tzdata
+
+	"github.com/getsentry/sentry-go"
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/app/settings"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/app/telegram"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+	"nuclight.org/antispam-tg-bot/pkg/redis"
+)
+
+// Options are the options of the bot command
+type Options struct {
+	cli.ConfigOptions
+	TelegramAPIToken   string `long:"telegram-api-token" env:"TELEGRAM_API_TOKEN" description:"telegram api token, required unless --offline"`
+	TelegramWorkersNum int    `long:"telegram-workers-num" env:"TELEGRAM_WORKERS_NUM" default:"5" description:"number of workers for telegram bot"`
+	ModeratorOptions
+	Offline             bool          `long:"offline" env:"OFFLINE" description:"moderate messages read from stdin, a line each, instead of telegram ones and print the actions"`
+	SentryDSN           string        `long:"sentry-dsn" env:"SENTRY_DSN" description:"sentry DSN for error monitoring (optional)"`
+	DevMode             bool          `long:"dev-mode" env:"DEV_MODE" description:"enable dev mode"`
+	RetentionDays       int           `long:"retention-days" env:"RETENTION_DAYS" description:"erase message texts and media references older than this number of days, 0 keeps them"`
+	RetentionMaxRows    int           `long:"retention-max-rows" env:"RETENTION_MAX_ROWS" description:"keep at most this number of messages per chat, 0 keeps all"`
+	RetentionVacuum     bool          `long:"retention-vacuum" env:"RETENTION_VACUUM" description:"rebuild the database after a retention run that removed data to give its space back, locking writes meanwhile"`
+	ArchiveUpdates      bool          `long:"archive-updates" env:"ARCHIVE_UPDATES" description:"archive raw telegram updates of checked messages for replay"`
+	ArchiveDays         int           `long:"archive-days" env:"ARCHIVE_DAYS" default:"30" description:"delete archived raw updates older than this number of days, 0 keeps them"`
+	BackupDir           string        `long:"backup-dir" env:"BACKUP_DIR" description:"directory for scheduled database backups, empty disables them"`
+	BackupInterval      time.Duration `long:"backup-interval" env:"BACKUP_INTERVAL" default:"24h" description:"interval between scheduled backups"`
+	BackupKeep          int           `long:"backup-keep" env:"BACKUP_KEEP" default:"7" description:"number of most recent scheduled backups to keep, 0 keeps all"`
+	MetricsAddr         string        `long:"metrics-addr" env:"METRICS_ADDR" description:"listen address of the prometheus metrics endpoint, e.g. :9090, empty disables it"`
+	RedisURL            string        `long:"redis-url" env:"REDIS_URL" description:"redis url (redis://[:password@]host[:port][/db]) for scores shared by replicas of the bot, empty keeps them local"`
+	ScoreCacheSize      int           `long:"score-cache-size" env:"SCORE_CACHE_SIZE" default:"10000" description:"number of user scores cached in memory, 0 disables the cache"`
+	ChatRefreshInterval time.Duration `long:"chat-refresh-interval" env:"CHAT_REFRESH_INTERVAL" default:"24h" description:"interval between refreshes of chats' metadata from telegram, 0 disables them"`
+}
+
+var opts Options
+
+// ConfigSettings is the section of the config file with the chat settings,
+// in the form of the --chat-settings file
+const ConfigSettings = "settings"
+
+// Run runs the bot command, args being its name and options as in os.Args
+func Run(args []string) {
+	config, err := cli.ParseWithConfig(&opts, args, ConfigSettings)
+	if err != nil {
+		os.Exit(1)
+	}
+	if opts.TelegramAPIToken == "" && !opts.Offline {
+		_, _ = fmt.Fprintln(os.Stderr, "the required flag `--telegram-api-token' was not specified")
+		os.Exit(1)
+	}
+	if err = opts.Validate(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// Initialize Sentry first if DSN is provided
+	sentryEnabled := false
+	if opts.SentryDSN != "" {
+		env := "production"
+		if opts.DevMode {
+			env = "development"
+		}
+		err = sentry.Init(sentry.ClientOptions{
+			ServerName:  "antispam-tg-bot",
+			Dsn:         opts.SentryDSN,
+			Environment: env,
+			// Defense in depth: scrub the bot token from any event before it
+			// is sent, in case a new error path interpolates it.
+			BeforeSend: func(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
+				token := opts.TelegramAPIToken
+				if token == "" {
+					return event
+				}
+				event.Message = strings.ReplaceAll(event.Message, token, "<redacted>")
+				for i := range event.Exception {
+					event.Exception[i].Value = strings.ReplaceAll(event.Exception[i].Value, token, "<redacted>")
+				}
+				return event
+			},
+		})
+		if err == nil {
+			sentryEnabled = true
+			defer sentry.Flush(2 * time.Second)
+		}
+	}
+
+	// Create logger with Sentry integration if enabled
+	var log logger.Logger
+	if sentryEnabled {
+		log = logger.NewLoggerWithSentry()
+	} else {
+		log = logger.NewLogger()
+	}
+
+	log.Info("starting bot", "dev_mode", opts.DevMode, "sentry", sentryEnabled)
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := opts.Open(ctx)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+	db = storage.NewInstrumented(db, metrics.Default, log, opts.SlowQueryThreshold)
+
+	if opts.MetricsAddr != "" {
+		go serveMetrics(ctx, log, opts.MetricsAddr)
+	}
+
+	var scores services.ScoreStore = db
+	privacySrv := &services.PrivacySrv{Store: db, Audit: db}
+	var locks services.UserLocker
+	switch {
+	case opts.RedisURL != "":
+		redisOpts, err := redis.ParseURL(opts.RedisURL)
+		if err != nil {
+			log.Error("parsing redis url", "error", err)
+			os.Exit(1)
+		}
+		redisClient := redis.NewClient(redisOpts)
+		defer func() { _ = redisClient.Close() }()
+
+		// A local cache would go stale when another replica changes a score
+		redisScores := storage.NewRedisScores(redisClient, db)
+		scores = redisScores
+		locks = redisScores
+		privacySrv.Cache = redisScores
+	case opts.ScoreCacheSize > 0:
+		cache := storage.NewScoreCache(db, opts.ScoreCacheSize)
+		scores = cache
+		privacySrv.Cache = cache
+	}
+
+	moderator, err := NewModerator(ctx, &opts.ModeratorOptions, config, db, scores, log)
+	if err != nil {
+		log.Error("creating moderator", "error", err)
+		os.Exit(1)
+	}
+	moderatingSrv, chatSettings, messageBuffer := moderator.Srv, moderator.Settings, moderator.Buffer
+	moderatingSrv.Locks = locks
+
+	reload := &reloader{args: args, srv: moderatingSrv, file: moderator.File, chatSettings: chatSettings, log: log}
+	go reloadOnHangup(ctx, reload, log)
+
+	if opts.Offline {
+		runOfflineMode(ctx, moderatingSrv, messageBuffer, log)
+	}
+
+	bot := &telegram.Client{
+		Log:         log,
+		APIToken:    opts.TelegramAPIToken,
+		WorkersNum:  opts.TelegramWorkersNum,
+		DevMode:     opts.DevMode,
+		Handler:     moderatingSrv,
+		Settings:    chatSettings,
+		Seeder:      moderatingSrv,
+		Decisions:   db,
+		Audit:       db,
+		Bans:        db,
+		Chats:       db,
+		Privacy:     privacySrv,
+		Stats:       db,
+		Reloader:    reload,
+		OwnerChatID: opts.OwnerChatID,
+	}
+	moderatingSrv.MediaDownloader = bot
+	moderatingSrv.Alerts = bot
+	moderatingSrv.Mentions = bot
+	if opts.ArchiveUpdates {
+		bot.Archive = db
+	}
+
+	bufferDone := make(chan struct{})
+	if messageBuffer != nil {
+		go func() {
+			messageBuffer.Run(ctx)
+			close(bufferDone)
+		}()
+	} else {
+		close(bufferDone)
+	}
+
+	err = bot.Start(ctx)
+	if err != nil {
+		log.Error("starting bot", "error", err)
+		os.Exit(1)
+	}
+
+	digestSrv := &services.DigestSrv{
+		Log:      log,
+		Stats:    db,
+		Settings: chatSettings,
+		Sender:   bot,
+	}
+	go digestSrv.Run(ctx)
+
+	retentionSrv := &services.RetentionSrv{
+		Log:   log,
+		Store: db,
+		Policy: e.RetentionPolicy{
+			Days:           opts.RetentionDays,
+			MaxRowsPerChat: opts.RetentionMaxRows,
+			RawUpdateDays:  opts.ArchiveDays,
+		},
+	}
+	if opts.RetentionVacuum {
+		retentionSrv.Vacuumer = db
+	}
+	go retentionSrv.Run(ctx)
+
+	verificationSweeper := &services.VerificationSweeper{
+		Log:      log,
+		Store:    db,
+		Enforcer: bot,
+	}
+	go verificationSweeper.Run(ctx)
+
+	if opts.ChatRefreshInterval > 0 {
+		chatRefreshSrv := &services.ChatRefreshSrv{
+			Log:      log,
+			Store:    db,
+			Fetcher:  bot,
+			Interval: opts.ChatRefreshInterval,
+		}
+		go chatRefreshSrv.Run(ctx)
+	}
+
+	if opts.BackupDir != "" {
+		backupSrv := &services.BackupSrv{
+			Log:      log,
+			Store:    db,
+			Dir:      opts.BackupDir,
+			Interval: opts.BackupInterval,
+			Keep:     opts.BackupKeep,
+		}
+		go backupSrv.Run(ctx)
+	}
+
+	<-ctx.Done()
+	log.Info("stopping bot")
+
+	bot.Wait()
+	<-bufferDone
+
+	os.Exit(0)
+}
+
+// runOfflineMode moderates messages from stdin until it ends, then exits
+func runOfflineMode(ctx context.Context, srv *services.ModeratingSrv, buffer *storage.MessageBuffer, log logger.Logger) {
+	log.Info("moderating messages from stdin, a line each, e.g. \"42: hello\"")
+
+	bufferDone := make(chan struct{})
+	bufferCtx, stopBuffer := context.WithCancel(ctx)
+	go func() {
+		if buffer != nil {
+			buffer.Run(bufferCtx)
+		}
+		close(bufferDone)
+	}()
+
+	err := runOffline(ctx, srv, os.Stdin, os.Stdout)
+	stopBuffer()
+	<-bufferDone
+	if err != nil {
+		log.Error("moderating offline", "error", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// serveMetrics serves the metrics endpoint until the context is done
+func serveMetrics(ctx context.Context, log logger.Logger, addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Default.Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	log.Info("serving metrics", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("serving metrics", "error", err)
+	}
+}
+
+// loadPrompts returns the spam check prompt of the options and the
+// experiment with the candidate prompt, nil without one
+func loadPrompts(o *ModeratorOptions) (services.RegisteredPrompt, *services.PromptExperiment, error) {
+	registry := services.NewPromptRegistry()
+	if o.AIPromptsDir != "" {
+		if err := registry.LoadDir(o.AIPromptsDir); err != nil {
+			return services.RegisteredPrompt{}, nil, err
+		}
+	}
+
+	prompt, ok := registry.Get(o.AIPrompt)
+	if !ok {
+		return services.RegisteredPrompt{}, nil, fmt.Errorf("unknown prompt %q", o.AIPrompt)
+	}
+	if o.AIPromptCandidate == "" {
+		return prompt, nil, nil
+	}
+
+	candidate, ok := registry.Get(o.AIPromptCandidate)
+	if !ok {
+		return services.RegisteredPrompt{}, nil, fmt.Errorf("unknown candidate prompt %q", o.AIPromptCandidate)
+	}
+	experiment := &services.PromptExperiment{Candidate: candidate, Percent: o.AIPromptPercent}
+	if err := experiment.Validate(); err != nil {
+		return services.RegisteredPrompt{}, nil, err
+	}
+	return prompt, experiment, nil
+}
+
+func logPrompts(log logger.Logger, prompt services.RegisteredPrompt, experiment *services.PromptExperiment) {
+	log.Info("spam check prompt", "name", prompt.Name, "prompt_version", prompt.Version)
+	if experiment != nil {
+		log.Info("prompt experiment", "candidate", experiment.Candidate.Name, "prompt_version", experiment.Candidate.Version, "percent", experiment.Percent)
+	}
+}
+
+// loadChatSettings returns the chat settings of the --chat-settings file at
+// path or of the config file, zero settings without either
+func loadChatSettings(config cli.Config, path string) (*settings.File, error) {
+	data, ok, err := config.SectionJSON(ConfigSettings)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case ok && path != "":
+		return nil, fmt.Errorf("chat settings are given both in the %s section of the config file and by --chat-settings", ConfigSettings)
+	case ok:
+		return settings.ParseFile(data)
+	case path != "":
+		return settings.LoadFile(path)
+	default:
+		return &settings.File{}, nil
+	}
+}
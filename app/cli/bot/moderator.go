@@ -0,0 +1,390 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/app/settings"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	"nuclight.org/antispam-tg-bot/pkg/classifier"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/links"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/media"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+	"nuclight.org/antispam-tg-bot/pkg/redact"
+	"nuclight.org/antispam-tg-bot/pkg/webhook"
+)
+
+// ModeratorOptions are the options of how messages are checked, shared by
+// the bot and the replay command, which checks archived traffic as the bot
+// would
+type ModeratorOptions struct {
+	cli.DBOptions
+	SkipMigrations      bool          `long:"skip-migrations" env:"SKIP_MIGRATIONS" description:"don't apply pending schema migrations on start but refuse to start with any, for migrations applied with the migrate command"`
+	OpenAIKey           string        `long:"ai-key" env:"OPENAI_KEY" description:"api key of the ai provider, required unless it's fake"`
+	AIProvider          string        `long:"ai-provider" env:"AI_PROVIDER" default:"openai" choice:"openai" choice:"anthropic" choice:"gemini" choice:"fake" description:"llm api used for checks, fake decides by keywords without an api"`
+	AIFakeKeywords      []string      `long:"ai-fake-keywords" env:"AI_FAKE_KEYWORDS" env-delim:"," description:"keywords the fake provider marks texts as spam by, empty uses its defaults"`
+	AIBaseURL           string        `long:"ai-base-url" env:"AI_BASE_URL" description:"api root of the ai provider, e.g. an openai-compatible gateway, empty uses the provider's"`
+	AIResponseFormat    string        `long:"ai-response-format" env:"AI_RESPONSE_FORMAT" default:"auto" choice:"auto" choice:"json_schema" choice:"json_object" choice:"prompt" description:"how openai-compatible models are asked for json, auto downgrades a model rejecting json_schema"`
+	AIModel             string        `long:"ai-model" env:"AI_MODEL" description:"model of the ai provider, empty uses the provider's default"`
+	AIVisionModel       string        `long:"ai-vision-model" env:"AI_VISION_MODEL" description:"model for checks of images, empty uses --ai-model"`
+	AIMaxAttempts       int           `long:"ai-max-attempts" env:"AI_MAX_ATTEMPTS" default:"3" description:"attempts of an ai request failed with a transient error, 1 disables retries"`
+	AIRetryDelay        time.Duration `long:"ai-retry-delay" env:"AI_RETRY_DELAY" default:"500ms" description:"delay before the first retry of an ai request, doubled for every next one"`
+	AIRetryMaxDelay     time.Duration `long:"ai-retry-max-delay" env:"AI_RETRY_MAX_DELAY" default:"10s" description:"longest delay between retries of an ai request"`
+	AIProxy             string        `long:"ai-proxy" env:"AI_PROXY" description:"url of the proxy ai requests go through, e.g. http://proxy:3128 or socks5://proxy:1080, empty takes HTTPS_PROXY"`
+	AICACert            string        `long:"ai-ca-cert" env:"AI_CA_CERT" description:"pem file of certificates trusted for the ai api besides the system's, e.g. of a tls inspecting proxy"`
+	AIInsecureTLS       bool          `long:"ai-insecure-tls" env:"AI_INSECURE_TLS" description:"accept any certificate of the ai api, for testing only"`
+	AIConnectTimeout    time.Duration `long:"ai-connect-timeout" env:"AI_CONNECT_TIMEOUT" default:"10s" description:"time to connect to the ai api, tls handshake included"`
+	AIRequestTimeout    time.Duration `long:"ai-request-timeout" env:"AI_REQUEST_TIMEOUT" default:"2m" description:"time an attempt of an ai request gets, reading the response included, 0 doesn't bound it"`
+	AITimeout           time.Duration `long:"ai-timeout" env:"AI_TIMEOUT" default:"30s" description:"timeout of an ai check including its retries, 0 disables it"`
+	AIFallback          []string      `long:"ai-fallback" env:"AI_FALLBACK" env-delim:"," description:"provider[:model] an ai check falls back to when the previous provider fails, repeat for more, tried in order"`
+	AIFallbackKeys      []string      `long:"ai-fallback-key" env:"AI_FALLBACK_KEYS" env-delim:"," description:"api keys of the --ai-fallback providers in their order, a missing one is --ai-key"`
+	AIFallbackTimeout   time.Duration `long:"ai-fallback-timeout" env:"AI_FALLBACK_TIMEOUT" default:"10s" description:"time an ai provider gets before the check falls back to the next one, 0 waits for it"`
+	AIFailureMode       string        `long:"ai-failure-mode" env:"AI_FAILURE_MODE" default:"open" choice:"open" choice:"closed" choice:"rules" description:"whether a message the ai failed to check is let through (open), erased (closed) or let through if the rules do, without earning trust (rules)"`
+	AIBreakerFailures   int           `long:"ai-breaker-failures" env:"AI_BREAKER_FAILURES" default:"5" description:"failed ai requests in a row after which the provider isn't called for the cooldown, 0 keeps calling it"`
+	AIBreakerCooldown   time.Duration `long:"ai-breaker-cooldown" env:"AI_BREAKER_COOLDOWN" default:"30s" description:"time a failing ai provider isn't called before a request probes it"`
+	AIRPM               int           `long:"ai-requests-per-minute" env:"AI_REQUESTS_PER_MINUTE" description:"limit of ai requests per minute shared by the workers, 0 doesn't limit them"`
+	AITPM               int           `long:"ai-tokens-per-minute" env:"AI_TOKENS_PER_MINUTE" description:"limit of ai tokens per minute shared by the workers, 0 doesn't limit them"`
+	AIDailyTokens       int           `long:"ai-daily-token-budget" env:"AI_DAILY_TOKEN_BUDGET" description:"ai tokens per utc day, the rules only apply once they're used up, 0 doesn't cap them"`
+	AIMonthlyTokens     int           `long:"ai-monthly-token-budget" env:"AI_MONTHLY_TOKEN_BUDGET" description:"ai tokens per utc month, 0 doesn't cap them"`
+	AIDailySpend        float64       `long:"ai-daily-spend-budget" env:"AI_DAILY_SPEND_BUDGET" description:"ai spend per utc day in usd, 0 doesn't cap it"`
+	AIMonthlySpend      float64       `long:"ai-monthly-spend-budget" env:"AI_MONTHLY_SPEND_BUDGET" description:"ai spend per utc month in usd, 0 doesn't cap it"`
+	ModerationFilter    bool          `long:"moderation-filter" env:"MODERATION_FILTER" description:"screen texts with the free openai moderation model before the ai spam check, needs the openai provider"`
+	ModerationFlagScore float64       `long:"moderation-flag-score" env:"MODERATION_FLAG_SCORE" default:"0.9" description:"remove a text flagged by the moderation model with at least this score without asking the ai, 0 never does"`
+	ModerationPassScore float64       `long:"moderation-pass-score" env:"MODERATION_PASS_SCORE" description:"let a text scoring below this in every moderation category through without asking the ai, 0 never does"`
+	AITools             bool          `long:"ai-tools" env:"AI_TOOLS" description:"let the ai look up the sender's recent messages and where the message's links lead during the spam check, needs the openai or anthropic provider"`
+	FewShotExamples     int           `long:"few-shot-examples" env:"FEW_SHOT_EXAMPLES" description:"labeled examples of spam and of ham added to the prompt of the ai spam check, 0 disables them"`
+	FewShotGlobal       bool          `long:"few-shot-global" env:"FEW_SHOT_GLOBAL" description:"pick few-shot examples confirmed in any chat rather than in the checked message's chat"`
+	OwnerChatID         string        `long:"owner-chat-id" env:"OWNER_CHAT_ID" description:"chat id the bot sends alerts for its owner to, e.g. when an ai budget is used up, and whose admins may /reload (optional)"`
+	ImageMaxDimension   int           `long:"image-max-dimension" env:"IMAGE_MAX_DIMENSION" default:"1024" description:"longest side in pixels images are shrunk to before the vision check, 0 sends them as they are"`
+	ImageJPEGQuality    int           `long:"image-jpeg-quality" env:"IMAGE_JPEG_QUALITY" default:"85" description:"jpeg quality of shrunk images"`
+	AIImageDetail       string        `long:"ai-image-detail" env:"AI_IMAGE_DETAIL" default:"low" choice:"low" choice:"high" choice:"auto" description:"detail the openai vision model sees images of the spam check at, high reads small text at more tokens"`
+	AIReasoningEffort   string        `long:"ai-reasoning-effort" env:"AI_REASONING_EFFORT" choice:"minimal" choice:"low" choice:"medium" choice:"high" description:"reasoning effort of the openai model for the spam check, empty keeps medium for texts and none for images"`
+	AIConfirmBelow      float64       `long:"ai-confirm-below" env:"AI_CONFIRM_BELOW" description:"check ai verdicts less confident than this again with --ai-confirm-effort, 0 doesn't"`
+	AIConfirmEffort     string        `long:"ai-confirm-effort" env:"AI_CONFIRM_EFFORT" default:"high" choice:"minimal" choice:"low" choice:"medium" choice:"high" description:"reasoning effort of the second check of uncertain verdicts"`
+	AIRedact            []string      `long:"ai-redact" env:"AI_REDACT" env-delim:"," choice:"email" choice:"phone" choice:"user" description:"personal data masked in texts sent to the ai, repeat for more: email, phone or user"`
+	AIPromptsDir        string        `long:"ai-prompts-dir" env:"AI_PROMPTS_DIR" description:"directory of versioned spam check prompts, a .txt file each named by the prompt (optional)"`
+	AIPrompt            string        `long:"ai-prompt" env:"AI_PROMPT" default:"builtin" description:"name or version of the spam check prompt"`
+	AIPromptCandidate   string        `long:"ai-prompt-candidate" env:"AI_PROMPT_CANDIDATE" description:"name or version of a prompt tried on a share of the checks against --ai-prompt (optional)"`
+	AIPromptPercent     int           `long:"ai-prompt-candidate-percent" env:"AI_PROMPT_CANDIDATE_PERCENT" default:"10" description:"percent of the checks made with the candidate prompt"`
+	AIMaxInputTokens    int           `long:"ai-max-input-tokens" env:"AI_MAX_INPUT_TOKENS" default:"2000" description:"tokens of a message's text sent to the ai, the middle of a longer one is dropped, 0 sends it whole"`
+	AILocalizedPrompts  bool          `long:"ai-localized-prompts" env:"AI_LOCALIZED_PROMPTS" description:"add a section for the detected language of the message to the ai prompt and ask for notes in the chat's language"`
+	NormalizeText       bool          `long:"normalize-text" env:"NORMALIZE_TEXT" description:"normalize unicode and strip invisible characters before sending text to ai"`
+	ChatSettingsPath    string        `long:"chat-settings" env:"CHAT_SETTINGS_PATH" description:"path to the json file with per-chat settings (optional)"`
+	ClassifierURL       string        `long:"classifier-url" env:"CLASSIFIER_URL" description:"url of your own spam classifier (optional)"`
+	ClassifierToken     string        `long:"classifier-token" env:"CLASSIFIER_TOKEN" description:"bearer token sent to the classifier"`
+	ClassifierMode      string        `long:"classifier-mode" env:"CLASSIFIER_MODE" default:"before" choice:"before" choice:"replace" description:"whether the classifier is asked before or instead of the ai"`
+	ClassifierCutoff    float64       `long:"classifier-confidence" env:"CLASSIFIER_CONFIDENCE" default:"0.9" description:"least confidence of a classifier verdict deciding before the ai"`
+	WebhookURL          string        `long:"webhook-url" env:"WEBHOOK_URL" description:"url of an external decision service (optional)"`
+	WebhookToken        string        `long:"webhook-token" env:"WEBHOOK_TOKEN" description:"bearer token sent to the decision service"`
+	WebhookMode         string        `long:"webhook-mode" env:"WEBHOOK_MODE" default:"supplement" choice:"supplement" choice:"replace" description:"whether the decision service supplements or replaces the ai check"`
+	MessageBatchDelay   time.Duration `long:"message-batch-delay" env:"MESSAGE_BATCH_DELAY" description:"batch message inserts of workers for up to this long, 0 stores every message at once"`
+	MessageBatchSize    int           `long:"message-batch-size" env:"MESSAGE_BATCH_SIZE" default:"100" description:"number of messages stored at once without waiting for the batch delay"`
+	VerdictCacheTTL     time.Duration `long:"verdict-cache-ttl" env:"VERDICT_CACHE_TTL" default:"24h" description:"reuse ai verdicts on the same normalized text for this long, 0 disables the cache"`
+	VerdictCacheSize    int           `long:"verdict-cache-size" env:"VERDICT_CACHE_SIZE" default:"10000" description:"number of ai verdicts cached in memory in front of the database"`
+	SlowQueryThreshold  time.Duration `long:"slow-query-threshold" env:"SLOW_QUERY_THRESHOLD" default:"500ms" description:"log storage calls slower than this, 0 disables logging"`
+}
+
+// Validate reports the options missing for the moderator
+func (o *ModeratorOptions) Validate() error {
+	if o.OpenAIKey == "" && o.AIProvider != ai.ProviderFake {
+		return errors.New("the required flag `--ai-key' was not specified")
+	}
+	return nil
+}
+
+// Open opens the database, with --skip-migrations refusing one with pending
+// migrations rather than applying them
+func (o *ModeratorOptions) Open(ctx context.Context) (storage.Store, error) {
+	if o.SkipMigrations {
+		return storage.OpenMigrated(ctx, o.DBPath)
+	}
+	return storage.Open(ctx, o.DBPath)
+}
+
+// Moderator is the moderator of the options with the chat settings it reads
+type Moderator struct {
+	Srv *services.ModeratingSrv
+
+	// File is the chat settings of --chat-settings or the config file,
+	// replaced on a reload
+	File *settings.File
+
+	// Settings are the chat settings of the database over File
+	Settings *settings.DB
+
+	// Buffer batches the decisions stored with --message-batch-delay, nil
+	// without it. It must run for them to be stored.
+	Buffer *storage.MessageBuffer
+}
+
+// NewModerator returns the moderator of the options checking messages with
+// the database and keeping the scores in the score store. Media isn't
+// downloaded and alerts aren't sent until the moderator is given a
+// downloader and an alert sender.
+func NewModerator(ctx context.Context, o *ModeratorOptions, config cli.Config, db storage.Store, scores services.ScoreStore, log logger.Logger) (*Moderator, error) {
+	fileSettings, err := loadChatSettings(config, o.ChatSettingsPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading chat settings: %w", err)
+	}
+
+	// Settings stored in the database take precedence over the file
+	chatSettings := &settings.DB{Store: db, Base: fileSettings, Audit: db}
+
+	var messages services.MessagesStore = db
+	var messageBuffer *storage.MessageBuffer
+	if o.MessageBatchDelay > 0 {
+		messageBuffer = storage.NewMessageBuffer(db, o.MessageBatchDelay, o.MessageBatchSize)
+		messages = bufferedMessages{Store: db, buffer: messageBuffer}
+	}
+
+	budget := ai.NewBudgetMeter(ai.Budget{
+		DailyTokens:   o.AIDailyTokens,
+		MonthlyTokens: o.AIMonthlyTokens,
+		DailySpend:    o.AIDailySpend,
+		MonthlySpend:  o.AIMonthlySpend,
+	})
+	if budget != nil {
+		if err = seedBudget(ctx, db, budget); err != nil {
+			return nil, fmt.Errorf("seeding ai budget: %w", err)
+		}
+	}
+
+	aiHTTP, err := ai.NewHTTPClient(ai.TransportOptions{
+		Proxy:              o.AIProxy,
+		CACertFile:         o.AICACert,
+		InsecureSkipVerify: o.AIInsecureTLS,
+		ConnectTimeout:     o.AIConnectTimeout,
+		Timeout:            o.AIRequestTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("creating ai http client: %w", err)
+	}
+	if o.AIInsecureTLS {
+		log.Warn("certificates of the ai api aren't verified")
+	}
+
+	providerOpts := ai.ProviderOptions{
+		Name:           o.AIProvider,
+		APIKey:         o.OpenAIKey,
+		BaseURL:        o.AIBaseURL,
+		Model:          o.AIModel,
+		VisionModel:    o.AIVisionModel,
+		ResponseFormat: ai.FormatStrategy(o.AIResponseFormat),
+		Retry: ai.RetryPolicy{
+			MaxAttempts: o.AIMaxAttempts,
+			BaseDelay:   o.AIRetryDelay,
+			MaxDelay:    o.AIRetryMaxDelay,
+		},
+		RateLimit: ai.RateLimit{RequestsPerMinute: o.AIRPM, TokensPerMinute: o.AITPM},
+		Budget:    budget,
+		Breaker:   circuitBreaker(o, providerName(o.AIProvider, o.AIModel), log),
+		Metrics:   ai.NewMetrics(metrics.Default),
+
+		FakeKeywords: o.AIFakeKeywords,
+	}
+	llm, err := ai.NewProvider(providerOpts, aiHTTP)
+	if err != nil {
+		return nil, fmt.Errorf("creating ai provider: %w", err)
+	}
+	if len(o.AIFallback) > 0 {
+		llm, err = fallbackChain(o, llm, providerOpts, aiHTTP, log)
+		if err != nil {
+			return nil, fmt.Errorf("creating ai fallback providers: %w", err)
+		}
+	}
+
+	srv := &services.ModeratingSrv{
+		DefaultScore:   0,
+		TrustedScore:   6,
+		BanScore:       -2,
+		ScoreStore:     scores,
+		MessagesStore:  messages,
+		Probations:     db,
+		AI:             llm,
+		AITimeout:      o.AITimeout,
+		AIFailureMode:  services.AIFailureMode(o.AIFailureMode),
+		Costs:          services.NewCostTracker(metrics.Default),
+		OwnerChatID:    o.OwnerChatID,
+		Verdicts:       storage.NewVerdictCache(db, o.VerdictCacheSize),
+		VerdictTTL:     o.VerdictCacheTTL,
+		MediaConverter: media.NewFFmpegExtractor(),
+		NormalizeText:  o.NormalizeText,
+		Settings:       chatSettings,
+		Audit:          db,
+		Fingerprints:   db,
+		Log:            log,
+	}
+
+	if o.ModerationFilter {
+		if o.AIProvider != ai.ProviderOpenAI {
+			return nil, fmt.Errorf("the moderation filter needs the openai provider, not %s", o.AIProvider)
+		}
+		srv.Moderation = ai.NewOpenAI(o.OpenAIKey, ai.WithRetries(aiHTTP, ai.RetryPolicy{
+			MaxAttempts: o.AIMaxAttempts,
+			BaseDelay:   o.AIRetryDelay,
+			MaxDelay:    o.AIRetryMaxDelay,
+		}), ai.OpenAIOptions{BaseURL: o.AIBaseURL})
+		srv.ModerationFlagScore = o.ModerationFlagScore
+		srv.ModerationPassScore = o.ModerationPassScore
+	}
+
+	if o.AITools {
+		if !ai.SupportsTools(o.AIProvider) {
+			return nil, fmt.Errorf("ai tools need the openai or anthropic provider, not %s", o.AIProvider)
+		}
+		srv.History = db
+		srv.Unwrapper = links.NewUnwrapper(5 * time.Second)
+	}
+
+	if downscaler := media.NewDownscaler(o.ImageMaxDimension, o.ImageJPEGQuality); downscaler != nil {
+		srv.ImageDownscaler = downscaler
+	}
+	srv.ImageDetail = ai.ImageDetail(o.AIImageDetail)
+	if len(o.AIRedact) > 0 {
+		kinds := make([]redact.Kind, 0, len(o.AIRedact))
+		for _, kind := range o.AIRedact {
+			kinds = append(kinds, redact.Kind(kind))
+		}
+		srv.Redactor, err = redact.NewRedactor(kinds...)
+		if err != nil {
+			return nil, fmt.Errorf("creating redactor: %w", err)
+		}
+	}
+
+	prompt, experiment, err := loadPrompts(o)
+	if err != nil {
+		return nil, fmt.Errorf("setting up prompts: %w", err)
+	}
+	srv.SetPrompt(prompt, experiment)
+	logPrompts(log, prompt, experiment)
+
+	srv.LocalizedPrompts = o.AILocalizedPrompts
+	srv.MaxInputTokens = o.AIMaxInputTokens
+	srv.ReasoningEffort = ai.ReasoningEffort(o.AIReasoningEffort)
+	srv.ConfirmBelow = o.AIConfirmBelow
+	srv.ConfirmEffort = ai.ReasoningEffort(o.AIConfirmEffort)
+
+	if o.FewShotExamples > 0 {
+		srv.FewShot = services.NewFewShot(db, o.FewShotExamples, o.FewShotGlobal)
+	}
+
+	if o.ClassifierURL != "" {
+		srv.Classifier = classifier.NewClient(o.ClassifierURL, o.ClassifierToken, &http.Client{Timeout: 10 * time.Second})
+		srv.ClassifierMode = services.ClassifierMode(o.ClassifierMode)
+		srv.ClassifierConfidence = o.ClassifierCutoff
+	}
+	if o.WebhookURL != "" {
+		srv.Webhook = webhook.NewClient(o.WebhookURL, o.WebhookToken, &http.Client{Timeout: 10 * time.Second})
+		srv.WebhookMode = services.WebhookMode(o.WebhookMode)
+	}
+
+	return &Moderator{Srv: srv, File: fileSettings, Settings: chatSettings, Buffer: messageBuffer}, nil
+}
+
+// bufferedMessages stores decisions through the batching buffer
+type bufferedMessages struct {
+	storage.Store
+	buffer *storage.MessageBuffer
+}
+
+func (m bufferedMessages) SaveDecision(ctx context.Context, decision e.Decision) (int64, error) {
+	return m.buffer.SaveDecision(ctx, decision)
+}
+
+// fallbackChain returns the primary provider falling back to the ones of
+// --ai-fallback. They share the budget and the retry policy of the primary,
+// but not its base url and vision model, which are of its api.
+func fallbackChain(o *ModeratorOptions, primary ai.Provider, primaryOpts ai.ProviderOptions, httpClient ai.HTTPClient, log logger.Logger) (ai.Provider, error) {
+	links := []ai.FallbackLink{{Name: providerName(primaryOpts.Name, primaryOpts.Model), Provider: primary}}
+	for i, spec := range o.AIFallback {
+		name, model, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		providerOpts := primaryOpts
+		providerOpts.Name = name
+		providerOpts.Model = model
+		providerOpts.BaseURL = ""
+		providerOpts.VisionModel = ""
+		providerOpts.ResponseFormat = ai.FormatAuto
+		providerOpts.APIKey = o.OpenAIKey
+		providerOpts.Breaker = circuitBreaker(o, providerName(name, model), log)
+		if i < len(o.AIFallbackKeys) && o.AIFallbackKeys[i] != "" {
+			providerOpts.APIKey = o.AIFallbackKeys[i]
+		}
+
+		p, err := ai.NewProvider(providerOpts, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("fallback %q: %w", spec, err)
+		}
+		links = append(links, ai.FallbackLink{Name: providerName(name, model), Provider: p})
+	}
+
+	failures := metrics.Default.Counter(
+		"antispam_ai_fallbacks_total",
+		"Number of AI requests passed on to the next provider of the fallback chain by the provider that failed them.",
+		"provider",
+	)
+	return ai.WithFallback(links, ai.FallbackOptions{
+		AttemptTimeout: o.AIFallbackTimeout,
+		OnFailure: func(name string, err error) {
+			failures.Inc(name)
+			log.Warn("ai provider failed, falling back", "provider", name, "error", err)
+		},
+	}), nil
+}
+
+// circuitBreaker returns the breaker of the named provider, logging and
+// reporting to metrics when it opens and closes
+func circuitBreaker(o *ModeratorOptions, name string, log logger.Logger) ai.CircuitBreaker {
+	circuitOpen := metrics.Default.Gauge(
+		"antispam_ai_circuit_open",
+		"Whether the circuit breaker of an AI provider is open, 1 while the provider isn't called.",
+		"provider",
+	)
+	return ai.CircuitBreaker{
+		Failures: o.AIBreakerFailures,
+		Cooldown: o.AIBreakerCooldown,
+		OnChange: func(open bool) {
+			if open {
+				circuitOpen.Set(1, name)
+				log.Error("ai provider keeps failing, not calling it for a while", "provider", name, "cooldown", o.AIBreakerCooldown)
+				return
+			}
+			circuitOpen.Set(0, name)
+			log.Info("ai provider recovered", "provider", name)
+		},
+	}
+}
+
+// providerName names a provider in metrics, e.g. "anthropic:claude-haiku-4-5"
+func providerName(name, model string) string {
+	if name == "" {
+		name = ai.ProviderOpenAI
+	}
+	if model == "" {
+		return name
+	}
+	return name + ":" + model
+}
+
+// seedBudget counts the AI usage of the current month recorded before the
+// bot started towards the budget
+func seedBudget(ctx context.Context, db storage.Store, budget *ai.BudgetMeter) error {
+	now := time.Now().UTC()
+	days, err := db.SpendByDay(ctx, storage.StatsFilter{From: time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		return err
+	}
+
+	for _, day := range days {
+		budget.Add(day.Day, day.PromptTokens+day.CompletionTokens, day.Cost)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package bot
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// offlineChatID is the chat of the messages of the offline mode
+const offlineChatID = "-1"
+
+type messageHandler interface {
+	HandleMessage(ctx context.Context, msg e.Message) (e.Action, error)
+}
+
+// runOffline moderates messages read from the input, a line each, and writes
+// the actions taken on them, so the pipeline can be tried without Telegram.
+// A line may start with the sender's id and a colon, e.g. "42: hello", other
+// lines are of user 1.
+func runOffline(ctx context.Context, handler messageHandler, in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	for n := 1; scanner.Scan(); n++ {
+		if ctx.Err() != nil {
+			return nil
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		userID, text := "1", line
+		if id, rest, ok := strings.Cut(line, ":"); ok {
+			if _, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil {
+				userID, text = strings.TrimSpace(id), strings.TrimSpace(rest)
+			}
+		}
+
+		action, err := handler.HandleMessage(ctx, e.Message{
+			Sender: e.User{ID: userID, Name: "user" + userID, ChatID: offlineChatID, ChatTitle: "offline"},
+			ID:     strconv.Itoa(n),
+			Text:   text,
+		})
+		if err != nil {
+			_, _ = fmt.Fprintf(out, "user %s: error: %v\n", userID, err)
+			continue
+		}
+
+		verdict := string(action.Kind)
+		if action.Category != "" {
+			verdict += " (" + string(action.Category) + ")"
+		}
+		_, _ = fmt.Fprintf(out, "user %s: %s by %s, score %d -> %d", userID, verdict, action.Trace.Stage, action.ScoreBefore, action.ScoreAfter)
+		if action.Note != "" {
+			_, _ = fmt.Fprintf(out, ": %s", action.Note)
+		}
+		_, _ = fmt.Fprintln(out)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading messages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/app/settings"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// reloader reloads what the running bot can change without dropping its
+// connection to Telegram: the spam check prompts, with the files of
+// --ai-prompts-dir read again, and the chat settings, which hold the rules'
+// actions and thresholds. The options are parsed again as on start, so a
+// changed config file applies with the environment and the flags still
+// taking precedence; changes of other options wait for a restart.
+type reloader struct {
+	args         []string
+	srv          *services.ModeratingSrv
+	file         *settings.File
+	chatSettings *settings.DB
+	log          logger.Logger
+
+	mu sync.Mutex // serializes reloads on SIGHUP and on /reload
+}
+
+// Reload reloads the prompts and the chat settings, leaving the previous ones
+// in effect on an error
+func (r *reloader) Reload(_ context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var fresh Options
+	config, err := cli.ParseWithConfig(&fresh, r.args, ConfigSettings)
+	if err != nil {
+		return "", fmt.Errorf("parsing options: %w", err)
+	}
+	prompt, experiment, err := loadPrompts(&fresh.ModeratorOptions)
+	if err != nil {
+		return "", fmt.Errorf("loading prompts: %w", err)
+	}
+	file, err := loadChatSettings(config, fresh.ChatSettingsPath)
+	if err != nil {
+		return "", fmt.Errorf("loading chat settings: %w", err)
+	}
+
+	r.srv.SetPrompt(prompt, experiment)
+	r.file.Replace(file)
+	r.chatSettings.ClearCache()
+
+	r.log.Info("reloaded prompts and chat settings")
+	logPrompts(r.log, prompt, experiment)
+
+	summary := fmt.Sprintf("prompt %s (%s)", prompt.Name, prompt.Version)
+	if experiment != nil {
+		summary += fmt.Sprintf(", candidate %s (%s) on %d%% of the checks", experiment.Candidate.Name, experiment.Candidate.Version, experiment.Percent)
+	}
+	return summary + ", chat settings", nil
+}
+
+// reloadOnHangup reloads on every SIGHUP until the context is done
+func reloadOnHangup(ctx context.Context, r *reloader, log logger.Logger) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			if _, err := r.Reload(ctx); err != nil {
+				log.Error("reloading on SIGHUP, the previous prompts and settings stay", "error", err)
+			}
+		}
+	}
+}
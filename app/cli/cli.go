@@ -0,0 +1,61 @@
+// Package cli holds what the commands of the antispam tool share: parsing of
+// their options, the context they run with and the database option. The
+// commands themselves are its subpackages, each run by cmd/antispam and by
+// the cmd/<name> binary kept for existing deployments.
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// DBOptions is the database option of the commands, embedded in their options
+type DBOptions struct {
+	DBPath string `long:"db-path" env:"DB_PATH" required:"true" description:"database DSN (sqlite://path) or a path to the sqlite database file"`
+}
+
+// NewParser returns a parser of the options of the command named name, as
+// shown in its help
+func NewParser(data any, name string) *flags.Parser {
+	parser := flags.NewParser(data, flags.Default)
+	parser.Name = name
+	return parser
+}
+
+// Parse parses the arguments of a command into its options. args are those
+// of os.Args: the name of the command followed by its arguments. Errors, the
+// help included, are printed by the parser.
+func Parse(data any, args []string) error {
+	_, err := NewParser(data, Name(args)).ParseArgs(args[1:])
+	return err
+}
+
+// Name returns the name of the command the arguments are of
+func Name(args []string) string {
+	return filepath.Base(args[0])
+}
+
+// Context returns the context of a command, cancelled on SIGINT or SIGTERM
+func Context() (context.Context, context.CancelFunc) {
+	return signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+}
+
+// ParseDate parses a YYYY-MM-DD date of a command's option, empty being the
+// zero time
+func ParseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD: %w", err)
+	}
+	return t, nil
+}
@@ -0,0 +1,56 @@
+package cli
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParse_TakesDBPathFromEnv(t *testing.T) {
+	t.Setenv("DB_PATH", "sqlite://from-env.sqlite")
+
+	var opts struct {
+		DBOptions
+		Days int `long:"days" default:"7"`
+	}
+	if err := Parse(&opts, []string{"antispam stats", "--days", "3"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if opts.DBPath != "sqlite://from-env.sqlite" {
+		t.Errorf("DBPath = %q, want the DB_PATH of the environment", opts.DBPath)
+	}
+	if opts.Days != 3 {
+		t.Errorf("Days = %d, want 3", opts.Days)
+	}
+}
+
+func TestParse_RequiresDBPath(t *testing.T) {
+	t.Setenv("DB_PATH", "")
+	if err := os.Unsetenv("DB_PATH"); err != nil {
+		t.Fatal(err)
+	}
+
+	var opts struct {
+		DBOptions
+	}
+	if err := Parse(&opts, []string{"stats"}); err == nil {
+		t.Error("Parse() error = nil, want the missing --db-path")
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	got, err := ParseDate("2025-03-01")
+	if err != nil {
+		t.Fatalf("ParseDate() error = %v", err)
+	}
+	if want := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("ParseDate() = %v, want %v", got, want)
+	}
+
+	if got, err = ParseDate(""); err != nil || !got.IsZero() {
+		t.Errorf("ParseDate(\"\") = %v, %v, want the zero time", got, err)
+	}
+	if _, err = ParseDate("01.03.2025"); err == nil {
+		t.Error("ParseDate() error = nil, want an error for another format")
+	}
+}
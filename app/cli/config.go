@@ -0,0 +1,186 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigOptions is the config file option of the commands reading one,
+// embedded in their options
+type ConfigOptions struct {
+	Config string `long:"config" env:"CONFIG_FILE" description:"yaml or toml file of options by their long names, overridden by the environment and the flags"`
+}
+
+// Config is the content of a config file: options by their long names, e.g.
+// ai-provider, and sections of the command, e.g. the bot's chat settings
+type Config map[string]any
+
+// ReadConfig reads a config file, YAML or TOML as told by its extension
+func ReadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	config := Config{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &config)
+	case ".toml":
+		err = toml.Unmarshal(data, &config)
+	default:
+		return nil, fmt.Errorf("config file %s: expected a .yaml, .yml or .toml file", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decoding config file: %w", err)
+	}
+	return config, nil
+}
+
+// ParseWithConfig parses the arguments like Parse, reading the config file
+// named by --config or CONFIG_FILE first, whose options are defaults the
+// environment and the flags override. data must embed ConfigOptions. Keys of
+// the config that name sections are left to the command, any other key must
+// be an option. It returns the config, nil without a file.
+func ParseWithConfig(data any, args []string, sections ...string) (Config, error) {
+	// The file must be known before the options are parsed for its values
+	// to become defaults; errors are left to the full parse
+	var pre ConfigOptions
+	_, _ = flags.NewParser(&pre, flags.IgnoreUnknown).ParseArgs(args[1:])
+
+	parser := NewParser(data, Name(args))
+	var config Config
+	if pre.Config != "" {
+		var err error
+		if config, err = ReadConfig(pre.Config); err == nil {
+			err = config.setDefaults(parser, pre.Config, sections)
+		}
+		if err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return nil, err
+		}
+	}
+
+	if _, err := parser.ParseArgs(args[1:]); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// MissingOptions returns the long names of the options of other that data
+// has not, for a command reading the config file of another command to
+// leave them alone as sections
+func MissingOptions(data, other any) []string {
+	parser := flags.NewParser(data, flags.None)
+	var names []string
+	var add func(group *flags.Group)
+	add = func(group *flags.Group) {
+		for _, option := range group.Options() {
+			if name := option.LongName; name != "" && parser.FindOptionByLongName(name) == nil {
+				names = append(names, name)
+			}
+		}
+		for _, g := range group.Groups() {
+			add(g)
+		}
+	}
+	add(flags.NewParser(other, flags.None).Group)
+	return names
+}
+
+// setDefaults makes the values of the config the defaults of the options
+func (c Config) setDefaults(parser *flags.Parser, path string, sections []string) error {
+	for _, name := range slices.Sorted(maps.Keys(c)) {
+		if slices.Contains(sections, name) {
+			continue
+		}
+		option := parser.FindOptionByLongName(name)
+		if option == nil || name == "config" {
+			return fmt.Errorf("config file %s: unknown option %q", path, name)
+		}
+
+		values, err := configValues(c[name])
+		if err != nil {
+			return fmt.Errorf("config file %s: option %q: %w", path, name, err)
+		}
+		option.Default = values
+		// Values of the file may be secrets, the help only tells where
+		// the default comes from
+		option.DefaultMask = path
+	}
+	return nil
+}
+
+// configValues returns the values of an option in the config: a value, or a
+// list of them for options given more than once
+func configValues(v any) ([]string, error) {
+	items, ok := v.([]any)
+	if !ok {
+		items = []any{v}
+	}
+
+	values := make([]string, 0, len(items))
+	for _, item := range items {
+		switch item := item.(type) {
+		case string:
+			values = append(values, item)
+		case bool, int, int64, uint64, float64:
+			values = append(values, fmt.Sprint(item))
+		case nil:
+			return nil, errors.New("no value")
+		default:
+			return nil, fmt.Errorf("expected a value or a list of them, got %T", item)
+		}
+	}
+	return values, nil
+}
+
+// SectionJSON returns the section of the config as JSON, false without it
+func (c Config) SectionJSON(name string) ([]byte, bool, error) {
+	section, ok := c[name]
+	if !ok {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(stringKeys(section))
+	if err != nil {
+		return nil, false, fmt.Errorf("encoding config section %s: %w", name, err)
+	}
+	return data, true, nil
+}
+
+// stringKeys returns the value with the keys of its maps made strings, as
+// YAML decodes a map of e.g. chat ids with keys of any type
+func stringKeys(v any) any {
+	switch v := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = stringKeys(value)
+		}
+		return m
+	case map[string]any:
+		m := make(map[string]any, len(v))
+		for key, value := range v {
+			m[key] = stringKeys(value)
+		}
+		return m
+	case []any:
+		items := make([]any, len(v))
+		for i, item := range v {
+			items[i] = stringKeys(item)
+		}
+		return items
+	default:
+		return v
+	}
+}
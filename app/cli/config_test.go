@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type configTestOpts struct {
+	ConfigOptions
+	DBOptions
+	Provider string        `long:"ai-provider" env:"AI_PROVIDER" default:"openai"`
+	Model    string        `long:"ai-model" env:"AI_MODEL"`
+	Timeout  time.Duration `long:"ai-timeout" env:"AI_TIMEOUT" default:"30s"`
+	Fallback []string      `long:"ai-fallback" env:"AI_FALLBACK" env-delim:","`
+	Cutoff   float64       `long:"classifier-confidence" default:"0.9"`
+	Offline  bool          `long:"offline" env:"OFFLINE"`
+}
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseWithConfig_YAML(t *testing.T) {
+	path := writeConfig(t, "bot.yaml", `
+db-path: sqlite://config.sqlite
+ai-provider: anthropic
+ai-model: claude
+ai-timeout: 1m
+ai-fallback: [gemini, "openai:gpt-5-mini"]
+classifier-confidence: 0.75
+offline: true
+settings:
+  default:
+    dry_run: true
+`)
+	t.Setenv("AI_MODEL", "from-env")
+
+	var opts configTestOpts
+	config, err := ParseWithConfig(&opts, []string{"bot", "--config", path, "--ai-provider=gemini"}, "settings")
+	if err != nil {
+		t.Fatalf("ParseWithConfig() error = %v", err)
+	}
+
+	if opts.DBPath != "sqlite://config.sqlite" {
+		t.Errorf("DBPath = %q, want the config's", opts.DBPath)
+	}
+	if opts.Provider != "gemini" {
+		t.Errorf("Provider = %q, want the flag's", opts.Provider)
+	}
+	if opts.Model != "from-env" {
+		t.Errorf("Model = %q, want the environment's", opts.Model)
+	}
+	if opts.Timeout != time.Minute {
+		t.Errorf("Timeout = %v, want 1m", opts.Timeout)
+	}
+	if len(opts.Fallback) != 2 || opts.Fallback[1] != "openai:gpt-5-mini" {
+		t.Errorf("Fallback = %v, want the config's list", opts.Fallback)
+	}
+	if opts.Cutoff != 0.75 {
+		t.Errorf("Cutoff = %v, want 0.75", opts.Cutoff)
+	}
+	if !opts.Offline {
+		t.Error("Offline = false, want true")
+	}
+
+	data, ok, err := config.SectionJSON("settings")
+	if err != nil || !ok {
+		t.Fatalf("SectionJSON() = %v, %v", ok, err)
+	}
+	if string(data) != `{"default":{"dry_run":true}}` {
+		t.Errorf("SectionJSON() = %s", data)
+	}
+}
+
+func TestParseWithConfig_TOML(t *testing.T) {
+	path := writeConfig(t, "bot.toml", `
+db-path = "sqlite://config.sqlite"
+ai-fallback = ["gemini"]
+ai-timeout = "10s"
+
+[settings.chats."-100456"]
+dry_run = true
+`)
+	t.Setenv("CONFIG_FILE", path)
+
+	var opts configTestOpts
+	config, err := ParseWithConfig(&opts, []string{"bot"}, "settings")
+	if err != nil {
+		t.Fatalf("ParseWithConfig() error = %v", err)
+	}
+	if opts.DBPath != "sqlite://config.sqlite" || opts.Timeout != 10*time.Second || len(opts.Fallback) != 1 {
+		t.Errorf("options = %+v, want the config's", opts)
+	}
+	if opts.Provider != "openai" {
+		t.Errorf("Provider = %q, want the default", opts.Provider)
+	}
+
+	data, _, err := config.SectionJSON("settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var section map[string]map[string]json.RawMessage
+	if err = json.Unmarshal(data, &section); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := section["chats"]["-100456"]; !ok {
+		t.Errorf("SectionJSON() = %s, want the chat", data)
+	}
+}
+
+func TestParseWithConfig_YAMLChatIDKeys(t *testing.T) {
+	path := writeConfig(t, "bot.yml", `
+db-path: db.sqlite
+settings:
+  chats:
+    -100456: {dry_run: true}
+`)
+
+	var opts configTestOpts
+	config, err := ParseWithConfig(&opts, []string{"bot", "--config=" + path}, "settings")
+	if err != nil {
+		t.Fatalf("ParseWithConfig() error = %v", err)
+	}
+	data, _, err := config.SectionJSON("settings")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `{"chats":{"-100456":{"dry_run":true}}}` {
+		t.Errorf("SectionJSON() = %s", data)
+	}
+}
+
+func TestParseWithConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name, file, content string
+	}{
+		{name: "unknown option", file: "bot.yaml", content: "db-path: db.sqlite\nai-modle: gpt\n"},
+		{name: "section of another command", file: "bot.yaml", content: "db-path: db.sqlite\nsettings: {}\n"},
+		{name: "map value", file: "bot.yaml", content: "db-path: {path: db.sqlite}\n"},
+		{name: "no value", file: "bot.yaml", content: "db-path:\n"},
+		{name: "invalid value", file: "bot.yaml", content: "db-path: db.sqlite\nai-timeout: soon\n"},
+		{name: "unsupported format", file: "bot.json", content: `{"db-path": "db.sqlite"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.file, tt.content)
+			var opts configTestOpts
+			if _, err := ParseWithConfig(&opts, []string{"bot", "--config", path}); err == nil {
+				t.Error("ParseWithConfig() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestParseWithConfig_OptionsOfAnotherCommand(t *testing.T) {
+	path := writeConfig(t, "bot.yaml", "db-path: db.sqlite\nai-model: claude\ntelegram-workers-num: 8\n")
+
+	var bot struct {
+		configTestOpts
+		Workers int `long:"telegram-workers-num" default:"5"`
+	}
+	var opts configTestOpts
+	missing := MissingOptions(&opts, &bot)
+	if len(missing) != 1 || missing[0] != "telegram-workers-num" {
+		t.Fatalf("MissingOptions() = %v, want the workers", missing)
+	}
+
+	if _, err := ParseWithConfig(&opts, []string{"replay", "--config", path}, missing...); err != nil {
+		t.Fatalf("ParseWithConfig() error = %v", err)
+	}
+	if opts.Model != "claude" {
+		t.Errorf("Model = %q, want the config's", opts.Model)
+	}
+}
@@ -0,0 +1,357 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/blob"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/media"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+var opts struct {
+	cli.DBOptions
+	TelegramKey string `long:"tg-key" env:"TELEGRAM_API_TOKEN" required:"true" description:"telegram bot api key"`
+	OutputDir   string `long:"output" env:"OUTPUT_DIR" default:"./files" description:"output directory or s3://bucket/prefix url for downloaded files"`
+	DaysBack    int    `long:"days" env:"DAYS_BACK" default:"10" description:"number of days back to fetch messages"`
+	Workers     int    `long:"workers" env:"TELEGRAM_WORKERS_NUM" default:"5" description:"number of concurrent download workers"`
+
+	Manifest    string        `long:"manifest" env:"DOWNLOAD_MANIFEST" default:"./download-manifest.json" description:"file tracking the attempted, failed and succeeded downloads across runs"`
+	RetryFailed bool          `long:"retry-failed" description:"download only the files that failed in previous runs"`
+	Attempts    int           `long:"attempts" env:"DOWNLOAD_ATTEMPTS" default:"3" description:"attempts to download a file before it's marked failed"`
+	RetryDelay  time.Duration `long:"retry-delay" env:"DOWNLOAD_RETRY_DELAY" default:"2s" description:"delay before the second attempt, doubled for each next one"`
+	MaxSize     int64         `long:"max-size" env:"DOWNLOAD_MAX_SIZE" default:"20" description:"largest file to download, in megabytes"`
+	ChatIDs     []string      `long:"chat-id" description:"download media of this chat only, can be repeated"`
+	Actions     []string      `long:"action" description:"download media of messages the bot took this action on, e.g. ban, can be repeated"`
+	Label       string        `long:"label" choice:"spam" choice:"ham" description:"download media of messages the bot's action labels so only"`
+	MediaTypes  []string      `long:"media-type" description:"download only this media type, e.g. image/jpeg or image for any image, can be repeated"`
+	Layout      string        `long:"layout" env:"DOWNLOAD_LAYOUT" default:"chat-date" choice:"chat-date" choice:"flat" description:"layout of the output: chat and date directories, or all files in its root"`
+	NoSidecars  bool          `long:"no-sidecars" description:"don't write a json file describing the message next to each downloaded file"`
+	MaxRate     int64         `long:"max-rate" env:"DOWNLOAD_MAX_RATE" description:"bandwidth limit of all workers together, in kilobytes per second, 0 for none"`
+	Progress    time.Duration `long:"progress-interval" default:"10s" description:"interval of progress reports, 0 reports only when done"`
+	TempDir     string        `long:"temp-dir" env:"DOWNLOAD_TEMP_DIR" description:"directory files are downloaded to before they're moved to the output, the system's temporary directory by default"`
+}
+
+var (
+	wg         sync.WaitGroup
+	downloaded int64
+	skipped    int64
+	failed     int64
+
+	pace   *throttle
+	status *progress
+)
+
+// Run runs the download command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+	log.Info("starting download")
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	output, err := blob.Open(opts.OutputDir)
+	if err != nil {
+		log.Error("opening output", "error", err)
+		os.Exit(1)
+	}
+
+	db, err := storage.Open(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	files, err := loadManifest(opts.Manifest)
+	if err != nil {
+		log.Error("loading manifest", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := files.save(); err != nil {
+			log.Error("saving manifest", "error", err)
+		}
+	}()
+
+	downloader, err := newMediaDownloader(opts.TelegramKey)
+	if err != nil {
+		log.Error("creating media downloader", "error", err)
+		os.Exit(1)
+	}
+
+	fromDate := time.Now().Add(time.Hour * 24 * time.Duration(opts.DaysBack) * -1)
+	filter := storage.MessageFilter{From: fromDate, HasMedia: true}
+	if len(opts.ChatIDs) == 1 {
+		filter.ChatID = opts.ChatIDs[0]
+	}
+	messages, err := db.ListMessages(ctx, filter)
+	if err != nil {
+		log.Error("listing messages from database", "error", err)
+		os.Exit(1)
+	}
+
+	log.Info("messages loaded from database", "count", len(messages), "from", fromDate.Format(time.RFC3339))
+
+	// Filter messages with media files
+	type downloadTask struct {
+		key string
+		msg e.SavedMessage
+	}
+
+	wanted := mediaFilter{
+		chatIDs:    opts.ChatIDs,
+		actions:    opts.Actions,
+		label:      e.Label(opts.Label),
+		mediaTypes: opts.MediaTypes,
+		maxSize:    opts.MaxSize << 20,
+	}
+
+	var tasks []downloadTask
+	var givenUp, filtered int
+	seen := make(map[string]struct{})
+
+	for _, msg := range messages {
+		if msg.MediaFileID == nil || msg.MediaType == nil {
+			continue
+		}
+		if !wanted.match(msg) {
+			filtered++
+			continue
+		}
+		fileID := *msg.MediaFileID
+		if _, exists := seen[fileID]; exists {
+			continue
+		}
+		seen[fileID] = struct{}{}
+
+		// Interrupted downloads are left attempted and are tried again
+		entry, _ := files.get(fileID)
+		switch {
+		case opts.RetryFailed && entry.Status != statusFailed:
+			continue
+		case entry.Status == statusSucceeded:
+			skipped++
+			continue
+		case entry.Status == statusFailed && !opts.RetryFailed:
+			givenUp++
+			continue
+		}
+
+		tasks = append(tasks, downloadTask{
+			key: media.Key(opts.Layout, msg),
+			msg: msg,
+		})
+	}
+
+	if filtered > 0 {
+		log.Info("skipping media not matching the filters", "count", filtered)
+	}
+	if givenUp > 0 {
+		log.Info("skipping files failed in previous runs, use --retry-failed to try them again", "count", givenUp)
+	}
+
+	log.Info("files to download", "count", len(tasks))
+
+	if len(tasks) == 0 {
+		log.Info("no files to download")
+		os.Exit(0)
+	}
+
+	pace = &throttle{bytesPerSecond: float64(opts.MaxRate << 10)}
+	status = newProgress(len(tasks))
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	if opts.Progress > 0 {
+		go status.run(progressCtx, log, opts.Progress)
+	}
+
+	// Create work channel
+	taskChan := make(chan downloadTask, len(tasks))
+	for _, task := range tasks {
+		taskChan <- task
+	}
+	close(taskChan)
+
+	// Start workers
+	for i := 0; i < opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range taskChan {
+				select {
+				case <-ctx.Done():
+					return
+				default:
+				}
+
+				fileID := *task.msg.MediaFileID
+				exists, err := download(ctx, files, fileID, task.key, func() (bool, error) {
+					return fetch(ctx, output, downloader, task.key, task.msg)
+				})
+				status.completed.Add(1)
+				if err != nil {
+					log.Error("downloading file", "error", err, "file_id", fileID, "key", task.key)
+					atomic.AddInt64(&failed, 1)
+					continue
+				}
+				if exists {
+					atomic.AddInt64(&skipped, 1)
+					continue
+				}
+
+				atomic.AddInt64(&downloaded, 1)
+			}
+		}()
+	}
+
+	wg.Wait()
+	stopProgress()
+	status.log(log)
+
+	log.Info("done",
+		"downloaded", downloaded,
+		"skipped", skipped,
+		"failed", failed,
+	)
+}
+
+// download runs fetch for the file, trying again with backoff on errors, and
+// records the attempts and the outcome in the manifest
+func download(ctx context.Context, files *manifest, fileID, key string, fetch func() (bool, error)) (exists bool, err error) {
+	delay := opts.RetryDelay
+	attempt := 1
+	for ; ; attempt++ {
+		if err = pace.wait(ctx); err != nil {
+			return false, err
+		}
+		if err = files.update(fileID, func(entry *manifestEntry) {
+			entry.Key = key
+			entry.Status = statusAttempted
+			entry.Attempts++
+		}); err != nil {
+			return false, err
+		}
+
+		exists, err = fetch()
+		if err == nil {
+			return exists, files.update(fileID, func(entry *manifestEntry) {
+				entry.Status = statusSucceeded
+				entry.Error = ""
+			})
+		}
+		if ctx.Err() != nil {
+			// Left attempted, the next run tries it again
+			return false, err
+		}
+		// Flood control holds all the workers for the time Telegram asks,
+		// the attempt is not counted
+		var apiErr *tg.APIError
+		if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+			pace.pause(apiErr.RetryAfter)
+			attempt--
+			continue
+		}
+		if attempt >= opts.Attempts || errors.Is(err, tg.ErrFileTooLarge) {
+			break
+		}
+
+		if sleepErr := sleep(ctx, delay); sleepErr != nil {
+			return false, err
+		}
+		delay *= 2
+	}
+
+	if saveErr := files.update(fileID, func(entry *manifestEntry) {
+		entry.Status = statusFailed
+		entry.Error = err.Error()
+	}); saveErr != nil {
+		return false, saveErr
+	}
+	return false, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+}
+
+// fetch downloads the media of the message to the output unless it's
+// already there, and writes its sidecar
+func fetch(ctx context.Context, output blob.Store, downloader *mediaDownloader, key string, msg e.SavedMessage) (exists bool, err error) {
+	exists, err = output.Exists(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("checking file: %w", err)
+	}
+	if !exists {
+		if err = fetchFile(ctx, output, downloader, key, *msg.MediaFileID, *msg.MediaType); err != nil {
+			return false, err
+		}
+	}
+
+	if !opts.NoSidecars {
+		if err = putSidecar(ctx, output, key, msg); err != nil {
+			return exists, err
+		}
+	}
+	return exists, nil
+}
+
+// fetchFile streams the file to a temporary file and moves it to the output
+func fetchFile(ctx context.Context, output blob.Store, downloader *mediaDownloader, key, fileID, mimeType string) error {
+	tmp, err := os.CreateTemp(opts.TempDir, "download-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	w := &meteredWriter{ctx: ctx, w: tmp, throttle: pace, progress: status}
+	_, err = downloader.DownloadFileTo(ctx, fileID, w, opts.MaxSize<<20)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if putter, ok := output.(blob.FilePutter); ok {
+		err = putter.PutFile(ctx, key, tmp.Name(), mimeType)
+	} else {
+		var content []byte
+		if content, err = os.ReadFile(tmp.Name()); err == nil {
+			err = output.Put(ctx, key, content, mimeType)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("writing file: %w", err)
+	}
+	return nil
+}
+
+type mediaDownloader struct {
+	client *tg.Client
+}
+
+func newMediaDownloader(token string) (*mediaDownloader, error) {
+	return &mediaDownloader{client: tg.NewClient(token, nil)}, nil
+}
+
+func (d *mediaDownloader) DownloadFileTo(ctx context.Context, fileID string, w io.Writer, maxSize int64) (int64, error) {
+	return d.client.DownloadFileTo(ctx, fileID, w, maxSize)
+}
@@ -0,0 +1,65 @@
+package download
+
+import (
+	"slices"
+	"strings"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// mediaFilter selects the media of the stored messages to download
+type mediaFilter struct {
+	chatIDs []string
+	actions []string
+
+	// label selects the media of messages the action of which implies it,
+	// empty selects any
+	label e.Label
+
+	// mediaTypes are MIME types, such as image/jpeg, or their top-level
+	// types, such as image
+	mediaTypes []string
+
+	// maxSize skips files known to be larger, 0 means no limit
+	maxSize int64
+}
+
+// match reports whether the media of the message is to be downloaded
+func (f mediaFilter) match(msg e.SavedMessage) bool {
+	if len(f.chatIDs) > 0 && !slices.Contains(f.chatIDs, msg.Sender.ChatID) {
+		return false
+	}
+
+	if len(f.actions) > 0 || f.label != "" {
+		if msg.Action == nil {
+			return false
+		}
+		if len(f.actions) > 0 && !slices.Contains(f.actions, string(*msg.Action)) {
+			return false
+		}
+		if f.label != "" && e.LabelOf(*msg.Action) != f.label {
+			return false
+		}
+	}
+
+	if len(f.mediaTypes) > 0 && !slices.ContainsFunc(f.mediaTypes, func(t string) bool {
+		return matchMediaType(t, *msg.MediaType)
+	}) {
+		return false
+	}
+
+	if f.maxSize > 0 && msg.MediaSize != nil && *msg.MediaSize > f.maxSize {
+		return false
+	}
+	return true
+}
+
+// matchMediaType reports whether the MIME type is the pattern or of the
+// pattern's top-level type
+func matchMediaType(pattern, mimeType string) bool {
+	if strings.Contains(pattern, "/") {
+		return strings.EqualFold(pattern, mimeType)
+	}
+	top, _, _ := strings.Cut(mimeType, "/")
+	return strings.EqualFold(pattern, top)
+}
@@ -0,0 +1,121 @@
+package download
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Statuses of the files in a manifest
+const (
+	statusAttempted = "attempted"
+	statusFailed    = "failed"
+	statusSucceeded = "succeeded"
+)
+
+// saveEvery is the number of updates after which the manifest is written
+// while downloading, so an interrupted run loses little of it
+const saveEvery = 50
+
+// manifestEntry is the state of the download of a file
+type manifestEntry struct {
+	Key       string    `json:"key"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// manifest tracks the files downloads were attempted for by their Telegram
+// file ID, so a run picks up where the previous one stopped. It's kept in a
+// JSON file next to the downloads.
+type manifest struct {
+	path string
+
+	mu      sync.Mutex
+	files   map[string]manifestEntry
+	unsaved int
+}
+
+// loadManifest reads the manifest at the path, a missing file is an empty
+// manifest
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{path: path, files: make(map[string]manifestEntry)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	if err = json.Unmarshal(data, &m.files); err != nil {
+		return nil, fmt.Errorf("decoding manifest: %w", err)
+	}
+	return m, nil
+}
+
+// get returns the entry of the file
+func (m *manifest) get(fileID string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.files[fileID]
+	return entry, ok
+}
+
+// update changes the entry of the file and writes the manifest every
+// saveEvery updates
+func (m *manifest) update(fileID string, change func(entry *manifestEntry)) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry := m.files[fileID]
+	change(&entry)
+	entry.UpdatedAt = time.Now().UTC()
+	m.files[fileID] = entry
+
+	if m.unsaved++; m.unsaved < saveEvery {
+		return nil
+	}
+	return m.saveLocked()
+}
+
+// save writes the manifest
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveLocked()
+}
+
+// saveLocked writes the manifest to a temporary file renamed over the old
+// one, so a crash never leaves it half-written
+func (m *manifest) saveLocked() error {
+	data, err := json.MarshalIndent(m.files, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding manifest: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(m.path), filepath.Base(m.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("creating manifest: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), m.path); err != nil {
+		return fmt.Errorf("replacing manifest: %w", err)
+	}
+
+	m.unsaved = 0
+	return nil
+}
@@ -0,0 +1,84 @@
+package download
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// barWidth is the number of characters of the progress bar
+const barWidth = 20
+
+// progress counts the files done of a run and the bytes downloaded
+type progress struct {
+	total int64
+	start time.Time
+
+	completed atomic.Int64
+	bytes     atomic.Int64
+}
+
+func newProgress(total int) *progress {
+	return &progress{total: int64(total), start: time.Now()}
+}
+
+// run logs the progress every interval until ctx is done
+func (p *progress) run(ctx context.Context, log logger.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.log(log)
+		}
+	}
+}
+
+// log logs the files done out of the total, the download speed and the time
+// left at the average pace so far
+func (p *progress) log(log logger.Logger) {
+	completed, bytes := p.completed.Load(), p.bytes.Load()
+	elapsed := time.Since(p.start)
+
+	args := []any{
+		"bar", bar(completed, p.total),
+		"completed", completed,
+		"total", p.total,
+		"downloaded", formatBytes(float64(bytes)),
+		"speed", formatBytes(float64(bytes)/elapsed.Seconds()) + "/s",
+	}
+	if completed > 0 && completed < p.total {
+		eta := time.Duration(float64(elapsed) / float64(completed) * float64(p.total-completed))
+		args = append(args, "eta", eta.Round(time.Second).String())
+	}
+	log.Info("progress", args...)
+}
+
+// bar draws the share of the total done, e.g. [#####---------------] 25%
+func bar(done, total int64) string {
+	if total <= 0 {
+		return ""
+	}
+	filled := int(done * barWidth / total)
+	return fmt.Sprintf("[%s%s] %d%%", strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled), done*100/total)
+}
+
+// formatBytes formats a number of bytes in binary units, e.g. 1.5 MiB
+func formatBytes(n float64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%.0f B", n)
+	}
+	exp := 0
+	for n >= unit*unit && exp < 3 {
+		n /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", n/unit, "KMGT"[exp])
+}
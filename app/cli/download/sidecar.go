@@ -0,0 +1,65 @@
+package download
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/blob"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// sidecar describes a downloaded file and the message it came with, so the
+// files can be labeled and trained on without the database
+type sidecar struct {
+	FileID     string          `json:"file_id"`
+	MediaType  string          `json:"media_type"`
+	MediaSize  *int64          `json:"media_size,omitempty"`
+	ChatID     string          `json:"chat_id"`
+	ChatTitle  string          `json:"chat_title,omitempty"`
+	MessageID  string          `json:"message_id"`
+	SenderID   string          `json:"sender_id"`
+	SenderName string          `json:"sender_name,omitempty"`
+	Text       string          `json:"text,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Action     *e.ActionKind   `json:"action,omitempty"`
+	Category   *e.SpamCategory `json:"category,omitempty"`
+	Note       *string         `json:"note,omitempty"`
+	Label      e.Label         `json:"label,omitempty"`
+}
+
+func newSidecar(msg e.SavedMessage) sidecar {
+	s := sidecar{
+		FileID:     *msg.MediaFileID,
+		MediaType:  *msg.MediaType,
+		MediaSize:  msg.MediaSize,
+		ChatID:     msg.Sender.ChatID,
+		ChatTitle:  msg.Sender.ChatTitle,
+		MessageID:  msg.ID,
+		SenderID:   msg.Sender.ID,
+		SenderName: msg.Sender.Name,
+		Text:       msg.Text,
+		CreatedAt:  msg.CreatedAt,
+		Action:     msg.Action,
+		Category:   msg.Category,
+		Note:       msg.ActionNote,
+	}
+	if msg.Action != nil {
+		s.Label = e.LabelOf(*msg.Action)
+	}
+	return s
+}
+
+// putSidecar writes the sidecar of the message next to the file of the key,
+// named by the key with .json appended
+func putSidecar(ctx context.Context, output blob.Store, key string, msg e.SavedMessage) error {
+	data, err := json.MarshalIndent(newSidecar(msg), "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding sidecar: %w", err)
+	}
+	if err = output.Put(ctx, key+".json", data, "application/json"); err != nil {
+		return fmt.Errorf("writing sidecar: %w", err)
+	}
+	return nil
+}
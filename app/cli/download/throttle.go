@@ -0,0 +1,101 @@
+package download
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize is the most bytes written at once by a throttled writer, so a
+// bandwidth limit is kept smoothly rather than in bursts
+const chunkSize = 32 << 10
+
+// throttle paces the downloads of all the workers: it holds them while
+// Telegram's flood control asks to wait, and spreads the bytes they write
+// to keep the bandwidth under a limit
+type throttle struct {
+	// bytesPerSecond limits the bandwidth, 0 means no limit
+	bytesPerSecond float64
+
+	mu          sync.Mutex
+	next        time.Time // when the next bytes may be written
+	pausedUntil time.Time
+}
+
+// pause holds the workers for d
+func (t *throttle) pause(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(d); until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+}
+
+// wait returns when a request may be made
+func (t *throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	d := time.Until(t.pausedUntil)
+	t.mu.Unlock()
+	return sleep(ctx, d)
+}
+
+// waitBytes returns when n bytes may be written
+func (t *throttle) waitBytes(ctx context.Context, n int) error {
+	if t.bytesPerSecond <= 0 {
+		return nil
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	d := t.next.Sub(now)
+	t.next = t.next.Add(time.Duration(float64(n) / t.bytesPerSecond * float64(time.Second)))
+	t.mu.Unlock()
+
+	return sleep(ctx, d)
+}
+
+// meteredWriter writes to w at the pace of the throttle, counting the bytes
+// in the progress
+type meteredWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	throttle *throttle
+	progress *progress
+}
+
+func (m *meteredWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		if err := m.throttle.waitBytes(m.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := m.w.Write(chunk)
+		written += n
+		m.progress.bytes.Add(int64(n))
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,137 @@
+package eval
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+)
+
+// checkpointLine is a result of a check in a checkpoint file
+type checkpointLine struct {
+	// Variant is the key of the variant, see variant.key
+	Variant string `json:"variant"`
+
+	// ID is the ID of the request, the message's chat and ID
+	ID string `json:"id"`
+
+	Content   json.RawMessage `json:"content"`
+	Usage     *ai.Usage       `json:"usage,omitempty"`
+	Model     string          `json:"model,omitempty"`
+	Batch     bool            `json:"batch,omitempty"`
+	LatencyMS int64           `json:"latency_ms"`
+}
+
+// checkpoint keeps the results of the checks done in a JSON lines file,
+// appended as they're done, so an interrupted run picks up where it stopped.
+// Failed checks are not kept and are made again. A nil checkpoint keeps
+// nothing.
+type checkpoint struct {
+	mu   sync.Mutex
+	file *os.File
+	done map[string]ai.BatchResult // by variant and request ID
+}
+
+// openCheckpoint reads the results of the file and opens it for appending
+func openCheckpoint(path string) (*checkpoint, error) {
+	c := &checkpoint{done: make(map[string]ai.BatchResult)}
+
+	var torn bool
+	f, err := os.Open(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, fmt.Errorf("opening checkpoint: %w", err)
+	default:
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var line checkpointLine
+			if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+				// The last line of an interrupted run may be cut short
+				continue
+			}
+			result := ai.BatchResult{ID: line.ID, Content: line.Content, Usage: line.Usage, Latency: time.Duration(line.LatencyMS) * time.Millisecond}
+			if result.Usage != nil {
+				result.Usage.Model, result.Usage.Batch = line.Model, line.Batch
+			}
+			c.done[line.Variant+" "+line.ID] = result
+		}
+		err = scanner.Err()
+		if info, statErr := f.Stat(); statErr == nil && info.Size() > 0 {
+			last := make([]byte, 1)
+			if _, readErr := f.ReadAt(last, info.Size()-1); readErr == nil && last[0] != '\n' {
+				torn = true
+			}
+		}
+		_ = f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading checkpoint: %w", err)
+		}
+	}
+
+	if c.file, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return nil, fmt.Errorf("opening checkpoint: %w", err)
+	}
+	if torn {
+		// Results go on a line of their own after the cut one
+		if _, err = c.file.WriteString("\n"); err != nil {
+			_ = c.file.Close()
+			return nil, fmt.Errorf("writing checkpoint: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// result returns the kept result of the request of the variant
+func (c *checkpoint) result(variant, id string) (ai.BatchResult, bool) {
+	if c == nil {
+		return ai.BatchResult{}, false
+	}
+	result, ok := c.done[variant+" "+id]
+	return result, ok
+}
+
+// len returns the number of the kept results
+func (c *checkpoint) len() int {
+	if c == nil {
+		return 0
+	}
+	return len(c.done)
+}
+
+// record appends the result of the variant's request if it succeeded
+func (c *checkpoint) record(variant string, result ai.BatchResult) error {
+	if c == nil || result.Err != nil || result.ID == "" {
+		return nil
+	}
+
+	line := checkpointLine{Variant: variant, ID: result.ID, Content: result.Content, Usage: result.Usage, LatencyMS: result.Latency.Milliseconds()}
+	if result.Usage != nil {
+		line.Model, line.Batch = result.Usage.Model, result.Usage.Batch
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return fmt.Errorf("encoding checkpoint: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, err = c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// close closes the file
+func (c *checkpoint) close() error {
+	if c == nil {
+		return nil
+	}
+	return c.file.Close()
+}
@@ -0,0 +1,56 @@
+package eval
+
+import (
+	"cmp"
+	"fmt"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// completionTokensEstimate is the estimate of the tokens of a verdict, its
+// JSON with a short note. Reasoning models spend more on thinking, so their
+// estimated cost is a lower bound.
+const completionTokensEstimate = 100
+
+// estimate logs the tokens and the cost of checking the messages with each
+// variant, without asking the AI. Messages with an image count it if images
+// are to be downloaded.
+func estimate(log logger.Logger, variants []*variant, msgs []e.SavedMessage, withImages bool) {
+	var total float64
+	var unpriced bool
+	for _, v := range variants {
+		usage := ai.Usage{
+			Model:            v.modelID,
+			CompletionTokens: completionTokensEstimate * len(msgs),
+			Batch:            v.batcher.Batch != nil && len(msgs) >= cmp.Or(v.batcher.MinBatch, ai.DefaultMinBatch),
+		}
+		for _, msg := range msgs {
+			images := 0
+			if withImages && hasImage(msg) {
+				images = 1
+			}
+			usage.PromptTokens += ai.EstimatePromptTokens(images, v.prompt.Text, userText(msg))
+		}
+		usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+		args := []any{
+			"variant", v.name,
+			"requests", len(msgs),
+			"prompt_tokens", usage.PromptTokens,
+			"completion_tokens", usage.CompletionTokens,
+			"batch", usage.Batch,
+		}
+		if cost, ok := usage.Cost(); ok {
+			total += cost
+			args = append(args, "cost_usd", fmt.Sprintf("%.4f", cost))
+		} else {
+			unpriced = true
+			args = append(args, "cost_usd", "unknown price of "+v.modelID)
+		}
+		log.Info("estimate", args...)
+	}
+
+	log.Info("estimated cost of the run", "cost_usd", fmt.Sprintf("%.4f", total), "without_unknown_prices", unpriced)
+}
@@ -0,0 +1,506 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	_ "embed"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	"nuclight.org/antispam-tg-bot/pkg/blob"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/media"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+var opts struct {
+	cli.DBOptions
+	OpenAIKey   string `long:"ai-key" env:"OPENAI_KEY" required:"true" description:"ai api key"`
+	AIBaseURL   string `long:"ai-base-url" env:"AI_BASE_URL" description:"base url of an openai-compatible api, empty uses openai"`
+	AIModel     string `long:"ai-model" env:"AI_MODEL" description:"model to test, empty uses the default"`
+	AIRPM       int    `long:"ai-requests-per-minute" env:"AI_REQUESTS_PER_MINUTE" description:"limit of ai requests per minute, 0 doesn't limit them"`
+	AITPM       int    `long:"ai-tokens-per-minute" env:"AI_TOKENS_PER_MINUTE" description:"limit of ai tokens per minute, 0 doesn't limit them"`
+	TelegramKey string `long:"tg-key" env:"TELEGRAM_KEY" description:"telegram bot api key (optional, for image analysis)"`
+
+	Media        string `long:"media" description:"directory or s3://bucket/prefix url of media downloaded by the download command, images are looked up there before telegram"`
+	MediaLayout  string `long:"media-layout" default:"chat-date" choice:"chat-date" choice:"flat" description:"layout --media was downloaded with"`
+	MediaOnly    bool   `long:"media-only" description:"check only the messages with an image, to evaluate the vision check"`
+	ImageMaxDim  int    `long:"image-max-dimension" default:"1024" description:"longest side in pixels images are shrunk to before the check, as the bot does; 0 sends them as they are"`
+	ImageQuality int    `long:"image-jpeg-quality" default:"85" description:"jpeg quality of shrunk images"`
+
+	Batch         bool          `long:"batch" description:"check through the openai batch api at about half the price, waiting up to a day"`
+	BatchMin      int           `long:"batch-min" default:"20" description:"smallest number of messages checked as a batch, fewer are checked one by one"`
+	BatchInterval time.Duration `long:"batch-poll-interval" default:"30s" description:"interval between checks of the batch status"`
+
+	PromptVersion string `long:"prompt-version" description:"evaluate only decisions made with this prompt version"`
+	OlderPrompts  bool   `long:"older-prompts" description:"evaluate only decisions made with prompts other than the tested one"`
+
+	Output string `long:"output" description:"file to write the per-message results to, csv for a .csv file and json otherwise"`
+
+	Prompt        string `long:"prompt" description:"prompt to test: a file, or a name or version of a prompt of --prompts-dir or builtin; empty uses the embedded one"`
+	ComparePrompt string `long:"compare-prompt" description:"second prompt checking the same messages, to compare the prompts' verdicts"`
+	PromptsDir    string `long:"prompts-dir" description:"directory of .txt prompts referred to by name"`
+
+	Sample     int     `long:"sample" description:"check at most this number of messages, picked the same way on every run; 0 checks all"`
+	SampleRate float64 `long:"sample-rate" description:"check this share of the messages, from 0 to 1, picked the same way on every run; 0 checks all"`
+	Checkpoint string  `long:"checkpoint" description:"jsonl file the results are appended to as they're done, so a rerun skips the messages already checked"`
+	Truth      string  `long:"truth" default:"labels" choice:"labels" choice:"actions" description:"what the verdicts are compared to: labels given by admins' overrides and ground truth, falling back to the bot's actions, or only the actions"`
+	HumanOnly  bool    `long:"human-labels-only" description:"check only the messages labeled by an override or ground truth"`
+	DryRun     bool    `long:"dry-run" description:"estimate the tokens and the cost of the run without asking the ai"`
+
+	CompareModels   []string `long:"compare-model" description:"provider[:model] checking the same messages, to compare the models' verdicts, repeat for more"`
+	CompareKeys     []string `long:"compare-model-key" description:"api keys of the --compare-model providers in their order, a missing one is --ai-key"`
+	CompareBaseURLs []string `long:"compare-model-base-url" description:"base urls of the --compare-model providers in their order, e.g. of a local openai-compatible server"`
+}
+
+//go:embed system_prompt.txt
+var prompt string
+
+// truthLabels is the --truth of labels given by people
+const truthLabels = "labels"
+
+// workers is the number of messages checked at once without the batch api
+const workers = 10
+
+// Run runs the eval command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+	log.Info("starting test")
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.OpenReadOnly(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	llm, err := ai.NewProvider(ai.ProviderOptions{
+		Name:        ai.ProviderOpenAI,
+		APIKey:      opts.OpenAIKey,
+		BaseURL:     opts.AIBaseURL,
+		Model:       opts.AIModel,
+		VisionModel: opts.AIModel,
+		Retry:       ai.DefaultRetryPolicy,
+		RateLimit:   ai.RateLimit{RequestsPerMinute: opts.AIRPM, TokensPerMinute: opts.AITPM},
+	}, http.DefaultClient)
+	if err != nil {
+		log.Error("creating ai provider", "error", err)
+		os.Exit(1)
+	}
+
+	imgs := &images{
+		layout:     opts.MediaLayout,
+		downscaler: media.NewDownscaler(opts.ImageMaxDim, opts.ImageQuality),
+	}
+	if opts.TelegramKey != "" {
+		imgs.downloader, err = newMediaDownloader(opts.TelegramKey)
+		if err != nil {
+			log.Error("creating media downloader", "error", err)
+			os.Exit(1)
+		}
+		log.Info("telegram media downloader enabled")
+	}
+	if opts.Media != "" {
+		if imgs.store, err = blob.Open(opts.Media); err != nil {
+			log.Error("opening media store", "error", err)
+			os.Exit(1)
+		}
+		log.Info("stored media enabled", "location", opts.Media)
+	}
+	if opts.MediaOnly && !imgs.available() {
+		log.Error("--media-only needs --media or --tg-key to load the images")
+		os.Exit(1)
+	}
+
+	registry := services.NewPromptRegistry()
+	if opts.PromptsDir != "" {
+		if err := registry.LoadDir(opts.PromptsDir); err != nil {
+			log.Error("loading prompts", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	batcher := ai.Batcher{
+		Provider:     llm,
+		MinBatch:     opts.BatchMin,
+		PollInterval: opts.BatchInterval,
+		Concurrency:  workers,
+	}
+	if opts.Batch {
+		batcher.Batch = ai.NewOpenAI(opts.OpenAIKey, ai.WithRetries(http.DefaultClient, ai.DefaultRetryPolicy), ai.OpenAIOptions{
+			BaseURL:     opts.AIBaseURL,
+			Model:       opts.AIModel,
+			VisionModel: opts.AIModel,
+		})
+	}
+
+	variants, err := setupVariants(registry, batcher)
+	if err != nil {
+		log.Error("setting up variants", "error", err)
+		os.Exit(1)
+	}
+	for _, v := range variants {
+		log.Info("testing variant", "variant", v.name, "prompt_version", v.prompt.Version, "model", v.model)
+	}
+
+	filter := storage.MessageFilter{
+		From:          time.Now().Add(time.Hour * 24 * 10 * -1),
+		PromptVersion: opts.PromptVersion,
+	}
+	if opts.OlderPrompts {
+		filter.ExceptPromptVersion = variants[0].prompt.Version
+	}
+	startedAt := time.Now().UTC()
+
+	messages, err := db.ListMessages(ctx, filter)
+	if err != nil {
+		log.Error("listing messages from database", "error", err)
+		os.Exit(1)
+	}
+
+	log.Info("messages loaded from database", "count", len(messages))
+
+	dedup := make(map[string]struct{}, len(messages))
+	unique := make([]e.SavedMessage, 0, len(messages))
+
+	for _, msg := range messages {
+		if opts.MediaOnly && !hasImage(msg) {
+			continue
+		}
+		// Messages with the same text and different images are checked
+		// separately
+		key := textnorm.Hash(msg.Text)
+		if hasImage(msg) && imgs.available() {
+			key += "/" + *msg.MediaFileID
+		}
+		if _, exists := dedup[key]; exists {
+			//log.Warn("duplicate message found", "text", msg.Text, "id", msg.ID)
+			continue
+		}
+		dedup[key] = struct{}{}
+
+		unique = append(unique, msg)
+	}
+
+	var truths *truth
+	if opts.Truth == truthLabels {
+		if truths, err = loadTruth(ctx, db); err != nil {
+			log.Error("loading labels", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	checked := make([]e.SavedMessage, 0, len(unique))
+	expected := make(map[string]expectation, len(unique))
+	for _, msg := range unique {
+		expect := truths.expect(msg)
+		if expect.label == "" {
+			log.Debug("message without action", "id", msg.ID, "text", msg.Text)
+			continue
+		}
+		if opts.HumanOnly && expect.source == labelSourceAction {
+			continue
+		}
+		checked = append(checked, msg)
+		expected[requestID(msg)] = expect
+	}
+
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		log.Error("sample rate is not between 0 and 1", "sample_rate", opts.SampleRate)
+		os.Exit(1)
+	}
+	if opts.Sample > 0 || opts.SampleRate > 0 {
+		checked = sample(checked, opts.SampleRate, opts.Sample)
+		log.Info("sampled messages", "count", len(checked))
+	}
+
+	if opts.DryRun {
+		estimate(log, variants, checked, imgs.available())
+		os.Exit(0)
+	}
+
+	var cp *checkpoint
+	if opts.Checkpoint != "" {
+		if cp, err = openCheckpoint(opts.Checkpoint); err != nil {
+			log.Error("opening checkpoint", "error", err)
+			os.Exit(1)
+		}
+		defer func() {
+			if err := cp.close(); err != nil {
+				log.Error("closing checkpoint", "error", err)
+			}
+		}()
+		log.Info("checkpoint loaded", "results", cp.len())
+	}
+
+	// Checks kept in the checkpoint are not made again; indexes map the
+	// requests of each variant to the checked messages
+	results := make([][]ai.BatchResult, len(variants))
+	requests := make([][]ai.BatchRequest, len(variants))
+	indexes := make([][]int, len(variants))
+	var restored, missingImages int
+	for i := range variants {
+		results[i] = make([]ai.BatchResult, len(checked))
+	}
+	for k, msg := range checked {
+		var request *ai.BatchRequest
+		for i, v := range variants {
+			if result, ok := cp.result(v.key(), requestID(msg)); ok {
+				results[i][k] = result
+				restored++
+				continue
+			}
+			if request == nil {
+				r := checkRequest(ctx, log, imgs, msg)
+				if r.Image == nil && hasImage(msg) && imgs.available() {
+					missingImages++
+				}
+				request = &r
+			}
+			r := *request
+			r.System = v.prompt.Text
+			requests[i] = append(requests[i], r)
+			indexes[i] = append(indexes[i], k)
+		}
+	}
+
+	if missingImages > 0 {
+		log.Warn("images not loaded, their messages are checked by the text only", "count", missingImages)
+	}
+	log.Info("checking messages", "count", len(checked), "variants", len(variants), "restored", restored, "batch", opts.Batch && len(checked) >= opts.BatchMin)
+
+	// The variants check the messages at once, each with its own provider
+	errs := make([]error, len(variants))
+	var wg sync.WaitGroup
+	for i, v := range variants {
+		batcher := v.batcher
+		batcher.OnResult = func(_ int, result ai.BatchResult) {
+			if err := cp.record(v.key(), result); err != nil {
+				log.Error("saving result to checkpoint", "error", err)
+			}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var run []ai.BatchResult
+			run, errs[i] = batcher.Run(ctx, requests[i])
+			for j, result := range run {
+				results[i][indexes[i][j]] = result
+			}
+		}()
+	}
+	wg.Wait()
+
+	switch err := errors.Join(errs...); {
+	case errors.Is(err, context.Canceled):
+		log.Info("context canceled, stopping")
+	case err != nil:
+		log.Error("checking messages", "error", err)
+		os.Exit(1)
+	}
+
+	rep := report{StartedAt: startedAt}
+	agreements := make([]agreement, len(variants))
+	for i, msg := range checked {
+		rows := make([]resultRow, len(variants))
+		withImage := hasImage(msg) && imgs.available()
+		for j, v := range variants {
+			if results[j][i].ID == "" {
+				// Not sent before the run was stopped
+				continue
+			}
+			result := results[j][i]
+			rows[j] = compare(log, v, msg, expected[requestID(msg)], result, withImage)
+			rep.Results = append(rep.Results, rows[j])
+		}
+
+		for j := 1; j < len(variants); j++ {
+			if rows[0].NewLabel == "" || rows[j].NewLabel == "" {
+				continue
+			}
+			agreements[j].compared++
+			if rows[0].NewLabel == rows[j].NewLabel {
+				agreements[j].agreed++
+				continue
+			}
+			log.Warn("variants disagree", "text", msg.Text,
+				variants[0].name, rows[0].NewLabel, variants[j].name, rows[j].NewLabel,
+				"expected_label", rows[0].ExpectedLabel)
+		}
+	}
+
+	for j, v := range variants {
+		mean, p95 := v.verdicts.latency()
+		summary := variantSummary{
+			Name:          v.name,
+			PromptVersion: v.prompt.Version,
+			Model:         v.model,
+			Processed:     v.verdicts.processed,
+			StayTheSame:   v.verdicts.stayTheSame,
+			BecomeSpam:    v.verdicts.becomeSpam,
+			BecomeNotSpam: v.verdicts.becomeNotSpam,
+			Accuracy:      v.verdicts.accuracy(),
+			Images:        v.verdicts.images,
+			ImageAccuracy: v.verdicts.imageAccuracy(),
+			MeanLatencyMS: mean.Milliseconds(),
+			P95LatencyMS:  p95.Milliseconds(),
+			Cost:          v.verdicts.cost,
+		}
+		args := []any{
+			"variant", v.name,
+			"processed", v.verdicts.processed,
+			"stay_the_same", v.verdicts.stayTheSame,
+			"become_spam", v.verdicts.becomeSpam,
+			"become_not_spam", v.verdicts.becomeNotSpam,
+			"accuracy", fmt.Sprintf("%.1f%%", summary.Accuracy*100),
+			"mean_latency", mean.Round(time.Millisecond),
+			"p95_latency", p95.Round(time.Millisecond),
+			"cost_usd", fmt.Sprintf("%.4f", summary.Cost),
+		}
+		if summary.Images > 0 {
+			args = append(args, "with_image", summary.Images, "image_accuracy", fmt.Sprintf("%.1f%%", summary.ImageAccuracy*100))
+		}
+		if j > 0 {
+			rate := agreements[j].rate()
+			summary.Agreement = &rate
+			args = append(args, "agreement", fmt.Sprintf("%.1f%%", rate*100), "disagreed", agreements[j].compared-agreements[j].agreed)
+		}
+		rep.Variants = append(rep.Variants, summary)
+		log.Info("done", args...)
+	}
+
+	if opts.Output != "" {
+		if err := writeReport(opts.Output, rep); err != nil {
+			log.Error("writing report", "error", err)
+			os.Exit(1)
+		}
+		log.Info("report written", "path", opts.Output)
+	}
+
+	os.Exit(0)
+}
+
+// checkRequest returns the spam check request of the message, with its
+// image if it's available and supported
+func checkRequest(ctx context.Context, log logger.Logger, imgs *images, msg e.SavedMessage) ai.BatchRequest {
+	request := ai.BatchRequest{
+		ID:     requestID(msg),
+		User:   userText(msg),
+		Format: ai.SpamCheckFormat,
+	}
+
+	if imgs.available() && hasImage(msg) {
+		content, mimeType, err := imgs.load(ctx, msg)
+		if err != nil {
+			log.Warn("loading image", "error", err, "file_id", *msg.MediaFileID)
+		} else if len(content) > 0 {
+			request.Image = &ai.ImageData{Content: content, MimeType: mimeType}
+		}
+	}
+
+	return request
+}
+
+// requestID returns the ID of the spam check request of the message
+func requestID(msg e.SavedMessage) string {
+	return msg.Sender.ChatID + "/" + msg.ID
+}
+
+// userText returns the user message of the spam check of the message
+func userText(msg e.SavedMessage) string {
+	if msg.Text == "" {
+		return "(no text, analyze image only)"
+	}
+	return msg.Text
+}
+
+// compare counts the result of checking the message with the variant
+// against the label it's expected to get and returns its row of the report.
+// withImage tells the checks of messages with an image apart.
+func compare(log logger.Logger, v *variant, msg e.SavedMessage, expect expectation, result ai.BatchResult, withImage bool) resultRow {
+	v.verdicts.processed++
+	if withImage {
+		v.verdicts.images++
+	}
+
+	wasSpam := expect.label == e.LabelSpam
+
+	row := resultRow{
+		Variant:       v.name,
+		ChatID:        msg.Sender.ChatID,
+		MessageID:     msg.ID,
+		TextHash:      textnorm.Hash(msg.Text),
+		ExpectedLabel: string(expect.label),
+		LabelSource:   expect.source,
+		LatencyMS:     result.Latency.Milliseconds(),
+		Image:         withImage,
+	}
+	if msg.Action != nil {
+		row.OldAction = string(*msg.Action)
+	}
+	if result.Latency > 0 {
+		v.verdicts.latencies = append(v.verdicts.latencies, result.Latency)
+	}
+	if result.Usage != nil {
+		row.PromptTokens, row.CompletionTokens = result.Usage.PromptTokens, result.Usage.CompletionTokens
+		row.Cost, _ = result.Usage.Cost()
+		v.verdicts.cost += row.Cost
+	}
+
+	var checkResult ai.SpamCheck
+	if err := result.Decode(&checkResult); err != nil {
+		log.Error("getting completion", "error", err, "text", msg.Text)
+		row.Error = err.Error()
+		return row
+	}
+
+	row.NewLabel = string(label(checkResult.IsSpam))
+	row.Category = checkResult.Category
+	row.Confidence = checkResult.Confidence
+	row.Note = checkResult.Note
+	row.Changed = checkResult.IsSpam != wasSpam
+
+	switch {
+	case checkResult.IsSpam == wasSpam:
+		v.verdicts.stayTheSame++
+		if withImage {
+			v.verdicts.imagesSame++
+		}
+	case checkResult.IsSpam:
+		v.verdicts.becomeSpam++
+		log.Info("became spam", "variant", v.name, "text", msg.Text, "note", checkResult.Note, "user", msg.Sender.Name, "time", msg.CreatedAt)
+	default:
+		v.verdicts.becomeNotSpam++
+		log.Warn("became not a spam", "variant", v.name, "text", msg.Text, "user", msg.Sender.Name, "time", msg.CreatedAt)
+	}
+	return row
+}
+
+// label returns the label of a spam verdict
+func label(isSpam bool) e.Label {
+	if isSpam {
+		return e.LabelSpam
+	}
+	return e.LabelHam
+}
@@ -0,0 +1,88 @@
+package eval
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	"nuclight.org/antispam-tg-bot/pkg/blob"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/media"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+// images loads the images of the checked messages the way the bot does for
+// its vision check: from the media downloaded by the download command,
+// falling back to Telegram, then downscaled. Without a store or a downloader
+// it loads nothing.
+type images struct {
+	store      blob.Store
+	layout     string
+	downloader *mediaDownloader
+	downscaler *media.Downscaler
+}
+
+// available reports whether the images of the messages can be loaded
+func (i *images) available() bool {
+	return i.store != nil || i.downloader != nil
+}
+
+// load returns the image of the message and its mime type
+func (i *images) load(ctx context.Context, msg e.SavedMessage) ([]byte, string, error) {
+	content, err := i.fetch(ctx, msg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mimeType := *msg.MediaType
+	if i.downscaler == nil {
+		return content, mimeType, nil
+	}
+	small, smallType, err := i.downscaler.Downscale(content, mimeType)
+	if err != nil {
+		// The vision model can take the image as it is
+		return content, mimeType, nil
+	}
+	return small, smallType, nil
+}
+
+// fetch returns the content of the image of the message from the store or,
+// if it's not there, from Telegram
+func (i *images) fetch(ctx context.Context, msg e.SavedMessage) ([]byte, error) {
+	if i.store != nil {
+		content, err := i.store.Get(ctx, media.Key(i.layout, msg))
+		switch {
+		case err == nil:
+			return content, nil
+		case !errors.Is(err, blob.ErrNotFound):
+			return nil, fmt.Errorf("reading stored media: %w", err)
+		case i.downloader == nil:
+			return nil, fmt.Errorf("media not downloaded: %w", err)
+		}
+	}
+
+	content, err := i.downloader.DownloadFile(ctx, *msg.MediaFileID)
+	if err != nil {
+		return nil, fmt.Errorf("downloading media from telegram: %w", err)
+	}
+	return content, nil
+}
+
+// hasImage reports whether the message has an image the AI can look at
+func hasImage(msg e.SavedMessage) bool {
+	return msg.MediaType != nil && ai.IsVisionSupported(*msg.MediaType) && msg.MediaFileID != nil
+}
+
+// mediaDownloader downloads media files from Telegram by file ID
+type mediaDownloader struct {
+	client *tg.Client
+}
+
+func newMediaDownloader(token string) (*mediaDownloader, error) {
+	return &mediaDownloader{client: tg.NewClient(token, nil)}, nil
+}
+
+func (d *mediaDownloader) DownloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	return d.client.DownloadFile(ctx, fileID)
+}
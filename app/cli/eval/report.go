@@ -0,0 +1,139 @@
+package eval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// resultRow is the evaluation of a message by a variant in a report
+type resultRow struct {
+	Variant   string `json:"variant"`
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+
+	// TextHash is the hash of the normalized text, the same for messages
+	// differing only in obfuscation
+	TextHash string `json:"text_hash"`
+
+	// OldAction is the bot's action, empty if it made no decision
+	OldAction string `json:"old_action,omitempty"`
+
+	// ExpectedLabel is the label the check is compared to, LabelSource
+	// where it comes from: override, ground_truth or action
+	ExpectedLabel string `json:"expected_label"`
+	LabelSource   string `json:"label_source"`
+
+	NewLabel   string  `json:"new_label,omitempty"`
+	Category   string  `json:"category,omitempty"`
+	Confidence float64 `json:"confidence"`
+	Note       string  `json:"note,omitempty"`
+	Changed    bool    `json:"changed"`
+
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost_usd"`
+	LatencyMS        int64   `json:"latency_ms"`
+
+	// Image is whether the message has an image it was checked with, unless
+	// the image failed to load
+	Image bool `json:"image"`
+
+	Error string `json:"error,omitempty"`
+}
+
+// report is the machine-readable result of a run
+type report struct {
+	StartedAt time.Time `json:"started_at"`
+
+	Variants []variantSummary `json:"variants"`
+	Results  []resultRow      `json:"results"`
+}
+
+// variantSummary is the totals of a variant of a run
+type variantSummary struct {
+	Name          string `json:"name"`
+	PromptVersion string `json:"prompt_version"`
+	Model         string `json:"model"`
+
+	Processed     int `json:"processed"`
+	StayTheSame   int `json:"stay_the_same"`
+	BecomeSpam    int `json:"become_spam"`
+	BecomeNotSpam int `json:"become_not_spam"`
+
+	// Accuracy is the share of the verdicts matching the expected labels
+	Accuracy float64 `json:"accuracy"`
+
+	// Images is the number of the messages with an image to check them with,
+	// ImageAccuracy the share of their verdicts matching the expected labels
+	Images        int     `json:"images"`
+	ImageAccuracy float64 `json:"image_accuracy"`
+
+	MeanLatencyMS int64   `json:"mean_latency_ms"`
+	P95LatencyMS  int64   `json:"p95_latency_ms"`
+	Cost          float64 `json:"cost_usd"`
+
+	// Agreement is the share of the messages the variant's verdict was the
+	// first variant's on, nil for the first variant
+	Agreement *float64 `json:"agreement,omitempty"`
+}
+
+// reportHeader is the header of a CSV report, in the order of the columns of
+// resultRow.csv
+var reportHeader = []string{
+	"variant", "chat_id", "message_id", "text_hash", "old_action", "expected_label", "label_source", "new_label", "category", "confidence", "note",
+	"changed", "prompt_tokens", "completion_tokens", "cost_usd", "latency_ms", "image", "error",
+}
+
+func (r resultRow) csv() []string {
+	return []string{
+		r.Variant, r.ChatID, r.MessageID, r.TextHash, r.OldAction, r.ExpectedLabel, r.LabelSource, r.NewLabel, r.Category,
+		strconv.FormatFloat(r.Confidence, 'f', -1, 64), r.Note, strconv.FormatBool(r.Changed),
+		strconv.Itoa(r.PromptTokens), strconv.Itoa(r.CompletionTokens),
+		strconv.FormatFloat(r.Cost, 'f', -1, 64), strconv.FormatInt(r.LatencyMS, 10), strconv.FormatBool(r.Image), r.Error,
+	}
+}
+
+// writeReport writes the report to the file, as CSV rows of the results for
+// a .csv file and as a JSON document otherwise
+func writeReport(path string, rep report) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating report: %w", err)
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		err = writeCSVReport(f, rep.Results)
+	} else {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(rep)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+func writeCSVReport(w io.Writer, rows []resultRow) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(reportHeader); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := cw.Write(row.csv()); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,43 @@
+package eval
+
+import (
+	"cmp"
+	"hash/fnv"
+	"slices"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// sample returns the share rate of the messages, then at most n of them, in
+// their order; zero rate and n keep all. A message is picked by the hash of
+// its chat and ID, so runs over the same messages sample the same ones and
+// their results can be compared.
+func sample(msgs []e.SavedMessage, rate float64, n int) []e.SavedMessage {
+	type hashed struct {
+		i    int
+		hash uint64
+	}
+
+	picked := make([]hashed, 0, len(msgs))
+	for i, msg := range msgs {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(msg.Sender.ChatID + ":" + msg.ID))
+		hash := h.Sum64()
+		if rate > 0 && float64(hash%10000) >= rate*10000 {
+			continue
+		}
+		picked = append(picked, hashed{i: i, hash: hash})
+	}
+
+	if n > 0 && len(picked) > n {
+		slices.SortFunc(picked, func(a, b hashed) int { return cmp.Compare(a.hash, b.hash) })
+		picked = picked[:n]
+		slices.SortFunc(picked, func(a, b hashed) int { return a.i - b.i })
+	}
+
+	sampled := make([]e.SavedMessage, len(picked))
+	for i, p := range picked {
+		sampled[i] = msgs[p.i]
+	}
+	return sampled
+}
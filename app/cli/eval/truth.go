@@ -0,0 +1,98 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// Sources of the expected label of a message, from the most trusted
+const (
+	labelSourceOverride    = "override"
+	labelSourceGroundTruth = "ground_truth"
+	labelSourceAction      = "action"
+)
+
+// expectation is the label a check of a message is expected to give
+type expectation struct {
+	label  e.Label
+	source string
+}
+
+// truth keeps the labels people gave to messages: the admins' overrides of
+// the bot's decisions and the imported ground truth. A nil truth knows none.
+type truth struct {
+	overrides map[string]e.Label // by request ID, the latest override
+
+	// Ground truth by request ID, and by the hash of the normalized text for
+	// examples imported without the IDs
+	byMessage map[string]e.Label
+	byText    map[string]e.Label
+}
+
+// loadTruth reads the overrides and the ground truth of the database
+func loadTruth(ctx context.Context, db storage.Store) (*truth, error) {
+	overrides, err := db.ListOverrides(ctx, storage.OverrideFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("listing overrides: %w", err)
+	}
+	examples, err := db.ListGroundTruth(ctx, storage.GroundTruthFilter{})
+	if err != nil {
+		return nil, fmt.Errorf("listing ground truth: %w", err)
+	}
+
+	t := &truth{
+		overrides: make(map[string]e.Label, len(overrides)),
+		byMessage: make(map[string]e.Label, len(examples)),
+		byText:    make(map[string]e.Label, len(examples)),
+	}
+	// Both come newest first, so the latest label of a message stays
+	for _, o := range overrides {
+		id := o.ChatID + "/" + o.MessageID
+		if _, ok := t.overrides[id]; !ok {
+			t.overrides[id] = o.Kind.Label()
+		}
+	}
+	for _, ex := range examples {
+		if ex.ChatID != "" && ex.MessageID != "" {
+			id := ex.ChatID + "/" + ex.MessageID
+			if _, ok := t.byMessage[id]; !ok {
+				t.byMessage[id] = ex.Label
+			}
+		}
+		if ex.Text != "" {
+			hash := textnorm.Hash(ex.Text)
+			if _, ok := t.byText[hash]; !ok {
+				t.byText[hash] = ex.Label
+			}
+		}
+	}
+	return t, nil
+}
+
+// expect returns the label the message is expected to get: an admin's, the
+// ground truth's or, without them, the one the bot's action implies. The
+// label is empty for a message with none of them.
+func (t *truth) expect(msg e.SavedMessage) expectation {
+	if t != nil {
+		id := requestID(msg)
+		if label, ok := t.overrides[id]; ok {
+			return expectation{label: label, source: labelSourceOverride}
+		}
+		if label, ok := t.byMessage[id]; ok {
+			return expectation{label: label, source: labelSourceGroundTruth}
+		}
+		if msg.Text != "" {
+			if label, ok := t.byText[textnorm.Hash(msg.Text)]; ok {
+				return expectation{label: label, source: labelSourceGroundTruth}
+			}
+		}
+	}
+	if msg.Action == nil {
+		return expectation{}
+	}
+	return expectation{label: e.LabelOf(*msg.Action), source: labelSourceAction}
+}
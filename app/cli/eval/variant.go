@@ -0,0 +1,203 @@
+package eval
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+)
+
+// embeddedPromptName names the prompt the eval command is built with
+const embeddedPromptName = "embedded"
+
+// variant is a prompt and a model the messages are checked with; a run
+// compares its variants on the same messages
+type variant struct {
+	name   string
+	prompt services.RegisteredPrompt
+
+	// model is the provider and the model, e.g. openai:gpt-5-nano, and
+	// modelID the model it prices the usage by
+	model   string
+	modelID string
+	batcher ai.Batcher
+
+	// verdicts counts the verdicts against the labels the messages are
+	// expected to get
+	verdicts tally
+}
+
+// key identifies the variant's results in a checkpoint across runs
+func (v *variant) key() string {
+	return v.prompt.Version + "/" + v.model
+}
+
+// tally counts the verdicts of a variant against the expected labels, and
+// what the checks took
+type tally struct {
+	processed     int
+	stayTheSame   int
+	becomeSpam    int
+	becomeNotSpam int
+
+	// images and imagesSame count the checks of messages with an image and
+	// those of them matching the expected labels
+	images     int
+	imagesSame int
+
+	latencies []time.Duration
+	cost      float64
+}
+
+// accuracy returns the share of the verdicts that match the expected labels
+func (t tally) accuracy() float64 {
+	if t.processed == 0 {
+		return 0
+	}
+	return float64(t.stayTheSame) / float64(t.processed)
+}
+
+// imageAccuracy returns the share of the verdicts on messages with an image
+// that match the expected labels
+func (t tally) imageAccuracy() float64 {
+	if t.images == 0 {
+		return 0
+	}
+	return float64(t.imagesSame) / float64(t.images)
+}
+
+// latency returns the mean and the 95th percentile of the latencies
+func (t tally) latency() (mean, p95 time.Duration) {
+	if len(t.latencies) == 0 {
+		return 0, 0
+	}
+	sorted := slices.Clone(t.latencies)
+	slices.Sort(sorted)
+	var sum time.Duration
+	for _, l := range sorted {
+		sum += l
+	}
+	return sum / time.Duration(len(sorted)), sorted[(len(sorted)-1)*95/100]
+}
+
+// setupVariants returns the variant of --prompt and the primary model, then
+// those differing from it in the prompt, --compare-prompt, or in the model,
+// --compare-model
+func setupVariants(registry *services.PromptRegistry, primary ai.Batcher) ([]*variant, error) {
+	base, err := loadPrompt(registry, opts.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	model := providerName(ai.ProviderOpenAI, opts.AIModel)
+	modelID := cmp.Or(opts.AIModel, ai.DefaultModel)
+	variants := []*variant{{name: base.Name + "/" + model, prompt: base, model: model, modelID: modelID, batcher: primary}}
+
+	if opts.ComparePrompt != "" {
+		p, err := loadPrompt(registry, opts.ComparePrompt)
+		if err != nil {
+			return nil, err
+		}
+		if p.Version == base.Version {
+			return nil, fmt.Errorf("the compared prompts are the same, version %s", p.Version)
+		}
+		name := p.Name + "/" + model
+		if p.Name == base.Name {
+			// Prompt files of the same name in different directories
+			variants[0].name, name = base.Version+"/"+model, p.Version+"/"+model
+		}
+		variants = append(variants, &variant{name: name, prompt: p, model: model, modelID: modelID, batcher: primary})
+	}
+
+	for i, spec := range opts.CompareModels {
+		name, modelName, _ := strings.Cut(strings.TrimSpace(spec), ":")
+		providerOpts := ai.ProviderOptions{
+			Name:        name,
+			APIKey:      opts.OpenAIKey,
+			Model:       modelName,
+			VisionModel: modelName,
+			Retry:       ai.DefaultRetryPolicy,
+			RateLimit:   ai.RateLimit{RequestsPerMinute: opts.AIRPM, TokensPerMinute: opts.AITPM},
+		}
+		if i < len(opts.CompareKeys) && opts.CompareKeys[i] != "" {
+			providerOpts.APIKey = opts.CompareKeys[i]
+		}
+		if i < len(opts.CompareBaseURLs) {
+			providerOpts.BaseURL = opts.CompareBaseURLs[i]
+		}
+
+		p, err := ai.NewProvider(providerOpts, http.DefaultClient)
+		if err != nil {
+			return nil, fmt.Errorf("compared model %q: %w", spec, err)
+		}
+		model := providerName(name, modelName)
+		variants = append(variants, &variant{
+			name:    variants[0].prompt.Name + "/" + model,
+			prompt:  variants[0].prompt,
+			model:   model,
+			modelID: cmp.Or(modelName, ai.DefaultModelOf(name)),
+			batcher: ai.Batcher{Provider: p, Concurrency: workers},
+		})
+	}
+
+	return variants, nil
+}
+
+// providerName names the provider and the model, like the bot's --ai-fallback
+func providerName(name, model string) string {
+	if name == "" {
+		name = ai.ProviderOpenAI
+	}
+	if model == "" {
+		return name
+	}
+	return name + ":" + model
+}
+
+// loadPrompt returns the prompt of the reference: the embedded one for an
+// empty reference, a prompt file, or a prompt of the registry by its name or
+// version
+func loadPrompt(registry *services.PromptRegistry, ref string) (services.RegisteredPrompt, error) {
+	if ref == "" {
+		return services.RegisteredPrompt{Name: embeddedPromptName, Version: ai.PromptVersion(prompt), Text: prompt}, nil
+	}
+
+	if info, err := os.Stat(ref); err == nil && !info.IsDir() {
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return services.RegisteredPrompt{}, fmt.Errorf("reading prompt: %w", err)
+		}
+		text := string(data)
+		if strings.TrimSpace(text) == "" {
+			return services.RegisteredPrompt{}, fmt.Errorf("prompt %s is empty", ref)
+		}
+		name := strings.TrimSuffix(filepath.Base(ref), filepath.Ext(ref))
+		return services.RegisteredPrompt{Name: name, Version: ai.PromptVersion(text), Text: text}, nil
+	}
+
+	p, ok := registry.Get(ref)
+	if !ok {
+		return services.RegisteredPrompt{}, fmt.Errorf("unknown prompt %q, neither a file nor in the registry", ref)
+	}
+	return p, nil
+}
+
+// agreement counts how often two variants reached the same verdict
+type agreement struct {
+	compared int
+	agreed   int
+}
+
+// rate returns the share of the verdicts the variants agreed on
+func (a agreement) rate() float64 {
+	if a.compared == 0 {
+		return 0
+	}
+	return float64(a.agreed) / float64(a.compared)
+}
@@ -0,0 +1,189 @@
+// Package export implements the export command, which writes decided
+// messages as labeled examples (text, media reference, action, category,
+// chat and the spam/ham label) in JSONL or CSV, for evaluating prompts, or
+// as OpenAI fine-tuning JSONL of the spam check's prompt, the messages and
+// the verdicts by their labels.
+package export
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/dataset"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	Output     string `long:"output" default:"-" description:"output file, - for stdout"`
+	Format     string `long:"format" default:"jsonl" choice:"jsonl" choice:"csv" choice:"openai" description:"output format, openai for fine-tuning jsonl"`
+	ChatID     string `long:"chat-id" description:"export messages of this chat only"`
+	From       string `long:"from" description:"export messages since this date (YYYY-MM-DD)"`
+	To         string `long:"to" description:"export messages before this date (YYYY-MM-DD)"`
+	PerLabel   int    `long:"per-label" description:"export at most this number of newest examples of each label, 0 exports all"`
+	Balance    bool   `long:"balance" description:"export as many ham examples as spam ones"`
+	Overridden bool   `long:"overridden" description:"export only messages whose decisions admins overrode"`
+	Dedup      bool   `long:"dedup" description:"export a message repeated with the same normalized text and media once, with its latest label"`
+	Prompt     string `long:"prompt" default:"builtin" description:"system prompt of --format=openai: a file, or a name or version of a prompt of --prompts-dir or builtin"`
+	PromptsDir string `long:"prompts-dir" description:"directory of .txt prompts referred to by name"`
+}
+
+// formatOpenAI is the --format of OpenAI fine-tuning JSONL
+const formatOpenAI = "openai"
+
+// Run runs the export command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	filter := storage.ExampleFilter{
+		ChatID:     opts.ChatID,
+		PerLabel:   opts.PerLabel,
+		Balance:    opts.Balance,
+		Overridden: opts.Overridden,
+	}
+	if filter.From, err = cli.ParseDate(opts.From); err != nil {
+		log.Error("parsing --from", "error", err)
+		os.Exit(1)
+	}
+	if filter.To, err = cli.ParseDate(opts.To); err != nil {
+		log.Error("parsing --to", "error", err)
+		os.Exit(1)
+	}
+
+	var system string
+	if opts.Format == formatOpenAI {
+		if system, err = loadPrompt(opts.Prompt, opts.PromptsDir); err != nil {
+			log.Error("loading prompt", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.OpenReadOnly(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	// Examples left out here are left out before the labels are limited and
+	// balanced, so those are done here too
+	selecting := opts.Dedup || opts.Format == formatOpenAI
+	if selecting {
+		filter.PerLabel, filter.Balance = 0, false
+	}
+
+	examples, err := db.ListExamples(ctx, filter)
+	if err != nil {
+		log.Error("listing examples", "error", err)
+		return
+	}
+
+	if opts.Dedup {
+		unique := dataset.Dedup(examples)
+		if skipped := len(examples) - len(unique); skipped > 0 {
+			log.Info("skipping repeated examples", "count", skipped)
+		}
+		examples = unique
+	}
+	if opts.Format == formatOpenAI {
+		withText := examples[:0]
+		for _, ex := range examples {
+			if ex.Text != "" {
+				withText = append(withText, ex)
+			}
+		}
+		if skipped := len(examples) - len(withText); skipped > 0 {
+			log.Info("skipping examples without text", "count", skipped)
+		}
+		examples = withText
+	}
+	if selecting {
+		examples = dataset.Limit(examples, opts.PerLabel, opts.Balance)
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.Output != "-" {
+		file, err := os.Create(opts.Output)
+		if err != nil {
+			log.Error("creating output file", "error", err)
+			return
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				log.Error("closing output file", "error", err)
+			}
+		}()
+		out = file
+	}
+
+	var w dataset.Writer
+	if opts.Format == formatOpenAI {
+		w = dataset.NewFineTuningWriter(out, system)
+	} else {
+		w, err = dataset.NewWriter(out, dataset.Format(opts.Format))
+		if err != nil {
+			log.Error("creating writer", "error", err)
+			return
+		}
+	}
+
+	counts := make(map[e.Label]int)
+	for _, ex := range examples {
+		if err = w.Write(ex); err != nil {
+			log.Error("writing example", "error", err)
+			return
+		}
+		counts[ex.Label]++
+	}
+	if err = w.Flush(); err != nil {
+		log.Error("writing examples", "error", err)
+		return
+	}
+
+	log.Info("examples exported", "spam", counts[e.LabelSpam], "ham", counts[e.LabelHam])
+}
+
+// loadPrompt returns the text of the prompt of the reference: a prompt file,
+// or a prompt of the registry by its name or version
+func loadPrompt(ref, dir string) (string, error) {
+	if info, err := os.Stat(ref); err == nil && !info.IsDir() {
+		data, err := os.ReadFile(ref)
+		if err != nil {
+			return "", fmt.Errorf("reading prompt: %w", err)
+		}
+		if strings.TrimSpace(string(data)) == "" {
+			return "", fmt.Errorf("prompt %s is empty", ref)
+		}
+		return string(data), nil
+	}
+
+	registry := services.NewPromptRegistry()
+	if dir != "" {
+		if err := registry.LoadDir(dir); err != nil {
+			return "", err
+		}
+	}
+	p, ok := registry.Get(ref)
+	if !ok {
+		return "", fmt.Errorf("unknown prompt %q, neither a file nor in the registry", ref)
+	}
+	return p.Text, nil
+}
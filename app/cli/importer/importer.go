@@ -0,0 +1,98 @@
+// Package importer implements the import command, which adds externally
+// labeled examples (from another bot, manual labeling or the export command)
+// to the ground truth used for evaluation and few-shot selection. Examples are
+// deduplicated by their normalized text.
+package importer
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/dataset"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	Input  string `long:"input" required:"true" description:"dataset file, - for stdin"`
+	Format string `long:"format" choice:"jsonl" choice:"csv" description:"input format, by the file extension if not set"`
+	Source string `long:"source" description:"name of the dataset source, the file name if not set"`
+}
+
+// Run runs the import command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	format := dataset.Format(opts.Format)
+	if format == "" {
+		format = dataset.Format(strings.TrimPrefix(filepath.Ext(opts.Input), "."))
+	}
+
+	source := opts.Source
+	if source == "" {
+		source = filepath.Base(opts.Input)
+	}
+
+	var in io.Reader = os.Stdin
+	if opts.Input != "-" {
+		file, err := os.Open(opts.Input)
+		if err != nil {
+			log.Error("opening input file", "error", err)
+			os.Exit(1)
+		}
+		defer func() { _ = file.Close() }()
+		in = file
+	}
+
+	r, err := dataset.NewReader(in, format)
+	if err != nil {
+		log.Error("creating reader, set --format", "error", err)
+		os.Exit(1)
+	}
+
+	var examples []e.Example
+	for {
+		ex, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Error("reading examples", "error", err)
+			os.Exit(1)
+		}
+		examples = append(examples, ex)
+	}
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.Open(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	result, err := db.ImportGroundTruth(ctx, source, examples)
+	if err != nil {
+		log.Error("importing examples", "error", err)
+		return
+	}
+
+	log.Info("examples imported", "source", source, "imported", result.Imported, "duplicates", result.Duplicates, "skipped", result.Skipped)
+}
@@ -0,0 +1,151 @@
+// Package label implements the label command, which walks a person through
+// stored messages nobody has labeled yet, showing each with its chat, sender
+// and the bot's verdict, and adds the spam or ham labels given to the ground
+// truth, with a spam category if one is picked.
+package label
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	ChatID string `long:"chat-id" description:"label messages of this chat only"`
+	Action string `long:"action" description:"label messages the bot took this action on, e.g. noop"`
+	Days   int    `long:"days" default:"30" description:"label messages of this number of last days, 0 labels all"`
+	Source string `long:"source" default:"manual" description:"name of the ground truth source the labels are added as"`
+}
+
+// Run runs the label command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.Open(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	filter := storage.MessageFilter{ChatID: opts.ChatID, Action: e.ActionKind(opts.Action)}
+	if opts.Days > 0 {
+		filter.From = time.Now().AddDate(0, 0, -opts.Days)
+	}
+	queue, err := unlabeled(ctx, db, filter)
+	if err != nil {
+		log.Error("listing unlabeled messages", "error", err)
+		os.Exit(1)
+	}
+	if len(queue) == 0 {
+		log.Info("no unlabeled messages")
+		return
+	}
+
+	chats, err := db.ListChats(ctx)
+	if err != nil {
+		log.Error("listing chats", "error", err)
+		os.Exit(1)
+	}
+	titles := make(map[string]string, len(chats))
+	for _, chat := range chats {
+		titles[chat.ID] = chat.Title
+	}
+
+	s := newSession(os.Stdin, os.Stdout, titles, func(ex e.Example) (bool, error) {
+		result, err := db.ImportGroundTruth(ctx, opts.Source, []e.Example{ex})
+		return result.Imported > 0, err
+	})
+	if err = s.run(ctx, queue); err != nil {
+		log.Error("labeling messages", "error", err)
+	}
+
+	log.Info("labeling done", "source", opts.Source,
+		"spam", s.counts[e.LabelSpam], "ham", s.counts[e.LabelHam], "skipped", s.skipped, "left", len(queue)-s.seen)
+}
+
+// unlabeled returns the messages of the filter, oldest first, that have a
+// text or media, have no admin override and no ground truth of their text
+// or media. Messages repeating the text or media of another one are left
+// out, as labeling one labels them all.
+func unlabeled(ctx context.Context, db storage.Store, filter storage.MessageFilter) ([]e.SavedMessage, error) {
+	messages, err := db.ListMessages(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	truths, err := db.ListGroundTruth(ctx, storage.GroundTruthFilter{})
+	if err != nil {
+		return nil, err
+	}
+	labeled := make(map[string]struct{}, len(truths))
+	for _, gt := range truths {
+		if key, ok := storage.GroundTruthKey(gt.Example); ok {
+			labeled[key] = struct{}{}
+		}
+	}
+
+	overrides, err := db.ListOverrides(ctx, storage.OverrideFilter{ChatID: filter.ChatID})
+	if err != nil {
+		return nil, err
+	}
+	overridden := make(map[string]struct{}, len(overrides))
+	for _, o := range overrides {
+		overridden[o.ChatID+"/"+o.MessageID] = struct{}{}
+	}
+
+	var queue []e.SavedMessage
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg := messages[i]
+		if _, ok := overridden[msg.Sender.ChatID+"/"+msg.ID]; ok {
+			continue
+		}
+		key, ok := storage.GroundTruthKey(example(msg))
+		if !ok {
+			continue
+		}
+		if _, ok = labeled[key]; ok {
+			continue
+		}
+		labeled[key] = struct{}{}
+		queue = append(queue, msg)
+	}
+	return queue, nil
+}
+
+// example returns the message as a ground truth example, without a label
+func example(msg e.SavedMessage) e.Example {
+	ex := e.Example{
+		ChatID:      msg.Sender.ChatID,
+		MessageID:   msg.ID,
+		Text:        msg.Text,
+		MediaType:   msg.MediaType,
+		MediaFileID: msg.MediaFileID,
+		CreatedAt:   msg.CreatedAt,
+	}
+	if msg.Action != nil {
+		ex.Action = *msg.Action
+	}
+	if msg.Category != nil {
+		ex.Category = *msg.Category
+	}
+	return ex
+}
@@ -0,0 +1,187 @@
+package label
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// categories are the spam categories a label can be tagged with, numbered
+// from 1 in the prompt
+var categories = []e.SpamCategory{
+	e.SpamCategoryCryptoScam, e.SpamCategoryJobScam, e.SpamCategoryAdult, e.SpamCategoryGambling,
+	e.SpamCategoryPhishing, e.SpamCategoryAds, e.SpamCategoryFlood, e.SpamCategoryOther,
+}
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\033[H\033[2J"
+
+// session shows messages one by one and reads the labels typed for them
+type session struct {
+	in     <-chan string
+	out    io.Writer
+	titles map[string]string
+
+	// save adds a labeled example to the ground truth, added is false if
+	// its text or media was labeled meanwhile
+	save func(ex e.Example) (added bool, err error)
+
+	counts  map[e.Label]int
+	skipped int
+	seen    int
+}
+
+func newSession(in io.Reader, out io.Writer, titles map[string]string, save func(e.Example) (bool, error)) *session {
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	return &session{in: lines, out: out, titles: titles, save: save, counts: make(map[e.Label]int)}
+}
+
+// run asks for the labels of the messages until all are labeled or skipped,
+// the input ends, q is typed or the context is done
+func (s *session) run(ctx context.Context, queue []e.SavedMessage) error {
+	var status string
+	for i, msg := range queue {
+		s.show(i, len(queue), msg, status)
+
+		for {
+			fmt.Fprint(s.out, "> ")
+			var line string
+			select {
+			case <-ctx.Done():
+				fmt.Fprintln(s.out)
+				return nil
+			case l, ok := <-s.in:
+				if !ok {
+					fmt.Fprintln(s.out)
+					return nil
+				}
+				line = l
+			}
+
+			cmd, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+			cmd = strings.ToLower(cmd)
+			switch cmd {
+			case "q":
+				return nil
+			case "k":
+				s.skipped++
+				status = "skipped"
+			case "h", "s":
+				label, category := e.LabelHam, e.SpamCategory("")
+				if cmd == "s" {
+					label = e.LabelSpam
+					var ok bool
+					if category, ok = pickCategory(msg, arg); !ok {
+						fmt.Fprintf(s.out, "unknown category %q\n", arg)
+						continue
+					}
+				}
+
+				ex := example(msg)
+				ex.Label, ex.Category = label, category
+				added, err := s.save(ex)
+				if err != nil {
+					return err
+				}
+				status = "labeled " + string(label)
+				if !added {
+					status += ", the text was already labeled"
+				} else {
+					s.counts[label]++
+				}
+			default:
+				fmt.Fprintln(s.out, help())
+				continue
+			}
+			break
+		}
+		s.seen++
+	}
+	return nil
+}
+
+// show clears the screen and prints the message with the status of the
+// previous one
+func (s *session) show(i, total int, msg e.SavedMessage, status string) {
+	var b strings.Builder
+	b.WriteString(clearScreen)
+	if status != "" {
+		fmt.Fprintf(&b, "previous: %s\n\n", status)
+	}
+
+	chat := msg.Sender.ChatID
+	if title := s.titles[chat]; title != "" {
+		chat = title + " (" + chat + ")"
+	}
+	fmt.Fprintf(&b, "[%d/%d] %s, %s\n", i+1, total, chat, msg.CreatedAt.Local().Format(time.DateTime))
+	fmt.Fprintf(&b, "from: %s (%s)\n", msg.Sender.Name, msg.Sender.ID)
+
+	verdict := "none"
+	if msg.Action != nil {
+		verdict = string(*msg.Action)
+		if msg.Category != nil && *msg.Category != "" {
+			verdict += ", " + string(*msg.Category)
+		}
+		if msg.ActionNote != nil && *msg.ActionNote != "" {
+			verdict += ": " + *msg.ActionNote
+		}
+	}
+	fmt.Fprintf(&b, "bot: %s\n", verdict)
+	if msg.MediaType != nil {
+		fmt.Fprintf(&b, "media: %s\n", *msg.MediaType)
+	}
+
+	text := msg.Text
+	if text == "" {
+		text = "(no text)"
+	}
+	const rule = "────────────────────────────────────────"
+	fmt.Fprintf(&b, "%s\n%s\n%s\n%s\n", rule, text, rule, help())
+	fmt.Fprint(s.out, b.String())
+}
+
+// help returns the keys of the commands
+func help() string {
+	names := make([]string, len(categories))
+	for i, c := range categories {
+		names[i] = strconv.Itoa(i+1) + " " + string(c)
+	}
+	return "s [category] spam, h ham, k skip, q quit; categories: " + strings.Join(names, ", ")
+}
+
+// pickCategory returns the category typed by its number or name, the bot's
+// category of the message if none is typed
+func pickCategory(msg e.SavedMessage, arg string) (e.SpamCategory, bool) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		if msg.Category != nil {
+			return *msg.Category, true
+		}
+		return "", true
+	}
+	if n, err := strconv.Atoi(arg); err == nil {
+		if n < 1 || n > len(categories) {
+			return "", false
+		}
+		return categories[n-1], true
+	}
+	for _, c := range categories {
+		if string(c) == arg {
+			return c, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,96 @@
+// Package migrate implements the migrate command, which manages the database
+// schema: it applies pending migrations, reverts applied ones or shows which
+// are applied. The bot applies pending migrations on start unless run with
+// --skip-migrations, this command allows doing it ahead of a deploy or
+// rolling a schema change back.
+package migrate
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	Steps int  `long:"steps" default:"1" description:"number of migrations to revert with down"`
+	Check bool `long:"check" description:"exit with status 3 from status if migrations are pending, e.g. to gate a deploy"`
+
+	Args struct {
+		Command string `positional-arg-name:"command" choice:"up" choice:"down" choice:"status" required:"true"`
+	} `positional-args:"true"`
+}
+
+// Run runs the migrate command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.OpenUnmigrated(opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	before, err := db.MigrationStatuses(ctx)
+	if err != nil {
+		log.Error("getting migration statuses", "error", err)
+		os.Exit(1)
+	}
+
+	switch opts.Args.Command {
+	case "up":
+		err = db.MigrateUp(ctx)
+	case "down":
+		err = db.MigrateDown(ctx, opts.Steps)
+	}
+	if err != nil {
+		log.Error("migrating", "command", opts.Args.Command, "error", err)
+		os.Exit(1)
+	}
+
+	statuses, err := db.MigrationStatuses(ctx)
+	if err != nil {
+		log.Error("getting migration statuses", "error", err)
+		os.Exit(1)
+	}
+
+	for _, s := range statuses {
+		applied := "pending"
+		if !s.AppliedAt.IsZero() {
+			applied = "applied " + s.AppliedAt.Format(time.DateTime)
+		}
+		fmt.Printf("%04d %-32s %s\n", s.Version, s.Name, applied)
+	}
+
+	pending := len(storage.PendingMigrations(statuses))
+	switch opts.Args.Command {
+	case "up":
+		log.Info("migrated up", "applied", len(storage.PendingMigrations(before))-pending)
+	case "down":
+		log.Info("migrated down", "reverted", pending-len(storage.PendingMigrations(before)))
+	case "status":
+		if pending > 0 {
+			log.Warn("migrations pending, apply them with up", "pending", pending)
+			if opts.Check {
+				os.Exit(3)
+			}
+		}
+	}
+}
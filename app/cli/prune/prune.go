@@ -0,0 +1,99 @@
+// Package prune implements the prune command, which enforces a message
+// retention policy once: it erases bodies of old messages and deletes
+// messages beyond a per-chat limit, keeping their statistics, and deletes
+// old archived raw updates. It then vacuums the database and prints the
+// space reclaimed. The bot does the same daily when retention is configured.
+package prune
+
+import (
+	"fmt"
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	Days    int `long:"days" env:"RETENTION_DAYS" description:"erase message texts and media references older than this number of days, 0 keeps them"`
+	MaxRows int `long:"max-rows" env:"RETENTION_MAX_ROWS" description:"keep at most this number of messages per chat, 0 keeps all"`
+	RawDays int `long:"raw-days" env:"ARCHIVE_DAYS" description:"delete archived raw updates older than this number of days, 0 keeps them"`
+
+	SkipVacuum bool `long:"skip-vacuum" description:"don't rebuild the database afterwards, keeping the space of the pruned data for new data"`
+}
+
+// Run runs the prune command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	policy := e.RetentionPolicy{Days: opts.Days, MaxRowsPerChat: opts.MaxRows, RawUpdateDays: opts.RawDays}
+	if policy.IsZero() && opts.SkipVacuum {
+		log.Error("nothing to do: set --days, --max-rows and/or --raw-days, or drop --skip-vacuum")
+		os.Exit(1)
+	}
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.Open(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	if !policy.IsZero() {
+		result, err := db.Prune(ctx, policy)
+		if err != nil {
+			log.Error("pruning messages", "error", err)
+			os.Exit(1)
+		}
+
+		log.Info(
+			"messages pruned",
+			"bodies_erased", result.BodiesErased,
+			"rows_deleted", result.RowsDeleted,
+			"raw_updates_deleted", result.RawUpdatesDeleted,
+			"verdicts_deleted", result.VerdictsDeleted,
+		)
+	}
+
+	if opts.SkipVacuum {
+		return
+	}
+
+	vacuum, err := db.Vacuum(ctx)
+	if err != nil {
+		log.Error("vacuuming database", "error", err)
+		os.Exit(1)
+	}
+	fmt.Printf("database %s -> %s, %s reclaimed\n",
+		formatBytes(vacuum.SizeBefore), formatBytes(vacuum.SizeAfter), formatBytes(vacuum.Reclaimed()))
+}
+
+// formatBytes returns the size in bytes, KiB, MiB or GiB
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	size := float64(n) / unit
+	for _, suffix := range []string{"KiB", "MiB"} {
+		if size < unit {
+			return fmt.Sprintf("%.1f %s", size, suffix)
+		}
+		size /= unit
+	}
+	return fmt.Sprintf("%.1f GiB", size)
+}
@@ -0,0 +1,407 @@
+// Package replay implements the replay command, which checks archived raw
+// updates or stored messages again with the bot's current configuration and
+// reports the messages it would now decide otherwise. It is a dry run:
+// nothing is sent to Telegram, and whatever it stores goes to a copy of the
+// database removed afterwards.
+package replay
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/cli/bot"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/app/telegram"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+// Options are the bot's options of how messages are checked and the ones of
+// what is replayed
+type Options struct {
+	cli.ConfigOptions
+	TelegramAPIToken string `long:"telegram-api-token" env:"TELEGRAM_API_TOKEN" description:"telegram api token to download media of the replayed messages with, media isn't checked without it"`
+	bot.ModeratorOptions
+
+	Source string `long:"source" default:"updates" choice:"updates" choice:"messages" description:"what is replayed: archived raw updates or stored messages"`
+	ChatID string `long:"chat-id" description:"replay messages of this chat only"`
+	From   string `long:"from" description:"replay messages since this date (YYYY-MM-DD), empty replays the last --days"`
+	To     string `long:"to" description:"replay messages before this date (YYYY-MM-DD)"`
+	Days   int    `long:"days" default:"7" description:"number of days up to now replayed without --from, 0 replays all"`
+	Output string `long:"output" default:"-" description:"file the report is written to, - for stdout"`
+}
+
+var opts Options
+
+// Run runs the replay command, args being its name and options as in os.Args
+func Run(args []string) {
+	// The bot's config file is taken as it is, with its options of running
+	// the bot left alone
+	ignored := append(cli.MissingOptions(&opts, &bot.Options{}), bot.ConfigSettings)
+	config, err := cli.ParseWithConfig(&opts, args, ignored...)
+	if err != nil {
+		os.Exit(1)
+	}
+	if err = opts.Validate(); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	if err = replayTraffic(ctx, config, log); err != nil {
+		log.Error("replaying messages", "error", err)
+		os.Exit(1)
+	}
+}
+
+// replayTraffic replays the messages selected by the options in a scratch
+// copy of the database and writes the report
+func replayTraffic(ctx context.Context, config cli.Config, log logger.Logger) (err error) {
+	filter := replayFilter{ChatID: opts.ChatID}
+	if filter.From, err = cli.ParseDate(opts.From); err != nil {
+		return fmt.Errorf("parsing --from: %w", err)
+	}
+	if filter.To, err = cli.ParseDate(opts.To); err != nil {
+		return fmt.Errorf("parsing --to: %w", err)
+	}
+	if filter.From.IsZero() && opts.Days > 0 {
+		filter.From = time.Now().AddDate(0, 0, -opts.Days)
+	}
+
+	db, err := opts.Open(ctx)
+	if err != nil {
+		return fmt.Errorf("opening database: %w", err)
+	}
+	// Whatever the replay stores goes to a copy thrown away after it
+	scratch, drop, err := openScratchCopy(ctx, db, opts.DBPath)
+	if err != nil {
+		_ = db.Close()
+		return fmt.Errorf("copying database: %w", err)
+	}
+	defer drop()
+	db = storage.NewInstrumented(scratch, metrics.Default, log, opts.SlowQueryThreshold)
+
+	moderator, err := bot.NewModerator(ctx, &opts.ModeratorOptions, config, db, db, log)
+	if err != nil {
+		return fmt.Errorf("creating moderator: %w", err)
+	}
+	srv := moderator.Srv
+	// Verdicts cached by the checks replayed would be reused as they are
+	srv.VerdictTTL = 0
+	if opts.TelegramAPIToken != "" {
+		srv.MediaDownloader = tg.NewClient(opts.TelegramAPIToken, nil)
+	}
+
+	bufferDone := make(chan struct{})
+	bufferCtx, stopBuffer := context.WithCancel(ctx)
+	go func() {
+		if moderator.Buffer != nil {
+			moderator.Buffer.Run(bufferCtx)
+		}
+		close(bufferDone)
+	}()
+	defer func() {
+		stopBuffer()
+		<-bufferDone
+	}()
+
+	items, err := loadReplay(ctx, db, opts.Source, filter)
+	if err != nil {
+		return err
+	}
+	withMedia := srv.MediaDownloader != nil
+	if !withMedia {
+		log.Warn("replaying without media, give --telegram-api-token to check it")
+	}
+	log.Info("replaying messages", "source", opts.Source, "messages", len(items))
+
+	results, skipped, err := runReplay(ctx, srv, srv.ScoreStore, items, withMedia)
+	if err != nil {
+		// The report covers the messages replayed so far
+		log.Error("replay stopped", "error", err)
+	}
+
+	var out io.Writer = os.Stdout
+	if opts.Output != "-" {
+		var file *os.File
+		if file, err = os.Create(opts.Output); err != nil {
+			return fmt.Errorf("creating report: %w", err)
+		}
+		defer func() {
+			if closeErr := file.Close(); err == nil && closeErr != nil {
+				err = fmt.Errorf("closing report: %w", closeErr)
+			}
+		}()
+		out = file
+	}
+	if err = writeReplayReport(out, results, skipped); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	return nil
+}
+
+const (
+	replaySourceUpdates  = "updates"
+	replaySourceMessages = "messages"
+)
+
+// Verdicts of the report besides the action kinds
+const (
+	verdictNone  = "none"
+	verdictError = "error"
+)
+
+// replayItem is a message to moderate again with the decision actually
+// taken on it, nil if none was stored
+type replayItem struct {
+	msg      e.Message
+	at       time.Time
+	original *e.SavedMessage
+}
+
+// replayResult is the decision on a replayed message next to the original
+type replayResult struct {
+	item  replayItem
+	was   string
+	now   string
+	stage e.DecisionStage
+	note  string
+}
+
+func (r replayResult) changed() bool {
+	return r.was != r.now
+}
+
+// messageHandler moderates a message
+type messageHandler interface {
+	HandleMessage(ctx context.Context, msg e.Message) (e.Action, error)
+}
+
+// scoreSetter puts senders back at the score they had when a message came
+type scoreSetter interface {
+	SetScore(ctx context.Context, sender e.User, score int) error
+}
+
+// openScratchCopy copies the database to a temporary file, closes it and
+// opens the copy, so decisions of the replay never reach the bot's database.
+// drop closes the copy and removes it.
+func openScratchCopy(ctx context.Context, db storage.Store, dsn string) (_ storage.Store, drop func(), err error) {
+	dir, err := os.MkdirTemp("", "antispam-replay-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating scratch directory: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	path := filepath.Join(dir, "replay.sqlite")
+	if err = db.Backup(ctx, path); err != nil {
+		return nil, nil, fmt.Errorf("copying database: %w", err)
+	}
+	if err = db.Close(); err != nil {
+		return nil, nil, fmt.Errorf("closing database: %w", err)
+	}
+
+	scratch, err := storage.Open(ctx, scratchDSN(dsn, path))
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening database copy: %w", err)
+	}
+	return scratch, func() {
+		_ = scratch.Close()
+		_ = os.RemoveAll(dir)
+	}, nil
+}
+
+// scratchDSN returns the DSN of the copy of the database at path, with the
+// key file of the original, as a copy of an encrypted database is encrypted
+func scratchDSN(dsn, path string) string {
+	_, query, ok := strings.Cut(dsn, "?")
+	if !ok {
+		return path
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil || params.Get("key_file") == "" {
+		return path
+	}
+	return "sqlite://" + path + "?" + url.Values{"key_file": {params.Get("key_file")}}.Encode()
+}
+
+// replayFilter selects the traffic replayed
+type replayFilter struct {
+	ChatID string
+	From   time.Time
+	To     time.Time
+}
+
+// loadReplay returns the archived raw updates or the stored messages of the
+// filter in the order they came, each with the decision stored for it
+func loadReplay(ctx context.Context, db storage.Store, source string, filter replayFilter) ([]replayItem, error) {
+	messages, err := db.ListMessages(ctx, storage.MessageFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To})
+	if err != nil {
+		return nil, fmt.Errorf("listing messages: %w", err)
+	}
+	slices.Reverse(messages)
+
+	if source == replaySourceMessages {
+		items := make([]replayItem, 0, len(messages))
+		for i := range messages {
+			items = append(items, replayItem{msg: toMessage(messages[i]), at: messages[i].CreatedAt, original: &messages[i]})
+		}
+		return items, nil
+	}
+
+	stored := make(map[string]*e.SavedMessage, len(messages))
+	for i := range messages {
+		stored[messages[i].Sender.ChatID+"/"+messages[i].ID] = &messages[i]
+	}
+
+	updates, err := db.ListRawUpdates(ctx, storage.RawUpdateFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To})
+	if err != nil {
+		return nil, fmt.Errorf("listing raw updates: %w", err)
+	}
+
+	items := make([]replayItem, 0, len(updates))
+	for _, u := range updates {
+		var update tg.Update
+		if err = json.Unmarshal(u.Payload, &update); err != nil {
+			return nil, fmt.Errorf("decoding update %d: %w", u.UpdateID, err)
+		}
+		msg, ok := telegram.MessageFromUpdate(update)
+		if !ok {
+			continue
+		}
+		items = append(items, replayItem{msg: msg, at: u.CreatedAt, original: stored[msg.Sender.ChatID+"/"+msg.ID]})
+	}
+	return items, nil
+}
+
+// toMessage returns the stored message as the bot checks it. Links hidden
+// behind the text and whether it was forwarded aren't stored.
+func toMessage(msg e.SavedMessage) e.Message {
+	return e.Message{
+		Sender:      msg.Sender,
+		ID:          msg.ID,
+		Text:        msg.Text,
+		MediaType:   msg.MediaType,
+		MediaFileID: msg.MediaFileID,
+		MediaSize:   msg.MediaSize,
+	}
+}
+
+// runReplay moderates the messages again in the order they came. Each
+// sender is put back at the score stored with the original decision, so
+// decisions differ by the configuration rather than by the trust earned
+// since. Without media, media is left out of the messages with text and
+// media-only messages are skipped.
+func runReplay(ctx context.Context, handler messageHandler, scores scoreSetter, items []replayItem, withMedia bool) (results []replayResult, skipped int, err error) {
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return results, skipped, ctx.Err()
+		}
+
+		msg := item.msg
+		if !withMedia && msg.HasMedia() {
+			if !msg.HasText() {
+				skipped++
+				continue
+			}
+			msg.MediaType, msg.MediaFileID, msg.MediaSize = nil, nil, nil
+		}
+
+		r := replayResult{item: item, was: verdictNone}
+		if o := item.original; o != nil {
+			switch {
+			case o.Action != nil:
+				r.was = string(*o.Action)
+			case o.Error != nil:
+				r.was = verdictError
+			}
+			if o.Trace != nil {
+				if err = scores.SetScore(ctx, msg.Sender, o.Trace.ScoreBefore); err != nil {
+					return results, skipped, fmt.Errorf("restoring score of user %s: %w", msg.Sender.ID, err)
+				}
+			}
+		}
+
+		action, err := handler.HandleMessage(ctx, msg)
+		if err != nil {
+			r.now, r.note = verdictError, err.Error()
+		} else {
+			r.now, r.stage, r.note = string(action.Kind), action.Trace.Stage, action.Note
+		}
+		results = append(results, r)
+	}
+	return results, skipped, nil
+}
+
+// writeReplayReport writes the messages decided otherwise than originally,
+// then how many were replayed and changed, by the change
+func writeReplayReport(w io.Writer, results []replayResult, skipped int) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	type change struct{ was, now string }
+	changes := make(map[change]int)
+	for _, r := range results {
+		if !r.changed() {
+			continue
+		}
+		if len(changes) == 0 {
+			fmt.Fprintln(tw, "time\tchat\tmessage\tuser\twas\tnow\tstage\tnote\ttext")
+		}
+		changes[change{r.was, r.now}]++
+
+		msg := r.item.msg
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			r.item.at.Local().Format(time.DateTime), msg.Sender.ChatID, msg.ID, msg.Sender.ID,
+			r.was, r.now, r.stage, oneLine(r.note, 60), oneLine(msg.Text, 60))
+	}
+	if len(changes) > 0 {
+		fmt.Fprintln(tw)
+	}
+
+	changed := 0
+	for _, n := range changes {
+		changed += n
+	}
+	fmt.Fprintf(tw, "replayed %d, changed %d, skipped %d\n", len(results), changed, skipped)
+
+	keys := make([]change, 0, len(changes))
+	for c := range changes {
+		keys = append(keys, c)
+	}
+	slices.SortFunc(keys, func(a, b change) int {
+		return cmp.Or(cmp.Compare(changes[b], changes[a]), cmp.Compare(a.was, b.was), cmp.Compare(a.now, b.now))
+	})
+	for _, c := range keys {
+		fmt.Fprintf(tw, "%s -> %s\t%d\n", c.was, c.now, changes[c])
+	}
+
+	return tw.Flush()
+}
+
+// oneLine returns the text on one line, cut to n runes
+func oneLine(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > n {
+		return string(runes[:n-1]) + "…"
+	}
+	return text
+}
@@ -0,0 +1,159 @@
+// Package seed implements the seed command, which backfills trust for
+// members of an established group from a chat history export (Telegram
+// Desktop: Export chat history -> JSON). Users who posted at least --min-
+// messages messages in the last --days days are marked as trusted, so the
+// bot doesn't check them for spam after install.
+package seed
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/services"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	ExportPath   string `long:"export" required:"true" description:"path to the result.json of a telegram desktop chat export"`
+	MinMessages  int    `long:"min-messages" default:"5" description:"minimal number of messages for a user to be trusted"`
+	DaysBack     int    `long:"days" default:"90" description:"only count messages from the last number of days"`
+	TrustedScore int    `long:"trusted-score" default:"6" description:"score of a trusted user, must match the bot"`
+	DryRun       bool   `long:"dry-run" description:"only print users who would be trusted"`
+}
+
+// chatExport is the subset of the Telegram Desktop export format we need
+type chatExport struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	ID       int64  `json:"id"`
+	Messages []struct {
+		Type         string `json:"type"`
+		DateUnixtime string `json:"date_unixtime"`
+		From         string `json:"from"`
+		FromID       string `json:"from_id"`
+	} `json:"messages"`
+}
+
+// Run runs the seed command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	data, err := os.ReadFile(opts.ExportPath)
+	if err != nil {
+		log.Error("reading export", "error", err)
+		os.Exit(1)
+	}
+
+	var export chatExport
+	if err = json.Unmarshal(data, &export); err != nil {
+		log.Error("decoding export", "error", err)
+		os.Exit(1)
+	}
+
+	chatID, err := botAPIChatID(export.Type, export.ID)
+	if err != nil {
+		log.Error("resolving chat id", "error", err)
+		os.Exit(1)
+	}
+
+	log.Info("export loaded", "chat", export.Name, "chat_id", chatID, "messages", len(export.Messages))
+
+	since := time.Now().Add(time.Hour * 24 * time.Duration(opts.DaysBack) * -1)
+	counts := make(map[string]int)
+	names := make(map[string]string)
+
+	for _, msg := range export.Messages {
+		// Channels post as "channel123", only users can be trusted
+		userID, ok := strings.CutPrefix(msg.FromID, "user")
+		if msg.Type != "message" || !ok {
+			continue
+		}
+
+		unix, err := strconv.ParseInt(msg.DateUnixtime, 10, 64)
+		if err != nil || time.Unix(unix, 0).Before(since) {
+			continue
+		}
+
+		counts[userID]++
+		names[userID] = msg.From
+	}
+
+	var users []e.User
+	for userID, count := range counts {
+		if count < opts.MinMessages {
+			continue
+		}
+
+		user := e.User{
+			ID:        userID,
+			Name:      names[userID],
+			ChatID:    chatID,
+			ChatTitle: export.Name,
+		}
+		if user.Name == "" {
+			user.Name = userID
+		}
+
+		users = append(users, user)
+		log.Info("trusted user", "user_id", user.ID, "name", user.Name, "messages", count)
+	}
+
+	log.Info("users to trust", "count", len(users), "active", len(counts))
+
+	if opts.DryRun || len(users) == 0 {
+		os.Exit(0)
+	}
+
+	db, err := storage.Open(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	seeder := &services.ModeratingSrv{
+		TrustedScore: opts.TrustedScore,
+		ScoreStore:   db,
+		Audit:        db,
+	}
+
+	if err = seeder.SeedTrusted(ctx, users); err != nil {
+		log.Error("seeding trusted users", "error", err)
+		return
+	}
+
+	log.Info("done", "trusted", len(users))
+}
+
+// botAPIChatID converts a chat ID from an export into the Bot API form:
+// supergroups and channels get the -100 prefix, basic groups are negated.
+func botAPIChatID(chatType string, id int64) (string, error) {
+	switch chatType {
+	case "public_supergroup", "private_supergroup", "public_channel", "private_channel":
+		return "-100" + strconv.FormatInt(id, 10), nil
+	case "private_group":
+		return strconv.FormatInt(-id, 10), nil
+	default:
+		return "", fmt.Errorf("unsupported chat type %q", chatType)
+	}
+}
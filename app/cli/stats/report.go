@@ -0,0 +1,176 @@
+package stats
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// counters are the moderation counters of a chat, a day or the whole period
+type counters struct {
+	Checked    int `json:"checked"`
+	Erased     int `json:"erased"`
+	Banned     int `json:"banned"`
+	Muted      int `json:"muted"`
+	Errors     int `json:"errors"`
+	Overridden int `json:"overridden"`
+
+	AIRequests       int     `json:"ai_requests"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost_usd"`
+}
+
+// add adds the counters of a chat on a day
+func (c *counters) add(s e.ChatDayStats) {
+	c.Checked += s.Checked
+	c.Erased += s.Erased
+	c.Banned += s.Banned
+	c.Muted += s.Muted
+	c.Errors += s.Errors
+	c.Overridden += s.Overridden
+	c.AIRequests += s.Spend.Requests
+	c.PromptTokens += s.Spend.PromptTokens
+	c.CompletionTokens += s.Spend.CompletionTokens
+	c.Cost += s.Spend.Cost
+}
+
+// removed returns the number of messages removed by any action
+func (c counters) removed() int {
+	return c.Erased + c.Banned + c.Muted
+}
+
+// chatCounters are the counters of a chat over the period
+type chatCounters struct {
+	ChatID string `json:"chat_id"`
+	Title  string `json:"title,omitempty"`
+	counters
+}
+
+// dayCounters are the counters of all the chats on a day
+type dayCounters struct {
+	Day string `json:"day"`
+	counters
+}
+
+// chatDayCounters are the counters of a chat on a day
+type chatDayCounters struct {
+	ChatID string `json:"chat_id"`
+	Day    string `json:"day"`
+	counters
+}
+
+// report is the statistics of the period
+type report struct {
+	ChatID string     `json:"chat_id,omitempty"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+
+	Total    counters          `json:"total"`
+	Chats    []chatCounters    `json:"chats"`
+	Days     []dayCounters     `json:"days"`
+	ChatDays []chatDayCounters `json:"chat_days"`
+}
+
+// newReport sums the counters of the chats and days by chat and by day,
+// chats ordered by the messages checked in them, most first
+func newReport(filter storage.StatsFilter, rows []e.ChatDayStats, titles map[string]string) report {
+	rep := report{
+		ChatID:   filter.ChatID,
+		Chats:    []chatCounters{},
+		Days:     []dayCounters{},
+		ChatDays: []chatDayCounters{},
+	}
+	if !filter.From.IsZero() {
+		rep.From = &filter.From
+	}
+	if !filter.To.IsZero() {
+		rep.To = &filter.To
+	}
+
+	chats := make(map[string]int)
+	for _, row := range rows {
+		day := row.Day.Format(time.DateOnly)
+
+		var c chatDayCounters
+		c.ChatID, c.Day = row.ChatID, day
+		c.counters.add(row)
+		rep.ChatDays = append(rep.ChatDays, c)
+
+		rep.Total.add(row)
+
+		// Rows come by day, so a new day is the last one
+		if n := len(rep.Days); n == 0 || rep.Days[n-1].Day != day {
+			rep.Days = append(rep.Days, dayCounters{Day: day})
+		}
+		rep.Days[len(rep.Days)-1].counters.add(row)
+
+		i, ok := chats[row.ChatID]
+		if !ok {
+			i = len(rep.Chats)
+			chats[row.ChatID] = i
+			rep.Chats = append(rep.Chats, chatCounters{ChatID: row.ChatID, Title: titles[row.ChatID]})
+		}
+		rep.Chats[i].counters.add(row)
+	}
+
+	slices.SortStableFunc(rep.Chats, func(a, b chatCounters) int {
+		return cmp.Or(cmp.Compare(b.Checked, a.Checked), cmp.Compare(a.ChatID, b.ChatID))
+	})
+	return rep
+}
+
+func (r report) writeJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// writeTables writes the counters by chat and by day as aligned tables,
+// each ending with the totals of the period
+func (r report) writeTables(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	writeRow := func(name string, c counters) {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%d\t%.4f\n",
+			name, c.Checked, c.Erased, c.Banned, c.Muted, c.Errors, c.Overridden, falsePositiveRate(c),
+			c.AIRequests, c.PromptTokens+c.CompletionTokens, c.Cost)
+	}
+	header := "\tchecked\terased\tbanned\tmuted\terrors\toverridden\tfp rate\tai requests\ttokens\tcost usd\n"
+
+	fmt.Fprint(tw, "chat"+header)
+	for _, c := range r.Chats {
+		name := c.ChatID
+		if c.Title != "" {
+			name = c.Title + " (" + c.ChatID + ")"
+		}
+		writeRow(name, c.counters)
+	}
+	writeRow("total", r.Total)
+
+	fmt.Fprintln(tw)
+	fmt.Fprint(tw, "day"+header)
+	for _, d := range r.Days {
+		writeRow(d.Day, d.counters)
+	}
+	writeRow("total", r.Total)
+
+	return tw.Flush()
+}
+
+// falsePositiveRate returns the share of the removed messages admins
+// overrode as no spam, in percent, or a dash without removals
+func falsePositiveRate(c counters) string {
+	if c.removed() == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(c.Overridden)*100/float64(c.removed()), 'f', 1, 64) + "%"
+}
@@ -0,0 +1,104 @@
+// Package stats implements the stats command, which prints moderation
+// statistics: messages checked, erased, banned and muted, checks that
+// failed, removals admins overrode as false positives, and AI requests,
+// tokens and cost, by chat and by day, as tables or JSON.
+package stats
+
+import (
+	"io"
+	"os"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/cli"
+	"nuclight.org/antispam-tg-bot/app/storage"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+var opts struct {
+	cli.DBOptions
+	Output string `long:"output" default:"-" description:"output file, - for stdout"`
+	Format string `long:"format" default:"table" choice:"table" choice:"json" description:"output format"`
+	ChatID string `long:"chat-id" description:"count messages of this chat only"`
+	From   string `long:"from" description:"count messages since this date (YYYY-MM-DD), empty counts from the last --days"`
+	To     string `long:"to" description:"count messages before this date (YYYY-MM-DD)"`
+	Days   int    `long:"days" default:"30" description:"number of days up to today counted without --from, 0 counts all"`
+}
+
+// Run runs the stats command, args being its name and options as in os.Args
+func Run(args []string) {
+	err := cli.Parse(&opts, args)
+	if err != nil {
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger()
+
+	filter := storage.StatsFilter{ChatID: opts.ChatID}
+	if filter.From, err = cli.ParseDate(opts.From); err != nil {
+		log.Error("parsing --from", "error", err)
+		os.Exit(1)
+	}
+	if filter.To, err = cli.ParseDate(opts.To); err != nil {
+		log.Error("parsing --to", "error", err)
+		os.Exit(1)
+	}
+	if filter.From.IsZero() && opts.Days > 0 {
+		filter.From = time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1-opts.Days)
+	}
+
+	ctx, cancel := cli.Context()
+	defer cancel()
+
+	db, err := storage.OpenReadOnly(ctx, opts.DBPath)
+	if err != nil {
+		log.Error("opening database", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		if err := db.Close(); err != nil {
+			log.Error("closing database", "error", err)
+		}
+	}()
+
+	rows, err := db.CountByChatDay(ctx, filter)
+	if err != nil {
+		log.Error("counting messages", "error", err)
+		return
+	}
+	chats, err := db.ListChats(ctx)
+	if err != nil {
+		log.Error("listing chats", "error", err)
+		return
+	}
+	titles := make(map[string]string, len(chats))
+	for _, chat := range chats {
+		titles[chat.ID] = chat.Title
+	}
+
+	rep := newReport(filter, rows, titles)
+
+	var out io.Writer = os.Stdout
+	if opts.Output != "-" {
+		file, err := os.Create(opts.Output)
+		if err != nil {
+			log.Error("creating output file", "error", err)
+			return
+		}
+		defer func() {
+			if err := file.Close(); err != nil {
+				log.Error("closing output file", "error", err)
+			}
+		}()
+		out = file
+	}
+
+	if opts.Format == "json" {
+		err = rep.writeJSON(out)
+	} else {
+		err = rep.writeTables(out)
+	}
+	if err != nil {
+		log.Error("writing statistics", "error", err)
+		return
+	}
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// AuditLog records changes for the audit trail
+type AuditLog interface {
+	AppendAudit(ctx context.Context, entry e.AuditEntry) error
+}
+
+// setScore sets the user's score and records the change in the audit log
+func (s *ModeratingSrv) setScore(ctx context.Context, user e.User, before, after int, reason string) error {
+	if err := s.ScoreStore.SetScore(ctx, user, after); err != nil {
+		return err
+	}
+
+	return s.auditScore(ctx, user, before, after, reason)
+}
+
+// invalidateScore drops the user's score from the score store's cache after
+// it was stored with a decision
+func (s *ModeratingSrv) invalidateScore(user e.User) {
+	if cache, ok := s.ScoreStore.(scoreInvalidator); ok {
+		cache.Invalidate(user)
+	}
+}
+
+// auditScore records the change of the user's score in the audit log
+func (s *ModeratingSrv) auditScore(ctx context.Context, user e.User, before, after int, reason string) error {
+	if s.Audit == nil {
+		return nil
+	}
+
+	err := s.Audit.AppendAudit(ctx, e.AuditEntry{
+		Kind:   e.AuditKindScore,
+		Actor:  e.AuditActorBot,
+		ChatID: user.ChatID,
+		UserID: user.ID,
+		Before: strconv.Itoa(before),
+		After:  strconv.Itoa(after),
+		Reason: reason,
+	})
+	if err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+
+	return nil
+}
+
+// scoreChangeReason describes why the action changes the sender's score
+func scoreChangeReason(action e.Action) string {
+	if action.Kind == e.ActionKindNoop {
+		return "message passed the check"
+	}
+
+	reason := "message got " + string(action.Kind)
+	if action.Category != "" {
+		reason += " as " + string(action.Category)
+	}
+	if action.Note != "" {
+		reason += ": " + action.Note
+	}
+	return reason
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// backupPrefix and backupExt name backup files, the time goes in between so
+// that names sort chronologically
+const (
+	backupPrefix     = "antispam-"
+	backupExt        = ".sqlite"
+	backupTimeLayout = "20060102-150405"
+)
+
+// BackupSrv periodically backs the database up into a directory, keeping a
+// number of the most recent backups
+type BackupSrv struct {
+	Log logger.Logger
+
+	// Store makes backups
+	Store Backuper
+
+	// Dir is the directory for backups
+	Dir string
+
+	// Interval between backups
+	Interval time.Duration
+
+	// Keep is how many most recent backups are kept, zero keeps all
+	Keep int
+}
+
+type Backuper interface {
+	Backup(ctx context.Context, destPath string) error
+}
+
+// Run makes a backup every interval until the context is canceled
+func (s *BackupSrv) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+
+		path, err := s.backup(ctx, time.Now())
+		if err != nil {
+			s.Log.Error("backing up database", "error", err)
+			continue
+		}
+
+		s.Log.Info("database backed up", "path", path)
+	}
+}
+
+// backup makes a backup named by the time and removes the old ones
+func (s *BackupSrv) backup(ctx context.Context, now time.Time) (string, error) {
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return "", fmt.Errorf("creating backup directory: %w", err)
+	}
+
+	path := filepath.Join(s.Dir, backupPrefix+now.UTC().Format(backupTimeLayout)+backupExt)
+	if err := s.Store.Backup(ctx, path); err != nil {
+		return "", err
+	}
+
+	if err := s.removeOld(); err != nil {
+		return path, fmt.Errorf("removing old backups: %w", err)
+	}
+
+	return path, nil
+}
+
+// removeOld removes all but Keep most recent backups
+func (s *BackupSrv) removeOld() error {
+	if s.Keep <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupExt) {
+			backups = append(backups, name)
+		}
+	}
+	sort.Strings(backups)
+
+	for len(backups) > s.Keep {
+		if err = os.Remove(filepath.Join(s.Dir, backups[0])); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeBackuper struct{}
+
+func (fakeBackuper) Backup(_ context.Context, destPath string) error {
+	return os.WriteFile(destPath, []byte("db"), 0o600)
+}
+
+func TestBackupSrv_KeepsRecentBackups(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "unrelated.txt"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &BackupSrv{Store: fakeBackuper{}, Dir: dir, Keep: 2}
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		if _, err := s.backup(context.Background(), start.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+
+	want := []string{"antispam-20250301-020000.sqlite", "antispam-20250301-030000.sqlite", "unrelated.txt"}
+	if len(names) != len(want) {
+		t.Fatalf("files = %v, want %v", names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Errorf("files = %v, want %v", names, want)
+			break
+		}
+	}
+}
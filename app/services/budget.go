@@ -0,0 +1,39 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"html"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+)
+
+// budgetExceeded reports a used up AI budget: it's logged as a warning and
+// the owner is alerted once per budget period
+func (s *ModeratingSrv) budgetExceeded(ctx context.Context, err error) {
+	key := err.Error()
+	var budgetErr *ai.BudgetError
+	if errors.As(err, &budgetErr) {
+		key = budgetErr.Period + " " + budgetErr.ResetsAt.String()
+	}
+
+	s.budgetMu.Lock()
+	alerted := s.budgetAlerted == key
+	s.budgetAlerted = key
+	s.budgetMu.Unlock()
+
+	if alerted {
+		return
+	}
+
+	s.log().Warn("ai budget used up, only the rules apply", "error", err)
+
+	if s.Alerts == nil || s.OwnerChatID == "" {
+		return
+	}
+	text := "<b>AI budget used up</b>\n" + html.EscapeString(err.Error()) +
+		"\nUntil then messages are checked by the rules only."
+	if err = s.Alerts.SendMessage(ctx, s.OwnerChatID, text); err != nil {
+		s.log().Error("sending budget alert", "error", err)
+	}
+}
@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// ChatRefreshSrv periodically refreshes the stored metadata of chats (type,
+// username, member count) from Telegram for reporting and policy decisions
+type ChatRefreshSrv struct {
+	Log logger.Logger
+
+	// Store keeps chats
+	Store ChatStore
+
+	// Fetcher gets chats' current metadata
+	Fetcher ChatFetcher
+
+	// Interval between refreshes
+	Interval time.Duration
+}
+
+type ChatStore interface {
+	ListChats(ctx context.Context) ([]e.Chat, error)
+	UpdateChat(ctx context.Context, chat e.Chat) error
+}
+
+type ChatFetcher interface {
+	FetchChat(ctx context.Context, chatID string) (e.Chat, error)
+}
+
+// Run refreshes chats on start and then every interval until the context is
+// canceled
+func (s *ChatRefreshSrv) Run(ctx context.Context) {
+	for {
+		refreshed, err := s.Refresh(ctx)
+		if err != nil {
+			s.Log.Error("refreshing chats", "error", err)
+		} else {
+			s.Log.Info("chats refreshed", "count", refreshed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.Interval):
+		}
+	}
+}
+
+// Refresh updates the metadata of all stored chats and returns the number of
+// chats refreshed. A failure for one chat, e.g. one the bot was removed from,
+// doesn't prevent the others.
+func (s *ChatRefreshSrv) Refresh(ctx context.Context) (int, error) {
+	chats, err := s.Store.ListChats(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("listing chats: %w", err)
+	}
+
+	var refreshed int
+	for _, chat := range chats {
+		if ctx.Err() != nil {
+			return refreshed, ctx.Err()
+		}
+
+		fetched, err := s.Fetcher.FetchChat(ctx, chat.ID)
+		if err != nil {
+			s.Log.Warn("fetching chat", "chat_id", chat.ID, "error", err)
+			continue
+		}
+
+		if err = s.Store.UpdateChat(ctx, fetched); err != nil {
+			return refreshed, fmt.Errorf("updating chat %s: %w", chat.ID, err)
+		}
+		refreshed++
+	}
+
+	return refreshed, nil
+}
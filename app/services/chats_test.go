@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeChatStore struct {
+	chats   []e.Chat
+	updated []e.Chat
+}
+
+func (f *fakeChatStore) ListChats(context.Context) ([]e.Chat, error) { return f.chats, nil }
+
+func (f *fakeChatStore) UpdateChat(_ context.Context, chat e.Chat) error {
+	f.updated = append(f.updated, chat)
+	return nil
+}
+
+type fakeChatFetcher map[string]e.Chat
+
+func (f fakeChatFetcher) FetchChat(_ context.Context, chatID string) (e.Chat, error) {
+	chat, ok := f[chatID]
+	if !ok {
+		return e.Chat{}, errors.New("Forbidden: bot was kicked from the supergroup chat")
+	}
+	return chat, nil
+}
+
+func TestChatRefreshSrv_Refresh(t *testing.T) {
+	store := &fakeChatStore{chats: []e.Chat{{ID: "-1"}, {ID: "-2"}, {ID: "-3"}}}
+	s := &ChatRefreshSrv{
+		Log:   slog.Default(),
+		Store: store,
+		Fetcher: fakeChatFetcher{
+			"-1": {ID: "-1", Title: "One", Type: "supergroup", MemberCount: 120},
+			"-3": {ID: "-3", Title: "Three", Type: "group", MemberCount: 7},
+		},
+	}
+
+	refreshed, err := s.Refresh(context.Background())
+	if err != nil {
+		t.Fatalf("Refresh: %v", err)
+	}
+	if refreshed != 2 {
+		t.Errorf("refreshed = %d, want 2, the kicked chat skipped", refreshed)
+	}
+	if len(store.updated) != 2 || store.updated[0].MemberCount != 120 || store.updated[1].ID != "-3" {
+		t.Errorf("updated = %+v, want chats -1 and -3", store.updated)
+	}
+}
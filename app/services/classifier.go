@@ -0,0 +1,67 @@
+package services
+
+import (
+	"context"
+
+	"nuclight.org/antispam-tg-bot/pkg/classifier"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// SpamClassifier is a spam classifier run by the bot's operator, e.g.
+// classifier.Client
+type SpamClassifier interface {
+	Classify(ctx context.Context, request classifier.Request) (classifier.Verdict, error)
+}
+
+// ClassifierMode defines how the verdicts of a SpamClassifier are combined
+// with the AI check
+type ClassifierMode string
+
+const (
+	// ClassifierModeBefore asks the classifier first: its verdicts at least
+	// as confident as ClassifierConfidence decide, the others and its
+	// failures are left to the AI check
+	ClassifierModeBefore ClassifierMode = "before"
+
+	// ClassifierModeReplace uses the classifier instead of the AI check
+	ClassifierModeReplace ClassifierMode = "replace"
+)
+
+// checkClassifier asks the classifier about the message, matched is false
+// if its verdict is left to the AI check
+func (s *ModeratingSrv) checkClassifier(ctx context.Context, msg e.Message) (verdict, bool, error) {
+	if s.Classifier == nil {
+		return verdict{}, false, nil
+	}
+
+	c, err := s.Classifier.Classify(ctx, classifier.Request{
+		ChatID:    msg.Sender.ChatID,
+		UserID:    msg.Sender.ID,
+		MessageID: msg.ID,
+		Text:      msg.Text,
+		MediaType: msg.MediaType,
+	})
+	switch {
+	case err != nil && s.ClassifierMode == ClassifierModeReplace:
+		return verdict{}, false, err
+	case err != nil:
+		s.log().Warn("classifier failed, asking the ai", "error", err)
+		return verdict{}, false, nil
+	case s.ClassifierMode != ClassifierModeReplace && c.Confidence < s.ClassifierConfidence:
+		return verdict{}, false, nil
+	}
+
+	v := verdict{
+		IsSpam: c.IsSpam,
+		Note:   c.Note,
+		Trace: e.Trace{
+			Stage:      e.DecisionStageClassifier,
+			Model:      c.Model,
+			Confidence: &c.Confidence,
+		},
+	}
+	if c.IsSpam {
+		v.Category = c.Category
+	}
+	return v, true, nil
+}
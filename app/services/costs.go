@@ -0,0 +1,142 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+)
+
+// costDays is how many UTC days of spend CostTracker keeps in memory
+const costDays = 2
+
+// CostTracker prices AI calls by the model price table and sums the spend by
+// chat and UTC day. Totals by model are reported to metrics. The spend kept
+// in memory starts from zero on restart, stored decisions keep the history.
+type CostTracker struct {
+	mu    sync.Mutex
+	spend map[costKey]e.Spend
+
+	requests metrics.Counter
+	tokens   metrics.Counter
+	cost     metrics.Counter
+	unpriced metrics.Counter
+	served   metrics.Counter
+
+	now func() time.Time
+}
+
+type costKey struct {
+	chatID string
+	day    time.Time
+}
+
+// NewCostTracker returns a tracker reporting to the registry
+func NewCostTracker(registry *metrics.Registry) *CostTracker {
+	return &CostTracker{
+		spend: make(map[costKey]e.Spend),
+		requests: registry.Counter(
+			"antispam_ai_requests_total",
+			"Number of AI requests by model.",
+			"model",
+		),
+		tokens: registry.Counter(
+			"antispam_ai_tokens_total",
+			"Number of AI tokens by model and kind (prompt or completion).",
+			"model", "kind",
+		),
+		cost: registry.Counter(
+			"antispam_ai_cost_usd_total",
+			"Cost of AI requests in USD by model.",
+			"model",
+		),
+		unpriced: registry.Counter(
+			"antispam_ai_unpriced_requests_total",
+			"Number of AI requests to models of unknown price, not counted in the cost.",
+			"model",
+		),
+		served: registry.Counter(
+			"antispam_ai_served_requests_total",
+			"Number of AI requests by the provider of the fallback chain that served them.",
+			"provider",
+		),
+		now: time.Now,
+	}
+}
+
+// Track prices the usage of an AI call made for the chat and adds it to the
+// chat's spend of the day. The result is false if the price of the model is
+// unknown, then the cost is zero.
+func (t *CostTracker) Track(chatID string, usage ai.Usage) (e.AIUsage, bool) {
+	cost, ok := usage.Cost()
+	result := e.AIUsage{
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             cost,
+	}
+
+	t.requests.Inc(usage.Model)
+	t.tokens.Add(float64(usage.PromptTokens), usage.Model, "prompt")
+	t.tokens.Add(float64(usage.CompletionTokens), usage.Model, "completion")
+	t.cost.Add(cost, usage.Model)
+	if !ok {
+		t.unpriced.Inc(usage.Model)
+	}
+	if usage.Provider != "" {
+		t.served.Inc(usage.Provider)
+	}
+
+	day := utcDay(t.now())
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := costKey{chatID: chatID, day: day}
+	spend := t.spend[key]
+	spend.Requests++
+	spend.PromptTokens += result.PromptTokens
+	spend.CompletionTokens += result.CompletionTokens
+	spend.Cost += result.Cost
+	t.spend[key] = spend
+
+	for k := range t.spend {
+		if k.day.Before(day.AddDate(0, 0, 1-costDays)) {
+			delete(t.spend, k)
+		}
+	}
+
+	return result, ok
+}
+
+// Spend returns the chat's spend on the UTC day of the time
+func (t *CostTracker) Spend(chatID string, at time.Time) e.Spend {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.spend[costKey{chatID: chatID, day: utcDay(at)}]
+}
+
+// Total returns the spend of all chats on the UTC day of the time
+func (t *CostTracker) Total(at time.Time) e.Spend {
+	day := utcDay(at)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var total e.Spend
+	for k, spend := range t.spend {
+		if k.day.Equal(day) {
+			total.Requests += spend.Requests
+			total.PromptTokens += spend.PromptTokens
+			total.CompletionTokens += spend.CompletionTokens
+			total.Cost += spend.Cost
+		}
+	}
+	return total
+}
+
+func utcDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
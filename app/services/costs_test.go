@@ -0,0 +1,64 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+)
+
+func TestCostTracker(t *testing.T) {
+	registry := metrics.NewRegistry()
+	tracker := NewCostTracker(registry)
+
+	now := time.Date(2025, 3, 1, 23, 0, 0, 0, time.UTC)
+	tracker.now = func() time.Time { return now }
+
+	usage, ok := tracker.Track("-100", ai.Usage{PromptTokens: 1000, CompletionTokens: 100, Model: "gpt-5-mini-2025-08-07"})
+	if !ok || usage.Cost != 0.00045 {
+		t.Errorf("Track = %+v, %v, want priced as gpt-5-mini", usage, ok)
+	}
+	tracker.Track("-100", ai.Usage{PromptTokens: 1000, Model: "gpt-5-mini"})
+	tracker.Track("-200", ai.Usage{PromptTokens: 10, Model: "llama", Provider: "anthropic"})
+
+	// The next day starts from zero
+	now = now.Add(2 * time.Hour)
+	tracker.Track("-100", ai.Usage{PromptTokens: 1000, Model: "gpt-5-mini"})
+
+	day := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if spend := tracker.Spend("-100", day); spend.Requests != 2 || spend.PromptTokens != 2000 || spend.CompletionTokens != 100 {
+		t.Errorf("spend of -100 = %+v, want 2 requests", spend)
+	}
+	if spend := tracker.Spend("-100", now); spend.Requests != 1 {
+		t.Errorf("spend of -100 the next day = %+v, want 1 request", spend)
+	}
+	if total := tracker.Total(day); total.Requests != 3 || total.PromptTokens != 2010 {
+		t.Errorf("total = %+v, want 3 requests of both chats", total)
+	}
+
+	// Days beyond the kept ones are dropped
+	now = now.AddDate(0, 0, costDays)
+	tracker.Track("-100", ai.Usage{Model: "gpt-5-mini"})
+	if spend := tracker.Spend("-100", day); spend != (e.Spend{}) {
+		t.Errorf("spend of an old day = %+v, want it dropped", spend)
+	}
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for _, want := range []string{
+		`antispam_ai_requests_total{model="gpt-5-mini"} 3`,
+		`antispam_ai_tokens_total{model="gpt-5-mini",kind="prompt"} 2000`,
+		`antispam_ai_unpriced_requests_total{model="llama"} 1`,
+		`antispam_ai_served_requests_total{provider="anthropic"} 1`,
+		`antispam_ai_cost_usd_total{model="gpt-5-mini-2025-08-07"} 0.00045`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"html"
+	"slices"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// topDigestCategories is how many spam categories a digest lists
+const topDigestCategories = 3
+
+// DigestSrv sends periodic moderation summaries to chats that enabled them
+// in their settings. Daily digests are sent at midnight UTC for the previous
+// day, weekly ones on Monday midnight UTC for the previous week.
+type DigestSrv struct {
+	Log logger.Logger
+
+	// Stats is a store of moderation statistics
+	Stats StatsStore
+
+	// Settings provides per-chat settings
+	Settings ChatSettingsProvider
+
+	// Sender delivers digests
+	Sender MessageSender
+}
+
+// Run sends digests on schedule until the context is canceled
+func (s *DigestSrv) Run(ctx context.Context) {
+	for {
+		now := time.Now().UTC()
+		next := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(next.Sub(now)):
+		}
+
+		err := s.SendDigests(ctx, e.DigestDaily, next.Add(-24*time.Hour), next)
+		if err != nil {
+			s.Log.Error("sending daily digests", "error", err)
+		}
+
+		if next.Weekday() == time.Monday {
+			err = s.SendDigests(ctx, e.DigestWeekly, next.Add(-7*24*time.Hour), next)
+			if err != nil {
+				s.Log.Error("sending weekly digests", "error", err)
+			}
+		}
+	}
+}
+
+// SendDigests sends digests covering [from, to) to every chat subscribed to
+// the interval. A failure for one chat doesn't prevent the others.
+func (s *DigestSrv) SendDigests(ctx context.Context, interval e.DigestInterval, from, to time.Time) error {
+	chats, err := s.Stats.ListChats(ctx)
+	if err != nil {
+		return fmt.Errorf("listing chats: %w", err)
+	}
+
+	for _, chat := range chats {
+		err = s.sendDigest(ctx, chat, interval, from, to)
+		if err != nil {
+			s.Log.Error("sending digest", "chat_id", chat.ID, "error", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *DigestSrv) sendDigest(ctx context.Context, chat e.Chat, interval e.DigestInterval, from, to time.Time) error {
+	settings, err := s.Settings.GetChatSettings(ctx, chat.ID)
+	if err != nil {
+		return fmt.Errorf("getting chat settings: %w", err)
+	}
+
+	target := settings.DigestTarget()
+	if settings.Digest != interval || target == "" {
+		return nil
+	}
+
+	stats, err := s.Stats.GetChatStats(ctx, chat.ID, from, to)
+	if err != nil {
+		return fmt.Errorf("getting chat stats: %w", err)
+	}
+
+	err = s.Sender.SendMessage(ctx, target, formatDigest(chat, interval, from, to, stats))
+	if err != nil {
+		return fmt.Errorf("sending message: %w", err)
+	}
+
+	return nil
+}
+
+func formatDigest(chat e.Chat, interval e.DigestInterval, from, to time.Time, stats e.ChatStats) string {
+	var sb strings.Builder
+
+	title := "Daily digest"
+	if interval == e.DigestWeekly {
+		title = "Weekly digest"
+	}
+
+	fmt.Fprintf(&sb, "<b>%s</b> for <b>%s</b>\n", title, html.EscapeString(chat.Title))
+	fmt.Fprintf(&sb, "%s – %s (UTC)\n\n", from.UTC().Format(time.DateOnly), to.UTC().Add(-time.Second).Format(time.DateOnly))
+	fmt.Fprintf(&sb, "Checked: %d\n", stats.Checked)
+	fmt.Fprintf(&sb, "Erased: %d\n", stats.Erased)
+	fmt.Fprintf(&sb, "Banned: %d\n", stats.Banned)
+	fmt.Fprintf(&sb, "Errors: %d\n", stats.Errors)
+
+	spend := stats.Spend
+	fmt.Fprintf(&sb, "AI spend: %d requests, %d tokens, $%.4f",
+		spend.Requests, spend.PromptTokens+spend.CompletionTokens, spend.Cost)
+
+	if len(stats.Categories) > 0 {
+		categories := make([]e.SpamCategory, 0, len(stats.Categories))
+		for c := range stats.Categories {
+			categories = append(categories, c)
+		}
+		slices.SortFunc(categories, func(a, b e.SpamCategory) int {
+			return cmp.Or(stats.Categories[b]-stats.Categories[a], cmp.Compare(a, b))
+		})
+
+		sb.WriteString("\n\nTop categories:")
+		for _, c := range categories[:min(len(categories), topDigestCategories)] {
+			fmt.Fprintf(&sb, "\n%s: %d", c, stats.Categories[c])
+		}
+	}
+
+	return sb.String()
+}
+
+type StatsStore interface {
+	ListChats(ctx context.Context) ([]e.Chat, error)
+	GetChatStats(ctx context.Context, chatID string, from, to time.Time) (e.ChatStats, error)
+}
+
+type ChatSettingsProvider interface {
+	GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, error)
+}
+
+type MessageSender interface {
+	SendMessage(ctx context.Context, chatID string, text string) error
+}
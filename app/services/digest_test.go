@@ -0,0 +1,77 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeStats struct {
+	chats []e.Chat
+	stats e.ChatStats
+}
+
+func (f *fakeStats) ListChats(context.Context) ([]e.Chat, error) { return f.chats, nil }
+func (f *fakeStats) GetChatStats(context.Context, string, time.Time, time.Time) (e.ChatStats, error) {
+	return f.stats, nil
+}
+
+type fakeSettings map[string]e.ChatSettings
+
+func (f fakeSettings) GetChatSettings(_ context.Context, chatID string) (e.ChatSettings, error) {
+	return f[chatID], nil
+}
+
+type fakeSender struct {
+	sent map[string]string
+}
+
+func (f *fakeSender) SendMessage(_ context.Context, chatID string, text string) error {
+	if f.sent == nil {
+		f.sent = make(map[string]string)
+	}
+	f.sent[chatID] = text
+	return nil
+}
+
+func TestDigestSrv_SendDigests(t *testing.T) {
+	sender := &fakeSender{}
+	s := &DigestSrv{
+		Log: slog.Default(),
+		Stats: &fakeStats{
+			chats: []e.Chat{{ID: "-1", Title: "daily"}, {ID: "-2", Title: "weekly"}, {ID: "-3", Title: "off"}, {ID: "-4", Title: "pm"}},
+			stats: e.ChatStats{
+				Checked: 42, Erased: 3, Banned: 1,
+				Spend: e.Spend{Requests: 40, PromptTokens: 12000, CompletionTokens: 800, Cost: 0.0125},
+			},
+		},
+		Settings: fakeSettings{
+			"-1": {Digest: e.DigestDaily, ModLogChatID: "-10"},
+			"-2": {Digest: e.DigestWeekly, ModLogChatID: "-20"},
+			"-3": {ModLogChatID: "-30"},
+			"-4": {Digest: e.DigestDaily, ModLogChatID: "-40", DigestChatID: "12345"},
+		},
+		Sender: sender,
+	}
+
+	to := time.Date(2026, 10, 16, 0, 0, 0, 0, time.UTC)
+	if err := s.SendDigests(context.Background(), e.DigestDaily, to.Add(-24*time.Hour), to); err != nil {
+		t.Fatalf("SendDigests: %v", err)
+	}
+
+	if len(sender.sent) != 2 {
+		t.Fatalf("sent %d digests, want 2: %v", len(sender.sent), sender.sent)
+	}
+	for _, want := range []string{"Checked: 42", "AI spend: 40 requests, 12800 tokens, $0.0125"} {
+		if !strings.Contains(sender.sent["-10"], want) {
+			t.Errorf("digest %q lacks %q", sender.sent["-10"], want)
+		}
+	}
+	if _, ok := sender.sent["12345"]; !ok {
+		t.Error("digest chat must take precedence over the mod log chat")
+	}
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+var (
+	linkRe    = regexp.MustCompile(`(?i)(?:https?://|www\.|\bt\.me/|\btelegram\.me/)\S+`)
+	mentionRe = regexp.MustCompile(`@[A-Za-z][A-Za-z0-9_]{3,31}`)
+)
+
+// extractFeatures computes heuristic features of a message. Text is
+// normalized first so zero-width characters can't hide links or mentions.
+func extractFeatures(msg e.Message) e.Features {
+	text := textnorm.Normalize(msg.Text)
+
+	return e.Features{
+		TextHash:     textnorm.Hash(msg.Text),
+		TextLength:   utf8.RuneCountInString(text),
+		LinkCount:    len(linkRe.FindAllStringIndex(text, -1)) + len(msg.Links),
+		MentionCount: len(mentionRe.FindAllStringIndex(text, -1)),
+		MixedScript:  hasMixedScriptWord(text),
+		HasMedia:     msg.HasMedia(),
+	}
+}
+
+// hasMixedScriptWord reports whether any word of the text contains both
+// Latin and Cyrillic letters.
+func hasMixedScriptWord(text string) bool {
+	for _, word := range strings.Fields(text) {
+		var latin, cyrillic bool
+		for _, r := range word {
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				latin = true
+			case unicode.Is(unicode.Cyrillic, r):
+				cyrillic = true
+			}
+		}
+		if latin && cyrillic {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestExtractFeatures(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		wantLinks    int
+		wantMentions int
+		wantMixed    bool
+	}{
+		{name: "plain text", text: "hello everyone"},
+		{name: "links", text: "visit https://example.com or t.me/somechannel", wantLinks: 2},
+		{name: "link hidden by zero-width space", text: "https:\u200B//example.com", wantLinks: 1},
+		{name: "mentions", text: "ping @alice_1 and @bob_22", wantMentions: 2},
+		{name: "mixed script word", text: "саsino bonus", wantMixed: true},
+		{name: "pure cyrillic is not mixed", text: "привет всем", wantMixed: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			f := extractFeatures(e.Message{Text: tc.text})
+			if f.LinkCount != tc.wantLinks {
+				t.Errorf("LinkCount = %d, want %d", f.LinkCount, tc.wantLinks)
+			}
+			if f.MentionCount != tc.wantMentions {
+				t.Errorf("MentionCount = %d, want %d", f.MentionCount, tc.wantMentions)
+			}
+			if f.MixedScript != tc.wantMixed {
+				t.Errorf("MixedScript = %v, want %v", f.MixedScript, tc.wantMixed)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeExamples struct {
+	// confirmed are the overridden messages, oldest first
+	confirmed []e.Example
+	truth     []e.GroundTruth
+
+	filters []storage.ExampleFilter
+}
+
+func (f *fakeExamples) ListExamples(_ context.Context, filter storage.ExampleFilter) ([]e.Example, error) {
+	f.filters = append(f.filters, filter)
+	var examples []e.Example
+	for _, ex := range f.confirmed {
+		if filter.ChatID == "" || ex.ChatID == filter.ChatID {
+			examples = append(examples, ex)
+		}
+	}
+	return examples, nil
+}
+
+func (f *fakeExamples) ListGroundTruth(_ context.Context, filter storage.GroundTruthFilter) ([]e.GroundTruth, error) {
+	var truth []e.GroundTruth
+	for _, gt := range f.truth {
+		if gt.Label == filter.Label && len(truth) < filter.Limit {
+			truth = append(truth, gt)
+		}
+	}
+	return truth, nil
+}
+
+func TestFewShot_Prompt(t *testing.T) {
+	store := &fakeExamples{
+		confirmed: []e.Example{
+			{ChatID: "1", Text: "old crypto offer", Label: e.LabelSpam},
+			{ChatID: "1", Text: "join my casino", Label: e.LabelSpam},
+			{ChatID: "2", Text: "other chat's spam", Label: e.LabelSpam},
+			{ChatID: "1", Label: e.LabelHam},
+			{ChatID: "1", Text: "who's hosting the game tonight?", Label: e.LabelHam},
+		},
+		truth: []e.GroundTruth{
+			{Example: e.Example{Text: "Who's hosting the game tonight?", Label: e.LabelHam}},
+			{Example: e.Example{Text: "see you at the club", Label: e.LabelHam}},
+			{Example: e.Example{Text: "imported spam", Label: e.LabelSpam}},
+		},
+	}
+	f := NewFewShot(store, 2, false)
+
+	got, err := f.Prompt(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Prompt: %v", err)
+	}
+
+	want := []string{
+		`<example label="spam">` + "\njoin my casino\n",
+		`<example label="spam">` + "\nold crypto offer\n",
+		`<example label="ham">` + "\nwho's hosting the game tonight?\n",
+		`<example label="ham">` + "\nsee you at the club\n",
+	}
+	last := -1
+	for _, w := range want {
+		i := strings.Index(got, w)
+		if i <= last {
+			t.Fatalf("prompt = %q, want %q after the previous example", got, w)
+		}
+		last = i
+	}
+	if strings.Contains(got, "other chat") || strings.Contains(got, "imported spam") || strings.Count(got, "<example") != 4 {
+		t.Errorf("prompt = %q, want the chat's examples topped up with distinct ground truth", got)
+	}
+
+	// Picks are reused until they're refreshed
+	store.confirmed = append(store.confirmed, e.Example{ChatID: "1", Text: "new spam", Label: e.LabelSpam})
+	if again, _ := f.Prompt(context.Background(), "1"); again != got || len(store.filters) != 1 {
+		t.Errorf("examples picked again within the refresh interval")
+	}
+	f.now = func() time.Time { return time.Now().Add(fewShotRefresh) }
+	if again, _ := f.Prompt(context.Background(), "1"); !strings.Contains(again, "new spam") {
+		t.Errorf("prompt = %q, want new examples after the refresh interval", again)
+	}
+}
+
+func TestFewShot_Global(t *testing.T) {
+	store := &fakeExamples{confirmed: []e.Example{
+		{ChatID: "2", Text: "other chat's spam", Label: e.LabelSpam},
+		{ChatID: "1", Text: strings.Repeat("a", fewShotMaxLength+10), Label: e.LabelHam},
+	}}
+	f := NewFewShot(store, 1, true)
+
+	got, err := f.Prompt(context.Background(), "1")
+	if err != nil {
+		t.Fatalf("Prompt: %v", err)
+	}
+	if store.filters[0].ChatID != "" || !strings.Contains(got, "other chat's spam") {
+		t.Errorf("prompt = %q, want examples of all chats", got)
+	}
+	if !strings.Contains(got, strings.Repeat("a", fewShotMaxLength)+"…\n") {
+		t.Errorf("prompt = %q, want the long example cut", got)
+	}
+
+	if empty := formatExamples(nil); empty != "" {
+		t.Errorf("formatExamples(nil) = %q, want nothing", empty)
+	}
+}
+
+func TestHandleMessage_FewShot(t *testing.T) {
+	scores := fakeScores{}
+	fake := &fakeAI{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		ScoreStore:    scores,
+		MessagesStore: &nopMessages{scores: scores},
+		AI:            fake,
+		FewShot: NewFewShot(&fakeExamples{confirmed: []e.Example{
+			{ChatID: "1", Text: "join my casino", Label: e.LabelSpam},
+		}}, 3, false),
+	}
+
+	_, err := s.HandleMessage(context.Background(), e.Message{Sender: e.User{ID: "1", ChatID: "1"}, Text: "hello"})
+	if err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if !strings.HasPrefix(fake.system, prompt) || !strings.Contains(fake.system, "join my casino") {
+		t.Errorf("system prompt doesn't end with the examples")
+	}
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"embed"
+	"path"
+	"strings"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/lang"
+)
+
+// languagePromptFiles has the sections added to the prompt of the spam check
+// of messages in a language, named by its ISO 639-1 code, e.g. uk.txt
+//
+//go:embed prompts/*.txt
+var languagePromptFiles embed.FS
+
+// languagePrompts are the sections by the code of their language
+var languagePrompts = loadLanguagePrompts()
+
+func loadLanguagePrompts() map[string]string {
+	entries, err := languagePromptFiles.ReadDir("prompts")
+	if err != nil {
+		panic(err)
+	}
+
+	prompts := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		data, err := languagePromptFiles.ReadFile(path.Join("prompts", entry.Name()))
+		if err != nil {
+			panic(err)
+		}
+		prompts[strings.TrimSuffix(entry.Name(), ".txt")] = strings.TrimSpace(string(data))
+	}
+	return prompts
+}
+
+// systemPrompt is the system prompt of the AI spam check of a message
+type systemPrompt struct {
+	text    string
+	version string
+
+	// language is the detected language of the message, empty if it's not
+	// detected or the prompt is not localized
+	language string
+
+	// experiment is the arm of the prompt experiment the message is in,
+	// empty if there is no experiment
+	experiment string
+}
+
+// basePrompt is the built-in prompt of the spam check of messages in any
+// language
+var basePrompt = systemPrompt{text: prompt, version: promptVersion}
+
+// selectPrompt returns the system prompt of the spam check of the message:
+// Prompt or, for the messages routed to it, the candidate of Experiment.
+// With LocalizedPrompts it has the section of the message's language, if
+// there is one, and asks for the note in the chat's language; the version
+// tells the variants apart in traces and the verdict cache.
+func (s *ModeratingSrv) selectPrompt(msg e.Message, settings e.ChatSettings) systemPrompt {
+	s.promptMu.RLock()
+	base, experiment := s.Prompt, s.Experiment
+	s.promptMu.RUnlock()
+
+	if base.Text == "" {
+		base = RegisteredPrompt{Version: promptVersion, Text: prompt}
+	}
+
+	var arm string
+	if experiment != nil {
+		if arm = experiment.arm(msg); arm == ExperimentCandidate {
+			base = experiment.Candidate
+		}
+	}
+
+	p := systemPrompt{text: base.Text, version: base.Version, experiment: arm}
+	if !s.LocalizedPrompts {
+		return p
+	}
+
+	p.language = lang.Detect(msg.Text)
+	if section, ok := languagePrompts[p.language]; ok {
+		p.text += "\n\nLANGUAGE:\n\n" + section + "\n"
+	}
+	if settings.Language != "" && settings.Language != "en" {
+		p.text += "\n\nNOTE LANGUAGE:\n\nwrite the `note` in " + lang.Name(settings.Language) + " instead of English, " +
+			"the admins of the chat read it.\n"
+	}
+	if p.text != base.Text {
+		p.version = ai.PromptVersion(p.text)
+	}
+	return p
+}
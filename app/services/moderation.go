@@ -0,0 +1,69 @@
+package services
+
+import (
+	"context"
+	"strings"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// ContentModerator classifies texts into categories of harmful content
+type ContentModerator interface {
+	Moderate(ctx context.Context, text string) (ai.Moderation, error)
+}
+
+// checkModeration screens the message's text with the moderation model. It
+// decides only when the model is confident: a text flagged with a score of
+// at least ModerationFlagScore is spam, a text scoring below
+// ModerationPassScore in every category passes unless its media is to be
+// checked. Other messages, and all of them if the moderation model fails,
+// are left to the AI.
+func (s *ModeratingSrv) checkModeration(ctx context.Context, msg e.Message, withMedia bool) (verdict, bool) {
+	if s.Moderation == nil || !msg.HasText() {
+		return verdict{}, false
+	}
+
+	text := msg.Text
+	if s.NormalizeText {
+		text = textnorm.Normalize(text)
+	}
+	text = s.redaction().Redact(ai.Truncate(text, s.MaxInputTokens))
+
+	aiCtx, cancel := s.aiContext(ctx)
+	defer cancel()
+
+	m, err := s.Moderation.Moderate(aiCtx, text)
+	if err != nil {
+		s.log().Warn("moderation pre-filter failed, asking the ai", "error", err)
+		return verdict{}, false
+	}
+
+	category, score := m.TopCategory()
+	trace := e.Trace{Stage: e.DecisionStageModeration, Model: ai.ModerationModel, Confidence: &score}
+
+	switch {
+	case m.Flagged && s.ModerationFlagScore > 0 && score >= s.ModerationFlagScore:
+		return verdict{
+			IsSpam:   true,
+			Category: moderationCategory(category),
+			Note:     "moderation: " + strings.Join(m.Categories, ", "),
+			Trace:    trace,
+		}, true
+	case !m.Flagged && score < s.ModerationPassScore && !(withMedia && s.analyzableMedia(msg)):
+		confidence := 1 - score
+		trace.Confidence = &confidence
+		return verdict{Trace: trace}, true
+	}
+
+	return verdict{}, false
+}
+
+// moderationCategory maps a moderation category to a spam category
+func moderationCategory(category string) e.SpamCategory {
+	if category == "sexual" || strings.HasPrefix(category, "sexual/") {
+		return e.SpamCategoryAdult
+	}
+	return e.SpamCategoryOther
+}
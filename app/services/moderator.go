@@ -3,10 +3,19 @@ package services
 import (
 	"context"
 	_ "embed"
+	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
 
 	"nuclight.org/antispam-tg-bot/pkg/ai"
 	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/redact"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+	"nuclight.org/antispam-tg-bot/pkg/webhook"
 )
 
 // ModeratingSrv handles new messages by determining appropriate actions based on a user score system.
@@ -35,6 +44,22 @@ type ModeratingSrv struct {
 	// AI is an AI client
 	AI AIClient
 
+	// AITimeout bounds every AI call apart from the handling context, zero
+	// leaves calls bounded by the handling context only
+	AITimeout time.Duration
+
+	// AIFailureMode defines what happens to a message the AI failed to
+	// check, e.g. timed out on. Empty means AIFailureOpen.
+	AIFailureMode AIFailureMode
+
+	// Costs sums the spend of AI calls by chat and day, optional
+	Costs *CostTracker
+
+	// Alerts delivers alerts for the bot's owner to OwnerChatID, e.g. when
+	// the AI budget is used up, optional
+	Alerts      MessageSender
+	OwnerChatID string
+
 	// MediaDownloader downloads media content by file ID (on-demand)
 	MediaDownloader MediaDownloader
 
@@ -42,143 +67,720 @@ type ModeratingSrv struct {
 	// (e.g. video stickers) into a still image. Optional: if nil, such
 	// media is treated as non-analyzable.
 	MediaConverter MediaConverter
+
+	// ImageDownscaler shrinks images before they're sent to the vision
+	// model, optional
+	ImageDownscaler ImageDownscaler
+
+	// ImageDetail is the detail the vision model sees images of the spam
+	// check at, e.g. high to read small text in them. Empty keeps the AI
+	// client's, NSFW checks always do.
+	ImageDetail ai.ImageDetail
+
+	// ReasoningEffort is the effort of the AI spam check, e.g. minimal for
+	// a cheap first look. Empty keeps the AI client's.
+	ReasoningEffort ai.ReasoningEffort
+
+	// ConfirmBelow has verdicts of the AI spam check less confident than it
+	// checked again with ConfirmEffort, the second verdict is taken. Zero
+	// doesn't check them again.
+	ConfirmBelow  float64
+	ConfirmEffort ai.ReasoningEffort
+
+	// Redactor masks personal data, such as phone numbers, in texts sent to
+	// the AI and the moderation model, nil sends them as they are. The
+	// values are restored in the AI's notes, which stay local.
+	Redactor *redact.Redactor
+
+	// Prompt is the system prompt of the AI spam check, the built-in one if
+	// its text is empty. Once messages are handled, it's changed with
+	// SetPrompt.
+	Prompt RegisteredPrompt
+
+	// Experiment routes a share of the AI spam checks to a candidate
+	// prompt, optional
+	Experiment *PromptExperiment
+
+	// MaxInputTokens caps the tokens of a message's text, and of the
+	// results of the AI's tools, sent to the AI; the middle of a longer text
+	// is dropped. Zero sends them whole.
+	MaxInputTokens int
+
+	// LocalizedPrompts adds a section for the message's language to the
+	// prompt of the AI spam check and asks for notes in the chat's language
+	LocalizedPrompts bool
+
+	// NormalizeText enables unicode normalization (NFKC, invisible character
+	// stripping) of the message text before it is sent to the AI
+	NormalizeText bool
+
+	// Webhook is an external decision service, optional
+	Webhook DecisionWebhook
+
+	// WebhookMode defines how Webhook decisions are combined with the AI check
+	WebhookMode WebhookMode
+
+	// Classifier is the operator's own spam classifier, optional. In
+	// ClassifierModeBefore its verdicts at least ClassifierConfidence
+	// confident decide before the AI check.
+	Classifier           SpamClassifier
+	ClassifierMode       ClassifierMode
+	ClassifierConfidence float64
+
+	// Probations stores users' probation periods, optional: if nil, the
+	// probation policy is disabled
+	Probations ProbationStore
+
+	// Verdicts caches AI verdicts on texts, so a text repeated across users
+	// and chats is checked once per VerdictTTL, optional
+	Verdicts   VerdictCache
+	VerdictTTL time.Duration
+
+	// Moderation screens texts with a moderation model before the AI spam
+	// check, deciding on those it classifies confidently by the scores
+	// below, optional. A zero score disables the decision.
+	Moderation          ContentModerator
+	ModerationFlagScore float64
+	ModerationPassScore float64
+
+	// FewShot adds examples of spam and ham labeled by admins to the prompt
+	// of the AI spam check, optional
+	FewShot *FewShot
+
+	// History and Unwrapper are offered to the AI as tools during the spam
+	// check of texts, to look up the sender's recent messages and where the
+	// message's links lead, optional. They need an AI client supporting
+	// tools, see ToolAI.
+	History   MessageHistory
+	Unwrapper LinkUnwrapper
+
+	// Fingerprints recognizes re-posts of confirmed spam without asking the
+	// AI, optional
+	Fingerprints FingerprintMatcher
+
+	// Mentions resolves @usernames mentioned in messages, for the invite
+	// link rule to tell chat and channel mentions from mentions of users,
+	// optional: if nil, mentions are not checked
+	Mentions MentionResolver
+
+	// Locks serializes handling of a user's messages across replicas of the
+	// bot sharing scores, optional
+	Locks UserLocker
+
+	// Settings provides per-chat settings, optional
+	Settings ChatSettingsProvider
+
+	// Audit records score changes, optional
+	Audit AuditLog
+
+	// Log is a logger, optional
+	Log logger.Logger
+
+	budgetMu      sync.Mutex
+	budgetAlerted string
+
+	promptMu sync.RWMutex
 }
 
+// WebhookMode defines how an external decision webhook is used
+type WebhookMode string
+
+const (
+	// WebhookModeSupplement asks the webhook first and falls back to the AI
+	// check if it abstains or fails
+	WebhookModeSupplement WebhookMode = "supplement"
+
+	// WebhookModeReplace uses the webhook instead of the AI check, an
+	// abstained decision lets the message through
+	WebhookModeReplace WebhookMode = "replace"
+)
+
+// AIFailureMode defines what happens to a message when the AI check fails
+type AIFailureMode string
+
+const (
+	// AIFailureOpen lets the message through and reports the error
+	AIFailureOpen AIFailureMode = "open"
+
+	// AIFailureClosed erases the message, leaving the sender's score as is
+	AIFailureClosed AIFailureMode = "closed"
+
+	// AIFailureRules lets the message through if the rules do, leaving the
+	// sender's score as is, like when the AI budget is used up
+	AIFailureRules AIFailureMode = "rules"
+)
+
 // HandleMessage handles a message, it takes a message, reviews it and returns an action to be taken
 // based on the score system. It returns an action and an error if something goes wrong. Returned
 // action has to be considered even if error is not nil.
 func (s *ModeratingSrv) HandleMessage(ctx context.Context, msg e.Message) (e.Action, error) {
 	hasText := msg.HasText()
 	hasAnalyzableMedia := s.analyzableMedia(msg)
+	analyzable := hasText || hasAnalyzableMedia
 
-	if !hasText && !hasAnalyzableMedia {
-		// Nothing to analyze: no text and no analyzable media (or unsupported media type)
+	if !analyzable && !msg.HasMedia() && !msg.IsForward {
+		// Nothing to check: no text, no media and not a forward
 		return noop, nil
 	}
 
+	settings, err := s.chatSettings(ctx, msg.Sender.ChatID)
+	if err != nil {
+		return noop, fmt.Errorf("getting chat settings: %w", err)
+	}
+
+	if s.Locks != nil {
+		// The score is read, changed and stored by one replica at a time
+		unlock, err := s.Locks.LockUser(ctx, msg.Sender)
+		if err != nil {
+			return noop, fmt.Errorf("locking user: %w", err)
+		}
+		defer unlock()
+	}
+
 	score, err := s.ScoreStore.GetScore(ctx, msg.Sender, s.DefaultScore)
 	if err != nil {
 		return noop, fmt.Errorf("getting user score: %w", err)
 	}
 
-	if score >= s.TrustedScore {
-		if score > s.TrustedScore {
+	probation, err := s.getProbation(ctx, settings, msg.Sender)
+	if err != nil {
+		return noop, fmt.Errorf("getting probation: %w", err)
+	}
+
+	trustedScore := s.trustedScore(settings)
+	if score >= trustedScore && probation.remaining == 0 {
+		if score > trustedScore {
 			// Adjust score down to the trusted score
-			err = s.ScoreStore.SetScore(ctx, msg.Sender, s.TrustedScore)
+			err = s.setScore(ctx, msg.Sender, score, trustedScore, "capped at the trusted score")
 			if err != nil {
 				return noop, fmt.Errorf("setting user score to trusted: %w", err)
 			}
 		}
 
+		// Trusted users skip the spam check, but not the NSFW policy
+		if settings.NSFWAction != "" && hasAnalyzableMedia &&
+			settings.FeatureEnabled(e.FeatureMedia) && settings.FeatureEnabled(e.FeatureAI) {
+			return s.checkTrustedNSFW(ctx, settings, msg)
+		}
+
 		return noop, nil
 	}
 
-	messageID, err := s.MessagesStore.SaveMessage(ctx, msg)
+	action, delta, err := s.getAction(ctx, score, settings, msg)
+	if errors.Is(err, errAICheck) && s.AIFailureMode == AIFailureClosed && ctx.Err() == nil {
+		return s.failClosed(ctx, msg, score, err), nil
+	}
 	if err != nil {
-		return noop, fmt.Errorf("saving message: %w", err)
+		_, _ = s.MessagesStore.SaveDecision(ctx, e.Decision{Message: msg, Error: err.Error()})
+		return action, fmt.Errorf("getting action: %w", err)
 	}
 
-	action, delta, err := s.getAction(ctx, score, msg)
+	if !analyzable && action.Kind == e.ActionKindNoop {
+		// Media the AI can't analyze, e.g. a video, passed the rules: it's
+		// let through without a trace, as if it wasn't checked
+		return noop, nil
+	}
+
+	err = s.applyProbation(ctx, settings, msg.Sender, probation, &action, &delta)
 	if err != nil {
-		_ = s.MessagesStore.SaveError(ctx, messageID, err.Error())
-		return action, fmt.Errorf("getting action: %w", err)
+		return action, fmt.Errorf("applying probation: %w", err)
 	}
 
-	err = s.MessagesStore.SaveAction(ctx, messageID, action)
+	newScore := s.getNewScore(settings, score, delta)
+	action.ScoreBefore = score
+	action.ScoreAfter = newScore
+	action.Trace.ScoreBefore = score
+	action.Trace.ScoreAfter = newScore
+
+	// The message, its action and the score change are stored atomically
+	decision := e.Decision{Message: msg, Action: &action}
+	if newScore != score {
+		decision.Score = &newScore
+	}
+	_, err = s.MessagesStore.SaveDecision(ctx, decision)
 	if err != nil {
-		return action, fmt.Errorf("saving action: %w", err)
+		return action, fmt.Errorf("saving decision: %w", err)
 	}
 
-	newScore := s.getNewScore(score, delta)
 	if newScore != score {
-		err = s.ScoreStore.SetScore(ctx, msg.Sender, newScore)
+		s.invalidateScore(msg.Sender)
+		err = s.auditScore(ctx, msg.Sender, score, newScore, scoreChangeReason(action))
 		if err != nil {
-			return action, fmt.Errorf("setting user score: %w", err)
+			return action, err
 		}
 	}
 
 	return action, nil
 }
 
-func (s *ModeratingSrv) getAction(ctx context.Context, score int, msg e.Message) (e.Action, int, error) {
-	report, err := s.checkSpam(ctx, msg)
+// failClosed erases a message the AI failed to check. The error is logged
+// and stored with the decision rather than returned, so the erase is taken.
+func (s *ModeratingSrv) failClosed(ctx context.Context, msg e.Message, score int, checkErr error) e.Action {
+	s.log().Error("ai check failed, erasing the message", "error", checkErr, "chat_id", msg.Sender.ChatID, "message_id", msg.ID)
+
+	action := e.Action{
+		Kind:        e.ActionKindErase,
+		Note:        "not checked: the ai check failed",
+		Trace:       e.Trace{Stage: e.DecisionStageAI, ScoreBefore: score, ScoreAfter: score},
+		ScoreBefore: score,
+		ScoreAfter:  score,
+	}
+
+	_, err := s.MessagesStore.SaveDecision(ctx, e.Decision{Message: msg, Action: &action, Error: checkErr.Error()})
 	if err != nil {
-		return noop, 0, fmt.Errorf("checking spam: %w", err)
+		s.log().Error("saving decision", "error", err)
 	}
 
-	if !report.IsSpam {
-		return noop, 1, nil
+	return action
+}
+
+// SeedTrusted raises the users' scores to the trusted score, leaving users
+// who are already trusted as is
+func (s *ModeratingSrv) SeedTrusted(ctx context.Context, users []e.User) error {
+	scores, err := s.ScoreStore.GetScores(ctx, users, s.DefaultScore)
+	if err != nil {
+		return fmt.Errorf("getting scores: %w", err)
 	}
 
-	newScore := s.getNewScore(score, -1)
-	if newScore <= s.BanScore {
-		return e.Action{
-			Kind: e.ActionKindBan,
-			Note: report.Note,
-		}, -1, nil
+	for i, user := range users {
+		settings, err := s.chatSettings(ctx, user.ChatID)
+		if err != nil {
+			return fmt.Errorf("getting chat settings: %w", err)
+		}
+
+		score := scores[i]
+		trustedScore := s.trustedScore(settings)
+		if score >= trustedScore {
+			continue
+		}
+
+		if err = s.setScore(ctx, user, score, trustedScore, "seeded as trusted"); err != nil {
+			return fmt.Errorf("setting score of user %s: %w", user.ID, err)
+		}
 	}
 
-	return e.Action{
-		Kind: e.ActionKindErase,
-		Note: report.Note,
-	}, -1, nil
+	return nil
 }
 
-func (s *ModeratingSrv) checkSpam(ctx context.Context, msg e.Message) (ai.SpamCheck, error) {
-	var check ai.SpamCheck
-	var err error
+func (s *ModeratingSrv) getAction(ctx context.Context, score int, settings e.ChatSettings, msg e.Message) (e.Action, int, error) {
+	v, err := s.review(ctx, score, settings, msg)
+	if err != nil {
+		return noop, 0, err
+	}
 
-	text := msg.Text
-	if text == "" {
-		text = "(no text, analyze image only)"
+	if !v.IsSpam {
+		if v.KeepScore {
+			// Not judged, e.g. the AI budget is used up
+			return e.Action{Kind: e.ActionKindNoop, Note: v.Note, Trace: v.Trace}, 0, nil
+		}
+		return e.Action{Kind: e.ActionKindNoop, Trace: v.Trace, Usage: v.Usage}, 1, nil
 	}
 
-	if s.analyzableMedia(msg) {
-		// Download media content on-demand
-		var mediaContent []byte
-		mediaContent, err = s.MediaDownloader.DownloadFile(ctx, *msg.MediaFileID)
-		if err != nil {
-			return check, fmt.Errorf("downloading media: %w", err)
+	if kind, ok := settings.CategoryActions[v.Category]; ok && v.Action == "" {
+		v.Action = kind
+	}
+
+	action := e.Action{
+		Kind:     e.ActionKindErase,
+		Note:     v.Note,
+		Category: v.Category,
+		Trace:    v.Trace,
+		Usage:    v.Usage,
+	}
+
+	if v.KeepScore {
+		if v.Action != "" {
+			action.Kind = v.Action
 		}
+		return action, 0, nil
+	}
 
-		mimeType := *msg.MediaType
-		if s.canConvertMedia(msg) {
-			// Media the vision API can't decode directly (e.g. video
-			// stickers): extract a still frame and analyze that as JPEG.
-			var frame []byte
-			frame, err = s.MediaConverter.ToImage(ctx, mediaContent)
-			if err != nil {
-				// Conversion failed (corrupt media or an unavailable/broken
-				// ffmpeg). If the message has text, degrade to text-only
-				// analysis rather than skipping the spam check entirely -
-				// otherwise spam text could bypass moderation by attaching
-				// an unconvertible file. If the message is media-only there
-				// is nothing real to analyze: report the error so the
-				// failure is visible instead of scoring a placeholder.
-				if !msg.HasText() {
-					return check, fmt.Errorf("converting media to image: %w", err)
-				}
-				mediaContent = nil // err is overwritten by the text-only completion below
-			} else {
-				mediaContent = frame
-				mimeType = "image/jpeg"
+	switch v.Action {
+	case e.ActionKindNoop:
+		// Spam of a category the chat tolerates
+		return e.Action{Kind: e.ActionKindNoop, Note: v.Note, Category: v.Category, Trace: v.Trace}, 0, nil
+	case e.ActionKindBan:
+		action.Kind = e.ActionKindBan
+		return action, s.banScore(settings) - score, nil
+	case e.ActionKindMute, e.ActionKindErase:
+		// Explicit actions are taken as is, without escalating to a ban
+		action.Kind = v.Action
+		return action, -1, nil
+	}
+
+	newScore := s.getNewScore(settings, score, -1)
+	if newScore <= s.banScore(settings) {
+		action.Kind = e.ActionKindBan
+	}
+
+	return action, -1, nil
+}
+
+// verdict is the outcome of reviewing a message
+type verdict struct {
+	IsSpam bool
+
+	// Action is a ban or mute to take regardless of the sender's score,
+	// empty leaves the choice to the score system
+	Action e.ActionKind
+
+	// KeepScore leaves the sender's score as is, for policy removals that
+	// are not a judgement of the sender and for messages left unchecked
+	KeepScore bool
+
+	Category e.SpamCategory
+	Note     string
+
+	// Trace explains the verdict
+	Trace e.Trace
+
+	// Usage is what asking the AI took, nil if it was not asked
+	Usage *e.AIUsage
+}
+
+// review decides whether the message is spam: zero-cost rules first, then the
+// decision webhook and/or the AI depending on configuration.
+func (s *ModeratingSrv) review(ctx context.Context, score int, settings e.ChatSettings, msg e.Message) (verdict, error) {
+	v, matched, err := s.checkFingerprint(ctx, msg)
+	if err != nil {
+		return verdict{}, fmt.Errorf("checking spam fingerprints: %w", err)
+	}
+	if matched {
+		return v, nil
+	}
+
+	v, matched, err = s.checkLinkOnly(ctx, settings, msg)
+	if err != nil {
+		return verdict{}, fmt.Errorf("checking link-only rule: %w", err)
+	}
+	if matched {
+		return v, nil
+	}
+
+	v, matched = s.checkInviteLinks(ctx, settings, msg)
+	if matched {
+		return v, nil
+	}
+
+	v, matched, err = checkQuietHours(settings, msg, time.Now())
+	if err != nil {
+		return verdict{}, fmt.Errorf("checking quiet hours: %w", err)
+	}
+	if matched {
+		return v, nil
+	}
+
+	if !msg.HasText() && !s.analyzableMedia(msg) {
+		// Nothing the AI can analyze, only the zero-cost rules apply
+		return verdict{KeepScore: true, Trace: e.Trace{Stage: e.DecisionStageRule}}, nil
+	}
+
+	if !settings.FeatureEnabled(e.FeatureAI) {
+		// Only the zero-cost rules apply in the chat
+		return verdict{Trace: e.Trace{Stage: e.DecisionStageRule}}, nil
+	}
+
+	withMedia := settings.FeatureEnabled(e.FeatureMedia)
+	if !withMedia && !msg.HasText() {
+		// A media-only message and media analysis is off in the chat
+		return verdict{Trace: e.Trace{Stage: e.DecisionStageRule}}, nil
+	}
+
+	if s.Webhook != nil {
+		decision, err := s.Webhook.Decide(ctx, webhook.Request{
+			ChatID:    msg.Sender.ChatID,
+			ChatTitle: msg.Sender.ChatTitle,
+			UserID:    msg.Sender.ID,
+			UserName:  msg.Sender.Name,
+			MessageID: msg.ID,
+			Text:      msg.Text,
+			MediaType: msg.MediaType,
+			Score:     score,
+			Features:  extractFeatures(msg),
+		})
+
+		switch {
+		case err != nil && s.WebhookMode == WebhookModeReplace:
+			return verdict{}, fmt.Errorf("asking decision webhook: %w", err)
+		case err != nil:
+			s.log().Warn("decision webhook failed, falling back to ai", "error", err)
+		case !decision.IsAbstain():
+			v = verdict{
+				IsSpam:   decision.Action != e.ActionKindNoop,
+				Category: decision.Category,
+				Note:     decision.Note,
+				Trace:    e.Trace{Stage: e.DecisionStageWebhook},
 			}
+			if decision.Action == e.ActionKindBan {
+				v.Action = e.ActionKindBan
+			}
+			return v, nil
+		case s.WebhookMode == WebhookModeReplace:
+			return verdict{Trace: e.Trace{Stage: e.DecisionStageWebhook}}, nil
 		}
+	}
 
-		if mediaContent != nil {
-			_, err = s.AI.GetJSONCompletionWithImage(ctx, prompt, text, mediaContent, mimeType, ai.SpamCheckFormat, &check)
-		} else {
-			_, err = s.AI.GetJSONCompletion(ctx, prompt, text, ai.SpamCheckFormat, &check)
-		}
+	v, matched, err = s.checkClassifier(ctx, msg)
+	if err != nil {
+		return verdict{}, fmt.Errorf("asking classifier: %w", err)
+	}
+	if matched {
+		return v, nil
+	}
+
+	system := s.selectPrompt(msg, settings)
+	v, matched, err = s.cachedVerdict(ctx, msg, withMedia, system.version)
+	if err != nil {
+		s.log().Warn("reading cached verdict, asking the ai", "error", err)
+	}
+	if matched {
+		return v, nil
+	}
+
+	v, matched = s.checkModeration(ctx, msg, withMedia)
+	if matched {
+		return v, nil
+	}
+
+	report, usage, err := s.checkSpam(ctx, msg, withMedia, system)
+	if errors.Is(err, ai.ErrBudgetExceeded) {
+		s.budgetExceeded(ctx, err)
+
+		// Only the zero-cost rules apply until the budget is renewed, and
+		// the unchecked message doesn't earn trust
+		return verdict{KeepScore: true, Note: "not checked: " + err.Error(), Trace: e.Trace{Stage: e.DecisionStageRule}}, nil
+	}
+	if err != nil && s.AIFailureMode == AIFailureRules && ctx.Err() == nil {
+		s.log().Warn("ai check failed, only the rules apply", "error", err, "chat_id", msg.Sender.ChatID, "message_id", msg.ID)
+		return verdict{KeepScore: true, Note: "not checked: " + err.Error(), Trace: e.Trace{Stage: e.DecisionStageRule}}, nil
+	}
+	if err != nil {
+		return verdict{}, fmt.Errorf("%w: %w", errAICheck, err)
+	}
+
+	if err = s.cacheVerdict(ctx, msg, withMedia, system.version, report, usage); err != nil {
+		s.log().Warn("caching verdict", "error", err)
+	}
+
+	trace := e.Trace{
+		Stage:         e.DecisionStageAI,
+		Model:         usage.Model,
+		PromptVersion: system.version,
+		Language:      system.language,
+		Experiment:    system.experiment,
+		Confidence:    &report.Confidence,
+	}
+
+	if !report.IsSpam && report.NSFW && settings.NSFWAction != "" {
+		v = nsfwVerdict(settings, report.Note, trace)
+		v.Usage = s.aiUsage(msg.Sender.ChatID, usage)
+		return v, nil
+	}
+
+	v = verdict{
+		IsSpam: report.IsSpam,
+		Note:   report.Note,
+		Trace:  trace,
+		Usage:  s.aiUsage(msg.Sender.ChatID, usage),
+	}
+	if report.IsSpam && report.Category != "none" {
+		v.Category = e.SpamCategory(report.Category)
+	}
+
+	return v, nil
+}
+
+// checkTrustedNSFW runs the NSFW-only vision check on media from a trusted
+// user. The message is not stored and the score is left as is.
+func (s *ModeratingSrv) checkTrustedNSFW(ctx context.Context, settings e.ChatSettings, msg e.Message) (e.Action, error) {
+	image, mimeType, err := s.loadImage(ctx, msg)
+	if err != nil {
+		return noop, fmt.Errorf("loading image for nsfw check: %w", err)
+	}
+
+	aiCtx, cancel := s.aiContext(ctx)
+	defer cancel()
+
+	var check ai.NSFWCheck
+	usage, err := s.AI.GetJSONCompletionWithImage(aiCtx, nsfwPrompt, "(analyze image only)", image, mimeType, ai.NSFWCheckFormat, &check)
+	if errors.Is(err, ai.ErrBudgetExceeded) {
+		s.budgetExceeded(ctx, err)
+		return noop, nil
+	}
+	if err != nil {
+		return noop, fmt.Errorf("getting nsfw completion: %w", err)
+	}
+
+	if !check.NSFW {
+		return noop, nil
+	}
+
+	v := nsfwVerdict(settings, check.Note, e.Trace{
+		Stage:         e.DecisionStageAI,
+		Model:         usage.Model,
+		PromptVersion: nsfwPromptVersion,
+	})
+	return e.Action{Kind: v.Action, Note: v.Note, Trace: v.Trace, Usage: s.aiUsage(msg.Sender.ChatID, usage)}, nil
+}
+
+// aiUsage converts the usage of an AI call made for the chat, pricing it
+func (s *ModeratingSrv) aiUsage(chatID string, usage *ai.Usage) *e.AIUsage {
+	if usage == nil {
+		return nil
+	}
+
+	var (
+		result e.AIUsage
+		ok     bool
+	)
+	if s.Costs != nil {
+		result, ok = s.Costs.Track(chatID, *usage)
 	} else {
-		_, err = s.AI.GetJSONCompletion(ctx, prompt, text, ai.SpamCheckFormat, &check)
+		result.PromptTokens, result.CompletionTokens = usage.PromptTokens, usage.CompletionTokens
+		result.Cost, ok = usage.Cost()
+	}
+	if !ok {
+		s.log().Warn("unknown price of the ai model, cost not recorded", "model", usage.Model)
+	}
+
+	return &result
+}
+
+// nsfwVerdict applies the chat's NSFW policy. It is not a judgement of the
+// sender, so the score is kept.
+func nsfwVerdict(settings e.ChatSettings, note string, trace e.Trace) verdict {
+	return verdict{
+		IsSpam:    true,
+		Action:    settings.NSFWAction,
+		KeepScore: true,
+		Note:      "nsfw: " + note,
+		Trace:     trace,
+	}
+}
+
+// spamReport is the AI's answer whether a message is spam
+type spamReport struct {
+	ai.SpamCheck
+
+	// fromHistory is set if the AI looked up the sender's earlier messages,
+	// so the verdict is on the sender as much as on the text
+	fromHistory bool
+}
+
+// checkSpam asks the AI whether the message is spam with the system prompt,
+// analyzing its media too if withMedia is set
+func (s *ModeratingSrv) checkSpam(ctx context.Context, msg e.Message, withMedia bool, system systemPrompt) (spamReport, *ai.Usage, error) {
+	var opts []ai.CallOption
+	if s.ReasoningEffort != "" {
+		opts = append(opts, ai.WithReasoningEffort(s.ReasoningEffort))
+	}
+	check, usage, err := s.askSpam(ctx, msg, withMedia, system.text, opts)
+	if err != nil || check.Confidence >= s.ConfirmBelow {
+		return check, usage, err
+	}
+
+	// An uncertain verdict is checked again with more effort, the model
+	// stays the same so the usage of both adds up
+	confirmed, more, err := s.askSpam(ctx, msg, withMedia, system.text, []ai.CallOption{ai.WithReasoningEffort(s.ConfirmEffort)})
+	if err != nil {
+		s.log().Warn("confirming ai verdict, keeping the first", "error", err, "confidence", check.Confidence)
+		return check, usage, nil
+	}
+	confirmed.fromHistory = confirmed.fromHistory || check.fromHistory
+	return confirmed, addUsage(usage, more), nil
+}
+
+// addUsage returns the usage of two requests to the same model
+func addUsage(a, b *ai.Usage) *ai.Usage {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	sum := *b
+	sum.PromptTokens += a.PromptTokens
+	sum.CompletionTokens += a.CompletionTokens
+	sum.TotalTokens += a.TotalTokens
+	sum.PromptTokensDetails.CachedTokens += a.PromptTokensDetails.CachedTokens
+	return &sum
+}
+
+// askSpam asks the AI whether the message is spam
+func (s *ModeratingSrv) askSpam(ctx context.Context, msg e.Message, withMedia bool, base string, opts []ai.CallOption) (spamReport, *ai.Usage, error) {
+	var check spamReport
+
+	text := msg.Text
+	if s.NormalizeText {
+		text = textnorm.Normalize(text)
+	}
+	text = ai.Truncate(text, s.MaxInputTokens)
+	mapping := s.redaction()
+	text = mapping.Redact(text)
+	if text == "" {
+		text = "(no text, analyze image only)"
 	}
+	system := s.spamPrompt(ctx, msg, base, mapping)
+
+	if withMedia && s.analyzableMedia(msg) {
+		var usage *ai.Usage
+		image, mimeType, err := s.loadImage(ctx, msg)
+
+		aiCtx, cancel := s.aiContext(ctx)
+		defer cancel()
+
+		switch {
+		case errors.Is(err, errMediaConversion) && msg.HasText():
+			// Conversion failed (corrupt media or an unavailable/broken
+			// ffmpeg). If the message has text, degrade to text-only
+			// analysis rather than skipping the spam check entirely -
+			// otherwise spam text could bypass moderation by attaching
+			// an unconvertible file. If the message is media-only there
+			// is nothing real to analyze: report the error so the
+			// failure is visible instead of scoring a placeholder.
+			usage, err = s.completeSpamCheck(aiCtx, msg, mapping, system, text, &check, opts...)
+		case err != nil:
+			return check, nil, err
+		default:
+			if s.ImageDetail != "" {
+				opts = append(slices.Clip(opts), ai.WithImageDetail(s.ImageDetail))
+			}
+			usage, err = s.AI.GetJSONCompletionWithImage(aiCtx, system, text, image, mimeType, ai.SpamCheckFormat, &check.SpamCheck, opts...)
+		}
+		if err != nil {
+			return check, nil, fmt.Errorf("getting completion: %w", err)
+		}
+		check.Note = mapping.Restore(check.Note)
+		return check, usage, nil
+	}
+
+	aiCtx, cancel := s.aiContext(ctx)
+	defer cancel()
 
+	usage, err := s.completeSpamCheck(aiCtx, msg, mapping, system, text, &check, opts...)
 	if err != nil {
-		return check, fmt.Errorf("getting completion: %w", err)
+		return check, nil, fmt.Errorf("getting completion: %w", err)
+	}
+
+	check.Note = mapping.Restore(check.Note)
+	return check, usage, nil
+}
+
+// redaction returns the mapping personal data of an AI check is masked with,
+// nil if it isn't masked
+func (s *ModeratingSrv) redaction() *redact.Mapping {
+	if s.Redactor == nil {
+		return nil
 	}
+	return s.Redactor.NewMapping()
+}
 
-	return check, nil
+// aiContext bounds an AI call by AITimeout
+func (s *ModeratingSrv) aiContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.AITimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.AITimeout)
 }
 
 // maxConvertibleMediaSize bounds media we're willing to download and run
@@ -187,6 +789,43 @@ func (s *ModeratingSrv) checkSpam(ctx context.Context, msg e.Message) (ai.SpamCh
 // video/webm documents or videos that merely share the same mime type.
 const maxConvertibleMediaSize = 512 * 1024
 
+// errAICheck marks a failure of the AI spam check
+var errAICheck = errors.New("checking spam")
+
+// errMediaConversion marks a failure to turn downloaded media into an image
+var errMediaConversion = errors.New("converting media to image")
+
+// loadImage downloads the message media on-demand and returns it as an image
+// the vision API can decode, converting it if needed.
+func (s *ModeratingSrv) loadImage(ctx context.Context, msg e.Message) ([]byte, string, error) {
+	content, err := s.MediaDownloader.DownloadFile(ctx, *msg.MediaFileID)
+	if err != nil {
+		return nil, "", fmt.Errorf("downloading media: %w", err)
+	}
+
+	mimeType := *msg.MediaType
+	if s.canConvertMedia(msg) {
+		// Media the vision API can't decode directly (e.g. video
+		// stickers): extract a still frame and analyze that as JPEG.
+		content, err = s.MediaConverter.ToImage(ctx, content)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", errMediaConversion, err)
+		}
+		mimeType = "image/jpeg"
+	}
+
+	if s.ImageDownscaler == nil {
+		return content, mimeType, nil
+	}
+	small, smallType, err := s.ImageDownscaler.Downscale(content, mimeType)
+	if err != nil {
+		// The vision model can take the image as it is
+		s.log().Warn("downscaling image", "error", err, "mime_type", mimeType)
+		return content, mimeType, nil
+	}
+	return small, smallType, nil
+}
+
 // analyzableMedia reports whether the message carries media the bot can send
 // to the vision pipeline - either a format the vision API supports directly,
 // or one the MediaConverter can turn into a still image.
@@ -213,40 +852,116 @@ func (s *ModeratingSrv) canConvertMedia(msg e.Message) bool {
 	return msg.MediaSize != nil && *msg.MediaSize > 0 && *msg.MediaSize <= maxConvertibleMediaSize
 }
 
-func (s *ModeratingSrv) getNewScore(score int, delta int) int {
+func (s *ModeratingSrv) chatSettings(ctx context.Context, chatID string) (e.ChatSettings, error) {
+	if s.Settings == nil {
+		return e.ChatSettings{}, nil
+	}
+	return s.Settings.GetChatSettings(ctx, chatID)
+}
+
+func (s *ModeratingSrv) log() logger.Logger {
+	if s.Log == nil {
+		return slog.Default()
+	}
+	return s.Log
+}
+
+func (s *ModeratingSrv) getNewScore(settings e.ChatSettings, score int, delta int) int {
 	newScore := score + delta
 
-	if newScore <= s.BanScore {
-		return s.BanScore
+	if banScore := s.banScore(settings); newScore <= banScore {
+		return banScore
 	}
 
-	if newScore >= s.TrustedScore {
-		return s.TrustedScore
+	if trustedScore := s.trustedScore(settings); newScore >= trustedScore {
+		return trustedScore
 	}
 
 	return newScore
 }
 
+// trustedScore returns the chat's trusted score threshold
+func (s *ModeratingSrv) trustedScore(settings e.ChatSettings) int {
+	if settings.TrustedScore != nil {
+		return *settings.TrustedScore
+	}
+	return s.TrustedScore
+}
+
+// banScore returns the chat's ban score threshold
+func (s *ModeratingSrv) banScore(settings e.ChatSettings) int {
+	if settings.BanScore != nil {
+		return *settings.BanScore
+	}
+	return s.BanScore
+}
+
 type ScoreStore interface {
 	GetScore(ctx context.Context, sender e.User, defaultValue int) (int, error)
+	GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error)
 	SetScore(ctx context.Context, sender e.User, score int) error
 }
 
+// scoreInvalidator is a ScoreStore caching scores, which must forget scores
+// stored bypassing it
+type scoreInvalidator interface {
+	Invalidate(user e.User)
+}
+
+// FingerprintMatcher looks texts up among fingerprints of confirmed spam
+type FingerprintMatcher interface {
+	MatchFingerprint(ctx context.Context, text string) (e.SpamFingerprint, bool, error)
+}
+
+// MentionResolver tells @usernames of chats and channels from those of users
+type MentionResolver interface {
+	// ResolveChat returns the ID of the channel or the supergroup with the
+	// username, without the @; found is false if the username is of a user
+	// or a bot
+	ResolveChat(ctx context.Context, username string) (chatID string, found bool, err error)
+}
+
+// UserLocker locks users across replicas of the bot
+type UserLocker interface {
+	// LockUser waits until the user is not locked by another replica and
+	// locks it, the returned function unlocks it
+	LockUser(ctx context.Context, user e.User) (unlock func(), err error)
+}
+
 type MessagesStore interface {
-	SaveMessage(ctx context.Context, msg e.Message) (int64, error)
-	SaveAction(ctx context.Context, messageID int64, action e.Action) error
-	SaveError(ctx context.Context, messageID int64, error string) error
+	// SaveDecision stores the message with its action and the sender's new
+	// score atomically
+	SaveDecision(ctx context.Context, decision e.Decision) (int64, error)
+	CountUserMessages(ctx context.Context, user e.User) (int, error)
+}
+
+type ProbationStore interface {
+	// GetProbation returns how many messages of the user's probation remain,
+	// found is false if the user has never been on probation
+	GetProbation(ctx context.Context, user e.User) (remaining int, found bool, err error)
+	SetProbation(ctx context.Context, user e.User, remaining int) error
 }
 
 type AIClient interface {
-	GetJSONCompletion(ctx context.Context, system, user string, rf ai.ResponseFormat, result any) (*ai.Usage, error)
-	GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ai.ResponseFormat, result any) (*ai.Usage, error)
+	GetJSONCompletion(ctx context.Context, system, user string, rf ai.ResponseFormat, result any, opts ...ai.CallOption) (*ai.Usage, error)
+	GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ai.ResponseFormat, result any, opts ...ai.CallOption) (*ai.Usage, error)
+}
+
+type DecisionWebhook interface {
+	Decide(ctx context.Context, request webhook.Request) (webhook.Decision, error)
 }
 
 type MediaDownloader interface {
 	DownloadFile(ctx context.Context, fileID string) ([]byte, error)
 }
 
+// ImageDownscaler shrinks images larger than the vision model needs
+type ImageDownscaler interface {
+	// Downscale returns the image shrunk with its mime type, or the image
+	// as it is if it's small enough
+	Downscale(content []byte, mimeType string) ([]byte, string, error)
+}
+
 // MediaConverter turns media types the vision API can't decode directly into a
 // still JPEG image (e.g. extracting the first frame of a video sticker).
 type MediaConverter interface {
@@ -263,3 +978,12 @@ var noop = e.Action{
 
 //go:embed system_prompt.txt
 var prompt string
+
+//go:embed nsfw_prompt.txt
+var nsfwPrompt string
+
+// promptVersion and nsfwPromptVersion identify the prompts in decision traces
+var (
+	promptVersion     = ai.PromptVersion(prompt)
+	nsfwPromptVersion = ai.PromptVersion(nsfwPrompt)
+)
@@ -3,10 +3,16 @@ package services
 import (
 	"context"
 	"errors"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"nuclight.org/antispam-tg-bot/pkg/ai"
+	"nuclight.org/antispam-tg-bot/pkg/classifier"
 	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/redact"
+	"nuclight.org/antispam-tg-bot/pkg/webhook"
 )
 
 // fakeAI records how the moderator invoked the vision / text completion calls.
@@ -15,17 +21,34 @@ type fakeAI struct {
 	imageMime   string
 	imageBytes  []byte
 	textCalled  bool
+	system      string
+
+	// check is returned as the spam check result
+	check ai.SpamCheck
+
+	// nsfw is returned as the nsfw check result
+	nsfw ai.NSFWCheck
 }
 
-func (f *fakeAI) GetJSONCompletion(_ context.Context, _, _ string, _ ai.ResponseFormat, _ any) (*ai.Usage, error) {
+func (f *fakeAI) GetJSONCompletion(_ context.Context, system, _ string, _ ai.ResponseFormat, result any, _ ...ai.CallOption) (*ai.Usage, error) {
 	f.textCalled = true
+	f.system = system
+	if check, ok := result.(*ai.SpamCheck); ok {
+		*check = f.check
+	}
 	return &ai.Usage{}, nil
 }
 
-func (f *fakeAI) GetJSONCompletionWithImage(_ context.Context, _, _ string, image []byte, mimeType string, _ ai.ResponseFormat, _ any) (*ai.Usage, error) {
+func (f *fakeAI) GetJSONCompletionWithImage(_ context.Context, _, _ string, image []byte, mimeType string, _ ai.ResponseFormat, result any, _ ...ai.CallOption) (*ai.Usage, error) {
 	f.imageCalled = true
 	f.imageMime = mimeType
 	f.imageBytes = image
+	switch check := result.(type) {
+	case *ai.SpamCheck:
+		*check = f.check
+	case *ai.NSFWCheck:
+		*check = f.nsfw
+	}
 	return &ai.Usage{}, nil
 }
 
@@ -70,7 +93,7 @@ func TestCheckSpam_VideoStickerExtractsFrame(t *testing.T) {
 		MediaConverter:  converter,
 	}
 
-	if _, err := s.checkSpam(context.Background(), mediaMsg("video/webm")); err != nil {
+	if _, _, err := s.checkSpam(context.Background(), mediaMsg("video/webm"), true, basePrompt); err != nil {
 		t.Fatalf("checkSpam: %v", err)
 	}
 
@@ -97,7 +120,7 @@ func TestCheckSpam_DirectImageSkipsConverter(t *testing.T) {
 		MediaConverter:  converter,
 	}
 
-	if _, err := s.checkSpam(context.Background(), mediaMsg("image/webp")); err != nil {
+	if _, _, err := s.checkSpam(context.Background(), mediaMsg("image/webp"), true, basePrompt); err != nil {
 		t.Fatalf("checkSpam: %v", err)
 	}
 
@@ -112,6 +135,41 @@ func TestCheckSpam_DirectImageSkipsConverter(t *testing.T) {
 	}
 }
 
+type fakeDownscaler struct{ err error }
+
+func (f fakeDownscaler) Downscale(content []byte, _ string) ([]byte, string, error) {
+	if f.err != nil {
+		return nil, "", f.err
+	}
+	return append([]byte("small-"), content...), "image/jpeg", nil
+}
+
+func TestCheckSpam_DownscalesImages(t *testing.T) {
+	for _, tc := range []struct {
+		downscaler fakeDownscaler
+		wantBytes  string
+		wantMime   string
+	}{
+		{wantBytes: "small-jpeg-frame", wantMime: "image/jpeg"},
+		{downscaler: fakeDownscaler{err: errors.New("corrupt")}, wantBytes: "jpeg-frame", wantMime: "image/jpeg"},
+	} {
+		aiClient := &fakeAI{}
+		s := &ModeratingSrv{
+			AI:              aiClient,
+			MediaDownloader: &fakeDownloader{content: []byte("webm-bytes")},
+			MediaConverter:  &fakeConverter{convertible: "video/webm", output: []byte("jpeg-frame")},
+			ImageDownscaler: tc.downscaler,
+		}
+
+		if _, _, err := s.checkSpam(context.Background(), mediaMsg("video/webm"), true, basePrompt); err != nil {
+			t.Fatalf("checkSpam: %v", err)
+		}
+		if string(aiClient.imageBytes) != tc.wantBytes || aiClient.imageMime != tc.wantMime {
+			t.Errorf("vision got %q as %s, want %q", aiClient.imageBytes, aiClient.imageMime, tc.wantBytes)
+		}
+	}
+}
+
 func TestCheckSpam_ConversionFailureFallsBackToText(t *testing.T) {
 	// A failed conversion (corrupt webm or broken ffmpeg) must not abort
 	// moderation - the accompanying text still needs to be spam-checked,
@@ -127,7 +185,7 @@ func TestCheckSpam_ConversionFailureFallsBackToText(t *testing.T) {
 	msg := mediaMsg("video/webm")
 	msg.Text = "spammy text"
 
-	if _, err := s.checkSpam(context.Background(), msg); err != nil {
+	if _, _, err := s.checkSpam(context.Background(), msg, true, basePrompt); err != nil {
 		t.Fatalf("checkSpam should not error on conversion failure, got: %v", err)
 	}
 	if !converter.called {
@@ -155,7 +213,7 @@ func TestCheckSpam_MediaOnlyConversionFailureErrors(t *testing.T) {
 
 	msg := mediaMsg("video/webm") // no text
 
-	if _, err := s.checkSpam(context.Background(), msg); err == nil {
+	if _, _, err := s.checkSpam(context.Background(), msg, true, basePrompt); err == nil {
 		t.Fatal("expected error for media-only message with failed conversion, got nil")
 	}
 	if aiClient.imageCalled || aiClient.textCalled {
@@ -184,7 +242,7 @@ func TestCheckSpam_LargeWebMNotConverted(t *testing.T) {
 			msg.MediaSize = size
 			msg.Text = "hi"
 
-			if _, err := s.checkSpam(context.Background(), msg); err != nil {
+			if _, _, err := s.checkSpam(context.Background(), msg, true, basePrompt); err != nil {
 				t.Fatalf("checkSpam: %v", err)
 			}
 
@@ -211,7 +269,7 @@ func TestCheckSpam_VideoStickerWithoutConverterFallsBackToText(t *testing.T) {
 
 	msg := mediaMsg("video/webm")
 	msg.Text = "hello"
-	if _, err := s.checkSpam(context.Background(), msg); err != nil {
+	if _, _, err := s.checkSpam(context.Background(), msg, true, basePrompt); err != nil {
 		t.Fatalf("checkSpam: %v", err)
 	}
 
@@ -222,3 +280,751 @@ func TestCheckSpam_VideoStickerWithoutConverterFallsBackToText(t *testing.T) {
 		t.Error("text completion should be used as fallback")
 	}
 }
+
+type fakeWebhook struct {
+	decision webhook.Decision
+	err      error
+	request  webhook.Request
+}
+
+func (f *fakeWebhook) Decide(_ context.Context, request webhook.Request) (webhook.Decision, error) {
+	f.request = request
+	return f.decision, f.err
+}
+
+func TestReview_Webhook(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       WebhookMode
+		decision   webhook.Decision
+		err        error
+		wantAI     bool
+		wantSpam   bool
+		wantBan    bool
+		wantErrors bool
+	}{
+		{name: "supplement: decision wins", mode: WebhookModeSupplement, decision: webhook.Decision{Action: e.ActionKindErase}, wantSpam: true},
+		{name: "supplement: ban decision", mode: WebhookModeSupplement, decision: webhook.Decision{Action: e.ActionKindBan}, wantSpam: true, wantBan: true},
+		{name: "supplement: abstain falls back to ai", mode: WebhookModeSupplement, wantAI: true},
+		{name: "supplement: failure falls back to ai", mode: WebhookModeSupplement, err: errors.New("down"), wantAI: true},
+		{name: "replace: noop decision", mode: WebhookModeReplace, decision: webhook.Decision{Action: e.ActionKindNoop}},
+		{name: "replace: abstain lets message through", mode: WebhookModeReplace},
+		{name: "replace: failure is an error", mode: WebhookModeReplace, err: errors.New("down"), wantErrors: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			aiClient := &fakeAI{}
+			wh := &fakeWebhook{decision: tc.decision, err: tc.err}
+			s := &ModeratingSrv{AI: aiClient, Webhook: wh, WebhookMode: tc.mode}
+
+			msg := e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: "m1", Text: "see https://example.com @someone"}
+			v, err := s.review(context.Background(), 3, e.ChatSettings{}, msg)
+			if (err != nil) != tc.wantErrors {
+				t.Fatalf("review error = %v, want error: %v", err, tc.wantErrors)
+			}
+
+			if aiClient.textCalled != tc.wantAI {
+				t.Errorf("ai called = %v, want %v", aiClient.textCalled, tc.wantAI)
+			}
+			if v.IsSpam != tc.wantSpam || (v.Action == e.ActionKindBan) != tc.wantBan {
+				t.Errorf("verdict = %+v, want spam=%v ban=%v", v, tc.wantSpam, tc.wantBan)
+			}
+			if wh.request.Score != 3 || wh.request.Features.LinkCount != 1 || wh.request.Features.MentionCount != 1 {
+				t.Errorf("unexpected webhook request: %+v", wh.request)
+			}
+		})
+	}
+}
+
+type fakeScores map[string]int
+
+func (f fakeScores) GetScore(_ context.Context, user e.User, defaultValue int) (int, error) {
+	if score, ok := f[user.ID]; ok {
+		return score, nil
+	}
+	return defaultValue, nil
+}
+
+func (f fakeScores) GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error) {
+	scores := make([]int, len(users))
+	for i, user := range users {
+		scores[i], _ = f.GetScore(ctx, user, defaultValue)
+	}
+	return scores, nil
+}
+
+func (f fakeScores) SetScore(_ context.Context, user e.User, score int) error {
+	f[user.ID] = score
+	return nil
+}
+
+type fakeAudit []e.AuditEntry
+
+func (f *fakeAudit) AppendAudit(_ context.Context, entry e.AuditEntry) error {
+	*f = append(*f, entry)
+	return nil
+}
+
+func TestSeedTrusted(t *testing.T) {
+	scores := fakeScores{"penalized": -1, "trusted": 6}
+	audit := &fakeAudit{}
+	s := &ModeratingSrv{DefaultScore: 0, TrustedScore: 6, ScoreStore: scores, Audit: audit}
+
+	users := []e.User{{ID: "new"}, {ID: "penalized"}, {ID: "trusted"}}
+	if err := s.SeedTrusted(context.Background(), users); err != nil {
+		t.Fatalf("SeedTrusted: %v", err)
+	}
+
+	for _, u := range users {
+		if scores[u.ID] != 6 {
+			t.Errorf("score of %s = %d, want 6", u.ID, scores[u.ID])
+		}
+	}
+
+	// Only actual changes are audited
+	if len(*audit) != 2 || (*audit)[1].UserID != "penalized" || (*audit)[1].Before != "-1" || (*audit)[1].After != "6" {
+		t.Errorf("audit entries = %+v", *audit)
+	}
+}
+
+func TestGetAction_CategoryActions(t *testing.T) {
+	settings := e.ChatSettings{CategoryActions: map[e.SpamCategory]e.ActionKind{
+		e.SpamCategoryPhishing: e.ActionKindBan,
+		e.SpamCategoryAds:      e.ActionKindErase,
+		e.SpamCategoryFlood:    e.ActionKindNoop,
+	}}
+
+	tests := []struct {
+		category  string
+		score     int
+		wantKind  e.ActionKind
+		wantDelta int
+	}{
+		{category: "phishing", score: 3, wantKind: e.ActionKindBan, wantDelta: -5},
+		{category: "ads", score: -1, wantKind: e.ActionKindErase, wantDelta: -1}, // would escalate to a ban otherwise
+		{category: "flood", score: 0, wantKind: e.ActionKindNoop, wantDelta: 0},
+		{category: "gambling", score: -1, wantKind: e.ActionKindBan, wantDelta: -1}, // no override, score ladder
+		{category: "job_scam", score: 3, wantKind: e.ActionKindErase, wantDelta: -1},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.category, func(t *testing.T) {
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				AI: &fakeAI{check: ai.SpamCheck{IsSpam: true, Category: tc.category}},
+			}
+
+			action, delta, err := s.getAction(context.Background(), tc.score, settings, e.Message{Text: "spam"})
+			if err != nil {
+				t.Fatalf("getAction: %v", err)
+			}
+			if action.Kind != tc.wantKind || delta != tc.wantDelta {
+				t.Errorf("got %s with delta %d, want %s with delta %d", action.Kind, delta, tc.wantKind, tc.wantDelta)
+			}
+			if action.Kind != e.ActionKindNoop && string(action.Category) != tc.category {
+				t.Errorf("category = %q, want %q", action.Category, tc.category)
+			}
+		})
+	}
+}
+
+func TestHandleMessage_NSFWFromTrustedUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		nsfwAction e.ActionKind
+		nsfw       bool
+		wantKind   e.ActionKind
+		wantAI     bool
+	}{
+		{name: "policy disabled", nsfw: true, wantKind: e.ActionKindNoop},
+		{name: "nsfw image erased", nsfwAction: e.ActionKindErase, nsfw: true, wantKind: e.ActionKindErase, wantAI: true},
+		{name: "safe image kept", nsfwAction: e.ActionKindErase, wantKind: e.ActionKindNoop, wantAI: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeAI{nsfw: ai.NSFWCheck{NSFW: tc.nsfw, Note: "explicit"}}
+			scores := fakeScores{"1": 6}
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				ScoreStore:      scores,
+				AI:              fake,
+				MediaDownloader: &fakeDownloader{content: []byte("jpeg")},
+				Settings:        fakeSettings{"": {NSFWAction: tc.nsfwAction}},
+			}
+
+			action, err := s.HandleMessage(context.Background(), mediaMsg("image/jpeg"))
+			if err != nil {
+				t.Fatalf("HandleMessage: %v", err)
+			}
+			if action.Kind != tc.wantKind {
+				t.Errorf("action = %s, want %s", action.Kind, tc.wantKind)
+			}
+			if fake.imageCalled != tc.wantAI {
+				t.Errorf("vision called = %v, want %v", fake.imageCalled, tc.wantAI)
+			}
+			if scores["1"] != 6 {
+				t.Errorf("score = %d, want it kept at 6", scores["1"])
+			}
+		})
+	}
+}
+
+func TestGetAction_NSFWKeepsScore(t *testing.T) {
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		AI:              &fakeAI{check: ai.SpamCheck{NSFW: true, Note: "explicit", Category: "none"}},
+		MediaDownloader: &fakeDownloader{content: []byte("jpeg")},
+	}
+
+	settings := e.ChatSettings{NSFWAction: e.ActionKindBan}
+	action, delta, err := s.getAction(context.Background(), 2, settings, mediaMsg("image/jpeg"))
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if action.Kind != e.ActionKindBan || delta != 0 {
+		t.Errorf("got %s with delta %d, want ban with delta 0", action.Kind, delta)
+	}
+
+	// Without the policy an nsfw flag alone is not spam
+	action, delta, err = s.getAction(context.Background(), 2, e.ChatSettings{}, mediaMsg("image/jpeg"))
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if action.Kind != e.ActionKindNoop || delta != 1 {
+		t.Errorf("got %s with delta %d, want noop with delta 1", action.Kind, delta)
+	}
+}
+
+func TestGetAction_ChatThresholds(t *testing.T) {
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		AI: &fakeAI{check: ai.SpamCheck{IsSpam: true, Category: "ads"}},
+	}
+
+	// A ban score of -5 lets a user with score -1 off with an erase
+	banScore := -5
+	settings := e.ChatSettings{BanScore: &banScore}
+	action, _, err := s.getAction(context.Background(), -1, settings, e.Message{Text: "spam"})
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if action.Kind != e.ActionKindErase {
+		t.Errorf("got %s, want erase under the chat ban score", action.Kind)
+	}
+
+	trustedScore := 3
+	settings = e.ChatSettings{TrustedScore: &trustedScore}
+	if got := s.getNewScore(settings, 2, 5); got != trustedScore {
+		t.Errorf("new score = %d, want it capped at the chat trusted score %d", got, trustedScore)
+	}
+}
+
+func TestGetAction_DisabledFeatures(t *testing.T) {
+	tests := []struct {
+		name       string
+		features   map[e.Feature]bool
+		msg        e.Message
+		wantCalled bool
+		wantImage  bool
+	}{
+		{name: "all enabled", msg: mediaMsg("image/jpeg"), wantCalled: true, wantImage: true},
+		{name: "ai disabled", features: map[e.Feature]bool{e.FeatureAI: false}, msg: e.Message{Text: "spam"}},
+		{name: "media disabled, media only", features: map[e.Feature]bool{e.FeatureMedia: false}, msg: mediaMsg("image/jpeg")},
+		{
+			name:       "media disabled, with text",
+			features:   map[e.Feature]bool{e.FeatureMedia: false},
+			msg:        func() e.Message { m := mediaMsg("image/jpeg"); m.Text = "look"; return m }(),
+			wantCalled: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeAI{check: ai.SpamCheck{IsSpam: true, Category: "ads"}}
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				AI:              fake,
+				MediaDownloader: &fakeDownloader{content: []byte("jpeg")},
+			}
+
+			action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{Features: tc.features}, tc.msg)
+			if err != nil {
+				t.Fatalf("getAction: %v", err)
+			}
+			if called := fake.textCalled || fake.imageCalled; called != tc.wantCalled {
+				t.Errorf("ai called = %v, want %v", called, tc.wantCalled)
+			}
+			if fake.imageCalled != tc.wantImage {
+				t.Errorf("image sent = %v, want %v", fake.imageCalled, tc.wantImage)
+			}
+			if !tc.wantCalled && action.Kind != e.ActionKindNoop {
+				t.Errorf("got %s without the ai, want noop", action.Kind)
+			}
+		})
+	}
+}
+
+type fakeLocks struct {
+	locked, unlocked []string
+}
+
+func (f *fakeLocks) LockUser(_ context.Context, user e.User) (func(), error) {
+	f.locked = append(f.locked, user.ID)
+	return func() { f.unlocked = append(f.unlocked, user.ID) }, nil
+}
+
+func TestHandleMessage_LocksUser(t *testing.T) {
+	locks := &fakeLocks{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		ScoreStore:      fakeScores{"1": 6},
+		AI:              &fakeAI{},
+		MediaDownloader: &fakeDownloader{content: []byte("jpeg")},
+		Locks:           locks,
+	}
+
+	if _, err := s.HandleMessage(context.Background(), mediaMsg("image/jpeg")); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if len(locks.locked) != 1 || len(locks.unlocked) != 1 || locks.locked[0] != "1" {
+		t.Errorf("locked %v, unlocked %v, want user 1 locked and unlocked once", locks.locked, locks.unlocked)
+	}
+}
+
+// hungAI answers only when the context is done
+type hungAI struct{ fakeAI }
+
+func (f *hungAI) GetJSONCompletion(ctx context.Context, _, _ string, _ ai.ResponseFormat, _ any, _ ...ai.CallOption) (*ai.Usage, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestHandleMessage_AITimeout(t *testing.T) {
+	tests := []struct {
+		mode     AIFailureMode
+		wantKind e.ActionKind
+		wantErr  bool
+	}{
+		{mode: AIFailureOpen, wantKind: e.ActionKindNoop, wantErr: true},
+		{mode: AIFailureClosed, wantKind: e.ActionKindErase},
+		{mode: AIFailureRules, wantKind: e.ActionKindNoop},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.mode), func(t *testing.T) {
+			scores := fakeScores{"1": 1}
+			messages := &nopMessages{scores: scores}
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				ScoreStore:    scores,
+				MessagesStore: messages,
+				AI:            &hungAI{},
+				AITimeout:     10 * time.Millisecond,
+				AIFailureMode: tc.mode,
+			}
+
+			action, err := s.HandleMessage(context.Background(), e.Message{Sender: e.User{ID: "1"}, Text: "hello"})
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("HandleMessage error = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("error = %v, want the timeout", err)
+			}
+			if action.Kind != tc.wantKind {
+				t.Errorf("action = %s, want %s", action.Kind, tc.wantKind)
+			}
+			if scores["1"] != 1 || messages.count != 1 {
+				t.Errorf("score = %d, decisions %d, want the score kept and the failure stored", scores["1"], messages.count)
+			}
+		})
+	}
+}
+
+type fakeVerdicts map[string]e.CachedVerdict
+
+func (f fakeVerdicts) GetVerdict(_ context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error) {
+	v, ok := f[textHash+"/"+promptVersion]
+	return v, ok, nil
+}
+
+func (f fakeVerdicts) PutVerdict(_ context.Context, v e.CachedVerdict) error {
+	f[v.TextHash+"/"+v.PromptVersion] = v
+	return nil
+}
+
+func TestGetAction_VerdictCache(t *testing.T) {
+	verdicts := fakeVerdicts{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		Verdicts:   verdicts,
+		VerdictTTL: time.Hour,
+	}
+
+	first := &fakeAI{check: ai.SpamCheck{IsSpam: true, Category: "ads", Confidence: 0.8, Note: "ad"}}
+	s.AI = first
+	if _, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "Buy followers cheap"}); err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if !first.textCalled || len(verdicts) != 1 {
+		t.Fatalf("ai called = %v, %d verdicts cached, want the verdict cached", first.textCalled, len(verdicts))
+	}
+
+	// The same text, obfuscated, reuses the verdict
+	second := &fakeAI{}
+	s.AI = second
+	action, delta, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "buy  FOLLOWERS cheap"})
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if second.textCalled {
+		t.Error("ai asked about a text with a cached verdict")
+	}
+	if action.Kind != e.ActionKindErase || action.Category != e.SpamCategoryAds || !action.Trace.Cached || action.Usage != nil || delta != -1 {
+		t.Errorf("action = %+v, delta %d, want the cached spam verdict", action, delta)
+	}
+
+	// Media messages are checked with their media, not by text
+	s.MediaDownloader = &fakeDownloader{content: []byte("jpeg")}
+	msg := mediaMsg("image/jpeg")
+	msg.Text = "Buy followers cheap"
+	if _, _, err = s.getAction(context.Background(), 0, e.ChatSettings{}, msg); err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if !second.imageCalled {
+		t.Error("media message decided by the cached verdict on its text")
+	}
+}
+
+type brokeAI struct{ fakeAI }
+
+func (f *brokeAI) GetJSONCompletion(context.Context, string, string, ai.ResponseFormat, any, ...ai.CallOption) (*ai.Usage, error) {
+	return nil, &ai.BudgetError{Period: "daily", Limit: "$1.00", ResetsAt: time.Date(2025, 3, 2, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestHandleMessage_BudgetExceeded(t *testing.T) {
+	scores := fakeScores{"1": 1}
+	messages := &nopMessages{scores: scores}
+	sender := &fakeSender{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		ScoreStore:    scores,
+		MessagesStore: messages,
+		AI:            &brokeAI{},
+		AIFailureMode: AIFailureClosed,
+		Alerts:        sender,
+		OwnerChatID:   "42",
+	}
+
+	for i := range 2 {
+		action, err := s.HandleMessage(context.Background(), e.Message{Sender: e.User{ID: "1"}, Text: "hello"})
+		if err != nil {
+			t.Fatalf("HandleMessage: %v", err)
+		}
+		if action.Kind != e.ActionKindNoop || !strings.Contains(action.Note, "budget") {
+			t.Errorf("action = %+v, want the message let through unchecked", action)
+		}
+		if scores["1"] != 1 {
+			t.Errorf("score = %d, want it kept", scores["1"])
+		}
+
+		alert, alerted := sender.sent["42"]
+		switch {
+		case i == 0 && !strings.Contains(alert, "daily ai budget of $1.00 exceeded"):
+			t.Errorf("alert = %q, want the budget named", alert)
+		case i == 1 && alerted:
+			t.Error("alerted again, want once per period")
+		}
+		delete(sender.sent, "42")
+	}
+}
+
+type fakeModerator map[string]ai.Moderation
+
+func (f fakeModerator) Moderate(_ context.Context, text string) (ai.Moderation, error) {
+	return f[text], nil
+}
+
+func TestGetAction_Moderation(t *testing.T) {
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		Moderation: fakeModerator{
+			"nude pics here": {Flagged: true, Categories: []string{"sexual"}, Scores: map[string]float64{"sexual": 0.97, "violence": 0.01}},
+			"you fool":       {Flagged: true, Categories: []string{"harassment"}, Scores: map[string]float64{"harassment": 0.6}},
+			"good morning":   {Scores: map[string]float64{"sexual": 0.001, "harassment": 0.002}},
+		},
+		ModerationFlagScore: 0.9,
+		ModerationPassScore: 0.01,
+	}
+
+	tests := []struct {
+		text      string
+		wantKind  e.ActionKind
+		wantAI    bool
+		wantStage e.DecisionStage
+	}{
+		{text: "nude pics here", wantKind: e.ActionKindErase, wantStage: e.DecisionStageModeration},
+		{text: "good morning", wantKind: e.ActionKindNoop, wantStage: e.DecisionStageModeration},
+		// Flagged without confidence goes to the ai
+		{text: "you fool", wantKind: e.ActionKindNoop, wantAI: true, wantStage: e.DecisionStageAI},
+	}
+	for _, tc := range tests {
+		t.Run(tc.text, func(t *testing.T) {
+			llm := &fakeAI{}
+			s.AI = llm
+
+			action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: tc.text})
+			if err != nil {
+				t.Fatalf("getAction: %v", err)
+			}
+			if action.Kind != tc.wantKind || action.Trace.Stage != tc.wantStage || llm.textCalled != tc.wantAI {
+				t.Errorf("action = %+v, ai called %v, want %s by %s", action, llm.textCalled, tc.wantKind, tc.wantStage)
+			}
+		})
+	}
+
+	action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "nude pics here"})
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if action.Category != e.SpamCategoryAdult || action.Note != "moderation: sexual" {
+		t.Errorf("action = %+v, want adult spam", action)
+	}
+}
+
+// effortAI answers by the reasoning effort asked for
+type effortAI struct {
+	fakeAI
+	checks  map[ai.ReasoningEffort]ai.SpamCheck
+	efforts []ai.ReasoningEffort
+}
+
+func (f *effortAI) GetJSONCompletion(_ context.Context, _, _ string, _ ai.ResponseFormat, result any, opts ...ai.CallOption) (*ai.Usage, error) {
+	var o ai.CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	f.efforts = append(f.efforts, o.ReasoningEffort)
+	*result.(*ai.SpamCheck) = f.checks[o.ReasoningEffort]
+	return &ai.Usage{PromptTokens: 90, CompletionTokens: 10, TotalTokens: 100, Model: "gpt-5-mini"}, nil
+}
+
+func TestGetAction_ConfirmUncertain(t *testing.T) {
+	tests := []struct {
+		name        string
+		first       ai.SpamCheck
+		wantEfforts []ai.ReasoningEffort
+		wantKind    e.ActionKind
+		wantTokens  int
+	}{
+		{
+			name:        "confident",
+			first:       ai.SpamCheck{IsSpam: true, Category: "ads", Confidence: 0.9},
+			wantEfforts: []ai.ReasoningEffort{ai.ReasoningEffortMinimal},
+			wantKind:    e.ActionKindErase,
+			wantTokens:  100,
+		},
+		{
+			name:        "uncertain",
+			first:       ai.SpamCheck{IsSpam: true, Category: "ads", Confidence: 0.5},
+			wantEfforts: []ai.ReasoningEffort{ai.ReasoningEffortMinimal, ai.ReasoningEffortHigh},
+			wantKind:    e.ActionKindNoop,
+			wantTokens:  200,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &effortAI{checks: map[ai.ReasoningEffort]ai.SpamCheck{
+				ai.ReasoningEffortMinimal: tc.first,
+				ai.ReasoningEffortHigh:    {IsSpam: false, Confidence: 0.95},
+			}}
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				AI:              fake,
+				ReasoningEffort: ai.ReasoningEffortMinimal,
+				ConfirmBelow:    0.7,
+				ConfirmEffort:   ai.ReasoningEffortHigh,
+			}
+
+			action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "Buy followers cheap"})
+			if err != nil {
+				t.Fatalf("getAction: %v", err)
+			}
+			if !slices.Equal(fake.efforts, tc.wantEfforts) {
+				t.Errorf("efforts = %v, want %v", fake.efforts, tc.wantEfforts)
+			}
+			if action.Kind != tc.wantKind {
+				t.Errorf("action = %s, want %s", action.Kind, tc.wantKind)
+			}
+			if action.Usage == nil || action.Usage.PromptTokens+action.Usage.CompletionTokens != tc.wantTokens {
+				t.Errorf("usage = %+v, want %d tokens", action.Usage, tc.wantTokens)
+			}
+		})
+	}
+}
+
+func TestHandleMessage_FakeAI(t *testing.T) {
+	scores := fakeScores{}
+	fake := ai.NewFake("casino")
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		ScoreStore:    scores,
+		MessagesStore: &nopMessages{scores: scores},
+		AI:            fake,
+	}
+
+	for _, tc := range []struct {
+		text     string
+		wantKind e.ActionKind
+	}{
+		{text: "hello everyone", wantKind: e.ActionKindNoop},
+		{text: "Best Casino bonus, DM me", wantKind: e.ActionKindErase},
+		{text: "casino again", wantKind: e.ActionKindErase},
+		{text: "and casino once more", wantKind: e.ActionKindBan},
+	} {
+		action, err := s.HandleMessage(context.Background(), e.Message{Sender: e.User{ID: "1"}, Text: tc.text})
+		if err != nil {
+			t.Fatalf("HandleMessage(%q): %v", tc.text, err)
+		}
+		if action.Kind != tc.wantKind {
+			t.Errorf("HandleMessage(%q) = %s, want %s", tc.text, action.Kind, tc.wantKind)
+		}
+	}
+	if calls := fake.Calls(); len(calls) != 4 {
+		t.Errorf("ai asked %d times, want every message checked", len(calls))
+	}
+}
+
+func TestGetAction_Redaction(t *testing.T) {
+	redactor, err := redact.NewRedactor()
+	if err != nil {
+		t.Fatalf("NewRedactor: %v", err)
+	}
+	fake := ai.NewFake()
+	if err = fake.Script(ai.SpamCheck{IsSpam: true, Category: "job_scam", Confidence: 0.9, Note: "asks to call [PHONE_1]"}); err != nil {
+		t.Fatalf("Script: %v", err)
+	}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		AI:       fake,
+		Redactor: redactor,
+	}
+
+	action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "Easy job, call +7 900 123-45-67 or mail hr@example.com"})
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 || calls[0].User != "Easy job, call [PHONE_1] or mail [EMAIL_1]" {
+		t.Errorf("calls = %+v, want the personal data masked", calls)
+	}
+	if action.Kind != e.ActionKindErase || action.Note != "asks to call +7 900 123-45-67" {
+		t.Errorf("action = %+v, want the note with the phone restored", action)
+	}
+}
+
+func TestLocalizedPrompt(t *testing.T) {
+	fake := ai.NewFake()
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		AI:               fake,
+		LocalizedPrompts: true,
+	}
+
+	msg := e.Message{Text: "Шукаю людей для віддаленої роботи, пишіть в особисті"}
+	action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{Language: "ru"}, msg)
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("calls = %d, want 1", len(calls))
+	}
+	if !strings.Contains(calls[0].System, languagePrompts["uk"]) || !strings.Contains(calls[0].System, "write the `note` in Russian") {
+		t.Errorf("system prompt lacks the ukrainian section or the note language:\n%s", calls[0].System)
+	}
+	if action.Trace.Language != "uk" || action.Trace.PromptVersion == promptVersion {
+		t.Errorf("trace = %+v, want the language and the version of the variant", action.Trace)
+	}
+
+	if p := s.selectPrompt(e.Message{Text: "👍"}, e.ChatSettings{}); p != basePrompt {
+		t.Errorf("selectPrompt of an undetected language = %+v, want the base prompt", p)
+	}
+}
+
+func TestMaxInputTokens(t *testing.T) {
+	fake := ai.NewFake()
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		AI:             fake,
+		MaxInputTokens: 50,
+	}
+
+	text := "Easy money! " + strings.Repeat("lorem ipsum dolor sit amet ", 200) + "write me @scammer"
+	if _, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: text}); err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 1 {
+		t.Fatalf("calls = %d, want 1", len(calls))
+	}
+	user := calls[0].User
+	if ai.CountTokens(user) > 50 || !strings.HasPrefix(user, "Easy money!") || !strings.HasSuffix(user, "write me @scammer") {
+		t.Errorf("the ai got %q, want the head and the tail of the text within 50 tokens", user)
+	}
+}
+
+type fakeClassifier struct {
+	verdict classifier.Verdict
+	err     error
+}
+
+func (f fakeClassifier) Classify(context.Context, classifier.Request) (classifier.Verdict, error) {
+	return f.verdict, f.err
+}
+
+func TestClassifier(t *testing.T) {
+	msg := e.Message{Text: "Easy money, DM me"}
+	tests := []struct {
+		name       string
+		classifier fakeClassifier
+		mode       ClassifierMode
+		wantStage  e.DecisionStage
+		wantErr    bool
+	}{
+		{"confident", fakeClassifier{verdict: classifier.Verdict{IsSpam: true, Confidence: 0.95, Category: e.SpamCategoryJobScam}}, ClassifierModeBefore, e.DecisionStageClassifier, false},
+		{"uncertain", fakeClassifier{verdict: classifier.Verdict{IsSpam: true, Confidence: 0.6}}, ClassifierModeBefore, e.DecisionStageAI, false},
+		{"failed", fakeClassifier{err: errors.New("down")}, ClassifierModeBefore, e.DecisionStageAI, false},
+		{"replace uncertain", fakeClassifier{verdict: classifier.Verdict{IsSpam: true, Confidence: 0.6}}, ClassifierModeReplace, e.DecisionStageClassifier, false},
+		{"replace failed", fakeClassifier{err: errors.New("down")}, ClassifierModeReplace, "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				AI:                   ai.NewFake("money"),
+				Classifier:           tc.classifier,
+				ClassifierMode:       tc.mode,
+				ClassifierConfidence: 0.9,
+			}
+			action, _, err := s.getAction(context.Background(), 0, e.ChatSettings{}, msg)
+			if tc.wantErr {
+				if err == nil {
+					t.Error("getAction succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("getAction: %v", err)
+			}
+			if action.Kind != e.ActionKindErase || action.Trace.Stage != tc.wantStage {
+				t.Errorf("action = %s by %s, want erase by %s", action.Kind, action.Trace.Stage, tc.wantStage)
+			}
+		})
+	}
+}
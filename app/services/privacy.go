@@ -0,0 +1,70 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// UserDataStore erases users' data
+type UserDataStore interface {
+	DeleteUserData(ctx context.Context, chatID, userID string) (e.ErasureResult, error)
+}
+
+// ScoreCache caches users' scores in front of the store
+type ScoreCache interface {
+	Invalidate(user e.User)
+	Purge()
+}
+
+// PrivacySrv erases users' data on their or an admin's request
+type PrivacySrv struct {
+	Store UserDataStore
+
+	// Cache is the score cache to drop erased scores from, optional
+	Cache ScoreCache
+
+	// Audit records erasures, optional
+	Audit AuditLog
+}
+
+// ForgetUser erases the user's data in the chat, or in all chats if chatID
+// is empty, on the actor's request
+func (s *PrivacySrv) ForgetUser(ctx context.Context, actor, chatID, userID string) (e.ErasureResult, error) {
+	result, err := s.Store.DeleteUserData(ctx, chatID, userID)
+	if err != nil {
+		return result, fmt.Errorf("deleting user data: %w", err)
+	}
+
+	if s.Cache != nil {
+		if chatID != "" {
+			s.Cache.Invalidate(e.User{ID: userID, ChatID: chatID})
+		} else {
+			s.Cache.Purge()
+		}
+	}
+
+	if s.Audit == nil {
+		return result, nil
+	}
+
+	reason := "erased on the user's request"
+	if actor != userID {
+		reason = "erased on an admin's request"
+	}
+	err = s.Audit.AppendAudit(ctx, e.AuditEntry{
+		Kind:   e.AuditKindErasure,
+		Actor:  actor,
+		ChatID: chatID,
+		UserID: userID,
+		After:  strconv.FormatInt(result.Messages, 10) + " messages",
+		Reason: reason,
+	})
+	if err != nil {
+		return result, fmt.Errorf("recording audit entry: %w", err)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,60 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeUserData struct{ chatID, userID string }
+
+func (f *fakeUserData) DeleteUserData(_ context.Context, chatID, userID string) (e.ErasureResult, error) {
+	f.chatID, f.userID = chatID, userID
+	return e.ErasureResult{Messages: 3, Scores: 1}, nil
+}
+
+type fakeScoreCache struct {
+	invalidated []e.User
+	purged      bool
+}
+
+func (f *fakeScoreCache) Invalidate(user e.User) { f.invalidated = append(f.invalidated, user) }
+func (f *fakeScoreCache) Purge()                 { f.purged = true }
+
+func TestPrivacySrv_ForgetUser(t *testing.T) {
+	tests := []struct {
+		name        string
+		actor       string
+		chatID      string
+		wantPurged  bool
+		wantReason  string
+		invalidated int
+	}{
+		{name: "user request in all chats", actor: "7", wantPurged: true, wantReason: "erased on the user's request"},
+		{name: "admin request in a chat", actor: "1", chatID: "-100", invalidated: 1, wantReason: "erased on an admin's request"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			store := &fakeUserData{}
+			cache := &fakeScoreCache{}
+			audit := &fakeAudit{}
+			s := &PrivacySrv{Store: store, Cache: cache, Audit: audit}
+
+			result, err := s.ForgetUser(context.Background(), tc.actor, tc.chatID, "7")
+			if err != nil {
+				t.Fatalf("ForgetUser: %v", err)
+			}
+			if result.Messages != 3 || store.chatID != tc.chatID || store.userID != "7" {
+				t.Errorf("erased %+v in %q of %q", result, store.chatID, store.userID)
+			}
+			if cache.purged != tc.wantPurged || len(cache.invalidated) != tc.invalidated {
+				t.Errorf("cache purged %v, invalidated %v", cache.purged, cache.invalidated)
+			}
+			if len(*audit) != 1 || (*audit)[0].Kind != e.AuditKindErasure || (*audit)[0].Reason != tc.wantReason {
+				t.Errorf("audit = %+v", *audit)
+			}
+		})
+	}
+}
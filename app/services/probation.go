@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// probationState is a user's probation at the time a message arrives
+type probationState struct {
+	// enabled is set if the chat has the probation policy configured
+	enabled bool
+
+	// started is set if the user has ever been put on probation
+	started bool
+
+	// remaining is how many messages of the probation are left
+	remaining int
+}
+
+func (s *ModeratingSrv) getProbation(ctx context.Context, settings e.ChatSettings, user e.User) (probationState, error) {
+	if s.Probations == nil || !settings.Probation.Enabled() {
+		return probationState{}, nil
+	}
+
+	remaining, found, err := s.Probations.GetProbation(ctx, user)
+	if err != nil {
+		return probationState{}, err
+	}
+
+	return probationState{enabled: true, started: found, remaining: remaining}, nil
+}
+
+// applyProbation adjusts the action and score delta of a message under the
+// chat's probation policy and advances the user's probation. The first
+// regular penalty of a user is replaced by a probation; during probation
+// clean messages earn no score and actions are held for a while.
+func (s *ModeratingSrv) applyProbation(ctx context.Context, settings e.ChatSettings, user e.User, p probationState, action *e.Action, delta *int) error {
+	if !p.enabled {
+		return nil
+	}
+
+	switch {
+	case p.remaining > 0:
+		if *delta > 0 {
+			*delta = 0
+		}
+		if action.Kind != e.ActionKindNoop {
+			action.Delay = settings.Probation.Hold()
+		}
+
+		err := s.Probations.SetProbation(ctx, user, p.remaining-1)
+		if err != nil {
+			return fmt.Errorf("advancing probation: %w", err)
+		}
+	case !p.started && action.Kind == e.ActionKindErase && *delta < 0:
+		*delta = 0
+		action.Note = fmt.Sprintf("%s (first offense, probation for %d messages)", action.Note, settings.Probation.Messages)
+
+		err := s.Probations.SetProbation(ctx, user, settings.Probation.Messages)
+		if err != nil {
+			return fmt.Errorf("starting probation: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeProbations map[string]int
+
+func (f fakeProbations) GetProbation(_ context.Context, user e.User) (int, bool, error) {
+	remaining, ok := f[user.ID]
+	return remaining, ok, nil
+}
+
+func (f fakeProbations) SetProbation(_ context.Context, user e.User, remaining int) error {
+	f[user.ID] = remaining
+	return nil
+}
+
+type nopMessages struct {
+	count  int
+	scores fakeScores
+}
+
+func (f *nopMessages) SaveDecision(_ context.Context, decision e.Decision) (int64, error) {
+	f.count++
+	if decision.Score != nil && f.scores != nil {
+		f.scores[decision.Message.Sender.ID] = *decision.Score
+	}
+	return int64(f.count), nil
+}
+func (f *nopMessages) CountUserMessages(context.Context, e.User) (int, error) {
+	return f.count, nil
+}
+
+func TestHandleMessage_Probation(t *testing.T) {
+	fake := &fakeAI{}
+	scores := fakeScores{}
+	probations := fakeProbations{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 2, BanScore: -2,
+		ScoreStore:    scores,
+		MessagesStore: &nopMessages{scores: scores},
+		AI:            fake,
+		Probations:    probations,
+		Settings:      fakeSettings{"": {Probation: &e.Probation{Messages: 2, HoldSeconds: 30}}},
+	}
+	msg := e.Message{Sender: e.User{ID: "1"}, Text: "hello"}
+
+	steps := []struct {
+		name          string
+		spam          bool
+		wantKind      e.ActionKind
+		wantScore     int
+		wantRemaining int
+		wantDelay     time.Duration
+	}{
+		{name: "first offense starts probation", spam: true, wantKind: e.ActionKindErase, wantScore: 0, wantRemaining: 2},
+		{name: "clean message earns no score", wantKind: e.ActionKindNoop, wantScore: 0, wantRemaining: 1},
+		{name: "offense on probation is penalized and held", spam: true, wantKind: e.ActionKindErase, wantScore: -1, wantRemaining: 0, wantDelay: 30 * time.Second},
+		{name: "served probation earns score", wantKind: e.ActionKindNoop, wantScore: 0, wantRemaining: 0},
+		{name: "second offense follows the ladder", spam: true, wantKind: e.ActionKindErase, wantScore: -1, wantRemaining: 0},
+	}
+
+	for _, step := range steps {
+		fake.check = ai.SpamCheck{IsSpam: step.spam, Category: "none"}
+
+		action, err := s.HandleMessage(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("%s: HandleMessage: %v", step.name, err)
+		}
+		if action.Kind != step.wantKind || action.Delay != step.wantDelay {
+			t.Errorf("%s: got %s delayed by %s, want %s delayed by %s", step.name, action.Kind, action.Delay, step.wantKind, step.wantDelay)
+		}
+		if scores["1"] != step.wantScore {
+			t.Errorf("%s: score = %d, want %d", step.name, scores["1"], step.wantScore)
+		}
+		if probations["1"] != step.wantRemaining {
+			t.Errorf("%s: remaining = %d, want %d", step.name, probations["1"], step.wantRemaining)
+		}
+	}
+}
+
+func TestHandleMessage_ProbationChecksTrustedUsers(t *testing.T) {
+	fake := &fakeAI{check: ai.SpamCheck{IsSpam: true, Category: "none"}}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 2, BanScore: -2,
+		ScoreStore:    fakeScores{"1": 2},
+		MessagesStore: &nopMessages{},
+		AI:            fake,
+		Probations:    fakeProbations{"1": 1},
+		Settings:      fakeSettings{"": {Probation: &e.Probation{Messages: 2}}},
+	}
+
+	action, err := s.HandleMessage(context.Background(), e.Message{Sender: e.User{ID: "1"}, Text: "spam"})
+	if err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if !fake.textCalled || action.Kind != e.ActionKindErase {
+		t.Errorf("got %s (checked: %v), want a checked and erased message", action.Kind, fake.textCalled)
+	}
+}
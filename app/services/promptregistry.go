@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// BuiltinPromptName names the system prompt the bot is built with
+const BuiltinPromptName = "builtin"
+
+// Arms of a prompt experiment recorded in decision traces
+const (
+	ExperimentControl   = "control"
+	ExperimentCandidate = "candidate"
+)
+
+// RegisteredPrompt is a version of the system prompt of the AI spam check
+type RegisteredPrompt struct {
+	Name    string
+	Version string
+	Text    string
+}
+
+// PromptRegistry keeps the versions of the system prompt of the AI spam
+// check: the built-in one and those loaded from files. A prompt is referred
+// to by its name or its version.
+type PromptRegistry struct {
+	prompts map[string]RegisteredPrompt // by name
+}
+
+// NewPromptRegistry returns a registry of the built-in prompt
+func NewPromptRegistry() *PromptRegistry {
+	return &PromptRegistry{prompts: map[string]RegisteredPrompt{
+		BuiltinPromptName: {Name: BuiltinPromptName, Version: promptVersion, Text: prompt},
+	}}
+}
+
+// LoadDir registers the prompts of the .txt files of the directory, named by
+// the files without the extension
+func (r *PromptRegistry) LoadDir(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return fmt.Errorf("listing prompts: %w", err)
+	}
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".txt")
+		if name == BuiltinPromptName {
+			return fmt.Errorf("prompt %s: the name is reserved for the built-in prompt", path)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading prompt: %w", err)
+		}
+		text := string(data)
+		if strings.TrimSpace(text) == "" {
+			return fmt.Errorf("prompt %s is empty", path)
+		}
+		r.prompts[name] = RegisteredPrompt{Name: name, Version: ai.PromptVersion(text), Text: text}
+	}
+	return nil
+}
+
+// Get returns the prompt of the name or the version
+func (r *PromptRegistry) Get(ref string) (RegisteredPrompt, bool) {
+	if p, ok := r.prompts[ref]; ok {
+		return p, true
+	}
+	for _, p := range r.prompts {
+		if p.Version == ref {
+			return p, true
+		}
+	}
+	return RegisteredPrompt{}, false
+}
+
+// List returns the prompts by name
+func (r *PromptRegistry) List() []RegisteredPrompt {
+	list := make([]RegisteredPrompt, 0, len(r.prompts))
+	for _, p := range r.prompts {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// PromptExperiment routes a share of the AI spam checks to a candidate
+// prompt, the rest going with the moderator's prompt. Decision traces record
+// the arm and the prompt version of each verdict, so the prompts can be
+// compared on the stored decisions.
+type PromptExperiment struct {
+	Candidate RegisteredPrompt
+
+	// Percent of the checked messages the candidate gets, from 0 to 100
+	Percent int
+}
+
+// Validate checks the experiment's settings
+func (x *PromptExperiment) Validate() error {
+	if x.Candidate.Text == "" {
+		return errors.New("candidate prompt is empty")
+	}
+	if x.Percent < 0 || x.Percent > 100 {
+		return fmt.Errorf("candidate percent %d is not between 0 and 100", x.Percent)
+	}
+	return nil
+}
+
+// arm returns the arm of the experiment the message is in. The message's
+// chat and ID pick it, so a message checked again stays in its arm.
+func (x *PromptExperiment) arm(msg e.Message) string {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(msg.Sender.ChatID + ":" + msg.ID))
+	if int(h.Sum32()%100) < x.Percent {
+		return ExperimentCandidate
+	}
+	return ExperimentControl
+}
+
+// SetPrompt replaces the prompt and the experiment of the AI spam check while
+// messages are handled, e.g. with prompts reloaded from their directory.
+// Checks already started finish with the previous ones.
+func (s *ModeratingSrv) SetPrompt(prompt RegisteredPrompt, experiment *PromptExperiment) {
+	s.promptMu.Lock()
+	s.Prompt, s.Experiment = prompt, experiment
+	s.promptMu.Unlock()
+}
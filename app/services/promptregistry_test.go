@@ -0,0 +1,94 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestPromptRegistry(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "strict.txt"), []byte("be strict"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewPromptRegistry()
+	if err := r.LoadDir(dir); err != nil {
+		t.Fatalf("LoadDir: %v", err)
+	}
+
+	p, ok := r.Get("strict")
+	if !ok || p.Text != "be strict" || p.Version != ai.PromptVersion("be strict") {
+		t.Errorf("Get(strict) = %+v, %v", p, ok)
+	}
+	if byVersion, ok := r.Get(p.Version); !ok || byVersion != p {
+		t.Errorf("Get(%s) = %+v, %v, want the strict prompt", p.Version, byVersion, ok)
+	}
+	if builtin, ok := r.Get(BuiltinPromptName); !ok || builtin.Version != promptVersion {
+		t.Errorf("Get(builtin) = %+v, %v", builtin, ok)
+	}
+	if list := r.List(); len(list) != 2 || list[0].Name != BuiltinPromptName || list[1].Name != "strict" {
+		t.Errorf("List() = %+v", list)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, BuiltinPromptName+".txt"), []byte("x"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LoadDir(dir); err == nil {
+		t.Error("LoadDir overriding the built-in prompt succeeded")
+	}
+}
+
+func TestPromptExperiment(t *testing.T) {
+	candidate := RegisteredPrompt{Name: "candidate", Version: ai.PromptVersion("be strict"), Text: "be strict"}
+	s := &ModeratingSrv{Experiment: &PromptExperiment{Candidate: candidate, Percent: 30}}
+
+	candidates := 0
+	for i := 0; i < 1000; i++ {
+		msg := e.Message{Sender: e.User{ChatID: "-100"}, ID: strconv.Itoa(i)}
+		p := s.selectPrompt(msg, e.ChatSettings{})
+		if p != s.selectPrompt(msg, e.ChatSettings{}) {
+			t.Fatalf("message %s switched arms", msg.ID)
+		}
+		switch p.experiment {
+		case ExperimentCandidate:
+			candidates++
+			if p.version != candidate.Version || p.text != candidate.Text {
+				t.Fatalf("candidate arm got prompt %s", p.version)
+			}
+		case ExperimentControl:
+			if p.version != promptVersion {
+				t.Fatalf("control arm got prompt %s", p.version)
+			}
+		default:
+			t.Fatalf("arm = %q", p.experiment)
+		}
+	}
+	if candidates < 200 || candidates > 400 {
+		t.Errorf("candidate got %d of 1000 checks, want about 300", candidates)
+	}
+
+	if err := (&PromptExperiment{Candidate: candidate, Percent: 101}).Validate(); err == nil {
+		t.Error("Validate of 101 percent succeeded")
+	}
+}
+
+func TestModeratingSrv_SetPrompt(t *testing.T) {
+	strict := RegisteredPrompt{Name: "strict", Version: ai.PromptVersion("be strict"), Text: "be strict"}
+	s := &ModeratingSrv{Experiment: &PromptExperiment{Candidate: strict, Percent: 100}}
+	msg := e.Message{Sender: e.User{ChatID: "-100"}, ID: "1"}
+
+	s.SetPrompt(strict, nil)
+	if p := s.selectPrompt(msg, e.ChatSettings{}); p.version != strict.Version || p.experiment != "" {
+		t.Errorf("selectPrompt() = %s in arm %q, want the strict prompt outside an experiment", p.version, p.experiment)
+	}
+
+	s.SetPrompt(RegisteredPrompt{}, nil)
+	if p := s.selectPrompt(msg, e.ChatSettings{}); p.version != promptVersion {
+		t.Errorf("selectPrompt() = %s, want the built-in prompt", p.version)
+	}
+}
@@ -0,0 +1,79 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// retentionInterval is how often RetentionSrv enforces the policy
+const retentionInterval = 24 * time.Hour
+
+// RetentionSrv periodically prunes stored messages according to the
+// retention policy
+type RetentionSrv struct {
+	Log logger.Logger
+
+	// Store prunes messages
+	Store Pruner
+
+	// Policy is the retention policy to enforce
+	Policy e.RetentionPolicy
+
+	// Vacuumer gives the space of the pruned data back after a run that
+	// removed any, nil keeps it for new data
+	Vacuumer Vacuumer
+}
+
+type Pruner interface {
+	Prune(ctx context.Context, policy e.RetentionPolicy) (e.PruneResult, error)
+}
+
+type Vacuumer interface {
+	Vacuum(ctx context.Context) (e.VacuumResult, error)
+}
+
+// Run prunes messages on start and then daily until the context is canceled
+func (s *RetentionSrv) Run(ctx context.Context) {
+	if s.Policy.IsZero() {
+		return
+	}
+
+	for {
+		result, err := s.Store.Prune(ctx, s.Policy)
+		if err != nil {
+			s.Log.Error("pruning messages", "error", err)
+		} else {
+			s.Log.Info(
+				"messages pruned",
+				"bodies_erased", result.BodiesErased,
+				"rows_deleted", result.RowsDeleted,
+				"raw_updates_deleted", result.RawUpdatesDeleted,
+				"verdicts_deleted", result.VerdictsDeleted,
+			)
+			s.vacuum(ctx, result)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retentionInterval):
+		}
+	}
+}
+
+// vacuum rebuilds the database if the prune removed anything
+func (s *RetentionSrv) vacuum(ctx context.Context, pruned e.PruneResult) {
+	if s.Vacuumer == nil || pruned == (e.PruneResult{}) {
+		return
+	}
+
+	result, err := s.Vacuumer.Vacuum(ctx)
+	if err != nil {
+		s.Log.Error("vacuuming database", "error", err)
+		return
+	}
+	s.Log.Info("database vacuumed", "size_before", result.SizeBefore, "size_after", result.SizeAfter, "reclaimed", result.Reclaimed())
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// newUserMessages is how many first messages of a user the link-only rule
+// applies to
+const newUserMessages = 2
+
+// checkLinkOnly applies the link-only rule: a forward or a message that is
+// nothing but links, sent as one of the first messages of a user, is spam
+// without asking the AI. It reports whether the rule matched.
+func (s *ModeratingSrv) checkLinkOnly(ctx context.Context, settings e.ChatSettings, msg e.Message) (verdict, bool, error) {
+	if settings.LinkOnlyAction == "" || !isLinkOnly(msg) {
+		return verdict{}, false, nil
+	}
+
+	// The current message is stored with the decision after the review, so
+	// only the earlier messages are counted
+	count, err := s.MessagesStore.CountUserMessages(ctx, msg.Sender)
+	if err != nil {
+		return verdict{}, false, fmt.Errorf("counting user messages: %w", err)
+	}
+
+	if count >= newUserMessages {
+		return verdict{}, false, nil
+	}
+
+	note := "link-only message from a new user"
+	if msg.IsForward {
+		note = "forwarded message from a new user"
+	}
+
+	return verdict{
+		IsSpam: true,
+		Action: settings.LinkOnlyAction,
+		Note:   note,
+		Trace:  e.Trace{Stage: e.DecisionStageRule, Rule: "link_only"},
+	}, true, nil
+}
+
+// checkFingerprint applies the fingerprint rule: a re-post of confirmed
+// spam, even an obfuscated one, is spam without asking the AI. It reports
+// whether the rule matched.
+func (s *ModeratingSrv) checkFingerprint(ctx context.Context, msg e.Message) (verdict, bool, error) {
+	if s.Fingerprints == nil || !msg.HasText() {
+		return verdict{}, false, nil
+	}
+
+	fp, found, err := s.Fingerprints.MatchFingerprint(ctx, msg.Text)
+	if err != nil || !found {
+		return verdict{}, false, err
+	}
+
+	return verdict{
+		IsSpam:   true,
+		Category: fp.Category,
+		Note:     "re-post of known spam",
+		Trace:    e.Trace{Stage: e.DecisionStageRule, Rule: "fingerprint"},
+	}, true, nil
+}
+
+var (
+	inviteLinkRe = regexp.MustCompile(`(?i)(?:\b(?:t\.me|telegram\.me|telegram\.dog)/(?:\+|joinchat/)|tg://join\?invite=)[\w-]+`)
+	publicLinkRe = regexp.MustCompile(`(?i)(?:\b(?:t\.me|telegram\.me|telegram\.dog)/|tg://resolve\?domain=)[a-z][\w]{3,31}\b`)
+)
+
+// maxResolvedMentions bounds the @usernames of a message the invite link
+// rule looks up
+const maxResolvedMentions = 3
+
+// checkInviteLinks applies the invite link rule: a message advertising a
+// Telegram chat or channel, in its text, behind a text link or by an
+// @mention, is spam without asking the AI. It reports whether the rule
+// matched.
+func (s *ModeratingSrv) checkInviteLinks(ctx context.Context, settings e.ChatSettings, msg e.Message) (verdict, bool) {
+	action := settings.InviteLinkAction
+	if action == "" || action == e.ActionKindNoop {
+		return verdict{}, false
+	}
+
+	note := findChatAdvertising(msg)
+	if note == "" {
+		note = s.findChatMention(ctx, msg)
+	}
+	if note == "" {
+		return verdict{}, false
+	}
+
+	return verdict{
+		IsSpam:   true,
+		Action:   action,
+		Category: e.SpamCategoryAds,
+		Note:     note,
+		Trace:    e.Trace{Stage: e.DecisionStageRule, Rule: "invite_link"},
+	}, true
+}
+
+// findChatAdvertising describes the first chat link found in the message,
+// empty if there is none.
+func findChatAdvertising(msg e.Message) string {
+	candidates := append([]string{textnorm.Normalize(msg.Text)}, msg.Links...)
+	for _, text := range candidates {
+		if inviteLinkRe.MatchString(text) {
+			return "telegram invite link"
+		}
+	}
+	for _, text := range candidates {
+		if publicLinkRe.MatchString(text) {
+			return "telegram chat link"
+		}
+	}
+	return ""
+}
+
+// findChatMention describes the first mention of a channel or a supergroup
+// other than the message's chat, empty if there is none. Mentioned usernames
+// are resolved with s.Mentions, a username failing to resolve is skipped.
+func (s *ModeratingSrv) findChatMention(ctx context.Context, msg e.Message) string {
+	if s.Mentions == nil {
+		return ""
+	}
+
+	for _, username := range mentionedUsernames(msg) {
+		chatID, found, err := s.Mentions.ResolveChat(ctx, username)
+		if err != nil {
+			s.log().Warn("resolving mention, skipping it", "error", err, "username", username)
+			continue
+		}
+		if found && chatID != msg.Sender.ChatID {
+			return "mention of chat or channel @" + username
+		}
+	}
+	return ""
+}
+
+// chatMentionRe matches @usernames in a text; the character before the @ is
+// matched to tell a mention from an email
+var chatMentionRe = regexp.MustCompile(`(?:^|[^\p{L}\p{N}_.%+-])@([A-Za-z][A-Za-z0-9_]{3,31})\b`)
+
+// mentionedUsernames returns the distinct usernames the message mentions,
+// marked by Telegram or found in its text, at most maxResolvedMentions
+func mentionedUsernames(msg e.Message) []string {
+	usernames := slices.Clone(msg.Mentions)
+	for _, match := range chatMentionRe.FindAllStringSubmatch(textnorm.Normalize(msg.Text), -1) {
+		usernames = append(usernames, match[1])
+	}
+
+	var distinct []string
+	for _, username := range usernames {
+		username = strings.ToLower(username)
+		if !slices.Contains(distinct, username) {
+			distinct = append(distinct, username)
+		}
+		if len(distinct) == maxResolvedMentions {
+			break
+		}
+	}
+	return distinct
+}
+
+// checkQuietHours applies the quiet hours media rule: during the chat's quiet
+// hours media messages are removed without an AI call. It reports whether
+// the rule matched.
+func checkQuietHours(settings e.ChatSettings, msg e.Message, now time.Time) (verdict, bool, error) {
+	q := settings.QuietHours
+	if q == nil || q.MediaAction == "" || !msg.HasMedia() {
+		return verdict{}, false, nil
+	}
+
+	loc, err := settings.Location()
+	if err != nil {
+		return verdict{}, false, fmt.Errorf("loading chat location: %w", err)
+	}
+
+	if !q.Contains(now.In(loc)) {
+		return verdict{}, false, nil
+	}
+
+	return verdict{
+		IsSpam:    true,
+		Action:    q.MediaAction,
+		KeepScore: true,
+		Note:      "media during quiet hours",
+		Trace:     e.Trace{Stage: e.DecisionStageRule, Rule: "quiet_hours"},
+	}, true, nil
+}
+
+// isLinkOnly reports whether the message is a forward or its text consists of
+// links only (ignoring whitespace, punctuation and emoji).
+func isLinkOnly(msg e.Message) bool {
+	if msg.IsForward {
+		return true
+	}
+
+	text := textnorm.Normalize(msg.Text)
+	if !linkRe.MatchString(text) {
+		return false
+	}
+
+	rest := linkRe.ReplaceAllString(text, "")
+	return !strings.ContainsFunc(rest, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeMessages struct {
+	MessagesStore
+	count int
+}
+
+func (f *fakeMessages) CountUserMessages(context.Context, e.User) (int, error) {
+	return f.count, nil
+}
+
+func TestIsLinkOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  e.Message
+		want bool
+	}{
+		{name: "bare link", msg: e.Message{Text: "https://example.com/join"}, want: true},
+		{name: "links and punctuation", msg: e.Message{Text: "👉 t.me/somechannel !!!"}, want: true},
+		{name: "forward", msg: e.Message{Text: "anything", IsForward: true}, want: true},
+		{name: "link with text", msg: e.Message{Text: "look at this https://example.com"}},
+		{name: "no link", msg: e.Message{Text: "hello"}},
+		{name: "empty", msg: e.Message{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isLinkOnly(tc.msg); got != tc.want {
+				t.Errorf("isLinkOnly = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReview_LinkOnlyRule(t *testing.T) {
+	msg := e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, Text: "https://example.com"}
+	enabled := e.ChatSettings{LinkOnlyAction: e.ActionKindMute}
+
+	tests := []struct {
+		name     string
+		settings e.ChatSettings
+		count    int
+		wantAI   bool
+	}{
+		{name: "first message", settings: enabled, count: 0},
+		{name: "second message", settings: enabled, count: 1},
+		{name: "third message goes to ai", settings: enabled, count: 2, wantAI: true},
+		{name: "rule disabled", count: 0, wantAI: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			aiClient := &fakeAI{}
+			s := &ModeratingSrv{AI: aiClient, MessagesStore: &fakeMessages{count: tc.count}}
+
+			v, err := s.review(context.Background(), 0, tc.settings, msg)
+			if err != nil {
+				t.Fatalf("review: %v", err)
+			}
+
+			if aiClient.textCalled != tc.wantAI {
+				t.Errorf("ai called = %v, want %v", aiClient.textCalled, tc.wantAI)
+			}
+			if !tc.wantAI && (!v.IsSpam || v.Action != e.ActionKindMute) {
+				t.Errorf("verdict = %+v, want spam with mute", v)
+			}
+		})
+	}
+}
+
+func TestCheckQuietHours(t *testing.T) {
+	settings := e.ChatSettings{
+		Timezone:   "Asia/Tbilisi", // UTC+4
+		QuietHours: &e.QuietHours{From: "23:00", To: "07:00", MediaAction: e.ActionKindErase},
+	}
+	media := e.Message{MediaType: strptr("image/jpeg")}
+
+	tests := []struct {
+		name string
+		msg  e.Message
+		now  time.Time
+		want bool
+	}{
+		{name: "night, before midnight", msg: media, now: time.Date(2026, 1, 1, 19, 30, 0, 0, time.UTC), want: true},
+		{name: "night, after midnight", msg: media, now: time.Date(2026, 1, 1, 2, 59, 0, 0, time.UTC), want: true},
+		{name: "window end is exclusive", msg: media, now: time.Date(2026, 1, 1, 3, 0, 0, 0, time.UTC)},
+		{name: "day", msg: media, now: time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{name: "text at night", msg: e.Message{Text: "hi"}, now: time.Date(2026, 1, 1, 20, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, matched, err := checkQuietHours(settings, tc.msg, tc.now)
+			if err != nil {
+				t.Fatalf("checkQuietHours: %v", err)
+			}
+			if matched != tc.want {
+				t.Errorf("matched = %v, want %v", matched, tc.want)
+			}
+			if matched && (!v.KeepScore || v.Action != e.ActionKindErase) {
+				t.Errorf("verdict = %+v, want erase keeping the score", v)
+			}
+		})
+	}
+}
+
+// fakeMentions resolves the usernames of chats to their IDs
+type fakeMentions map[string]string
+
+func (f fakeMentions) ResolveChat(_ context.Context, username string) (string, bool, error) {
+	chatID, ok := f[username]
+	return chatID, ok, nil
+}
+
+func TestHandleMessage_CaptionlessMedia(t *testing.T) {
+	// A video the AI can't analyze still goes through the zero-cost rules
+	now := time.Now().UTC()
+	quiet := &e.QuietHours{From: now.Add(-time.Hour).Format("15:04"), To: now.Add(time.Hour).Format("15:04"), MediaAction: e.ActionKindErase}
+	video := mediaMsg("video/mp4")
+	forward := mediaMsg("video/mp4")
+	forward.IsForward = true
+
+	tests := []struct {
+		name      string
+		settings  e.ChatSettings
+		msg       e.Message
+		wantKind  e.ActionKind
+		wantSaved bool
+	}{
+		{name: "quiet hours", settings: e.ChatSettings{QuietHours: quiet}, msg: video, wantKind: e.ActionKindErase, wantSaved: true},
+		{name: "forward from a new user", settings: e.ChatSettings{LinkOnlyAction: e.ActionKindMute}, msg: forward, wantKind: e.ActionKindMute, wantSaved: true},
+		{name: "no rule matched", settings: e.ChatSettings{LinkOnlyAction: e.ActionKindMute}, msg: video, wantKind: e.ActionKindNoop},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			aiClient := &fakeAI{}
+			scores := fakeScores{}
+			messages := &nopMessages{scores: scores}
+			s := &ModeratingSrv{
+				DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+				ScoreStore:    scores,
+				MessagesStore: messages,
+				AI:            aiClient,
+				Settings:      fakeSettings{"": tc.settings},
+			}
+
+			action, err := s.HandleMessage(context.Background(), tc.msg)
+			if err != nil {
+				t.Fatalf("HandleMessage: %v", err)
+			}
+			if action.Kind != tc.wantKind {
+				t.Errorf("action = %s, want %s", action.Kind, tc.wantKind)
+			}
+			if aiClient.textCalled || aiClient.imageCalled {
+				t.Error("the ai was asked about media it can't analyze")
+			}
+			if saved := messages.count > 0; saved != tc.wantSaved {
+				t.Errorf("saved = %v, want %v", saved, tc.wantSaved)
+			}
+			if tc.wantKind == e.ActionKindNoop && len(scores) > 0 {
+				t.Errorf("scores = %v, want the score left as is", scores)
+			}
+		})
+	}
+}
+
+func TestCheckInviteLinks(t *testing.T) {
+	settings := e.ChatSettings{InviteLinkAction: e.ActionKindBan}
+	s := &ModeratingSrv{Mentions: fakeMentions{"bestdeals": "-200", "ourchat": "-100"}}
+
+	tests := []struct {
+		name     string
+		msg      e.Message
+		settings e.ChatSettings
+		want     bool
+	}{
+		{name: "invite link", msg: e.Message{Text: "join us t.me/+AbCdEf123"}, settings: settings, want: true},
+		{name: "legacy invite link", msg: e.Message{Text: "https://telegram.me/joinchat/AbCdEf"}, settings: settings, want: true},
+		{name: "public channel link", msg: e.Message{Text: "best deals at https://t.me/bestdeals"}, settings: settings, want: true},
+		{name: "channel mention", msg: e.Message{Text: "subscribe to @bestdeals"}, settings: settings, want: true},
+		{name: "channel mention entity", msg: e.Message{Text: "subscribe", Mentions: []string{"BestDeals"}}, settings: settings, want: true},
+		{name: "user mention", msg: e.Message{Text: "@alice thanks!", Mentions: []string{"alice"}}, settings: settings},
+		{name: "email", msg: e.Message{Text: "write me at bestdeals@example.com"}, settings: settings},
+		{name: "email of a chat's name", msg: e.Message{Text: "write me at john@bestdeals.com"}, settings: settings},
+		{name: "own chat mention", msg: e.Message{Sender: e.User{ChatID: "-100"}, Text: "welcome to @ourchat"}, settings: settings},
+		{name: "hidden text link", msg: e.Message{Text: "click here", Links: []string{"https://t.me/+AbCdEf"}}, settings: settings, want: true},
+		{name: "invisible characters", msg: e.Message{Text: "t.me/\u200B+AbCdEf"}, settings: settings, want: true},
+		{name: "other link", msg: e.Message{Text: "see https://example.com", Links: []string{"https://example.org"}}, settings: settings},
+		{name: "plain text", msg: e.Message{Text: "hello everyone"}, settings: settings},
+		{name: "allowed", msg: e.Message{Text: "t.me/+AbCdEf"}, settings: e.ChatSettings{InviteLinkAction: e.ActionKindNoop}},
+		{name: "disabled", msg: e.Message{Text: "t.me/+AbCdEf"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v, matched := s.checkInviteLinks(context.Background(), tc.settings, tc.msg)
+			if matched != tc.want {
+				t.Fatalf("matched = %v, want %v", matched, tc.want)
+			}
+			if matched && v.Action != e.ActionKindBan {
+				t.Errorf("action = %s, want ban", v.Action)
+			}
+		})
+	}
+
+	// Mentions can't be told from mentions of users without a resolver
+	s.Mentions = nil
+	if _, matched := s.checkInviteLinks(context.Background(), settings, e.Message{Text: "subscribe to @bestdeals"}); matched {
+		t.Error("mention matched without a resolver")
+	}
+}
+
+type fakeFingerprints map[string]e.SpamCategory
+
+func (f fakeFingerprints) MatchFingerprint(_ context.Context, text string) (e.SpamFingerprint, bool, error) {
+	category, ok := f[text]
+	return e.SpamFingerprint{Category: category}, ok, nil
+}
+
+func TestGetAction_Fingerprint(t *testing.T) {
+	fake := &fakeAI{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		AI:           fake,
+		Fingerprints: fakeFingerprints{"join our crypto signals channel": e.SpamCategoryCryptoScam},
+	}
+
+	action, delta, err := s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "join our crypto signals channel"})
+	if err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if action.Kind != e.ActionKindErase || action.Category != e.SpamCategoryCryptoScam || action.Trace.Rule != "fingerprint" || delta != -1 {
+		t.Errorf("action = %+v, delta %d, want an erase by the fingerprint rule", action, delta)
+	}
+	if fake.textCalled {
+		t.Error("ai asked about known spam")
+	}
+
+	if _, _, err = s.getAction(context.Background(), 0, e.ChatSettings{}, e.Message{Text: "hello everyone"}); err != nil {
+		t.Fatalf("getAction: %v", err)
+	}
+	if !fake.textCalled {
+		t.Error("ai not asked about an unknown text")
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeHistory map[e.User][]e.SavedMessage
+
+func (f fakeHistory) ListUserMessages(_ context.Context, user e.User, limit int) ([]e.SavedMessage, error) {
+	messages := f[user]
+	return messages[:min(limit, len(messages))], nil
+}
+
+type fakeUnwrapper map[string]string
+
+func (f fakeUnwrapper) Unwrap(_ context.Context, link string) (string, error) {
+	if final, ok := f[link]; ok {
+		return final, nil
+	}
+	return link, nil
+}
+
+// toolAI calls every tool offered with the arguments by tool name and
+// records the results
+type toolAI struct {
+	fakeAI
+	args    map[string]string
+	results map[string]string
+}
+
+func (f *toolAI) GetJSONCompletionWithTools(ctx context.Context, _, _ string, tools []ai.Tool, _ ai.ResponseFormat, result any, _ ...ai.CallOption) (*ai.Usage, error) {
+	f.results = make(map[string]string)
+	for _, tool := range tools {
+		out, err := tool.Call(ctx, json.RawMessage(f.args[tool.Name()]))
+		if err != nil {
+			out = "error: " + err.Error()
+		}
+		f.results[tool.Name()] = out
+	}
+	*result.(*ai.SpamCheck) = f.check
+	return &ai.Usage{}, nil
+}
+
+func TestHandleMessage_Tools(t *testing.T) {
+	sender := e.User{ID: "1", ChatID: "10"}
+	noop, erase := e.ActionKind(e.ActionKindNoop), e.ActionKind(e.ActionKindErase)
+	category := e.SpamCategoryCryptoScam
+	at := time.Date(2026, 10, 1, 12, 30, 0, 0, time.UTC)
+
+	scores := fakeScores{}
+	fake := &toolAI{
+		fakeAI: fakeAI{check: ai.SpamCheck{IsSpam: true, Category: "crypto_scam"}},
+		args: map[string]string{
+			"recent_messages": `{"count": 10}`,
+			"resolve_link":    `{"url": "https://bit.ly/abc"}`,
+		},
+	}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		ScoreStore:    scores,
+		MessagesStore: &nopMessages{scores: scores},
+		AI:            fake,
+		History: fakeHistory{
+			sender: {
+				{Text: "buy my coins", CreatedAt: at, Action: &erase, Category: &category},
+				{Text: "hi all", CreatedAt: at, Action: &noop},
+			},
+			{ID: "2", ChatID: "10"}: {{Text: "someone else's"}},
+		},
+		Unwrapper: fakeUnwrapper{"https://bit.ly/abc": "https://www.scam.example/offer"},
+	}
+
+	action, err := s.HandleMessage(context.Background(), e.Message{Sender: sender, Text: "great deal https://bit.ly/abc!"})
+	if err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if action.Kind == e.ActionKindNoop {
+		t.Errorf("action = %+v, want the message removed", action)
+	}
+
+	want := "2026-10-01 12:30, removed as crypto_scam spam: buy my coins\n2026-10-01 12:30, let through: hi all\n"
+	if got := fake.results["recent_messages"]; got != want {
+		t.Errorf("recent_messages = %q, want %q", got, want)
+	}
+	if got := fake.results["resolve_link"]; got != "leads to https://www.scam.example/offer (domain scam.example)" {
+		t.Errorf("resolve_link = %q, want the destination", got)
+	}
+
+	// Links the message doesn't contain aren't resolved
+	fake.args["resolve_link"] = `{"url": "http://169.254.169.254/latest"}`
+	if _, err = s.HandleMessage(context.Background(), e.Message{Sender: sender, Text: "see t.me/channel"}); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if got := fake.results["resolve_link"]; !strings.HasPrefix(got, "error: only links of the checked message") {
+		t.Errorf("resolve_link = %q, want it refused", got)
+	}
+
+	// Without links there is nothing to resolve
+	if _, err = s.HandleMessage(context.Background(), e.Message{Sender: sender, Text: "hello"}); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if _, offered := fake.results["resolve_link"]; offered {
+		t.Error("resolve_link offered for a message without links")
+	}
+}
+
+func TestHandleMessage_ToolsVerdictCache(t *testing.T) {
+	sender := e.User{ID: "1", ChatID: "10"}
+	msg := e.Message{Sender: sender, Text: "great deal https://bit.ly/abc!"}
+
+	verdicts := fakeVerdicts{}
+	scores := fakeScores{}
+	s := &ModeratingSrv{
+		DefaultScore: 0, TrustedScore: 6, BanScore: -2,
+		ScoreStore:    scores,
+		MessagesStore: &nopMessages{scores: scores},
+		AI:            &toolAI{fakeAI: fakeAI{check: ai.SpamCheck{IsSpam: true}}, args: map[string]string{"recent_messages": `{"count": 5}`}},
+		History:       fakeHistory{sender: {{Text: "buy my coins"}}},
+		Unwrapper:     fakeUnwrapper{},
+		Verdicts:      verdicts,
+		VerdictTTL:    time.Hour,
+	}
+
+	// A verdict reached from the sender's messages doesn't hold for others
+	if _, err := s.HandleMessage(context.Background(), msg); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if len(verdicts) != 0 {
+		t.Errorf("cached %d verdicts reached from the sender's messages, want none", len(verdicts))
+	}
+
+	// Resolving the message's links keeps the verdict on the text
+	s.History = nil
+	if _, err := s.HandleMessage(context.Background(), msg); err != nil {
+		t.Fatalf("HandleMessage: %v", err)
+	}
+	if len(verdicts) != 1 {
+		t.Errorf("cached %d verdicts, want the verdict on the text", len(verdicts))
+	}
+}
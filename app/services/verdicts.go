@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// VerdictCache keeps AI verdicts on texts by the hash of their normalized
+// text
+type VerdictCache interface {
+	GetVerdict(ctx context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error)
+	PutVerdict(ctx context.Context, v e.CachedVerdict) error
+}
+
+// cacheable reports whether the verdict on the message depends on its text
+// only, so it may be reused for the same text
+func (s *ModeratingSrv) cacheable(msg e.Message, withMedia bool) bool {
+	return s.Verdicts != nil && s.VerdictTTL > 0 && msg.HasText() && !(withMedia && s.analyzableMedia(msg))
+}
+
+// cachedVerdict returns the cached AI verdict on the message's text given with
+// the prompt of the version, found is false if there is none
+func (s *ModeratingSrv) cachedVerdict(ctx context.Context, msg e.Message, withMedia bool, version string) (verdict, bool, error) {
+	if !s.cacheable(msg, withMedia) {
+		return verdict{}, false, nil
+	}
+
+	cached, found, err := s.Verdicts.GetVerdict(ctx, textnorm.Hash(msg.Text), version)
+	if err != nil || !found {
+		return verdict{}, false, err
+	}
+
+	return verdict{
+		IsSpam:   cached.IsSpam,
+		Category: cached.Category,
+		Note:     cached.Note,
+		Trace: e.Trace{
+			Stage:         e.DecisionStageAI,
+			Model:         cached.Model,
+			PromptVersion: cached.PromptVersion,
+			Confidence:    &cached.Confidence,
+			Cached:        true,
+		},
+	}, true, nil
+}
+
+// cacheVerdict caches the AI's report on the message's text, given with the
+// prompt of the version, for VerdictTTL. A report the AI made looking at the
+// sender's earlier messages is not cached, it may not hold for other senders.
+func (s *ModeratingSrv) cacheVerdict(ctx context.Context, msg e.Message, withMedia bool, version string, report spamReport, usage *ai.Usage) error {
+	if !s.cacheable(msg, withMedia) || report.fromHistory {
+		return nil
+	}
+
+	v := e.CachedVerdict{
+		TextHash:      textnorm.Hash(msg.Text),
+		PromptVersion: version,
+		IsSpam:        report.IsSpam,
+		Confidence:    report.Confidence,
+		Note:          report.Note,
+		ExpiresAt:     time.Now().Add(s.VerdictTTL),
+	}
+	if usage != nil {
+		v.Model = usage.Model
+	}
+	if report.IsSpam && report.Category != "none" {
+		v.Category = e.SpamCategory(report.Category)
+	}
+
+	return s.Verdicts.PutVerdict(ctx, v)
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+)
+
+// verificationSweepInterval is how often VerificationSweeper looks for
+// expired challenges
+const verificationSweepInterval = time.Minute
+
+// VerificationSweeper enforces captcha challenges left unanswered past their
+// deadline, including the ones whose deadline passed while the bot was down
+type VerificationSweeper struct {
+	Log logger.Logger
+
+	// Store keeps challenges
+	Store VerificationStore
+
+	// Enforcer removes users who didn't pass the challenge
+	Enforcer VerificationEnforcer
+}
+
+type VerificationStore interface {
+	ListVerifications(ctx context.Context, filter storage.VerificationFilter) ([]e.Verification, error)
+	ResolveVerification(ctx context.Context, id int64, status e.VerificationStatus) (bool, error)
+}
+
+type VerificationEnforcer interface {
+	RemoveUnverified(ctx context.Context, v e.Verification) error
+}
+
+// Run sweeps expired challenges on start and then every minute until the
+// context is canceled
+func (s *VerificationSweeper) Run(ctx context.Context) {
+	for {
+		expired, err := s.Sweep(ctx, time.Now())
+		if err != nil {
+			s.Log.Error("sweeping verifications", "error", err)
+		} else if expired > 0 {
+			s.Log.Info("expired verifications enforced", "count", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(verificationSweepInterval):
+		}
+	}
+}
+
+// Sweep expires pending challenges with the deadline before now and removes
+// their users, returning the number of challenges expired. A challenge
+// answered meanwhile is left alone. A failure to remove one user doesn't
+// prevent the others.
+func (s *VerificationSweeper) Sweep(ctx context.Context, now time.Time) (int, error) {
+	pending, err := s.Store.ListVerifications(ctx, storage.VerificationFilter{
+		Status:         e.VerificationPending,
+		DeadlineBefore: now,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("listing expired verifications: %w", err)
+	}
+
+	var expired int
+	for _, v := range pending {
+		resolved, err := s.Store.ResolveVerification(ctx, v.ID, e.VerificationExpired)
+		if err != nil {
+			return expired, fmt.Errorf("expiring verification %d: %w", v.ID, err)
+		}
+		if !resolved {
+			continue
+		}
+		expired++
+
+		if err = s.Enforcer.RemoveUnverified(ctx, v); err != nil {
+			s.Log.Error("removing unverified user", "chat_id", v.ChatID, "user_id", v.UserID, "error", err)
+		}
+	}
+
+	return expired, nil
+}
@@ -0,0 +1,66 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/app/storage"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeVerifications struct {
+	pending  []e.Verification
+	answered map[int64]bool
+	expired  []int64
+}
+
+func (f *fakeVerifications) ListVerifications(_ context.Context, filter storage.VerificationFilter) ([]e.Verification, error) {
+	var due []e.Verification
+	for _, v := range f.pending {
+		if v.Deadline.Before(filter.DeadlineBefore) {
+			due = append(due, v)
+		}
+	}
+	return due, nil
+}
+
+func (f *fakeVerifications) ResolveVerification(_ context.Context, id int64, _ e.VerificationStatus) (bool, error) {
+	if f.answered[id] {
+		return false, nil
+	}
+	f.expired = append(f.expired, id)
+	return true, nil
+}
+
+type fakeEnforcer struct {
+	removed []string
+}
+
+func (f *fakeEnforcer) RemoveUnverified(_ context.Context, v e.Verification) error {
+	f.removed = append(f.removed, v.UserID)
+	return nil
+}
+
+func TestVerificationSweeper_Sweep(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	store := &fakeVerifications{
+		pending: []e.Verification{
+			{ID: 1, ChatID: "-100", UserID: "1", Deadline: now.Add(-time.Minute)},
+			{ID: 2, ChatID: "-100", UserID: "2", Deadline: now.Add(-time.Hour)},
+			{ID: 3, ChatID: "-100", UserID: "3", Deadline: now.Add(time.Minute)},
+		},
+		answered: map[int64]bool{2: true},
+	}
+	enforcer := &fakeEnforcer{}
+	s := &VerificationSweeper{Log: slog.Default(), Store: store, Enforcer: enforcer}
+
+	expired, err := s.Sweep(context.Background(), now)
+	if err != nil {
+		t.Fatalf("Sweep: %v", err)
+	}
+	if expired != 1 || len(enforcer.removed) != 1 || enforcer.removed[0] != "1" {
+		t.Errorf("Sweep = %d, removed %v, want only user 1: user 2 answered meanwhile, user 3 has time left", expired, enforcer.removed)
+	}
+}
@@ -0,0 +1,186 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// DefaultCacheTTL is how long DB serves cached settings of a chat before
+// reading them from the store again
+const DefaultCacheTTL = time.Minute
+
+// Store persists settings of chats managed at runtime
+type Store interface {
+	GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, bool, error)
+	SetChatSettings(ctx context.Context, chatID string, settings e.ChatSettings) error
+	DeleteChatSettings(ctx context.Context, chatID string) error
+}
+
+// AuditLog records settings changes
+type AuditLog interface {
+	AppendAudit(ctx context.Context, entry e.AuditEntry) error
+}
+
+// Provider returns settings of a chat
+type Provider interface {
+	GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, error)
+}
+
+// DB serves chat settings stored in the database, falling back to Base for
+// chats without stored settings. Settings are consulted on every message, so
+// they are cached in memory for CacheTTL; changes made through DB are visible
+// at once, changes made to the store directly after the cache expires.
+type DB struct {
+	Store    Store
+	Base     Provider
+	CacheTTL time.Duration
+
+	// Audit records changes made through DB, optional
+	Audit AuditLog
+
+	mu    sync.Mutex
+	cache map[string]cachedSettings
+}
+
+type cachedSettings struct {
+	settings e.ChatSettings
+	expires  time.Time
+}
+
+// GetChatSettings returns stored settings of the chat or settings from Base
+// if there are none
+func (d *DB) GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, error) {
+	now := time.Now()
+
+	d.mu.Lock()
+	cached, ok := d.cache[chatID]
+	d.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.settings, nil
+	}
+
+	settings, found, err := d.Store.GetChatSettings(ctx, chatID)
+	if err != nil {
+		return e.ChatSettings{}, fmt.Errorf("getting stored settings: %w", err)
+	}
+
+	if !found && d.Base != nil {
+		settings, err = d.Base.GetChatSettings(ctx, chatID)
+		if err != nil {
+			return e.ChatSettings{}, err
+		}
+	}
+
+	d.mu.Lock()
+	if d.cache == nil {
+		d.cache = make(map[string]cachedSettings)
+	}
+	d.cache[chatID] = cachedSettings{settings: settings, expires: now.Add(d.cacheTTL())}
+	d.mu.Unlock()
+
+	return settings, nil
+}
+
+// SetChatSettings validates and stores settings of the chat on behalf of the
+// actor
+func (d *DB) SetChatSettings(ctx context.Context, actor, chatID string, settings e.ChatSettings, reason string) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	before, err := d.storedJSON(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	if err = d.Store.SetChatSettings(ctx, chatID, settings); err != nil {
+		return fmt.Errorf("storing settings: %w", err)
+	}
+	d.invalidate(chatID)
+
+	after, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+
+	return d.audit(ctx, actor, chatID, before, string(after), reason)
+}
+
+// DeleteChatSettings removes stored settings of the chat on behalf of the
+// actor, so the chat falls back to Base
+func (d *DB) DeleteChatSettings(ctx context.Context, actor, chatID, reason string) error {
+	before, err := d.storedJSON(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	if err = d.Store.DeleteChatSettings(ctx, chatID); err != nil {
+		return fmt.Errorf("deleting settings: %w", err)
+	}
+	d.invalidate(chatID)
+
+	return d.audit(ctx, actor, chatID, before, "", reason)
+}
+
+// storedJSON returns stored settings of the chat as JSON, empty if there are
+// none
+func (d *DB) storedJSON(ctx context.Context, chatID string) (string, error) {
+	settings, found, err := d.Store.GetChatSettings(ctx, chatID)
+	if err != nil {
+		return "", fmt.Errorf("getting stored settings: %w", err)
+	}
+	if !found {
+		return "", nil
+	}
+
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("encoding settings: %w", err)
+	}
+	return string(data), nil
+}
+
+func (d *DB) audit(ctx context.Context, actor, chatID, before, after, reason string) error {
+	if d.Audit == nil {
+		return nil
+	}
+
+	err := d.Audit.AppendAudit(ctx, e.AuditEntry{
+		Kind:   e.AuditKindSettings,
+		Actor:  actor,
+		ChatID: chatID,
+		Before: before,
+		After:  after,
+		Reason: reason,
+	})
+	if err != nil {
+		return fmt.Errorf("recording audit entry: %w", err)
+	}
+	return nil
+}
+
+// ClearCache drops the cached settings of all chats, e.g. after Base
+// changed, so they are read again
+func (d *DB) ClearCache() {
+	d.mu.Lock()
+	d.cache = nil
+	d.mu.Unlock()
+}
+
+func (d *DB) invalidate(chatID string) {
+	d.mu.Lock()
+	delete(d.cache, chatID)
+	d.mu.Unlock()
+}
+
+func (d *DB) cacheTTL() time.Duration {
+	if d.CacheTTL > 0 {
+		return d.CacheTTL
+	}
+	return DefaultCacheTTL
+}
@@ -0,0 +1,131 @@
+package settings
+
+import (
+	"context"
+	"testing"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type fakeStore struct {
+	chats map[string]e.ChatSettings
+	reads int
+}
+
+func (f *fakeStore) GetChatSettings(_ context.Context, chatID string) (e.ChatSettings, bool, error) {
+	f.reads++
+	s, ok := f.chats[chatID]
+	return s, ok, nil
+}
+
+func (f *fakeStore) SetChatSettings(_ context.Context, chatID string, settings e.ChatSettings) error {
+	f.chats[chatID] = settings
+	return nil
+}
+
+func (f *fakeStore) DeleteChatSettings(_ context.Context, chatID string) error {
+	delete(f.chats, chatID)
+	return nil
+}
+
+func TestDB_StoredSettingsOverrideBase(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStore{chats: map[string]e.ChatSettings{"-1": {Language: "ru"}}}
+	db := &DB{Store: store, Base: &File{defaults: e.ChatSettings{Language: "en"}}}
+
+	for chatID, want := range map[string]string{"-1": "ru", "-2": "en"} {
+		s, err := db.GetChatSettings(ctx, chatID)
+		if err != nil {
+			t.Fatalf("GetChatSettings(%s): %v", chatID, err)
+		}
+		if s.Language != want {
+			t.Errorf("chat %s: language = %q, want %q", chatID, s.Language, want)
+		}
+	}
+}
+
+func TestDB_CachesUntilChanged(t *testing.T) {
+	ctx := context.Background()
+	store := &fakeStore{chats: map[string]e.ChatSettings{}}
+	db := &DB{Store: store}
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.GetChatSettings(ctx, "-1"); err != nil {
+			t.Fatalf("GetChatSettings: %v", err)
+		}
+	}
+	if store.reads != 1 {
+		t.Errorf("store read %d times, want 1", store.reads)
+	}
+
+	if err := db.SetChatSettings(ctx, "admin", "-1", e.ChatSettings{DryRun: true}, "trying the bot"); err != nil {
+		t.Fatalf("SetChatSettings: %v", err)
+	}
+	s, err := db.GetChatSettings(ctx, "-1")
+	if err != nil {
+		t.Fatalf("GetChatSettings: %v", err)
+	}
+	if !s.DryRun {
+		t.Error("settings set through DB must be visible at once")
+	}
+
+	if err = db.SetChatSettings(ctx, "admin", "-1", e.ChatSettings{Announce: "loud"}, ""); err == nil {
+		t.Error("invalid settings must be rejected")
+	}
+}
+
+func TestDB_ReplacedBaseAfterClearCache(t *testing.T) {
+	ctx := context.Background()
+	base := &File{defaults: e.ChatSettings{Language: "en"}}
+	db := &DB{Store: &fakeStore{chats: map[string]e.ChatSettings{}}, Base: base}
+
+	if _, err := db.GetChatSettings(ctx, "-1"); err != nil {
+		t.Fatalf("GetChatSettings: %v", err)
+	}
+
+	reloaded, err := ParseFile([]byte(`{"chats": {"-1": {"language": "ru"}}}`))
+	if err != nil {
+		t.Fatalf("ParseFile: %v", err)
+	}
+	base.Replace(reloaded)
+	db.ClearCache()
+
+	s, err := db.GetChatSettings(ctx, "-1")
+	if err != nil {
+		t.Fatalf("GetChatSettings: %v", err)
+	}
+	if s.Language != "ru" {
+		t.Errorf("language = %q, want the replaced file's", s.Language)
+	}
+}
+
+type fakeAudit []e.AuditEntry
+
+func (f *fakeAudit) AppendAudit(_ context.Context, entry e.AuditEntry) error {
+	*f = append(*f, entry)
+	return nil
+}
+
+func TestDB_AuditsChanges(t *testing.T) {
+	ctx := context.Background()
+	audit := &fakeAudit{}
+	db := &DB{Store: &fakeStore{chats: map[string]e.ChatSettings{}}, Audit: audit}
+
+	if err := db.SetChatSettings(ctx, "42", "-1", e.ChatSettings{Language: "ru"}, "russian chat"); err != nil {
+		t.Fatalf("SetChatSettings: %v", err)
+	}
+	if err := db.DeleteChatSettings(ctx, "42", "-1", "back to defaults"); err != nil {
+		t.Fatalf("DeleteChatSettings: %v", err)
+	}
+
+	if len(*audit) != 2 {
+		t.Fatalf("got %d audit entries, want 2", len(*audit))
+	}
+	set, deleted := (*audit)[0], (*audit)[1]
+	if set.Kind != e.AuditKindSettings || set.Actor != "42" || set.Before != "" || set.After != `{"language":"ru"}` || set.Reason != "russian chat" {
+		t.Errorf("set entry = %+v", set)
+	}
+	if deleted.Before != `{"language":"ru"}` || deleted.After != "" {
+		t.Errorf("delete entry = %+v", deleted)
+	}
+}
@@ -0,0 +1,104 @@
+// Package settings provides per-chat settings for the moderator and the
+// Telegram client.
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// File serves chat settings loaded from a JSON file of the form:
+//
+//	{
+//	  "default": {"mod_log_chat_id": "-100123"},
+//	  "chats": {
+//	    "-100456": {"mod_log_chat_id": "-100789"}
+//	  }
+//	}
+//
+// Chat entries are overlaid on top of the defaults, so a chat only needs to
+// list the fields it changes. A zero File serves zero settings for every chat.
+type File struct {
+	mu       sync.RWMutex
+	defaults e.ChatSettings
+	chats    map[string]e.ChatSettings
+}
+
+type fileContent struct {
+	Default json.RawMessage            `json:"default"`
+	Chats   map[string]json.RawMessage `json:"chats"`
+}
+
+// LoadFile reads and parses a settings file
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading settings file: %w", err)
+	}
+	return ParseFile(data)
+}
+
+// ParseFile parses the JSON content of a settings file
+func ParseFile(data []byte) (*File, error) {
+	var content fileContent
+	err := json.Unmarshal(data, &content)
+	if err != nil {
+		return nil, fmt.Errorf("decoding settings file: %w", err)
+	}
+
+	f := &File{
+		chats: make(map[string]e.ChatSettings, len(content.Chats)),
+	}
+
+	if len(content.Default) > 0 {
+		if err = json.Unmarshal(content.Default, &f.defaults); err != nil {
+			return nil, fmt.Errorf("decoding default settings: %w", err)
+		}
+		if err = f.defaults.Validate(); err != nil {
+			return nil, fmt.Errorf("validating default settings: %w", err)
+		}
+	}
+
+	for chatID, raw := range content.Chats {
+		// Decoding into a copy of the defaults only overwrites the fields
+		// present in the chat entry
+		chat := f.defaults
+		if err = json.Unmarshal(raw, &chat); err != nil {
+			return nil, fmt.Errorf("decoding settings for chat %s: %w", chatID, err)
+		}
+		if err = chat.Validate(); err != nil {
+			return nil, fmt.Errorf("validating settings for chat %s: %w", chatID, err)
+		}
+		f.chats[chatID] = chat
+	}
+
+	return f, nil
+}
+
+// GetChatSettings returns settings for the chat, falling back to defaults
+func (f *File) GetChatSettings(_ context.Context, chatID string) (e.ChatSettings, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if s, ok := f.chats[chatID]; ok {
+		return s, nil
+	}
+	return f.defaults, nil
+}
+
+// Replace serves the settings of the loaded file from now on, e.g. of the
+// file read again while the bot runs
+func (f *File) Replace(loaded *File) {
+	loaded.mu.RLock()
+	defaults, chats := loaded.defaults, loaded.chats
+	loaded.mu.RUnlock()
+
+	f.mu.Lock()
+	f.defaults, f.chats = defaults, chats
+	f.mu.Unlock()
+}
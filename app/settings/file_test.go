@@ -0,0 +1,51 @@
+package settings
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadFile_ChatOverlaysDefaults(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "settings.json")
+	content := `{
+		"default": {"mod_log_chat_id": "-1001"},
+		"chats": {
+			"-1002": {"mod_log_chat_id": "-1003"},
+			"-1004": {}
+		}
+	}`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := LoadFile(path)
+	if err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+
+	for chatID, want := range map[string]string{
+		"-1002": "-1003", // overridden
+		"-1004": "-1001", // listed but inherits the default
+		"-1005": "-1001", // not listed at all
+	} {
+		s, err := f.GetChatSettings(context.Background(), chatID)
+		if err != nil {
+			t.Fatalf("GetChatSettings(%s): %v", chatID, err)
+		}
+		if s.ModLogChatID != want {
+			t.Errorf("chat %s: mod log = %q, want %q", chatID, s.ModLogChatID, want)
+		}
+	}
+}
+
+func TestFile_ZeroValue(t *testing.T) {
+	s, err := (&File{}).GetChatSettings(context.Background(), "-100")
+	if err != nil {
+		t.Fatalf("GetChatSettings: %v", err)
+	}
+	if s.HasModLog() {
+		t.Error("zero File must not enable the mod log")
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// AppendAudit appends an entry to the audit log, its ID and CreatedAt are
+// assigned by the store
+func (c *SQLite) AppendAudit(ctx context.Context, entry e.AuditEntry) error {
+	_, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO audit_log (kind, actor, chat_id, user_id, before, after, reason, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+		string(entry.Kind), entry.Actor, entry.ChatID, entry.UserID, entry.Before, entry.After, entry.Reason,
+	)
+	return err
+}
+
+// AuditFilter selects audit log entries, zero fields don't filter
+type AuditFilter struct {
+	ChatID string
+	UserID string
+	Actor  string
+	Kind   e.AuditKind
+
+	// From and To bound the entry time, To is exclusive
+	From time.Time
+	To   time.Time
+
+	Limit  int
+	Offset int
+}
+
+// ListAudit returns audit log entries matching the filter, newest first
+func (c *SQLite) ListAudit(ctx context.Context, filter AuditFilter) ([]e.AuditEntry, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.ChatID != "" {
+		where = append(where, "chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if filter.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if filter.Kind != "" {
+		where = append(where, "kind = ?")
+		args = append(args, string(filter.Kind))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, formatTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, formatTime(filter.To))
+	}
+
+	query := `SELECT id, kind, actor, chat_id, user_id, before, after, reason, created_at FROM audit_log`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying audit log: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var entries []e.AuditEntry
+	for rows.Next() {
+		var entry e.AuditEntry
+		err = rows.Scan(
+			&entry.ID, &entry.Kind, &entry.Actor, &entry.ChatID, &entry.UserID,
+			&entry.Before, &entry.After, &entry.Reason, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning audit entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over audit log: %w", err)
+	}
+
+	return entries, nil
+}
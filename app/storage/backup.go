@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// Backup writes a consistent copy of the database to destPath using the
+// SQLite online backup API, which is safe while the bot is writing. The copy
+// is written to a temporary file first, so destPath never holds a partial
+// backup. A backup of an encrypted database is encrypted with the same key.
+func (c *SQLite) Backup(ctx context.Context, destPath string) error {
+	tmp := destPath + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing stale temporary file: %w", err)
+	}
+
+	dest, err := openSQLiteDB(tmp, c.key, "")
+	if err != nil {
+		return fmt.Errorf("opening backup file: %w", err)
+	}
+
+	err = copyDatabase(ctx, dest, c.db)
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err = os.Rename(tmp, destPath); err != nil {
+		return fmt.Errorf("moving backup into place: %w", err)
+	}
+
+	return nil
+}
+
+// Restore replaces the database contents with the backup at srcPath after
+// checking its integrity. It must not run while the bot is using the
+// database; the schema of the restored backup may need migrating.
+func (c *SQLite) Restore(ctx context.Context, srcPath string) error {
+	if _, err := os.Stat(srcPath); err != nil {
+		return fmt.Errorf("checking backup file: %w", err)
+	}
+
+	src, err := openSQLiteDB("file:"+srcPath+"?mode=ro", c.key, "")
+	if err != nil {
+		return fmt.Errorf("opening backup file: %w", err)
+	}
+	defer func() { _ = src.Close() }()
+
+	var result string
+	if err = src.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("checking backup integrity: %w", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("backup is corrupted: %s", result)
+	}
+
+	if err = copyDatabase(ctx, c.db, src); err != nil {
+		return err
+	}
+
+	c.forgetChats()
+	return nil
+}
+
+// copyDatabase copies the main database of src into dst with the backup API
+// in a single step. A step holds a read transaction on the source, which in
+// WAL mode doesn't block writers; copying in several steps would restart
+// whenever another connection writes.
+func copyDatabase(ctx context.Context, dst, src *sql.DB) error {
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting source connection: %w", err)
+	}
+	defer func() { _ = srcConn.Close() }()
+
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("getting destination connection: %w", err)
+	}
+	defer func() { _ = dstConn.Close() }()
+
+	return dstConn.Raw(func(dstDriverConn any) error {
+		return srcConn.Raw(func(srcDriverConn any) error {
+			dstSQLite, ok := dstDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected destination connection %T", dstDriverConn)
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected source connection %T", srcDriverConn)
+			}
+
+			backup, err := dstSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("starting backup: %w", err)
+			}
+
+			if _, err = backup.Step(-1); err != nil {
+				_ = backup.Finish()
+				return fmt.Errorf("copying pages: %w", err)
+			}
+
+			if err = backup.Finish(); err != nil {
+				return fmt.Errorf("finishing backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
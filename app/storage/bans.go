@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+const banColumns = `id, chat_id, user_id, reason, issued_by, created_at, expires_at, revoked_at, revoked_by`
+
+// AddBan records a ban and returns its ID, CreatedAt is assigned by the store
+func (c *SQLite) AddBan(ctx context.Context, ban e.Ban) (int64, error) {
+	res, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO bans (chat_id, user_id, reason, issued_by, created_at, expires_at)
+			VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, ?)`,
+		ban.ChatID, ban.UserID, ban.Reason, ban.IssuedBy, nullTime(ban.ExpiresAt),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.LastInsertId()
+}
+
+// GetBan returns the ban by ID, found is false if there is none
+func (c *SQLite) GetBan(ctx context.Context, id int64) (e.Ban, bool, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT `+banColumns+` FROM bans WHERE id = ?`, id)
+	return scanBanRow(row)
+}
+
+// GetActiveBan returns the latest ban of the user in the chat which is in
+// force now, found is false if there is none
+func (c *SQLite) GetActiveBan(ctx context.Context, chatID, userID string) (e.Ban, bool, error) {
+	row := c.db.QueryRowContext(
+		ctx,
+		`SELECT `+banColumns+` FROM bans
+			WHERE chat_id = ? AND user_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)
+			ORDER BY created_at DESC, id DESC
+			LIMIT 1`,
+		chatID, userID, formatTime(time.Now()),
+	)
+	return scanBanRow(row)
+}
+
+// BanFilter selects bans, zero fields don't filter
+type BanFilter struct {
+	ChatID string
+	UserID string
+
+	// Active selects only bans in force now
+	Active bool
+
+	// ExpiresBefore selects bans which are not revoked and expire before the
+	// time, e.g. due for an unban
+	ExpiresBefore time.Time
+
+	Limit  int
+	Offset int
+}
+
+// ListBans returns bans matching the filter, newest first
+func (c *SQLite) ListBans(ctx context.Context, filter BanFilter) ([]e.Ban, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.ChatID != "" {
+		where = append(where, "chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if filter.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Active {
+		where = append(where, "revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)")
+		args = append(args, formatTime(time.Now()))
+	}
+	if !filter.ExpiresBefore.IsZero() {
+		where = append(where, "revoked_at IS NULL AND expires_at < ?")
+		args = append(args, formatTime(filter.ExpiresBefore))
+	}
+
+	query := `SELECT ` + banColumns + ` FROM bans`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying bans: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var bans []e.Ban
+	for rows.Next() {
+		ban, err := scanBan(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning ban: %w", err)
+		}
+		bans = append(bans, ban)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over bans: %w", err)
+	}
+
+	return bans, nil
+}
+
+// UpdateBan changes the reason and the expiry of the ban, found is false if
+// there is no such ban
+func (c *SQLite) UpdateBan(ctx context.Context, ban e.Ban) (bool, error) {
+	res, err := c.db.ExecContext(
+		ctx,
+		"UPDATE bans SET reason = ?, expires_at = ? WHERE id = ?",
+		ban.Reason, nullTime(ban.ExpiresAt), ban.ID,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// RevokeBan lifts the ban, revoked is false if there is no such ban or it is
+// already revoked
+func (c *SQLite) RevokeBan(ctx context.Context, id int64, revokedBy string) (bool, error) {
+	res, err := c.db.ExecContext(
+		ctx,
+		"UPDATE bans SET revoked_at = CURRENT_TIMESTAMP, revoked_by = ? WHERE id = ? AND revoked_at IS NULL",
+		revokedBy, id,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// DeleteBan removes the ban from the registry, e.g. one issued by mistake
+func (c *SQLite) DeleteBan(ctx context.Context, id int64) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM bans WHERE id = ?", id)
+	return err
+}
+
+func scanBanRow(row *sql.Row) (e.Ban, bool, error) {
+	ban, err := scanBan(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.Ban{}, false, nil
+		}
+		return e.Ban{}, false, err
+	}
+
+	return ban, true, nil
+}
+
+func scanBan(row interface{ Scan(dest ...any) error }) (e.Ban, error) {
+	var (
+		ban       e.Ban
+		expiresAt sql.NullTime
+		revokedAt sql.NullTime
+		revokedBy sql.NullString
+	)
+	err := row.Scan(
+		&ban.ID, &ban.ChatID, &ban.UserID, &ban.Reason, &ban.IssuedBy,
+		&ban.CreatedAt, &expiresAt, &revokedAt, &revokedBy,
+	)
+	if err != nil {
+		return e.Ban{}, err
+	}
+
+	if expiresAt.Valid {
+		ban.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		ban.RevokedAt = &revokedAt.Time
+	}
+	ban.RevokedBy = revokedBy.String
+
+	return ban, nil
+}
+
+func nullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: formatTime(*t), Valid: true}
+}
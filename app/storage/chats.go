@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+const chatColumns = `chat_id, title, type, username, member_count, language, joined_at, refreshed_at`
+
+// UpdateChat stores the chat with its metadata. Empty fields keep the stored
+// values, so a partial update such as the join time doesn't erase the rest.
+func (c *SQLite) UpdateChat(ctx context.Context, chat e.Chat) error {
+	var memberCount sql.NullInt64
+	if chat.MemberCount > 0 {
+		memberCount = sql.NullInt64{Int64: int64(chat.MemberCount), Valid: true}
+	}
+
+	_, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO chats (`+chatColumns+`, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(chat_id) DO UPDATE SET
+				title = CASE WHEN excluded.title <> '' THEN excluded.title ELSE title END,
+				type = COALESCE(excluded.type, type),
+				username = COALESCE(excluded.username, username),
+				member_count = COALESCE(excluded.member_count, member_count),
+				language = COALESCE(excluded.language, language),
+				joined_at = COALESCE(excluded.joined_at, joined_at),
+				refreshed_at = COALESCE(excluded.refreshed_at, refreshed_at)`,
+		chat.ID, chat.Title, nullString(chat.Type), nullString(chat.Username), memberCount,
+		nullString(chat.Language), nullZeroTime(chat.JoinedAt), nullZeroTime(chat.RefreshedAt),
+	)
+	if err != nil {
+		return fmt.Errorf("upserting chat: %w", err)
+	}
+
+	if chat.Title != "" {
+		c.mu.Lock()
+		c.chats[chat.ID] = chat.Title
+		c.mu.Unlock()
+	}
+
+	return nil
+}
+
+// GetChat returns the stored chat, found is false if there is none
+func (c *SQLite) GetChat(ctx context.Context, chatID string) (e.Chat, bool, error) {
+	row := c.db.QueryRowContext(ctx, `SELECT `+chatColumns+` FROM chats WHERE chat_id = ?`, chatID)
+
+	chat, err := scanChat(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.Chat{}, false, nil
+		}
+		return e.Chat{}, false, err
+	}
+
+	return chat, true, nil
+}
+
+func (c *SQLite) ListChats(ctx context.Context) ([]e.Chat, error) {
+	rows, err := c.db.QueryContext(ctx, `SELECT `+chatColumns+` FROM chats ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("querying chats: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var chats []e.Chat
+	for rows.Next() {
+		chat, err := scanChat(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning chat: %w", err)
+		}
+		chats = append(chats, chat)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over chats: %w", err)
+	}
+
+	return chats, nil
+}
+
+func scanChat(row interface{ Scan(dest ...any) error }) (e.Chat, error) {
+	var (
+		chat                  e.Chat
+		chatType, username    sql.NullString
+		language              sql.NullString
+		memberCount           sql.NullInt64
+		joinedAt, refreshedAt sql.NullTime
+	)
+	err := row.Scan(
+		&chat.ID, &chat.Title, &chatType, &username, &memberCount,
+		&language, &joinedAt, &refreshedAt,
+	)
+	if err != nil {
+		return e.Chat{}, err
+	}
+
+	chat.Type = chatType.String
+	chat.Username = username.String
+	chat.MemberCount = int(memberCount.Int64)
+	chat.Language = language.String
+	chat.JoinedAt = joinedAt.Time
+	chat.RefreshedAt = refreshedAt.Time
+
+	return chat, nil
+}
+
+// nullZeroTime maps the zero time to NULL
+func nullZeroTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return nullTime(&t)
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrEncryptionUnavailable is returned for a database key when the SQLite
+// library the bot is built with is not SQLCipher
+var ErrEncryptionUnavailable = errors.New("database encryption requires a build linked with SQLCipher")
+
+// SQLiteKeyEnv is the environment variable holding the key of an encrypted
+// database, SQLiteKeyFileEnv names a file holding it
+const (
+	SQLiteKeyEnv     = "DB_KEY"
+	SQLiteKeyFileEnv = "DB_KEY_FILE"
+)
+
+// openSQLiteDB opens the database at the DSN. With a key, every connection of
+// the pool is unlocked with it before use, and the journal mode, which reads
+// the database, is set only afterwards.
+func openSQLiteDB(dsn, key, journalMode string) (*sql.DB, error) {
+	if key == "" {
+		return sql.Open("sqlite3", dsn)
+	}
+
+	return sql.OpenDB(&cipherConnector{dsn: dsn, key: key, journalMode: journalMode}), nil
+}
+
+// cipherConnector opens connections to a database encrypted with SQLCipher
+type cipherConnector struct {
+	dsn         string
+	key         string
+	journalMode string
+	driver      sqlite3.SQLiteDriver
+}
+
+func (c *cipherConnector) Connect(context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.unlock(conn.(*sqlite3.SQLiteConn)); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+func (c *cipherConnector) Driver() driver.Driver {
+	return &c.driver
+}
+
+func (c *cipherConnector) unlock(conn *sqlite3.SQLiteConn) error {
+	// Other SQLite builds ignore the key pragma and would keep the database
+	// in plain text
+	version, err := pragmaValue(conn, "PRAGMA cipher_version")
+	if err != nil {
+		return fmt.Errorf("checking for SQLCipher: %w", err)
+	}
+	if version == "" {
+		return ErrEncryptionUnavailable
+	}
+
+	if _, err = conn.Exec("PRAGMA key = '"+strings.ReplaceAll(c.key, "'", "''")+"'", nil); err != nil {
+		return fmt.Errorf("setting database key: %w", err)
+	}
+
+	// The key is checked on the first read
+	if _, err = conn.Exec("SELECT count(*) FROM sqlite_master", nil); err != nil {
+		return fmt.Errorf("unlocking database, is the key right: %w", err)
+	}
+
+	if c.journalMode != "" {
+		if _, err = conn.Exec("PRAGMA journal_mode = "+c.journalMode, nil); err != nil {
+			return fmt.Errorf("setting journal mode: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pragmaValue returns the first column of the first row of the pragma, empty
+// if it returns no rows
+func pragmaValue(conn *sqlite3.SQLiteConn, pragma string) (string, error) {
+	rows, err := conn.Query(pragma, nil)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = rows.Close() }()
+
+	if len(rows.Columns()) == 0 {
+		return "", nil
+	}
+
+	values := make([]driver.Value, len(rows.Columns()))
+	if err = rows.Next(values); err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	return fmt.Sprint(values[0]), nil
+}
@@ -0,0 +1,188 @@
+package storage
+
+import (
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// defaultSimilarLimit is the number of messages FindSimilar returns if the
+// filter sets no limit
+const defaultSimilarLimit = 10
+
+// StoreEmbedding stores the embedding of a saved message, given by the ID
+// SaveMessage returned, replacing the previous one
+func (c *SQLite) StoreEmbedding(ctx context.Context, messageID int64, model string, vector []float32) error {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return errors.New("zero embedding vector")
+	}
+
+	res, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO embeddings (message_id, chat_id, model, dimensions, norm, vector, created_at)
+			SELECT id, chat_id, ?, ?, ?, ?, CURRENT_TIMESTAMP FROM messages WHERE id = ?
+			ON CONFLICT(message_id) DO UPDATE
+			    SET model = excluded.model, dimensions = excluded.dimensions,
+			        norm = excluded.norm, vector = excluded.vector, created_at = CURRENT_TIMESTAMP`,
+		model, len(vector), norm, encodeVector(vector), messageID,
+	)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("message %d not found", messageID)
+	}
+
+	return nil
+}
+
+// SimilarityFilter selects candidates for FindSimilar, zero fields don't
+// filter
+type SimilarityFilter struct {
+	ChatID string
+
+	// Action keeps only messages that got the action, e.g. erase to match
+	// known spam
+	Action e.ActionKind
+
+	// MinSimilarity drops less similar messages
+	MinSimilarity float64
+
+	// Limit is the number of most similar messages returned, 10 if zero
+	Limit int
+}
+
+// FindSimilar returns stored messages whose embeddings made by the model are
+// the most similar to the vector, most similar first
+func (c *SQLite) FindSimilar(ctx context.Context, model string, vector []float32, filter SimilarityFilter) ([]e.SimilarMessage, error) {
+	norm := vectorNorm(vector)
+	if norm == 0 {
+		return nil, errors.New("zero embedding vector")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultSimilarLimit
+	}
+
+	where := []string{"x.model = ?", "x.dimensions = ?"}
+	args := []any{model, len(vector)}
+	if filter.ChatID != "" {
+		where = append(where, "x.chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if filter.Action != "" {
+		where = append(where, "m.action = ?")
+		args = append(args, string(filter.Action))
+	}
+
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT x.message_id, x.norm, x.vector
+		 FROM embeddings AS x JOIN messages AS m ON m.id = x.message_id
+		 WHERE `+strings.Join(where, " AND "),
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying embeddings: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	// Brute force over the candidates keeping the top ones in a min-heap
+	top := &similarityHeap{}
+	for rows.Next() {
+		var (
+			id            int64
+			candidateNorm float64
+			encoded       []byte
+		)
+		if err = rows.Scan(&id, &candidateNorm, &encoded); err != nil {
+			return nil, fmt.Errorf("scanning embedding: %w", err)
+		}
+
+		similarity := dot(vector, encoded) / (norm * candidateNorm)
+		if similarity < filter.MinSimilarity {
+			continue
+		}
+
+		if top.Len() < limit {
+			heap.Push(top, scored{id: id, similarity: similarity})
+		} else if similarity > (*top)[0].similarity {
+			(*top)[0] = scored{id: id, similarity: similarity}
+			heap.Fix(top, 0)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over embeddings: %w", err)
+	}
+	_ = rows.Close()
+
+	result := make([]e.SimilarMessage, top.Len())
+	for i := len(result) - 1; i >= 0; i-- {
+		s := heap.Pop(top).(scored)
+
+		row := c.db.QueryRowContext(ctx, `SELECT `+messageColumns+` FROM messages AS m WHERE m.id = ?`, s.id)
+		msg, err := scanMessage(row)
+		if err != nil {
+			return nil, fmt.Errorf("getting message %d: %w", s.id, err)
+		}
+		result[i] = e.SimilarMessage{SavedMessage: msg, Similarity: s.similarity}
+	}
+
+	return result, nil
+}
+
+// encodeVector encodes the vector as float32 little-endian
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+// dot returns the dot product of the vector and an encoded one of the same
+// dimensions
+func dot(vector []float32, encoded []byte) float64 {
+	var sum float64
+	for i, v := range vector {
+		sum += float64(v) * float64(math.Float32frombits(binary.LittleEndian.Uint32(encoded[4*i:])))
+	}
+	return sum
+}
+
+func vectorNorm(vector []float32) float64 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum)
+}
+
+type scored struct {
+	id         int64
+	similarity float64
+}
+
+// similarityHeap is a min-heap of scored messages by similarity
+type similarityHeap []scored
+
+func (h similarityHeap) Len() int           { return len(h) }
+func (h similarityHeap) Less(i, j int) bool { return h[i].similarity < h[j].similarity }
+func (h similarityHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *similarityHeap) Push(x any)        { *h = append(*h, x.(scored)) }
+func (h *similarityHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// ExampleFilter selects messages exported as labeled examples, zero fields
+// don't filter
+type ExampleFilter struct {
+	ChatID string
+
+	// From and To bound the message time, To is exclusive
+	From time.Time
+	To   time.Time
+
+	// PerLabel caps the number of examples of each label, newest are kept
+	PerLabel int
+
+	// Balance takes as many examples of each label as there are of the
+	// rarest one
+	Balance bool
+
+	// Overridden selects only messages whose decisions admins overrode
+	Overridden bool
+}
+
+// labelExpr labels a message of messages aliased as m by its latest admin
+// override, or by its action if it was not overridden
+const labelExpr = `COALESCE(
+	(SELECT CASE WHEN o.kind = '` + string(e.OverrideKindConfirmSpam) + `' THEN 'spam' ELSE 'ham' END
+	 FROM overrides AS o WHERE o.message_id = m.id ORDER BY o.created_at DESC, o.id DESC LIMIT 1),
+	CASE WHEN m.action = 'noop' THEN 'ham' ELSE 'spam' END)`
+
+// ListExamples returns decided messages as labeled examples, oldest first.
+// Admin overrides take precedence over the bot's actions. Messages the check
+// failed on, unless overridden, and messages whose bodies were erased by
+// retention are skipped.
+func (c *SQLite) ListExamples(ctx context.Context, filter ExampleFilter) ([]e.Example, error) {
+	where, args := MessageFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To}.conditions()
+	where = append(where,
+		"(m.action IS NOT NULL AND m.error IS NULL OR m.id IN (SELECT message_id FROM overrides))",
+		"(m.text != '' OR m.media_file_id IS NOT NULL)",
+	)
+	if filter.Overridden {
+		where = append(where, "m.id IN (SELECT message_id FROM overrides)")
+	}
+	conditions := strings.Join(where, " AND ")
+
+	perLabel := filter.PerLabel
+	if filter.Balance {
+		rarest, err := c.rarestLabelCount(ctx, conditions, args)
+		if err != nil {
+			return nil, err
+		}
+		if perLabel <= 0 || rarest < perLabel {
+			perLabel = rarest
+		}
+	}
+
+	query := `SELECT message_id, chat_id, text, media_type, media_file_id, action, category, label, created_at
+		FROM (
+			SELECT m.message_id, m.chat_id, m.text, m.media_type, m.media_file_id, COALESCE(m.action, '') AS action, m.category, m.id,
+			       ` + labelExpr + ` AS label, m.created_at,
+			       ROW_NUMBER() OVER (PARTITION BY ` + labelExpr + ` ORDER BY m.created_at DESC, m.id DESC) AS n
+			FROM messages AS m
+			WHERE ` + conditions + `
+		)`
+	if filter.Balance || perLabel > 0 {
+		query += " WHERE n <= ?"
+		args = append(args, perLabel)
+	}
+	query += " ORDER BY created_at, id"
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying examples: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var examples []e.Example
+	for rows.Next() {
+		var (
+			ex       e.Example
+			category sql.NullString
+		)
+		err = rows.Scan(
+			&ex.MessageID, &ex.ChatID, &ex.Text, &ex.MediaType, &ex.MediaFileID,
+			&ex.Action, &category, &ex.Label, &ex.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning example: %w", err)
+		}
+		ex.Category = e.SpamCategory(category.String)
+		examples = append(examples, ex)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over examples: %w", err)
+	}
+
+	return examples, nil
+}
+
+// rarestLabelCount returns the number of messages of the rarest label among
+// the messages matching the conditions, zero if a label has none
+func (c *SQLite) rarestLabelCount(ctx context.Context, conditions string, args []any) (int, error) {
+	var spam, ham int
+	err := c.db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(SUM(`+labelExpr+` = 'spam'), 0), COALESCE(SUM(`+labelExpr+` = 'ham'), 0)
+		 FROM messages AS m WHERE `+conditions,
+		args...,
+	).Scan(&spam, &ham)
+	if err != nil {
+		return 0, fmt.Errorf("counting labels: %w", err)
+	}
+
+	return min(spam, ham), nil
+}
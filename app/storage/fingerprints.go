@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+const (
+	fingerprintColumns = `hash, sample, category, source, hits, created_at, last_seen_at`
+
+	// minFingerprintLength is the length of the folded text a fingerprint is
+	// taken of at least, shorter texts such as "hi" are too common
+	minFingerprintLength = 16
+
+	// fingerprintSampleLength is how many runes of the text are kept
+	fingerprintSampleLength = 200
+)
+
+// FingerprintSourceOverride is the source of fingerprints of spam confirmed
+// by an admin's override
+const FingerprintSourceOverride = "override"
+
+// AddFingerprint records the text as confirmed spam from the source and
+// returns its fingerprint, added is false if the text is too short to be
+// fingerprinted. A known fingerprint keeps its sample and hits.
+func (c *SQLite) AddFingerprint(ctx context.Context, text string, category e.SpamCategory, source string) (string, bool, error) {
+	return addFingerprint(ctx, c.db, text, category, source)
+}
+
+// execer is a database or a transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// addFingerprint adds the fingerprint in the database or a transaction
+func addFingerprint(ctx context.Context, db execer, text string, category e.SpamCategory, source string) (string, bool, error) {
+	if len([]rune(textnorm.Fold(text))) < minFingerprintLength {
+		return "", false, nil
+	}
+
+	hash := textnorm.Hash(text)
+	_, err := db.ExecContext(
+		ctx,
+		`INSERT INTO spam_fingerprints (hash, sample, category, source, hits, created_at)
+			VALUES (?, ?, ?, ?, 0, CURRENT_TIMESTAMP)
+			ON CONFLICT(hash) DO UPDATE SET category = COALESCE(excluded.category, category)`,
+		hash, truncateRunes(text, fingerprintSampleLength), nullString(string(category)), source,
+	)
+	if err != nil {
+		return "", false, fmt.Errorf("inserting fingerprint: %w", err)
+	}
+
+	return hash, true, nil
+}
+
+// MatchFingerprint looks the text up among fingerprints of confirmed spam.
+// A match is counted as a hit.
+func (c *SQLite) MatchFingerprint(ctx context.Context, text string) (e.SpamFingerprint, bool, error) {
+	if len([]rune(textnorm.Fold(text))) < minFingerprintLength {
+		return e.SpamFingerprint{}, false, nil
+	}
+
+	row := c.db.QueryRowContext(
+		ctx,
+		`UPDATE spam_fingerprints SET hits = hits + 1, last_seen_at = CURRENT_TIMESTAMP
+			WHERE hash = ?
+			RETURNING `+fingerprintColumns,
+		textnorm.Hash(text),
+	)
+
+	fp, err := scanFingerprint(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.SpamFingerprint{}, false, nil
+		}
+		return e.SpamFingerprint{}, false, err
+	}
+
+	return fp, true, nil
+}
+
+// DeleteFingerprint forgets the fingerprint of the text, e.g. one found to be
+// no spam after all. deleted is false if there was none.
+func (c *SQLite) DeleteFingerprint(ctx context.Context, text string) (bool, error) {
+	return deleteFingerprint(ctx, c.db, text)
+}
+
+func deleteFingerprint(ctx context.Context, db execer, text string) (bool, error) {
+	res, err := db.ExecContext(ctx, `DELETE FROM spam_fingerprints WHERE hash = ?`, textnorm.Hash(text))
+	if err != nil {
+		return false, fmt.Errorf("deleting fingerprint: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// FingerprintFilter selects fingerprints, zero fields don't filter
+type FingerprintFilter struct {
+	Category e.SpamCategory
+
+	// SeenSince selects fingerprints matched at or after the time
+	SeenSince time.Time
+
+	Limit  int
+	Offset int
+}
+
+// ListFingerprints returns fingerprints matching the filter, the most hit
+// first
+func (c *SQLite) ListFingerprints(ctx context.Context, filter FingerprintFilter) ([]e.SpamFingerprint, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.Category != "" {
+		where = append(where, "category = ?")
+		args = append(args, string(filter.Category))
+	}
+	if !filter.SeenSince.IsZero() {
+		where = append(where, "last_seen_at >= ?")
+		args = append(args, formatTime(filter.SeenSince))
+	}
+
+	query := `SELECT ` + fingerprintColumns + ` FROM spam_fingerprints`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY hits DESC, created_at DESC"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying fingerprints: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var fingerprints []e.SpamFingerprint
+	for rows.Next() {
+		fp, err := scanFingerprint(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning fingerprint: %w", err)
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over fingerprints: %w", err)
+	}
+
+	return fingerprints, nil
+}
+
+func scanFingerprint(row interface{ Scan(dest ...any) error }) (e.SpamFingerprint, error) {
+	var (
+		fp         e.SpamFingerprint
+		category   sql.NullString
+		lastSeenAt sql.NullTime
+	)
+	err := row.Scan(&fp.Hash, &fp.Sample, &category, &fp.Source, &fp.Hits, &fp.CreatedAt, &lastSeenAt)
+	if err != nil {
+		return e.SpamFingerprint{}, err
+	}
+
+	fp.Category = e.SpamCategory(category.String)
+	fp.LastSeenAt = lastSeenAt.Time
+
+	return fp, nil
+}
+
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
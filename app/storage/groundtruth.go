@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// ImportGroundTruth adds labeled examples from the source to the ground truth
+// in one transaction. Examples whose normalized text is already there are
+// counted as duplicates; examples without a valid label or without both text
+// and media are skipped. Texts of spam examples are fingerprinted.
+func (c *SQLite) ImportGroundTruth(ctx context.Context, source string, examples []e.Example) (e.ImportResult, error) {
+	var result e.ImportResult
+
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		stmt, err := tx.PrepareContext(
+			ctx,
+			`INSERT INTO ground_truth (
+				text_hash, text, media_type, media_file_id, label, category, source, chat_id, message_id, created_at
+			) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(text_hash) DO NOTHING`,
+		)
+		if err != nil {
+			return fmt.Errorf("preparing insert: %w", err)
+		}
+		defer func() { _ = stmt.Close() }()
+
+		for _, ex := range examples {
+			key, ok := GroundTruthKey(ex)
+			if !ok || (ex.Label != e.LabelSpam && ex.Label != e.LabelHam) {
+				result.Skipped++
+				continue
+			}
+
+			res, err := stmt.ExecContext(
+				ctx,
+				key, ex.Text, ex.MediaType, ex.MediaFileID, string(ex.Label), nullString(string(ex.Category)),
+				source, ex.ChatID, ex.MessageID,
+			)
+			if err != nil {
+				return fmt.Errorf("inserting example: %w", err)
+			}
+
+			if n, _ := res.RowsAffected(); n == 0 {
+				result.Duplicates++
+			} else {
+				result.Imported++
+			}
+
+			if ex.Label == e.LabelSpam {
+				if _, _, err = addFingerprint(ctx, tx, ex.Text, ex.Category, source); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return e.ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+// GroundTruthKey returns the dedup key of the example: the hash of its
+// normalized text, or of its media file ID for media without text
+func GroundTruthKey(ex e.Example) (string, bool) {
+	if strings.TrimSpace(ex.Text) != "" {
+		return textnorm.Hash(ex.Text), true
+	}
+	if ex.MediaFileID != nil && *ex.MediaFileID != "" {
+		return "media:" + *ex.MediaFileID, true
+	}
+	return "", false
+}
+
+// GroundTruthFilter selects ground truth examples, zero fields don't filter
+type GroundTruthFilter struct {
+	Label    e.Label
+	Category e.SpamCategory
+	Source   string
+
+	// TextOnly skips media examples, e.g. for text-only few-shot prompts
+	TextOnly bool
+
+	Limit int
+}
+
+// ListGroundTruth returns ground truth examples matching the filter, newest
+// first
+func (c *SQLite) ListGroundTruth(ctx context.Context, filter GroundTruthFilter) ([]e.GroundTruth, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.Label != "" {
+		where = append(where, "label = ?")
+		args = append(args, string(filter.Label))
+	}
+	if filter.Category != "" {
+		where = append(where, "category = ?")
+		args = append(args, string(filter.Category))
+	}
+	if filter.Source != "" {
+		where = append(where, "source = ?")
+		args = append(args, filter.Source)
+	}
+	if filter.TextOnly {
+		where = append(where, "text != ''", "media_type IS NULL")
+	}
+
+	query := `SELECT chat_id, message_id, text, media_type, media_file_id, label, category, source, created_at
+		FROM ground_truth`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY created_at DESC, id DESC"
+	if filter.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, filter.Limit)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying ground truth: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var examples []e.GroundTruth
+	for rows.Next() {
+		var (
+			gt       e.GroundTruth
+			category sql.NullString
+		)
+		err = rows.Scan(
+			&gt.ChatID, &gt.MessageID, &gt.Text, &gt.MediaType, &gt.MediaFileID,
+			&gt.Label, &category, &gt.Source, &gt.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("scanning ground truth: %w", err)
+		}
+		gt.Category = e.SpamCategory(category.String)
+		examples = append(examples, gt)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over ground truth: %w", err)
+	}
+
+	return examples, nil
+}
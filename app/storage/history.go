@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// ListUserMessages returns the user's recent messages in the user's chat,
+// newest first
+func (c *SQLite) ListUserMessages(ctx context.Context, user e.User, limit int) ([]e.SavedMessage, error) {
+	return c.ListMessages(ctx, MessageFilter{ChatID: user.ChatID, SenderID: user.ID, Limit: limit})
+}
+
+// GetUserHistory returns the user's current score with up to limit recent
+// messages and score changes in the user's chat
+func (c *SQLite) GetUserHistory(ctx context.Context, user e.User, limit int) (e.UserHistory, error) {
+	history := e.UserHistory{User: user}
+
+	var score int
+	err := c.queryRow(
+		ctx,
+		"SELECT score FROM scores WHERE chat_id = ? and user_id = ?",
+		user.ChatID, user.ID,
+	).Scan(&score)
+	switch {
+	case err == nil:
+		history.Score = &score
+	case !errors.Is(err, sql.ErrNoRows):
+		return history, fmt.Errorf("getting score: %w", err)
+	}
+
+	if history.Messages, err = c.ListUserMessages(ctx, user, limit); err != nil {
+		return history, fmt.Errorf("listing messages: %w", err)
+	}
+
+	entries, err := c.ListAudit(ctx, AuditFilter{
+		ChatID: user.ChatID,
+		UserID: user.ID,
+		Kind:   e.AuditKindScore,
+		Limit:  limit,
+	})
+	if err != nil {
+		return history, fmt.Errorf("listing score changes: %w", err)
+	}
+
+	for _, entry := range slices.Backward(entries) {
+		before, errBefore := strconv.Atoi(entry.Before)
+		after, errAfter := strconv.Atoi(entry.After)
+		if errBefore != nil || errAfter != nil {
+			// Not a numeric score, e.g. an entry added by hand
+			continue
+		}
+		history.ScoreChanges = append(history.ScoreChanges, e.ScoreChange{
+			Before: before,
+			After:  after,
+			Reason: entry.Reason,
+			At:     entry.CreatedAt,
+		})
+	}
+
+	return history, nil
+}
@@ -0,0 +1,402 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/logger"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+)
+
+// Instrumented counts calls of the store and their durations by method and
+// logs calls slower than SlowThreshold, so operators can see when the
+// database becomes the bottleneck
+type Instrumented struct {
+	Store
+
+	Log logger.Logger
+
+	// SlowThreshold is the duration of a call logged as slow, zero disables
+	// logging
+	SlowThreshold time.Duration
+
+	calls    metrics.Counter
+	duration metrics.Summary
+}
+
+// NewInstrumented wraps the store, reporting to the registry
+func NewInstrumented(store Store, registry *metrics.Registry, log logger.Logger, slowThreshold time.Duration) *Instrumented {
+	return &Instrumented{
+		Store:         store,
+		Log:           log,
+		SlowThreshold: slowThreshold,
+		calls: registry.Counter(
+			"antispam_storage_calls_total",
+			"Number of storage calls by method and status (ok or error).",
+			"method", "status",
+		),
+		duration: registry.Summary(
+			"antispam_storage_call_duration_seconds",
+			"Duration of storage calls by method.",
+			"method",
+		),
+	}
+}
+
+// observe records a call of the method which started at start and returned
+// the error
+func (s *Instrumented) observe(method string, start time.Time, err *error) {
+	elapsed := time.Since(start)
+
+	status := "ok"
+	if *err != nil {
+		status = "error"
+	}
+	s.calls.Inc(method, status)
+	s.duration.Observe(elapsed.Seconds(), method)
+
+	if s.SlowThreshold > 0 && elapsed >= s.SlowThreshold && s.Log != nil {
+		s.Log.Warn("slow storage call", "method", method, "duration", elapsed, "status", status)
+	}
+}
+
+func (s *Instrumented) GetScore(ctx context.Context, user e.User, defaultValue int) (_ int, err error) {
+	defer s.observe("GetScore", time.Now(), &err)
+	return s.Store.GetScore(ctx, user, defaultValue)
+}
+
+func (s *Instrumented) GetScores(ctx context.Context, users []e.User, defaultValue int) (_ []int, err error) {
+	defer s.observe("GetScores", time.Now(), &err)
+	return s.Store.GetScores(ctx, users, defaultValue)
+}
+
+func (s *Instrumented) SetScore(ctx context.Context, user e.User, score int) (err error) {
+	defer s.observe("SetScore", time.Now(), &err)
+	return s.Store.SetScore(ctx, user, score)
+}
+
+func (s *Instrumented) GetProbation(ctx context.Context, user e.User) (_ int, _ bool, err error) {
+	defer s.observe("GetProbation", time.Now(), &err)
+	return s.Store.GetProbation(ctx, user)
+}
+
+func (s *Instrumented) SetProbation(ctx context.Context, user e.User, remaining int) (err error) {
+	defer s.observe("SetProbation", time.Now(), &err)
+	return s.Store.SetProbation(ctx, user, remaining)
+}
+
+func (s *Instrumented) SaveMessage(ctx context.Context, msg e.Message) (_ int64, err error) {
+	defer s.observe("SaveMessage", time.Now(), &err)
+	return s.Store.SaveMessage(ctx, msg)
+}
+
+func (s *Instrumented) SaveDecision(ctx context.Context, decision e.Decision) (_ int64, err error) {
+	defer s.observe("SaveDecision", time.Now(), &err)
+	return s.Store.SaveDecision(ctx, decision)
+}
+
+func (s *Instrumented) SaveDecisions(ctx context.Context, decisions []e.Decision) (_ []int64, err error) {
+	defer s.observe("SaveDecisions", time.Now(), &err)
+	return s.Store.SaveDecisions(ctx, decisions)
+}
+
+func (s *Instrumented) SaveAction(ctx context.Context, messageID int64, action e.Action) (err error) {
+	defer s.observe("SaveAction", time.Now(), &err)
+	return s.Store.SaveAction(ctx, messageID, action)
+}
+
+func (s *Instrumented) SaveError(ctx context.Context, messageID int64, failure string) (err error) {
+	defer s.observe("SaveError", time.Now(), &err)
+	return s.Store.SaveError(ctx, messageID, failure)
+}
+
+func (s *Instrumented) GetMessage(ctx context.Context, chatID, messageID string) (_ e.SavedMessage, _ bool, err error) {
+	defer s.observe("GetMessage", time.Now(), &err)
+	return s.Store.GetMessage(ctx, chatID, messageID)
+}
+
+func (s *Instrumented) CountUserMessages(ctx context.Context, user e.User) (_ int, err error) {
+	defer s.observe("CountUserMessages", time.Now(), &err)
+	return s.Store.CountUserMessages(ctx, user)
+}
+
+func (s *Instrumented) ListMessages(ctx context.Context, filter MessageFilter) (_ []e.SavedMessage, err error) {
+	defer s.observe("ListMessages", time.Now(), &err)
+	return s.Store.ListMessages(ctx, filter)
+}
+
+func (s *Instrumented) SearchMessages(ctx context.Context, query string, filter MessageFilter) (_ []e.SavedMessage, err error) {
+	defer s.observe("SearchMessages", time.Now(), &err)
+	return s.Store.SearchMessages(ctx, query, filter)
+}
+
+func (s *Instrumented) ListUserMessages(ctx context.Context, user e.User, limit int) (_ []e.SavedMessage, err error) {
+	defer s.observe("ListUserMessages", time.Now(), &err)
+	return s.Store.ListUserMessages(ctx, user, limit)
+}
+
+func (s *Instrumented) GetUserHistory(ctx context.Context, user e.User, limit int) (_ e.UserHistory, err error) {
+	defer s.observe("GetUserHistory", time.Now(), &err)
+	return s.Store.GetUserHistory(ctx, user, limit)
+}
+
+func (s *Instrumented) ListExamples(ctx context.Context, filter ExampleFilter) (_ []e.Example, err error) {
+	defer s.observe("ListExamples", time.Now(), &err)
+	return s.Store.ListExamples(ctx, filter)
+}
+
+func (s *Instrumented) AddOverride(ctx context.Context, override e.Override) (_ int64, _ bool, err error) {
+	defer s.observe("AddOverride", time.Now(), &err)
+	return s.Store.AddOverride(ctx, override)
+}
+
+func (s *Instrumented) ListOverrides(ctx context.Context, filter OverrideFilter) (_ []e.Override, err error) {
+	defer s.observe("ListOverrides", time.Now(), &err)
+	return s.Store.ListOverrides(ctx, filter)
+}
+
+func (s *Instrumented) ImportGroundTruth(ctx context.Context, source string, examples []e.Example) (_ e.ImportResult, err error) {
+	defer s.observe("ImportGroundTruth", time.Now(), &err)
+	return s.Store.ImportGroundTruth(ctx, source, examples)
+}
+
+func (s *Instrumented) ListGroundTruth(ctx context.Context, filter GroundTruthFilter) (_ []e.GroundTruth, err error) {
+	defer s.observe("ListGroundTruth", time.Now(), &err)
+	return s.Store.ListGroundTruth(ctx, filter)
+}
+
+func (s *Instrumented) AddFingerprint(ctx context.Context, text string, category e.SpamCategory, source string) (_ string, _ bool, err error) {
+	defer s.observe("AddFingerprint", time.Now(), &err)
+	return s.Store.AddFingerprint(ctx, text, category, source)
+}
+
+func (s *Instrumented) MatchFingerprint(ctx context.Context, text string) (_ e.SpamFingerprint, _ bool, err error) {
+	defer s.observe("MatchFingerprint", time.Now(), &err)
+	return s.Store.MatchFingerprint(ctx, text)
+}
+
+func (s *Instrumented) DeleteFingerprint(ctx context.Context, text string) (_ bool, err error) {
+	defer s.observe("DeleteFingerprint", time.Now(), &err)
+	return s.Store.DeleteFingerprint(ctx, text)
+}
+
+func (s *Instrumented) ListFingerprints(ctx context.Context, filter FingerprintFilter) (_ []e.SpamFingerprint, err error) {
+	defer s.observe("ListFingerprints", time.Now(), &err)
+	return s.Store.ListFingerprints(ctx, filter)
+}
+
+func (s *Instrumented) GetVerdict(ctx context.Context, textHash, promptVersion string) (_ e.CachedVerdict, _ bool, err error) {
+	defer s.observe("GetVerdict", time.Now(), &err)
+	return s.Store.GetVerdict(ctx, textHash, promptVersion)
+}
+
+func (s *Instrumented) PutVerdict(ctx context.Context, v e.CachedVerdict) (err error) {
+	defer s.observe("PutVerdict", time.Now(), &err)
+	return s.Store.PutVerdict(ctx, v)
+}
+
+func (s *Instrumented) StoreEmbedding(ctx context.Context, messageID int64, model string, vector []float32) (err error) {
+	defer s.observe("StoreEmbedding", time.Now(), &err)
+	return s.Store.StoreEmbedding(ctx, messageID, model, vector)
+}
+
+func (s *Instrumented) FindSimilar(ctx context.Context, model string, vector []float32, filter SimilarityFilter) (_ []e.SimilarMessage, err error) {
+	defer s.observe("FindSimilar", time.Now(), &err)
+	return s.Store.FindSimilar(ctx, model, vector, filter)
+}
+
+func (s *Instrumented) ListChats(ctx context.Context) (_ []e.Chat, err error) {
+	defer s.observe("ListChats", time.Now(), &err)
+	return s.Store.ListChats(ctx)
+}
+
+func (s *Instrumented) GetChat(ctx context.Context, chatID string) (_ e.Chat, _ bool, err error) {
+	defer s.observe("GetChat", time.Now(), &err)
+	return s.Store.GetChat(ctx, chatID)
+}
+
+func (s *Instrumented) UpdateChat(ctx context.Context, chat e.Chat) (err error) {
+	defer s.observe("UpdateChat", time.Now(), &err)
+	return s.Store.UpdateChat(ctx, chat)
+}
+
+func (s *Instrumented) GetChatStats(ctx context.Context, chatID string, from, to time.Time) (_ e.ChatStats, err error) {
+	defer s.observe("GetChatStats", time.Now(), &err)
+	return s.Store.GetChatStats(ctx, chatID, from, to)
+}
+
+func (s *Instrumented) CountByAction(ctx context.Context, filter StatsFilter) (_ map[e.ActionKind]int, err error) {
+	defer s.observe("CountByAction", time.Now(), &err)
+	return s.Store.CountByAction(ctx, filter)
+}
+
+func (s *Instrumented) CountByChat(ctx context.Context, filter StatsFilter) (_ map[string]int, err error) {
+	defer s.observe("CountByChat", time.Now(), &err)
+	return s.Store.CountByChat(ctx, filter)
+}
+
+func (s *Instrumented) CountByDay(ctx context.Context, filter StatsFilter) (_ []e.DayStats, err error) {
+	defer s.observe("CountByDay", time.Now(), &err)
+	return s.Store.CountByDay(ctx, filter)
+}
+
+func (s *Instrumented) CountByChatDay(ctx context.Context, filter StatsFilter) (_ []e.ChatDayStats, err error) {
+	defer s.observe("CountByChatDay", time.Now(), &err)
+	return s.Store.CountByChatDay(ctx, filter)
+}
+
+func (s *Instrumented) AverageConfidence(ctx context.Context, filter StatsFilter) (_ e.Confidence, err error) {
+	defer s.observe("AverageConfidence", time.Now(), &err)
+	return s.Store.AverageConfidence(ctx, filter)
+}
+
+func (s *Instrumented) TopOffenders(ctx context.Context, filter StatsFilter, limit int) (_ []e.UserOffenses, err error) {
+	defer s.observe("TopOffenders", time.Now(), &err)
+	return s.Store.TopOffenders(ctx, filter, limit)
+}
+
+func (s *Instrumented) CountByPromptVersion(ctx context.Context, filter StatsFilter) (_ []e.PromptVersionStats, err error) {
+	defer s.observe("CountByPromptVersion", time.Now(), &err)
+	return s.Store.CountByPromptVersion(ctx, filter)
+}
+
+func (s *Instrumented) SpendByChat(ctx context.Context, filter StatsFilter) (_ map[string]e.Spend, err error) {
+	defer s.observe("SpendByChat", time.Now(), &err)
+	return s.Store.SpendByChat(ctx, filter)
+}
+
+func (s *Instrumented) SpendByDay(ctx context.Context, filter StatsFilter) (_ []e.DaySpend, err error) {
+	defer s.observe("SpendByDay", time.Now(), &err)
+	return s.Store.SpendByDay(ctx, filter)
+}
+
+func (s *Instrumented) GetChatSettings(ctx context.Context, chatID string) (_ e.ChatSettings, _ bool, err error) {
+	defer s.observe("GetChatSettings", time.Now(), &err)
+	return s.Store.GetChatSettings(ctx, chatID)
+}
+
+func (s *Instrumented) SetChatSettings(ctx context.Context, chatID string, settings e.ChatSettings) (err error) {
+	defer s.observe("SetChatSettings", time.Now(), &err)
+	return s.Store.SetChatSettings(ctx, chatID, settings)
+}
+
+func (s *Instrumented) DeleteChatSettings(ctx context.Context, chatID string) (err error) {
+	defer s.observe("DeleteChatSettings", time.Now(), &err)
+	return s.Store.DeleteChatSettings(ctx, chatID)
+}
+
+func (s *Instrumented) AddBan(ctx context.Context, ban e.Ban) (_ int64, err error) {
+	defer s.observe("AddBan", time.Now(), &err)
+	return s.Store.AddBan(ctx, ban)
+}
+
+func (s *Instrumented) GetBan(ctx context.Context, id int64) (_ e.Ban, _ bool, err error) {
+	defer s.observe("GetBan", time.Now(), &err)
+	return s.Store.GetBan(ctx, id)
+}
+
+func (s *Instrumented) GetActiveBan(ctx context.Context, chatID, userID string) (_ e.Ban, _ bool, err error) {
+	defer s.observe("GetActiveBan", time.Now(), &err)
+	return s.Store.GetActiveBan(ctx, chatID, userID)
+}
+
+func (s *Instrumented) ListBans(ctx context.Context, filter BanFilter) (_ []e.Ban, err error) {
+	defer s.observe("ListBans", time.Now(), &err)
+	return s.Store.ListBans(ctx, filter)
+}
+
+func (s *Instrumented) UpdateBan(ctx context.Context, ban e.Ban) (_ bool, err error) {
+	defer s.observe("UpdateBan", time.Now(), &err)
+	return s.Store.UpdateBan(ctx, ban)
+}
+
+func (s *Instrumented) RevokeBan(ctx context.Context, id int64, revokedBy string) (_ bool, err error) {
+	defer s.observe("RevokeBan", time.Now(), &err)
+	return s.Store.RevokeBan(ctx, id, revokedBy)
+}
+
+func (s *Instrumented) DeleteBan(ctx context.Context, id int64) (err error) {
+	defer s.observe("DeleteBan", time.Now(), &err)
+	return s.Store.DeleteBan(ctx, id)
+}
+
+func (s *Instrumented) AddVerification(ctx context.Context, v e.Verification) (_ int64, err error) {
+	defer s.observe("AddVerification", time.Now(), &err)
+	return s.Store.AddVerification(ctx, v)
+}
+
+func (s *Instrumented) GetPendingVerification(ctx context.Context, chatID, userID string) (_ e.Verification, _ bool, err error) {
+	defer s.observe("GetPendingVerification", time.Now(), &err)
+	return s.Store.GetPendingVerification(ctx, chatID, userID)
+}
+
+func (s *Instrumented) ResolveVerification(ctx context.Context, id int64, status e.VerificationStatus) (_ bool, err error) {
+	defer s.observe("ResolveVerification", time.Now(), &err)
+	return s.Store.ResolveVerification(ctx, id, status)
+}
+
+func (s *Instrumented) ListVerifications(ctx context.Context, filter VerificationFilter) (_ []e.Verification, err error) {
+	defer s.observe("ListVerifications", time.Now(), &err)
+	return s.Store.ListVerifications(ctx, filter)
+}
+
+func (s *Instrumented) ArchiveUpdate(ctx context.Context, update e.RawUpdate) (err error) {
+	defer s.observe("ArchiveUpdate", time.Now(), &err)
+	return s.Store.ArchiveUpdate(ctx, update)
+}
+
+func (s *Instrumented) ListRawUpdates(ctx context.Context, filter RawUpdateFilter) (_ []e.RawUpdate, err error) {
+	defer s.observe("ListRawUpdates", time.Now(), &err)
+	return s.Store.ListRawUpdates(ctx, filter)
+}
+
+func (s *Instrumented) DeleteUserData(ctx context.Context, chatID, userID string) (_ e.ErasureResult, err error) {
+	defer s.observe("DeleteUserData", time.Now(), &err)
+	return s.Store.DeleteUserData(ctx, chatID, userID)
+}
+
+func (s *Instrumented) AppendAudit(ctx context.Context, entry e.AuditEntry) (err error) {
+	defer s.observe("AppendAudit", time.Now(), &err)
+	return s.Store.AppendAudit(ctx, entry)
+}
+
+func (s *Instrumented) ListAudit(ctx context.Context, filter AuditFilter) (_ []e.AuditEntry, err error) {
+	defer s.observe("ListAudit", time.Now(), &err)
+	return s.Store.ListAudit(ctx, filter)
+}
+
+func (s *Instrumented) Prune(ctx context.Context, policy e.RetentionPolicy) (_ e.PruneResult, err error) {
+	defer s.observe("Prune", time.Now(), &err)
+	return s.Store.Prune(ctx, policy)
+}
+
+func (s *Instrumented) Vacuum(ctx context.Context) (_ e.VacuumResult, err error) {
+	defer s.observe("Vacuum", time.Now(), &err)
+	return s.Store.Vacuum(ctx)
+}
+
+func (s *Instrumented) Backup(ctx context.Context, destPath string) (err error) {
+	defer s.observe("Backup", time.Now(), &err)
+	return s.Store.Backup(ctx, destPath)
+}
+
+func (s *Instrumented) Restore(ctx context.Context, srcPath string) (err error) {
+	defer s.observe("Restore", time.Now(), &err)
+	return s.Store.Restore(ctx, srcPath)
+}
+
+func (s *Instrumented) MigrateUp(ctx context.Context) (err error) {
+	defer s.observe("MigrateUp", time.Now(), &err)
+	return s.Store.MigrateUp(ctx)
+}
+
+func (s *Instrumented) MigrateDown(ctx context.Context, steps int) (err error) {
+	defer s.observe("MigrateDown", time.Now(), &err)
+	return s.Store.MigrateDown(ctx, steps)
+}
+
+func (s *Instrumented) MigrationStatuses(ctx context.Context) (_ []MigrationStatus, err error) {
+	defer s.observe("MigrationStatuses", time.Now(), &err)
+	return s.Store.MigrationStatuses(ctx)
+}
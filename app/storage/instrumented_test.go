@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+)
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+	registry := metrics.NewRegistry()
+	var logs strings.Builder
+	store := NewInstrumented(newTestSQLite(t), registry, slog.New(slog.NewTextHandler(&logs, nil)), time.Hour)
+
+	if _, err := store.GetScore(ctx, e.User{ID: "1", ChatID: "-100"}, 0); err != nil {
+		t.Fatalf("GetScore: %v", err)
+	}
+	if err := store.Restore(ctx, "/nonexistent/backup.sqlite"); err == nil {
+		t.Fatal("Restore of a missing file succeeded")
+	}
+
+	var out strings.Builder
+	_, _ = registry.WriteTo(&out)
+	for _, want := range []string{
+		`antispam_storage_calls_total{method="GetScore",status="ok"} 1`,
+		`antispam_storage_calls_total{method="Restore",status="error"} 1`,
+		`antispam_storage_call_duration_seconds_count{method="GetScore"} 1`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %q:\n%s", want, out.String())
+		}
+	}
+	if logs.Len() > 0 {
+		t.Errorf("fast calls logged: %s", logs.String())
+	}
+
+	store.SlowThreshold = time.Nanosecond
+	_, _ = store.GetScore(ctx, e.User{ID: "1", ChatID: "-100"}, 0)
+	if !strings.Contains(logs.String(), "slow storage call") || !strings.Contains(logs.String(), "method=GetScore") {
+		t.Errorf("slow call not logged: %s", logs.String())
+	}
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Migrations are numbered SQL files in the migrations directory, a pair per
+// schema change: NNNN_name.up.sql applies it and NNNN_name.down.sql reverts
+// it. Applied versions are recorded in the schema_migrations table.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration with the time it was applied, zero if it is
+// pending
+type MigrationStatus struct {
+	Migration
+	AppliedAt time.Time
+}
+
+// Migrations returns all known migrations ordered by version
+func Migrations() ([]Migration, error) {
+	files, err := fs.ReadDir(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		match := migrationFileRe.FindStringSubmatch(file.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", file.Name())
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, match[2])
+		}
+
+		content, err := migrationsFS.ReadFile(path.Join("migrations", file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("reading migration %s: %w", file.Name(), err)
+		}
+
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// ErrReadOnly is returned for changes of a database opened read-only
+var ErrReadOnly = errors.New("database is opened read-only")
+
+// ErrMigrationsPending is returned for a database whose schema must be
+// migrated but isn't allowed to be
+var ErrMigrationsPending = errors.New("schema migrations pending")
+
+// MigrateUp applies all pending migrations. A read-only database is only
+// checked to have none pending.
+func (c *SQLite) MigrateUp(ctx context.Context) error {
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	if c.readOnly {
+		return c.checkMigrated(ctx, migrations)
+	}
+
+	if err = c.prepareMigrations(ctx); err != nil {
+		return err
+	}
+
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+
+		err = c.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.Up); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(
+				ctx,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, CURRENT_TIMESTAMP)",
+				m.Version, m.Name,
+			)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("applying migration %d_%s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return c.ensureSearchIndex(ctx)
+}
+
+// MigrateDown reverts the given number of most recently applied migrations
+func (c *SQLite) MigrateDown(ctx context.Context, steps int) error {
+	if c.readOnly {
+		return ErrReadOnly
+	}
+
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+
+	if err = c.prepareMigrations(ctx); err != nil {
+		return err
+	}
+
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		m := migrations[i]
+		if _, ok := applied[m.Version]; !ok {
+			continue
+		}
+
+		err = c.inTx(ctx, func(tx *sql.Tx) error {
+			if _, err := tx.ExecContext(ctx, m.Down); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("reverting migration %d_%s: %w", m.Version, m.Name, err)
+		}
+		steps--
+	}
+
+	c.forgetChats()
+	return c.ensureSearchIndex(ctx)
+}
+
+// MigrationStatuses returns all known migrations with their applied times
+func (c *SQLite) MigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = c.prepareMigrations(ctx); err != nil {
+		return nil, err
+	}
+
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		statuses[i] = MigrationStatus{Migration: m, AppliedAt: applied[m.Version]}
+	}
+
+	return statuses, nil
+}
+
+// prepareMigrations creates the schema_migrations table. A database created
+// before versioned migrations (by the former init.sql) gets the columns it
+// may miss and is baselined at the initial migration, whose statements are
+// idempotent.
+func (c *SQLite) prepareMigrations(ctx context.Context) error {
+	if c.readOnly {
+		return nil
+	}
+
+	var legacy bool
+	err := c.db.QueryRowContext(
+		ctx,
+		`SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'messages')
+		    AND NOT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations')`,
+	).Scan(&legacy)
+	if err != nil {
+		return fmt.Errorf("checking for a legacy schema: %w", err)
+	}
+
+	_, err = c.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations
+		(
+			version    INTEGER PRIMARY KEY,
+			name       TEXT      NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		)`)
+	if err != nil {
+		return fmt.Errorf("creating schema_migrations table: %w", err)
+	}
+
+	if !legacy {
+		return nil
+	}
+
+	for _, m := range legacyColumns {
+		err = c.migrateAddColumn(ctx, m.table, m.column, m.definition)
+		if err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+
+	return nil
+}
+
+// checkMigrated fails if any of the migrations is not applied
+func (c *SQLite) checkMigrated(ctx context.Context, migrations []Migration) error {
+	applied, err := c.appliedMigrations(ctx)
+	if err != nil {
+		return err
+	}
+
+	var pending int
+	for _, m := range migrations {
+		if _, ok := applied[m.Version]; !ok {
+			pending++
+		}
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d, apply them first", ErrMigrationsPending, pending)
+	}
+
+	return nil
+}
+
+func (c *SQLite) appliedMigrations(ctx context.Context) (map[int]time.Time, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("querying applied migrations: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("scanning applied migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over applied migrations: %w", err)
+	}
+
+	return applied, nil
+}
+
+// legacyColumns were added to tables of the former init.sql schema after
+// their creation, a legacy database may lack them
+var legacyColumns = []struct {
+	table, column, definition string
+}{
+	{"messages", "media_type", "TEXT NULL"},
+	{"messages", "media_size", "INTEGER NULL"},
+	{"messages", "media_file_id", "TEXT NULL"},
+	{"messages", "category", "TEXT NULL"},
+	{"messages", "trace", "TEXT NULL"},
+}
+
+// migrateAddColumn adds a column to a table unless it already exists
+func (c *SQLite) migrateAddColumn(ctx context.Context, table, column, definition string) error {
+	rows, err := c.db.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return fmt.Errorf("querying table info: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, colType    string
+			defaultValue     sql.NullString
+		)
+		if err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return fmt.Errorf("scanning table info: %w", err)
+		}
+		if name == column {
+			return nil
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("iterating over table info: %w", err)
+	}
+
+	_, err = c.db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds
+func (c *SQLite) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %w", err)
+	}
+
+	if err = fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
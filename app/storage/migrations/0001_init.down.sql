@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS probations;
+DROP TABLE IF EXISTS chats;
+DROP TABLE IF EXISTS messages;
+DROP TABLE IF EXISTS scores;
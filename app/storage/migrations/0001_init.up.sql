@@ -25,10 +25,13 @@ CREATE TABLE IF NOT EXISTS messages
     error            TEXT      NULL,
     media_type       TEXT      NULL,
     media_size       INTEGER   NULL,
-    media_file_id    TEXT      NULL
+    media_file_id    TEXT      NULL,
+    category         TEXT      NULL,
+    trace            TEXT      NULL
 );
 
 CREATE INDEX IF NOT EXISTS idx_messages_created_at ON messages (created_at);
+CREATE INDEX IF NOT EXISTS idx_messages__chat_id__sender_user_id ON messages (chat_id, sender_user_id);
 
 CREATE TABLE IF NOT EXISTS chats
 (
@@ -40,3 +43,14 @@ CREATE TABLE IF NOT EXISTS chats
 
 CREATE UNIQUE INDEX IF NOT EXISTS idx_chats__chat_id ON chats (chat_id);
 
+
+CREATE TABLE IF NOT EXISTS probations
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id    TEXT      NOT NULL,
+    user_id    TEXT      NOT NULL,
+    remaining  INTEGER   NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_probations__chat_id__user_id ON probations (chat_id, user_id);
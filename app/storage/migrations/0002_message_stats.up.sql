@@ -0,0 +1,13 @@
+-- Daily counters of pruned messages, so statistics survive retention
+CREATE TABLE message_stats
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id   TEXT    NOT NULL,
+    day       TEXT    NOT NULL,
+    action    TEXT    NOT NULL,
+    category  TEXT    NOT NULL,
+    has_error INTEGER NOT NULL,
+    count     INTEGER NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_message_stats__key ON message_stats (chat_id, day, action, category, has_error);
@@ -0,0 +1,8 @@
+-- Per-chat settings managed at runtime, stored as a JSON document of
+-- entities.ChatSettings
+CREATE TABLE chat_settings
+(
+    chat_id    TEXT PRIMARY KEY,
+    settings   TEXT      NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
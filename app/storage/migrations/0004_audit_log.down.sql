@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS audit_log__no_delete;
+DROP TRIGGER IF EXISTS audit_log__no_update;
+DROP TABLE IF EXISTS audit_log;
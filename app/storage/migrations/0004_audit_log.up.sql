@@ -0,0 +1,30 @@
+-- Append-only log of score, settings and ban changes
+CREATE TABLE audit_log
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind       TEXT      NOT NULL,
+    actor      TEXT      NOT NULL,
+    chat_id    TEXT      NOT NULL,
+    user_id    TEXT      NOT NULL,
+    before     TEXT      NOT NULL,
+    after      TEXT      NOT NULL,
+    reason     TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_audit_log__chat_id__created_at ON audit_log (chat_id, created_at);
+CREATE INDEX idx_audit_log__chat_id__user_id ON audit_log (chat_id, user_id);
+
+CREATE TRIGGER audit_log__no_update
+    BEFORE UPDATE
+    ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
+
+CREATE TRIGGER audit_log__no_delete
+    BEFORE DELETE
+    ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit log is append-only');
+END;
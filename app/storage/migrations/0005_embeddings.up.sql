@@ -0,0 +1,15 @@
+-- Embeddings of message texts for similarity search. Vectors are float32
+-- little-endian blobs, searched by brute force, which is fine at the scale of
+-- a few chats; norm is precomputed for cosine similarity.
+CREATE TABLE embeddings
+(
+    message_id INTEGER PRIMARY KEY REFERENCES messages (id) ON DELETE CASCADE,
+    chat_id    TEXT      NOT NULL,
+    model      TEXT      NOT NULL,
+    dimensions INTEGER   NOT NULL,
+    norm       REAL      NOT NULL,
+    vector     BLOB      NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_embeddings__model__chat_id ON embeddings (model, chat_id);
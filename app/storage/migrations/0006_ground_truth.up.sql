@@ -0,0 +1,19 @@
+-- Externally labeled examples for evaluation and few-shot selection, unique
+-- by the hash of the normalized text (textnorm.Hash)
+CREATE TABLE ground_truth
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    text_hash     TEXT      NOT NULL,
+    text          TEXT      NOT NULL,
+    media_type    TEXT      NULL,
+    media_file_id TEXT      NULL,
+    label         TEXT      NOT NULL,
+    category      TEXT      NULL,
+    source        TEXT      NOT NULL,
+    chat_id       TEXT      NOT NULL,
+    message_id    TEXT      NOT NULL,
+    created_at    TIMESTAMP NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_ground_truth__text_hash ON ground_truth (text_hash);
+CREATE INDEX idx_ground_truth__label ON ground_truth (label);
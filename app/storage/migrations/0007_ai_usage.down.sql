@@ -0,0 +1,8 @@
+ALTER TABLE message_stats DROP COLUMN ai_cost;
+ALTER TABLE message_stats DROP COLUMN completion_tokens;
+ALTER TABLE message_stats DROP COLUMN prompt_tokens;
+ALTER TABLE message_stats DROP COLUMN ai_requests;
+
+ALTER TABLE messages DROP COLUMN ai_cost;
+ALTER TABLE messages DROP COLUMN completion_tokens;
+ALTER TABLE messages DROP COLUMN prompt_tokens;
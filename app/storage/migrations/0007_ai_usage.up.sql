@@ -0,0 +1,10 @@
+-- AI usage of the decision on a message: tokens and cost in USD, NULL if the
+-- AI was not asked. Counters of pruned messages keep the sums.
+ALTER TABLE messages ADD COLUMN prompt_tokens INTEGER NULL;
+ALTER TABLE messages ADD COLUMN completion_tokens INTEGER NULL;
+ALTER TABLE messages ADD COLUMN ai_cost REAL NULL;
+
+ALTER TABLE message_stats ADD COLUMN ai_requests INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE message_stats ADD COLUMN prompt_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE message_stats ADD COLUMN completion_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE message_stats ADD COLUMN ai_cost REAL NOT NULL DEFAULT 0;
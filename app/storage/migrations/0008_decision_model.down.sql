@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_messages__prompt_version;
+
+ALTER TABLE messages DROP COLUMN prompt_version;
+ALTER TABLE messages DROP COLUMN model;
@@ -0,0 +1,11 @@
+-- Model and prompt version that produced the verdict on a message, copied
+-- out of the trace so decisions can be compared across prompt changes
+ALTER TABLE messages ADD COLUMN model TEXT NULL;
+ALTER TABLE messages ADD COLUMN prompt_version TEXT NULL;
+
+UPDATE messages
+SET model          = json_extract(trace, '$.model'),
+    prompt_version = json_extract(trace, '$.prompt_version')
+WHERE trace IS NOT NULL AND json_valid(trace);
+
+CREATE INDEX idx_messages__prompt_version ON messages (prompt_version);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_bans__expires_at;
+DROP INDEX IF EXISTS idx_bans__chat_id__user_id;
+DROP TABLE IF EXISTS bans;
@@ -0,0 +1,17 @@
+-- Registry of bans. A ban is active until it expires or is revoked, expired
+-- and revoked bans are kept as the user's history
+CREATE TABLE bans
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id    TEXT      NOT NULL,
+    user_id    TEXT      NOT NULL,
+    reason     TEXT      NOT NULL,
+    issued_by  TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    revoked_by TEXT      NULL
+);
+
+CREATE INDEX idx_bans__chat_id__user_id ON bans (chat_id, user_id);
+CREATE INDEX idx_bans__expires_at ON bans (expires_at) WHERE revoked_at IS NULL;
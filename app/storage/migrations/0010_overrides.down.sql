@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_overrides__chat_id__created_at;
+DROP INDEX IF EXISTS idx_overrides__message_id;
+DROP TABLE IF EXISTS overrides;
@@ -0,0 +1,16 @@
+-- Admin overrides of the bot's decisions, linked to the overridden message.
+-- The latest override of a message takes precedence over its action when
+-- the message is labeled for evaluation or training.
+CREATE TABLE overrides
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    message_id INTEGER   NOT NULL REFERENCES messages (id) ON DELETE CASCADE,
+    chat_id    TEXT      NOT NULL,
+    kind       TEXT      NOT NULL,
+    actor      TEXT      NOT NULL,
+    note       TEXT      NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_overrides__message_id ON overrides (message_id);
+CREATE INDEX idx_overrides__chat_id__created_at ON overrides (chat_id, created_at);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_messages__chat_id__message_id;
@@ -0,0 +1,17 @@
+-- A message is stored once per chat: re-delivered updates and edits upsert
+-- its row. Existing duplicates are folded into their latest row.
+UPDATE overrides
+SET message_id = (SELECT MAX(d.id)
+                  FROM messages AS m
+                           JOIN messages AS d ON d.chat_id = m.chat_id AND d.message_id = m.message_id
+                  WHERE m.id = overrides.message_id);
+
+DELETE
+FROM embeddings
+WHERE message_id NOT IN (SELECT MAX(id) FROM messages GROUP BY chat_id, message_id);
+
+DELETE
+FROM messages
+WHERE id NOT IN (SELECT MAX(id) FROM messages GROUP BY chat_id, message_id);
+
+CREATE UNIQUE INDEX idx_messages__chat_id__message_id ON messages (chat_id, message_id);
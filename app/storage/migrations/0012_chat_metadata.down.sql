@@ -0,0 +1,6 @@
+ALTER TABLE chats DROP COLUMN refreshed_at;
+ALTER TABLE chats DROP COLUMN joined_at;
+ALTER TABLE chats DROP COLUMN language;
+ALTER TABLE chats DROP COLUMN member_count;
+ALTER TABLE chats DROP COLUMN username;
+ALTER TABLE chats DROP COLUMN type;
@@ -0,0 +1,7 @@
+-- Chat metadata refreshed from the Bot API for reporting and policies
+ALTER TABLE chats ADD COLUMN type TEXT NULL;
+ALTER TABLE chats ADD COLUMN username TEXT NULL;
+ALTER TABLE chats ADD COLUMN member_count INTEGER NULL;
+ALTER TABLE chats ADD COLUMN language TEXT NULL;
+ALTER TABLE chats ADD COLUMN joined_at TIMESTAMP NULL;
+ALTER TABLE chats ADD COLUMN refreshed_at TIMESTAMP NULL;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_verifications__deadline;
+DROP INDEX IF EXISTS idx_verifications__pending;
+DROP TABLE IF EXISTS verifications;
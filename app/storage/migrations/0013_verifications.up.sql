@@ -0,0 +1,16 @@
+-- Captcha challenges issued to joining users. A user has at most one pending
+-- challenge per chat, resolved ones are kept as history
+CREATE TABLE verifications
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id     TEXT      NOT NULL,
+    user_id     TEXT      NOT NULL,
+    challenge   TEXT      NOT NULL,
+    issued_at   TIMESTAMP NOT NULL,
+    deadline    TIMESTAMP NOT NULL,
+    status      TEXT      NOT NULL,
+    resolved_at TIMESTAMP NULL
+);
+
+CREATE UNIQUE INDEX idx_verifications__pending ON verifications (chat_id, user_id) WHERE status = 'pending';
+CREATE INDEX idx_verifications__deadline ON verifications (deadline) WHERE status = 'pending';
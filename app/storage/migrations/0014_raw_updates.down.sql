@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_raw_updates__created_at;
+DROP INDEX IF EXISTS idx_raw_updates__chat_id__message_id;
+DROP TABLE IF EXISTS raw_updates;
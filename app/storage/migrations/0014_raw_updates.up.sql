@@ -0,0 +1,14 @@
+-- Raw Telegram updates of checked messages, gzip-compressed JSON, archived
+-- for replaying historical traffic and reproducing bugs
+CREATE TABLE raw_updates
+(
+    update_id  INTEGER PRIMARY KEY,
+    chat_id    TEXT      NOT NULL,
+    message_id TEXT      NOT NULL,
+    user_id    TEXT      NOT NULL,
+    payload    BLOB      NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_raw_updates__chat_id__message_id ON raw_updates (chat_id, message_id);
+CREATE INDEX idx_raw_updates__created_at ON raw_updates (created_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_spam_fingerprints__last_seen_at;
+DROP TABLE IF EXISTS spam_fingerprints;
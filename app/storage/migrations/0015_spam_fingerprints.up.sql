@@ -0,0 +1,14 @@
+-- Hashes of the normalized text of confirmed spam (textnorm.Hash), matched
+-- before asking the AI. Hits count re-posts for campaign statistics
+CREATE TABLE spam_fingerprints
+(
+    hash         TEXT PRIMARY KEY,
+    sample       TEXT      NOT NULL,
+    category     TEXT      NULL,
+    source       TEXT      NOT NULL,
+    hits         INTEGER   NOT NULL DEFAULT 0,
+    created_at   TIMESTAMP NOT NULL,
+    last_seen_at TIMESTAMP NULL
+);
+
+CREATE INDEX idx_spam_fingerprints__last_seen_at ON spam_fingerprints (last_seen_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_verdict_cache__expires_at;
+DROP TABLE IF EXISTS verdict_cache;
@@ -0,0 +1,17 @@
+-- AI verdicts on texts by the hash of their normalized text (textnorm.Hash)
+-- and the prompt version, reused for the same text until they expire
+CREATE TABLE verdict_cache
+(
+    text_hash      TEXT      NOT NULL,
+    prompt_version TEXT      NOT NULL,
+    model          TEXT      NOT NULL,
+    is_spam        BOOLEAN   NOT NULL,
+    category       TEXT      NULL,
+    confidence     REAL      NOT NULL,
+    note           TEXT      NOT NULL,
+    created_at     TIMESTAMP NOT NULL,
+    expires_at     TIMESTAMP NOT NULL,
+    PRIMARY KEY (text_hash, prompt_version)
+);
+
+CREATE INDEX idx_verdict_cache__expires_at ON verdict_cache (expires_at);
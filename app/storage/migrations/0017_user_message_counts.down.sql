@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS messages__count_user;
+DROP TABLE IF EXISTS user_message_counts;
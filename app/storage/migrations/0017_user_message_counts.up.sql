@@ -0,0 +1,27 @@
+-- Messages stored of each user, counted as they are inserted, so deleting
+-- old messages by the retention policy doesn't make long-time members new
+-- users again
+CREATE TABLE user_message_counts
+(
+    chat_id TEXT    NOT NULL,
+    user_id TEXT    NOT NULL,
+    count   INTEGER NOT NULL,
+    PRIMARY KEY (chat_id, user_id)
+);
+
+INSERT INTO user_message_counts (chat_id, user_id, count)
+SELECT chat_id, sender_user_id, COUNT(*)
+FROM messages
+WHERE sender_user_id != ''
+GROUP BY chat_id, sender_user_id;
+
+-- An upsert of a stored message updates it without firing the trigger
+CREATE TRIGGER messages__count_user
+    AFTER INSERT
+    ON messages
+    WHEN new.sender_user_id != ''
+BEGIN
+    INSERT INTO user_message_counts (chat_id, user_id, count)
+    VALUES (new.chat_id, new.sender_user_id, 1)
+    ON CONFLICT (chat_id, user_id) DO UPDATE SET count = count + 1;
+END;
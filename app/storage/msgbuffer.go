@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// DecisionBatchSaver stores decisions in a single transaction
+type DecisionBatchSaver interface {
+	SaveDecisions(ctx context.Context, decisions []e.Decision) ([]int64, error)
+}
+
+// ErrBufferStopped is returned for decisions saved after the buffer stopped
+var ErrBufferStopped = errors.New("message buffer is stopped")
+
+// MessageBuffer batches decisions on messages of concurrent workers:
+// SaveDecision queues the decision and waits for the next flush, which
+// stores all queued decisions in one transaction, on a tick or once the
+// batch is full. During a spam flood this takes a commit per batch rather
+// than per message, at the cost of up to a tick of latency per message.
+type MessageBuffer struct {
+	store    DecisionBatchSaver
+	interval time.Duration
+	size     int
+
+	queue   chan pendingMessage
+	stopped chan struct{}
+}
+
+type pendingMessage struct {
+	decision e.Decision
+	result   chan savedMessage
+}
+
+type savedMessage struct {
+	id  int64
+	err error
+}
+
+// NewMessageBuffer returns a buffer flushing decisions to the store every
+// interval or once size decisions are queued
+func NewMessageBuffer(store DecisionBatchSaver, interval time.Duration, size int) *MessageBuffer {
+	return &MessageBuffer{
+		store:    store,
+		interval: interval,
+		size:     size,
+		queue:    make(chan pendingMessage, size),
+		stopped:  make(chan struct{}),
+	}
+}
+
+// SaveDecision queues the decision and returns the ID of its message once
+// it is stored
+func (b *MessageBuffer) SaveDecision(ctx context.Context, decision e.Decision) (int64, error) {
+	p := pendingMessage{decision: decision, result: make(chan savedMessage, 1)}
+
+	select {
+	case b.queue <- p:
+	case <-b.stopped:
+		return 0, ErrBufferStopped
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	select {
+	case r := <-p.result:
+		return r.id, r.err
+	case <-b.stopped:
+		// The final flush may have stored it
+		select {
+		case r := <-p.result:
+			return r.id, r.err
+		default:
+			return 0, ErrBufferStopped
+		}
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+}
+
+// Run flushes queued decisions until the context is done, then flushes the
+// remaining ones
+func (b *MessageBuffer) Run(ctx context.Context) {
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	batch := make([]pendingMessage, 0, b.size)
+	for {
+		select {
+		case p := <-b.queue:
+			batch = append(batch, p)
+			if len(batch) >= b.size {
+				b.flush(ctx, batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			b.flush(ctx, batch)
+			batch = batch[:0]
+		case <-ctx.Done():
+		drain:
+			for {
+				select {
+				case p := <-b.queue:
+					batch = append(batch, p)
+				default:
+					break drain
+				}
+			}
+			b.flush(context.WithoutCancel(ctx), batch)
+			close(b.stopped)
+			return
+		}
+	}
+}
+
+func (b *MessageBuffer) flush(ctx context.Context, batch []pendingMessage) {
+	if len(batch) == 0 {
+		return
+	}
+
+	decisions := make([]e.Decision, len(batch))
+	for i, p := range batch {
+		decisions[i] = p.decision
+	}
+
+	ids, err := b.store.SaveDecisions(ctx, decisions)
+	for i, p := range batch {
+		if err != nil {
+			p.result <- savedMessage{err: err}
+			continue
+		}
+		p.result <- savedMessage{id: ids[i]}
+	}
+}
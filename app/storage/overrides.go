@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// AddOverride records an admin override of the decision on a stored message
+// and returns its ID, found is false if the message is not stored. ID,
+// Original and CreatedAt are assigned by the store. Confirmed spam is
+// fingerprinted, and the fingerprint of a message found to be no spam is
+// forgotten. Cached AI verdicts on the text are dropped either way.
+func (c *SQLite) AddOverride(ctx context.Context, override e.Override) (int64, bool, error) {
+	var (
+		id    int64
+		found bool
+	)
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		var (
+			messageID int64
+			text      string
+			category  sql.NullString
+		)
+		err := tx.QueryRowContext(
+			ctx,
+			`SELECT id, text, category FROM messages WHERE chat_id = ? AND message_id = ?`,
+			override.ChatID, override.MessageID,
+		).Scan(&messageID, &text, &category)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("querying message: %w", err)
+		}
+		found = true
+
+		res, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO overrides (message_id, chat_id, kind, actor, note, created_at)
+				VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`,
+			messageID, override.ChatID, string(override.Kind), override.Actor, override.Note,
+		)
+		if err != nil {
+			return err
+		}
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM verdict_cache WHERE text_hash = ?`, textnorm.Hash(text))
+		if err != nil {
+			return fmt.Errorf("deleting cached verdicts: %w", err)
+		}
+
+		if override.Kind.Label() == e.LabelSpam {
+			_, _, err = addFingerprint(ctx, tx, text, e.SpamCategory(category.String), FingerprintSourceOverride)
+		} else {
+			_, err = deleteFingerprint(ctx, tx, text)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, false, err
+	}
+
+	return id, found, nil
+}
+
+// OverrideFilter selects overrides, zero fields don't filter
+type OverrideFilter struct {
+	ChatID string
+	Kind   e.OverrideKind
+	Actor  string
+
+	// From and To bound the override time, To is exclusive
+	From time.Time
+	To   time.Time
+
+	Limit  int
+	Offset int
+}
+
+// ListOverrides returns overrides matching the filter with the actions they
+// overrode, newest first
+func (c *SQLite) ListOverrides(ctx context.Context, filter OverrideFilter) ([]e.Override, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.ChatID != "" {
+		where = append(where, "o.chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if filter.Kind != "" {
+		where = append(where, "o.kind = ?")
+		args = append(args, string(filter.Kind))
+	}
+	if filter.Actor != "" {
+		where = append(where, "o.actor = ?")
+		args = append(args, filter.Actor)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "o.created_at >= ?")
+		args = append(args, formatTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "o.created_at < ?")
+		args = append(args, formatTime(filter.To))
+	}
+
+	query := `SELECT o.id, o.chat_id, m.message_id, o.kind, o.actor, o.note, m.action, o.created_at
+		FROM overrides AS o
+		JOIN messages AS m ON m.id = o.message_id`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY o.created_at DESC, o.id DESC"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying overrides: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var overrides []e.Override
+	for rows.Next() {
+		var (
+			o        e.Override
+			original sql.NullString
+		)
+		err = rows.Scan(&o.ID, &o.ChatID, &o.MessageID, &o.Kind, &o.Actor, &o.Note, &original, &o.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scanning override: %w", err)
+		}
+		o.Original = e.ActionKind(original.String)
+		overrides = append(overrides, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over overrides: %w", err)
+	}
+
+	return overrides, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// DeleteUserData erases the data of the user in the chat, or in all chats if
+// chatID is empty. Messages are anonymized rather than deleted, so they are
+// still counted in statistics: their text, media reference, sender name and
+// AI note are erased, the sender ID is blanked, and their embeddings and
+// ground truth copies are deleted. Scores, message counts, probations and
+// archived raw updates are deleted. The audit log is append-only and keeps the user ID of
+// past changes.
+func (c *SQLite) DeleteUserData(ctx context.Context, chatID, userID string) (e.ErasureResult, error) {
+	var result e.ErasureResult
+
+	where := "sender_user_id = ?"
+	args := []any{userID}
+	userWhere := "user_id = ?"
+	if chatID != "" {
+		where += " AND chat_id = ?"
+		userWhere += " AND chat_id = ?"
+		args = append(args, chatID)
+	}
+
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`DELETE FROM embeddings WHERE message_id IN (SELECT id FROM messages WHERE `+where+`)`,
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("deleting embeddings: %w", err)
+		}
+
+		_, err = tx.ExecContext(
+			ctx,
+			`DELETE FROM ground_truth
+			 WHERE (chat_id, message_id) IN (SELECT chat_id, message_id FROM messages WHERE `+where+`)`,
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("deleting ground truth: %w", err)
+		}
+
+		res, err := tx.ExecContext(
+			ctx,
+			`UPDATE messages
+			 SET text = '', media_file_id = NULL, sender_user_id = '', sender_user_name = '', action_note = NULL
+			 WHERE `+where,
+			args...,
+		)
+		if err != nil {
+			return fmt.Errorf("anonymizing messages: %w", err)
+		}
+		result.Messages, _ = res.RowsAffected()
+
+		res, err = tx.ExecContext(ctx, `DELETE FROM scores WHERE `+userWhere, args...)
+		if err != nil {
+			return fmt.Errorf("deleting scores: %w", err)
+		}
+		result.Scores, _ = res.RowsAffected()
+
+		if _, err = tx.ExecContext(ctx, `DELETE FROM user_message_counts WHERE `+userWhere, args...); err != nil {
+			return fmt.Errorf("deleting message counts: %w", err)
+		}
+
+		if _, err = tx.ExecContext(ctx, `DELETE FROM probations WHERE `+userWhere, args...); err != nil {
+			return fmt.Errorf("deleting probations: %w", err)
+		}
+
+		if _, err = tx.ExecContext(ctx, `DELETE FROM raw_updates WHERE `+userWhere, args...); err != nil {
+			return fmt.Errorf("deleting raw updates: %w", err)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// ArchiveUpdate stores the raw update compressed, an update already archived
+// is kept as is. CreatedAt is assigned by the store.
+func (c *SQLite) ArchiveUpdate(ctx context.Context, update e.RawUpdate) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(update.Payload); err != nil {
+		return fmt.Errorf("compressing update: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compressing update: %w", err)
+	}
+
+	_, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO raw_updates (update_id, chat_id, message_id, user_id, payload, created_at)
+			VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(update_id) DO NOTHING`,
+		update.UpdateID, update.ChatID, update.MessageID, update.UserID, buf.Bytes(),
+	)
+	return err
+}
+
+// RawUpdateFilter selects archived updates, zero fields don't filter
+type RawUpdateFilter struct {
+	ChatID string
+
+	// From and To bound the archiving time, To is exclusive
+	From time.Time
+	To   time.Time
+
+	Limit  int
+	Offset int
+}
+
+// ListRawUpdates returns archived updates matching the filter with their
+// payloads decompressed, in the order they were received
+func (c *SQLite) ListRawUpdates(ctx context.Context, filter RawUpdateFilter) ([]e.RawUpdate, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.ChatID != "" {
+		where = append(where, "chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, formatTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, formatTime(filter.To))
+	}
+
+	query := `SELECT update_id, chat_id, message_id, user_id, payload, created_at FROM raw_updates`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY update_id"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying raw updates: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var updates []e.RawUpdate
+	for rows.Next() {
+		var (
+			u          e.RawUpdate
+			compressed []byte
+		)
+		err = rows.Scan(&u.UpdateID, &u.ChatID, &u.MessageID, &u.UserID, &compressed, &u.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("scanning raw update: %w", err)
+		}
+
+		if u.Payload, err = decompress(compressed); err != nil {
+			return nil, fmt.Errorf("decompressing update %d: %w", u.UpdateID, err)
+		}
+		updates = append(updates, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over raw updates: %w", err)
+	}
+
+	return updates, nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = zr.Close() }()
+
+	return io.ReadAll(zr)
+}
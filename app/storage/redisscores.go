@@ -0,0 +1,209 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/redis"
+)
+
+const (
+	// redisScorePrefix and redisLockPrefix start keys of scores and user
+	// locks, followed by the chat and user IDs
+	redisScorePrefix = "antispam:score:"
+	redisLockPrefix  = "antispam:lock:"
+
+	// redisScoreTTL bounds how long a score stays in Redis without being
+	// read from the store, in case an invalidation was lost
+	redisScoreTTL = time.Hour
+
+	// redisLockTTL is how long a user lock is held at most, longer than
+	// handling of a message takes including the AI call
+	redisLockTTL = 2 * time.Minute
+
+	// redisInvalidateTimeout bounds dropping a score, which has no context
+	redisInvalidateTimeout = 5 * time.Second
+
+	// redisPurgeTimeout bounds dropping all scores
+	redisPurgeTimeout = 30 * time.Second
+
+	// redisPurgeBatch is how many keys Purge scans for and deletes at once
+	redisPurgeBatch = 500
+)
+
+// RedisScores keeps user scores in Redis in front of the store, shared by
+// replicas of the bot, so a score changed by one replica is seen by the
+// others. Scores are written through to the store, which stays the source of
+// truth together with the messages; scores changed bypassing RedisScores
+// must be dropped with Invalidate.
+type RedisScores struct {
+	client *redis.Client
+	store  ScoreStore
+}
+
+// NewRedisScores returns the Redis score store in front of the store
+func NewRedisScores(client *redis.Client, store ScoreStore) *RedisScores {
+	return &RedisScores{client: client, store: store}
+}
+
+// GetScore returns the user's score from Redis, reading it from the store on
+// a miss. The default value of a user without a score is kept too, so callers
+// must pass the same default.
+func (r *RedisScores) GetScore(ctx context.Context, user e.User, defaultValue int) (int, error) {
+	value, err := r.client.Get(ctx, redisScoreKey(user))
+	switch {
+	case err == nil:
+		score, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("parsing score %q: %w", value, err)
+		}
+		return score, nil
+	case !errors.Is(err, redis.ErrNil):
+		return 0, fmt.Errorf("getting score from redis: %w", err)
+	}
+
+	score, err := r.store.GetScore(ctx, user, defaultValue)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = r.client.Set(ctx, redisScoreKey(user), strconv.Itoa(score), redisScoreTTL); err != nil {
+		return 0, fmt.Errorf("setting score in redis: %w", err)
+	}
+
+	return score, nil
+}
+
+// GetScores returns the scores of the users in the same order, looking up
+// the ones missing in Redis with a single read from the store
+func (r *RedisScores) GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, len(users))
+	for i, user := range users {
+		keys[i] = redisScoreKey(user)
+	}
+
+	values, err := r.client.MGet(ctx, keys...)
+	if err != nil {
+		return nil, fmt.Errorf("getting scores from redis: %w", err)
+	}
+
+	scores := make([]int, len(users))
+
+	var (
+		missing []e.User
+		indexes []int
+	)
+	for i, value := range values {
+		if value == nil {
+			missing = append(missing, users[i])
+			indexes = append(indexes, i)
+			continue
+		}
+		if scores[i], err = strconv.Atoi(*value); err != nil {
+			return nil, fmt.Errorf("parsing score %q: %w", *value, err)
+		}
+	}
+
+	if len(missing) == 0 {
+		return scores, nil
+	}
+
+	stored, err := r.store.GetScores(ctx, missing, defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range missing {
+		scores[indexes[i]] = stored[i]
+		if err = r.client.Set(ctx, redisScoreKey(user), strconv.Itoa(stored[i]), redisScoreTTL); err != nil {
+			return nil, fmt.Errorf("setting score in redis: %w", err)
+		}
+	}
+
+	return scores, nil
+}
+
+// SetScore writes the score to the store and then to Redis
+func (r *RedisScores) SetScore(ctx context.Context, user e.User, score int) error {
+	if err := r.store.SetScore(ctx, user, score); err != nil {
+		r.Invalidate(user)
+		return err
+	}
+
+	if err := r.client.Set(ctx, redisScoreKey(user), strconv.Itoa(score), redisScoreTTL); err != nil {
+		return fmt.Errorf("setting score in redis: %w", err)
+	}
+
+	return nil
+}
+
+// Invalidate drops the user's score from Redis, e.g. after it was stored
+// with a decision. If Redis is unreachable the score expires on its own.
+func (r *RedisScores) Invalidate(user e.User) {
+	ctx, cancel := context.WithTimeout(context.Background(), redisInvalidateTimeout)
+	defer cancel()
+
+	_ = r.DropScore(ctx, user)
+}
+
+// DropScore deletes the user's score from Redis, so it's read from the store
+// next time, e.g. after another process changed it there
+func (r *RedisScores) DropScore(ctx context.Context, user e.User) error {
+	if _, err := r.client.Del(ctx, redisScoreKey(user)); err != nil {
+		return fmt.Errorf("deleting score from redis: %w", err)
+	}
+	return nil
+}
+
+// Purge drops all scores from Redis. They are scanned for and deleted in
+// batches, so Redis serves other commands meanwhile; a score missed or left
+// by a failure expires on its own.
+func (r *RedisScores) Purge() {
+	ctx, cancel := context.WithTimeout(context.Background(), redisPurgeTimeout)
+	defer cancel()
+
+	cursor := "0"
+	for {
+		next, keys, err := r.client.Scan(ctx, cursor, redisScorePrefix+"*", redisPurgeBatch)
+		if err != nil {
+			return
+		}
+		if len(keys) > 0 {
+			if _, err = r.client.Del(ctx, keys...); err != nil {
+				return
+			}
+		}
+		if cursor = next; cursor == "0" {
+			return
+		}
+	}
+}
+
+// LockUser serializes handling of the user's messages across replicas: it
+// waits for other replicas to release the user until the context is done
+func (r *RedisScores) LockUser(ctx context.Context, user e.User) (func(), error) {
+	unlock, err := r.client.Lock(ctx, redisLockPrefix+user.ChatID+":"+user.ID, redisLockTTL)
+	if err != nil {
+		return nil, err
+	}
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), redisInvalidateTimeout)
+		defer cancel()
+
+		// A lock failed to be released expires on its own
+		_ = unlock(ctx)
+	}, nil
+}
+
+func redisScoreKey(user e.User) string {
+	return redisScorePrefix + user.ChatID + ":" + user.ID
+}
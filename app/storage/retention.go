@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// Prune enforces the retention policy: erases bodies of messages older than
+// policy.Days and deletes messages beyond policy.MaxRowsPerChat per chat,
+// folding the deleted ones into the daily message_stats counters first.
+// Archived raw updates older than policy.RawUpdateDays and expired cached
+// verdicts are deleted.
+func (c *SQLite) Prune(ctx context.Context, policy e.RetentionPolicy) (e.PruneResult, error) {
+	var result e.PruneResult
+
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		if policy.MaxRowsPerChat > 0 {
+			// Messages beyond the newest MaxRowsPerChat of their chat
+			const doomed = `SELECT id FROM (
+					SELECT id, ROW_NUMBER() OVER (PARTITION BY chat_id ORDER BY created_at DESC, id DESC) AS n
+					FROM messages
+				) WHERE n > ?`
+
+			_, err := tx.ExecContext(
+				ctx,
+				`INSERT INTO message_stats (
+					chat_id, day, action, category, has_error, count,
+					ai_requests, prompt_tokens, completion_tokens, ai_cost
+				 )
+				 SELECT chat_id, date(created_at), COALESCE(action, ''), COALESCE(category, ''), error IS NOT NULL, COUNT(*),
+				        COUNT(prompt_tokens), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+				        COALESCE(SUM(ai_cost), 0)
+				 FROM messages
+				 WHERE id IN (`+doomed+`)
+				 GROUP BY 1, 2, 3, 4, 5
+				 ON CONFLICT (chat_id, day, action, category, has_error) DO UPDATE
+				     SET count = count + excluded.count,
+				         ai_requests = ai_requests + excluded.ai_requests,
+				         prompt_tokens = prompt_tokens + excluded.prompt_tokens,
+				         completion_tokens = completion_tokens + excluded.completion_tokens,
+				         ai_cost = ai_cost + excluded.ai_cost`,
+				policy.MaxRowsPerChat,
+			)
+			if err != nil {
+				return fmt.Errorf("folding messages into counters: %w", err)
+			}
+
+			res, err := tx.ExecContext(ctx, `DELETE FROM messages WHERE id IN (`+doomed+`)`, policy.MaxRowsPerChat)
+			if err != nil {
+				return fmt.Errorf("deleting messages: %w", err)
+			}
+			result.RowsDeleted, _ = res.RowsAffected()
+		}
+
+		if policy.Days > 0 {
+			cutoff := time.Now().AddDate(0, 0, -policy.Days)
+			res, err := tx.ExecContext(
+				ctx,
+				`UPDATE messages SET text = '', media_file_id = NULL
+				 WHERE created_at < ? AND (text != '' OR media_file_id IS NOT NULL)`,
+				formatTime(cutoff),
+			)
+			if err != nil {
+				return fmt.Errorf("erasing message bodies: %w", err)
+			}
+			result.BodiesErased, _ = res.RowsAffected()
+		}
+
+		if policy.RawUpdateDays > 0 {
+			cutoff := time.Now().AddDate(0, 0, -policy.RawUpdateDays)
+			res, err := tx.ExecContext(ctx, `DELETE FROM raw_updates WHERE created_at < ?`, formatTime(cutoff))
+			if err != nil {
+				return fmt.Errorf("deleting raw updates: %w", err)
+			}
+			result.RawUpdatesDeleted, _ = res.RowsAffected()
+		}
+
+		res, err := tx.ExecContext(ctx, `DELETE FROM verdict_cache WHERE expires_at <= ?`, formatTime(time.Now()))
+		if err != nil {
+			return fmt.Errorf("deleting expired verdicts: %w", err)
+		}
+		result.VerdictsDeleted, _ = res.RowsAffected()
+
+		return nil
+	})
+
+	return result, err
+}
+
+// Vacuum rebuilds the database to give the space of deleted and erased data
+// back to the file system and truncates the write-ahead log. The database is
+// locked for writes while it runs, which takes a while for a large one.
+func (c *SQLite) Vacuum(ctx context.Context) (e.VacuumResult, error) {
+	if c.readOnly {
+		return e.VacuumResult{}, ErrReadOnly
+	}
+
+	var result e.VacuumResult
+	var err error
+	if result.SizeBefore, err = c.size(ctx); err != nil {
+		return result, err
+	}
+
+	if _, err = c.db.ExecContext(ctx, "VACUUM"); err != nil {
+		return result, fmt.Errorf("vacuuming: %w", err)
+	}
+	if _, err = c.db.ExecContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+		return result, fmt.Errorf("checkpointing: %w", err)
+	}
+
+	if result.SizeAfter, err = c.size(ctx); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// size returns the size of the database pages in bytes
+func (c *SQLite) size(ctx context.Context) (int64, error) {
+	var pages, pageSize int64
+	if err := c.db.QueryRowContext(ctx, "PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, fmt.Errorf("getting page count: %w", err)
+	}
+	if err := c.db.QueryRowContext(ctx, "PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("getting page size: %w", err)
+	}
+	return pages * pageSize, nil
+}
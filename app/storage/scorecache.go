@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// ScoreStore stores user scores
+type ScoreStore interface {
+	GetScore(ctx context.Context, user e.User, defaultValue int) (int, error)
+	GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error)
+	SetScore(ctx context.Context, user e.User, score int) error
+}
+
+// scoreMemoryTTL is how long a score is used from memory before it is read
+// from the store again, so one changed by another process, e.g. by cmd/admin,
+// takes effect
+const scoreMemoryTTL = time.Minute
+
+// ScoreCache is a write-through LRU cache of user scores in front of a
+// store, sparing a database read per message of an active user. Scores
+// changed bypassing the cache in the process must be dropped from it with
+// Invalidate, those changed by other processes are read again within
+// scoreMemoryTTL.
+type ScoreCache struct {
+	store ScoreStore
+	size  int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *scoreEntry, most recently used first
+	entries map[scoreKey]*list.Element
+}
+
+type scoreKey struct {
+	chatID, userID string
+}
+
+type scoreEntry struct {
+	key   scoreKey
+	score int
+	stale time.Time
+}
+
+// NewScoreCache returns a cache of at most size scores in front of the store
+func NewScoreCache(store ScoreStore, size int) *ScoreCache {
+	return &ScoreCache{
+		store:   store,
+		size:    size,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[scoreKey]*list.Element, size),
+	}
+}
+
+// GetScore returns the cached score of the user, reading it from the store
+// on a miss. The default value of a user without a score is cached too, so
+// callers must pass the same default.
+func (c *ScoreCache) GetScore(ctx context.Context, user e.User, defaultValue int) (int, error) {
+	key := scoreKey{chatID: user.ChatID, userID: user.ID}
+
+	c.mu.Lock()
+	if score, ok := c.lookup(key); ok {
+		c.mu.Unlock()
+		return score, nil
+	}
+	c.mu.Unlock()
+
+	score, err := c.store.GetScore(ctx, user, defaultValue)
+	if err != nil {
+		return 0, err
+	}
+
+	c.put(key, score)
+	return score, nil
+}
+
+// GetScores returns the scores of the users in the same order, looking up
+// the ones missing in the cache with a single read from the store
+func (c *ScoreCache) GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error) {
+	scores := make([]int, len(users))
+
+	var (
+		missing []e.User
+		indexes []int
+	)
+	c.mu.Lock()
+	for i, user := range users {
+		score, ok := c.lookup(scoreKey{chatID: user.ChatID, userID: user.ID})
+		if !ok {
+			missing = append(missing, user)
+			indexes = append(indexes, i)
+			continue
+		}
+		scores[i] = score
+	}
+	c.mu.Unlock()
+
+	if len(missing) == 0 {
+		return scores, nil
+	}
+
+	stored, err := c.store.GetScores(ctx, missing, defaultValue)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, user := range missing {
+		scores[indexes[i]] = stored[i]
+		c.put(scoreKey{chatID: user.ChatID, userID: user.ID}, stored[i])
+	}
+
+	return scores, nil
+}
+
+// SetScore writes the score to the store and then to the cache
+func (c *ScoreCache) SetScore(ctx context.Context, user e.User, score int) error {
+	if err := c.store.SetScore(ctx, user, score); err != nil {
+		c.Invalidate(user)
+		return err
+	}
+
+	c.put(scoreKey{chatID: user.ChatID, userID: user.ID}, score)
+	return nil
+}
+
+// Invalidate drops the cached score of the user, e.g. after an admin
+// override changed it in the store
+func (c *ScoreCache) Invalidate(user e.User) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := scoreKey{chatID: user.ChatID, userID: user.ID}
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// Purge drops all cached scores
+func (c *ScoreCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	clear(c.entries)
+}
+
+// lookup returns the cached score, dropping it if it's stale. The caller
+// holds c.mu.
+func (c *ScoreCache) lookup(key scoreKey) (int, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return 0, false
+	}
+
+	entry := el.Value.(*scoreEntry)
+	if !c.now().Before(entry.stale) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return 0, false
+	}
+
+	c.order.MoveToFront(el)
+	return entry.score, true
+}
+
+func (c *ScoreCache) put(key scoreKey, score int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stale := c.now().Add(scoreMemoryTTL)
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*scoreEntry)
+		entry.score, entry.stale = score, stale
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&scoreEntry{key: key, score: score, stale: stale})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*scoreEntry).key)
+	}
+}
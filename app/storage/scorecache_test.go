@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type countingScores struct {
+	scores map[string]int
+	reads  int
+	fail   bool
+}
+
+func (s *countingScores) GetScore(_ context.Context, user e.User, defaultValue int) (int, error) {
+	s.reads++
+	if score, ok := s.scores[user.ChatID+"/"+user.ID]; ok {
+		return score, nil
+	}
+	return defaultValue, nil
+}
+
+func (s *countingScores) GetScores(_ context.Context, users []e.User, defaultValue int) ([]int, error) {
+	s.reads++
+	scores := make([]int, len(users))
+	for i, user := range users {
+		score, ok := s.scores[user.ChatID+"/"+user.ID]
+		if !ok {
+			score = defaultValue
+		}
+		scores[i] = score
+	}
+	return scores, nil
+}
+
+func (s *countingScores) SetScore(_ context.Context, user e.User, score int) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	s.scores[user.ChatID+"/"+user.ID] = score
+	return nil
+}
+
+func TestScoreCache(t *testing.T) {
+	ctx := context.Background()
+	store := &countingScores{scores: map[string]int{"-100/1": 5}}
+	cache := NewScoreCache(store, 2)
+
+	u1 := e.User{ID: "1", ChatID: "-100"}
+	u2 := e.User{ID: "2", ChatID: "-100"}
+	u3 := e.User{ID: "1", ChatID: "-200"}
+
+	get := func(user e.User) int {
+		t.Helper()
+		score, err := cache.GetScore(ctx, user, 0)
+		if err != nil {
+			t.Fatalf("GetScore: %v", err)
+		}
+		return score
+	}
+
+	if get(u1) != 5 || get(u1) != 5 || store.reads != 1 {
+		t.Errorf("repeated reads hit the store %d times, want 1", store.reads)
+	}
+
+	// Write-through
+	if err := cache.SetScore(ctx, u2, 3); err != nil {
+		t.Fatalf("SetScore: %v", err)
+	}
+	if store.scores["-100/2"] != 3 || get(u2) != 3 || store.reads != 1 {
+		t.Errorf("written score not served from the cache: store %v, reads %d", store.scores, store.reads)
+	}
+
+	// The least recently used user 1 of chat -100 is evicted
+	get(u3)
+	reads := store.reads
+	get(u1)
+	if store.reads != reads+1 {
+		t.Error("evicted score served from the cache")
+	}
+
+	// Changes bypassing the cache are seen after invalidation
+	store.scores["-100/1"] = -1
+	cache.Invalidate(u1)
+	if get(u1) != -1 {
+		t.Error("invalidated score served from the cache")
+	}
+
+	// A failed write doesn't leave a stale score behind
+	store.fail = true
+	if err := cache.SetScore(ctx, u1, 6); err == nil {
+		t.Fatal("SetScore must fail with the store")
+	}
+	store.fail = false
+	if get(u1) != -1 {
+		t.Error("score of a failed write served from the cache")
+	}
+
+	// Only the missing scores are read from the store, at once
+	reads = store.reads
+	scores, err := cache.GetScores(ctx, []e.User{u1, {ID: "3", ChatID: "-100"}}, 0)
+	if err != nil {
+		t.Fatalf("GetScores: %v", err)
+	}
+	if scores[0] != -1 || scores[1] != 0 || store.reads != reads+1 {
+		t.Errorf("GetScores = %v with %d reads, want [-1 0] with 1 read", scores, store.reads-reads)
+	}
+
+	// Changes by other processes are seen once the cached score is stale
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	cache.Invalidate(u1)
+	get(u1)
+	store.scores["-100/1"] = 6
+	if get(u1) != -1 {
+		t.Error("fresh score read from the store again")
+	}
+	now = now.Add(scoreMemoryTTL)
+	if get(u1) != 6 {
+		t.Error("stale score served from the cache")
+	}
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// The full-text index of message texts is an FTS5 table kept in sync with
+// messages by triggers. FTS5 is compiled into the SQLite driver only with the
+// sqlite_fts5 build tag, so the index is not a versioned migration: it is
+// created after migrating when the driver supports it, and SearchMessages
+// falls back to a substring scan otherwise.
+var searchIndexStatements = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+		text,
+		content = 'messages',
+		content_rowid = 'id',
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts__insert AFTER INSERT ON messages BEGIN
+		INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts__delete AFTER DELETE ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS messages_fts__update AFTER UPDATE OF text ON messages BEGIN
+		INSERT INTO messages_fts (messages_fts, rowid, text) VALUES ('delete', old.id, old.text);
+		INSERT INTO messages_fts (rowid, text) VALUES (new.id, new.text);
+	END`,
+}
+
+// SearchMessages returns messages whose text contains all words of the
+// query and which match the filter, newest first
+func (c *SQLite) SearchMessages(ctx context.Context, query string, filter MessageFilter) ([]e.SavedMessage, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("empty search query")
+	}
+
+	indexed, err := c.hasSearchIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := filter.conditions()
+	from := "messages AS m"
+	if indexed {
+		from = "messages_fts JOIN messages AS m ON m.id = messages_fts.rowid"
+		where = append(where, "messages_fts MATCH ?")
+		args = append(args, ftsQuery(terms))
+	} else {
+		for _, term := range terms {
+			where = append(where, `m.text LIKE ? ESCAPE '\'`)
+			args = append(args, "%"+likeEscaper.Replace(term)+"%")
+		}
+	}
+
+	sqlQuery := `SELECT ` + messageColumns + ` FROM ` + from +
+		` WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY m.created_at DESC, m.id DESC`
+	paging, pagingArgs := filter.paging()
+	sqlQuery += paging
+	args = append(args, pagingArgs...)
+
+	return c.queryMessages(ctx, sqlQuery, args...)
+}
+
+// ftsQuery builds an FTS5 query matching all terms, each quoted so that
+// user input can't use the query syntax
+func ftsQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return strings.Join(quoted, " ")
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ensureSearchIndex creates the full-text index if the driver supports it
+// and fills it from existing messages, or drops it once messages are gone
+func (c *SQLite) ensureSearchIndex(ctx context.Context) error {
+	var available bool
+	err := c.db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&available)
+	if err != nil {
+		return fmt.Errorf("checking fts5 support: %w", err)
+	}
+	if !available {
+		return nil
+	}
+
+	var messages bool
+	err = c.db.QueryRowContext(
+		ctx,
+		"SELECT EXISTS (SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'messages')",
+	).Scan(&messages)
+	if err != nil {
+		return fmt.Errorf("checking messages table: %w", err)
+	}
+
+	if !messages {
+		_, err = c.db.ExecContext(ctx, "DROP TABLE IF EXISTS messages_fts")
+		return err
+	}
+
+	if indexed, err := c.hasSearchIndex(ctx); err != nil || indexed {
+		return err
+	}
+
+	return c.inTx(ctx, func(tx *sql.Tx) error {
+		for _, stmt := range searchIndexStatements {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("creating search index: %w", err)
+			}
+		}
+		_, err := tx.ExecContext(ctx, "INSERT INTO messages_fts (messages_fts) VALUES ('rebuild')")
+		return err
+	})
+}
+
+// hasSearchIndex reports whether the full-text index and all its triggers
+// exist
+func (c *SQLite) hasSearchIndex(ctx context.Context) (bool, error) {
+	var count int
+	err := c.db.QueryRowContext(
+		ctx,
+		`SELECT COUNT(*) FROM sqlite_master
+		 WHERE (type = 'table' AND name = 'messages_fts')
+		    OR (type = 'trigger' AND name IN ('messages_fts__insert', 'messages_fts__delete', 'messages_fts__update'))`,
+	).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("checking search index: %w", err)
+	}
+	return count == 1+3, nil
+}
@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// GetChatSettings returns the stored settings of the chat, found is false if
+// the chat has none
+func (c *SQLite) GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, bool, error) {
+	var raw string
+	err := c.db.QueryRowContext(
+		ctx,
+		"SELECT settings FROM chat_settings WHERE chat_id = ?",
+		chatID,
+	).Scan(&raw)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.ChatSettings{}, false, nil
+		}
+
+		return e.ChatSettings{}, false, err
+	}
+
+	var settings e.ChatSettings
+	if err = json.Unmarshal([]byte(raw), &settings); err != nil {
+		return e.ChatSettings{}, false, fmt.Errorf("decoding settings of chat %s: %w", chatID, err)
+	}
+
+	return settings, true, nil
+}
+
+// SetChatSettings stores the settings of the chat, replacing previous ones
+func (c *SQLite) SetChatSettings(ctx context.Context, chatID string, settings e.ChatSettings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("encoding settings: %w", err)
+	}
+
+	_, err = c.db.ExecContext(
+		ctx,
+		`INSERT INTO chat_settings (chat_id, settings, updated_at)
+			VALUES (?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(chat_id) DO UPDATE
+			    SET settings = excluded.settings, updated_at = CURRENT_TIMESTAMP`,
+		chatID, string(raw),
+	)
+	return err
+}
+
+// DeleteChatSettings removes the stored settings of the chat
+func (c *SQLite) DeleteChatSettings(ctx context.Context, chatID string) error {
+	_, err := c.db.ExecContext(ctx, "DELETE FROM chat_settings WHERE chat_id = ?", chatID)
+	return err
+}
@@ -3,9 +3,13 @@ package storage
 import (
 	"context"
 	"database/sql"
-	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -14,34 +18,200 @@ import (
 
 type SQLite struct {
 	db *sql.DB
+
+	// key unlocks the database and its backups, empty if it is not
+	// encrypted
+	key string
+
+	readOnly bool
+
+	mu    sync.Mutex
+	stmts map[string]*sql.Stmt
+	// chats are titles of chats already stored, sparing an upsert of the
+	// chat for every saved message
+	chats map[string]string
+}
+
+// SQLiteOptions tune the connection, zero fields keep the driver defaults
+type SQLiteOptions struct {
+	// JournalMode is the journal_mode pragma, e.g. WAL, which lets readers
+	// work while a write is in progress
+	JournalMode string
+
+	// BusyTimeout is how long a connection waits for a lock held by another
+	// one before failing with SQLITE_BUSY
+	BusyTimeout time.Duration
+
+	// ForeignKeys enables foreign key constraints enforcement
+	ForeignKeys bool
+
+	// MaxOpenConns bounds the connection pool
+	MaxOpenConns int
+
+	// Key unlocks a database encrypted with SQLCipher, empty for a plain
+	// database. It requires the bot built with the libsqlite3 tag against
+	// the SQLCipher library.
+	Key string
+
+	// ReadOnly opens the database for reading only, so tools can query the
+	// live database without taking write locks. Immutable also skips
+	// locking altogether, which is only safe for a copy nobody writes to,
+	// e.g. a backup.
+	ReadOnly  bool
+	Immutable bool
+}
+
+// DefaultSQLiteOptions suit concurrent workers writing to the database
+var DefaultSQLiteOptions = SQLiteOptions{
+	JournalMode:  "WAL",
+	BusyTimeout:  5 * time.Second,
+	ForeignKeys:  true,
+	MaxOpenConns: 4,
+}
+
+// NewSQLite opens the database and applies pending schema migrations
+func NewSQLite(ctx context.Context, filePath string, opts SQLiteOptions) (*SQLite, error) {
+	client, err := OpenSQLite(filePath, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	err = client.MigrateUp(ctx)
+	if err != nil {
+		_ = client.Close()
+		return nil, fmt.Errorf("migrating sqlite3 database: %w", err)
+	}
+
+	return client, nil
 }
 
-func NewSQLite(ctx context.Context, filePath string) (*SQLite, error) {
-	db, err := sql.Open("sqlite3", filePath)
+// OpenSQLite opens the database as is, without migrating its schema
+func OpenSQLite(filePath string, opts SQLiteOptions) (*SQLite, error) {
+	db, err := openSQLiteDB(sqliteDSN(filePath, opts), opts.Key, opts.JournalMode)
 	if err != nil {
 		return nil, fmt.Errorf("opening sqlite3 database: %w", err)
 	}
 
-	client := &SQLite{
-		db: db,
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+		db.SetMaxIdleConns(opts.MaxOpenConns)
+	}
+
+	if opts.Key != "" {
+		// Report a wrong key or a build without SQLCipher right away
+		if err = db.Ping(); err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("opening encrypted sqlite3 database: %w", err)
+		}
+	}
+
+	return &SQLite{
+		db:       db,
+		key:      opts.Key,
+		readOnly: opts.ReadOnly || opts.Immutable,
+		stmts:    make(map[string]*sql.Stmt),
+		chats:    make(map[string]string),
+	}, nil
+}
+
+// sqliteDSN adds the options to the file path as driver parameters, which
+// the driver applies to every connection of the pool. Parameters already in
+// the path take precedence.
+func sqliteDSN(filePath string, opts SQLiteOptions) string {
+	params := url.Values{}
+	readOnly := opts.ReadOnly || opts.Immutable
+
+	// The journal mode of an encrypted database is set after unlocking it,
+	// a read-only connection can't change it
+	if opts.JournalMode != "" && opts.Key == "" && !readOnly {
+		params.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	}
+	if opts.ForeignKeys {
+		params.Set("_foreign_keys", "on")
+	}
+	// Take the write lock when a transaction begins rather than on its first
+	// write, so the busy timeout applies instead of failing on lock upgrade
+	params.Set("_txlock", "immediate")
+
+	path, query, _ := strings.Cut(filePath, "?")
+	if readOnly {
+		// Open modes are URI parameters, passed to SQLite for file: paths only
+		if !strings.HasPrefix(path, "file:") {
+			path = "file:" + path
+		}
+		params.Set("mode", "ro")
+		params.Set("_query_only", "true")
+		if opts.Immutable {
+			params.Set("immutable", "1")
+		}
 	}
 
-	err = client.init(ctx)
+	existing, err := url.ParseQuery(query)
 	if err != nil {
-		_ = db.Close()
-		return nil, fmt.Errorf("initializing sqlite3 database: %w", err)
+		return filePath
+	}
+	for key, values := range existing {
+		params[key] = values
 	}
 
-	return client, nil
+	return path + "?" + params.Encode()
 }
 
 func (c *SQLite) Close() error {
+	c.mu.Lock()
+	for query, stmt := range c.stmts {
+		_ = stmt.Close()
+		delete(c.stmts, query)
+	}
+	c.mu.Unlock()
+
 	return c.db.Close()
 }
 
+// stmt returns the query prepared on its first use. Statements of the hot
+// path are prepared once rather than parsed for every message.
+func (c *SQLite) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if stmt, ok := c.stmts[query]; ok {
+		return stmt, nil
+	}
+
+	stmt, err := c.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("preparing statement: %w", err)
+	}
+	c.stmts[query] = stmt
+
+	return stmt, nil
+}
+
+// exec executes the query as a prepared statement
+func (c *SQLite) exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(ctx, args...)
+}
+
+// queryRow queries a row with a prepared statement
+func (c *SQLite) queryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	stmt, err := c.stmt(ctx, query)
+	if err != nil {
+		// Let the driver report the error on Scan
+		return c.db.QueryRowContext(ctx, query, args...)
+	}
+	return stmt.QueryRowContext(ctx, args...)
+}
+
 func (c *SQLite) GetScore(ctx context.Context, user e.User, defaultValue int) (int, error) {
 	var score int
-	err := c.db.QueryRowContext(
+	err := c.queryRow(
 		ctx,
 		"SELECT score FROM scores WHERE chat_id = ? and user_id = ?",
 		user.ChatID, user.ID,
@@ -57,67 +227,403 @@ func (c *SQLite) GetScore(ctx context.Context, user e.User, defaultValue int) (i
 	return score, nil
 }
 
+// scoresBatchSize bounds the number of users looked up by a query, keeping
+// it within the limit of bound parameters
+const scoresBatchSize = 500
+
+// GetScores returns the scores of the users in the same order, the default
+// value for users without a score
+func (c *SQLite) GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error) {
+	scores := make([]int, len(users))
+	for start := 0; start < len(users); start += scoresBatchSize {
+		batch := users[start:min(start+scoresBatchSize, len(users))]
+
+		args := make([]any, 0, 2*len(batch))
+		for _, user := range batch {
+			args = append(args, user.ChatID, user.ID)
+		}
+		values := strings.TrimSuffix(strings.Repeat("(?, ?), ", len(batch)), ", ")
+
+		found := make(map[[2]string]int, len(batch))
+		rows, err := c.db.QueryContext(
+			ctx,
+			"SELECT chat_id, user_id, score FROM scores WHERE (chat_id, user_id) IN (VALUES "+values+")",
+			args...,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("querying scores: %w", err)
+		}
+		for rows.Next() {
+			var (
+				chatID, userID string
+				score          int
+			)
+			if err = rows.Scan(&chatID, &userID, &score); err != nil {
+				_ = rows.Close()
+				return nil, fmt.Errorf("scanning score: %w", err)
+			}
+			found[[2]string{chatID, userID}] = score
+		}
+		_ = rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("iterating over scores: %w", err)
+		}
+
+		for i, user := range batch {
+			score, ok := found[[2]string{user.ChatID, user.ID}]
+			if !ok {
+				score = defaultValue
+			}
+			scores[start+i] = score
+		}
+	}
+
+	return scores, nil
+}
+
 func (c *SQLite) SetScore(ctx context.Context, user e.User, score int) error {
-	_, err := c.db.ExecContext(
+	_, err := c.exec(
 		ctx,
-		`INSERT INTO scores (chat_id, user_id, user_name, score, updated_at)
-			VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP) 
-			ON CONFLICT(chat_id, user_id) DO UPDATE 
-			    SET score = ?, updated_at = CURRENT_TIMESTAMP`,
+		setScoreQuery,
 		user.ChatID, user.ID, user.Name, score, score,
 	)
 	return err
 }
 
-func (c *SQLite) SaveMessage(ctx context.Context, msg e.Message) (int64, error) {
-	_, err := c.db.ExecContext(
+func (c *SQLite) GetProbation(ctx context.Context, user e.User) (int, bool, error) {
+	var remaining int
+	err := c.queryRow(
 		ctx,
-		`INSERT INTO chats (
-			chat_id, title, created_at
-		) VALUES (
-			?, ?, CURRENT_TIMESTAMP
-		) ON CONFLICT(chat_id) DO UPDATE SET title = ?`,
-		msg.Sender.ChatID, msg.Sender.ChatTitle, msg.Sender.ChatTitle,
-	)
+		"SELECT remaining FROM probations WHERE chat_id = ? and user_id = ?",
+		user.ChatID, user.ID,
+	).Scan(&remaining)
 	if err != nil {
-		return 0, fmt.Errorf("inserting chat: %w", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+
+		return 0, false, err
 	}
 
-	result, err := c.db.ExecContext(
+	return remaining, true, nil
+}
+
+func (c *SQLite) SetProbation(ctx context.Context, user e.User, remaining int) error {
+	_, err := c.exec(
 		ctx,
-		`INSERT INTO messages (
-			message_id, chat_id, sender_user_id, sender_user_name, text, created_at, action, action_note,
-			media_type, media_file_id, media_size
-		) VALUES (
-			?, ?, ?, ?, ?, CURRENT_TIMESTAMP, NULL, NULL,
-			?, ?, ?
-		)`,
-		msg.ID, msg.Sender.ChatID, msg.Sender.ID, msg.Sender.Name, msg.Text,
-		msg.MediaType, msg.MediaFileID, msg.MediaSize,
+		`INSERT INTO probations (chat_id, user_id, remaining, updated_at)
+			VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(chat_id, user_id) DO UPDATE
+			    SET remaining = ?, updated_at = CURRENT_TIMESTAMP`,
+		user.ChatID, user.ID, remaining, remaining,
 	)
+	return err
+}
+
+func (c *SQLite) SaveMessage(ctx context.Context, msg e.Message) (int64, error) {
+	return c.SaveDecision(ctx, e.Decision{Message: msg})
+}
+
+// SaveDecision stores the message with the action taken on it and the
+// sender's new score in a single transaction
+func (c *SQLite) SaveDecision(ctx context.Context, decision e.Decision) (int64, error) {
+	ids, err := c.SaveDecisions(ctx, []e.Decision{decision})
 	if err != nil {
-		return 0, fmt.Errorf("inserting message: %w", err)
+		return 0, err
 	}
 
-	id, err := result.LastInsertId()
+	return ids[0], nil
+}
+
+const (
+	upsertChatQuery = `INSERT INTO chats (
+			chat_id, title, created_at
+		) VALUES (
+			?, ?, CURRENT_TIMESTAMP
+		) ON CONFLICT(chat_id) DO UPDATE SET title = ?`
+
+	// A message already stored, e.g. from a re-delivered update or an edit,
+	// gets the new content. Its decision is replaced only by a new one, a
+	// save without an action or an error keeps it.
+	upsertMessageQuery = `INSERT INTO messages (
+			message_id, chat_id, sender_user_id, sender_user_name, text, created_at,
+			action, action_note, category, trace, error,
+			media_type, media_file_id, media_size,
+			prompt_tokens, completion_tokens, ai_cost, model, prompt_version
+		) VALUES (
+			?, ?, ?, ?, ?, CURRENT_TIMESTAMP,
+			?, ?, ?, ?, ?,
+			?, ?, ?,
+			?, ?, ?, ?, ?
+		) ON CONFLICT(chat_id, message_id) DO UPDATE SET
+			sender_user_name = excluded.sender_user_name,
+			text = excluded.text,
+			media_type = excluded.media_type,
+			media_file_id = excluded.media_file_id,
+			media_size = excluded.media_size,
+			action = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.action ELSE action END,
+			action_note = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.action_note ELSE action_note END,
+			category = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.category ELSE category END,
+			trace = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.trace ELSE trace END,
+			error = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.error ELSE error END,
+			prompt_tokens = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.prompt_tokens ELSE prompt_tokens END,
+			completion_tokens = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.completion_tokens ELSE completion_tokens END,
+			ai_cost = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.ai_cost ELSE ai_cost END,
+			model = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.model ELSE model END,
+			prompt_version = CASE WHEN excluded.action IS NOT NULL OR excluded.error IS NOT NULL THEN excluded.prompt_version ELSE prompt_version END
+		RETURNING id`
+
+	setScoreQuery = `INSERT INTO scores (chat_id, user_id, user_name, score, updated_at)
+			VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP) 
+			ON CONFLICT(chat_id, user_id) DO UPDATE 
+			    SET score = ?, updated_at = CURRENT_TIMESTAMP`
+)
+
+// SaveDecisions stores the decisions in a single transaction and returns the
+// IDs of their messages in the same order. A message already stored in the
+// chat is updated in place and keeps its ID. Each chat is upserted once, and
+// only if its title is not stored yet.
+func (c *SQLite) SaveDecisions(ctx context.Context, decisions []e.Decision) ([]int64, error) {
+	upsertChat, err := c.stmt(ctx, upsertChatQuery)
+	if err != nil {
+		return nil, err
+	}
+	upsertMessage, err := c.stmt(ctx, upsertMessageQuery)
+	if err != nil {
+		return nil, err
+	}
+	setScore, err := c.stmt(ctx, setScoreQuery)
 	if err != nil {
-		return 0, fmt.Errorf("getting last insert id: %w", err)
+		return nil, err
 	}
 
-	return id, nil
+	chats := make(map[string]string)
+	for _, d := range decisions {
+		sender := d.Message.Sender
+		if !c.chatStored(sender.ChatID, sender.ChatTitle) {
+			chats[sender.ChatID] = sender.ChatTitle
+		}
+	}
+
+	ids := make([]int64, len(decisions))
+	err = c.inTx(ctx, func(tx *sql.Tx) error {
+		for chatID, title := range chats {
+			_, err := tx.StmtContext(ctx, upsertChat).ExecContext(ctx, chatID, title, title)
+			if err != nil {
+				return fmt.Errorf("inserting chat: %w", err)
+			}
+		}
+
+		upsert := tx.StmtContext(ctx, upsertMessage)
+		for i, d := range decisions {
+			msg := d.Message
+
+			var (
+				kind, note, category, trace, model, prompt sql.NullString
+				promptTokens, completionTokens             sql.NullInt64
+				cost                                       sql.NullFloat64
+			)
+			if d.Action != nil && d.Action.Usage != nil {
+				usage := d.Action.Usage
+				promptTokens = sql.NullInt64{Int64: int64(usage.PromptTokens), Valid: true}
+				completionTokens = sql.NullInt64{Int64: int64(usage.CompletionTokens), Valid: true}
+				cost = sql.NullFloat64{Float64: usage.Cost, Valid: true}
+			}
+			if d.Action != nil {
+				encoded, err := json.Marshal(d.Action.Trace)
+				if err != nil {
+					return fmt.Errorf("marshaling trace: %w", err)
+				}
+				kind = nullString(string(d.Action.Kind))
+				note = sql.NullString{String: d.Action.Note, Valid: true}
+				category = nullString(string(d.Action.Category))
+				trace = nullString(string(encoded))
+				model = nullString(d.Action.Trace.Model)
+				prompt = nullString(d.Action.Trace.PromptVersion)
+			}
+
+			err := upsert.QueryRowContext(
+				ctx,
+				msg.ID, msg.Sender.ChatID, msg.Sender.ID, msg.Sender.Name, msg.Text,
+				kind, note, category, trace, nullString(d.Error),
+				msg.MediaType, msg.MediaFileID, msg.MediaSize,
+				promptTokens, completionTokens, cost, model, prompt,
+			).Scan(&ids[i])
+			if err != nil {
+				return fmt.Errorf("upserting message: %w", err)
+			}
+
+			if d.Score == nil {
+				continue
+			}
+			_, err = tx.StmtContext(ctx, setScore).ExecContext(
+				ctx,
+				msg.Sender.ChatID, msg.Sender.ID, msg.Sender.Name, *d.Score, *d.Score,
+			)
+			if err != nil {
+				return fmt.Errorf("setting score: %w", err)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	for chatID, title := range chats {
+		c.chats[chatID] = title
+	}
+	c.mu.Unlock()
+
+	return ids, nil
 }
 
-func (c *SQLite) ListMessages(ctx context.Context, fromDate time.Time) ([]e.SavedMessage, error) {
-	rows, err := c.db.QueryContext(
+// chatStored reports whether the chat is stored with the title
+func (c *SQLite) chatStored(chatID, title string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stored, ok := c.chats[chatID]
+	return ok && stored == title
+}
+
+// forgetChats drops the stored chats cache after the database contents were
+// replaced
+func (c *SQLite) forgetChats() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.chats)
+}
+
+// CountUserMessages returns the number of messages of the user ever stored in
+// the user's chat, including the ones since deleted by the retention policy
+func (c *SQLite) CountUserMessages(ctx context.Context, user e.User) (int, error) {
+	var count int
+	err := c.queryRow(
 		ctx,
-		`SELECT m.id, m.message_id, m.chat_id, m.sender_user_id, m.sender_user_name, m.text,
-		        m.created_at, m.action, m.action_note, m.error,
-		        m.media_type, m.media_file_id, m.media_size
-		 FROM messages AS m
-		 WHERE m.created_at >= ?
-		 ORDER BY m.created_at DESC`,
-		fromDate,
-	)
+		"SELECT count FROM user_message_counts WHERE chat_id = ? AND user_id = ?",
+		user.ChatID, user.ID,
+	).Scan(&count)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("counting messages: %w", err)
+	}
+
+	return count, nil
+}
+
+// MessageFilter selects stored messages, zero fields match any message
+type MessageFilter struct {
+	ChatID   string
+	SenderID string
+	Action   e.ActionKind
+
+	// From and To bound the time the message was received, From inclusive
+	// and To exclusive
+	From time.Time
+	To   time.Time
+
+	// HasMedia selects only messages with a media attachment
+	HasMedia bool
+
+	// Model and PromptVersion select messages decided by the AI model or
+	// with the prompt version. ExceptPromptVersion selects messages decided
+	// by the AI with any other prompt version, e.g. older ones.
+	Model               string
+	PromptVersion       string
+	ExceptPromptVersion string
+
+	// Limit caps the number of returned messages, Offset skips the first
+	// ones, for paging through results
+	Limit  int
+	Offset int
+}
+
+// ListMessages returns messages matching the filter, newest first
+func (c *SQLite) ListMessages(ctx context.Context, filter MessageFilter) ([]e.SavedMessage, error) {
+	where, args := filter.conditions()
+
+	query := `SELECT ` + messageColumns + ` FROM messages AS m`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY m.created_at DESC, m.id DESC"
+	paging, pagingArgs := filter.paging()
+	query += paging
+	args = append(args, pagingArgs...)
+
+	return c.queryMessages(ctx, query, args...)
+}
+
+// messageColumns are the columns of messages aliased as m scanned by
+// scanMessage
+const messageColumns = `m.message_id, m.chat_id, m.sender_user_id, m.sender_user_name, m.text,
+		m.created_at, m.action, m.action_note, m.error,
+		m.media_type, m.media_file_id, m.media_size, m.category, m.trace,
+		m.prompt_tokens, m.completion_tokens, m.ai_cost`
+
+// conditions returns the WHERE conditions of the filter over messages
+// aliased as m
+func (filter MessageFilter) conditions() (where []string, args []any) {
+	if filter.ChatID != "" {
+		where = append(where, "m.chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if filter.SenderID != "" {
+		where = append(where, "m.sender_user_id = ?")
+		args = append(args, filter.SenderID)
+	}
+	if filter.Action != "" {
+		where = append(where, "m.action = ?")
+		args = append(args, string(filter.Action))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "m.created_at >= ?")
+		args = append(args, formatTime(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "m.created_at < ?")
+		args = append(args, formatTime(filter.To))
+	}
+	if filter.HasMedia {
+		where = append(where, "m.media_file_id IS NOT NULL")
+	}
+	if filter.Model != "" {
+		where = append(where, "m.model = ?")
+		args = append(args, filter.Model)
+	}
+	if filter.PromptVersion != "" {
+		where = append(where, "m.prompt_version = ?")
+		args = append(args, filter.PromptVersion)
+	}
+	if filter.ExceptPromptVersion != "" {
+		where = append(where, "m.prompt_version != ?")
+		args = append(args, filter.ExceptPromptVersion)
+	}
+	return where, args
+}
+
+// paging returns the LIMIT clause of the filter, empty if it doesn't page
+func (filter MessageFilter) paging() (string, []any) {
+	if filter.Limit <= 0 && filter.Offset <= 0 {
+		return "", nil
+	}
+
+	// SQLite requires a LIMIT for an OFFSET, -1 means no limit
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = -1
+	}
+	return " LIMIT ? OFFSET ?", []any{limit, filter.Offset}
+}
+
+func (c *SQLite) queryMessages(ctx context.Context, query string, args ...any) ([]e.SavedMessage, error) {
+	rows, err := c.db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("querying messages: %w", err)
 	}
@@ -125,22 +631,7 @@ func (c *SQLite) ListMessages(ctx context.Context, fromDate time.Time) ([]e.Save
 
 	var messages []e.SavedMessage
 	for rows.Next() {
-		var msg e.SavedMessage
-		err = rows.Scan(
-			&msg.ID,
-			&msg.Sender.ID,
-			&msg.Sender.ChatID,
-			&msg.Sender.ID,
-			&msg.Sender.Name,
-			&msg.Text,
-			&msg.CreatedAt,
-			&msg.Action,
-			&msg.ActionNote,
-			&msg.Error,
-			&msg.MediaType,
-			&msg.MediaFileID,
-			&msg.MediaSize,
-		)
+		msg, err := scanMessage(rows)
 		if err != nil {
 			return nil, fmt.Errorf("scanning message: %w", err)
 		}
@@ -152,22 +643,184 @@ func (c *SQLite) ListMessages(ctx context.Context, fromDate time.Time) ([]e.Save
 	}
 
 	return messages, nil
+}
+
+// scanMessage scans a row of the messages columns selected by ListMessages
+// and GetMessage
+func scanMessage(row interface{ Scan(dest ...any) error }) (e.SavedMessage, error) {
+	var (
+		msg                            e.SavedMessage
+		trace                          sql.NullString
+		promptTokens, completionTokens sql.NullInt64
+		cost                           sql.NullFloat64
+	)
+	err := row.Scan(
+		&msg.ID,
+		&msg.Sender.ChatID,
+		&msg.Sender.ID,
+		&msg.Sender.Name,
+		&msg.Text,
+		&msg.CreatedAt,
+		&msg.Action,
+		&msg.ActionNote,
+		&msg.Error,
+		&msg.MediaType,
+		&msg.MediaFileID,
+		&msg.MediaSize,
+		&msg.Category,
+		&trace,
+		&promptTokens,
+		&completionTokens,
+		&cost,
+	)
+	if err != nil {
+		return msg, err
+	}
+
+	if promptTokens.Valid {
+		msg.Usage = &e.AIUsage{
+			PromptTokens:     int(promptTokens.Int64),
+			CompletionTokens: int(completionTokens.Int64),
+			Cost:             cost.Float64,
+		}
+	}
 
+	if trace.Valid {
+		msg.Trace = &e.Trace{}
+		if err = json.Unmarshal([]byte(trace.String), msg.Trace); err != nil {
+			return msg, fmt.Errorf("unmarshaling trace: %w", err)
+		}
+	}
+
+	return msg, nil
+}
+
+// GetChatStats returns moderation counters and the AI spend of the chat for
+// messages created in [from, to). Messages deleted by retention are counted with day precision.
+func (c *SQLite) GetChatStats(ctx context.Context, chatID string, from, to time.Time) (e.ChatStats, error) {
+	var stats e.ChatStats
+	err := c.db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(SUM(checked), 0), COALESCE(SUM(erased), 0), COALESCE(SUM(banned), 0), COALESCE(SUM(errors), 0),
+		        COALESCE(SUM(ai_requests), 0), COALESCE(SUM(prompt_tokens), 0),
+		        COALESCE(SUM(completion_tokens), 0), COALESCE(SUM(ai_cost), 0)
+		 FROM (
+		     SELECT COUNT(*) AS checked,
+		            COUNT(CASE WHEN action = ? THEN 1 END) AS erased,
+		            COUNT(CASE WHEN action = ? THEN 1 END) AS banned,
+		            COUNT(error) AS errors,
+		            COUNT(prompt_tokens) AS ai_requests,
+		            SUM(prompt_tokens) AS prompt_tokens,
+		            SUM(completion_tokens) AS completion_tokens,
+		            SUM(ai_cost) AS ai_cost
+		     FROM messages
+		     WHERE chat_id = ? AND created_at >= ? AND created_at < ?
+		     UNION ALL
+		     SELECT SUM(count),
+		            SUM(CASE WHEN action = ? THEN count END),
+		            SUM(CASE WHEN action = ? THEN count END),
+		            SUM(CASE WHEN has_error THEN count END),
+		            SUM(ai_requests), SUM(prompt_tokens), SUM(completion_tokens), SUM(ai_cost)
+		     FROM message_stats
+		     WHERE chat_id = ? AND day >= date(?) AND day < date(?)
+		 )`,
+		e.ActionKindErase, e.ActionKindBan,
+		chatID, formatTime(from), formatTime(to),
+		e.ActionKindErase, e.ActionKindBan,
+		chatID, formatTime(from), formatTime(to),
+	).Scan(
+		&stats.Checked, &stats.Erased, &stats.Banned, &stats.Errors,
+		&stats.Spend.Requests, &stats.Spend.PromptTokens, &stats.Spend.CompletionTokens, &stats.Spend.Cost,
+	)
+	if err != nil {
+		return stats, fmt.Errorf("querying chat stats: %w", err)
+	}
+
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT category, SUM(count)
+		 FROM (
+		     SELECT category, COUNT(*) AS count
+		     FROM messages
+		     WHERE chat_id = ? AND created_at >= ? AND created_at < ? AND category IS NOT NULL
+		     GROUP BY category
+		     UNION ALL
+		     SELECT category, SUM(count)
+		     FROM message_stats
+		     WHERE chat_id = ? AND day >= date(?) AND day < date(?) AND category != ''
+		     GROUP BY category
+		 )
+		 GROUP BY category`,
+		chatID, formatTime(from), formatTime(to),
+		chatID, formatTime(from), formatTime(to),
+	)
+	if err != nil {
+		return stats, fmt.Errorf("querying chat categories: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	stats.Categories = make(map[e.SpamCategory]int)
+	for rows.Next() {
+		var category e.SpamCategory
+		var count int
+		if err = rows.Scan(&category, &count); err != nil {
+			return stats, fmt.Errorf("scanning chat category: %w", err)
+		}
+		stats.Categories[category] = count
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, fmt.Errorf("iterating over chat categories: %w", err)
+	}
+
+	return stats, nil
 }
 
 func (c *SQLite) SaveAction(ctx context.Context, messageID int64, action e.Action) error {
-	_, err := c.db.ExecContext(
+	trace, err := json.Marshal(action.Trace)
+	if err != nil {
+		return fmt.Errorf("marshaling trace: %w", err)
+	}
+
+	_, err = c.exec(
 		ctx,
-		`UPDATE messages SET action = ?, action_note = ? WHERE id = ?`,
+		`UPDATE messages SET action = ?, action_note = ?, category = ?, trace = ? WHERE id = ?`,
 		string(action.Kind),
 		action.Note,
+		nullString(string(action.Category)),
+		string(trace),
 		messageID,
 	)
 	return err
 }
 
+// GetMessage returns the latest stored version of a Telegram message, found
+// is false if it was never stored
+func (c *SQLite) GetMessage(ctx context.Context, chatID, messageID string) (e.SavedMessage, bool, error) {
+	row := c.db.QueryRowContext(
+		ctx,
+		`SELECT `+messageColumns+`
+		 FROM messages AS m
+		 WHERE m.chat_id = ? AND m.message_id = ?
+		 ORDER BY m.id DESC
+		 LIMIT 1`,
+		chatID, messageID,
+	)
+
+	msg, err := scanMessage(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return msg, false, nil
+		}
+
+		return msg, false, err
+	}
+
+	return msg, true, nil
+}
+
 func (c *SQLite) SaveError(ctx context.Context, messageID int64, error string) error {
-	_, err := c.db.ExecContext(
+	_, err := c.exec(
 		ctx,
 		`UPDATE messages SET error = ? WHERE id = ?`,
 		error,
@@ -176,10 +829,24 @@ func (c *SQLite) SaveError(ctx context.Context, messageID int64, error string) e
 	return err
 }
 
-//go:embed init.sql
-var initQuery string
+// formatTime formats t the way CURRENT_TIMESTAMP stores timestamps, so
+// they compare correctly as strings.
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.DateTime)
+}
 
-func (c *SQLite) init(ctx context.Context) error {
-	_, err := c.db.ExecContext(ctx, initQuery)
-	return err
+// parseTime parses a timestamp aggregated by SQLite, which loses the column
+// type and returns it as stored
+func parseTime(s string) (time.Time, error) {
+	for _, layout := range []string{time.DateTime, time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("parsing time %q", s)
+}
+
+// nullString maps an empty string to NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
 }
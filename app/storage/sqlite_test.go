@@ -0,0 +1,1688 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func newTestSQLite(t *testing.T) *SQLite {
+	t.Helper()
+
+	db, err := NewSQLite(context.Background(), filepath.Join(t.TempDir(), "test.sqlite"), DefaultSQLiteOptions)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	return db
+}
+
+func TestSQLite_InitIsIdempotent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+
+	for i := 0; i < 2; i++ {
+		db, err := NewSQLite(context.Background(), path, DefaultSQLiteOptions)
+		if err != nil {
+			t.Fatalf("NewSQLite (run %d): %v", i+1, err)
+		}
+		_ = db.Close()
+	}
+}
+
+func TestSQLite_GetChatStats(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100", ChatTitle: "chat"}
+	actions := []e.Action{
+		{Kind: e.ActionKindNoop},
+		{Kind: e.ActionKindErase, Category: e.SpamCategoryAds},
+		{Kind: e.ActionKindErase, Category: e.SpamCategoryAds},
+		{Kind: e.ActionKindBan, Category: e.SpamCategoryPhishing},
+	}
+	for i, action := range actions {
+		id, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: string(rune('a' + i)), Text: "text"})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err = db.SaveAction(ctx, id, action); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+	}
+
+	now := time.Now()
+	stats, err := db.GetChatStats(ctx, "-100", now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetChatStats: %v", err)
+	}
+
+	if stats.Checked != 4 || stats.Erased != 2 || stats.Banned != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.Categories[e.SpamCategoryAds] != 2 || stats.Categories[e.SpamCategoryPhishing] != 1 {
+		t.Errorf("unexpected categories: %v", stats.Categories)
+	}
+}
+
+func TestSQLite_AddsMissingColumns(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "old.sqlite")
+
+	// A messages table as created by an older version, without newer columns
+	old, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.ExecContext(ctx, `CREATE TABLE messages (
+		id INTEGER PRIMARY KEY AUTOINCREMENT, message_id TEXT NOT NULL, chat_id TEXT NOT NULL,
+		sender_user_id TEXT NOT NULL, sender_user_name TEXT NOT NULL, text TEXT NOT NULL,
+		created_at TIMESTAMP NOT NULL, action TEXT NULL, action_note TEXT NULL, error TEXT NULL
+	)`)
+	_ = old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := NewSQLite(ctx, path, DefaultSQLiteOptions)
+	if err != nil {
+		t.Fatalf("NewSQLite: %v", err)
+	}
+	defer func() { _ = db.Close() }()
+
+	mediaType, fileID, size := "image/jpeg", "file-1", int64(1024)
+	id, err := db.SaveMessage(ctx, e.Message{
+		Sender: e.User{ID: "1", ChatID: "-100"}, ID: "1",
+		MediaType: &mediaType, MediaFileID: &fileID, MediaSize: &size,
+	})
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if err = db.SaveAction(ctx, id, e.Action{Kind: e.ActionKindErase, Category: e.SpamCategoryAds}); err != nil {
+		t.Fatalf("SaveAction on migrated table: %v", err)
+	}
+
+	msg, _, err := db.GetMessage(ctx, "-100", "1")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if msg.MediaType == nil || *msg.MediaType != mediaType || msg.MediaFileID == nil || *msg.MediaFileID != fileID ||
+		msg.MediaSize == nil || *msg.MediaSize != size {
+		t.Errorf("media metadata not stored: %+v", msg)
+	}
+}
+
+func TestSQLite_GetMessageReturnsTrace(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100", ChatTitle: "chat"}
+	id, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: "42", Text: "buy now"})
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	confidence := 0.9
+	trace := e.Trace{Stage: e.DecisionStageAI, Model: "gpt", PromptVersion: "abcd", Confidence: &confidence, ScoreBefore: 1, ScoreAfter: 0}
+	if err = db.SaveAction(ctx, id, e.Action{Kind: e.ActionKindErase, Note: "ads", Trace: trace}); err != nil {
+		t.Fatalf("SaveAction: %v", err)
+	}
+
+	msg, found, err := db.GetMessage(ctx, "-100", "42")
+	if err != nil || !found {
+		t.Fatalf("GetMessage: found %v, err %v", found, err)
+	}
+	if msg.Trace == nil || msg.Trace.Model != "gpt" || *msg.Trace.Confidence != 0.9 || msg.Trace.ScoreAfter != 0 {
+		t.Errorf("trace = %+v, want %+v", msg.Trace, trace)
+	}
+
+	if _, found, err = db.GetMessage(ctx, "-100", "43"); err != nil || found {
+		t.Errorf("GetMessage of unknown message: found %v, err %v", found, err)
+	}
+}
+
+func TestSQLite_MigrateDownAndUp(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+
+	if err = db.MigrateDown(ctx, len(migrations)); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+
+	statuses, err := db.MigrationStatuses(ctx)
+	if err != nil {
+		t.Fatalf("MigrationStatuses: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.AppliedAt.IsZero() {
+			t.Errorf("migration %d still applied after reverting all", s.Version)
+		}
+	}
+
+	if _, err = db.SaveMessage(ctx, e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: "1"}); err == nil {
+		t.Error("SaveMessage succeeded on a reverted schema")
+	}
+
+	if err = db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+	if _, err = db.SaveMessage(ctx, e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: "1"}); err != nil {
+		t.Errorf("SaveMessage after migrating up: %v", err)
+	}
+}
+
+func TestSQLite_ListMessages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	alice := e.User{ID: "1", Name: "alice", ChatID: "-100", ChatTitle: "chat"}
+	bob := e.User{ID: "2", Name: "bob", ChatID: "-200", ChatTitle: "other"}
+	media := "image/jpeg"
+	fileID := "file1"
+
+	for _, m := range []struct {
+		msg    e.Message
+		action e.ActionKind
+	}{
+		{msg: e.Message{Sender: alice, ID: "10", Text: "hi"}, action: e.ActionKindNoop},
+		{msg: e.Message{Sender: alice, ID: "11", Text: "spam", MediaType: &media, MediaFileID: &fileID}, action: e.ActionKindErase},
+		{msg: e.Message{Sender: bob, ID: "20", Text: "hello"}, action: e.ActionKindNoop},
+	} {
+		id, err := db.SaveMessage(ctx, m.msg)
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err = db.SaveAction(ctx, id, e.Action{Kind: m.action}); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		filter MessageFilter
+		want   []string
+	}{
+		{name: "all newest first", filter: MessageFilter{}, want: []string{"20", "11", "10"}},
+		{name: "chat", filter: MessageFilter{ChatID: "-100"}, want: []string{"11", "10"}},
+		{name: "sender", filter: MessageFilter{SenderID: "2"}, want: []string{"20"}},
+		{name: "action", filter: MessageFilter{Action: e.ActionKindErase}, want: []string{"11"}},
+		{name: "media", filter: MessageFilter{HasMedia: true}, want: []string{"11"}},
+		{name: "future range", filter: MessageFilter{From: time.Now().Add(time.Hour)}},
+		{name: "page", filter: MessageFilter{Limit: 1, Offset: 1}, want: []string{"11"}},
+		{name: "offset only", filter: MessageFilter{Offset: 2}, want: []string{"10"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			messages, err := db.ListMessages(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("ListMessages: %v", err)
+			}
+
+			var got []string
+			for _, msg := range messages {
+				got = append(got, msg.ID)
+			}
+			if strings.Join(got, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("got messages %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	messages, err := db.ListMessages(ctx, MessageFilter{SenderID: "2"})
+	if err != nil || len(messages) != 1 {
+		t.Fatalf("ListMessages: %v, %d messages", err, len(messages))
+	}
+	if got := messages[0].Sender; got.ID != "2" || got.ChatID != "-200" || got.Name != "bob" {
+		t.Errorf("sender mapped as %+v", got)
+	}
+}
+
+func TestSQLite_PruneKeepsStats(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100", ChatTitle: "chat"}
+	actions := []e.Action{
+		{Kind: e.ActionKindErase, Category: e.SpamCategoryAds},
+		{Kind: e.ActionKindBan, Category: e.SpamCategoryPhishing},
+		{Kind: e.ActionKindNoop},
+		{Kind: e.ActionKindNoop},
+	}
+	for i, action := range actions {
+		id, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: string(rune('a' + i)), Text: "text"})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err = db.SaveAction(ctx, id, action); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+	}
+
+	from := time.Now().UTC().Truncate(24 * time.Hour)
+	to := from.Add(24 * time.Hour)
+	before, err := db.GetChatStats(ctx, "-100", from, to)
+	if err != nil {
+		t.Fatalf("GetChatStats: %v", err)
+	}
+
+	result, err := db.Prune(ctx, e.RetentionPolicy{MaxRowsPerChat: 2})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if result.RowsDeleted != 2 {
+		t.Errorf("deleted %d rows, want 2", result.RowsDeleted)
+	}
+
+	messages, err := db.ListMessages(ctx, MessageFilter{})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(messages) != 2 || messages[0].ID != "d" || messages[1].ID != "c" {
+		t.Errorf("kept %d messages, want the 2 newest", len(messages))
+	}
+
+	after, err := db.GetChatStats(ctx, "-100", from, to)
+	if err != nil {
+		t.Fatalf("GetChatStats: %v", err)
+	}
+	if after.Checked != before.Checked || after.Erased != before.Erased || after.Banned != before.Banned ||
+		after.Categories[e.SpamCategoryAds] != 1 || after.Categories[e.SpamCategoryPhishing] != 1 {
+		t.Errorf("stats after prune = %+v, want %+v", after, before)
+	}
+}
+
+func TestSQLite_PruneErasesOldBodies(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100"}
+	if _, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: "old", Text: "secret"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if _, err := db.db.ExecContext(ctx, "UPDATE messages SET created_at = ?", formatTime(time.Now().AddDate(0, 0, -40))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: "new", Text: "fresh"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	result, err := db.Prune(ctx, e.RetentionPolicy{Days: 30})
+	if err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+	if result.BodiesErased != 1 {
+		t.Errorf("erased %d bodies, want 1", result.BodiesErased)
+	}
+
+	count, err := db.CountUserMessages(ctx, user)
+	if err != nil || count != 2 {
+		t.Errorf("CountUserMessages = %d, %v, want 2 rows kept", count, err)
+	}
+
+	old, _, err := db.GetMessage(ctx, "-100", "old")
+	if err != nil || old.Text != "" {
+		t.Errorf("old message text = %q, %v, want erased", old.Text, err)
+	}
+}
+
+func TestSQLite_PruneKeepsUserMessageCounts(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	member := e.User{ID: "1", Name: "member", ChatID: "-100"}
+	for _, id := range []string{"a", "b", "c"} {
+		if _, err := db.SaveMessage(ctx, e.Message{Sender: member, ID: id, Text: "hello"}); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+	// A re-delivered message is the same message
+	if _, err := db.SaveMessage(ctx, e.Message{Sender: member, ID: "c", Text: "hello, edited"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	newcomer := e.User{ID: "2", Name: "newcomer", ChatID: "-100"}
+	if _, err := db.SaveMessage(ctx, e.Message{Sender: newcomer, ID: "d", Text: "hi"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	if _, err := db.Prune(ctx, e.RetentionPolicy{MaxRowsPerChat: 1}); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	if count, err := db.CountUserMessages(ctx, member); err != nil || count != 3 {
+		t.Errorf("CountUserMessages of the member = %d, %v, want 3 with the pruned ones", count, err)
+	}
+	if count, err := db.CountUserMessages(ctx, newcomer); err != nil || count != 1 {
+		t.Errorf("CountUserMessages of the newcomer = %d, %v, want 1", count, err)
+	}
+	if count, err := db.CountUserMessages(ctx, e.User{ID: "3", ChatID: "-100"}); err != nil || count != 0 {
+		t.Errorf("CountUserMessages of an unknown user = %d, %v, want 0", count, err)
+	}
+}
+
+func TestSQLite_VacuumReclaimsSpace(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100"}
+	text := strings.Repeat("spam ", 2000)
+	for i := 0; i < 50; i++ {
+		if _, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: strconv.Itoa(i), Text: text}); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+	if _, err := db.Prune(ctx, e.RetentionPolicy{MaxRowsPerChat: 1}); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	result, err := db.Vacuum(ctx)
+	if err != nil {
+		t.Fatalf("Vacuum: %v", err)
+	}
+	if result.Reclaimed() <= 0 || result.SizeAfter <= 0 {
+		t.Errorf("vacuum result = %+v, want space reclaimed", result)
+	}
+}
+
+func TestSQLite_ConcurrentWrites(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	var mode string
+	if err := db.db.QueryRowContext(ctx, "PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("journal_mode = %q, %v, want wal", mode, err)
+	}
+
+	const workers, writes = 8, 25
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			user := e.User{ID: strconv.Itoa(w), Name: "user", ChatID: "-100"}
+			for i := 0; i < writes; i++ {
+				id, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: strconv.Itoa(i), Text: "text"})
+				if err == nil {
+					err = db.SaveAction(ctx, id, e.Action{Kind: e.ActionKindNoop})
+				}
+				if err == nil {
+					err = db.SetScore(ctx, user, i)
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}(w)
+	}
+
+	for w := 0; w < workers; w++ {
+		if err := <-errs; err != nil {
+			t.Errorf("concurrent write: %v", err)
+		}
+	}
+}
+
+func TestSQLite_ChatSettingsRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	if _, found, err := db.GetChatSettings(ctx, "-100"); err != nil || found {
+		t.Fatalf("GetChatSettings of unknown chat: found %v, err %v", found, err)
+	}
+
+	ban := -2
+	want := e.ChatSettings{
+		BanScore: &ban,
+		DryRun:   true,
+		Language: "ru",
+		Announce: e.AnnounceNotice,
+		Features: map[e.Feature]bool{e.FeatureMedia: false},
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.SetChatSettings(ctx, "-100", want); err != nil {
+			t.Fatalf("SetChatSettings (run %d): %v", i+1, err)
+		}
+	}
+
+	got, found, err := db.GetChatSettings(ctx, "-100")
+	if err != nil || !found {
+		t.Fatalf("GetChatSettings: found %v, err %v", found, err)
+	}
+	if got.BanScore == nil || *got.BanScore != ban || !got.DryRun || got.Language != "ru" ||
+		got.Announce != e.AnnounceNotice || got.FeatureEnabled(e.FeatureMedia) || !got.FeatureEnabled(e.FeatureAI) {
+		t.Errorf("settings = %+v, want %+v", got, want)
+	}
+
+	if err = db.DeleteChatSettings(ctx, "-100"); err != nil {
+		t.Fatalf("DeleteChatSettings: %v", err)
+	}
+	if _, found, err = db.GetChatSettings(ctx, "-100"); err != nil || found {
+		t.Errorf("GetChatSettings after delete: found %v, err %v", found, err)
+	}
+}
+
+func TestSQLite_AuditLogIsAppendOnly(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	entries := []e.AuditEntry{
+		{Kind: e.AuditKindScore, Actor: e.AuditActorBot, ChatID: "-100", UserID: "1", Before: "0", After: "1", Reason: "message passed the check"},
+		{Kind: e.AuditKindSettings, Actor: "42", ChatID: "-100", After: `{"dry_run":true}`, Reason: "trying the bot"},
+		{Kind: e.AuditKindBan, Actor: e.AuditActorBot, ChatID: "-200", UserID: "1", Reason: "crypto scam"},
+	}
+	for _, entry := range entries {
+		if err := db.AppendAudit(ctx, entry); err != nil {
+			t.Fatalf("AppendAudit: %v", err)
+		}
+	}
+
+	got, err := db.ListAudit(ctx, AuditFilter{ChatID: "-100"})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(got) != 2 || got[0].Kind != e.AuditKindSettings || got[1].Before != "0" || got[0].CreatedAt.IsZero() {
+		t.Errorf("entries of chat -100 = %+v", got)
+	}
+
+	got, err = db.ListAudit(ctx, AuditFilter{UserID: "1", Kind: e.AuditKindBan})
+	if err != nil {
+		t.Fatalf("ListAudit: %v", err)
+	}
+	if len(got) != 1 || got[0].ChatID != "-200" {
+		t.Errorf("bans of user 1 = %+v", got)
+	}
+
+	if _, err = db.db.ExecContext(ctx, "UPDATE audit_log SET actor = 'someone'"); err == nil {
+		t.Error("updating the audit log must fail")
+	}
+	if _, err = db.db.ExecContext(ctx, "DELETE FROM audit_log"); err == nil {
+		t.Error("deleting from the audit log must fail")
+	}
+}
+
+// TestSQLite_SearchMessages runs against the full-text index when built with
+// the sqlite_fts5 tag and against the substring fallback otherwise
+func TestSQLite_SearchMessages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	for i, m := range []struct{ chatID, text string }{
+		{"-100", "Free crypto signals, join now"},
+		{"-100", "Who is going to the meetup?"},
+		{"-200", "CRYPTO signals for everyone"},
+		{"-100", "100% profit_guaranteed"},
+	} {
+		msg := e.Message{Sender: e.User{ID: "1", ChatID: m.chatID}, ID: strconv.Itoa(i + 1), Text: m.text}
+		if _, err := db.SaveMessage(ctx, msg); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		query  string
+		filter MessageFilter
+		want   []string
+	}{
+		{name: "all words, any case", query: "crypto signals", want: []string{"3", "1"}},
+		{name: "filtered by chat", query: "crypto", filter: MessageFilter{ChatID: "-100"}, want: []string{"1"}},
+		{name: "query syntax is literal", query: `meetup "OR`, want: nil},
+		{name: "no match", query: "casino", want: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := db.SearchMessages(ctx, tc.query, tc.filter)
+			if err != nil {
+				t.Fatalf("SearchMessages: %v", err)
+			}
+			var ids []string
+			for _, msg := range got {
+				ids = append(ids, msg.ID)
+			}
+			if strings.Join(ids, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("got messages %v, want %v", ids, tc.want)
+			}
+		})
+	}
+
+	if _, err := db.SearchMessages(ctx, "  ", MessageFilter{}); err == nil {
+		t.Error("empty query must fail")
+	}
+}
+
+func TestSQLite_FindSimilar(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	ids := make(map[string]int64)
+	for _, m := range []struct {
+		id, chatID string
+		action     e.ActionKind
+		vector     []float32
+	}{
+		{"1", "-100", e.ActionKindErase, []float32{1, 0, 0}},
+		{"2", "-100", e.ActionKindNoop, []float32{0.9, 0.1, 0}},
+		{"3", "-100", e.ActionKindErase, []float32{0, 1, 0}},
+		{"4", "-200", e.ActionKindErase, []float32{1, 0.05, 0}},
+	} {
+		id, err := db.SaveMessage(ctx, e.Message{Sender: e.User{ID: "1", ChatID: m.chatID}, ID: m.id, Text: "text"})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err = db.SaveAction(ctx, id, e.Action{Kind: m.action}); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+		if err = db.StoreEmbedding(ctx, id, "small", m.vector); err != nil {
+			t.Fatalf("StoreEmbedding: %v", err)
+		}
+		ids[m.id] = id
+	}
+
+	if err := db.StoreEmbedding(ctx, 1000, "small", []float32{1, 0, 0}); err == nil {
+		t.Error("StoreEmbedding of an unknown message must fail")
+	}
+
+	tests := []struct {
+		name   string
+		model  string
+		filter SimilarityFilter
+		want   []string
+	}{
+		{name: "most similar first", model: "small", filter: SimilarityFilter{Limit: 3}, want: []string{"1", "4", "2"}},
+		{name: "known spam in the chat", model: "small", filter: SimilarityFilter{ChatID: "-100", Action: e.ActionKindErase}, want: []string{"1", "3"}},
+		{name: "threshold", model: "small", filter: SimilarityFilter{MinSimilarity: 0.995}, want: []string{"1", "4"}},
+		{name: "other model", model: "large", want: nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := db.FindSimilar(ctx, tc.model, []float32{2, 0, 0}, tc.filter)
+			if err != nil {
+				t.Fatalf("FindSimilar: %v", err)
+			}
+			var msgIDs []string
+			for _, msg := range got {
+				msgIDs = append(msgIDs, msg.ID)
+			}
+			if strings.Join(msgIDs, ",") != strings.Join(tc.want, ",") {
+				t.Errorf("got messages %v, want %v", msgIDs, tc.want)
+			}
+			if len(got) > 0 && got[0].ID == "1" && got[0].Similarity < 0.999 {
+				t.Errorf("similarity of an identical direction = %f, want 1", got[0].Similarity)
+			}
+		})
+	}
+
+	// Embeddings go away with their messages
+	if _, err := db.db.ExecContext(ctx, "DELETE FROM messages WHERE id = ?", ids["1"]); err != nil {
+		t.Fatalf("deleting message: %v", err)
+	}
+	got, err := db.FindSimilar(ctx, "small", []float32{1, 0, 0}, SimilarityFilter{Limit: 1})
+	if err != nil {
+		t.Fatalf("FindSimilar: %v", err)
+	}
+	if len(got) != 1 || got[0].ID != "4" {
+		t.Errorf("after deleting message 1 got %+v, want message 4", got)
+	}
+}
+
+func TestSQLite_ListExamples(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	for i, m := range []struct {
+		action e.ActionKind
+		text   string
+		err    string
+	}{
+		{e.ActionKindNoop, "hello", ""},
+		{e.ActionKindErase, "buy now", ""},
+		{e.ActionKindNoop, "how are you", ""},
+		{e.ActionKindBan, "crypto", ""},
+		{e.ActionKindNoop, "see you", ""},
+		{e.ActionKindNoop, "failed", "ai unavailable"},
+		{e.ActionKindNoop, "", ""}, // body erased by retention
+	} {
+		id, err := db.SaveMessage(ctx, e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: strconv.Itoa(i + 1), Text: m.text})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err = db.SaveAction(ctx, id, e.Action{Kind: m.action}); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+		if m.err != "" {
+			if err = db.SaveError(ctx, id, m.err); err != nil {
+				t.Fatalf("SaveError: %v", err)
+			}
+		}
+	}
+	// A message still being checked has no action
+	if _, err := db.SaveMessage(ctx, e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: "100", Text: "pending"}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter ExampleFilter
+		want   string
+	}{
+		{name: "all decided", want: "1:ham,2:spam,3:ham,4:spam,5:ham"},
+		{name: "per label", filter: ExampleFilter{PerLabel: 1}, want: "4:spam,5:ham"},
+		{name: "balanced", filter: ExampleFilter{Balance: true}, want: "2:spam,3:ham,4:spam,5:ham"},
+		{name: "other chat", filter: ExampleFilter{ChatID: "-200"}, want: ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			examples, err := db.ListExamples(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("ListExamples: %v", err)
+			}
+			var got []string
+			for _, ex := range examples {
+				got = append(got, ex.MessageID+":"+string(ex.Label))
+			}
+			if strings.Join(got, ",") != tc.want {
+				t.Errorf("got %s, want %s", strings.Join(got, ","), tc.want)
+			}
+		})
+	}
+}
+
+func TestSQLite_ImportGroundTruth(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	fileID := "file-1"
+	examples := []e.Example{
+		{Text: "Free crypto signals", Label: e.LabelSpam, Category: e.SpamCategoryCryptoScam},
+		{Text: "free  CRYPTO sig\u200Bnals", Label: e.LabelSpam}, // same normalized text
+		{Text: "See you tomorrow", Label: e.LabelHam},
+		{MediaFileID: &fileID, Label: e.LabelSpam},
+		{Text: "unlabeled"},
+		{Label: e.LabelHam}, // neither text nor media
+	}
+
+	result, err := db.ImportGroundTruth(ctx, "manual", examples)
+	if err != nil {
+		t.Fatalf("ImportGroundTruth: %v", err)
+	}
+	if want := (e.ImportResult{Imported: 3, Duplicates: 1, Skipped: 2}); result != want {
+		t.Errorf("result = %+v, want %+v", result, want)
+	}
+
+	// Importing again adds nothing
+	result, err = db.ImportGroundTruth(ctx, "other", examples[:3])
+	if err != nil {
+		t.Fatalf("ImportGroundTruth: %v", err)
+	}
+	if result.Imported != 0 || result.Duplicates != 3 {
+		t.Errorf("second import = %+v, want all duplicates", result)
+	}
+
+	spam, err := db.ListGroundTruth(ctx, GroundTruthFilter{Label: e.LabelSpam, TextOnly: true})
+	if err != nil {
+		t.Fatalf("ListGroundTruth: %v", err)
+	}
+	if len(spam) != 1 || spam[0].Text != "Free crypto signals" || spam[0].Source != "manual" || spam[0].Category != e.SpamCategoryCryptoScam {
+		t.Errorf("text spam = %+v", spam)
+	}
+}
+
+func TestSQLite_BackupAndRestore(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+	user := e.User{ID: "1", Name: "user", ChatID: "-100"}
+
+	if err := db.SetScore(ctx, user, 3); err != nil {
+		t.Fatalf("SetScore: %v", err)
+	}
+
+	// Back up while another goroutine keeps writing
+	done := make(chan struct{})
+	writerErr := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				writerErr <- nil
+				return
+			default:
+			}
+			if _, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: strconv.Itoa(i), Text: "text"}); err != nil {
+				writerErr <- err
+				return
+			}
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "backup.sqlite")
+	err := db.Backup(ctx, path)
+	close(done)
+	if err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+	if err = <-writerErr; err != nil {
+		t.Fatalf("writing during backup: %v", err)
+	}
+
+	if err = db.SetScore(ctx, user, -1); err != nil {
+		t.Fatalf("SetScore: %v", err)
+	}
+
+	if err = db.Restore(ctx, path); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	score, err := db.GetScore(ctx, user, 0)
+	if err != nil {
+		t.Fatalf("GetScore: %v", err)
+	}
+	if score != 3 {
+		t.Errorf("score after restore = %d, want 3 as backed up", score)
+	}
+
+	if err = db.Restore(ctx, filepath.Join(t.TempDir(), "missing.sqlite")); err == nil {
+		t.Error("restoring a missing backup must fail")
+	}
+}
+
+func TestSQLite_AggregateStats(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	conf := func(v float64) *float64 { return &v }
+	for i, m := range []struct {
+		chatID, userID string
+		action         e.ActionKind
+		trace          e.Trace
+	}{
+		{"-100", "1", e.ActionKindErase, e.Trace{Stage: e.DecisionStageAI, Confidence: conf(0.9)}},
+		{"-100", "1", e.ActionKindBan, e.Trace{Stage: e.DecisionStageAI, Confidence: conf(0.7)}},
+		{"-100", "2", e.ActionKindNoop, e.Trace{Stage: e.DecisionStageAI}},
+		{"-100", "3", e.ActionKindErase, e.Trace{Stage: e.DecisionStageRule, Rule: "link_only"}},
+		{"-200", "1", e.ActionKindNoop, e.Trace{Stage: e.DecisionStageAI, Confidence: conf(0.2)}},
+	} {
+		user := e.User{ID: m.userID, Name: "user" + m.userID, ChatID: m.chatID}
+		id, err := db.SaveMessage(ctx, e.Message{Sender: user, ID: strconv.Itoa(i), Text: "text"})
+		if err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		if err = db.SaveAction(ctx, id, e.Action{Kind: m.action, Trace: m.trace}); err != nil {
+			t.Fatalf("SaveAction: %v", err)
+		}
+	}
+
+	// Pruned messages are still counted by action, chat and day
+	if _, err := db.Prune(ctx, e.RetentionPolicy{MaxRowsPerChat: 3}); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	byAction, err := db.CountByAction(ctx, StatsFilter{})
+	if err != nil {
+		t.Fatalf("CountByAction: %v", err)
+	}
+	if byAction[e.ActionKindErase] != 2 || byAction[e.ActionKindBan] != 1 || byAction[e.ActionKindNoop] != 2 {
+		t.Errorf("by action = %v", byAction)
+	}
+
+	byChat, err := db.CountByChat(ctx, StatsFilter{})
+	if err != nil {
+		t.Fatalf("CountByChat: %v", err)
+	}
+	if byChat["-100"] != 4 || byChat["-200"] != 1 {
+		t.Errorf("by chat = %v", byChat)
+	}
+
+	days, err := db.CountByDay(ctx, StatsFilter{ChatID: "-100"})
+	if err != nil {
+		t.Fatalf("CountByDay: %v", err)
+	}
+	if len(days) != 1 || days[0].Checked != 4 || days[0].Removed != 3 {
+		t.Errorf("by day = %+v", days)
+	}
+
+	confidence, err := db.AverageConfidence(ctx, StatsFilter{ChatID: "-200"})
+	if err != nil {
+		t.Fatalf("AverageConfidence: %v", err)
+	}
+	if confidence.Count != 1 || confidence.Average != 0.2 {
+		t.Errorf("confidence = %+v", confidence)
+	}
+
+	offenders, err := db.TopOffenders(ctx, StatsFilter{ChatID: "-100"}, 10)
+	if err != nil {
+		t.Fatalf("TopOffenders: %v", err)
+	}
+	// The oldest message of chat -100, an erase by user 1, was pruned
+	if len(offenders) != 2 || offenders[0].User.ID != "1" || offenders[0].Offenses != 1 || offenders[0].User.Name != "user1" {
+		t.Errorf("offenders = %+v", offenders)
+	}
+}
+
+func TestSQLite_CountByChatDay(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	for i, m := range []struct {
+		chatID string
+		action e.ActionKind
+		usage  *e.AIUsage
+	}{
+		{"-100", e.ActionKindErase, &e.AIUsage{PromptTokens: 100, CompletionTokens: 10, Cost: 0.5}},
+		{"-100", e.ActionKindBan, &e.AIUsage{PromptTokens: 200, CompletionTokens: 20, Cost: 0.25}},
+		{"-100", e.ActionKindErase, nil},
+		{"-200", e.ActionKindNoop, nil},
+	} {
+		_, err := db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: e.User{ID: "1", ChatID: m.chatID}, ID: strconv.Itoa(i + 1), Text: "text"},
+			Action:  &e.Action{Kind: m.action, Usage: m.usage},
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+	}
+
+	// The restore of message 3 is overruled by a later confirmation
+	for _, o := range []e.Override{
+		{ChatID: "-100", MessageID: "1", Kind: e.OverrideKindRestore},
+		{ChatID: "-100", MessageID: "3", Kind: e.OverrideKindRestore},
+		{ChatID: "-100", MessageID: "3", Kind: e.OverrideKindConfirmSpam},
+	} {
+		if _, _, err := db.AddOverride(ctx, o); err != nil {
+			t.Fatalf("AddOverride: %v", err)
+		}
+	}
+
+	stats, err := db.CountByChatDay(ctx, StatsFilter{})
+	if err != nil {
+		t.Fatalf("CountByChatDay: %v", err)
+	}
+	if len(stats) != 2 || stats[0].ChatID != "-100" || stats[1].ChatID != "-200" {
+		t.Fatalf("CountByChatDay = %+v, want a day of -100 and -200", stats)
+	}
+
+	got := stats[0]
+	if got.Checked != 3 || got.Erased != 2 || got.Banned != 1 || got.Overridden != 1 {
+		t.Errorf("counters of -100 = %+v", got)
+	}
+	want := e.Spend{Requests: 2, PromptTokens: 300, CompletionTokens: 30, Cost: 0.75}
+	if got.Spend != want {
+		t.Errorf("spend of -100 = %+v, want %+v", got.Spend, want)
+	}
+	if stats[1].Checked != 1 || stats[1].Overridden != 0 || stats[1].Spend.Requests != 0 {
+		t.Errorf("counters of -200 = %+v", stats[1])
+	}
+}
+
+func TestSQLite_MessageBuffer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	db := newTestSQLite(t)
+
+	// Full batches are flushed without waiting for the tick
+	buffer := NewMessageBuffer(db, time.Hour, 4)
+	done := make(chan struct{})
+	go func() {
+		buffer.Run(ctx)
+		close(done)
+	}()
+
+	const count = 8
+	ids := make(chan int64, count)
+	errs := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			user := e.User{ID: "1", Name: "user", ChatID: "-100", ChatTitle: "Chat"}
+			id, err := buffer.SaveDecision(ctx, e.Decision{Message: e.Message{Sender: user, ID: strconv.Itoa(i), Text: "text"}})
+			if err != nil {
+				errs <- err
+				return
+			}
+			ids <- id
+		}(i)
+	}
+
+	seen := make(map[int64]bool)
+	deadline := time.After(5 * time.Second)
+	for len(seen) < count {
+		select {
+		case id := <-ids:
+			if seen[id] {
+				t.Fatalf("message ID %d returned twice", id)
+			}
+			seen[id] = true
+		case err := <-errs:
+			t.Fatalf("SaveDecision: %v", err)
+		case <-deadline:
+			t.Fatal("full batches not flushed")
+		}
+	}
+
+	cancel()
+	<-done
+
+	messages, err := db.ListMessages(context.Background(), MessageFilter{})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(messages) != count {
+		t.Errorf("stored %d messages, want %d", len(messages), count)
+	}
+
+	chats, err := db.ListChats(context.Background())
+	if err != nil || len(chats) != 1 || chats[0].Title != "Chat" {
+		t.Errorf("ListChats = %+v, %v, want the single chat", chats, err)
+	}
+
+	if _, err = buffer.SaveDecision(context.Background(), e.Decision{}); err != ErrBufferStopped {
+		t.Errorf("SaveDecision after stop = %v, want ErrBufferStopped", err)
+	}
+}
+
+func TestSQLite_SaveDecisionIsAtomic(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100", ChatTitle: "Chat"}
+	score := -1
+	_, err := db.SaveDecision(ctx, e.Decision{
+		Message: e.Message{Sender: user, ID: "10", Text: "spam"},
+		Action:  &e.Action{Kind: e.ActionKindErase, Category: e.SpamCategoryAds, Trace: e.Trace{Stage: e.DecisionStageAI}},
+		Score:   &score,
+	})
+	if err != nil {
+		t.Fatalf("SaveDecision: %v", err)
+	}
+
+	msg, found, err := db.GetMessage(ctx, "-100", "10")
+	if err != nil || !found {
+		t.Fatalf("GetMessage = %v, %v", found, err)
+	}
+	if msg.Action == nil || *msg.Action != e.ActionKindErase || msg.Category == nil || *msg.Category != e.SpamCategoryAds ||
+		msg.Trace == nil || msg.Trace.Stage != e.DecisionStageAI {
+		t.Errorf("stored message = %+v, want the erase action with its trace", msg)
+	}
+	if got, _ := db.GetScore(ctx, user, 0); got != -1 {
+		t.Errorf("score = %d, want -1", got)
+	}
+
+	// A failing score update leaves no message behind
+	_, err = db.db.ExecContext(ctx, `CREATE TRIGGER fail_score BEFORE UPDATE ON scores
+		BEGIN SELECT RAISE(ABORT, 'score rejected'); END`)
+	if err != nil {
+		t.Fatalf("creating trigger: %v", err)
+	}
+	score = -2
+	_, err = db.SaveDecision(ctx, e.Decision{
+		Message: e.Message{Sender: user, ID: "11", Text: "spam"},
+		Action:  &e.Action{Kind: e.ActionKindErase},
+		Score:   &score,
+	})
+	if err == nil {
+		t.Fatal("SaveDecision must fail with the score update")
+	}
+	if _, found, _ = db.GetMessage(ctx, "-100", "11"); found {
+		t.Error("message of the failed decision is stored")
+	}
+}
+
+func TestSQLite_GetScores(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	users := make([]e.User, scoresBatchSize+2)
+	for i := range users {
+		users[i] = e.User{ID: strconv.Itoa(i), ChatID: "-100"}
+		if i%2 == 0 {
+			if err := db.SetScore(ctx, users[i], i); err != nil {
+				t.Fatalf("SetScore: %v", err)
+			}
+		}
+	}
+	// Same user ID in another chat
+	if err := db.SetScore(ctx, e.User{ID: "1", ChatID: "-200"}, 100); err != nil {
+		t.Fatalf("SetScore: %v", err)
+	}
+
+	scores, err := db.GetScores(ctx, users, -1)
+	if err != nil {
+		t.Fatalf("GetScores: %v", err)
+	}
+	for i, score := range scores {
+		want := -1
+		if i%2 == 0 {
+			want = i
+		}
+		if score != want {
+			t.Fatalf("score of user %d = %d, want %d", i, score, want)
+		}
+	}
+}
+
+func TestSQLite_SpendSurvivesPruning(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	save := func(chatID, id string, usage *e.AIUsage) {
+		t.Helper()
+		user := e.User{ID: "1", Name: "user", ChatID: chatID}
+		_, err := db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: user, ID: id, Text: "text"},
+			Action:  &e.Action{Kind: e.ActionKindNoop, Usage: usage},
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+	}
+	save("-100", "1", &e.AIUsage{PromptTokens: 100, CompletionTokens: 10, Cost: 0.5})
+	save("-100", "2", &e.AIUsage{PromptTokens: 200, CompletionTokens: 20, Cost: 0.25})
+	save("-100", "3", nil) // decided by a rule
+	save("-200", "1", &e.AIUsage{PromptTokens: 50, CompletionTokens: 5, Cost: 0.125})
+
+	msg, _, err := db.GetMessage(ctx, "-100", "2")
+	if err != nil || msg.Usage == nil || msg.Usage.PromptTokens != 200 || msg.Usage.Cost != 0.25 {
+		t.Fatalf("GetMessage usage = %+v, %v", msg.Usage, err)
+	}
+
+	// The oldest messages of -100 are folded into counters
+	if _, err = db.Prune(ctx, e.RetentionPolicy{MaxRowsPerChat: 1}); err != nil {
+		t.Fatalf("Prune: %v", err)
+	}
+
+	byChat, err := db.SpendByChat(ctx, StatsFilter{})
+	if err != nil {
+		t.Fatalf("SpendByChat: %v", err)
+	}
+	want := e.Spend{Requests: 2, PromptTokens: 300, CompletionTokens: 30, Cost: 0.75}
+	if byChat["-100"] != want {
+		t.Errorf("spend of -100 = %+v, want %+v", byChat["-100"], want)
+	}
+
+	byDay, err := db.SpendByDay(ctx, StatsFilter{})
+	if err != nil {
+		t.Fatalf("SpendByDay: %v", err)
+	}
+	if len(byDay) != 1 || byDay[0].Requests != 3 || byDay[0].Cost != 0.875 {
+		t.Errorf("SpendByDay = %+v, want a single day of 3 requests", byDay)
+	}
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	stats, err := db.GetChatStats(ctx, "-100", day, day.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("GetChatStats: %v", err)
+	}
+	if stats.Spend != want {
+		t.Errorf("GetChatStats spend = %+v, want %+v", stats.Spend, want)
+	}
+}
+
+func TestSQLite_PromptVersions(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	user := e.User{ID: "1", Name: "user", ChatID: "-100"}
+	for i, d := range []struct {
+		version string
+		kind    e.ActionKind
+	}{
+		{"old", e.ActionKindErase},
+		{"old", e.ActionKindNoop},
+		{"new", e.ActionKindNoop},
+		{"", e.ActionKindNoop}, // decided by a rule
+	} {
+		_, err := db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: user, ID: strconv.Itoa(i), Text: "text"},
+			Action: &e.Action{Kind: d.kind, Trace: e.Trace{
+				Stage: e.DecisionStageAI, Model: "gpt-5-mini", PromptVersion: d.version,
+			}},
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+	}
+
+	older, err := db.ListMessages(ctx, MessageFilter{ExceptPromptVersion: "new"})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	if len(older) != 2 {
+		t.Errorf("decisions of older prompts = %d, want 2", len(older))
+	}
+
+	versions, err := db.CountByPromptVersion(ctx, StatsFilter{})
+	if err != nil {
+		t.Fatalf("CountByPromptVersion: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("CountByPromptVersion = %+v, want 2 versions", versions)
+	}
+	for _, v := range versions {
+		if v.PromptVersion == "old" && (v.Checked != 2 || v.Removed != 1 || v.Model != "gpt-5-mini" || v.First.IsZero()) {
+			t.Errorf("stats of the old prompt = %+v", v)
+		}
+	}
+}
+
+func TestSQLite_DeleteUserData(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	fileID := "file"
+	for i, user := range []e.User{
+		{ID: "1", Name: "alice", ChatID: "-100"},
+		{ID: "1", Name: "alice", ChatID: "-200"},
+		{ID: "2", Name: "bob", ChatID: "-100"},
+	} {
+		score := 3
+		id, err := db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: user, ID: strconv.Itoa(10 + i), Text: "my phone is 555", MediaFileID: &fileID},
+			Action:  &e.Action{Kind: e.ActionKindErase, Note: "quotes 555"},
+			Score:   &score,
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+		if err = db.StoreEmbedding(ctx, id, "model", []float32{1, 0}); err != nil {
+			t.Fatalf("StoreEmbedding: %v", err)
+		}
+	}
+
+	result, err := db.DeleteUserData(ctx, "-100", "1")
+	if err != nil {
+		t.Fatalf("DeleteUserData: %v", err)
+	}
+	if result.Messages != 1 || result.Scores != 1 {
+		t.Errorf("DeleteUserData = %+v, want a message and a score", result)
+	}
+
+	messages, err := db.ListMessages(ctx, MessageFilter{ChatID: "-100"})
+	if err != nil {
+		t.Fatalf("ListMessages: %v", err)
+	}
+	for _, msg := range messages {
+		erased := msg.Sender.ID == ""
+		if erased && (msg.Text != "" || msg.MediaFileID != nil || msg.Sender.Name != "" || msg.ActionNote != nil) {
+			t.Errorf("message not anonymized: %+v", msg)
+		}
+		if erased && (msg.Action == nil || *msg.Action != e.ActionKindErase) {
+			t.Error("anonymized message lost its action")
+		}
+		if !erased && msg.Sender.ID != "2" {
+			t.Errorf("unexpected message of %q", msg.Sender.ID)
+		}
+	}
+
+	if score, _ := db.GetScore(ctx, e.User{ID: "1", ChatID: "-100"}, 0); score != 0 {
+		t.Errorf("score in -100 = %d, want it deleted", score)
+	}
+	if score, _ := db.GetScore(ctx, e.User{ID: "1", ChatID: "-200"}, 0); score != 3 {
+		t.Errorf("score in -200 = %d, want it kept", score)
+	}
+	if count, _ := db.CountUserMessages(ctx, e.User{ID: "1", ChatID: "-100"}); count != 0 {
+		t.Errorf("message count in -100 = %d, want it deleted", count)
+	}
+	if count, _ := db.CountUserMessages(ctx, e.User{ID: "1", ChatID: "-200"}); count != 1 {
+		t.Errorf("message count in -200 = %d, want it kept", count)
+	}
+
+	similar, err := db.FindSimilar(ctx, "model", []float32{1, 0}, SimilarityFilter{ChatID: "-100"})
+	if err != nil || len(similar) != 1 {
+		t.Errorf("FindSimilar = %d messages, %v, want only bob's", len(similar), err)
+	}
+
+	// All chats
+	if result, err = db.DeleteUserData(ctx, "", "1"); err != nil || result.Messages != 1 || result.Scores != 1 {
+		t.Errorf("DeleteUserData in all chats = %+v, %v, want the rest of the data", result, err)
+	}
+}
+
+func TestSQLite_GetUserHistory(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	alice := e.User{ID: "1", Name: "alice", ChatID: "-100"}
+	for i, user := range []e.User{alice, alice, {ID: "1", Name: "alice", ChatID: "-200"}, {ID: "2", Name: "bob", ChatID: "-100"}} {
+		score := i + 1
+		_, err := db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: user, ID: strconv.Itoa(10 + i), Text: "hello"},
+			Action:  &e.Action{Kind: e.ActionKindNoop},
+			Score:   &score,
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+		err = db.AppendAudit(ctx, e.AuditEntry{
+			Kind: e.AuditKindScore, Actor: e.AuditActorBot, ChatID: user.ChatID, UserID: user.ID,
+			Before: strconv.Itoa(i), After: strconv.Itoa(score), Reason: "message passed the check",
+		})
+		if err != nil {
+			t.Fatalf("AppendAudit: %v", err)
+		}
+	}
+
+	history, err := db.GetUserHistory(ctx, alice, 10)
+	if err != nil {
+		t.Fatalf("GetUserHistory: %v", err)
+	}
+	if history.Score == nil || *history.Score != 2 {
+		t.Errorf("Score = %v, want 2", history.Score)
+	}
+	if len(history.Messages) != 2 || history.Messages[0].ID != "11" {
+		t.Errorf("Messages = %+v, want 11 and 10 in the chat", history.Messages)
+	}
+	if len(history.ScoreChanges) != 2 || history.ScoreChanges[0].After != 1 || history.ScoreChanges[1].After != 2 {
+		t.Errorf("ScoreChanges = %+v, want 0 → 1 → 2", history.ScoreChanges)
+	}
+
+	history, err = db.GetUserHistory(ctx, e.User{ID: "3", ChatID: "-100"}, 10)
+	if err != nil || history.Score != nil || len(history.Messages) != 0 {
+		t.Errorf("GetUserHistory of a new user = %+v, %v, want it empty", history, err)
+	}
+}
+
+func TestSQLite_Bans(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	permanent, err := db.AddBan(ctx, e.Ban{ChatID: "-100", UserID: "1", Reason: "crypto scam", IssuedBy: e.AuditActorBot})
+	if err != nil {
+		t.Fatalf("AddBan: %v", err)
+	}
+	expired, _ := db.AddBan(ctx, e.Ban{ChatID: "-100", UserID: "2", Reason: "flood", IssuedBy: "42", ExpiresAt: &past})
+	temporary, _ := db.AddBan(ctx, e.Ban{ChatID: "-100", UserID: "3", Reason: "flood", IssuedBy: "42", ExpiresAt: &future})
+
+	ban, found, err := db.GetActiveBan(ctx, "-100", "1")
+	if err != nil || !found || ban.ID != permanent || ban.Reason != "crypto scam" || ban.ExpiresAt != nil {
+		t.Errorf("GetActiveBan = %+v, %v, %v, want the permanent ban", ban, found, err)
+	}
+	if _, found, _ = db.GetActiveBan(ctx, "-100", "2"); found {
+		t.Error("expired ban is active")
+	}
+
+	active, err := db.ListBans(ctx, BanFilter{ChatID: "-100", Active: true})
+	if err != nil || len(active) != 2 {
+		t.Errorf("ListBans active = %d bans, %v, want 2", len(active), err)
+	}
+
+	due, err := db.ListBans(ctx, BanFilter{ExpiresBefore: time.Now().Add(2 * time.Hour)})
+	if err != nil || len(due) != 2 {
+		t.Errorf("ListBans due = %d bans, %v, want the expired and the temporary ones", len(due), err)
+	}
+
+	if revoked, err := db.RevokeBan(ctx, temporary, "42"); err != nil || !revoked {
+		t.Fatalf("RevokeBan = %v, %v", revoked, err)
+	}
+	if revoked, _ := db.RevokeBan(ctx, temporary, "42"); revoked {
+		t.Error("ban revoked twice")
+	}
+	ban, _, _ = db.GetBan(ctx, temporary)
+	if ban.Active(time.Now()) || ban.RevokedBy != "42" || ban.RevokedAt == nil {
+		t.Errorf("revoked ban = %+v", ban)
+	}
+
+	ban, _, _ = db.GetBan(ctx, expired)
+	ban.ExpiresAt = &future
+	if updated, err := db.UpdateBan(ctx, ban); err != nil || !updated {
+		t.Fatalf("UpdateBan = %v, %v", updated, err)
+	}
+	if _, found, _ = db.GetActiveBan(ctx, "-100", "2"); !found {
+		t.Error("extended ban is not active")
+	}
+
+	if err = db.DeleteBan(ctx, permanent); err != nil {
+		t.Fatalf("DeleteBan: %v", err)
+	}
+	if _, found, _ = db.GetBan(ctx, permanent); found {
+		t.Error("deleted ban is found")
+	}
+}
+
+func TestSQLite_Overrides(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	for i, kind := range []e.ActionKind{e.ActionKindErase, e.ActionKindNoop, e.ActionKindBan} {
+		_, err := db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: strconv.Itoa(i + 1), Text: "text"},
+			Action:  &e.Action{Kind: kind},
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+	}
+
+	for _, o := range []e.Override{
+		{ChatID: "-100", MessageID: "1", Kind: e.OverrideKindRestore, Actor: "42"},
+		{ChatID: "-100", MessageID: "2", Kind: e.OverrideKindConfirmSpam, Actor: "42", Note: "missed ad"},
+	} {
+		if _, found, err := db.AddOverride(ctx, o); err != nil || !found {
+			t.Fatalf("AddOverride = %v, %v", found, err)
+		}
+	}
+	if _, found, err := db.AddOverride(ctx, e.Override{ChatID: "-100", MessageID: "404", Kind: e.OverrideKindRestore}); err != nil || found {
+		t.Errorf("AddOverride of an unknown message = %v, %v, want not found", found, err)
+	}
+
+	overrides, err := db.ListOverrides(ctx, OverrideFilter{ChatID: "-100"})
+	if err != nil {
+		t.Fatalf("ListOverrides: %v", err)
+	}
+	if len(overrides) != 2 || overrides[0].MessageID != "2" || overrides[0].Original != e.ActionKindNoop || overrides[0].Note != "missed ad" {
+		t.Errorf("ListOverrides = %+v", overrides)
+	}
+
+	examples, err := db.ListExamples(ctx, ExampleFilter{})
+	if err != nil {
+		t.Fatalf("ListExamples: %v", err)
+	}
+	var got []string
+	for _, ex := range examples {
+		got = append(got, ex.MessageID+":"+string(ex.Label))
+	}
+	if want := "1:ham,2:spam,3:spam"; strings.Join(got, ",") != want {
+		t.Errorf("examples = %s, want overrides to relabel them: %s", strings.Join(got, ","), want)
+	}
+
+	if examples, _ = db.ListExamples(ctx, ExampleFilter{Overridden: true}); len(examples) != 2 {
+		t.Errorf("overridden examples = %d, want 2", len(examples))
+	}
+}
+
+func TestSQLite_SaveDecisionUpsertsMessage(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	sender := e.User{ID: "1", ChatID: "-100"}
+	first, err := db.SaveDecision(ctx, e.Decision{
+		Message: e.Message{Sender: sender, ID: "10", Text: "hello"},
+		Action:  &e.Action{Kind: e.ActionKindNoop},
+	})
+	if err != nil {
+		t.Fatalf("SaveDecision: %v", err)
+	}
+
+	// Re-delivered without a decision keeps the stored one
+	id, err := db.SaveMessage(ctx, e.Message{Sender: sender, ID: "10", Text: "hello"})
+	if err != nil || id != first {
+		t.Fatalf("SaveMessage = %d, %v, want the existing row %d", id, err, first)
+	}
+	msg, _, _ := db.GetMessage(ctx, "-100", "10")
+	if msg.Action == nil || *msg.Action != e.ActionKindNoop {
+		t.Errorf("re-delivered message lost its decision: %+v", msg)
+	}
+
+	// Edited into spam replaces the content and the decision
+	id, err = db.SaveDecision(ctx, e.Decision{
+		Message: e.Message{Sender: sender, ID: "10", Text: "buy crypto"},
+		Action:  &e.Action{Kind: e.ActionKindErase, Note: "edited into spam"},
+	})
+	if err != nil || id != first {
+		t.Fatalf("SaveDecision of an edit = %d, %v, want the existing row %d", id, err, first)
+	}
+	msg, _, _ = db.GetMessage(ctx, "-100", "10")
+	if msg.Text != "buy crypto" || msg.Action == nil || *msg.Action != e.ActionKindErase {
+		t.Errorf("edited message = %+v, want the new text and decision", msg)
+	}
+
+	if messages, _ := db.ListMessages(ctx, MessageFilter{ChatID: "-100"}); len(messages) != 1 {
+		t.Errorf("stored %d rows, want 1", len(messages))
+	}
+	if other, _ := db.SaveMessage(ctx, e.Message{Sender: e.User{ID: "1", ChatID: "-200"}, ID: "10"}); other == first {
+		t.Error("message of another chat with the same ID shares the row")
+	}
+}
+
+func TestSQLite_MigrationFoldsDuplicateMessages(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	// Revert down to the schema before the unique index
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatalf("Migrations: %v", err)
+	}
+	var steps int
+	for i, m := range migrations {
+		if m.Name == "unique_message" {
+			steps = len(migrations) - i
+		}
+	}
+	if err = db.MigrateDown(ctx, steps); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	for _, text := range []string{"first", "second"} {
+		_, err := db.db.ExecContext(ctx,
+			`INSERT INTO messages (message_id, chat_id, sender_user_id, sender_user_name, text, created_at)
+				VALUES ('10', '-100', '1', 'alice', ?, CURRENT_TIMESTAMP)`,
+			text,
+		)
+		if err != nil {
+			t.Fatalf("inserting duplicate: %v", err)
+		}
+	}
+
+	if err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("MigrateUp: %v", err)
+	}
+
+	messages, err := db.ListMessages(ctx, MessageFilter{ChatID: "-100"})
+	if err != nil || len(messages) != 1 || messages[0].Text != "second" {
+		t.Errorf("ListMessages = %+v, %v, want the latest duplicate only", messages, err)
+	}
+}
+
+func TestSQLite_ChatMetadata(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	joined := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	if err := db.UpdateChat(ctx, e.Chat{ID: "-100", Title: "Chat", Type: "supergroup", Language: "ru", JoinedAt: joined}); err != nil {
+		t.Fatalf("UpdateChat join: %v", err)
+	}
+
+	refreshed := joined.Add(24 * time.Hour)
+	err := db.UpdateChat(ctx, e.Chat{ID: "-100", Title: "Renamed", Type: "supergroup", Username: "chat", MemberCount: 250, RefreshedAt: refreshed})
+	if err != nil {
+		t.Fatalf("UpdateChat refresh: %v", err)
+	}
+
+	chat, found, err := db.GetChat(ctx, "-100")
+	if err != nil || !found {
+		t.Fatalf("GetChat = %v, %v", found, err)
+	}
+	want := e.Chat{
+		ID: "-100", Title: "Renamed", Type: "supergroup", Username: "chat", MemberCount: 250,
+		Language: "ru", JoinedAt: joined, RefreshedAt: refreshed,
+	}
+	if !chat.JoinedAt.Equal(want.JoinedAt) || !chat.RefreshedAt.Equal(want.RefreshedAt) {
+		t.Errorf("GetChat times = %v, %v, want %v, %v", chat.JoinedAt, chat.RefreshedAt, want.JoinedAt, want.RefreshedAt)
+	}
+	chat.JoinedAt, chat.RefreshedAt = want.JoinedAt, want.RefreshedAt
+	if chat != want {
+		t.Errorf("GetChat = %+v, want %+v, the join language kept", chat, want)
+	}
+
+	// A chat first stored with a message has no metadata yet
+	if _, err = db.SaveMessage(ctx, e.Message{ID: "1", Sender: e.User{ID: "1", ChatID: "-200", ChatTitle: "Other"}}); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	chats, err := db.ListChats(ctx)
+	if err != nil || len(chats) != 2 || chats[1].Title != "Other" || chats[1].Type != "" || !chats[1].JoinedAt.IsZero() {
+		t.Errorf("ListChats = %+v, %v, want the second chat without metadata", chats, err)
+	}
+
+	if _, found, _ = db.GetChat(ctx, "-300"); found {
+		t.Error("unknown chat found")
+	}
+}
+
+func TestSQLite_Verifications(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	past := time.Now().Add(-time.Minute)
+	future := time.Now().Add(time.Hour)
+
+	first, err := db.AddVerification(ctx, e.Verification{ChatID: "-100", UserID: "1", Challenge: "7", Deadline: future})
+	if err != nil {
+		t.Fatalf("AddVerification: %v", err)
+	}
+	second, _ := db.AddVerification(ctx, e.Verification{ChatID: "-100", UserID: "1", Challenge: "4", Deadline: future})
+	overdue, _ := db.AddVerification(ctx, e.Verification{ChatID: "-100", UserID: "2", Challenge: "9", Deadline: past})
+
+	v, found, err := db.GetPendingVerification(ctx, "-100", "1")
+	if err != nil || !found || v.ID != second || v.Challenge != "4" || v.Status != e.VerificationPending {
+		t.Errorf("GetPendingVerification = %+v, %v, %v, want the second challenge", v, found, err)
+	}
+
+	superseded, err := db.ListVerifications(ctx, VerificationFilter{UserID: "1", Status: e.VerificationExpired})
+	if err != nil || len(superseded) != 1 || superseded[0].ID != first || superseded[0].ResolvedAt == nil {
+		t.Errorf("ListVerifications expired = %+v, %v, want the first challenge", superseded, err)
+	}
+
+	due, err := db.ListVerifications(ctx, VerificationFilter{Status: e.VerificationPending, DeadlineBefore: time.Now()})
+	if err != nil || len(due) != 1 || due[0].ID != overdue {
+		t.Errorf("ListVerifications due = %+v, %v, want the overdue challenge", due, err)
+	}
+
+	if resolved, err := db.ResolveVerification(ctx, second, e.VerificationPassed); err != nil || !resolved {
+		t.Fatalf("ResolveVerification = %v, %v", resolved, err)
+	}
+	if resolved, _ := db.ResolveVerification(ctx, second, e.VerificationExpired); resolved {
+		t.Error("passed challenge expired")
+	}
+	if _, found, _ = db.GetPendingVerification(ctx, "-100", "1"); found {
+		t.Error("passed challenge still pending")
+	}
+}
+
+func TestSQLite_RawUpdates(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	payload := []byte(`{"update_id":2,"message":{"message_id":10,"text":"free crypto"}}`)
+	updates := []e.RawUpdate{
+		{UpdateID: 2, ChatID: "-100", MessageID: "10", UserID: "1", Payload: payload},
+		{UpdateID: 1, ChatID: "-100", MessageID: "9", UserID: "2", Payload: []byte(`{"update_id":1}`)},
+		{UpdateID: 3, ChatID: "-200", MessageID: "5", UserID: "1", Payload: []byte(`{"update_id":3}`)},
+	}
+	for _, u := range updates {
+		if err := db.ArchiveUpdate(ctx, u); err != nil {
+			t.Fatalf("ArchiveUpdate: %v", err)
+		}
+	}
+	if err := db.ArchiveUpdate(ctx, e.RawUpdate{UpdateID: 2, ChatID: "-100", MessageID: "10", UserID: "1", Payload: []byte(`{}`)}); err != nil {
+		t.Fatalf("ArchiveUpdate again: %v", err)
+	}
+
+	archived, err := db.ListRawUpdates(ctx, RawUpdateFilter{ChatID: "-100"})
+	if err != nil || len(archived) != 2 {
+		t.Fatalf("ListRawUpdates = %d updates, %v, want 2", len(archived), err)
+	}
+	if archived[0].UpdateID != 1 || archived[1].UpdateID != 2 || !bytes.Equal(archived[1].Payload, payload) {
+		t.Errorf("ListRawUpdates = %+v, want updates in order with the first payload kept", archived)
+	}
+
+	if _, err = db.DeleteUserData(ctx, "-200", "1"); err != nil {
+		t.Fatalf("DeleteUserData: %v", err)
+	}
+	if archived, _ = db.ListRawUpdates(ctx, RawUpdateFilter{ChatID: "-200"}); len(archived) != 0 {
+		t.Errorf("erased user's updates kept: %+v", archived)
+	}
+
+	if _, err = db.db.ExecContext(ctx, `UPDATE raw_updates SET created_at = ? WHERE update_id = 1`, formatTime(time.Now().AddDate(0, 0, -40))); err != nil {
+		t.Fatalf("aging update: %v", err)
+	}
+	result, err := db.Prune(ctx, e.RetentionPolicy{RawUpdateDays: 30})
+	if err != nil || result.RawUpdatesDeleted != 1 {
+		t.Errorf("Prune = %+v, %v, want the old update deleted", result, err)
+	}
+}
+
+func TestSQLite_Fingerprints(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	if _, added, err := db.AddFingerprint(ctx, "hi there", "", "manual"); err != nil || added {
+		t.Errorf("AddFingerprint of a short text = %v, %v, want skipped", added, err)
+	}
+	hash, added, err := db.AddFingerprint(ctx, "Earn $500 a day from home, DM me", e.SpamCategoryJobScam, "manual")
+	if err != nil || !added {
+		t.Fatalf("AddFingerprint = %v, %v", added, err)
+	}
+
+	// An obfuscated re-post matches and is counted
+	fp, found, err := db.MatchFingerprint(ctx, "earn  $500 a d\u200Bay from HOME, dm me")
+	if err != nil || !found {
+		t.Fatalf("MatchFingerprint = %v, %v, want found", found, err)
+	}
+	if fp.Hash != hash || fp.Hits != 1 || fp.Category != e.SpamCategoryJobScam || fp.LastSeenAt.IsZero() {
+		t.Errorf("matched fingerprint = %+v", fp)
+	}
+	if _, found, _ = db.MatchFingerprint(ctx, "a completely different message"); found {
+		t.Error("MatchFingerprint of an unknown text found a fingerprint")
+	}
+
+	// Confirmed spam is fingerprinted, restored messages are forgotten
+	for i, text := range []string{"Join our VIP crypto signals channel", "Earn $500 a day from home, DM me"} {
+		_, err = db.SaveDecision(ctx, e.Decision{
+			Message: e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: strconv.Itoa(i + 1), Text: text},
+			Action:  &e.Action{Kind: e.ActionKindNoop},
+		})
+		if err != nil {
+			t.Fatalf("SaveDecision: %v", err)
+		}
+	}
+	for _, o := range []e.Override{
+		{ChatID: "-100", MessageID: "1", Kind: e.OverrideKindConfirmSpam, Actor: "42"},
+		{ChatID: "-100", MessageID: "2", Kind: e.OverrideKindRestore, Actor: "42"},
+	} {
+		if _, _, err = db.AddOverride(ctx, o); err != nil {
+			t.Fatalf("AddOverride: %v", err)
+		}
+	}
+
+	// Spam of the ground truth is fingerprinted with its source
+	_, err = db.ImportGroundTruth(ctx, "dataset", []e.Example{
+		{Text: "Hot singles in your area tonight", Label: e.LabelSpam, Category: e.SpamCategoryAdult},
+		{Text: "Does anyone know a good dentist?", Label: e.LabelHam},
+	})
+	if err != nil {
+		t.Fatalf("ImportGroundTruth: %v", err)
+	}
+
+	fingerprints, err := db.ListFingerprints(ctx, FingerprintFilter{})
+	if err != nil {
+		t.Fatalf("ListFingerprints: %v", err)
+	}
+	var got []string
+	for _, fp := range fingerprints {
+		got = append(got, fp.Source+":"+fp.Sample)
+	}
+	want := []string{"override:Join our VIP crypto signals channel", "dataset:Hot singles in your area tonight"}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("fingerprints = %q, want %q", got, want)
+	}
+
+	if fingerprints, _ = db.ListFingerprints(ctx, FingerprintFilter{Category: e.SpamCategoryAdult}); len(fingerprints) != 1 {
+		t.Errorf("adult fingerprints = %d, want 1", len(fingerprints))
+	}
+}
@@ -0,0 +1,421 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// StatsFilter selects messages counted in statistics, zero fields don't
+// filter
+type StatsFilter struct {
+	ChatID string
+
+	// From and To bound the message time, To is exclusive. Messages pruned
+	// by retention are counted by day, so their bounds are rounded to days.
+	From time.Time
+	To   time.Time
+}
+
+// removedActions are the actions that remove a message
+var removedActions = `('` + strings.Join([]string{e.ActionKindErase, e.ActionKindBan, e.ActionKindMute}, `', '`) + `')`
+
+// counts returns a query of message counts and AI usage sums by chat, day,
+// action, category and error presence over both stored messages and the
+// counters of pruned ones
+func (f StatsFilter) counts() (string, []any) {
+	var (
+		messagesWhere = []string{"1 = 1"}
+		countersWhere = []string{"1 = 1"}
+		messagesArgs  []any
+		countersArgs  []any
+	)
+	if f.ChatID != "" {
+		messagesWhere = append(messagesWhere, "chat_id = ?")
+		messagesArgs = append(messagesArgs, f.ChatID)
+		countersWhere = append(countersWhere, "chat_id = ?")
+		countersArgs = append(countersArgs, f.ChatID)
+	}
+	if !f.From.IsZero() {
+		messagesWhere = append(messagesWhere, "created_at >= ?")
+		messagesArgs = append(messagesArgs, formatTime(f.From))
+		countersWhere = append(countersWhere, "day >= date(?)")
+		countersArgs = append(countersArgs, formatTime(f.From))
+	}
+	if !f.To.IsZero() {
+		messagesWhere = append(messagesWhere, "created_at < ?")
+		messagesArgs = append(messagesArgs, formatTime(f.To))
+		countersWhere = append(countersWhere, "day < date(?)")
+		countersArgs = append(countersArgs, formatTime(f.To))
+	}
+
+	query := `SELECT chat_id, date(created_at) AS day, COALESCE(action, '') AS action,
+		       COALESCE(category, '') AS category, error IS NOT NULL AS has_error, COUNT(*) AS count,
+		       COUNT(prompt_tokens) AS ai_requests, COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens,
+		       COALESCE(SUM(completion_tokens), 0) AS completion_tokens, COALESCE(SUM(ai_cost), 0) AS ai_cost
+		FROM messages
+		WHERE ` + strings.Join(messagesWhere, " AND ") + `
+		GROUP BY 1, 2, 3, 4, 5
+		UNION ALL
+		SELECT chat_id, day, action, category, has_error, count,
+		       ai_requests, prompt_tokens, completion_tokens, ai_cost
+		FROM message_stats
+		WHERE ` + strings.Join(countersWhere, " AND ")
+
+	return query, append(messagesArgs, countersArgs...)
+}
+
+// CountByAction returns the number of decided messages by action
+func (c *SQLite) CountByAction(ctx context.Context, filter StatsFilter) (map[e.ActionKind]int, error) {
+	counts, args := filter.counts()
+	query := `SELECT action, SUM(count) FROM (` + counts + `) WHERE action != '' GROUP BY action`
+
+	result := make(map[e.ActionKind]int)
+	err := c.queryCounts(ctx, query, args, func(key string, count int) {
+		result[e.ActionKind(key)] = count
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting by action: %w", err)
+	}
+	return result, nil
+}
+
+// CountByChat returns the number of checked messages by chat ID
+func (c *SQLite) CountByChat(ctx context.Context, filter StatsFilter) (map[string]int, error) {
+	counts, args := filter.counts()
+	query := `SELECT chat_id, SUM(count) FROM (` + counts + `) GROUP BY chat_id`
+
+	result := make(map[string]int)
+	err := c.queryCounts(ctx, query, args, func(key string, count int) {
+		result[key] = count
+	})
+	if err != nil {
+		return nil, fmt.Errorf("counting by chat: %w", err)
+	}
+	return result, nil
+}
+
+// CountByDay returns checked and removed messages by day, oldest first. Days
+// without messages are omitted.
+func (c *SQLite) CountByDay(ctx context.Context, filter StatsFilter) ([]e.DayStats, error) {
+	counts, args := filter.counts()
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT day, SUM(count), SUM(CASE WHEN action IN `+removedActions+` THEN count ELSE 0 END)
+		 FROM (`+counts+`)
+		 GROUP BY day
+		 ORDER BY day`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("counting by day: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var days []e.DayStats
+	for rows.Next() {
+		var (
+			day   string
+			stats e.DayStats
+		)
+		if err = rows.Scan(&day, &stats.Checked, &stats.Removed); err != nil {
+			return nil, fmt.Errorf("scanning day stats: %w", err)
+		}
+		if stats.Day, err = time.Parse(time.DateOnly, day); err != nil {
+			return nil, fmt.Errorf("parsing day %q: %w", day, err)
+		}
+		days = append(days, stats)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over day stats: %w", err)
+	}
+
+	return days, nil
+}
+
+// CountByChatDay returns moderation counters by chat and day, oldest first
+// and by chat ID within a day. Chats without messages on a day are omitted.
+// Overrides of pruned messages are gone with them and are not counted.
+func (c *SQLite) CountByChatDay(ctx context.Context, filter StatsFilter) ([]e.ChatDayStats, error) {
+	counts, args := filter.counts()
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT chat_id, day, SUM(count),
+		        SUM(CASE WHEN action = ? THEN count ELSE 0 END),
+		        SUM(CASE WHEN action = ? THEN count ELSE 0 END),
+		        SUM(CASE WHEN action = ? THEN count ELSE 0 END),
+		        SUM(CASE WHEN has_error THEN count ELSE 0 END),
+		        SUM(ai_requests), SUM(prompt_tokens), SUM(completion_tokens), SUM(ai_cost)
+		 FROM (`+counts+`)
+		 GROUP BY chat_id, day
+		 ORDER BY day, chat_id`,
+		append([]any{e.ActionKindErase, e.ActionKindBan, e.ActionKindMute}, args...)...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("counting by chat and day: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var stats []e.ChatDayStats
+	for rows.Next() {
+		var (
+			day string
+			s   e.ChatDayStats
+		)
+		err = rows.Scan(&s.ChatID, &day, &s.Checked, &s.Erased, &s.Banned, &s.Muted, &s.Errors,
+			&s.Spend.Requests, &s.Spend.PromptTokens, &s.Spend.CompletionTokens, &s.Spend.Cost)
+		if err != nil {
+			return nil, fmt.Errorf("scanning chat day stats: %w", err)
+		}
+		if s.Day, err = time.Parse(time.DateOnly, day); err != nil {
+			return nil, fmt.Errorf("parsing day %q: %w", day, err)
+		}
+		stats = append(stats, s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over chat day stats: %w", err)
+	}
+
+	overridden, err := c.countOverriddenByChatDay(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	for i, s := range stats {
+		stats[i].Overridden = overridden[s.ChatID+"/"+s.Day.Format(time.DateOnly)]
+	}
+
+	return stats, nil
+}
+
+// countOverriddenByChatDay returns the number of messages whose latest
+// override labels them ham by their chat ID and day joined with a slash
+func (c *SQLite) countOverriddenByChatDay(ctx context.Context, filter StatsFilter) (map[string]int, error) {
+	where, args := MessageFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To}.conditions()
+	where = append(where, `(SELECT o.kind FROM overrides AS o WHERE o.message_id = m.id
+		ORDER BY o.created_at DESC, o.id DESC LIMIT 1) IN (?, ?)`)
+	args = append(args, string(e.OverrideKindRestore), string(e.OverrideKindUnban))
+
+	result := make(map[string]int)
+	err := c.queryCounts(
+		ctx,
+		`SELECT m.chat_id || '/' || date(m.created_at), COUNT(*)
+		 FROM messages AS m
+		 WHERE `+strings.Join(where, " AND ")+`
+		 GROUP BY m.chat_id, date(m.created_at)`,
+		args,
+		func(key string, count int) {
+			result[key] = count
+		},
+	)
+	if err != nil {
+		return nil, fmt.Errorf("counting overridden messages: %w", err)
+	}
+	return result, nil
+}
+
+// CountByPromptVersion returns counters of AI decisions by model and prompt
+// version, the most recently used first. Pruned messages are not counted,
+// as their counters don't keep versions.
+func (c *SQLite) CountByPromptVersion(ctx context.Context, filter StatsFilter) ([]e.PromptVersionStats, error) {
+	where, args := MessageFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To}.conditions()
+	where = append(where, "m.prompt_version IS NOT NULL")
+
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT COALESCE(m.model, ''), m.prompt_version, COUNT(*),
+		        SUM(CASE WHEN m.action IN `+removedActions+` THEN 1 ELSE 0 END),
+		        MIN(m.created_at), MAX(m.created_at)
+		 FROM messages AS m
+		 WHERE `+strings.Join(where, " AND ")+`
+		 GROUP BY 1, 2
+		 ORDER BY MAX(m.created_at) DESC, 1, 2`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("counting by prompt version: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var versions []e.PromptVersionStats
+	for rows.Next() {
+		var (
+			stats       e.PromptVersionStats
+			first, last string
+		)
+		err = rows.Scan(&stats.Model, &stats.PromptVersion, &stats.Checked, &stats.Removed, &first, &last)
+		if err != nil {
+			return nil, fmt.Errorf("scanning prompt version stats: %w", err)
+		}
+		if stats.First, err = parseTime(first); err != nil {
+			return nil, err
+		}
+		if stats.Last, err = parseTime(last); err != nil {
+			return nil, err
+		}
+		versions = append(versions, stats)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over prompt version stats: %w", err)
+	}
+
+	return versions, nil
+}
+
+// SpendByChat returns the AI spend by chat ID
+func (c *SQLite) SpendByChat(ctx context.Context, filter StatsFilter) (map[string]e.Spend, error) {
+	counts, args := filter.counts()
+
+	result := make(map[string]e.Spend)
+	err := c.querySpend(ctx, `chat_id`, counts, args, func(key string, spend e.Spend) error {
+		result[key] = spend
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("summing spend by chat: %w", err)
+	}
+	return result, nil
+}
+
+// SpendByDay returns the AI spend by day, oldest first. Days without AI
+// requests are omitted.
+func (c *SQLite) SpendByDay(ctx context.Context, filter StatsFilter) ([]e.DaySpend, error) {
+	counts, args := filter.counts()
+
+	var days []e.DaySpend
+	err := c.querySpend(ctx, `day`, counts, args, func(key string, spend e.Spend) error {
+		day, err := time.Parse(time.DateOnly, key)
+		if err != nil {
+			return fmt.Errorf("parsing day %q: %w", key, err)
+		}
+		days = append(days, e.DaySpend{Day: day, Spend: spend})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("summing spend by day: %w", err)
+	}
+	return days, nil
+}
+
+// querySpend sums AI usage of the counts query grouped by the key column,
+// ordered by the key
+func (c *SQLite) querySpend(ctx context.Context, key, counts string, args []any, fn func(key string, spend e.Spend) error) error {
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT `+key+`, SUM(ai_requests), SUM(prompt_tokens), SUM(completion_tokens), SUM(ai_cost)
+		 FROM (`+counts+`)
+		 GROUP BY `+key+`
+		 HAVING SUM(ai_requests) > 0
+		 ORDER BY `+key,
+		args...,
+	)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			k     string
+			spend e.Spend
+		)
+		if err = rows.Scan(&k, &spend.Requests, &spend.PromptTokens, &spend.CompletionTokens, &spend.Cost); err != nil {
+			return err
+		}
+		if err = fn(k, spend); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// AverageConfidence returns the average confidence of AI verdicts recorded
+// in decision traces. Pruned messages have no traces and are not counted.
+func (c *SQLite) AverageConfidence(ctx context.Context, filter StatsFilter) (e.Confidence, error) {
+	where, args := MessageFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To}.conditions()
+	where = append(where,
+		"json_extract(m.trace, '$.stage') = ?",
+		"json_extract(m.trace, '$.confidence') IS NOT NULL",
+	)
+	args = append(args, string(e.DecisionStageAI))
+
+	var result e.Confidence
+	err := c.db.QueryRowContext(
+		ctx,
+		`SELECT COALESCE(AVG(json_extract(m.trace, '$.confidence')), 0), COUNT(*)
+		 FROM messages AS m
+		 WHERE `+strings.Join(where, " AND "),
+		args...,
+	).Scan(&result.Average, &result.Count)
+	if err != nil {
+		return result, fmt.Errorf("averaging confidence: %w", err)
+	}
+
+	return result, nil
+}
+
+// TopOffenders returns users with the most messages removed as spam, at most
+// limit of them. Pruned messages are not counted, as their counters don't
+// keep senders.
+func (c *SQLite) TopOffenders(ctx context.Context, filter StatsFilter, limit int) ([]e.UserOffenses, error) {
+	where, args := MessageFilter{ChatID: filter.ChatID, From: filter.From, To: filter.To}.conditions()
+	where = append(where, "m.action IN "+removedActions)
+	args = append(args, limit)
+
+	rows, err := c.db.QueryContext(
+		ctx,
+		`SELECT m.chat_id, m.sender_user_id, MAX(m.sender_user_name), COUNT(*) AS offenses
+		 FROM messages AS m
+		 WHERE `+strings.Join(where, " AND ")+`
+		 GROUP BY m.chat_id, m.sender_user_id
+		 ORDER BY offenses DESC, m.chat_id, m.sender_user_id
+		 LIMIT ?`,
+		args...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("querying offenders: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var offenders []e.UserOffenses
+	for rows.Next() {
+		var o e.UserOffenses
+		if err = rows.Scan(&o.User.ChatID, &o.User.ID, &o.User.Name, &o.Offenses); err != nil {
+			return nil, fmt.Errorf("scanning offender: %w", err)
+		}
+		offenders = append(offenders, o)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over offenders: %w", err)
+	}
+
+	return offenders, nil
+}
+
+// queryCounts runs a query of key and count pairs
+func (c *SQLite) queryCounts(ctx context.Context, query string, args []any, fn func(key string, count int)) error {
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var (
+			key   string
+			count int
+		)
+		if err = rows.Scan(&key, &count); err != nil {
+			return err
+		}
+		fn(key, count)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// Store is a storage backend
+type Store interface {
+	GetScore(ctx context.Context, user e.User, defaultValue int) (int, error)
+	GetScores(ctx context.Context, users []e.User, defaultValue int) ([]int, error)
+	SetScore(ctx context.Context, user e.User, score int) error
+	GetProbation(ctx context.Context, user e.User) (int, bool, error)
+	SetProbation(ctx context.Context, user e.User, remaining int) error
+
+	SaveMessage(ctx context.Context, msg e.Message) (int64, error)
+	SaveDecision(ctx context.Context, decision e.Decision) (int64, error)
+	SaveDecisions(ctx context.Context, decisions []e.Decision) ([]int64, error)
+	SaveAction(ctx context.Context, messageID int64, action e.Action) error
+	SaveError(ctx context.Context, messageID int64, error string) error
+	GetMessage(ctx context.Context, chatID, messageID string) (e.SavedMessage, bool, error)
+	CountUserMessages(ctx context.Context, user e.User) (int, error)
+	ListMessages(ctx context.Context, filter MessageFilter) ([]e.SavedMessage, error)
+	SearchMessages(ctx context.Context, query string, filter MessageFilter) ([]e.SavedMessage, error)
+	ListUserMessages(ctx context.Context, user e.User, limit int) ([]e.SavedMessage, error)
+	GetUserHistory(ctx context.Context, user e.User, limit int) (e.UserHistory, error)
+
+	ListExamples(ctx context.Context, filter ExampleFilter) ([]e.Example, error)
+	AddOverride(ctx context.Context, override e.Override) (int64, bool, error)
+	ListOverrides(ctx context.Context, filter OverrideFilter) ([]e.Override, error)
+	ImportGroundTruth(ctx context.Context, source string, examples []e.Example) (e.ImportResult, error)
+	ListGroundTruth(ctx context.Context, filter GroundTruthFilter) ([]e.GroundTruth, error)
+	AddFingerprint(ctx context.Context, text string, category e.SpamCategory, source string) (string, bool, error)
+	MatchFingerprint(ctx context.Context, text string) (e.SpamFingerprint, bool, error)
+	DeleteFingerprint(ctx context.Context, text string) (bool, error)
+	ListFingerprints(ctx context.Context, filter FingerprintFilter) ([]e.SpamFingerprint, error)
+	GetVerdict(ctx context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error)
+	PutVerdict(ctx context.Context, v e.CachedVerdict) error
+
+	StoreEmbedding(ctx context.Context, messageID int64, model string, vector []float32) error
+	FindSimilar(ctx context.Context, model string, vector []float32, filter SimilarityFilter) ([]e.SimilarMessage, error)
+
+	ListChats(ctx context.Context) ([]e.Chat, error)
+	GetChat(ctx context.Context, chatID string) (e.Chat, bool, error)
+	UpdateChat(ctx context.Context, chat e.Chat) error
+	GetChatStats(ctx context.Context, chatID string, from, to time.Time) (e.ChatStats, error)
+	CountByAction(ctx context.Context, filter StatsFilter) (map[e.ActionKind]int, error)
+	CountByChat(ctx context.Context, filter StatsFilter) (map[string]int, error)
+	CountByDay(ctx context.Context, filter StatsFilter) ([]e.DayStats, error)
+	CountByChatDay(ctx context.Context, filter StatsFilter) ([]e.ChatDayStats, error)
+	AverageConfidence(ctx context.Context, filter StatsFilter) (e.Confidence, error)
+	TopOffenders(ctx context.Context, filter StatsFilter, limit int) ([]e.UserOffenses, error)
+	CountByPromptVersion(ctx context.Context, filter StatsFilter) ([]e.PromptVersionStats, error)
+	SpendByChat(ctx context.Context, filter StatsFilter) (map[string]e.Spend, error)
+	SpendByDay(ctx context.Context, filter StatsFilter) ([]e.DaySpend, error)
+
+	GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, bool, error)
+	SetChatSettings(ctx context.Context, chatID string, settings e.ChatSettings) error
+	DeleteChatSettings(ctx context.Context, chatID string) error
+
+	AddBan(ctx context.Context, ban e.Ban) (int64, error)
+	GetBan(ctx context.Context, id int64) (e.Ban, bool, error)
+	GetActiveBan(ctx context.Context, chatID, userID string) (e.Ban, bool, error)
+	ListBans(ctx context.Context, filter BanFilter) ([]e.Ban, error)
+	UpdateBan(ctx context.Context, ban e.Ban) (bool, error)
+	RevokeBan(ctx context.Context, id int64, revokedBy string) (bool, error)
+	DeleteBan(ctx context.Context, id int64) error
+
+	AddVerification(ctx context.Context, v e.Verification) (int64, error)
+	GetPendingVerification(ctx context.Context, chatID, userID string) (e.Verification, bool, error)
+	ResolveVerification(ctx context.Context, id int64, status e.VerificationStatus) (bool, error)
+	ListVerifications(ctx context.Context, filter VerificationFilter) ([]e.Verification, error)
+
+	ArchiveUpdate(ctx context.Context, update e.RawUpdate) error
+	ListRawUpdates(ctx context.Context, filter RawUpdateFilter) ([]e.RawUpdate, error)
+
+	DeleteUserData(ctx context.Context, chatID, userID string) (e.ErasureResult, error)
+
+	AppendAudit(ctx context.Context, entry e.AuditEntry) error
+	ListAudit(ctx context.Context, filter AuditFilter) ([]e.AuditEntry, error)
+
+	Prune(ctx context.Context, policy e.RetentionPolicy) (e.PruneResult, error)
+	Vacuum(ctx context.Context) (e.VacuumResult, error)
+
+	Backup(ctx context.Context, destPath string) error
+	Restore(ctx context.Context, srcPath string) error
+
+	MigrateUp(ctx context.Context) error
+	MigrateDown(ctx context.Context, steps int) error
+	MigrationStatuses(ctx context.Context) ([]MigrationStatus, error)
+
+	Close() error
+}
+
+// Opener opens a backend at the location, the part of the DSN after the
+// scheme, without migrating its schema
+type Opener func(location string) (Store, error)
+
+var backends = map[string]Opener{}
+
+// Register makes a backend available for DSNs with the given scheme
+func Register(scheme string, open Opener) {
+	backends[scheme] = open
+}
+
+func init() {
+	Register("sqlite", func(location string) (Store, error) {
+		path, opts, err := parseSQLiteLocation(location)
+		if err != nil {
+			return nil, err
+		}
+		return OpenSQLite(path, opts)
+	})
+}
+
+// Open opens the storage backend selected by the DSN scheme and applies
+// pending schema migrations. A DSN without a scheme is a path to an SQLite
+// database file, for compatibility with plain DB_PATH values. SQLite DSNs
+// accept journal_mode, busy_timeout, foreign_keys, max_open_conns, read_only
+// and immutable parameters overriding DefaultSQLiteOptions, and a key_file
+// parameter naming the file with the key of an encrypted database, which can
+// also be given in the DB_KEY or DB_KEY_FILE environment variables. The schema
+// of a read-only database is checked to be up to date instead of migrated.
+//
+// Examples: "sqlite://./db/antispam.sqlite?busy_timeout=10s",
+// "./db/antispam.sqlite", "file:antispam.sqlite?cache=shared".
+func Open(ctx context.Context, dsn string) (Store, error) {
+	store, err := OpenUnmigrated(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = store.MigrateUp(ctx); err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("migrating database: %w", err)
+	}
+
+	return store, nil
+}
+
+// OpenMigrated opens the storage backend selected by the DSN without
+// migrating its schema, failing with ErrMigrationsPending unless all the
+// migrations are applied, for a bot whose migrations are applied ahead of a
+// deploy with the migrate command
+func OpenMigrated(ctx context.Context, dsn string) (Store, error) {
+	store, err := OpenUnmigrated(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses, err := store.MigrationStatuses(ctx)
+	if err != nil {
+		_ = store.Close()
+		return nil, fmt.Errorf("getting migration statuses: %w", err)
+	}
+	if pending := len(PendingMigrations(statuses)); pending > 0 {
+		_ = store.Close()
+		return nil, fmt.Errorf("%w: %d, apply them with the migrate command", ErrMigrationsPending, pending)
+	}
+
+	return store, nil
+}
+
+// PendingMigrations returns the migrations of the statuses not applied yet
+func PendingMigrations(statuses []MigrationStatus) []MigrationStatus {
+	var pending []MigrationStatus
+	for _, s := range statuses {
+		if s.AppliedAt.IsZero() {
+			pending = append(pending, s)
+		}
+	}
+	return pending
+}
+
+// OpenReadOnly opens the storage backend selected by the DSN for reading
+// only, for analytics tools running against the live database of the bot or
+// a copy of it. The schema must be up to date. A DSN may ask for an immutable
+// SQLite database instead with the immutable parameter.
+func OpenReadOnly(ctx context.Context, dsn string) (Store, error) {
+	if scheme, _ := parseDSN(dsn); scheme == "sqlite" && !strings.Contains(dsn, "immutable=") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "read_only=true"
+	}
+
+	return Open(ctx, dsn)
+}
+
+// OpenUnmigrated opens the storage backend selected by the DSN scheme as is,
+// for tools that manage the schema themselves
+func OpenUnmigrated(dsn string) (Store, error) {
+	scheme, location := parseDSN(dsn)
+
+	open, ok := backends[scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage scheme %q, known: %s", scheme, strings.Join(schemes(), ", "))
+	}
+
+	return open(location)
+}
+
+// parseDSN splits a DSN into the backend scheme and the location passed to
+// the backend
+func parseDSN(dsn string) (scheme, location string) {
+	if strings.HasPrefix(dsn, "file:") {
+		// SQLite URI filename, passed to the driver as is
+		return "sqlite", dsn
+	}
+
+	if scheme, location, ok := strings.Cut(dsn, "://"); ok {
+		if scheme == "sqlite" || scheme == "sqlite3" {
+			return "sqlite", location
+		}
+		return scheme, dsn
+	}
+
+	return "sqlite", dsn
+}
+
+// parseSQLiteLocation extracts SQLiteOptions from the location parameters,
+// other parameters are left for the driver
+func parseSQLiteLocation(location string) (string, SQLiteOptions, error) {
+	opts := DefaultSQLiteOptions
+
+	var err error
+	opts.Key = os.Getenv(SQLiteKeyEnv)
+	if file := os.Getenv(SQLiteKeyFileEnv); file != "" {
+		if opts.Key, err = readKeyFile(file); err != nil {
+			return "", opts, err
+		}
+	}
+
+	path, query, ok := strings.Cut(location, "?")
+	if !ok {
+		return location, opts, nil
+	}
+
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", opts, fmt.Errorf("parsing sqlite parameters: %w", err)
+	}
+
+	if v := params.Get("journal_mode"); v != "" {
+		opts.JournalMode = v
+		params.Del("journal_mode")
+	}
+	if v := params.Get("busy_timeout"); v != "" {
+		if opts.BusyTimeout, err = time.ParseDuration(v); err != nil {
+			return "", opts, fmt.Errorf("parsing busy_timeout: %w", err)
+		}
+		params.Del("busy_timeout")
+	}
+	if v := params.Get("foreign_keys"); v != "" {
+		if opts.ForeignKeys, err = strconv.ParseBool(v); err != nil {
+			return "", opts, fmt.Errorf("parsing foreign_keys: %w", err)
+		}
+		params.Del("foreign_keys")
+	}
+	if v := params.Get("max_open_conns"); v != "" {
+		if opts.MaxOpenConns, err = strconv.Atoi(v); err != nil {
+			return "", opts, fmt.Errorf("parsing max_open_conns: %w", err)
+		}
+		params.Del("max_open_conns")
+	}
+	if v := params.Get("read_only"); v != "" {
+		if opts.ReadOnly, err = strconv.ParseBool(v); err != nil {
+			return "", opts, fmt.Errorf("parsing read_only: %w", err)
+		}
+		params.Del("read_only")
+	}
+	if v := params.Get("immutable"); v != "" {
+		if opts.Immutable, err = strconv.ParseBool(v); err != nil {
+			return "", opts, fmt.Errorf("parsing immutable: %w", err)
+		}
+		params.Del("immutable")
+	}
+	if v := params.Get("key_file"); v != "" {
+		if opts.Key, err = readKeyFile(v); err != nil {
+			return "", opts, err
+		}
+		params.Del("key_file")
+	}
+
+	if len(params) > 0 {
+		path += "?" + params.Encode()
+	}
+
+	return path, opts, nil
+}
+
+// readKeyFile reads a database key from the file, ignoring surrounding
+// whitespace such as a trailing newline
+func readKeyFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("reading database key: %w", err)
+	}
+
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return "", fmt.Errorf("database key file %s is empty", path)
+	}
+
+	return key, nil
+}
+
+func schemes() []string {
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
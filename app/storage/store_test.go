@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		dsn          string
+		wantScheme   string
+		wantLocation string
+	}{
+		{dsn: "./db/antispam.sqlite", wantScheme: "sqlite", wantLocation: "./db/antispam.sqlite"},
+		{dsn: "sqlite://./db/antispam.sqlite", wantScheme: "sqlite", wantLocation: "./db/antispam.sqlite"},
+		{dsn: "sqlite3:///var/lib/antispam.sqlite", wantScheme: "sqlite", wantLocation: "/var/lib/antispam.sqlite"},
+		{dsn: "file:antispam.sqlite?cache=shared", wantScheme: "sqlite", wantLocation: "file:antispam.sqlite?cache=shared"},
+		{dsn: "mysql://user@localhost/antispam", wantScheme: "mysql", wantLocation: "mysql://user@localhost/antispam"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.dsn, func(t *testing.T) {
+			scheme, location := parseDSN(tc.dsn)
+			if scheme != tc.wantScheme || location != tc.wantLocation {
+				t.Errorf("parseDSN = %q, %q, want %q, %q", scheme, location, tc.wantScheme, tc.wantLocation)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	ctx := context.Background()
+
+	store, err := Open(ctx, "sqlite://"+filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatalf("Open sqlite: %v", err)
+	}
+	_ = store.Close()
+
+	if _, err = Open(ctx, "mysql://localhost/antispam"); err == nil {
+		t.Error("Open with an unknown scheme succeeded")
+	}
+}
+
+func TestParseSQLiteLocation(t *testing.T) {
+	path, opts, err := parseSQLiteLocation("./db.sqlite?busy_timeout=10s&journal_mode=DELETE&max_open_conns=1&cache=shared")
+	if err != nil {
+		t.Fatalf("parseSQLiteLocation: %v", err)
+	}
+
+	if path != "./db.sqlite?cache=shared" {
+		t.Errorf("path = %q, want driver parameters kept", path)
+	}
+	want := SQLiteOptions{JournalMode: "DELETE", BusyTimeout: 10 * time.Second, ForeignKeys: true, MaxOpenConns: 1}
+	if opts != want {
+		t.Errorf("opts = %+v, want %+v", opts, want)
+	}
+
+	if _, _, err = parseSQLiteLocation("./db.sqlite?busy_timeout=soon"); err == nil {
+		t.Error("invalid busy_timeout accepted")
+	}
+}
+
+func TestParseSQLiteLocation_KeyFile(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	path, opts, err := parseSQLiteLocation("./db.sqlite?key_file=" + keyFile)
+	if err != nil {
+		t.Fatalf("parseSQLiteLocation: %v", err)
+	}
+	if path != "./db.sqlite" || opts.Key != "s3cret" {
+		t.Errorf("parseSQLiteLocation = %q, key %q, want the key read from the file", path, opts.Key)
+	}
+
+	t.Setenv(SQLiteKeyEnv, "from-env")
+	if _, opts, _ = parseSQLiteLocation("./db.sqlite"); opts.Key != "from-env" {
+		t.Errorf("key = %q, want it from the environment", opts.Key)
+	}
+
+	if _, _, err = parseSQLiteLocation("./db.sqlite?key_file=" + keyFile + ".missing"); err == nil {
+		t.Error("missing key file accepted")
+	}
+}
+
+func TestOpenSQLite_EncryptionUnavailable(t *testing.T) {
+	opts := DefaultSQLiteOptions
+	opts.Key = "s3cret"
+
+	db, err := OpenSQLite(filepath.Join(t.TempDir(), "test.sqlite"), opts)
+	if err == nil {
+		// Built against SQLCipher
+		_ = db.Close()
+		t.Skip("SQLCipher is available")
+	}
+	if !errors.Is(err, ErrEncryptionUnavailable) {
+		t.Errorf("OpenSQLite with a key: err = %v, want ErrEncryptionUnavailable", err)
+	}
+}
+
+func TestOpenMigrated(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+
+	if _, err := OpenMigrated(ctx, path); !errors.Is(err, ErrMigrationsPending) {
+		t.Fatalf("OpenMigrated of a new database: err = %v, want ErrMigrationsPending", err)
+	}
+
+	db, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err = db.MigrateDown(ctx, 1); err != nil {
+		t.Fatalf("MigrateDown: %v", err)
+	}
+	if err = db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err = OpenMigrated(ctx, path); !errors.Is(err, ErrMigrationsPending) {
+		t.Errorf("OpenMigrated with a reverted migration: err = %v, want ErrMigrationsPending", err)
+	}
+
+	migrated, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	_ = migrated.Close()
+
+	db, err = OpenMigrated(ctx, path)
+	if err != nil {
+		t.Fatalf("OpenMigrated of a migrated database: %v", err)
+	}
+	_ = db.Close()
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.sqlite")
+
+	rw, err := Open(ctx, path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer func() { _ = rw.Close() }()
+	if err = rw.SetScore(ctx, e.User{ID: "1", ChatID: "-100"}, 3); err != nil {
+		t.Fatalf("SetScore: %v", err)
+	}
+
+	// Immutable opening ignores the write-ahead log, so it needs a copy
+	backup := filepath.Join(t.TempDir(), "backup.sqlite")
+	if err = rw.Backup(ctx, backup); err != nil {
+		t.Fatalf("Backup: %v", err)
+	}
+
+	for _, dsn := range []string{path, "sqlite://" + backup + "?immutable=true"} {
+		t.Run(dsn, func(t *testing.T) {
+			ro, err := OpenReadOnly(ctx, dsn)
+			if err != nil {
+				t.Fatalf("OpenReadOnly: %v", err)
+			}
+			defer func() { _ = ro.Close() }()
+
+			if score, err := ro.GetScore(ctx, e.User{ID: "1", ChatID: "-100"}, 0); err != nil || score != 3 {
+				t.Errorf("GetScore = %d, %v, want 3", score, err)
+			}
+			if err = ro.SetScore(ctx, e.User{ID: "2", ChatID: "-100"}, 1); err == nil {
+				t.Error("SetScore on a read-only database succeeded")
+			}
+			if err = ro.MigrateDown(ctx, 1); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("MigrateDown: err = %v, want ErrReadOnly", err)
+			}
+		})
+	}
+
+	if _, err = OpenReadOnly(ctx, filepath.Join(t.TempDir(), "missing.sqlite")); err == nil {
+		t.Error("OpenReadOnly of a missing database succeeded")
+	}
+}
@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+const verdictColumns = `text_hash, prompt_version, model, is_spam, category, confidence, note, created_at, expires_at`
+
+// GetVerdict returns the cached verdict on the text hash made with the prompt
+// version, found is false if there is none or it has expired
+func (c *SQLite) GetVerdict(ctx context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error) {
+	row := c.db.QueryRowContext(
+		ctx,
+		`SELECT `+verdictColumns+` FROM verdict_cache WHERE text_hash = ? AND prompt_version = ? AND expires_at > ?`,
+		textHash, promptVersion, formatTime(time.Now()),
+	)
+
+	v, err := scanVerdict(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.CachedVerdict{}, false, nil
+		}
+		return e.CachedVerdict{}, false, err
+	}
+
+	return v, true, nil
+}
+
+// PutVerdict caches the verdict, replacing the one on the same text hash and
+// prompt version. CreatedAt is assigned by the store.
+func (c *SQLite) PutVerdict(ctx context.Context, v e.CachedVerdict) error {
+	_, err := c.db.ExecContext(
+		ctx,
+		`INSERT INTO verdict_cache (`+verdictColumns+`)
+			VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?)
+			ON CONFLICT (text_hash, prompt_version) DO UPDATE SET
+				model = excluded.model,
+				is_spam = excluded.is_spam,
+				category = excluded.category,
+				confidence = excluded.confidence,
+				note = excluded.note,
+				created_at = excluded.created_at,
+				expires_at = excluded.expires_at`,
+		v.TextHash, v.PromptVersion, v.Model, v.IsSpam, nullString(string(v.Category)), v.Confidence, v.Note,
+		formatTime(v.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("inserting verdict: %w", err)
+	}
+
+	return nil
+}
+
+func scanVerdict(row interface{ Scan(dest ...any) error }) (e.CachedVerdict, error) {
+	var (
+		v        e.CachedVerdict
+		category sql.NullString
+	)
+	err := row.Scan(&v.TextHash, &v.PromptVersion, &v.Model, &v.IsSpam, &category, &v.Confidence, &v.Note, &v.CreatedAt, &v.ExpiresAt)
+	if err != nil {
+		return e.CachedVerdict{}, err
+	}
+
+	v.Category = e.SpamCategory(category.String)
+
+	return v, nil
+}
+
+// VerdictStore stores cached verdicts
+type VerdictStore interface {
+	GetVerdict(ctx context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error)
+	PutVerdict(ctx context.Context, v e.CachedVerdict) error
+}
+
+// verdictMemoryTTL is how long a verdict is used from memory before it is
+// read from the store again, so one dropped from the store by another
+// process, e.g. by an override, stops being used
+const verdictMemoryTTL = 10 * time.Minute
+
+// VerdictCache is a write-through LRU cache of verdicts in front of a store,
+// so a text repeated across chats is looked up in memory
+type VerdictCache struct {
+	store VerdictStore
+	size  int
+	now   func() time.Time
+
+	mu      sync.Mutex
+	order   *list.List // of *verdictEntry, most recently used first
+	entries map[verdictKey]*list.Element
+}
+
+type verdictKey struct {
+	textHash, promptVersion string
+}
+
+type verdictEntry struct {
+	verdict e.CachedVerdict
+	stale   time.Time
+}
+
+// NewVerdictCache returns a cache of at most size verdicts in front of the
+// store
+func NewVerdictCache(store VerdictStore, size int) *VerdictCache {
+	return &VerdictCache{
+		store:   store,
+		size:    size,
+		now:     time.Now,
+		order:   list.New(),
+		entries: make(map[verdictKey]*list.Element, size),
+	}
+}
+
+// GetVerdict returns the cached verdict, reading it from the store on a miss
+func (c *VerdictCache) GetVerdict(ctx context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error) {
+	key := verdictKey{textHash: textHash, promptVersion: promptVersion}
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*verdictEntry)
+		if now := c.now(); now.Before(entry.verdict.ExpiresAt) && now.Before(entry.stale) {
+			c.order.MoveToFront(el)
+			c.mu.Unlock()
+			return entry.verdict, true, nil
+		}
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+
+	v, found, err := c.store.GetVerdict(ctx, textHash, promptVersion)
+	if err != nil || !found {
+		return e.CachedVerdict{}, false, err
+	}
+
+	c.put(v)
+	return v, true, nil
+}
+
+// PutVerdict writes the verdict to the store and then to the cache
+func (c *VerdictCache) PutVerdict(ctx context.Context, v e.CachedVerdict) error {
+	if err := c.store.PutVerdict(ctx, v); err != nil {
+		return err
+	}
+
+	c.put(v)
+	return nil
+}
+
+func (c *VerdictCache) put(v e.CachedVerdict) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := verdictKey{textHash: v.TextHash, promptVersion: v.PromptVersion}
+	entry := &verdictEntry{verdict: v, stale: c.now().Add(verdictMemoryTTL)}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		old := oldest.Value.(*verdictEntry).verdict
+		delete(c.entries, verdictKey{textHash: old.TextHash, promptVersion: old.PromptVersion})
+	}
+}
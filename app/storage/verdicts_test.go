@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+func TestSQLite_VerdictCache(t *testing.T) {
+	ctx := context.Background()
+	db := newTestSQLite(t)
+
+	text := "Earn $500 a day from home, DM me"
+	v := e.CachedVerdict{
+		TextHash:      textnorm.Hash(text),
+		PromptVersion: "v1",
+		Model:         "gpt-5-mini",
+		IsSpam:        true,
+		Category:      e.SpamCategoryJobScam,
+		Confidence:    0.9,
+		Note:          "job scam",
+		ExpiresAt:     time.Now().Add(time.Hour),
+	}
+	if err := db.PutVerdict(ctx, v); err != nil {
+		t.Fatalf("PutVerdict: %v", err)
+	}
+
+	got, found, err := db.GetVerdict(ctx, v.TextHash, "v1")
+	if err != nil || !found {
+		t.Fatalf("GetVerdict = %v, %v, want found", found, err)
+	}
+	if !got.IsSpam || got.Category != e.SpamCategoryJobScam || got.Model != "gpt-5-mini" || got.Confidence != 0.9 || got.CreatedAt.IsZero() {
+		t.Errorf("verdict = %+v", got)
+	}
+	if _, found, _ = db.GetVerdict(ctx, v.TextHash, "v2"); found {
+		t.Error("verdict of another prompt version found")
+	}
+
+	// Expired verdicts are not used and are pruned
+	expired := v
+	expired.TextHash = textnorm.Hash("another text")
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err = db.PutVerdict(ctx, expired); err != nil {
+		t.Fatalf("PutVerdict: %v", err)
+	}
+	if _, found, _ = db.GetVerdict(ctx, expired.TextHash, "v1"); found {
+		t.Error("expired verdict found")
+	}
+	result, err := db.Prune(ctx, e.RetentionPolicy{})
+	if err != nil || result.VerdictsDeleted != 1 {
+		t.Errorf("Prune = %+v, %v, want the expired verdict deleted", result, err)
+	}
+
+	// An override of a message with the text drops its verdicts
+	_, err = db.SaveDecision(ctx, e.Decision{
+		Message: e.Message{Sender: e.User{ID: "1", ChatID: "-100"}, ID: "1", Text: text},
+		Action:  &e.Action{Kind: e.ActionKindErase},
+	})
+	if err != nil {
+		t.Fatalf("SaveDecision: %v", err)
+	}
+	if _, _, err = db.AddOverride(ctx, e.Override{ChatID: "-100", MessageID: "1", Kind: e.OverrideKindRestore}); err != nil {
+		t.Fatalf("AddOverride: %v", err)
+	}
+	if _, found, _ = db.GetVerdict(ctx, v.TextHash, "v1"); found {
+		t.Error("verdict on a restored text found")
+	}
+}
+
+type countingVerdicts struct {
+	verdicts map[string]e.CachedVerdict
+	reads    int
+}
+
+func (s *countingVerdicts) GetVerdict(_ context.Context, textHash, promptVersion string) (e.CachedVerdict, bool, error) {
+	s.reads++
+	v, ok := s.verdicts[textHash+"/"+promptVersion]
+	return v, ok, nil
+}
+
+func (s *countingVerdicts) PutVerdict(_ context.Context, v e.CachedVerdict) error {
+	s.verdicts[v.TextHash+"/"+v.PromptVersion] = v
+	return nil
+}
+
+func TestVerdictCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := &countingVerdicts{verdicts: map[string]e.CachedVerdict{}}
+	cache := NewVerdictCache(store, 10)
+	cache.now = func() time.Time { return now }
+
+	v := e.CachedVerdict{TextHash: "h", PromptVersion: "v1", IsSpam: true, ExpiresAt: now.Add(time.Hour)}
+	if err := cache.PutVerdict(ctx, v); err != nil {
+		t.Fatalf("PutVerdict: %v", err)
+	}
+
+	if got, found, _ := cache.GetVerdict(ctx, "h", "v1"); !found || !got.IsSpam || store.reads != 0 {
+		t.Errorf("GetVerdict = %+v, %v with %d store reads, want it from memory", got, found, store.reads)
+	}
+
+	// Dropped from the store by another process, it's used from memory only
+	// for a while
+	delete(store.verdicts, "h/v1")
+	now = now.Add(verdictMemoryTTL)
+	if _, found, _ := cache.GetVerdict(ctx, "h", "v1"); found || store.reads != 1 {
+		t.Errorf("stale GetVerdict found = %v with %d store reads, want it re-read and missing", found, store.reads)
+	}
+
+	if _, found, _ := cache.GetVerdict(ctx, "missing", "v1"); found {
+		t.Error("GetVerdict of a missing verdict found")
+	}
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+const verificationColumns = `id, chat_id, user_id, challenge, issued_at, deadline, status, resolved_at`
+
+// AddVerification records a pending challenge and returns its ID, IssuedAt
+// and Status are assigned by the store. A challenge still pending for the
+// user in the chat is expired, so the user has only the latest one.
+func (c *SQLite) AddVerification(ctx context.Context, v e.Verification) (int64, error) {
+	var id int64
+	err := c.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(
+			ctx,
+			`UPDATE verifications SET status = ?, resolved_at = CURRENT_TIMESTAMP
+				WHERE chat_id = ? AND user_id = ? AND status = ?`,
+			string(e.VerificationExpired), v.ChatID, v.UserID, string(e.VerificationPending),
+		)
+		if err != nil {
+			return fmt.Errorf("expiring pending verification: %w", err)
+		}
+
+		res, err := tx.ExecContext(
+			ctx,
+			`INSERT INTO verifications (chat_id, user_id, challenge, issued_at, deadline, status)
+				VALUES (?, ?, ?, CURRENT_TIMESTAMP, ?, ?)`,
+			v.ChatID, v.UserID, v.Challenge, formatTime(v.Deadline), string(e.VerificationPending),
+		)
+		if err != nil {
+			return fmt.Errorf("inserting verification: %w", err)
+		}
+
+		id, err = res.LastInsertId()
+		return err
+	})
+
+	return id, err
+}
+
+// GetPendingVerification returns the challenge pending for the user in the
+// chat, found is false if there is none
+func (c *SQLite) GetPendingVerification(ctx context.Context, chatID, userID string) (e.Verification, bool, error) {
+	row := c.db.QueryRowContext(
+		ctx,
+		`SELECT `+verificationColumns+` FROM verifications WHERE chat_id = ? AND user_id = ? AND status = ?`,
+		chatID, userID, string(e.VerificationPending),
+	)
+
+	v, err := scanVerification(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return e.Verification{}, false, nil
+		}
+		return e.Verification{}, false, err
+	}
+
+	return v, true, nil
+}
+
+// ResolveVerification sets the final status of a pending challenge, resolved
+// is false if the challenge is not pending anymore, e.g. it was answered
+// while being expired
+func (c *SQLite) ResolveVerification(ctx context.Context, id int64, status e.VerificationStatus) (bool, error) {
+	res, err := c.db.ExecContext(
+		ctx,
+		`UPDATE verifications SET status = ?, resolved_at = CURRENT_TIMESTAMP WHERE id = ? AND status = ?`,
+		string(status), id, string(e.VerificationPending),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// VerificationFilter selects challenges, zero fields don't filter
+type VerificationFilter struct {
+	ChatID string
+	UserID string
+	Status e.VerificationStatus
+
+	// DeadlineBefore selects challenges with the deadline before the time,
+	// combined with the pending status it selects the ones to enforce
+	DeadlineBefore time.Time
+
+	Limit  int
+	Offset int
+}
+
+// ListVerifications returns challenges matching the filter, newest first
+func (c *SQLite) ListVerifications(ctx context.Context, filter VerificationFilter) ([]e.Verification, error) {
+	var (
+		where []string
+		args  []any
+	)
+	if filter.ChatID != "" {
+		where = append(where, "chat_id = ?")
+		args = append(args, filter.ChatID)
+	}
+	if filter.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, string(filter.Status))
+	}
+	if !filter.DeadlineBefore.IsZero() {
+		where = append(where, "deadline < ?")
+		args = append(args, formatTime(filter.DeadlineBefore))
+	}
+
+	query := `SELECT ` + verificationColumns + ` FROM verifications`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY issued_at DESC, id DESC"
+	if filter.Limit > 0 || filter.Offset > 0 {
+		limit := filter.Limit
+		if limit <= 0 {
+			limit = -1
+		}
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, limit, filter.Offset)
+	}
+
+	rows, err := c.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying verifications: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+
+	var verifications []e.Verification
+	for rows.Next() {
+		v, err := scanVerification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scanning verification: %w", err)
+		}
+		verifications = append(verifications, v)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterating over verifications: %w", err)
+	}
+
+	return verifications, nil
+}
+
+func scanVerification(row interface{ Scan(dest ...any) error }) (e.Verification, error) {
+	var (
+		v          e.Verification
+		resolvedAt sql.NullTime
+	)
+	err := row.Scan(&v.ID, &v.ChatID, &v.UserID, &v.Challenge, &v.IssuedAt, &v.Deadline, &v.Status, &resolvedAt)
+	if err != nil {
+		return e.Verification{}, err
+	}
+
+	if resolvedAt.Valid {
+		v.ResolvedAt = &resolvedAt.Time
+	}
+
+	return v, nil
+}
@@ -0,0 +1,56 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+// announcements are notices posted in the chat after an action, by language
+// and action kind. The user name is substituted for %s.
+var announcements = map[string]map[e.ActionKind]string{
+	"en": {
+		e.ActionKindErase: "A message from %s was removed as spam.",
+		e.ActionKindMute:  "%s was muted for spam.",
+		e.ActionKindBan:   "%s was banned for spam.",
+	},
+	"ru": {
+		e.ActionKindErase: "Сообщение от %s удалено как спам.",
+		e.ActionKindMute:  "%s получает мут за спам.",
+		e.ActionKindBan:   "%s получает бан за спам.",
+	},
+}
+
+// announce posts a notice about the action in the chat if the chat asks for
+// it
+func (c *Client) announce(ctx context.Context, settings e.ChatSettings, tgMsg *tg.Message, act e.Action) error {
+	if settings.Announce != e.AnnounceNotice {
+		return nil
+	}
+
+	text, ok := formatAnnouncement(settings.Language, takeUserName(tgMsg.From), act.Kind)
+	if !ok {
+		return nil
+	}
+
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, text)
+}
+
+// formatAnnouncement returns the notice in the language, English if there
+// are no notices in it
+func formatAnnouncement(language, userName string, kind e.ActionKind) (string, bool) {
+	texts, ok := announcements[language]
+	if !ok {
+		texts = announcements["en"]
+	}
+
+	text, ok := texts[kind]
+	if !ok {
+		return "", false
+	}
+
+	return fmt.Sprintf(text, html.EscapeString(userName)), true
+}
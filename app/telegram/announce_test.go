@@ -0,0 +1,36 @@
+package telegram
+
+import (
+	"testing"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestFormatAnnouncement(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		user     string
+		kind     e.ActionKind
+		want     string
+		wantOK   bool
+	}{
+		{name: "english", language: "en", user: "bob", kind: e.ActionKindBan, want: "bob was banned for spam.", wantOK: true},
+		{name: "russian", language: "ru", user: "bob", kind: e.ActionKindErase, want: "Сообщение от bob удалено как спам.", wantOK: true},
+		{name: "unknown language falls back to english", language: "de", user: "bob", kind: e.ActionKindMute, want: "bob was muted for spam.", wantOK: true},
+		{name: "user name escaped", language: "en", user: "<b>x</b>", kind: e.ActionKindBan, want: "&lt;b&gt;x&lt;/b&gt; was banned for spam.", wantOK: true},
+		{name: "noop is not announced", language: "en", user: "bob", kind: e.ActionKindNoop},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := formatAnnouncement(tc.language, tc.user, tc.kind)
+			if ok != tc.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tc.wantOK)
+			}
+			if got != tc.want {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -15,10 +15,64 @@ import (
 	"nuclight.org/antispam-tg-bot/pkg/tg"
 )
 
+// muteDuration is how long a muted user can't send messages
+const muteDuration = 24 * time.Hour
+
 type MessageHandler interface {
 	HandleMessage(ctx context.Context, msg e.Message) (e.Action, error)
 }
 
+type TrustSeeder interface {
+	SeedTrusted(ctx context.Context, users []e.User) error
+}
+
+// DecisionStore looks up stored messages with their decision traces and
+// users' histories
+type DecisionStore interface {
+	GetMessage(ctx context.Context, chatID, messageID string) (msg e.SavedMessage, found bool, err error)
+	GetUserHistory(ctx context.Context, user e.User, limit int) (e.UserHistory, error)
+}
+
+// AuditLog records bans for the audit trail
+type AuditLog interface {
+	AppendAudit(ctx context.Context, entry e.AuditEntry) error
+}
+
+// BanRegistry records applied bans
+type BanRegistry interface {
+	AddBan(ctx context.Context, ban e.Ban) (int64, error)
+}
+
+// ChatRegistry records chats the bot joins
+type ChatRegistry interface {
+	UpdateChat(ctx context.Context, chat e.Chat) error
+}
+
+// UpdateArchive keeps raw updates for replay
+type UpdateArchive interface {
+	ArchiveUpdate(ctx context.Context, update e.RawUpdate) error
+}
+
+// UserForgetter erases users' data on request
+type UserForgetter interface {
+	ForgetUser(ctx context.Context, actor, chatID, userID string) (e.ErasureResult, error)
+}
+
+// ChatStatsStore answers the /stats command
+type ChatStatsStore interface {
+	GetChatStats(ctx context.Context, chatID string, from, to time.Time) (e.ChatStats, error)
+}
+
+// Reloader reloads the prompts and the settings of the running bot, returning
+// what is in effect
+type Reloader interface {
+	Reload(ctx context.Context) (string, error)
+}
+
+type ChatSettingsProvider interface {
+	GetChatSettings(ctx context.Context, chatID string) (e.ChatSettings, error)
+}
+
 type Client struct {
 	Log        logger.Logger
 	APIToken   string
@@ -26,9 +80,44 @@ type Client struct {
 	DevMode    bool
 	Handler    MessageHandler
 
+	// Settings provides per-chat settings, optional
+	Settings ChatSettingsProvider
+
+	// Seeder marks chat admins as trusted when the bot joins a chat, optional
+	Seeder TrustSeeder
+
+	// Decisions answers the /why and /score commands, optional
+	Decisions DecisionStore
+
+	// Audit records applied bans, optional
+	Audit AuditLog
+
+	// Bans keeps the registry of applied bans, optional
+	Bans BanRegistry
+
+	// Chats records the chats the bot joins, optional
+	Chats ChatRegistry
+
+	// Archive keeps raw updates of checked messages, optional
+	Archive UpdateArchive
+
+	// Privacy answers the /forgetme and /forget commands, optional
+	Privacy UserForgetter
+
+	// Stats answers the /stats command, optional
+	Stats ChatStatsStore
+
+	// Reloader answers the /reload command sent to OwnerChatID by its
+	// admins, or by the owner in a private chat, optional
+	Reloader    Reloader
+	OwnerChatID string
+
 	api         *tg.Client
 	updatesChan chan tg.Update
 	wg          sync.WaitGroup
+
+	mentionsMu sync.Mutex
+	mentions   map[string]resolvedMention
 }
 
 func (c *Client) Start(ctx context.Context) (err error) {
@@ -120,6 +209,10 @@ func (c *Client) handleUpdate(ctx context.Context, tgUpdate tg.Update) error {
 		}
 	}()
 
+	if tgUpdate.MyChatMember != nil {
+		return c.handleMyChatMember(ctx, tgUpdate.MyChatMember)
+	}
+
 	tgMsg := takeMessage(tgUpdate)
 	if tgMsg == nil {
 		log.Warn("message is nil")
@@ -143,6 +236,12 @@ func (c *Client) handleUpdate(ctx context.Context, tgUpdate tg.Update) error {
 
 	if tgMsg.Chat.IsPrivate() && !c.DevMode {
 		log.Info("message is private")
+		if tgMsg.IsCommand() && tgMsg.Command() == "forgetme" {
+			return c.handleForgetMe(ctx, tgMsg)
+		}
+		if tgMsg.IsCommand() && tgMsg.Command() == "reload" {
+			return c.handleReload(ctx, tgMsg)
+		}
 		err := c.replyPrivate(ctx, tgMsg)
 		if err != nil {
 			log.Error("replying to private message", "error", err)
@@ -163,30 +262,19 @@ func (c *Client) handleUpdate(ctx context.Context, tgUpdate tg.Update) error {
 	)
 
 	if tgMsg.IsCommand() {
-		// TODO: handle commands
 		log.Info("command received", "command", tgMsg.Command())
-		return nil
+		return c.handleCommand(ctx, tgMsg)
 	}
 
-	msg := e.Message{
-		Sender: e.User{
-			ID:        takeUserID(tgMsg.From),
-			Name:      takeUserName(tgMsg.From),
-			ChatID:    takeChatID(tgMsg.Chat),
-			ChatTitle: tgMsg.Chat.Title,
-		},
-		ID:   takeMessageID(tgMsg),
-		Text: takeText(tgMsg),
-	}
+	c.archiveUpdate(ctx, tgUpdate, tgMsg)
 
+	msg := toMessage(tgMsg)
 	if mi := getMediaInfo(tgMsg); mi != nil {
-		mimeType, fileID, size, err := c.getMediaMetadata(ctx, mi)
+		size, err := c.getMediaSize(ctx, mi)
 		if err != nil {
-			log.Error("getting media metadata", "error", err)
+			log.Warn("getting media size", "error", err)
 		} else {
-			msg.MediaType = mimeType
-			msg.MediaFileID = fileID
-			msg.MediaSize = size
+			msg.MediaSize = &size
 		}
 	}
 
@@ -196,15 +284,99 @@ func (c *Client) handleUpdate(ctx context.Context, tgUpdate tg.Update) error {
 	}
 
 	log.Info("message handled", "action", act.Kind, "note", act.Note)
+	if act.Kind == e.ActionKindNoop {
+		return nil
+	}
+
+	settings, err := c.chatSettings(ctx, msg.Sender.ChatID)
+	if err != nil {
+		return fmt.Errorf("getting chat settings: %w", err)
+	}
+
+	if settings.DryRun {
+		log.Info("dry run, action not applied", "action", act.Kind)
+		if err = c.reportToModLog(ctx, settings, msg, act); err != nil {
+			log.Error("reporting to mod log", "error", err)
+		}
+		return nil
+	}
+
+	if act.Delay > 0 {
+		c.wg.Add(1)
+		go c.applyDelayedAction(ctx, tgUpdate.UpdateID, settings, msg, tgMsg, act)
+		return nil
+	}
+
 	err = c.applyAction(ctx, tgUpdate.UpdateID, tgMsg, act)
 	if err != nil {
 		return fmt.Errorf("applying action: %w", err)
 	}
 
+	c.reportAction(ctx, tgUpdate.UpdateID, settings, msg, tgMsg, act)
+
 	return nil
 
 }
 
+// reportAction reports the applied action to the mod log and announces it in
+// the chat, as configured for the chat
+func (c *Client) reportAction(ctx context.Context, tgUpdateID int, settings e.ChatSettings, msg e.Message, tgMsg *tg.Message, act e.Action) {
+	log := c.Log.With("tg_update_id", tgUpdateID)
+
+	if err := c.reportToModLog(ctx, settings, msg, act); err != nil {
+		log.Error("reporting to mod log", "error", err)
+	}
+
+	if err := c.announce(ctx, settings, tgMsg, act); err != nil {
+		log.Error("announcing action", "error", err)
+	}
+}
+
+// chatSettings returns settings of the chat, zero ones if no provider is set
+func (c *Client) chatSettings(ctx context.Context, chatID string) (e.ChatSettings, error) {
+	if c.Settings == nil {
+		return e.ChatSettings{}, nil
+	}
+	return c.Settings.GetChatSettings(ctx, chatID)
+}
+
+// applyDelayedAction applies the action once its delay passes. The action is
+// dropped if the bot stops before that.
+func (c *Client) applyDelayedAction(ctx context.Context, tgUpdateID int, settings e.ChatSettings, msg e.Message, tgMsg *tg.Message, act e.Action) {
+	defer c.wg.Done()
+	log := c.Log.With("tg_update_id", tgUpdateID)
+
+	timer := time.NewTimer(act.Delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		log.Warn("dropping delayed action on shutdown", "action", act.Kind)
+		return
+	case <-timer.C:
+	}
+
+	if err := c.applyAction(ctx, tgUpdateID, tgMsg, act); err != nil {
+		log.Error("applying delayed action", "error", err)
+		return
+	}
+
+	c.reportAction(ctx, tgUpdateID, settings, msg, tgMsg, act)
+}
+
+// takeLinks returns URLs of text_link entities, which are not part of the text
+func takeLinks(msg *tg.Message) []string {
+	var links []string
+	for _, entities := range [][]tg.MessageEntity{msg.Entities, msg.CaptionEntities} {
+		for _, entity := range entities {
+			if entity.Type == "text_link" && entity.URL != "" {
+				links = append(links, entity.URL)
+			}
+		}
+	}
+	return links
+}
+
 func takeText(msg *tg.Message) string {
 	text := msg.Text
 	if text == "" {
@@ -261,6 +433,26 @@ func (c *Client) applyAction(ctx context.Context, tgUpdateID int, tgMsg *tg.Mess
 			return fmt.Errorf("banning user: %w", err)
 		}
 
+		if err := c.auditBan(ctx, tgMsg, act); err != nil {
+			log.Error("recording ban in audit log", "error", err)
+		}
+
+		if err := c.registerBan(ctx, tgMsg, act); err != nil {
+			log.Error("recording ban in ban registry", "error", err)
+		}
+
+		return nil
+	case e.ActionKindMute:
+		log.Info("erasing message")
+		if err := c.eraseMessage(ctx, tgMsg); err != nil {
+			return fmt.Errorf("erasing message: %w", err)
+		}
+
+		log.Info("muting user", "tg_user_id", tgMsg.From.ID, "tg_chat_id", tgMsg.Chat.ID, "duration", muteDuration)
+		if err := c.api.RestrictChatMember(ctx, tgMsg.Chat.ID, tgMsg.From.ID, time.Now().Add(muteDuration)); err != nil {
+			return fmt.Errorf("muting user: %w", err)
+		}
+
 		return nil
 
 	default:
@@ -269,6 +461,62 @@ func (c *Client) applyAction(ctx context.Context, tgUpdateID int, tgMsg *tg.Mess
 
 }
 
+// auditBan records the ban of the message sender in the audit log
+func (c *Client) auditBan(ctx context.Context, tgMsg *tg.Message, act e.Action) error {
+	if c.Audit == nil {
+		return nil
+	}
+
+	return c.Audit.AppendAudit(ctx, e.AuditEntry{
+		Kind:   e.AuditKindBan,
+		Actor:  e.AuditActorBot,
+		ChatID: takeChatID(tgMsg.Chat),
+		UserID: takeUserID(tgMsg.From),
+		Before: strconv.Itoa(act.ScoreBefore),
+		After:  strconv.Itoa(act.ScoreAfter),
+		Reason: act.Note,
+	})
+}
+
+// registerBan adds the permanent ban of the message sender to the registry
+func (c *Client) registerBan(ctx context.Context, tgMsg *tg.Message, act e.Action) error {
+	if c.Bans == nil {
+		return nil
+	}
+
+	reason := act.Note
+	if reason == "" && act.Category != "" {
+		reason = string(act.Category)
+	}
+
+	_, err := c.Bans.AddBan(ctx, e.Ban{
+		ChatID:   takeChatID(tgMsg.Chat),
+		UserID:   takeUserID(tgMsg.From),
+		Reason:   reason,
+		IssuedBy: e.AuditActorBot,
+	})
+	return err
+}
+
+// archiveUpdate stores the raw update of the message to be checked. A
+// failure is only logged, archiving is for debugging.
+func (c *Client) archiveUpdate(ctx context.Context, tgUpdate tg.Update, tgMsg *tg.Message) {
+	if c.Archive == nil || len(tgUpdate.Raw) == 0 {
+		return
+	}
+
+	err := c.Archive.ArchiveUpdate(ctx, e.RawUpdate{
+		UpdateID:  int64(tgUpdate.UpdateID),
+		ChatID:    takeChatID(tgMsg.Chat),
+		MessageID: takeMessageID(tgMsg),
+		UserID:    takeUserID(tgMsg.From),
+		Payload:   tgUpdate.Raw,
+	})
+	if err != nil {
+		c.Log.Error("archiving update", "tg_update_id", tgUpdate.UpdateID, "error", err)
+	}
+}
+
 func (c *Client) eraseMessage(ctx context.Context, tgMsg *tg.Message) error {
 	return c.api.DeleteMessage(ctx, tgMsg.Chat.ID, tgMsg.MessageID)
 }
@@ -303,6 +551,45 @@ func takeMessage(update tg.Update) *tg.Message {
 	return nil
 }
 
+// MessageFromUpdate returns the message of the update as the bot checks it,
+// false if the update has no message with a sender and a chat. The size of
+// media is known only if the update carries it.
+func MessageFromUpdate(update tg.Update) (e.Message, bool) {
+	tgMsg := takeMessage(update)
+	if tgMsg == nil || tgMsg.From == nil || tgMsg.Chat == nil {
+		return e.Message{}, false
+	}
+
+	msg := toMessage(tgMsg)
+	if mi := getMediaInfo(tgMsg); mi != nil && mi.size > 0 {
+		msg.MediaSize = &mi.size
+	}
+	return msg, true
+}
+
+// toMessage returns the message to check without the size of its media
+func toMessage(tgMsg *tg.Message) e.Message {
+	msg := e.Message{
+		Sender: e.User{
+			ID:        takeUserID(tgMsg.From),
+			Name:      takeUserName(tgMsg.From),
+			ChatID:    takeChatID(tgMsg.Chat),
+			ChatTitle: tgMsg.Chat.Title,
+		},
+		ID:        takeMessageID(tgMsg),
+		Text:      takeText(tgMsg),
+		IsForward: tgMsg.IsForward(),
+		Links:     takeLinks(tgMsg),
+		Mentions:  takeMentions(tgMsg),
+	}
+
+	if mi := getMediaInfo(tgMsg); mi != nil {
+		msg.MediaType = &mi.mimeType
+		msg.MediaFileID = &mi.fileID
+	}
+	return msg
+}
+
 func takeMessageID(message *tg.Message) string {
 	return strconv.Itoa(message.MessageID)
 }
@@ -352,22 +639,23 @@ func takeUserName(user *tg.User) string {
 type mediaInfo struct {
 	fileID   string
 	mimeType string
+	size     int64 // zero if the update doesn't carry it
 }
 
 func getMediaInfo(msg *tg.Message) *mediaInfo {
 	if len(msg.Photo) > 0 {
 		// Get largest photo (last in array)
 		photo := msg.Photo[len(msg.Photo)-1]
-		return &mediaInfo{fileID: photo.FileID, mimeType: "image/jpeg"}
+		return &mediaInfo{fileID: photo.FileID, mimeType: "image/jpeg", size: int64(photo.FileSize)}
 	}
 	if msg.Animation != nil {
-		return &mediaInfo{fileID: msg.Animation.FileID, mimeType: msg.Animation.MimeType}
+		return &mediaInfo{fileID: msg.Animation.FileID, mimeType: msg.Animation.MimeType, size: int64(msg.Animation.FileSize)}
 	}
 	if msg.Video != nil {
-		return &mediaInfo{fileID: msg.Video.FileID, mimeType: msg.Video.MimeType}
+		return &mediaInfo{fileID: msg.Video.FileID, mimeType: msg.Video.MimeType, size: int64(msg.Video.FileSize)}
 	}
 	if msg.Document != nil {
-		return &mediaInfo{fileID: msg.Document.FileID, mimeType: msg.Document.MimeType}
+		return &mediaInfo{fileID: msg.Document.FileID, mimeType: msg.Document.MimeType, size: int64(msg.Document.FileSize)}
 	}
 	if msg.Sticker != nil {
 		// Static stickers are real WEBP images. Animated (Lottie) stickers are
@@ -381,20 +669,24 @@ func getMediaInfo(msg *tg.Message) *mediaInfo {
 		case msg.Sticker.IsVideo:
 			mimeType = "video/webm"
 		}
-		return &mediaInfo{fileID: msg.Sticker.FileID, mimeType: mimeType}
+		return &mediaInfo{fileID: msg.Sticker.FileID, mimeType: mimeType, size: int64(msg.Sticker.FileSize)}
 	}
 	return nil
 }
 
-// getMediaMetadata returns metadata about the media without downloading content
-func (c *Client) getMediaMetadata(ctx context.Context, info *mediaInfo) (mimeType *string, fileID *string, size *int64, err error) {
+// getMediaSize returns the size of the media, asking the API without
+// downloading content only if the update doesn't carry it
+func (c *Client) getMediaSize(ctx context.Context, info *mediaInfo) (int64, error) {
+	if info.size > 0 {
+		return info.size, nil
+	}
+
 	file, err := c.api.GetFile(ctx, info.fileID)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("getting file info: %w", err)
+		return 0, fmt.Errorf("getting file info: %w", err)
 	}
 
-	fileSize := int64(file.FileSize)
-	return &info.mimeType, &info.fileID, &fileSize, nil
+	return int64(file.FileSize), nil
 }
 
 // DownloadFile downloads file content by file ID (on-demand)
@@ -0,0 +1,301 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+// handleCommand handles a bot command sent to a group
+func (c *Client) handleCommand(ctx context.Context, tgMsg *tg.Message) error {
+	switch tgMsg.Command() {
+	case "why":
+		return c.handleWhy(ctx, tgMsg)
+	case "score":
+		return c.handleScore(ctx, tgMsg)
+	case "forget":
+		return c.handleForget(ctx, tgMsg)
+	case "forgetme":
+		return c.handleForgetMe(ctx, tgMsg)
+	case "stats":
+		return c.handleStats(ctx, tgMsg)
+	case "reload":
+		return c.handleReload(ctx, tgMsg)
+	default:
+		c.Log.Info("unknown command", "command", tgMsg.Command())
+		return nil
+	}
+}
+
+// handleWhy replies to an admin with the decision trace of a message: the
+// one the command replies to, or the message ID given as an argument (for
+// messages already erased, e.g. taken from the mod log).
+func (c *Client) handleWhy(ctx context.Context, tgMsg *tg.Message) error {
+	if c.Decisions == nil {
+		return nil
+	}
+
+	isAdmin, err := c.isChatAdmin(ctx, tgMsg.Chat.ID, tgMsg.From.ID)
+	if err != nil {
+		return fmt.Errorf("checking admin rights: %w", err)
+	}
+	if !isAdmin {
+		return nil
+	}
+
+	var messageID string
+	switch {
+	case tgMsg.ReplyToMessage != nil:
+		messageID = strconv.Itoa(tgMsg.ReplyToMessage.MessageID)
+	case tgMsg.CommandArgs() != "":
+		if _, err = strconv.Atoi(tgMsg.CommandArgs()); err != nil {
+			return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Usage: reply to a message with /why or send /why &lt;message id&gt;")
+		}
+		messageID = tgMsg.CommandArgs()
+	default:
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Usage: reply to a message with /why or send /why &lt;message id&gt;")
+	}
+
+	msg, found, err := c.Decisions.GetMessage(ctx, takeChatID(tgMsg.Chat), messageID)
+	if err != nil {
+		return fmt.Errorf("getting message: %w", err)
+	}
+
+	if !found {
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID, fmt.Sprintf("No decision recorded for message %s: it was not checked (e.g. sent by a trusted user)", messageID))
+	}
+
+	text := formatTrace(msg)
+	if msg.Sender.ID != "" {
+		history, err := c.Decisions.GetUserHistory(ctx, msg.Sender, whyHistoryLimit)
+		if err != nil {
+			return fmt.Errorf("getting user history: %w", err)
+		}
+		text += formatRecentActions(history.Messages)
+	}
+
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, text)
+}
+
+// whyHistoryLimit is the number of the sender's recent decisions shown by
+// /why, scoreHistoryLimit is the number of messages and score changes shown
+// by /score
+const (
+	whyHistoryLimit   = 10
+	scoreHistoryLimit = 5
+)
+
+// handleScore replies to an admin with a user's score and recent history:
+// the sender of the message the command replies to, or the user ID given as
+// an argument
+func (c *Client) handleScore(ctx context.Context, tgMsg *tg.Message) error {
+	if c.Decisions == nil {
+		return nil
+	}
+
+	isAdmin, err := c.isChatAdmin(ctx, tgMsg.Chat.ID, tgMsg.From.ID)
+	if err != nil {
+		return fmt.Errorf("checking admin rights: %w", err)
+	}
+	if !isAdmin {
+		return nil
+	}
+
+	user := e.User{ChatID: takeChatID(tgMsg.Chat), ChatTitle: tgMsg.Chat.Title}
+	switch {
+	case tgMsg.ReplyToMessage != nil && tgMsg.ReplyToMessage.From != nil:
+		user.ID = takeUserID(tgMsg.ReplyToMessage.From)
+		user.Name = takeUserName(tgMsg.ReplyToMessage.From)
+	case tgMsg.CommandArgs() != "":
+		if _, err = strconv.ParseInt(tgMsg.CommandArgs(), 10, 64); err != nil {
+			return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Usage: reply to a message with /score or send /score &lt;user id&gt;")
+		}
+		user.ID = tgMsg.CommandArgs()
+	default:
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Usage: reply to a message with /score or send /score &lt;user id&gt;")
+	}
+
+	history, err := c.Decisions.GetUserHistory(ctx, user, scoreHistoryLimit)
+	if err != nil {
+		return fmt.Errorf("getting user history: %w", err)
+	}
+
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, formatHistory(history))
+}
+
+// handleReload reloads the prompts and the settings of the bot for its owner:
+// an admin of the owner chat, or the owner in a private chat with the bot if
+// it's the owner chat
+func (c *Client) handleReload(ctx context.Context, tgMsg *tg.Message) error {
+	if c.Reloader == nil || c.OwnerChatID == "" || takeChatID(tgMsg.Chat) != c.OwnerChatID {
+		return nil
+	}
+
+	if !tgMsg.Chat.IsPrivate() {
+		isAdmin, err := c.isChatAdmin(ctx, tgMsg.Chat.ID, tgMsg.From.ID)
+		if err != nil {
+			return fmt.Errorf("checking admin rights: %w", err)
+		}
+		if !isAdmin {
+			return nil
+		}
+	}
+
+	summary, err := c.Reloader.Reload(ctx)
+	if err != nil {
+		c.Log.Error("reloading", "error", err)
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Reload failed, the previous prompts and settings stay: "+html.EscapeString(err.Error()))
+	}
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Reloaded: "+html.EscapeString(summary))
+}
+
+func (c *Client) isChatAdmin(ctx context.Context, chatID, userID int64) (bool, error) {
+	admins, err := c.api.GetChatAdministrators(ctx, chatID)
+	if err != nil {
+		return false, err
+	}
+
+	for _, admin := range admins {
+		if admin.User != nil && admin.User.ID == userID {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func formatTrace(msg e.SavedMessage) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "<b>Message %s</b> from %s (<code>%s</code>)\n", msg.ID, html.EscapeString(msg.Sender.Name), msg.Sender.ID)
+
+	if msg.Error != nil {
+		fmt.Fprintf(&sb, "Error: %s\n", html.EscapeString(*msg.Error))
+	}
+
+	if msg.Action != nil {
+		fmt.Fprintf(&sb, "Action: %s\n", *msg.Action)
+	}
+
+	if msg.Category != nil {
+		fmt.Fprintf(&sb, "Category: %s\n", *msg.Category)
+	}
+
+	if msg.ActionNote != nil && *msg.ActionNote != "" {
+		fmt.Fprintf(&sb, "Note: %s\n", html.EscapeString(*msg.ActionNote))
+	}
+
+	t := msg.Trace
+	if t == nil {
+		return sb.String()
+	}
+
+	stage := string(t.Stage)
+	if t.Rule != "" {
+		stage += " " + t.Rule
+	}
+	if t.Cached {
+		stage += " (cached verdict)"
+	}
+	fmt.Fprintf(&sb, "Decided by: %s\n", html.EscapeString(stage))
+
+	if t.Model != "" {
+		fmt.Fprintf(&sb, "Model: %s\n", html.EscapeString(t.Model))
+	}
+	if t.PromptVersion != "" {
+		prompt := "<code>" + html.EscapeString(t.PromptVersion) + "</code>"
+		if t.Experiment != "" {
+			prompt += " (" + html.EscapeString(t.Experiment) + " of the experiment)"
+		}
+		if t.Language != "" {
+			prompt += ", language " + html.EscapeString(t.Language)
+		}
+		fmt.Fprintf(&sb, "Prompt: %s\n", prompt)
+	}
+	if t.Confidence != nil {
+		fmt.Fprintf(&sb, "Confidence: %.2f\n", *t.Confidence)
+	}
+
+	fmt.Fprintf(&sb, "Score: %d → %d\n", t.ScoreBefore, t.ScoreAfter)
+
+	return sb.String()
+}
+
+// formatRecentActions lists the actions taken on the messages, oldest first
+func formatRecentActions(messages []e.SavedMessage) string {
+	if len(messages) == 0 {
+		return ""
+	}
+
+	actions := make([]string, 0, len(messages))
+	for i := len(messages) - 1; i >= 0; i-- {
+		actions = append(actions, formatAction(messages[i]))
+	}
+
+	return "Recent decisions: " + strings.Join(actions, ", ") + "\n"
+}
+
+func formatHistory(h e.UserHistory) string {
+	var sb strings.Builder
+
+	name := h.User.ID
+	if h.User.Name != "" {
+		name = html.EscapeString(h.User.Name)
+	}
+	fmt.Fprintf(&sb, "<b>User %s</b> (<code>%s</code>)\n", name, h.User.ID)
+
+	if h.Score != nil {
+		fmt.Fprintf(&sb, "Score: %d\n", *h.Score)
+	} else {
+		sb.WriteString("Score: none, not checked yet\n")
+	}
+
+	if len(h.ScoreChanges) > 0 {
+		sb.WriteString("\n<b>Score changes</b>\n")
+		for _, change := range h.ScoreChanges {
+			fmt.Fprintf(&sb, "%s: %d → %d, %s\n",
+				change.At.Format(time.DateTime), change.Before, change.After, html.EscapeString(change.Reason))
+		}
+	}
+
+	if len(h.Messages) > 0 {
+		sb.WriteString("\n<b>Recent messages</b>\n")
+		for _, msg := range h.Messages {
+			fmt.Fprintf(&sb, "%s #%s %s: %s\n",
+				msg.CreatedAt.Format(time.DateTime), msg.ID, formatAction(msg), html.EscapeString(snippet(msg.Text)))
+		}
+	}
+
+	return sb.String()
+}
+
+// formatAction names the action taken on the message, or the error which
+// prevented the decision
+func formatAction(msg e.SavedMessage) string {
+	switch {
+	case msg.Action != nil:
+		return string(*msg.Action)
+	case msg.Error != nil:
+		return "error"
+	default:
+		return "pending"
+	}
+}
+
+// snippetLength is the number of characters of a message text shown in
+// lists
+const snippetLength = 50
+
+func snippet(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if runes := []rune(text); len(runes) > snippetLength {
+		return string(runes[:snippetLength]) + "…"
+	}
+	return text
+}
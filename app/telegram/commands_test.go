@@ -0,0 +1,99 @@
+package telegram
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestFormatTrace(t *testing.T) {
+	action := e.ActionKind(e.ActionKindErase)
+	note := "crypto <scam>"
+	confidence := 0.85
+	msg := e.SavedMessage{
+		ID:         "42",
+		Sender:     e.User{ID: "7", Name: "spammer"},
+		Action:     &action,
+		ActionNote: &note,
+		Trace: &e.Trace{
+			Stage:         e.DecisionStageAI,
+			Model:         "gpt-5-mini",
+			PromptVersion: "a1b2c3d4",
+			Confidence:    &confidence,
+			ScoreBefore:   1,
+			ScoreAfter:    0,
+		},
+	}
+
+	got := formatTrace(msg)
+	for _, want := range []string{
+		"<b>Message 42</b>",
+		"Action: erase",
+		"Note: crypto &lt;scam&gt;",
+		"Decided by: ai",
+		"Model: gpt-5-mini",
+		"Prompt: <code>a1b2c3d4</code>",
+		"Confidence: 0.85",
+		"Score: 1 → 0",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("trace %q lacks %q", got, want)
+		}
+	}
+}
+
+func TestFormatHistory(t *testing.T) {
+	score := 3
+	erase := e.ActionKind(e.ActionKindErase)
+	failure := "timeout"
+	at := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	history := e.UserHistory{
+		User:  e.User{ID: "7", Name: "<bob>"},
+		Score: &score,
+		Messages: []e.SavedMessage{
+			{ID: "43", Text: strings.Repeat("spam ", 20), CreatedAt: at, Action: &erase},
+			{ID: "42", Text: "hi", CreatedAt: at, Error: &failure},
+		},
+		ScoreChanges: []e.ScoreChange{{Before: 2, After: 3, Reason: "message passed the check", At: at}},
+	}
+
+	got := formatHistory(history)
+	for _, want := range []string{
+		"<b>User &lt;bob&gt;</b> (<code>7</code>)",
+		"Score: 3",
+		"2025-03-01 12:00:00: 2 → 3, message passed the check",
+		"#43 erase: spam spam",
+		"…",
+		"#42 error: hi",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("history %q lacks %q", got, want)
+		}
+	}
+
+	if got = formatRecentActions(history.Messages); got != "Recent decisions: error, erase\n" {
+		t.Errorf("formatRecentActions = %q", got)
+	}
+}
+
+func TestFormatStats(t *testing.T) {
+	got := formatStats(7, e.ChatStats{
+		Checked: 10,
+		Erased:  2,
+		Spend:   e.Spend{Requests: 4, PromptTokens: 4000, CompletionTokens: 200, Cost: 0.002},
+	})
+	for _, want := range []string{
+		"Stats for the last 7 days",
+		"Checked: 10",
+		"Erased: 2",
+		"Requests: 4",
+		"Tokens: 4000 prompt, 200 completion",
+		"Cost: $0.0020 ($0.000500 per request)",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("stats %q lack %q", got, want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package telegram
 
 import (
+	"encoding/json"
+	"slices"
 	"testing"
 
 	"nuclight.org/antispam-tg-bot/pkg/tg"
@@ -44,3 +46,75 @@ func TestGetMediaInfo_Sticker(t *testing.T) {
 		})
 	}
 }
+
+func TestGetMediaInfo_Size(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      *tg.Message
+		wantSize int64
+	}{
+		{
+			name:     "largest photo size",
+			msg:      &tg.Message{Photo: []tg.PhotoSize{{FileID: "small", FileSize: 100}, {FileID: "large", FileSize: 900}}},
+			wantSize: 900,
+		},
+		{name: "video", msg: &tg.Message{Video: &tg.Video{FileID: "v", FileSize: 2048}}, wantSize: 2048},
+		{name: "document without size", msg: &tg.Message{Document: &tg.Document{FileID: "d"}}, wantSize: 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mi := getMediaInfo(tc.msg)
+			if mi == nil {
+				t.Fatal("getMediaInfo returned nil")
+			}
+			if mi.size != tc.wantSize {
+				t.Errorf("size = %d, want %d", mi.size, tc.wantSize)
+			}
+		})
+	}
+}
+
+func TestMessageFromUpdate(t *testing.T) {
+	var update tg.Update
+	raw := `{"update_id":9,"message":{"message_id":5,"from":{"id":42,"first_name":"Ann"},` +
+		`"chat":{"id":-100,"type":"supergroup","title":"Chat"},"caption":"buy now",` +
+		`"photo":[{"file_id":"small","file_size":10},{"file_id":"large","file_size":90}]}}`
+	if err := json.Unmarshal([]byte(raw), &update); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, ok := MessageFromUpdate(update)
+	if !ok {
+		t.Fatal("MessageFromUpdate found no message")
+	}
+	if msg.ID != "5" || msg.Sender.ID != "42" || msg.Sender.ChatID != "-100" || msg.Sender.ChatTitle != "Chat" {
+		t.Errorf("message = %+v", msg)
+	}
+	if msg.Text != "buy now" {
+		t.Errorf("text = %q, want the caption", msg.Text)
+	}
+	if msg.MediaFileID == nil || *msg.MediaFileID != "large" || msg.MediaSize == nil || *msg.MediaSize != 90 {
+		t.Errorf("media = %v, %v, want the largest photo", msg.MediaFileID, msg.MediaSize)
+	}
+
+	if _, ok = MessageFromUpdate(tg.Update{UpdateID: 10}); ok {
+		t.Error("update without a message has one")
+	}
+}
+
+func TestTakeMentions(t *testing.T) {
+	// Offsets are in UTF-16 code units, the emoji takes two
+	msg := &tg.Message{
+		Text:     "😀 @bestdeals, mail john@example.com",
+		Entities: []tg.MessageEntity{{Type: "mention", Offset: 3, Length: 10}, {Type: "email", Offset: 20, Length: 16}},
+	}
+	if got := takeMentions(msg); !slices.Equal(got, []string{"bestdeals"}) {
+		t.Errorf("mentions = %q, want [bestdeals]", got)
+	}
+
+	msg = &tg.Message{Caption: "by @alice", CaptionEntities: []tg.MessageEntity{{Type: "mention", Offset: 3, Length: 6}, {Type: "mention", Offset: 8, Length: 10}}}
+	if got := takeMentions(msg); !slices.Equal(got, []string{"alice"}) {
+		t.Errorf("caption mentions = %q, want [alice] and the out of range entity skipped", got)
+	}
+}
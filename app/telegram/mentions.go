@@ -0,0 +1,104 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+const (
+	// mentionTTL is how long a resolved username is remembered
+	mentionTTL = 24 * time.Hour
+
+	// maxMentions bounds the resolved usernames remembered, the expired
+	// ones are dropped when it's reached
+	maxMentions = 10000
+)
+
+type resolvedMention struct {
+	chatID  string // empty if the username is not of a channel or a supergroup
+	expires time.Time
+}
+
+// ResolveChat returns the ID of the channel or the supergroup with the
+// username, without the @; found is false if the username is of a user, a
+// bot or another kind of chat. Answers are cached for a day, so a username
+// mentioned repeatedly is resolved once.
+func (c *Client) ResolveChat(ctx context.Context, username string) (string, bool, error) {
+	username = strings.ToLower(username)
+	now := time.Now()
+
+	c.mentionsMu.Lock()
+	resolved, ok := c.mentions[username]
+	c.mentionsMu.Unlock()
+	if ok && now.Before(resolved.expires) {
+		return resolved.chatID, resolved.chatID != "", nil
+	}
+
+	info, err := c.api.GetChatByUsername(ctx, username)
+	var apiErr *tg.APIError
+	switch {
+	case errors.As(err, &apiErr) && apiErr.Code == http.StatusBadRequest:
+		// Users and bots can't be looked up by username: "chat not found"
+		resolved = resolvedMention{}
+	case err != nil:
+		return "", false, fmt.Errorf("getting chat @%s: %w", username, err)
+	case info.Type == "channel" || info.Type == "supergroup":
+		resolved = resolvedMention{chatID: strconv.FormatInt(info.ID, 10)}
+	default:
+		resolved = resolvedMention{}
+	}
+	resolved.expires = now.Add(mentionTTL)
+
+	c.mentionsMu.Lock()
+	defer c.mentionsMu.Unlock()
+	if c.mentions == nil {
+		c.mentions = make(map[string]resolvedMention)
+	}
+	if len(c.mentions) >= maxMentions {
+		for name, m := range c.mentions {
+			if !now.Before(m.expires) {
+				delete(c.mentions, name)
+			}
+		}
+		if len(c.mentions) >= maxMentions {
+			clear(c.mentions)
+		}
+	}
+	c.mentions[username] = resolved
+
+	return resolved.chatID, resolved.chatID != "", nil
+}
+
+// takeMentions returns the usernames of mention entities, without the @
+func takeMentions(msg *tg.Message) []string {
+	var mentions []string
+	for _, part := range []struct {
+		text     string
+		entities []tg.MessageEntity
+	}{{msg.Text, msg.Entities}, {msg.Caption, msg.CaptionEntities}} {
+		var units []uint16
+		for _, entity := range part.entities {
+			if entity.Type != "mention" {
+				continue
+			}
+			if units == nil {
+				// Entity offsets are in UTF-16 code units
+				units = utf16.Encode([]rune(part.text))
+			}
+			if entity.Offset < 0 || entity.Length <= 0 || entity.Offset+entity.Length > len(units) {
+				continue
+			}
+			mention := string(utf16.Decode(units[entity.Offset : entity.Offset+entity.Length]))
+			mentions = append(mentions, strings.TrimPrefix(mention, "@"))
+		}
+	}
+	return mentions
+}
@@ -0,0 +1,81 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// maxModLogText bounds the quoted message text so the report stays within
+// Telegram's 4096 characters message limit.
+const maxModLogText = 3000
+
+// reportToModLog posts a report about the action taken on the message to the
+// chat's mod log, if one is configured.
+func (c *Client) reportToModLog(ctx context.Context, settings e.ChatSettings, msg e.Message, act e.Action) error {
+	if !settings.HasModLog() {
+		return nil
+	}
+
+	return c.SendMessage(ctx, settings.ModLogChatID, formatModLogReport(msg, act, settings.DryRun))
+}
+
+// SendMessage sends an HTML formatted message to a chat, or to a user via
+// private messages if chatID is a user ID
+func (c *Client) SendMessage(ctx context.Context, chatID string, text string) error {
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing chat id %q: %w", chatID, err)
+	}
+
+	return c.api.SendMessage(ctx, id, text)
+}
+
+// formatModLogReport formats the report, a dry run one tells the action was
+// not actually applied
+func formatModLogReport(msg e.Message, act e.Action, dryRun bool) string {
+	var sb strings.Builder
+
+	if dryRun {
+		sb.WriteString("[dry run] ")
+	}
+
+	verb := "Erased message"
+	switch act.Kind {
+	case e.ActionKindBan:
+		verb = "Banned user"
+	case e.ActionKindMute:
+		verb = "Muted user"
+	}
+
+	fmt.Fprintf(&sb, "<b>%s</b> in <b>%s</b>\n", verb, html.EscapeString(msg.Sender.ChatTitle))
+	fmt.Fprintf(&sb, "User: %s (<code>%s</code>)\n", html.EscapeString(msg.Sender.Name), msg.Sender.ID)
+	fmt.Fprintf(&sb, "Message: <code>%s</code>\n", msg.ID)
+	fmt.Fprintf(&sb, "Score: %d → %d\n", act.ScoreBefore, act.ScoreAfter)
+
+	if act.Category != "" {
+		fmt.Fprintf(&sb, "Category: %s\n", act.Category)
+	}
+
+	if act.Note != "" {
+		fmt.Fprintf(&sb, "Note: %s\n", html.EscapeString(act.Note))
+	}
+
+	if msg.HasMedia() {
+		fmt.Fprintf(&sb, "Media: %s\n", html.EscapeString(*msg.MediaType))
+	}
+
+	if msg.HasText() {
+		text := msg.Text
+		if r := []rune(text); len(r) > maxModLogText {
+			text = string(r[:maxModLogText]) + "…"
+		}
+		fmt.Fprintf(&sb, "<blockquote>%s</blockquote>", html.EscapeString(text))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,83 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+// forgetMeConfirmation is the argument of /forgetme that confirms erasure
+const forgetMeConfirmation = "confirm"
+
+// handleForgetMe erases the sender's data in all chats. It works in a
+// private chat only and asks for confirmation first.
+func (c *Client) handleForgetMe(ctx context.Context, tgMsg *tg.Message) error {
+	if c.Privacy == nil {
+		return nil
+	}
+
+	if !tgMsg.Chat.IsPrivate() {
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Send /forgetme to me in a private chat")
+	}
+
+	if tgMsg.CommandArgs() != forgetMeConfirmation {
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID,
+			"This erases the texts of your messages I stored in all groups and resets your reputation there, "+
+				"so your messages will be checked for spam again as a newcomer's.\n"+
+				"Send <code>/forgetme "+forgetMeConfirmation+"</code> to proceed.")
+	}
+
+	userID := takeUserID(tgMsg.From)
+	result, err := c.Privacy.ForgetUser(ctx, userID, "", userID)
+	if err != nil {
+		return fmt.Errorf("forgetting user: %w", err)
+	}
+
+	c.Log.Info("user data erased on request", "tg_user_id", tgMsg.From.ID, "messages", result.Messages)
+
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, formatErasure("Your data is erased", result))
+}
+
+// handleForget erases the data of a user in the chat on an admin's request:
+// the sender of the message the command replies to, or the user ID given as
+// an argument
+func (c *Client) handleForget(ctx context.Context, tgMsg *tg.Message) error {
+	if c.Privacy == nil {
+		return nil
+	}
+
+	isAdmin, err := c.isChatAdmin(ctx, tgMsg.Chat.ID, tgMsg.From.ID)
+	if err != nil {
+		return fmt.Errorf("checking admin rights: %w", err)
+	}
+	if !isAdmin {
+		return nil
+	}
+
+	var userID string
+	switch {
+	case tgMsg.ReplyToMessage != nil && tgMsg.ReplyToMessage.From != nil:
+		userID = takeUserID(tgMsg.ReplyToMessage.From)
+	case tgMsg.CommandArgs() != "":
+		if _, err = strconv.ParseInt(tgMsg.CommandArgs(), 10, 64); err != nil {
+			return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Usage: reply to a message with /forget or send /forget &lt;user id&gt;")
+		}
+		userID = tgMsg.CommandArgs()
+	default:
+		return c.api.SendMessage(ctx, tgMsg.Chat.ID, "Usage: reply to a message with /forget or send /forget &lt;user id&gt;")
+	}
+
+	result, err := c.Privacy.ForgetUser(ctx, takeUserID(tgMsg.From), takeChatID(tgMsg.Chat), userID)
+	if err != nil {
+		return fmt.Errorf("forgetting user: %w", err)
+	}
+
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, formatErasure("Data of user <code>"+userID+"</code> is erased in this chat", result))
+}
+
+func formatErasure(title string, result e.ErasureResult) string {
+	return fmt.Sprintf("%s: %d stored messages anonymized, reputation reset in %d chats.", title, result.Messages, result.Scores)
+}
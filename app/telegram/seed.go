@@ -0,0 +1,97 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+// handleMyChatMember handles changes of the bot's own membership. When the
+// bot is added to a chat (or re-added after being removed) the join is
+// recorded and the chat's admins are seeded as trusted, so an established
+// group doesn't get its staff checked for spam during the first days.
+func (c *Client) handleMyChatMember(ctx context.Context, update *tg.ChatMemberUpdated) error {
+	log := c.Log.With("tg_chat_id", update.Chat.ID, "tg_chat_title", update.Chat.Title)
+
+	joined := !update.OldChatMember.IsPresent() && update.NewChatMember.IsPresent()
+	if !joined || update.Chat.IsPrivate() {
+		return nil
+	}
+
+	if c.Chats != nil {
+		err := c.Chats.UpdateChat(ctx, e.Chat{
+			ID:       takeChatID(&update.Chat),
+			Title:    update.Chat.Title,
+			Type:     update.Chat.Type,
+			Language: update.From.LanguageCode,
+			JoinedAt: time.Unix(int64(update.Date), 0),
+		})
+		if err != nil {
+			log.Error("recording chat join", "error", err)
+		}
+	}
+
+	if c.Seeder == nil {
+		return nil
+	}
+
+	log.Info("bot joined chat, seeding admins as trusted")
+
+	admins, err := c.api.GetChatAdministrators(ctx, update.Chat.ID)
+	if err != nil {
+		return fmt.Errorf("getting chat administrators: %w", err)
+	}
+
+	users := make([]e.User, 0, len(admins))
+	for _, admin := range admins {
+		if admin.User == nil || admin.User.IsBot {
+			continue
+		}
+		users = append(users, e.User{
+			ID:        takeUserID(admin.User),
+			Name:      takeUserName(admin.User),
+			ChatID:    takeChatID(&update.Chat),
+			ChatTitle: update.Chat.Title,
+		})
+	}
+
+	if err = c.Seeder.SeedTrusted(ctx, users); err != nil {
+		return fmt.Errorf("seeding trusted users: %w", err)
+	}
+
+	log.Info("admins seeded as trusted", "count", len(users))
+
+	return nil
+}
+
+// FetchChat returns the chat with its metadata as currently known to
+// Telegram
+func (c *Client) FetchChat(ctx context.Context, chatID string) (e.Chat, error) {
+	id, err := strconv.ParseInt(chatID, 10, 64)
+	if err != nil {
+		return e.Chat{}, fmt.Errorf("parsing chat id %q: %w", chatID, err)
+	}
+
+	info, err := c.api.GetChat(ctx, id)
+	if err != nil {
+		return e.Chat{}, fmt.Errorf("getting chat: %w", err)
+	}
+
+	count, err := c.api.GetChatMemberCount(ctx, id)
+	if err != nil {
+		return e.Chat{}, fmt.Errorf("getting chat member count: %w", err)
+	}
+
+	return e.Chat{
+		ID:          chatID,
+		Title:       info.Title,
+		Type:        info.Type,
+		Username:    info.Username,
+		MemberCount: count,
+		RefreshedAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,79 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/tg"
+)
+
+const (
+	// defaultStatsDays is the period of /stats without an argument
+	defaultStatsDays = 7
+
+	// maxStatsDays bounds the period of /stats
+	maxStatsDays = 365
+)
+
+// handleStats replies to an admin with moderation counters and the AI spend
+// of the chat over the last days, 7 or the number given as an argument
+func (c *Client) handleStats(ctx context.Context, tgMsg *tg.Message) error {
+	if c.Stats == nil {
+		return nil
+	}
+
+	isAdmin, err := c.isChatAdmin(ctx, tgMsg.Chat.ID, tgMsg.From.ID)
+	if err != nil {
+		return fmt.Errorf("checking admin rights: %w", err)
+	}
+	if !isAdmin {
+		return nil
+	}
+
+	days := defaultStatsDays
+	if args := tgMsg.CommandArgs(); args != "" {
+		days, err = strconv.Atoi(args)
+		if err != nil || days < 1 || days > maxStatsDays {
+			return c.api.SendMessage(ctx, tgMsg.Chat.ID,
+				fmt.Sprintf("Usage: /stats or /stats &lt;days&gt;, up to %d days", maxStatsDays))
+		}
+	}
+
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, -days)
+	stats, err := c.Stats.GetChatStats(ctx, takeChatID(tgMsg.Chat), from, to)
+	if err != nil {
+		return fmt.Errorf("getting chat stats: %w", err)
+	}
+
+	return c.api.SendMessage(ctx, tgMsg.Chat.ID, formatStats(days, stats))
+}
+
+func formatStats(days int, stats e.ChatStats) string {
+	var sb strings.Builder
+
+	period := "the last day"
+	if days > 1 {
+		period = fmt.Sprintf("the last %d days", days)
+	}
+	fmt.Fprintf(&sb, "<b>Stats for %s</b>\n", period)
+	fmt.Fprintf(&sb, "Checked: %d\n", stats.Checked)
+	fmt.Fprintf(&sb, "Erased: %d\n", stats.Erased)
+	fmt.Fprintf(&sb, "Banned: %d\n", stats.Banned)
+	fmt.Fprintf(&sb, "Errors: %d\n", stats.Errors)
+
+	spend := stats.Spend
+	sb.WriteString("\n<b>AI spend</b>\n")
+	fmt.Fprintf(&sb, "Requests: %d\n", spend.Requests)
+	fmt.Fprintf(&sb, "Tokens: %d prompt, %d completion\n", spend.PromptTokens, spend.CompletionTokens)
+	fmt.Fprintf(&sb, "Cost: $%.4f", spend.Cost)
+	if spend.Requests > 0 {
+		fmt.Fprintf(&sb, " ($%.6f per request)", spend.Cost/float64(spend.Requests))
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,32 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// RemoveUnverified removes the user who didn't pass the challenge from the
+// chat. The user is kicked rather than banned, so they can join again and
+// get a new challenge.
+func (c *Client) RemoveUnverified(ctx context.Context, v e.Verification) error {
+	chatID, err := strconv.ParseInt(v.ChatID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing chat id %q: %w", v.ChatID, err)
+	}
+	userID, err := strconv.ParseInt(v.UserID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing user id %q: %w", v.UserID, err)
+	}
+
+	if err = c.banUser(ctx, userID, chatID); err != nil {
+		return fmt.Errorf("banning user: %w", err)
+	}
+	if err = c.api.UnbanChatMember(ctx, chatID, userID, true); err != nil {
+		return fmt.Errorf("unbanning user: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,13 @@
+// Command admin manages scores, blacklisted texts and chat settings and
+// lists recent actions, the same as `antispam admin`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/admin"
+)
+
+func main() {
+	admin.Run(os.Args)
+}
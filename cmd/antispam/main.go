@@ -0,0 +1,93 @@
+// Command antispam is the single binary of the bot and its tools: the first
+// argument names the command, run with the arguments after it, e.g.
+// `antispam stats --days 7`. Without a command, or with an option first, it
+// runs the bot, so deployments passing the bot its options or environment
+// keep working.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"nuclight.org/antispam-tg-bot/app/cli/admin"
+	"nuclight.org/antispam-tg-bot/app/cli/backup"
+	"nuclight.org/antispam-tg-bot/app/cli/bot"
+	"nuclight.org/antispam-tg-bot/app/cli/download"
+	"nuclight.org/antispam-tg-bot/app/cli/eval"
+	"nuclight.org/antispam-tg-bot/app/cli/export"
+	"nuclight.org/antispam-tg-bot/app/cli/importer"
+	"nuclight.org/antispam-tg-bot/app/cli/label"
+	"nuclight.org/antispam-tg-bot/app/cli/migrate"
+	"nuclight.org/antispam-tg-bot/app/cli/prune"
+	"nuclight.org/antispam-tg-bot/app/cli/replay"
+	"nuclight.org/antispam-tg-bot/app/cli/seed"
+	"nuclight.org/antispam-tg-bot/app/cli/stats"
+)
+
+type command struct {
+	name        string
+	alias       string // name of the separate binary the command was, if another
+	description string
+	run         func(args []string)
+}
+
+var commands = []command{
+	{name: "bot", description: "run the telegram bot", run: bot.Run},
+	{name: "download", description: "download media of stored messages for evaluation", run: download.Run},
+	{name: "eval", alias: "test", description: "check stored messages with prompts and models and report their accuracy", run: eval.Run},
+	{name: "replay", description: "check archived traffic again with the current configuration and report the decisions that differ", run: replay.Run},
+	{name: "stats", description: "print moderation statistics", run: stats.Run},
+	{name: "migrate", description: "apply, revert or show schema migrations", run: migrate.Run},
+	{name: "prune", description: "enforce a message retention policy once and vacuum the database", run: prune.Run},
+	{name: "backup", description: "back the database up or restore it", run: backup.Run},
+	{name: "export", description: "write decided messages as labeled examples or fine-tuning data", run: export.Run},
+	{name: "import", description: "add externally labeled examples to the ground truth", run: importer.Run},
+	{name: "label", description: "label stored messages in the terminal", run: label.Run},
+	{name: "seed", description: "trust members of a group from a chat history export", run: seed.Run},
+	{name: "admin", description: "manage scores, blacklisted texts and chat settings, list recent actions", run: admin.Run},
+}
+
+func main() {
+	name := filepath.Base(os.Args[0])
+	args := os.Args[1:]
+
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelp(args[0])) {
+		bot.Run(append([]string{name + " bot"}, args...))
+		return
+	}
+	if isHelp(args[0]) || args[0] == "help" {
+		usage(os.Stdout, name)
+		return
+	}
+
+	for _, c := range commands {
+		if args[0] == c.name || args[0] == c.alias {
+			c.run(append([]string{name + " " + c.name}, args[1:]...))
+			return
+		}
+	}
+
+	_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage(os.Stderr, name)
+	os.Exit(1)
+}
+
+func isHelp(arg string) bool {
+	return arg == "-h" || arg == "--help"
+}
+
+// usage lists the commands
+func usage(w io.Writer, name string) {
+	_, _ = fmt.Fprintf(w, "Usage:\n  %s [command] [OPTIONS]\n\n", name)
+	_, _ = fmt.Fprintln(w, "Commands, bot if none is given:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		_, _ = fmt.Fprintf(tw, "  %s\t%s\n", c.name, c.description)
+	}
+	_ = tw.Flush()
+	_, _ = fmt.Fprintf(w, "\nRun %s <command> --help for the options of a command.\n", name)
+}
@@ -0,0 +1,13 @@
+// Command backup backs the database up or restores it, the same as `antispam
+// backup`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/backup"
+)
+
+func main() {
+	backup.Run(os.Args)
+}
@@ -1,125 +1,12 @@
+// Command bot runs the telegram bot, the same as `antispam bot`.
 package main
 
 import (
-	"context"
-	"net/http"
 	"os"
-	"os/signal"
-	"strings"
-	"syscall"
-	"time"
 
-	"github.com/getsentry/sentry-go"
-	"github.com/jessevdk/go-flags"
-	"nuclight.org/antispam-tg-bot/app/services"
-	"nuclight.org/antispam-tg-bot/app/storage"
-	"nuclight.org/antispam-tg-bot/app/telegram"
-	"nuclight.org/antispam-tg-bot/pkg/ai"
-	"nuclight.org/antispam-tg-bot/pkg/logger"
-	"nuclight.org/antispam-tg-bot/pkg/media"
+	"nuclight.org/antispam-tg-bot/app/cli/bot"
 )
 
-var opts struct {
-	TelegramAPIToken   string `long:"telegram-api-token" env:"TELEGRAM_API_TOKEN" required:"true" description:"telegram api token"`
-	TelegramWorkersNum int    `long:"telegram-workers-num" env:"TELEGRAM_WORKERS_NUM" default:"5" description:"number of workers for telegram bot"`
-	DBPath             string `long:"db-path" env:"DB_PATH" required:"true" description:"path to the sqlite database file"`
-	OpenAIKey          string `long:"ai-key" env:"OPENAI_KEY" required:"true" description:"ai api key"`
-	SentryDSN          string `long:"sentry-dsn" env:"SENTRY_DSN" description:"sentry DSN for error monitoring (optional)"`
-	DevMode            bool   `long:"dev-mode" env:"DEV_MODE" description:"enable dev mode"`
-}
-
 func main() {
-	_, err := flags.Parse(&opts)
-	if err != nil {
-		os.Exit(1)
-	}
-
-	// Initialize Sentry first if DSN is provided
-	sentryEnabled := false
-	if opts.SentryDSN != "" {
-		env := "production"
-		if opts.DevMode {
-			env = "development"
-		}
-		err = sentry.Init(sentry.ClientOptions{
-			ServerName:  "antispam-tg-bot",
-			Dsn:         opts.SentryDSN,
-			Environment: env,
-			// Defense in depth: scrub the bot token from any event before it
-			// is sent, in case a new error path interpolates it.
-			BeforeSend: func(event *sentry.Event, _ *sentry.EventHint) *sentry.Event {
-				token := opts.TelegramAPIToken
-				if token == "" {
-					return event
-				}
-				event.Message = strings.ReplaceAll(event.Message, token, "<redacted>")
-				for i := range event.Exception {
-					event.Exception[i].Value = strings.ReplaceAll(event.Exception[i].Value, token, "<redacted>")
-				}
-				return event
-			},
-		})
-		if err == nil {
-			sentryEnabled = true
-			defer sentry.Flush(2 * time.Second)
-		}
-	}
-
-	// Create logger with Sentry integration if enabled
-	var log logger.Logger
-	if sentryEnabled {
-		log = logger.NewLoggerWithSentry()
-	} else {
-		log = logger.NewLogger()
-	}
-
-	log.Info("starting bot", "dev_mode", opts.DevMode, "sentry", sentryEnabled)
-
-	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
-	defer cancel()
-
-	db, err := storage.NewSQLite(ctx, opts.DBPath)
-	if err != nil {
-		log.Error("creating sqlite3 database", "error", err)
-		os.Exit(1)
-	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			log.Error("closing sqlite3 database", "error", err)
-		}
-	}()
-
-	openAIClient := ai.NewOpenAI(opts.OpenAIKey, http.DefaultClient)
-
-	moderatingSrv := &services.ModeratingSrv{
-		DefaultScore:   0,
-		TrustedScore:   6,
-		BanScore:       -2,
-		ScoreStore:     db,
-		MessagesStore:  db,
-		AI:             openAIClient,
-		MediaConverter: media.NewFFmpegExtractor(),
-	}
-
-	bot := &telegram.Client{
-		Log:        log,
-		APIToken:   opts.TelegramAPIToken,
-		WorkersNum: opts.TelegramWorkersNum,
-		DevMode:    opts.DevMode,
-		Handler:    moderatingSrv,
-	}
-	moderatingSrv.MediaDownloader = bot
-
-	err = bot.Start(ctx)
-	if err != nil {
-		log.Error("starting bot", "error", err)
-		os.Exit(1)
-	}
-
-	<-ctx.Done()
-	log.Info("stopping bot")
-
-	bot.Wait()
-
-	os.Exit(0)
+	bot.Run(os.Args)
 }
@@ -0,0 +1,13 @@
+// Command export writes decided messages as labeled examples or fine-tuning
+// data, the same as `antispam export`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/export"
+)
+
+func main() {
+	export.Run(os.Args)
+}
@@ -0,0 +1,13 @@
+// Command import adds externally labeled examples to the ground truth, the
+// same as `antispam import`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/importer"
+)
+
+func main() {
+	importer.Run(os.Args)
+}
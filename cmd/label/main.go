@@ -0,0 +1,13 @@
+// Command label labels stored messages in the terminal, the same as
+// `antispam label`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/label"
+)
+
+func main() {
+	label.Run(os.Args)
+}
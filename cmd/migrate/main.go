@@ -0,0 +1,13 @@
+// Command migrate applies, reverts or shows schema migrations, the same as
+// `antispam migrate`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/migrate"
+)
+
+func main() {
+	migrate.Run(os.Args)
+}
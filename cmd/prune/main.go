@@ -0,0 +1,13 @@
+// Command prune enforces a message retention policy once, the same as
+// `antispam prune`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/prune"
+)
+
+func main() {
+	prune.Run(os.Args)
+}
@@ -0,0 +1,13 @@
+// Command seed trusts members of a group from a chat history export, the
+// same as `antispam seed`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/seed"
+)
+
+func main() {
+	seed.Run(os.Args)
+}
@@ -0,0 +1,12 @@
+// Command stats prints moderation statistics, the same as `antispam stats`.
+package main
+
+import (
+	"os"
+
+	"nuclight.org/antispam-tg-bot/app/cli/stats"
+)
+
+func main() {
+	stats.Run(os.Args)
+}
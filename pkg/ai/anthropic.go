@@ -0,0 +1,322 @@
+package ai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	AnthropicModel   = "claude-haiku-4-5"
+	AnthropicBaseURL = "https://api.anthropic.com/v1"
+
+	anthropicVersion   = "2023-06-01"
+	anthropicMaxTokens = 1024
+)
+
+// Anthropic is the client of the Anthropic Messages API. Structured output is
+// requested as a forced call of a tool whose input schema is the response
+// format's schema. The system prompt is cached, so the checks sharing it
+// are charged less for it.
+type Anthropic struct {
+	apiKey      string
+	httpClient  HTTPClient
+	baseURL     string
+	model       string
+	visionModel string
+}
+
+func NewAnthropic(apiKey string, httpClient HTTPClient) *Anthropic {
+	return &Anthropic{
+		apiKey:      apiKey,
+		httpClient:  httpClient,
+		baseURL:     AnthropicBaseURL,
+		model:       AnthropicModel,
+		visionModel: AnthropicModel,
+	}
+}
+
+// configure applies the non-zero provider options
+func (c *Anthropic) configure(opts ProviderOptions) {
+	if opts.BaseURL != "" {
+		c.baseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	}
+	if opts.Model != "" {
+		c.model = opts.Model
+	}
+	if opts.VisionModel != "" {
+		c.visionModel = opts.VisionModel
+	}
+}
+
+func (c *Anthropic) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return c.getCompletion(ctx, system, user, nil, rf, result, callOptions(opts))
+}
+
+func (c *Anthropic) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return c.getCompletion(ctx, system, user, []ImageData{{Content: image, MimeType: mimeType}}, rf, result, callOptions(opts))
+}
+
+func (c *Anthropic) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+	return c.getCompletion(ctx, system, user, images, rf, result, callOptions(opts))
+}
+
+// GetEmbeddings is not supported, Anthropic has no embeddings API
+func (c *Anthropic) GetEmbeddings(context.Context, []string) ([][]float32, *Usage, error) {
+	return nil, nil, ErrEmbeddingsNotSupported
+}
+
+type anthropicContent struct {
+	Type   string                `json:"type"`
+	Text   string                `json:"text,omitempty"`
+	Source *anthropicImageSource `json:"source,omitempty"`
+
+	// ID, Name and Input are of tool_use blocks
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// ToolUseID and Content are of tool_result blocks
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   string `json:"content,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    Role               `json:"role"`
+	Content []anthropicContent `json:"content"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+// anthropicSystem is a block of the system prompt, a cached one is read from
+// the prompt cache by the requests starting with it
+type anthropicSystem struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model       string              `json:"model"`
+	MaxTokens   int                 `json:"max_tokens"`
+	Temperature *float64            `json:"temperature,omitempty"`
+	System      []anthropicSystem   `json:"system"`
+	Messages    []anthropicMessage  `json:"messages"`
+	Tools       []anthropicTool     `json:"tools"`
+	ToolChoice  anthropicToolChoice `json:"tool_choice"`
+}
+
+type anthropicToolChoice struct {
+	Type string `json:"type"`
+	Name string `json:"name,omitempty"`
+}
+
+type anthropicResponse struct {
+	Model      string             `json:"model"`
+	Content    []anthropicContent `json:"content"`
+	StopReason string             `json:"stop_reason"`
+	Usage      struct {
+		InputTokens              int `json:"input_tokens"`
+		CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+		CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+		OutputTokens             int `json:"output_tokens"`
+	} `json:"usage"`
+}
+
+// usage returns the usage of the response in the OpenAI form
+func (r anthropicResponse) usage() Usage {
+	// Input tokens don't count those written to or read from the cache,
+	// unlike OpenAI's
+	promptTokens := r.Usage.InputTokens + r.Usage.CacheCreationInputTokens + r.Usage.CacheReadInputTokens
+	return Usage{
+		PromptTokens:        promptTokens,
+		CompletionTokens:    r.Usage.OutputTokens,
+		TotalTokens:         promptTokens + r.Usage.OutputTokens,
+		PromptTokensDetails: PromptTokensDetails{CachedTokens: r.Usage.CacheReadInputTokens},
+		Model:               r.Model,
+	}
+}
+
+// reportTool returns the tool the model reports its result with, its input
+// schema is the response format's
+func reportTool(rf ResponseFormat) (anthropicTool, error) {
+	name, schema, err := rf.jsonSchema()
+	if err != nil {
+		return anthropicTool{}, err
+	}
+	return anthropicTool{Name: name, Description: "Report the result of the analysis", InputSchema: schema}, nil
+}
+
+// newRequest returns the request of a conversation starting with the user's
+// content. The system prompt is cached, as it is the same for all checks.
+func (c *Anthropic) newRequest(model, system string, content []anthropicContent, o CallOptions) anthropicRequest {
+	if o.Model != "" {
+		model = o.Model
+	}
+	maxTokens := anthropicMaxTokens
+	if o.MaxTokens > 0 {
+		maxTokens = o.MaxTokens
+	}
+
+	return anthropicRequest{
+		Model:       model,
+		MaxTokens:   maxTokens,
+		Temperature: o.Temperature,
+		System:      []anthropicSystem{{Type: "text", Text: system, CacheControl: &anthropicCacheControl{Type: "ephemeral"}}},
+		Messages:    []anthropicMessage{{Role: RoleUser, Content: content}},
+	}
+}
+
+func (c *Anthropic) getCompletion(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, o CallOptions) (*Usage, error) {
+	report, err := reportTool(rf)
+	if err != nil {
+		return nil, err
+	}
+
+	model := c.model
+	var content []anthropicContent
+	for _, image := range images {
+		model = c.visionModel
+		content = append(content, anthropicContent{
+			Type: "image",
+			Source: &anthropicImageSource{
+				Type:      "base64",
+				MediaType: image.MimeType,
+				Data:      base64.StdEncoding.EncodeToString(image.Content),
+			},
+		})
+	}
+	content = append(content, anthropicContent{Type: "text", Text: user})
+
+	request := c.newRequest(model, system, content, o)
+	request.Tools = []anthropicTool{report}
+	request.ToolChoice = anthropicToolChoice{Type: "tool", Name: report.Name}
+
+	response, err := c.postMessages(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := response.usage()
+	return &usage, decodeReport(response, report.Name, result)
+}
+
+// GetJSONCompletionWithTools is GetJSONCompletion letting the model call the
+// tools first, for up to maxToolRounds rounds. The model has to call a tool
+// in every round, the report tool when it's done. The usage sums all
+// requests.
+func (c *Anthropic) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	report, err := reportTool(rf)
+	if err != nil {
+		return nil, err
+	}
+
+	request := c.newRequest(c.model, system, []anthropicContent{{Type: "text", Text: user}}, callOptions(opts))
+	for _, t := range tools {
+		params, err := json.Marshal(t.params)
+		if err != nil {
+			return nil, fmt.Errorf("tool %s: %w", t.name, err)
+		}
+		request.Tools = append(request.Tools, anthropicTool{Name: t.name, Description: t.description, InputSchema: params})
+	}
+	request.Tools = append(request.Tools, report)
+
+	var total Usage
+	for round := 0; ; round++ {
+		request.ToolChoice = anthropicToolChoice{Type: "any"}
+		if round == maxToolRounds {
+			request.ToolChoice = anthropicToolChoice{Type: "tool", Name: report.Name}
+		}
+
+		response, err := c.postMessages(ctx, request)
+		if err != nil {
+			if round == 0 {
+				return nil, err
+			}
+			return &total, err
+		}
+		usage := response.usage()
+		total.PromptTokens += usage.PromptTokens
+		total.CompletionTokens += usage.CompletionTokens
+		total.TotalTokens += usage.TotalTokens
+		total.PromptTokensDetails.CachedTokens += usage.PromptTokensDetails.CachedTokens
+		total.Model = usage.Model
+
+		var (
+			results  []anthropicContent
+			reported bool
+		)
+		for _, block := range response.Content {
+			switch {
+			case block.Type != "tool_use":
+			case block.Name == report.Name:
+				reported = true
+			default:
+				call := ToolCall{ID: block.ID, Type: "function"}
+				call.Function.Name = block.Name
+				call.Function.Arguments = string(block.Input)
+				results = append(results, anthropicContent{Type: "tool_result", ToolUseID: block.ID, Content: callTool(ctx, tools, call)})
+			}
+		}
+		if reported || len(results) == 0 || round == maxToolRounds {
+			return &total, decodeReport(response, report.Name, result)
+		}
+
+		request.Messages = append(request.Messages,
+			anthropicMessage{Role: RoleAssistant, Content: response.Content},
+			anthropicMessage{Role: RoleUser, Content: results},
+		)
+	}
+}
+
+// postMessages sends the request to the Messages API
+func (c *Anthropic) postMessages(ctx context.Context, request anthropicRequest) (anthropicResponse, error) {
+	header := http.Header{
+		"X-Api-Key":         {c.apiKey},
+		"Anthropic-Version": {anthropicVersion},
+	}
+
+	var response anthropicResponse
+	err := postJSON(ctx, c.httpClient, c.baseURL+"/messages", header, request, &response)
+	return response, err
+}
+
+// decodeReport decodes the input of the call of the report tool of the name
+// into result
+func decodeReport(response anthropicResponse, name string, result any) error {
+	if response.StopReason != "tool_use" {
+		return fmt.Errorf("unexpected stop reason: %v", response.StopReason)
+	}
+
+	for _, block := range response.Content {
+		if block.Type != "tool_use" || block.Name != name {
+			continue
+		}
+		if err := json.Unmarshal(block.Input, result); err != nil {
+			return fmt.Errorf("%w: unmarshal response content: %w", ErrInvalidResponse, err)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w: no %s tool call in response", ErrInvalidResponse, name)
+}
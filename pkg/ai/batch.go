@@ -0,0 +1,435 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinBatch is the smallest run Batcher sends through the Batch
+	// API by default, smaller runs aren't worth waiting for a batch
+	DefaultMinBatch = 20
+
+	// DefaultBatchPollInterval is how often the status of a batch is checked
+	// by default
+	DefaultBatchPollInterval = 30 * time.Second
+)
+
+// BatchRequest is a completion request of a bulk run
+type BatchRequest struct {
+	// ID identifies the request among the run's, unique
+	ID string
+
+	System string
+	User   string
+
+	// Image is optional
+	Image *ImageData
+
+	Format ResponseFormat
+}
+
+// images returns the image of the request as a list, empty without one
+func (r BatchRequest) images() []ImageData {
+	if r.Image == nil {
+		return nil
+	}
+	return []ImageData{*r.Image}
+}
+
+// BatchResult is the outcome of a batch request
+type BatchResult struct {
+	ID string
+
+	// Content is the JSON the model answered with
+	Content json.RawMessage
+
+	// Usage is nil if the request didn't reach the model
+	Usage *Usage
+
+	// Latency is how long the request took when sent one by one, zero for
+	// the requests of a batch
+	Latency time.Duration
+
+	Err error
+}
+
+// Decode decodes the content into result, returning the request's error if
+// it failed
+func (r BatchResult) Decode(result any) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	return json.Unmarshal(r.Content, result)
+}
+
+// Batcher runs bulk completion requests, such as re-evaluations of stored
+// messages. Runs of at least MinBatch requests go through the OpenAI Batch
+// API, at about half the price and outside the rate limits, at the cost of
+// waiting up to a day. Smaller runs, and all of them without Batch, are sent
+// to Provider one by one.
+type Batcher struct {
+	Provider Provider
+
+	// Batch is the client of the Batch API, optional
+	Batch *OpenAI
+
+	// MinBatch is the smallest run sent as a batch, zero means
+	// DefaultMinBatch
+	MinBatch int
+
+	// PollInterval is how often the status of a batch is checked, zero
+	// means DefaultBatchPollInterval
+	PollInterval time.Duration
+
+	// Concurrency is the number of requests sent to Provider at once, zero
+	// means one
+	Concurrency int
+
+	// OnResult, if set, is called with the index and the result of each
+	// request as soon as it's done, e.g. to save the results of a long run as
+	// it goes. Requests sent one by one call it from several goroutines at
+	// once.
+	OnResult func(i int, result BatchResult)
+}
+
+// Run returns the results of the requests in their order. The error is
+// about the run as a whole, failures of single requests are in their
+// results.
+func (b Batcher) Run(ctx context.Context, requests []BatchRequest) ([]BatchResult, error) {
+	minBatch := b.MinBatch
+	if minBatch <= 0 {
+		minBatch = DefaultMinBatch
+	}
+	if b.Batch != nil && len(requests) >= minBatch {
+		poll := b.PollInterval
+		if poll <= 0 {
+			poll = DefaultBatchPollInterval
+		}
+		results, err := b.Batch.RunBatch(ctx, requests, poll)
+		if b.OnResult != nil {
+			for i, result := range results {
+				if result.ID != "" {
+					b.OnResult(i, result)
+				}
+			}
+		}
+		return results, err
+	}
+
+	return b.runSync(ctx, requests)
+}
+
+func (b Batcher) runSync(ctx context.Context, requests []BatchRequest) ([]BatchResult, error) {
+	results := make([]BatchResult, len(requests))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	for range max(b.Concurrency, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = b.runOne(ctx, requests[i])
+				if b.OnResult != nil {
+					b.OnResult(i, results[i])
+				}
+			}
+		}()
+	}
+
+	for i := range requests {
+		if ctx.Err() != nil {
+			break
+		}
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+func (b Batcher) runOne(ctx context.Context, r BatchRequest) BatchResult {
+	result := BatchResult{ID: r.ID}
+	start := time.Now()
+	if r.Image != nil {
+		result.Usage, result.Err = b.Provider.GetJSONCompletionWithImage(ctx, r.System, r.User, r.Image.Content, r.Image.MimeType, r.Format, &result.Content)
+	} else {
+		result.Usage, result.Err = b.Provider.GetJSONCompletion(ctx, r.System, r.User, r.Format, &result.Content)
+	}
+	result.Latency = time.Since(start)
+	return result
+}
+
+// batchLine is a line of a batch input file
+type batchLine struct {
+	CustomID string  `json:"custom_id"`
+	Method   string  `json:"method"`
+	URL      string  `json:"url"`
+	Body     Request `json:"body"`
+}
+
+// batchOutputLine is a line of a batch output or error file
+type batchOutputLine struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type openAIBatch struct {
+	ID           string `json:"id"`
+	Status       string `json:"status"`
+	OutputFileID string `json:"output_file_id"`
+	ErrorFileID  string `json:"error_file_id"`
+	Errors       *struct {
+		Data []struct {
+			Message string `json:"message"`
+		} `json:"data"`
+	} `json:"errors"`
+}
+
+// RunBatch runs the requests as a batch of the Batch API and waits for it,
+// checking its status every poll interval. The results are in the order of
+// the requests. The batch is canceled if the context is done first.
+func (c *OpenAI) RunBatch(ctx context.Context, requests []BatchRequest, poll time.Duration) ([]BatchResult, error) {
+	var input bytes.Buffer
+	enc := json.NewEncoder(&input)
+	for _, r := range requests {
+		model := c.model
+		if r.Image != nil {
+			model = c.visionModel
+		}
+		line := batchLine{
+			CustomID: r.ID,
+			Method:   http.MethodPost,
+			URL:      "/v1/chat/completions",
+			Body:     completionRequest(model, r.System, r.User, r.images(), r.Format),
+		}
+		if err := enc.Encode(line); err != nil {
+			return nil, fmt.Errorf("encoding request %s: %w", r.ID, err)
+		}
+	}
+
+	fileID, err := c.uploadBatchFile(ctx, input.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("uploading batch input: %w", err)
+	}
+
+	var batch openAIBatch
+	err = c.batchCall(ctx, http.MethodPost, "batches", map[string]string{
+		"input_file_id":     fileID,
+		"endpoint":          "/v1/chat/completions",
+		"completion_window": "24h",
+	}, &batch)
+	if err != nil {
+		return nil, fmt.Errorf("creating batch: %w", err)
+	}
+
+	for !batchDone(batch.Status) {
+		if err = sleep(ctx, poll); err != nil {
+			c.cancelBatch(ctx, batch.ID)
+			return nil, err
+		}
+		if err = c.batchCall(ctx, http.MethodGet, "batches/"+batch.ID, nil, &batch); err != nil {
+			return nil, fmt.Errorf("getting batch %s: %w", batch.ID, err)
+		}
+	}
+
+	if batch.Status == "failed" {
+		return nil, fmt.Errorf("batch %s failed: %s", batch.ID, batch.errorMessage())
+	}
+
+	// An expired or canceled batch has the results of the requests done
+	// before
+	byID := make(map[string]BatchResult, len(requests))
+	for _, fileID := range []string{batch.OutputFileID, batch.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		if err = c.readBatchOutput(ctx, fileID, byID); err != nil {
+			return nil, fmt.Errorf("reading batch %s output: %w", batch.ID, err)
+		}
+	}
+
+	results := make([]BatchResult, len(requests))
+	for i, r := range requests {
+		result, ok := byID[r.ID]
+		if !ok {
+			result = BatchResult{ID: r.ID, Err: fmt.Errorf("not done, the batch is %s", batch.Status)}
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+func batchDone(status string) bool {
+	switch status {
+	case "completed", "failed", "expired", "cancelled":
+		return true
+	}
+	return false
+}
+
+func (b openAIBatch) errorMessage() string {
+	if b.Errors == nil || len(b.Errors.Data) == 0 {
+		return "no details"
+	}
+	return b.Errors.Data[0].Message
+}
+
+func (c *OpenAI) uploadBatchFile(ctx context.Context, data []byte) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	if err := w.WriteField("purpose", "batch"); err != nil {
+		return "", err
+	}
+	part, err := w.CreateFormFile("file", "batch.jsonl")
+	if err != nil {
+		return "", err
+	}
+	if _, err = part.Write(data); err != nil {
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
+
+	res, err := c.batchRequest(ctx, http.MethodPost, "files", &body, w.FormDataContentType())
+	if err != nil {
+		return "", err
+	}
+
+	var file struct {
+		ID string `json:"id"`
+	}
+	if err = json.Unmarshal(res, &file); err != nil {
+		return "", fmt.Errorf("decoding file: %w", err)
+	}
+	return file.ID, nil
+}
+
+// readBatchOutput adds the results of a batch output or error file to
+// results by request ID
+func (c *OpenAI) readBatchOutput(ctx context.Context, fileID string, results map[string]BatchResult) error {
+	data, err := c.batchRequest(ctx, http.MethodGet, "files/"+fileID+"/content", nil, "")
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		var line batchOutputLine
+		if err = json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			return fmt.Errorf("decoding line: %w", err)
+		}
+
+		result := BatchResult{ID: line.CustomID}
+		switch {
+		case line.Error != nil:
+			result.Err = fmt.Errorf("%s: %s", line.Error.Code, line.Error.Message)
+		case line.Response == nil:
+			result.Err = errors.New("no response")
+		case line.Response.StatusCode != http.StatusOK:
+			result.Err = fmt.Errorf("unexpected status code: %d: %s", line.Response.StatusCode, line.Response.Body)
+		default:
+			var response Response
+			if err = json.Unmarshal(line.Response.Body, &response); err != nil {
+				result.Err = fmt.Errorf("failed to decode response: %w", err)
+				break
+			}
+			result.Usage, result.Err = decodeCompletion(response, &result.Content)
+			result.Usage.Batch = true
+		}
+		results[line.CustomID] = result
+	}
+
+	return scanner.Err()
+}
+
+// cancelBatch cancels the batch on a best effort basis, after the context is
+// done
+func (c *OpenAI) cancelBatch(ctx context.Context, id string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	_ = c.batchCall(ctx, http.MethodPost, "batches/"+id+"/cancel", nil, &openAIBatch{})
+}
+
+// batchCall sends the request as JSON, if any, and decodes the JSON response
+func (c *OpenAI) batchCall(ctx context.Context, method, path string, request, response any) error {
+	var (
+		body        io.Reader
+		contentType string
+	)
+	if request != nil {
+		data, err := json.Marshal(request)
+		if err != nil {
+			return fmt.Errorf("marshaling body: %w", err)
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+
+	data, err := c.batchRequest(ctx, method, path, body, contentType)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(data, response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// batchRequest sends a request to the API path and returns the body of a
+// successful response
+func (c *OpenAI) batchRequest(ctx context.Context, method, path string, body io.Reader, contentType string) ([]byte, error) {
+	endpoint, err := c.endpoint(path)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header = c.header()
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doing request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, data)
+	}
+
+	return data, nil
+}
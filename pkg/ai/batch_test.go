@@ -0,0 +1,120 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatcher_Batch(t *testing.T) {
+	var (
+		input string
+		polls int
+	)
+	completion := func(content string) string {
+		body, _ := json.Marshal(map[string]any{
+			"model":   "gpt-5-mini",
+			"choices": []any{map[string]any{"finish_reason": "stop", "message": map[string]any{"content": content}}},
+			"usage":   map[string]any{"prompt_tokens": 1000, "completion_tokens": 100, "total_tokens": 1100},
+		})
+		return string(body)
+	}
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/files":
+			file, _, err := r.FormFile("file")
+			if err != nil || r.FormValue("purpose") != "batch" {
+				return jsonResponse(400, `{}`), nil
+			}
+			data, _ := io.ReadAll(file)
+			input = string(data)
+			return jsonResponse(200, `{"id": "file-in"}`), nil
+		case "POST /v1/batches":
+			return jsonResponse(200, `{"id": "batch-1", "status": "validating"}`), nil
+		case "GET /v1/batches/batch-1":
+			polls++
+			if polls < 2 {
+				return jsonResponse(200, `{"id": "batch-1", "status": "in_progress"}`), nil
+			}
+			return jsonResponse(200, `{"id": "batch-1", "status": "completed", "output_file_id": "file-out", "error_file_id": "file-err"}`), nil
+		case "GET /v1/files/file-out/content":
+			return jsonResponse(200, `{"custom_id": "b", "response": {"status_code": 200, "body": `+completion(`{"is_spam": true}`)+`}}
+{"custom_id": "a", "response": {"status_code": 200, "body": `+completion(`{"is_spam": false}`)+`}}
+`), nil
+		case "GET /v1/files/file-err/content":
+			return jsonResponse(200, `{"custom_id": "c", "response": {"status_code": 400, "body": {"error": {"message": "bad image"}}}}`), nil
+		}
+		return jsonResponse(404, `{}`), nil
+	}), OpenAIOptions{})
+
+	batcher := Batcher{Batch: client, MinBatch: 2, PollInterval: time.Millisecond}
+	results, err := batcher.Run(context.Background(), []BatchRequest{
+		{ID: "a", System: "sys", User: "hello", Format: SpamCheckFormat},
+		{ID: "b", System: "sys", User: "buy now", Format: SpamCheckFormat},
+		{ID: "c", System: "sys", User: "look", Image: &ImageData{Content: []byte("img"), MimeType: "image/png"}, Format: SpamCheckFormat},
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if lines := strings.Split(strings.TrimSpace(input), "\n"); len(lines) != 3 || !strings.Contains(lines[0], `"custom_id":"a"`) ||
+		!strings.Contains(lines[0], `"url":"/v1/chat/completions"`) || !strings.Contains(lines[2], "data:image/png;base64") {
+		t.Errorf("input = %s, want a line per request", input)
+	}
+
+	var check SpamCheck
+	if len(results) != 3 || results[0].ID != "a" || results[0].Decode(&check) != nil || check.IsSpam {
+		t.Fatalf("results = %+v, want them in the order of the requests", results)
+	}
+	if err = results[1].Decode(&check); err != nil || !check.IsSpam {
+		t.Errorf("result b = %+v, %v, want spam", check, err)
+	}
+	if results[2].Err == nil {
+		t.Error("failed request has no error")
+	}
+
+	cost, _ := results[0].Usage.Cost()
+	full, _ := Usage{PromptTokens: 1000, CompletionTokens: 100, Model: "gpt-5-mini"}.Cost()
+	if !results[0].Usage.Batch || cost != full/2 {
+		t.Errorf("cost = %v, want half of %v", cost, full)
+	}
+}
+
+func TestBatcher_SmallRun(t *testing.T) {
+	var calls int
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("request to %s, want a completion", r.URL.Path)
+		}
+		return jsonResponse(200, `{"model": "gpt-5-mini", "choices": [{"finish_reason": "stop", "message": {"content": "{\"is_spam\": true}"}}]}`), nil
+	}), OpenAIOptions{})
+
+	var mu sync.Mutex
+	done := make(map[int]string)
+	batcher := Batcher{Provider: client, Batch: client, MinBatch: 5, OnResult: func(i int, result BatchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		done[i] = result.ID
+	}}
+	results, err := batcher.Run(context.Background(), []BatchRequest{{ID: "a", User: "hi", Format: SpamCheckFormat}, {ID: "b", User: "hey", Format: SpamCheckFormat}})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	var check SpamCheck
+	if calls != 2 || len(results) != 2 || results[1].ID != "b" || results[1].Decode(&check) != nil || !check.IsSpam {
+		t.Errorf("results = %+v after %d calls, want both checked one by one", results, calls)
+	}
+	if len(done) != 2 || done[0] != "a" || done[1] != "b" {
+		t.Errorf("OnResult got %v, want both results", done)
+	}
+	if results[0].Latency <= 0 {
+		t.Errorf("latency = %v, want the time the request took", results[0].Latency)
+	}
+}
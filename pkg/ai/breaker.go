@@ -0,0 +1,199 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without calling a provider that kept failing
+// until its circuit breaker lets a request through again
+var ErrCircuitOpen = errors.New("ai provider is failing, circuit breaker open")
+
+// CircuitBreaker stops calling a provider that keeps failing, so an outage
+// fails requests at once instead of each waiting for its retries. After the
+// cooldown one request is let through to probe the provider: its success
+// closes the circuit, its failure opens it for another cooldown.
+type CircuitBreaker struct {
+	// Failures in a row open the circuit, zero disables the breaker
+	Failures int
+
+	// Cooldown is how long an open circuit refuses requests
+	Cooldown time.Duration
+
+	// OnChange is called when the circuit opens or closes, optional
+	OnChange func(open bool)
+}
+
+// WithCircuitBreaker returns the provider behind the circuit breaker, a
+// breaker without failures returns it as is. Errors of the caller, such as
+// a canceled context or a used up budget, don't count as failures.
+func WithCircuitBreaker(p Provider, cb CircuitBreaker) Provider {
+	if cb.Failures <= 0 {
+		return p
+	}
+	return &breakerProvider{Provider: p, breaker: &breaker{config: cb, now: time.Now}}
+}
+
+type breaker struct {
+	config CircuitBreaker
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+
+	now func() time.Time
+}
+
+// allow reports whether a request may be sent
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.config.Failures {
+		return nil
+	}
+	if b.probing || b.now().Sub(b.openedAt) < b.config.Cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of a request allowed
+func (b *breaker) record(err error) {
+	if !counts(err) {
+		b.mu.Lock()
+		b.probing = false
+		b.mu.Unlock()
+		return
+	}
+
+	b.mu.Lock()
+	wasOpen := b.failures >= b.config.Failures
+	b.probing = false
+	if err == nil {
+		b.failures = 0
+	} else {
+		b.failures++
+		if b.failures >= b.config.Failures {
+			b.openedAt = b.now()
+		}
+	}
+	isOpen := b.failures >= b.config.Failures
+	b.mu.Unlock()
+
+	if wasOpen != isOpen && b.config.OnChange != nil {
+		b.config.OnChange(isOpen)
+	}
+}
+
+// counts reports whether the outcome of a request counts: nil as a success
+// and errors of the provider as failures, unlike those of the request
+func counts(err error) bool {
+	var imageErr *UnsupportedImageError
+	return !errors.Is(err, context.Canceled) &&
+		!refused(err) &&
+		!errors.Is(err, ErrToolsNotSupported) &&
+		!errors.Is(err, ErrStreamingNotSupported) &&
+		!errors.Is(err, ErrTranscriptionNotSupported) &&
+		!errors.As(err, &imageErr)
+}
+
+// refused reports whether the request was refused before it was sent, for
+// reasons no provider would accept it for
+func refused(err error) bool {
+	return errors.Is(err, ErrBudgetExceeded) ||
+		errors.Is(err, ErrAudioTooLarge) ||
+		errors.Is(err, ErrAudioTooLong) ||
+		errors.Is(err, ErrNoImages)
+}
+
+type breakerProvider struct {
+	Provider
+	breaker *breaker
+}
+
+func (p *breakerProvider) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletion(ctx, system, user, rf, result, opts...)
+	p.breaker.record(err)
+	return usage, err
+}
+
+func (p *breakerProvider) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletionWithImage(ctx, system, user, image, mimeType, rf, result, opts...)
+	p.breaker.record(err)
+	return usage, err
+}
+
+func (p *breakerProvider) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletionWithImages(ctx, system, user, images, rf, result, opts...)
+	p.breaker.record(err)
+	return usage, err
+}
+
+func (p *breakerProvider) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	tc, ok := p.Provider.(ToolCaller)
+	if !ok {
+		return nil, ErrToolsNotSupported
+	}
+	if err := p.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	usage, err := tc.GetJSONCompletionWithTools(ctx, system, user, tools, rf, result, opts...)
+	p.breaker.record(err)
+	return usage, err
+}
+
+func (p *breakerProvider) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	streamer, ok := p.Provider.(Streamer)
+	if !ok {
+		return "", nil, ErrStreamingNotSupported
+	}
+	if err := p.breaker.allow(); err != nil {
+		return "", nil, err
+	}
+
+	text, usage, err := streamer.StreamCompletion(ctx, system, user, onDelta)
+	p.breaker.record(err)
+	return text, usage, err
+}
+
+func (p *breakerProvider) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	transcriber, ok := p.Provider.(Transcriber)
+	if !ok {
+		return "", nil, ErrTranscriptionNotSupported
+	}
+	if err := p.breaker.allow(); err != nil {
+		return "", nil, err
+	}
+
+	text, usage, err := transcriber.Transcribe(ctx, audio, opts)
+	p.breaker.record(err)
+	return text, usage, err
+}
+
+func (p *breakerProvider) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	if err := p.breaker.allow(); err != nil {
+		return nil, nil, err
+	}
+
+	vectors, usage, err := p.Provider.GetEmbeddings(ctx, texts)
+	p.breaker.record(err)
+	return vectors, usage, err
+}
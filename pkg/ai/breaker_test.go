@@ -0,0 +1,77 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestWithCircuitBreaker(t *testing.T) {
+	var calls int
+	failing := true
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if err := r.Context().Err(); err != nil {
+			return nil, err
+		}
+		calls++
+		if failing {
+			return jsonResponse(http.StatusInternalServerError, `{"error": "down"}`), nil
+		}
+		return jsonResponse(http.StatusOK, spamCompletion), nil
+	}), OpenAIOptions{})
+
+	var changes []bool
+	p := WithCircuitBreaker(client, CircuitBreaker{
+		Failures: 2,
+		Cooldown: time.Minute,
+		OnChange: func(open bool) { changes = append(changes, open) },
+	})
+	b := p.(*breakerProvider).breaker
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	b.now = func() time.Time { return now }
+
+	complete := func(ctx context.Context) error {
+		var result SpamCheck
+		_, err := p.GetJSONCompletion(ctx, "sys", "user", SpamCheckFormat, &result)
+		return err
+	}
+
+	// Requests the caller gave up on don't count
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range 3 {
+		if err := complete(ctx); !errors.Is(err, context.Canceled) {
+			t.Fatalf("GetJSONCompletion = %v, want context.Canceled", err)
+		}
+	}
+
+	for range 2 {
+		_ = complete(context.Background())
+	}
+	if err := complete(context.Background()); !errors.Is(err, ErrCircuitOpen) || calls != 2 {
+		t.Fatalf("GetJSONCompletion = %v after %d calls, want the circuit open after 2 failures", err, calls)
+	}
+
+	// After the cooldown a failed probe opens it again
+	now = now.Add(time.Minute)
+	if err := complete(context.Background()); errors.Is(err, ErrCircuitOpen) || calls != 3 {
+		t.Fatalf("GetJSONCompletion = %v after %d calls, want a probe", err, calls)
+	}
+	if err := complete(context.Background()); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("GetJSONCompletion = %v, want the circuit open again", err)
+	}
+
+	// And a successful one closes it
+	now = now.Add(time.Minute)
+	failing = false
+	for range 2 {
+		if err := complete(context.Background()); err != nil {
+			t.Fatalf("GetJSONCompletion = %v, want the circuit closed", err)
+		}
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("changes = %v, want opened and closed", changes)
+	}
+}
@@ -0,0 +1,223 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Budget caps the AI usage per UTC day and month, zero fields don't cap
+type Budget struct {
+	DailyTokens   int
+	MonthlyTokens int
+
+	// DailySpend and MonthlySpend are in USD, usage of models of unknown
+	// price doesn't count towards them
+	DailySpend   float64
+	MonthlySpend float64
+}
+
+// ErrBudgetExceeded is returned instead of calling the provider once a
+// budget is used up
+var ErrBudgetExceeded = errors.New("ai budget exceeded")
+
+// BudgetError tells which budget is used up and when it's renewed
+type BudgetError struct {
+	// Period is "daily" or "monthly"
+	Period string
+
+	// Limit is the budget, e.g. "100000 tokens" or "$5.00"
+	Limit string
+
+	// ResetsAt is the start of the next period
+	ResetsAt time.Time
+}
+
+func (e *BudgetError) Error() string {
+	return fmt.Sprintf("%s ai budget of %s exceeded until %s", e.Period, e.Limit, e.ResetsAt.Format("2006-01-02 15:04 MST"))
+}
+
+func (e *BudgetError) Is(target error) bool {
+	return target == ErrBudgetExceeded
+}
+
+// BudgetMeter sums the usage of the current UTC day and month against a
+// budget. Usage made before the bot started can be added from history.
+type BudgetMeter struct {
+	mu     sync.Mutex
+	budget Budget
+
+	day, month     time.Time
+	daily, monthly budgetUsage
+
+	now func() time.Time
+}
+
+type budgetUsage struct {
+	tokens int
+	cost   float64
+}
+
+// NewBudgetMeter returns the meter of the budget, nil if it doesn't cap
+func NewBudgetMeter(budget Budget) *BudgetMeter {
+	if budget == (Budget{}) {
+		return nil
+	}
+	return &BudgetMeter{budget: budget, now: time.Now}
+}
+
+// Add counts usage made at the time, usage of past periods is ignored
+func (m *BudgetMeter) Add(at time.Time, tokens int, cost float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll()
+	at = at.UTC()
+	if !at.Before(m.month) {
+		m.monthly.tokens += tokens
+		m.monthly.cost += cost
+	}
+	if !at.Before(m.day) {
+		m.daily.tokens += tokens
+		m.daily.cost += cost
+	}
+}
+
+// Check returns a BudgetError if a budget is used up. The call that crosses
+// a budget is allowed, the next one is refused.
+func (m *BudgetMeter) Check() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.roll()
+	nextMonth, nextDay := m.month.AddDate(0, 1, 0), m.day.AddDate(0, 0, 1)
+	switch {
+	case m.budget.MonthlyTokens > 0 && m.monthly.tokens >= m.budget.MonthlyTokens:
+		return &BudgetError{Period: "monthly", Limit: fmt.Sprintf("%d tokens", m.budget.MonthlyTokens), ResetsAt: nextMonth}
+	case m.budget.MonthlySpend > 0 && m.monthly.cost >= m.budget.MonthlySpend:
+		return &BudgetError{Period: "monthly", Limit: fmt.Sprintf("$%.2f", m.budget.MonthlySpend), ResetsAt: nextMonth}
+	case m.budget.DailyTokens > 0 && m.daily.tokens >= m.budget.DailyTokens:
+		return &BudgetError{Period: "daily", Limit: fmt.Sprintf("%d tokens", m.budget.DailyTokens), ResetsAt: nextDay}
+	case m.budget.DailySpend > 0 && m.daily.cost >= m.budget.DailySpend:
+		return &BudgetError{Period: "daily", Limit: fmt.Sprintf("$%.2f", m.budget.DailySpend), ResetsAt: nextDay}
+	}
+	return nil
+}
+
+// roll starts new periods when the current ones are over
+func (m *BudgetMeter) roll() {
+	now := m.now().UTC()
+
+	if day := now.Truncate(24 * time.Hour); !day.Equal(m.day) {
+		m.day, m.daily = day, budgetUsage{}
+	}
+	if month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC); !month.Equal(m.month) {
+		m.month, m.monthly = month, budgetUsage{}
+	}
+}
+
+// WithBudget returns the provider refusing requests with ErrBudgetExceeded
+// once the meter's budget is used up, nil meter means no budget
+func WithBudget(p Provider, meter *BudgetMeter) Provider {
+	if meter == nil {
+		return p
+	}
+	return &budgetedProvider{Provider: p, meter: meter}
+}
+
+type budgetedProvider struct {
+	Provider
+	meter *BudgetMeter
+}
+
+func (p *budgetedProvider) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if err := p.meter.Check(); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletion(ctx, system, user, rf, result, opts...)
+	p.add(usage)
+	return usage, err
+}
+
+func (p *budgetedProvider) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if err := p.meter.Check(); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletionWithImage(ctx, system, user, image, mimeType, rf, result, opts...)
+	p.add(usage)
+	return usage, err
+}
+
+func (p *budgetedProvider) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if err := p.meter.Check(); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletionWithImages(ctx, system, user, images, rf, result, opts...)
+	p.add(usage)
+	return usage, err
+}
+
+func (p *budgetedProvider) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	tc, ok := p.Provider.(ToolCaller)
+	if !ok {
+		return nil, ErrToolsNotSupported
+	}
+	if err := p.meter.Check(); err != nil {
+		return nil, err
+	}
+
+	usage, err := tc.GetJSONCompletionWithTools(ctx, system, user, tools, rf, result, opts...)
+	p.add(usage)
+	return usage, err
+}
+
+func (p *budgetedProvider) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	streamer, ok := p.Provider.(Streamer)
+	if !ok {
+		return "", nil, ErrStreamingNotSupported
+	}
+	if err := p.meter.Check(); err != nil {
+		return "", nil, err
+	}
+
+	text, usage, err := streamer.StreamCompletion(ctx, system, user, onDelta)
+	p.add(usage)
+	return text, usage, err
+}
+
+func (p *budgetedProvider) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	transcriber, ok := p.Provider.(Transcriber)
+	if !ok {
+		return "", nil, ErrTranscriptionNotSupported
+	}
+	if err := p.meter.Check(); err != nil {
+		return "", nil, err
+	}
+
+	text, usage, err := transcriber.Transcribe(ctx, audio, opts)
+	p.add(usage)
+	return text, usage, err
+}
+
+func (p *budgetedProvider) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	if err := p.meter.Check(); err != nil {
+		return nil, nil, err
+	}
+
+	vectors, usage, err := p.Provider.GetEmbeddings(ctx, texts)
+	p.add(usage)
+	return vectors, usage, err
+}
+
+func (p *budgetedProvider) add(usage *Usage) {
+	if usage == nil {
+		return
+	}
+	cost, _ := usage.Cost()
+	p.meter.Add(p.meter.now(), usage.TotalTokens, cost)
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBudgetMeter(t *testing.T) {
+	now := time.Date(2025, 3, 31, 12, 0, 0, 0, time.UTC)
+	meter := NewBudgetMeter(Budget{DailyTokens: 1000, MonthlySpend: 1})
+	meter.now = func() time.Time { return now }
+
+	// Usage of the previous day counts towards the month only, usage of the
+	// previous month doesn't count
+	meter.Add(now.AddDate(0, 0, -1), 5000, 0.5)
+	meter.Add(time.Date(2025, 2, 15, 0, 0, 0, 0, time.UTC), 5000, 5)
+	if err := meter.Check(); err != nil {
+		t.Fatalf("Check = %v, want the budget left", err)
+	}
+
+	meter.Add(now, 1000, 0.1)
+	var budgetErr *BudgetError
+	if err := meter.Check(); !errors.As(err, &budgetErr) || budgetErr.Period != "daily" || !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Check = %v, want the daily budget exceeded", err)
+	}
+	if want := time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC); !budgetErr.ResetsAt.Equal(want) {
+		t.Errorf("resets at %v, want %v", budgetErr.ResetsAt, want)
+	}
+
+	meter.Add(now, 0, 0.4)
+	if err := meter.Check(); !errors.As(err, &budgetErr) || budgetErr.Period != "monthly" {
+		t.Fatalf("Check = %v, want the monthly budget exceeded", err)
+	}
+
+	// A new month renews both
+	now = now.Add(12 * time.Hour)
+	if err := meter.Check(); err != nil {
+		t.Errorf("Check = %v in a new month, want the budget renewed", err)
+	}
+}
+
+func TestWithBudget(t *testing.T) {
+	var calls int
+	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		return jsonResponse(200, `{
+			"model": "gpt-5-mini",
+			"choices": [{"finish_reason": "stop", "message": {"content": "{\"is_spam\": false}"}}],
+			"usage": {"prompt_tokens": 900, "completion_tokens": 100, "total_tokens": 1000}
+		}`), nil
+	}), OpenAIOptions{})
+	p := WithBudget(client, NewBudgetMeter(Budget{DailyTokens: 1000}))
+
+	var result SpamCheck
+	if _, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if _, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("GetJSONCompletion = %v, want ErrBudgetExceeded", err)
+	}
+	if calls != 1 {
+		t.Errorf("provider called %d times, want the request over the budget refused", calls)
+	}
+}
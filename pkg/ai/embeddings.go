@@ -0,0 +1,65 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+)
+
+// DefaultEmbeddingBatch is the number of texts embedded in one request by
+// default, within the limits of the providers
+const DefaultEmbeddingBatch = 100
+
+// Embedder makes embeddings with a provider, for similarity matching,
+// clustering and deduplication of messages
+type Embedder struct {
+	Provider Provider
+
+	// BatchSize is the most texts embedded in one request, zero means
+	// DefaultEmbeddingBatch
+	BatchSize int
+}
+
+// GetEmbedding returns the embedding vector of the text
+func (e Embedder) GetEmbedding(ctx context.Context, text string) ([]float32, *Usage, error) {
+	vectors, usage, err := e.Provider.GetEmbeddings(ctx, []string{text})
+	if err != nil {
+		return nil, usage, err
+	}
+	if len(vectors) != 1 {
+		return nil, usage, fmt.Errorf("got %d embeddings for a text", len(vectors))
+	}
+	return vectors[0], usage, nil
+}
+
+// GetEmbeddings returns the embedding vectors of the texts in order, sending
+// them in batches of at most BatchSize. The usage sums all requests. On an
+// error, the vectors of the batches done before it are returned.
+func (e Embedder) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	batchSize := e.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultEmbeddingBatch
+	}
+
+	var (
+		vectors = make([][]float32, 0, len(texts))
+		total   Usage
+	)
+	for start := 0; start < len(texts); start += batchSize {
+		batch := texts[start:min(start+batchSize, len(texts))]
+
+		batchVectors, usage, err := e.Provider.GetEmbeddings(ctx, batch)
+		if usage != nil {
+			total.PromptTokens += usage.PromptTokens
+			total.CompletionTokens += usage.CompletionTokens
+			total.TotalTokens += usage.TotalTokens
+			total.Model = usage.Model
+		}
+		if err != nil {
+			return vectors, &total, fmt.Errorf("embedding texts %d-%d: %w", start, start+len(batch)-1, err)
+		}
+
+		vectors = append(vectors, batchVectors...)
+	}
+
+	return vectors, &total, nil
+}
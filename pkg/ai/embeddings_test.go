@@ -0,0 +1,61 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestEmbedder(t *testing.T) {
+	var (
+		batches    [][]any
+		dimensions []any
+	)
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var req map[string]any
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &req)
+		input, _ := req["input"].([]any)
+		batches = append(batches, input)
+		dimensions = append(dimensions, req["dimensions"])
+
+		body := `{"model": "text-embedding-3-large", "data": [`
+		for i := range input {
+			if i > 0 {
+				body += ","
+			}
+			body += fmt.Sprintf(`{"index": %d, "embedding": [%d, 1]}`, i, len(batches)*10+i)
+		}
+		body += `], "usage": {"prompt_tokens": 3, "total_tokens": 3}}`
+		return jsonResponse(200, body), nil
+	}), OpenAIOptions{EmbeddingModel: "text-embedding-3-large", EmbeddingDimensions: 2})
+	embedder := Embedder{Provider: client, BatchSize: 2}
+
+	vectors, usage, err := embedder.GetEmbeddings(context.Background(), []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("GetEmbeddings: %v", err)
+	}
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Errorf("batches = %v, want 2 and 1 texts", batches)
+	}
+	if dimensions[0] != float64(2) {
+		t.Errorf("dimensions = %v, want 2", dimensions[0])
+	}
+	if len(vectors) != 3 || vectors[0][0] != 10 || vectors[1][0] != 11 || vectors[2][0] != 20 {
+		t.Errorf("vectors = %v, want them in the order of the texts", vectors)
+	}
+	if usage.TotalTokens != 6 || usage.Model != "text-embedding-3-large" {
+		t.Errorf("usage = %+v, want both batches summed", usage)
+	}
+
+	vector, _, err := embedder.GetEmbedding(context.Background(), "d")
+	if err != nil {
+		t.Fatalf("GetEmbedding: %v", err)
+	}
+	if len(vector) != 2 || vector[0] != 30 {
+		t.Errorf("vector = %v", vector)
+	}
+}
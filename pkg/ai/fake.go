@@ -0,0 +1,181 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+)
+
+// ProviderFake names the rule-based provider answering without an API, for
+// tests and the offline demo
+const ProviderFake = "fake"
+
+// FakeModel is the model reported in the usage of the fake provider
+const FakeModel = "fake"
+
+// DefaultFakeKeywords mark texts as spam in the fake provider by default
+var DefaultFakeKeywords = []string{
+	"casino", "crypto", "earn", "investment", "profit", "18+",
+	"казино", "крипт", "заработ", "доход", "инвест",
+}
+
+// fakeDimensions is the length of the embeddings of the fake provider
+const fakeDimensions = 16
+
+// Fake is a deterministic provider deciding by keywords instead of asking a
+// model: a text containing any of Keywords, case-insensitively, gets the Spam
+// verdict and others the Ham one. Scripted responses, if any, are returned
+// first in order. It makes no requests, so it needs no API key.
+type Fake struct {
+	// Keywords mark texts as spam
+	Keywords []string
+
+	// Spam and Ham are the verdicts, defaults to a confident one of the ads
+	// category and a confident ham one
+	Spam, Ham *SpamCheck
+
+	mu     sync.Mutex
+	script []fakeResponse
+	calls  []FakeCall
+}
+
+type fakeResponse struct {
+	content string
+	err     error
+}
+
+// FakeCall is a request made to the fake provider
+type FakeCall struct {
+	Method string
+	System string
+	User   string
+	Images int
+	Opts   CallOptions
+}
+
+// NewFake returns a fake provider marking texts with the keywords as spam
+func NewFake(keywords ...string) *Fake {
+	return &Fake{Keywords: keywords}
+}
+
+// Script queues responses returned before the keywords decide, in order. A
+// response is the JSON of the result as a string, a value marshaled into it,
+// or an error to fail the request with.
+func (f *Fake) Script(responses ...any) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, r := range responses {
+		switch r := r.(type) {
+		case string:
+			f.script = append(f.script, fakeResponse{content: r})
+		case error:
+			f.script = append(f.script, fakeResponse{err: r})
+		default:
+			data, err := json.Marshal(r)
+			if err != nil {
+				return fmt.Errorf("marshaling scripted response: %w", err)
+			}
+			f.script = append(f.script, fakeResponse{content: string(data)})
+		}
+	}
+	return nil
+}
+
+// Calls returns the requests made so far
+func (f *Fake) Calls() []FakeCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]FakeCall(nil), f.calls...)
+}
+
+func (f *Fake) GetJSONCompletion(_ context.Context, system, user string, _ ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return f.complete(FakeCall{Method: "completion", System: system, User: user, Opts: callOptions(opts)}, result)
+}
+
+func (f *Fake) GetJSONCompletionWithImage(_ context.Context, system, user string, _ []byte, _ string, _ ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return f.complete(FakeCall{Method: "image", System: system, User: user, Images: 1, Opts: callOptions(opts)}, result)
+}
+
+func (f *Fake) GetJSONCompletionWithImages(_ context.Context, system, user string, images []ImageData, _ ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+	return f.complete(FakeCall{Method: "images", System: system, User: user, Images: len(images), Opts: callOptions(opts)}, result)
+}
+
+// GetEmbeddings returns vectors hashed from the words of the texts, equal
+// for equal texts and close for texts sharing words
+func (f *Fake) GetEmbeddings(_ context.Context, texts []string) ([][]float32, *Usage, error) {
+	vectors := make([][]float32, len(texts))
+	var tokens int
+	for i, text := range texts {
+		vector := make([]float32, fakeDimensions)
+		for _, word := range strings.Fields(strings.ToLower(text)) {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(word))
+			vector[h.Sum32()%fakeDimensions]++
+		}
+		vectors[i] = vector
+		tokens += fakeTokens(text)
+	}
+	return vectors, &Usage{PromptTokens: tokens, TotalTokens: tokens, Model: FakeModel}, nil
+}
+
+func (f *Fake) complete(call FakeCall, result any) (*Usage, error) {
+	f.mu.Lock()
+	f.calls = append(f.calls, call)
+	var response fakeResponse
+	if len(f.script) > 0 {
+		response, f.script = f.script[0], f.script[1:]
+	}
+	f.mu.Unlock()
+
+	usage := &Usage{
+		PromptTokens:     fakeTokens(call.System) + fakeTokens(call.User),
+		CompletionTokens: 20,
+		Model:            FakeModel,
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+
+	if response.err != nil {
+		return nil, response.err
+	}
+	if response.content == "" {
+		data, err := json.Marshal(f.verdict(call.User))
+		if err != nil {
+			return nil, fmt.Errorf("marshaling verdict: %w", err)
+		}
+		response.content = string(data)
+	}
+	if err := json.Unmarshal([]byte(response.content), result); err != nil {
+		return usage, fmt.Errorf("%w: unmarshal response content: %w", ErrInvalidResponse, err)
+	}
+	return usage, nil
+}
+
+// verdict decides on the text by the keywords
+func (f *Fake) verdict(text string) SpamCheck {
+	text = strings.ToLower(text)
+	for _, keyword := range f.Keywords {
+		if keyword != "" && strings.Contains(text, strings.ToLower(keyword)) {
+			if f.Spam != nil {
+				return *f.Spam
+			}
+			return SpamCheck{IsSpam: true, Category: "ads", Confidence: 0.9, Note: fmt.Sprintf("contains %q", keyword)}
+		}
+	}
+	if f.Ham != nil {
+		return *f.Ham
+	}
+	return SpamCheck{Category: "none", Confidence: 0.9}
+}
+
+// fakeTokens estimates the tokens of the text at four bytes a token
+func fakeTokens(text string) int {
+	return (len(text) + 3) / 4
+}
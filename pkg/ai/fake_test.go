@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestFake(t *testing.T) {
+	fake := NewFake("casino", "Крипт")
+
+	var check SpamCheck
+	if _, err := fake.GetJSONCompletion(context.Background(), "sys", "Best CASINO bonus", SpamCheckFormat, &check); err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if !check.IsSpam || check.Category != "ads" {
+		t.Errorf("check = %+v, want spam by the keyword", check)
+	}
+
+	check = SpamCheck{}
+	if _, err := fake.GetJSONCompletion(context.Background(), "sys", "обсуждаем криптографию", SpamCheckFormat, &check); err != nil || !check.IsSpam {
+		t.Errorf("check = %+v, %v, want keywords matched case-insensitively", check, err)
+	}
+
+	var nsfw NSFWCheck
+	usage, err := fake.GetJSONCompletionWithImage(context.Background(), "sys", "photo", []byte("jpeg"), "image/jpeg", NSFWCheckFormat, &nsfw)
+	if err != nil || nsfw.NSFW {
+		t.Errorf("nsfw = %+v, %v, want a safe image", nsfw, err)
+	}
+	if usage.Model != FakeModel || usage.TotalTokens == 0 {
+		t.Errorf("usage = %+v, want tokens of the fake model", usage)
+	}
+
+	calls := fake.Calls()
+	if len(calls) != 3 || calls[2].Method != "image" || calls[2].Images != 1 {
+		t.Errorf("calls = %+v, want the three requests recorded", calls)
+	}
+}
+
+func TestFake_Script(t *testing.T) {
+	fake := NewFake("casino")
+	down := errors.New("down")
+	if err := fake.Script(SpamCheck{IsSpam: true, Category: "phishing"}, down, `{"is_spam": false, "note": "raw"}`); err != nil {
+		t.Fatalf("Script: %v", err)
+	}
+
+	var check SpamCheck
+	if _, err := fake.GetJSONCompletion(context.Background(), "sys", "hello", SpamCheckFormat, &check); err != nil || check.Category != "phishing" {
+		t.Errorf("first = %+v, %v, want the scripted verdict", check, err)
+	}
+	if _, err := fake.GetJSONCompletion(context.Background(), "sys", "hello", SpamCheckFormat, &check); !errors.Is(err, down) {
+		t.Errorf("second err = %v, want the scripted error", err)
+	}
+	check = SpamCheck{}
+	if _, err := fake.GetJSONCompletion(context.Background(), "casino", "casino", SpamCheckFormat, &check); err != nil || check.IsSpam || check.Note != "raw" {
+		t.Errorf("third = %+v, %v, want the scripted json", check, err)
+	}
+
+	// Then the keywords decide again
+	if _, err := fake.GetJSONCompletion(context.Background(), "sys", "casino", SpamCheckFormat, &check); err != nil || !check.IsSpam {
+		t.Errorf("after the script = %+v, %v, want spam by the keyword", check, err)
+	}
+}
+
+func TestFake_Embeddings(t *testing.T) {
+	fake := NewFake()
+	vectors, _, err := fake.GetEmbeddings(context.Background(), []string{"buy followers", "Buy  FOLLOWERS", "hello"})
+	if err != nil {
+		t.Fatalf("GetEmbeddings: %v", err)
+	}
+	if len(vectors) != 3 || len(vectors[0]) != fakeDimensions {
+		t.Fatalf("vectors = %v, want 3 of %d dimensions", vectors, fakeDimensions)
+	}
+	if !slices.Equal(vectors[0], vectors[1]) {
+		t.Errorf("vectors %v and %v of the same words differ", vectors[0], vectors[1])
+	}
+	if slices.Equal(vectors[0], vectors[2]) {
+		t.Error("different texts got the same vector")
+	}
+}
@@ -0,0 +1,166 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// FallbackLink is a provider of a fallback chain
+type FallbackLink struct {
+	// Name identifies the provider in Usage.Provider, e.g.
+	// "anthropic:claude-haiku-4-5"
+	Name     string
+	Provider Provider
+}
+
+// FallbackOptions configure a fallback chain
+type FallbackOptions struct {
+	// AttemptTimeout bounds the attempt of every provider but the last, so a
+	// hanging one leaves time for the next, zero doesn't bound them
+	AttemptTimeout time.Duration
+
+	// OnFailure is called with the error of a provider the chain falls back
+	// from, optional
+	OnFailure func(name string, err error)
+}
+
+// WithFallback returns the providers as one trying them in order: a request
+// that fails goes to the next provider, until one serves it. The name of the
+// provider that did is set in Usage.Provider. A request isn't passed on once
+// its context is done or the budget is used up, and embeddings are always
+// made by the first provider, as vectors of different models don't mix.
+func WithFallback(links []FallbackLink, opts FallbackOptions) Provider {
+	return &fallbackProvider{links: links, opts: opts}
+}
+
+type fallbackProvider struct {
+	links []FallbackLink
+	opts  FallbackOptions
+}
+
+func (p *fallbackProvider) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.try(ctx, opts, func(ctx context.Context, provider Provider, opts []CallOption) (*Usage, error) {
+		return provider.GetJSONCompletion(ctx, system, user, rf, result, opts...)
+	})
+}
+
+func (p *fallbackProvider) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.try(ctx, opts, func(ctx context.Context, provider Provider, opts []CallOption) (*Usage, error) {
+		return provider.GetJSONCompletionWithImage(ctx, system, user, image, mimeType, rf, result, opts...)
+	})
+}
+
+func (p *fallbackProvider) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.try(ctx, opts, func(ctx context.Context, provider Provider, opts []CallOption) (*Usage, error) {
+		return provider.GetJSONCompletionWithImages(ctx, system, user, images, rf, result, opts...)
+	})
+}
+
+// GetJSONCompletionWithTools tries the providers supporting tools
+func (p *fallbackProvider) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.try(ctx, opts, func(ctx context.Context, provider Provider, opts []CallOption) (*Usage, error) {
+		tc, ok := provider.(ToolCaller)
+		if !ok {
+			return nil, ErrToolsNotSupported
+		}
+		return tc.GetJSONCompletionWithTools(ctx, system, user, tools, rf, result, opts...)
+	})
+}
+
+// Transcribe tries the providers supporting transcription
+func (p *fallbackProvider) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	var text string
+	usage, err := p.try(ctx, nil, func(ctx context.Context, provider Provider, _ []CallOption) (*Usage, error) {
+		transcriber, ok := provider.(Transcriber)
+		if !ok {
+			return nil, ErrTranscriptionNotSupported
+		}
+		var (
+			usage *Usage
+			err   error
+		)
+		text, usage, err = transcriber.Transcribe(ctx, audio, opts)
+		return usage, err
+	})
+	return text, usage, err
+}
+
+func (p *fallbackProvider) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	vectors, usage, err := p.links[0].Provider.GetEmbeddings(ctx, texts)
+	if usage != nil {
+		usage.Provider = p.links[0].Name
+	}
+	return vectors, usage, err
+}
+
+// StreamCompletion streams from the first provider supporting streaming,
+// without falling back: a stream can't be taken back once it's begun
+func (p *fallbackProvider) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	for _, link := range p.links {
+		streamer, ok := link.Provider.(Streamer)
+		if !ok {
+			continue
+		}
+		text, usage, err := streamer.StreamCompletion(ctx, system, user, onDelta)
+		if errors.Is(err, ErrStreamingNotSupported) {
+			continue
+		}
+		if usage != nil {
+			usage.Provider = link.Name
+		}
+		return text, usage, err
+	}
+	return "", nil, ErrStreamingNotSupported
+}
+
+// try makes the call with the providers in order until one succeeds. A
+// provider not supporting the call is skipped quietly, if none does the
+// error is that of the call. A model set in the options is of the first
+// provider, the others are called with theirs.
+func (p *fallbackProvider) try(ctx context.Context, opts []CallOption, call func(ctx context.Context, provider Provider, opts []CallOption) (*Usage, error)) (*Usage, error) {
+	var (
+		errs        []error
+		unsupported error
+	)
+	for i, link := range p.links {
+		last := i == len(p.links)-1
+
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if p.opts.AttemptTimeout > 0 && !last {
+			attemptCtx, cancel = context.WithTimeout(ctx, p.opts.AttemptTimeout)
+		}
+		linkOpts := opts
+		if i > 0 && len(opts) > 0 {
+			linkOpts = append(slices.Clip(opts), WithModel(""))
+		}
+		usage, err := call(attemptCtx, link.Provider, linkOpts)
+		cancel()
+
+		if usage != nil {
+			usage.Provider = link.Name
+		}
+		if err == nil {
+			return usage, nil
+		}
+		if errors.Is(err, ErrToolsNotSupported) || errors.Is(err, ErrTranscriptionNotSupported) {
+			unsupported = err
+			continue
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", link.Name, err))
+		if last || ctx.Err() != nil || refused(err) {
+			return usage, errors.Join(errs...)
+		}
+		if p.opts.OnFailure != nil {
+			p.opts.OnFailure(link.Name, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil, unsupported
+	}
+	return nil, errors.Join(errs...)
+}
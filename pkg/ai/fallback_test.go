@@ -0,0 +1,90 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const spamCompletion = `{
+	"model": "gpt-5-mini",
+	"choices": [{"finish_reason": "stop", "message": {"content": "{\"is_spam\": true}"}}],
+	"usage": {"prompt_tokens": 90, "completion_tokens": 10, "total_tokens": 100}
+}`
+
+func TestWithFallback(t *testing.T) {
+	var calls []string
+	provider := func(name string, do roundTripFunc) FallbackLink {
+		return FallbackLink{Name: name, Provider: NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			calls = append(calls, name)
+			return do(r)
+		}), OpenAIOptions{})}
+	}
+	failing := provider("failing", func(*http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusServiceUnavailable, `{"error": "overloaded"}`), nil
+	})
+	hanging := provider("hanging", func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	})
+	serving := provider("serving", func(*http.Request) (*http.Response, error) {
+		return jsonResponse(http.StatusOK, spamCompletion), nil
+	})
+
+	var failed []string
+	p := WithFallback([]FallbackLink{failing, hanging, serving}, FallbackOptions{
+		AttemptTimeout: 10 * time.Millisecond,
+		OnFailure:      func(name string, _ error) { failed = append(failed, name) },
+	})
+
+	var result SpamCheck
+	usage, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result)
+	if err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if !result.IsSpam || usage == nil || usage.Provider != "serving" {
+		t.Errorf("result = %+v, usage = %+v, want served by the last provider", result, usage)
+	}
+	if len(failed) != 2 || failed[0] != "failing" || failed[1] != "hanging" {
+		t.Errorf("failed = %v, want the first two", failed)
+	}
+
+	// The last provider isn't timed out, its error is returned with the others
+	calls = nil
+	p = WithFallback([]FallbackLink{failing, failing}, FallbackOptions{})
+	if _, err = p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err == nil || len(calls) != 2 {
+		t.Errorf("GetJSONCompletion = %v after %d calls, want both failed", err, len(calls))
+	}
+}
+
+func TestWithFallback_Stops(t *testing.T) {
+	var calls int
+	serving := FallbackLink{Name: "serving", Provider: NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		calls++
+		return jsonResponse(http.StatusOK, spamCompletion), nil
+	}), OpenAIOptions{})}
+
+	// A used up budget is shared by the chain
+	meter := NewBudgetMeter(Budget{DailyTokens: 100})
+	meter.Add(time.Now(), 100, 0)
+	budgeted := FallbackLink{Name: "budgeted", Provider: WithBudget(serving.Provider, meter)}
+
+	p := WithFallback([]FallbackLink{budgeted, serving}, FallbackOptions{})
+	var result SpamCheck
+	if _, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); !errors.Is(err, ErrBudgetExceeded) || calls != 0 {
+		t.Errorf("GetJSONCompletion = %v after %d calls, want ErrBudgetExceeded", err, calls)
+	}
+
+	// Nor is a request the caller gave up on
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelling := FallbackLink{Name: "cancelling", Provider: NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		cancel()
+		return nil, context.Canceled
+	}), OpenAIOptions{})}
+	p = WithFallback([]FallbackLink{cancelling, serving}, FallbackOptions{})
+	if _, err := p.GetJSONCompletion(ctx, "sys", "user", SpamCheckFormat, &result); !errors.Is(err, context.Canceled) || calls != 0 {
+		t.Errorf("GetJSONCompletion = %v after %d calls, want context.Canceled", err, calls)
+	}
+}
@@ -0,0 +1,142 @@
+package ai
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// FormatStrategy is how a model is asked for JSON matching a response format
+type FormatStrategy string
+
+const (
+	// FormatAuto starts with FormatJSONSchema and downgrades the strategy of
+	// a model when the model rejects it
+	FormatAuto FormatStrategy = "auto"
+
+	// FormatJSONSchema sends the response format as a strict json_schema
+	FormatJSONSchema FormatStrategy = "json_schema"
+
+	// FormatJSONObject asks for any JSON object with json_object and puts
+	// the schema into the system prompt
+	FormatJSONObject FormatStrategy = "json_object"
+
+	// FormatPrompt asks for the JSON in the system prompt only, for models
+	// without a response_format
+	FormatPrompt FormatStrategy = "prompt"
+)
+
+// formatDowngrades lists the strategies in the order they're tried
+var formatDowngrades = []FormatStrategy{FormatJSONSchema, FormatJSONObject, FormatPrompt}
+
+// errFormatNotSupported is returned for a request whose response format the
+// model rejected
+var errFormatNotSupported = errors.New("response format not supported by the model")
+
+// formatStrategies keeps the strategies of the models of a client. Results
+// of the weaker strategies aren't enforced by the provider, see
+// WithValidation.
+type formatStrategies struct {
+	fixed FormatStrategy
+
+	mu      sync.Mutex
+	byModel map[string]FormatStrategy
+}
+
+func newFormatStrategies(fixed FormatStrategy) *formatStrategies {
+	if fixed == "" {
+		fixed = FormatAuto
+	}
+	return &formatStrategies{fixed: fixed, byModel: make(map[string]FormatStrategy)}
+}
+
+// get returns the strategy of the model
+func (f *formatStrategies) get(model string) FormatStrategy {
+	if f.fixed != FormatAuto {
+		return f.fixed
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s, ok := f.byModel[model]; ok {
+		return s
+	}
+	return FormatJSONSchema
+}
+
+// downgrade moves the model to the strategy after the rejected one, ok is
+// false if there is none to move to
+func (f *formatStrategies) downgrade(model string, rejected FormatStrategy) bool {
+	if f.fixed != FormatAuto {
+		return false
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	current, ok := f.byModel[model]
+	if !ok {
+		current = FormatJSONSchema
+	}
+	if current != rejected {
+		// Another request has downgraded it meanwhile
+		return true
+	}
+
+	i := slices.Index(formatDowngrades, rejected)
+	if i < 0 || i == len(formatDowngrades)-1 {
+		return false
+	}
+	f.byModel[model] = formatDowngrades[i+1]
+	return true
+}
+
+// withFormat returns the request asking for the response format in the
+// strategy, the messages of the request are not changed
+func withFormat(request Request, rf ResponseFormat, strategy FormatStrategy) (Request, error) {
+	if strategy == FormatJSONSchema || rf == "" {
+		request.ResponseFormat = rf
+		return request, nil
+	}
+
+	_, schema, err := rf.jsonSchema()
+	if err != nil {
+		return request, err
+	}
+	instruction := "\n\nANSWER WITH A SINGLE JSON OBJECT MATCHING THIS JSON SCHEMA AND NOTHING ELSE:\n" + string(schema) + "\n"
+
+	request.Messages = slices.Clone(request.Messages)
+	if len(request.Messages) > 0 && request.Messages[0].Role == RoleSystem {
+		if system, ok := request.Messages[0].Content.(string); ok {
+			request.Messages[0].Content = system + instruction
+		}
+	} else {
+		request.Messages = slices.Insert(request.Messages, 0, Message{Role: RoleSystem, Content: strings.TrimSpace(instruction)})
+	}
+
+	request.ResponseFormat = nil
+	if strategy == FormatJSONObject {
+		request.ResponseFormat = map[string]string{"type": "json_object"}
+	}
+	return request, nil
+}
+
+// isUnsupportedResponseFormat reports whether the body of a 400 response
+// rejects the response format
+func isUnsupportedResponseFormat(resBody []byte) bool {
+	body := strings.ToLower(string(resBody))
+	return strings.Contains(body, "response_format") ||
+		strings.Contains(body, "json_schema") ||
+		strings.Contains(body, "json_object") ||
+		strings.Contains(body, "structured output")
+}
+
+// extractJSON returns the JSON object in the content of a completion, which
+// a model not held to the schema may wrap in a code block or prose
+func extractJSON(content string) string {
+	start, end := strings.IndexByte(content, '{'), strings.LastIndexByte(content, '}')
+	if start < 0 || end < start {
+		return content
+	}
+	return content[start : end+1]
+}
@@ -0,0 +1,62 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOpenAI_FormatDowngrade(t *testing.T) {
+	var formats []string
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body Request
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+
+		format, _ := json.Marshal(body.ResponseFormat)
+		formats = append(formats, string(format))
+		if strings.Contains(string(format), "json_schema") {
+			return jsonResponse(400, `{"error": {"message": "response_format json_schema is not supported by this model"}}`), nil
+		}
+		if system := body.Messages[0].Content.(string); !strings.Contains(system, `"is_spam"`) {
+			t.Errorf("system = %q, want the schema in it", system)
+		}
+		return jsonResponse(200, `{"model": "llama", "choices": [{"finish_reason": "stop", "message": {"content": "`+
+			"```json\\n{\\\"is_spam\\\": true, \\\"category\\\": \\\"ads\\\"}\\n```"+`"}}]}`), nil
+	}), OpenAIOptions{BaseURL: "http://localhost:8000/v1", Model: "llama"})
+
+	var result SpamCheck
+	if _, err := client.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if !result.IsSpam || result.Category != "ads" {
+		t.Errorf("result = %+v, want it read from the code block", result)
+	}
+	if len(formats) != 2 || formats[1] != `{"type":"json_object"}` {
+		t.Errorf("formats = %v, want json_schema then json_object", formats)
+	}
+
+	formats = nil
+	if _, err := client.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if len(formats) != 1 || formats[0] != `{"type":"json_object"}` {
+		t.Errorf("formats = %v, want the model asked with json_object right away", formats)
+	}
+}
+
+func TestOpenAI_FixedFormat(t *testing.T) {
+	requests := 0
+	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		requests++
+		return jsonResponse(400, `{"error": {"message": "unknown parameter response_format"}}`), nil
+	}), OpenAIOptions{ResponseFormat: FormatJSONSchema})
+
+	var result SpamCheck
+	if _, err := client.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err == nil || requests != 1 {
+		t.Errorf("GetJSONCompletion = %v after %d requests, want the rejection of the fixed format", err, requests)
+	}
+}
@@ -0,0 +1,217 @@
+package ai
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	GeminiModel          = "gemini-2.5-flash"
+	GeminiEmbeddingModel = "text-embedding-004"
+	GeminiBaseURL        = "https://generativelanguage.googleapis.com/v1beta"
+)
+
+// Gemini is the client of the Google Gemini API
+type Gemini struct {
+	apiKey              string
+	httpClient          HTTPClient
+	baseURL             string
+	model               string
+	visionModel         string
+	embeddingModel      string
+	embeddingDimensions int
+}
+
+func NewGemini(apiKey string, httpClient HTTPClient) *Gemini {
+	return &Gemini{
+		apiKey:         apiKey,
+		httpClient:     httpClient,
+		baseURL:        GeminiBaseURL,
+		model:          GeminiModel,
+		visionModel:    GeminiModel,
+		embeddingModel: GeminiEmbeddingModel,
+	}
+}
+
+// configure applies the non-zero provider options
+func (c *Gemini) configure(opts ProviderOptions) {
+	if opts.BaseURL != "" {
+		c.baseURL = strings.TrimSuffix(opts.BaseURL, "/")
+	}
+	if opts.Model != "" {
+		c.model = opts.Model
+	}
+	if opts.VisionModel != "" {
+		c.visionModel = opts.VisionModel
+	}
+	if opts.EmbeddingModel != "" {
+		c.embeddingModel = opts.EmbeddingModel
+	}
+	c.embeddingDimensions = opts.EmbeddingDimensions
+}
+
+func (c *Gemini) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return c.getCompletion(ctx, system, user, nil, rf, result, callOptions(opts))
+}
+
+func (c *Gemini) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return c.getCompletion(ctx, system, user, []ImageData{{Content: image, MimeType: mimeType}}, rf, result, callOptions(opts))
+}
+
+func (c *Gemini) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+	return c.getCompletion(ctx, system, user, images, rf, result, callOptions(opts))
+}
+
+type geminiPart struct {
+	Text       string            `json:"text,omitempty"`
+	InlineData *geminiInlineData `json:"inlineData,omitempty"`
+}
+
+type geminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	SystemInstruction geminiContent   `json:"systemInstruction"`
+	Contents          []geminiContent `json:"contents"`
+	GenerationConfig  struct {
+		ResponseMimeType   string          `json:"responseMimeType"`
+		ResponseJSONSchema json.RawMessage `json:"responseJsonSchema"`
+		Temperature        *float64        `json:"temperature,omitempty"`
+		MaxOutputTokens    int             `json:"maxOutputTokens,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiResponse struct {
+	ModelVersion string `json:"modelVersion"`
+	Candidates   []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount        int `json:"promptTokenCount"`
+		CachedContentTokenCount int `json:"cachedContentTokenCount"`
+		CandidatesTokenCount    int `json:"candidatesTokenCount"`
+		TotalTokenCount         int `json:"totalTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+func (c *Gemini) getCompletion(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, o CallOptions) (*Usage, error) {
+	_, schema, err := rf.jsonSchema()
+	if err != nil {
+		return nil, err
+	}
+
+	model := c.model
+	parts := []geminiPart{{Text: user}}
+	for _, image := range images {
+		model = c.visionModel
+		parts = append(parts, geminiPart{InlineData: &geminiInlineData{
+			MimeType: image.MimeType,
+			Data:     base64.StdEncoding.EncodeToString(image.Content),
+		}})
+	}
+
+	if o.Model != "" {
+		model = o.Model
+	}
+
+	request := geminiRequest{
+		SystemInstruction: geminiContent{Parts: []geminiPart{{Text: system}}},
+		Contents:          []geminiContent{{Role: "user", Parts: parts}},
+	}
+	request.GenerationConfig.ResponseMimeType = "application/json"
+	request.GenerationConfig.ResponseJSONSchema = schema
+	request.GenerationConfig.Temperature = o.Temperature
+	request.GenerationConfig.MaxOutputTokens = o.MaxTokens
+
+	var response geminiResponse
+	if err = postJSON(ctx, c.httpClient, c.baseURL+"/models/"+model+":generateContent", c.header(), request, &response); err != nil {
+		return nil, err
+	}
+
+	usage := &Usage{
+		PromptTokens:        response.UsageMetadata.PromptTokenCount,
+		CompletionTokens:    response.UsageMetadata.CandidatesTokenCount,
+		TotalTokens:         response.UsageMetadata.TotalTokenCount,
+		PromptTokensDetails: PromptTokensDetails{CachedTokens: response.UsageMetadata.CachedContentTokenCount},
+		Model:               response.ModelVersion,
+	}
+
+	if len(response.Candidates) == 0 {
+		return usage, fmt.Errorf("empty candidates in response")
+	}
+
+	candidate := response.Candidates[0]
+	if candidate.FinishReason != "STOP" {
+		return usage, fmt.Errorf("unexpected finish reason: %v", candidate.FinishReason)
+	}
+
+	var text string
+	for _, part := range candidate.Content.Parts {
+		text += part.Text
+	}
+	if err = json.Unmarshal([]byte(text), result); err != nil {
+		return usage, fmt.Errorf("%w: unmarshal response content: %w", ErrInvalidResponse, err)
+	}
+
+	return usage, nil
+}
+
+// GetEmbeddings returns embeddings of the texts made by the embedding model
+func (c *Gemini) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	type embedRequest struct {
+		Model                string        `json:"model"`
+		Content              geminiContent `json:"content"`
+		OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+	}
+	var request struct {
+		Requests []embedRequest `json:"requests"`
+	}
+	for _, text := range texts {
+		request.Requests = append(request.Requests, embedRequest{
+			Model:                "models/" + c.embeddingModel,
+			Content:              geminiContent{Parts: []geminiPart{{Text: text}}},
+			OutputDimensionality: c.embeddingDimensions,
+		})
+	}
+
+	var response struct {
+		Embeddings []struct {
+			Values []float32 `json:"values"`
+		} `json:"embeddings"`
+	}
+	if err := postJSON(ctx, c.httpClient, c.baseURL+"/models/"+c.embeddingModel+":batchEmbedContents", c.header(), request, &response); err != nil {
+		return nil, nil, err
+	}
+
+	// The embeddings API doesn't report token counts
+	usage := &Usage{Model: c.embeddingModel}
+	if len(response.Embeddings) != len(texts) {
+		return nil, usage, fmt.Errorf("got %d embeddings for %d texts", len(response.Embeddings), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for i, embedding := range response.Embeddings {
+		vectors[i] = embedding.Values
+	}
+
+	return vectors, usage, nil
+}
+
+func (c *Gemini) header() http.Header {
+	return http.Header{"X-Goog-Api-Key": {c.apiKey}}
+}
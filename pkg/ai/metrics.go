@@ -0,0 +1,160 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+)
+
+// Metrics count the calls of providers and their durations, so a moderation
+// latency spike can be told apart from a slow provider
+type Metrics struct {
+	calls    metrics.Counter
+	duration metrics.Summary
+	tokens   metrics.Counter
+}
+
+// NewMetrics returns the metrics of providers, reporting to the registry
+func NewMetrics(registry *metrics.Registry) *Metrics {
+	return &Metrics{
+		calls: registry.Counter(
+			"antispam_ai_calls_total",
+			"Number of AI provider calls by provider, model, method, status (ok, error, timeout or canceled) and whether the prompt cache was hit.",
+			"provider", "model", "method", "status", "cached",
+		),
+		duration: registry.Summary(
+			"antispam_ai_call_duration_seconds",
+			"Duration of AI provider calls, retries included, by provider, method and status.",
+			"provider", "method", "status",
+		),
+		tokens: registry.Counter(
+			"antispam_ai_call_tokens_total",
+			"Number of tokens of AI provider calls by provider, model and kind (prompt, cached or completion).",
+			"provider", "model", "kind",
+		),
+	}
+}
+
+// WithMetrics returns the provider reporting its calls to the metrics under
+// the name, nil metrics return it as is
+func WithMetrics(p Provider, name string, m *Metrics) Provider {
+	if m == nil {
+		return p
+	}
+	return &meteredProvider{Provider: p, name: name, metrics: m}
+}
+
+type meteredProvider struct {
+	Provider
+	name    string
+	metrics *Metrics
+}
+
+// observe records a call of the method which started at start. Calls the
+// provider refused without sending them aren't recorded.
+func (p *meteredProvider) observe(method string, start time.Time, usage *Usage, err error) {
+	if refused(err) || notSupported(err) {
+		return
+	}
+
+	status := callStatus(err)
+	p.metrics.duration.Observe(time.Since(start).Seconds(), p.name, method, status)
+
+	var model string
+	var cached int
+	if usage != nil {
+		model = usage.Model
+		cached = usage.PromptTokensDetails.CachedTokens
+		p.metrics.tokens.Add(float64(usage.PromptTokens), p.name, model, "prompt")
+		p.metrics.tokens.Add(float64(cached), p.name, model, "cached")
+		p.metrics.tokens.Add(float64(usage.CompletionTokens), p.name, model, "completion")
+	}
+	p.metrics.calls.Inc(p.name, model, method, status, strconv.FormatBool(cached > 0))
+}
+
+func notSupported(err error) bool {
+	return errors.Is(err, ErrToolsNotSupported) ||
+		errors.Is(err, ErrEmbeddingsNotSupported) ||
+		errors.Is(err, ErrStreamingNotSupported) ||
+		errors.Is(err, ErrTranscriptionNotSupported)
+}
+
+func callStatus(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+func (p *meteredProvider) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	start := time.Now()
+	usage, err := p.Provider.GetJSONCompletion(ctx, system, user, rf, result, opts...)
+	p.observe("completion", start, usage, err)
+	return usage, err
+}
+
+func (p *meteredProvider) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	start := time.Now()
+	usage, err := p.Provider.GetJSONCompletionWithImage(ctx, system, user, image, mimeType, rf, result, opts...)
+	p.observe("image", start, usage, err)
+	return usage, err
+}
+
+func (p *meteredProvider) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	start := time.Now()
+	usage, err := p.Provider.GetJSONCompletionWithImages(ctx, system, user, images, rf, result, opts...)
+	p.observe("images", start, usage, err)
+	return usage, err
+}
+
+func (p *meteredProvider) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	tc, ok := p.Provider.(ToolCaller)
+	if !ok {
+		return nil, ErrToolsNotSupported
+	}
+
+	start := time.Now()
+	usage, err := tc.GetJSONCompletionWithTools(ctx, system, user, tools, rf, result, opts...)
+	p.observe("tools", start, usage, err)
+	return usage, err
+}
+
+func (p *meteredProvider) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	streamer, ok := p.Provider.(Streamer)
+	if !ok {
+		return "", nil, ErrStreamingNotSupported
+	}
+
+	start := time.Now()
+	text, usage, err := streamer.StreamCompletion(ctx, system, user, onDelta)
+	p.observe("stream", start, usage, err)
+	return text, usage, err
+}
+
+func (p *meteredProvider) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	transcriber, ok := p.Provider.(Transcriber)
+	if !ok {
+		return "", nil, ErrTranscriptionNotSupported
+	}
+
+	start := time.Now()
+	text, usage, err := transcriber.Transcribe(ctx, audio, opts)
+	p.observe("transcribe", start, usage, err)
+	return text, usage, err
+}
+
+func (p *meteredProvider) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	start := time.Now()
+	vectors, usage, err := p.Provider.GetEmbeddings(ctx, texts)
+	p.observe("embeddings", start, usage, err)
+	return vectors, usage, err
+}
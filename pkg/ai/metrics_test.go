@@ -0,0 +1,57 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"nuclight.org/antispam-tg-bot/pkg/metrics"
+)
+
+func TestWithMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	failing := false
+	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		if failing {
+			return jsonResponse(500, `{"error": "down"}`), nil
+		}
+		return jsonResponse(200, `{
+			"model": "gpt-5-mini",
+			"choices": [{"finish_reason": "stop", "message": {"content": "{\"is_spam\": true}"}}],
+			"usage": {"prompt_tokens": 90, "completion_tokens": 10, "total_tokens": 100, "prompt_tokens_details": {"cached_tokens": 64}}
+		}`), nil
+	}), OpenAIOptions{})
+	p := WithMetrics(client, ProviderOpenAI, NewMetrics(registry))
+
+	var result SpamCheck
+	if _, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	failing = true
+	if _, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err == nil {
+		t.Fatal("GetJSONCompletion succeeded, want the provider's error")
+	}
+	// Refused before being sent, not a call of the provider
+	if _, err := p.GetJSONCompletionWithImages(context.Background(), "sys", "user", nil, SpamCheckFormat, &result); err == nil {
+		t.Fatal("GetJSONCompletionWithImages without images succeeded")
+	}
+
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for _, want := range []string{
+		`antispam_ai_calls_total{provider="openai",model="gpt-5-mini",method="completion",status="ok",cached="true"} 1`,
+		`antispam_ai_calls_total{provider="openai",model="",method="completion",status="error",cached="false"} 1`,
+		`antispam_ai_call_duration_seconds_count{provider="openai",method="completion",status="ok"} 1`,
+		`antispam_ai_call_tokens_total{provider="openai",model="gpt-5-mini",kind="cached"} 64`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("metrics lack %s:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), `method="images"`) {
+		t.Errorf("refused call recorded:\n%s", out.String())
+	}
+}
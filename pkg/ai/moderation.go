@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// ModerationModel is the model of the moderations endpoint, free of charge
+const ModerationModel = "omni-moderation-latest"
+
+// Moderation is the moderation model's classification of a text into
+// categories of harmful content, e.g. "sexual" or "harassment/threatening".
+// It doesn't recognize spam as such.
+type Moderation struct {
+	Flagged bool
+
+	// Categories are the flagged categories, sorted
+	Categories []string
+
+	// Scores are the model's confidences from 0 to 1 by category
+	Scores map[string]float64
+}
+
+// TopCategory returns the category of the highest score and the score
+func (m Moderation) TopCategory() (string, float64) {
+	var (
+		top   string
+		score float64
+	)
+	for category, s := range m.Scores {
+		if s > score || (s == score && category < top) {
+			top, score = category, s
+		}
+	}
+	return top, score
+}
+
+// Moderate classifies the text with the moderations endpoint
+func (c *OpenAI) Moderate(ctx context.Context, text string) (Moderation, error) {
+	request := struct {
+		Model string `json:"model"`
+		Input string `json:"input"`
+	}{Model: ModerationModel, Input: text}
+
+	var response struct {
+		Results []struct {
+			Flagged        bool               `json:"flagged"`
+			Categories     map[string]bool    `json:"categories"`
+			CategoryScores map[string]float64 `json:"category_scores"`
+		} `json:"results"`
+	}
+	endpoint, err := c.endpoint("moderations")
+	if err != nil {
+		return Moderation{}, err
+	}
+	if err = postJSON(ctx, c.httpClient, endpoint, c.header(), request, &response); err != nil {
+		return Moderation{}, err
+	}
+
+	if len(response.Results) != 1 {
+		return Moderation{}, fmt.Errorf("got %d moderation results for a text", len(response.Results))
+	}
+
+	result := response.Results[0]
+	m := Moderation{Flagged: result.Flagged, Scores: result.CategoryScores}
+	for category, flagged := range result.Categories {
+		if flagged {
+			m.Categories = append(m.Categories, category)
+		}
+	}
+	sort.Strings(m.Categories)
+
+	return m, nil
+}
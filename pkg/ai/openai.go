@@ -5,42 +5,202 @@ import (
 	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"time"
 )
 
+// OpenAIOptions configure the client, zero fields keep the defaults
+type OpenAIOptions struct {
+	// BaseURL is the API root of an OpenAI-compatible service, such as
+	// OpenRouter ("https://openrouter.ai/api/v1") or an Azure OpenAI resource
+	// ("https://my-resource.openai.azure.com/openai/v1"). Query parameters,
+	// e.g. an api-version, are kept. Defaults to DefaultBaseURL.
+	BaseURL string
+
+	// Model defaults to DefaultModel
+	Model string
+
+	// VisionModel is used for requests with images, defaults to VisionModel
+	VisionModel string
+
+	// EmbeddingModel defaults to EmbeddingModel
+	EmbeddingModel string
+
+	// EmbeddingDimensions shortens embeddings to the number of dimensions,
+	// zero keeps the model's. Supported by text-embedding-3 models.
+	EmbeddingDimensions int
+
+	// TranscriptionModel defaults to TranscriptionModel
+	TranscriptionModel string
+
+	// MaxAudioDuration caps the audio to transcribe, defaults to
+	// DefaultMaxAudioDuration
+	MaxAudioDuration time.Duration
+
+	// ImageDetail is the detail of images of requests not setting theirs
+	// with WithImageDetail, defaults to ImageDetailLow
+	ImageDetail ImageDetail
+
+	// ResponseFormat is how the models are asked for JSON, defaults to
+	// FormatAuto, which suits OpenAI-compatible services whose models don't
+	// all support json_schema
+	ResponseFormat FormatStrategy
+}
+
 type OpenAI struct {
-	apiKey     string
-	httpClient HTTPClient
+	apiKey              string
+	httpClient          HTTPClient
+	baseURL             string
+	model               string
+	visionModel         string
+	embeddingModel      string
+	embeddingDimensions int
+	transcriptionModel  string
+	maxAudioDuration    time.Duration
+	imageDetail         ImageDetail
+	formats             *formatStrategies
 }
 
-func NewOpenAI(apiKey string, httpClient HTTPClient) *OpenAI {
-	return &OpenAI{
-		apiKey:     apiKey,
-		httpClient: httpClient,
+func NewOpenAI(apiKey string, httpClient HTTPClient, opts OpenAIOptions) *OpenAI {
+	c := &OpenAI{
+		apiKey:              apiKey,
+		httpClient:          httpClient,
+		baseURL:             DefaultBaseURL,
+		model:               DefaultModel,
+		visionModel:         VisionModel,
+		embeddingModel:      EmbeddingModel,
+		embeddingDimensions: opts.EmbeddingDimensions,
+		transcriptionModel:  TranscriptionModel,
+		maxAudioDuration:    DefaultMaxAudioDuration,
+		imageDetail:         ImageDetailLow,
+		formats:             newFormatStrategies(opts.ResponseFormat),
+	}
+	if opts.BaseURL != "" {
+		c.baseURL = opts.BaseURL
+	}
+	if opts.Model != "" {
+		c.model = opts.Model
+	}
+	if opts.VisionModel != "" {
+		c.visionModel = opts.VisionModel
+	}
+	if opts.EmbeddingModel != "" {
+		c.embeddingModel = opts.EmbeddingModel
+	}
+	if opts.TranscriptionModel != "" {
+		c.transcriptionModel = opts.TranscriptionModel
+	}
+	if opts.MaxAudioDuration > 0 {
+		c.maxAudioDuration = opts.MaxAudioDuration
+	}
+	if opts.ImageDetail != "" {
+		c.imageDetail = opts.ImageDetail
 	}
+	return c
 }
 
-func (c *OpenAI) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any) (*Usage, error) {
-	return c.getCompletion(ctx, DefaultModel, system, user, nil, rf, result)
+func (c *OpenAI) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return c.getCompletion(ctx, c.model, system, user, nil, rf, result, callOptions(opts))
 }
 
 // GetJSONCompletionWithImage sends a request with both text and image to the vision model
-func (c *OpenAI) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any) (*Usage, error) {
-	imageData := &ImageData{
-		Content:  image,
-		MimeType: mimeType,
+func (c *OpenAI) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return c.GetJSONCompletionWithImages(ctx, system, user, []ImageData{{Content: image, MimeType: mimeType}}, rf, result, opts...)
+}
+
+// GetJSONCompletionWithImages sends a request with the text and all the
+// images to the vision model, images without a detail get the detail of
+// the request
+func (c *OpenAI) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	if len(images) == 0 {
+		return nil, ErrNoImages
+	}
+
+	o := callOptions(opts)
+	detail := c.imageDetail
+	if o.ImageDetail != "" {
+		detail = o.ImageDetail
 	}
-	return c.getCompletion(ctx, VisionModel, system, user, imageData, rf, result)
+	images = slices.Clone(images)
+	for i := range images {
+		if images[i].Detail == "" {
+			images[i].Detail = detail
+		}
+	}
+	return c.getCompletion(ctx, c.visionModel, system, user, images, rf, result, o)
+}
+
+// GetEmbeddings returns embeddings of the texts made by the embedding model
+func (c *OpenAI) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	request := struct {
+		Model      string   `json:"model"`
+		Input      []string `json:"input"`
+		Dimensions int      `json:"dimensions,omitempty"`
+	}{Model: c.embeddingModel, Input: texts, Dimensions: c.embeddingDimensions}
+
+	var response struct {
+		Model string `json:"model"`
+		Data  []struct {
+			Index     int       `json:"index"`
+			Embedding []float32 `json:"embedding"`
+		} `json:"data"`
+		Usage Usage `json:"usage"`
+	}
+	endpoint, err := c.endpoint("embeddings")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = postJSON(ctx, c.httpClient, endpoint, c.header(), request, &response); err != nil {
+		return nil, nil, err
+	}
+	response.Usage.Model = response.Model
+
+	if len(response.Data) != len(texts) {
+		return nil, &response.Usage, fmt.Errorf("got %d embeddings for %d texts", len(response.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range response.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, &response.Usage, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+
+	return vectors, &response.Usage, nil
 }
 
 type ImageData struct {
 	Content  []byte
 	MimeType string
+
+	// Detail is the detail the model sees the image at, only OpenAI models
+	// take it
+	Detail ImageDetail
 }
 
+// ImageDetail is the detail an OpenAI vision model sees an image at
+type ImageDetail string
+
+const (
+	// ImageDetailLow shows the model a 512px view of the image, at a low
+	// fixed cost in tokens
+	ImageDetailLow ImageDetail = "low"
+
+	// ImageDetailHigh adds 512px tiles of the image, each costing tokens,
+	// for reading small text
+	ImageDetailHigh ImageDetail = "high"
+
+	// ImageDetailAuto lets the model choose by the size of the image
+	ImageDetailAuto ImageDetail = "auto"
+)
+
 // VisionSupportedMimeTypes are the image formats supported by OpenAI vision API
 var VisionSupportedMimeTypes = map[string]bool{
 	"image/jpeg": true,
@@ -95,85 +255,155 @@ func isUnsupportedImageFormat(resBody []byte) bool {
 	return apiErr.Error.Code == "invalid_image_format"
 }
 
-func (c *OpenAI) getCompletion(ctx context.Context, model, system, user string, image *ImageData, rf ResponseFormat, result any) (*Usage, error) {
-	var userContent any
-	if image != nil {
-		// Multi-modal content with text and image
-		b64 := base64.StdEncoding.EncodeToString(image.Content)
-		dataURL := fmt.Sprintf("data:%s;base64,%s", image.MimeType, b64)
-		userContent = []ContentPart{
-			{Type: "text", Text: user},
-			{Type: "image_url", ImageURL: &ImageURL{URL: dataURL, Detail: "low"}}, // "low" saves tokens
+func (c *OpenAI) getCompletion(ctx context.Context, model, system, user string, images []ImageData, rf ResponseFormat, result any, o CallOptions) (*Usage, error) {
+	request := completionRequest(model, system, user, images, rf)
+	o.apply(&request)
+	response, err := c.postFormatted(ctx, request, rf, images)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeCompletion(response, result)
+}
+
+// postFormatted sends the chat completion request asking for the response
+// format in the strategy of the request's model. A model rejecting the
+// strategy is downgraded to the next one and asked again.
+func (c *OpenAI) postFormatted(ctx context.Context, request Request, rf ResponseFormat, images []ImageData) (Response, error) {
+	for {
+		strategy := c.formats.get(request.Model)
+		formatted, err := withFormat(request, rf, strategy)
+		if err != nil {
+			return Response{}, err
 		}
-	} else {
-		userContent = user
+
+		response, err := c.postCompletion(ctx, formatted, images)
+		if errors.Is(err, errFormatNotSupported) && c.formats.downgrade(request.Model, strategy) {
+			continue
+		}
+		return response, err
 	}
+}
 
-	request := Request{
-		Model: model,
-		Messages: []Message{
-			{
-				Role:    RoleSystem,
-				Content: system,
-			},
-			{
-				Role:    RoleUser,
-				Content: userContent,
-			},
-		},
-		ResponseFormat: rf,
+// postCompletion sends the chat completion request, images are the
+// request's images if any
+func (c *OpenAI) postCompletion(ctx context.Context, request Request, images []ImageData) (Response, error) {
+	res, err := c.doCompletion(ctx, request)
+	if err != nil {
+		return Response{}, err
 	}
 
-	// Only add reasoning effort for non-vision models
-	if image == nil {
-		request.ReasoningEffort = ReasoningEffortMedium
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		statusErr := fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
+
+		// The error doesn't tell which of several images is unsupported
+		if len(images) == 1 && isUnsupportedImageFormat(resBody) && len(images[0].Content) <= maxAttachmentSize {
+			return Response{}, &UnsupportedImageError{err: statusErr, mimeType: images[0].MimeType, content: images[0].Content}
+		}
+		if res.StatusCode == http.StatusBadRequest && request.ResponseFormat != nil && isUnsupportedResponseFormat(resBody) {
+			return Response{}, fmt.Errorf("%w: %w", errFormatNotSupported, statusErr)
+		}
+
+		return Response{}, statusErr
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return Response{}, fmt.Errorf("reading response body: %w", err)
 	}
 
+	var response Response
+	if err = json.Unmarshal(body, &response); err != nil {
+		return Response{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response, nil
+}
+
+// doCompletion sends the chat completion request and returns the response,
+// whatever its status
+func (c *OpenAI) doCompletion(ctx context.Context, request Request) (*http.Response, error) {
 	body, err := json.Marshal(request)
 	if err != nil {
 		return nil, fmt.Errorf("marshaling body: %w", err)
 	}
 
+	endpoint, err := c.endpoint("chat/completions")
+	if err != nil {
+		return nil, err
+	}
+
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		"https://api.openai.com/v1/chat/completions",
+		endpoint,
 		bytes.NewReader(body),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("creating request: %w", err)
 	}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey))
+	req.Header = c.header()
 	req.Header.Set("Content-Type", "application/json")
 
 	res, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("doing request: %w", err)
 	}
+	return res, nil
+}
 
-	defer func() { _ = res.Body.Close() }()
-	if res.StatusCode != 200 {
-		resBody, _ := io.ReadAll(res.Body)
-		statusErr := fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
-
-		if image != nil && isUnsupportedImageFormat(resBody) && len(image.Content) <= maxAttachmentSize {
-			return nil, &UnsupportedImageError{err: statusErr, mimeType: image.MimeType, content: image.Content}
+// completionRequest returns the chat completion request of the prompts and
+// the optional images
+func completionRequest(model, system, user string, images []ImageData, rf ResponseFormat) Request {
+	var userContent any
+	if len(images) > 0 {
+		// Multi-modal content with text and images
+		parts := []ContentPart{{Type: "text", Text: user}}
+		for _, image := range images {
+			b64 := base64.StdEncoding.EncodeToString(image.Content)
+			dataURL := fmt.Sprintf("data:%s;base64,%s", image.MimeType, b64)
+			detail := image.Detail
+			if detail == "" {
+				detail = ImageDetailLow // saves tokens
+			}
+			parts = append(parts, ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: dataURL, Detail: string(detail)}})
 		}
-
-		return nil, statusErr
+		userContent = parts
+	} else {
+		userContent = user
 	}
 
-	body, err = io.ReadAll(res.Body)
-	if err != nil {
-		return nil, fmt.Errorf("reading response body: %w", err)
+	request := Request{
+		Model: model,
+		Messages: []Message{
+			{
+				Role:    RoleSystem,
+				Content: system,
+			},
+			{
+				Role:    RoleUser,
+				Content: userContent,
+			},
+		},
+		ResponseFormat: rf,
 	}
 
-	var response Response
-	if err = json.Unmarshal(body, &response); err != nil {
-		return nil, fmt.Errorf("failed to decode response: %w", err)
+	// Only add reasoning effort for non-vision models
+	if len(images) == 0 {
+		request.ReasoningEffort = ReasoningEffortMedium
 	}
 
+	return request
+}
+
+// decodeCompletion decodes the content of a chat completion response into
+// result
+func decodeCompletion(response Response, result any) (*Usage, error) {
+	response.Usage.Model = response.Model
+
 	if len(response.Choices) == 0 {
 		return &response.Usage, fmt.Errorf("empty choices in response")
 	}
@@ -184,46 +414,64 @@ func (c *OpenAI) getCompletion(ctx context.Context, model, system, user string,
 		return &response.Usage, fmt.Errorf("unexpected finish reason: %v", choice.FinishReason)
 	}
 
-	if err = json.Unmarshal([]byte(choice.Message.Content), result); err != nil {
-		return &response.Usage, fmt.Errorf("unmarshal response content: %w", err)
+	if err := json.Unmarshal([]byte(extractJSON(choice.Message.Content)), result); err != nil {
+		return &response.Usage, fmt.Errorf("%w: unmarshal response content: %w", ErrInvalidResponse, err)
 	}
 
 	return &response.Usage, nil
 }
 
+// endpoint returns the URL of the API path under the base URL
+func (c *OpenAI) endpoint(path string) (string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing base url: %w", err)
+	}
+	return u.JoinPath(path).String(), nil
+}
+
+// header authenticates requests, Azure OpenAI takes the key in its own
+// header
+func (c *OpenAI) header() http.Header {
+	if u, err := url.Parse(c.baseURL); err == nil && strings.HasSuffix(u.Hostname(), ".azure.com") {
+		return http.Header{"Api-Key": {c.apiKey}}
+	}
+	return http.Header{"Authorization": {"Bearer " + c.apiKey}}
+}
+
+// SpamCheck is the AI's report on a message, its response format is derived
+// from it
 type SpamCheck struct {
-	IsSpam bool   `json:"is_spam"`
-	Note   string `json:"note"`
+	IsSpam     bool    `json:"is_spam" description:"true if the message is spam, false otherwise"`
+	Category   string  `json:"category" enum:"none,crypto_scam,job_scam,adult,gambling,phishing,ads,flood,other" description:"spam category if message is spam, none otherwise"`
+	NSFW       bool    `json:"nsfw" description:"true if the attached image is sexually explicit or shows graphic violence, false otherwise or if there is no image"`
+	Confidence float64 `json:"confidence" minimum:"0" maximum:"1" description:"confidence in the is_spam verdict, from 0 (a guess) to 1 (certain)"`
+	Note       string  `json:"note" description:"if message is spam, this field contains short description of reason why it is spam"`
 }
 
+// NSFWCheck is the AI's report on an image, its response format is derived
+// from it
+type NSFWCheck struct {
+	NSFW bool   `json:"nsfw" description:"true if the image is sexually explicit or shows graphic violence, false otherwise"`
+	Note string `json:"note" description:"if image is nsfw, this field contains short description of what makes it nsfw"`
+}
+
+// ResponseFormat is a response_format of a chat completion request in the
+// OpenAI form, see NewResponseFormat
 type ResponseFormat string
 
 func (rf ResponseFormat) MarshalJSON() ([]byte, error) {
 	return []byte(rf), nil
 }
 
-var SpamCheckFormat ResponseFormat = `{
-  "type": "json_schema",
-  "json_schema": {
-    "name": "spam_check_response",
-    "schema": {
-      "type": "object",
-      "properties": {
-        "is_spam": {
-          "type": "boolean",
-		  "description": "true if the message is spam, false otherwise"
-        },
-		"note": {
-		  "type": "string",
-		  "description": "if message is spam, this field contains short description of reason why it is spam"
-		}
-      },
-      "required": ["is_spam", "note"],
-      "additionalProperties": false
-    },
-    "strict": true
-  }
-}`
+var (
+	SpamCheckFormat = MustResponseFormat("spam_check_response", SpamCheck{})
+	NSFWCheckFormat = MustResponseFormat("nsfw_check_response", NSFWCheck{})
+)
 
+const DefaultBaseURL = "https://api.openai.com/v1"
 const DefaultModel = "gpt-5-mini"
 const VisionModel = "gpt-5-mini" // same model, supports vision/image analysis
+const EmbeddingModel = "text-embedding-3-small"
+const TranscriptionModel = "gpt-4o-mini-transcribe"
+const DefaultMaxAudioDuration = 5 * time.Minute
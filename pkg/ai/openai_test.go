@@ -3,6 +3,7 @@ package ai
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -34,7 +35,7 @@ const unsupportedFormatBody = `{
 func TestGetJSONCompletionWithImage_UnsupportedFormat(t *testing.T) {
 	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
 		return jsonResponse(400, unsupportedFormatBody), nil
-	}))
+	}), OpenAIOptions{})
 
 	var result SpamCheck
 	_, err := client.GetJSONCompletionWithImage(context.Background(), "sys", "user", []byte("not really a webp"), "image/webp", SpamCheckFormat, &result)
@@ -61,7 +62,7 @@ func TestGetJSONCompletionWithImage_UnsupportedFormat(t *testing.T) {
 func TestUnsupportedImageError_SurvivesWrapping(t *testing.T) {
 	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
 		return jsonResponse(400, unsupportedFormatBody), nil
-	}))
+	}), OpenAIOptions{})
 
 	var result SpamCheck
 	_, err := client.GetJSONCompletionWithImage(context.Background(), "sys", "user", []byte("x"), "image/webp", SpamCheckFormat, &result)
@@ -80,7 +81,7 @@ func TestUnsupportedImageError_SurvivesWrapping(t *testing.T) {
 func TestGetJSONCompletionWithImage_UnsupportedFormatTooLarge(t *testing.T) {
 	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
 		return jsonResponse(400, unsupportedFormatBody), nil
-	}))
+	}), OpenAIOptions{})
 
 	huge := make([]byte, maxAttachmentSize+1)
 
@@ -99,7 +100,7 @@ func TestGetJSONCompletionWithImage_UnsupportedFormatTooLarge(t *testing.T) {
 func TestGetJSONCompletionWithImage_OtherErrorNotWrapped(t *testing.T) {
 	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
 		return jsonResponse(500, `{"error":{"message":"server error","type":"server_error","code":""}}`), nil
-	}))
+	}), OpenAIOptions{})
 
 	var result SpamCheck
 	_, err := client.GetJSONCompletionWithImage(context.Background(), "sys", "user", []byte("content"), "image/webp", SpamCheckFormat, &result)
@@ -112,3 +113,187 @@ func TestGetJSONCompletionWithImage_OtherErrorNotWrapped(t *testing.T) {
 		t.Fatal("expected an error, got nil")
 	}
 }
+
+func TestOpenAI_Options(t *testing.T) {
+	tests := []struct {
+		name       string
+		opts       OpenAIOptions
+		wantURL    string
+		wantHeader string
+		wantModel  string
+	}{
+		{
+			name:       "defaults",
+			wantURL:    "https://api.openai.com/v1/chat/completions",
+			wantHeader: "Authorization",
+			wantModel:  DefaultModel,
+		},
+		{
+			name:       "gateway",
+			opts:       OpenAIOptions{BaseURL: "https://openrouter.ai/api/v1/", Model: "openai/gpt-5-nano"},
+			wantURL:    "https://openrouter.ai/api/v1/chat/completions",
+			wantHeader: "Authorization",
+			wantModel:  "openai/gpt-5-nano",
+		},
+		{
+			name:       "azure",
+			opts:       OpenAIOptions{BaseURL: "https://res.openai.azure.com/openai/v1?api-version=preview", Model: "my-deployment"},
+			wantURL:    "https://res.openai.azure.com/openai/v1/chat/completions?api-version=preview",
+			wantHeader: "Api-Key",
+			wantModel:  "my-deployment",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var (
+				req     *http.Request
+				reqBody map[string]any
+			)
+			client := NewOpenAI("key", capture(`{"choices": [{"finish_reason": "stop", "message": {"content": "{}"}}]}`, &req, &reqBody), tc.opts)
+
+			var result NSFWCheck
+			if _, err := client.GetJSONCompletion(context.Background(), "sys", "user", NSFWCheckFormat, &result); err != nil {
+				t.Fatalf("GetJSONCompletion: %v", err)
+			}
+
+			if req.URL.String() != tc.wantURL {
+				t.Errorf("url = %s, want %s", req.URL, tc.wantURL)
+			}
+			if req.Header.Get(tc.wantHeader) == "" {
+				t.Errorf("header = %v, want the key in %s", req.Header, tc.wantHeader)
+			}
+			if reqBody["model"] != tc.wantModel {
+				t.Errorf("model = %v, want %s", reqBody["model"], tc.wantModel)
+			}
+		})
+	}
+}
+
+func TestOpenAI_Moderate(t *testing.T) {
+	var (
+		req     *http.Request
+		reqBody map[string]any
+	)
+	client := NewOpenAI("key", capture(`{
+		"results": [{
+			"flagged": true,
+			"categories": {"sexual": true, "harassment": true, "violence": false},
+			"category_scores": {"sexual": 0.91, "harassment": 0.55, "violence": 0.01}
+		}]
+	}`, &req, &reqBody), OpenAIOptions{})
+
+	m, err := client.Moderate(context.Background(), "text")
+	if err != nil {
+		t.Fatalf("Moderate: %v", err)
+	}
+	if !m.Flagged || len(m.Categories) != 2 || m.Categories[0] != "harassment" {
+		t.Errorf("moderation = %+v", m)
+	}
+	if category, score := m.TopCategory(); category != "sexual" || score != 0.91 {
+		t.Errorf("TopCategory = %s, %v, want sexual", category, score)
+	}
+	if req.URL.Path != "/v1/moderations" || reqBody["model"] != ModerationModel || reqBody["input"] != "text" {
+		t.Errorf("request = %s %v", req.URL, reqBody)
+	}
+}
+
+func TestGetJSONCompletionWithImage_Detail(t *testing.T) {
+	var body string
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+		return jsonResponse(200, spamCompletion), nil
+	}), OpenAIOptions{})
+
+	var result SpamCheck
+	for _, tc := range []struct {
+		opts []CallOption
+		want string
+	}{
+		{want: `"detail":"low"`},
+		{opts: []CallOption{WithImageDetail(ImageDetailHigh)}, want: `"detail":"high"`},
+	} {
+		if _, err := client.GetJSONCompletionWithImage(context.Background(), "sys", "user", []byte("png"), "image/png", SpamCheckFormat, &result, tc.opts...); err != nil {
+			t.Fatalf("GetJSONCompletionWithImage: %v", err)
+		}
+		if !strings.Contains(body, tc.want) {
+			t.Errorf("request = %s, want %s", body, tc.want)
+		}
+	}
+}
+
+func TestGetJSONCompletionWithImages(t *testing.T) {
+	var parts []ContentPart
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		data, _ := io.ReadAll(r.Body)
+		var request struct {
+			Messages []struct {
+				Content json.RawMessage `json:"content"`
+			} `json:"messages"`
+		}
+		_ = json.Unmarshal(data, &request)
+		_ = json.Unmarshal(request.Messages[1].Content, &parts)
+		return jsonResponse(400, unsupportedFormatBody), nil
+	}), OpenAIOptions{})
+
+	images := []ImageData{
+		{Content: []byte("one"), MimeType: "image/jpeg"},
+		{Content: []byte("two"), MimeType: "image/png", Detail: ImageDetailHigh},
+	}
+	var result SpamCheck
+	_, err := client.GetJSONCompletionWithImages(context.Background(), "sys", "album", images, SpamCheckFormat, &result, WithImageDetail(ImageDetailAuto))
+
+	// Which of the images is unsupported is unknown
+	var target *UnsupportedImageError
+	if err == nil || errors.As(err, &target) {
+		t.Errorf("err = %v, want a plain error", err)
+	}
+
+	if len(parts) != 3 || parts[0].Text != "album" {
+		t.Fatalf("parts = %+v, want the text and both images", parts)
+	}
+	if parts[1].ImageURL.Detail != "auto" || parts[2].ImageURL.Detail != "high" {
+		t.Errorf("details = %s, %s, want the request's and the image's own", parts[1].ImageURL.Detail, parts[2].ImageURL.Detail)
+	}
+	if images[0].Detail != "" {
+		t.Error("the caller's images changed")
+	}
+
+	if _, err = client.GetJSONCompletionWithImages(context.Background(), "sys", "album", nil, SpamCheckFormat, &result); !errors.Is(err, ErrNoImages) {
+		t.Errorf("err = %v, want ErrNoImages", err)
+	}
+}
+
+func TestGetJSONCompletion_CallOptions(t *testing.T) {
+	var request map[string]any
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		request = nil
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &request)
+		return jsonResponse(200, spamCompletion), nil
+	}), OpenAIOptions{})
+
+	var result SpamCheck
+	_, err := client.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result,
+		WithModel("gpt-5"), WithReasoningEffort(ReasoningEffortHigh), WithTemperature(0), WithMaxTokens(500))
+	if err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if request["model"] != "gpt-5" || request["reasoning_effort"] != "high" {
+		t.Errorf("request = %v, want the model and the effort of the options", request)
+	}
+	if temperature, ok := request["temperature"]; !ok || temperature != 0.0 {
+		t.Errorf("temperature = %v, want a zero set", temperature)
+	}
+	if request["max_completion_tokens"] != 500.0 {
+		t.Errorf("max_completion_tokens = %v, want 500", request["max_completion_tokens"])
+	}
+
+	if _, err = client.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if _, ok := request["temperature"]; ok || request["model"] != DefaultModel {
+		t.Errorf("request = %v, want the client's settings without options", request)
+	}
+}
@@ -0,0 +1,73 @@
+package ai
+
+// CallOptions override settings of the client for one request, zero fields
+// keep the client's. Providers ignore the options they have no use for,
+// e.g. only OpenAI models take a reasoning effort and an image detail.
+type CallOptions struct {
+	// Model replaces the model, or the vision model for requests with
+	// images
+	Model string
+
+	// ReasoningEffort replaces the effort of reasoning models, by default
+	// medium for text and none for images
+	ReasoningEffort ReasoningEffort
+
+	// Temperature is the sampling temperature, nil keeps the model's
+	Temperature *float64
+
+	// MaxTokens caps the tokens of the completion, reasoning included
+	MaxTokens int
+
+	// ImageDetail replaces the detail the model sees images at
+	ImageDetail ImageDetail
+}
+
+// CallOption sets an option of a request
+type CallOption func(*CallOptions)
+
+// WithModel has the request served by the model, empty keeps the client's
+func WithModel(model string) CallOption {
+	return func(o *CallOptions) { o.Model = model }
+}
+
+// WithReasoningEffort has the model reason with the effort, e.g. minimal for
+// a cheap first look and high for a second one
+func WithReasoningEffort(effort ReasoningEffort) CallOption {
+	return func(o *CallOptions) { o.ReasoningEffort = effort }
+}
+
+// WithTemperature sets the sampling temperature
+func WithTemperature(temperature float64) CallOption {
+	return func(o *CallOptions) { o.Temperature = &temperature }
+}
+
+// WithMaxTokens caps the tokens of the completion
+func WithMaxTokens(n int) CallOption {
+	return func(o *CallOptions) { o.MaxTokens = n }
+}
+
+// WithImageDetail has the model see the images of the request at the detail
+func WithImageDetail(detail ImageDetail) CallOption {
+	return func(o *CallOptions) { o.ImageDetail = detail }
+}
+
+// callOptions returns the options set, later ones win
+func callOptions(opts []CallOption) CallOptions {
+	var o CallOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// apply sets the options in an OpenAI request
+func (o CallOptions) apply(request *Request) {
+	if o.Model != "" {
+		request.Model = o.Model
+	}
+	if o.ReasoningEffort != "" {
+		request.ReasoningEffort = o.ReasoningEffort
+	}
+	request.Temperature = o.Temperature
+	request.MaxCompletionTokens = o.MaxTokens
+}
@@ -0,0 +1,76 @@
+package ai
+
+import "strings"
+
+// Price is the price of a model in USD per million tokens
+type Price struct {
+	Prompt     float64
+	Completion float64
+}
+
+// Prices of known models. A dated snapshot, e.g. gpt-5-mini-2025-08-07, is
+// priced as its model.
+var Prices = map[string]Price{
+	"gpt-5":        {Prompt: 1.25, Completion: 10},
+	"gpt-5-mini":   {Prompt: 0.25, Completion: 2},
+	"gpt-5-nano":   {Prompt: 0.05, Completion: 0.4},
+	"gpt-4.1":      {Prompt: 2, Completion: 8},
+	"gpt-4.1-mini": {Prompt: 0.4, Completion: 1.6},
+	"gpt-4o":       {Prompt: 2.5, Completion: 10},
+	"gpt-4o-mini":  {Prompt: 0.15, Completion: 0.6},
+
+	"text-embedding-3-small": {Prompt: 0.02},
+	"text-embedding-3-large": {Prompt: 0.13},
+
+	// Transcription models, priced by their audio tokens
+	"gpt-4o-transcribe":      {Prompt: 6, Completion: 10},
+	"gpt-4o-mini-transcribe": {Prompt: 3, Completion: 5},
+
+	"claude-haiku-4-5":  {Prompt: 1, Completion: 5},
+	"claude-sonnet-4-5": {Prompt: 3, Completion: 15},
+
+	"gemini-2.5-flash":      {Prompt: 0.3, Completion: 2.5},
+	"gemini-2.5-flash-lite": {Prompt: 0.1, Completion: 0.4},
+	"gemini-2.5-pro":        {Prompt: 1.25, Completion: 10},
+
+	// The fake provider is free
+	FakeModel: {},
+}
+
+// batchDiscount is the share of the price paid for usage of the Batch API
+const batchDiscount = 0.5
+
+// Cost returns the cost of the usage in USD, false if the price of the model
+// is unknown
+func (u Usage) Cost() (float64, bool) {
+	price, ok := modelPrice(u.Model)
+	if !ok {
+		return 0, false
+	}
+
+	cost := (float64(u.PromptTokens)*price.Prompt + float64(u.CompletionTokens)*price.Completion) / 1e6
+	if u.Batch {
+		cost *= batchDiscount
+	}
+	return cost, true
+}
+
+// modelPrice looks the model up in Prices, falling back to the longest known
+// model name the model is a snapshot of
+func modelPrice(model string) (Price, bool) {
+	if price, ok := Prices[model]; ok {
+		return price, true
+	}
+
+	var (
+		best  string
+		price Price
+	)
+	for name, p := range Prices {
+		if strings.HasPrefix(model, name+"-") && len(name) > len(best) {
+			best, price = name, p
+		}
+	}
+
+	return price, best != ""
+}
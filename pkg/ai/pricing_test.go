@@ -0,0 +1,41 @@
+package ai
+
+import (
+	"math"
+	"testing"
+)
+
+func TestUsage_Cost(t *testing.T) {
+	tests := []struct {
+		name     string
+		usage    Usage
+		wantCost float64
+		wantOK   bool
+	}{
+		{
+			name:     "known model",
+			usage:    Usage{Model: "gpt-5-mini", PromptTokens: 1_000_000, CompletionTokens: 500_000},
+			wantCost: 1.25, wantOK: true,
+		},
+		{
+			name:     "dated snapshot priced as its model",
+			usage:    Usage{Model: "gpt-5-mini-2025-08-07", PromptTokens: 2000, CompletionTokens: 100},
+			wantCost: 0.0007, wantOK: true,
+		},
+		{
+			name:     "snapshot of the longest matching model",
+			usage:    Usage{Model: "gpt-5-nano-2025-08-07", PromptTokens: 1_000_000},
+			wantCost: 0.05, wantOK: true,
+		},
+		{name: "unknown model", usage: Usage{Model: "llama", PromptTokens: 1000}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cost, ok := tc.usage.Cost()
+			if ok != tc.wantOK || math.Abs(cost-tc.wantCost) > 1e-9 {
+				t.Errorf("Cost() = %v, %v, want %v, %v", cost, ok, tc.wantCost, tc.wantOK)
+			}
+		})
+	}
+}
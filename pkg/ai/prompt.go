@@ -0,0 +1,13 @@
+package ai
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// PromptVersion returns a short content hash identifying a prompt in
+// decision traces
+func PromptVersion(prompt string) string {
+	sum := sha256.Sum256([]byte(prompt))
+	return hex.EncodeToString(sum[:4])
+}
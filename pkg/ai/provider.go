@@ -0,0 +1,210 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Provider is an LLM API. Results of completions are decoded from JSON
+// matching the response format into result.
+type Provider interface {
+	GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error)
+	GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error)
+
+	// GetJSONCompletionWithImages is GetJSONCompletionWithImage with several
+	// images seen together, e.g. the photos of an album, for one verdict on
+	// them all in one request
+	GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error)
+
+	// GetEmbeddings returns the embedding vectors of the texts, in order.
+	// Usage.Model is the model that made them.
+	GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error)
+}
+
+// ErrNoImages is returned for a request with images without any
+var ErrNoImages = errors.New("no images in the request")
+
+// ErrEmbeddingsNotSupported is returned by providers without an embeddings
+// API
+var ErrEmbeddingsNotSupported = errors.New("embeddings are not supported by the provider")
+
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderGemini    = "gemini"
+)
+
+// ProviderOptions select and configure a provider
+type ProviderOptions struct {
+	// Name is one of ProviderOpenAI, ProviderAnthropic, ProviderGemini and
+	// ProviderFake
+	Name   string
+	APIKey string
+
+	// BaseURL overrides the provider's API root, e.g. for an OpenAI-compatible
+	// gateway
+	BaseURL string
+
+	// Model overrides the provider's default model for completions
+	Model string
+
+	// VisionModel overrides the model for completions with images, defaults
+	// to Model
+	VisionModel string
+
+	// ResponseFormat is how OpenAI-compatible models are asked for JSON,
+	// defaults to FormatAuto
+	ResponseFormat FormatStrategy
+
+	// EmbeddingModel overrides the provider's default embedding model
+	EmbeddingModel string
+
+	// EmbeddingDimensions shortens embeddings to the number of dimensions
+	// for models that support it, zero keeps the model's
+	EmbeddingDimensions int
+
+	// Retry is applied to requests, zero disables retries
+	Retry RetryPolicy
+
+	// RateLimit is applied to requests, zero doesn't limit them
+	RateLimit RateLimit
+
+	// Budget refuses requests once it's used up, nil doesn't cap usage
+	Budget *BudgetMeter
+
+	// Breaker stops calling the provider while it keeps failing, zero
+	// doesn't
+	Breaker CircuitBreaker
+
+	// Metrics record the calls of the provider, nil doesn't
+	Metrics *Metrics
+
+	// FakeKeywords mark texts as spam for ProviderFake, defaults to
+	// DefaultFakeKeywords
+	FakeKeywords []string
+}
+
+// NewProvider returns the provider named by the options
+func NewProvider(opts ProviderOptions, httpClient HTTPClient) (Provider, error) {
+	if opts.VisionModel == "" {
+		opts.VisionModel = opts.Model
+	}
+	httpClient = WithRetries(httpClient, opts.Retry)
+
+	var p Provider
+	switch opts.Name {
+	case ProviderOpenAI, "":
+		p = NewOpenAI(opts.APIKey, httpClient, OpenAIOptions{
+			BaseURL:             opts.BaseURL,
+			Model:               opts.Model,
+			VisionModel:         opts.VisionModel,
+			EmbeddingModel:      opts.EmbeddingModel,
+			EmbeddingDimensions: opts.EmbeddingDimensions,
+			ResponseFormat:      opts.ResponseFormat,
+		})
+	case ProviderAnthropic:
+		c := NewAnthropic(opts.APIKey, httpClient)
+		c.configure(opts)
+		p = c
+	case ProviderGemini:
+		c := NewGemini(opts.APIKey, httpClient)
+		c.configure(opts)
+		p = c
+	case ProviderFake:
+		keywords := opts.FakeKeywords
+		if len(keywords) == 0 {
+			keywords = DefaultFakeKeywords
+		}
+		p = NewFake(keywords...)
+	default:
+		return nil, fmt.Errorf("unknown ai provider %q, known: %s, %s, %s, %s", opts.Name, ProviderOpenAI, ProviderAnthropic, ProviderGemini, ProviderFake)
+	}
+
+	name := opts.Name
+	if name == "" {
+		name = ProviderOpenAI
+	}
+	p = WithCircuitBreaker(WithMetrics(p, name, opts.Metrics), opts.Breaker)
+	p = WithBudget(WithRateLimit(p, NewLimiter(opts.RateLimit)), opts.Budget)
+	return WithValidation(p), nil
+}
+
+// DefaultModelOf returns the model the provider uses if none is given, empty
+// for an unknown provider
+func DefaultModelOf(name string) string {
+	switch name {
+	case ProviderOpenAI, "":
+		return DefaultModel
+	case ProviderAnthropic:
+		return AnthropicModel
+	case ProviderGemini:
+		return GeminiModel
+	case ProviderFake:
+		return FakeModel
+	}
+	return ""
+}
+
+// jsonSchema returns the name and the JSON schema of a response format given
+// in the OpenAI json_schema form, for providers taking a bare schema
+func (rf ResponseFormat) jsonSchema() (string, json.RawMessage, error) {
+	var format struct {
+		JSONSchema struct {
+			Name   string          `json:"name"`
+			Schema json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	}
+	if err := json.Unmarshal([]byte(rf), &format); err != nil {
+		return "", nil, fmt.Errorf("parsing response format: %w", err)
+	}
+	if format.JSONSchema.Name == "" || len(format.JSONSchema.Schema) == 0 {
+		return "", nil, errors.New("response format has no json schema")
+	}
+
+	return format.JSONSchema.Name, format.JSONSchema.Schema, nil
+}
+
+// postJSON sends the request body as JSON and decodes the JSON response into
+// response
+func postJSON(ctx context.Context, httpClient HTTPClient, url string, header http.Header, request, response any) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("marshaling body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("doing request: %w", err)
+	}
+
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != 200 {
+		resBody, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
+	}
+
+	body, err = io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("reading response body: %w", err)
+	}
+
+	if err = json.Unmarshal(body, response); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
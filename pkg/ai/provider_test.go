@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// capture answers with the body and keeps the request and its decoded body
+func capture(body string, req **http.Request, reqBody *map[string]any) roundTripFunc {
+	return func(r *http.Request) (*http.Response, error) {
+		*req = r
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, reqBody)
+		return jsonResponse(200, body), nil
+	}
+}
+
+func TestAnthropic_GetJSONCompletionWithImage(t *testing.T) {
+	var (
+		req     *http.Request
+		reqBody map[string]any
+	)
+	client := NewAnthropic("key", capture(`{
+		"model": "claude-haiku-4-5-20251001",
+		"stop_reason": "tool_use",
+		"content": [{"type": "tool_use", "name": "spam_check_response", "input": {"is_spam": true, "category": "ads", "confidence": 0.9, "note": "ad"}}],
+		"usage": {"input_tokens": 100, "output_tokens": 20}
+	}`, &req, &reqBody))
+
+	var result SpamCheck
+	usage, err := client.GetJSONCompletionWithImage(context.Background(), "sys", "user", []byte("img"), "image/png", SpamCheckFormat, &result)
+	if err != nil {
+		t.Fatalf("GetJSONCompletionWithImage: %v", err)
+	}
+
+	if !result.IsSpam || result.Category != "ads" {
+		t.Errorf("result = %+v", result)
+	}
+	if usage.TotalTokens != 120 || usage.Model != "claude-haiku-4-5-20251001" {
+		t.Errorf("usage = %+v", usage)
+	}
+	if _, ok := usage.Cost(); !ok {
+		t.Error("claude model not priced")
+	}
+
+	if req.URL.String() != "https://api.anthropic.com/v1/messages" || req.Header.Get("X-Api-Key") != "key" || req.Header.Get("Anthropic-Version") == "" {
+		t.Errorf("request = %s %v", req.URL, req.Header)
+	}
+	if choice, _ := reqBody["tool_choice"].(map[string]any); choice["name"] != "spam_check_response" {
+		t.Errorf("tool_choice = %v, want the response format forced", reqBody["tool_choice"])
+	}
+	if !strings.Contains(toJSON(reqBody["messages"]), `"media_type":"image/png"`) {
+		t.Errorf("messages = %s, want the image", toJSON(reqBody["messages"]))
+	}
+}
+
+func TestAnthropic_NoToolCall(t *testing.T) {
+	var (
+		req     *http.Request
+		reqBody map[string]any
+	)
+	client := NewAnthropic("key", capture(`{"stop_reason": "max_tokens", "content": []}`, &req, &reqBody))
+
+	var result SpamCheck
+	if _, err := client.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &result); err == nil {
+		t.Error("GetJSONCompletion succeeded without a tool call")
+	}
+}
+
+func TestGemini_GetJSONCompletion(t *testing.T) {
+	var (
+		req     *http.Request
+		reqBody map[string]any
+	)
+	client := NewGemini("key", capture(`{
+		"modelVersion": "gemini-2.5-flash",
+		"candidates": [{"finishReason": "STOP", "content": {"parts": [{"text": "{\"nsfw\": true, \"note\": \"nudity\"}"}]}}],
+		"usageMetadata": {"promptTokenCount": 50, "candidatesTokenCount": 5, "totalTokenCount": 55}
+	}`, &req, &reqBody))
+
+	var result NSFWCheck
+	usage, err := client.GetJSONCompletion(context.Background(), "sys", "user", NSFWCheckFormat, &result)
+	if err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+
+	if !result.NSFW || result.Note != "nudity" {
+		t.Errorf("result = %+v", result)
+	}
+	if usage.TotalTokens != 55 || usage.Model != "gemini-2.5-flash" {
+		t.Errorf("usage = %+v", usage)
+	}
+
+	if !strings.HasSuffix(req.URL.Path, "/models/gemini-2.5-flash:generateContent") || req.Header.Get("X-Goog-Api-Key") != "key" {
+		t.Errorf("request = %s %v", req.URL, req.Header)
+	}
+	config, _ := reqBody["generationConfig"].(map[string]any)
+	if schema, _ := config["responseJsonSchema"].(map[string]any); schema["type"] != "object" {
+		t.Errorf("generationConfig = %v, want the bare schema", config)
+	}
+}
+
+func TestOpenAI_GetEmbeddings(t *testing.T) {
+	var (
+		req     *http.Request
+		reqBody map[string]any
+	)
+	client := NewOpenAI("key", capture(`{
+		"model": "text-embedding-3-small",
+		"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}],
+		"usage": {"prompt_tokens": 4, "total_tokens": 4}
+	}`, &req, &reqBody), OpenAIOptions{})
+
+	vectors, usage, err := client.GetEmbeddings(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("GetEmbeddings: %v", err)
+	}
+	if len(vectors) != 2 || vectors[0][0] != 1 || vectors[1][1] != 1 {
+		t.Errorf("vectors = %v, want them in the order of the texts", vectors)
+	}
+	if usage.Model != "text-embedding-3-small" || usage.PromptTokens != 4 {
+		t.Errorf("usage = %+v", usage)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	p, err := NewProvider(ProviderOptions{Name: ProviderAnthropic, APIKey: "key", Model: "claude-sonnet-4-5"}, nil)
+	if err != nil {
+		t.Fatalf("NewProvider: %v", err)
+	}
+	v, ok := p.(*validatingProvider)
+	if !ok {
+		t.Fatalf("provider = %#v, want its results validated", p)
+	}
+	if c, ok := v.Provider.(*Anthropic); !ok || c.model != "claude-sonnet-4-5" {
+		t.Errorf("provider = %#v, want anthropic with the model", v.Provider)
+	}
+
+	if _, _, err = p.GetEmbeddings(context.Background(), []string{"a"}); err != ErrEmbeddingsNotSupported {
+		t.Errorf("GetEmbeddings = %v, want ErrEmbeddingsNotSupported", err)
+	}
+
+	if _, err = NewProvider(ProviderOptions{Name: "llama"}, nil); err == nil {
+		t.Error("NewProvider of an unknown provider succeeded")
+	}
+}
+
+func toJSON(v any) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
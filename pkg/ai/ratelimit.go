@@ -0,0 +1,256 @@
+package ai
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimit caps the rate of requests to a provider, zero fields don't limit
+type RateLimit struct {
+	RequestsPerMinute int
+
+	// TokensPerMinute counts prompt and completion tokens. A request is
+	// charged an estimate of its prompt up front and the difference to the
+	// usage reported by the provider once it's done.
+	TokensPerMinute int
+}
+
+// imageTokens is the estimate of an image's tokens, the order of a low
+// detail image
+const imageTokens = 1000
+
+// Limiter is a pair of token buckets of requests and tokens, refilled
+// continuously and holding up to a minute's worth. Share one limiter among
+// everything calling the provider with the same key.
+type Limiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewLimiter returns the limiter of the rate, nil if it doesn't limit
+func NewLimiter(limit RateLimit) *Limiter {
+	if limit.RequestsPerMinute <= 0 && limit.TokensPerMinute <= 0 {
+		return nil
+	}
+
+	l := &Limiter{now: time.Now, sleep: sleep}
+	now := l.now()
+	if limit.RequestsPerMinute > 0 {
+		l.requests = newBucket(limit.RequestsPerMinute, now)
+	}
+	if limit.TokensPerMinute > 0 {
+		l.tokens = newBucket(limit.TokensPerMinute, now)
+	}
+	return l
+}
+
+// Wait blocks until a request of the estimated number of tokens may be sent
+// and takes it from the buckets
+func (l *Limiter) Wait(ctx context.Context, tokens int) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		delay := max(l.requests.delay(1, now), l.tokens.delay(float64(tokens), now))
+		if delay <= 0 {
+			l.requests.take(1)
+			l.tokens.take(float64(tokens))
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if err := l.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Adjust charges the tokens a request took beyond its estimate, a negative
+// number returns the overestimate
+func (l *Limiter) Adjust(tokens int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens.refill(l.now())
+	l.tokens.take(float64(tokens))
+}
+
+type bucket struct {
+	capacity  float64
+	perSecond float64
+	available float64
+	updated   time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	return &bucket{
+		capacity:  float64(perMinute),
+		perSecond: float64(perMinute) / 60,
+		available: float64(perMinute),
+		updated:   now,
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if b == nil {
+		return
+	}
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.available = min(b.capacity, b.available+elapsed*b.perSecond)
+	}
+	b.updated = now
+}
+
+// delay returns how long until n are available, n larger than the capacity
+// needs a full bucket. The available amount goes negative when a request
+// took more than estimated.
+func (b *bucket) delay(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.refill(now)
+
+	n = min(n, b.capacity)
+	if b.available >= n {
+		return 0
+	}
+	return time.Duration((n - b.available) / b.perSecond * float64(time.Second))
+}
+
+func (b *bucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.available = min(b.capacity, b.available-n)
+}
+
+// WithRateLimit returns the provider waiting for the limiter before every
+// request, nil limiter means no waiting
+func WithRateLimit(p Provider, limiter *Limiter) Provider {
+	if limiter == nil {
+		return p
+	}
+	return &limitedProvider{Provider: p, limiter: limiter}
+}
+
+type limitedProvider struct {
+	Provider
+	limiter *Limiter
+}
+
+func (p *limitedProvider) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	estimate := estimateTokens(system, user)
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletion(ctx, system, user, rf, result, opts...)
+	p.settle(estimate, usage)
+	return usage, err
+}
+
+func (p *limitedProvider) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	estimate := estimateTokens(system, user) + imageTokens
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletionWithImage(ctx, system, user, image, mimeType, rf, result, opts...)
+	p.settle(estimate, usage)
+	return usage, err
+}
+
+func (p *limitedProvider) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	estimate := estimateTokens(system, user) + len(images)*imageTokens
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return nil, err
+	}
+
+	usage, err := p.Provider.GetJSONCompletionWithImages(ctx, system, user, images, rf, result, opts...)
+	p.settle(estimate, usage)
+	return usage, err
+}
+
+func (p *limitedProvider) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	tc, ok := p.Provider.(ToolCaller)
+	if !ok {
+		return nil, ErrToolsNotSupported
+	}
+
+	// Tool calls add rounds the estimate doesn't know of, they're settled
+	// with the usage
+	estimate := estimateTokens(system, user)
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return nil, err
+	}
+
+	usage, err := tc.GetJSONCompletionWithTools(ctx, system, user, tools, rf, result, opts...)
+	p.settle(estimate, usage)
+	return usage, err
+}
+
+func (p *limitedProvider) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	streamer, ok := p.Provider.(Streamer)
+	if !ok {
+		return "", nil, ErrStreamingNotSupported
+	}
+
+	estimate := estimateTokens(system, user)
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return "", nil, err
+	}
+
+	text, usage, err := streamer.StreamCompletion(ctx, system, user, onDelta)
+	p.settle(estimate, usage)
+	return text, usage, err
+}
+
+func (p *limitedProvider) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	transcriber, ok := p.Provider.(Transcriber)
+	if !ok {
+		return "", nil, ErrTranscriptionNotSupported
+	}
+
+	estimate := estimateTokens(opts.Prompt) + int(opts.Duration.Seconds())*audioTokensPerSecond
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return "", nil, err
+	}
+
+	text, usage, err := transcriber.Transcribe(ctx, audio, opts)
+	p.settle(estimate, usage)
+	return text, usage, err
+}
+
+func (p *limitedProvider) GetEmbeddings(ctx context.Context, texts []string) ([][]float32, *Usage, error) {
+	estimate := estimateTokens(texts...)
+	if err := p.limiter.Wait(ctx, estimate); err != nil {
+		return nil, nil, err
+	}
+
+	vectors, usage, err := p.Provider.GetEmbeddings(ctx, texts)
+	p.settle(estimate, usage)
+	return vectors, usage, err
+}
+
+// settle charges the difference between the usage and the estimate, a
+// request without reported usage keeps the estimate
+func (p *limitedProvider) settle(estimate int, usage *Usage) {
+	if usage == nil || usage.TotalTokens == 0 {
+		return
+	}
+	p.limiter.Adjust(usage.TotalTokens - estimate)
+}
+
+// estimateTokens estimates the tokens of the texts, see CountTokens
+func estimateTokens(texts ...string) int {
+	var tokens int
+	for _, text := range texts {
+		tokens += CountTokens(text)
+	}
+	return tokens + 1
+}
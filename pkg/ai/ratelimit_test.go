@@ -0,0 +1,97 @@
+package ai
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock advances when the limiter sleeps
+type fakeClock struct {
+	now    time.Time
+	slept  time.Duration
+	sleeps int
+}
+
+func (c *fakeClock) limiter(limit RateLimit) *Limiter {
+	l := NewLimiter(limit)
+	l.now = func() time.Time { return c.now }
+	l.sleep = func(_ context.Context, d time.Duration) error {
+		c.now = c.now.Add(d)
+		c.slept += d
+		c.sleeps++
+		return nil
+	}
+	l.requests.reset(c.now)
+	l.tokens.reset(c.now)
+	return l
+}
+
+func (b *bucket) reset(now time.Time) {
+	if b != nil {
+		b.updated = now
+	}
+}
+
+func TestLimiter_Requests(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := clock.limiter(RateLimit{RequestsPerMinute: 60})
+	ctx := context.Background()
+
+	// A minute's worth goes at once, the next waits for a refill
+	for range 60 {
+		if err := l.Wait(ctx, 0); err != nil {
+			t.Fatalf("Wait: %v", err)
+		}
+	}
+	if clock.slept != 0 {
+		t.Fatalf("slept %v within the burst", clock.slept)
+	}
+
+	if err := l.Wait(ctx, 0); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if clock.slept != time.Second {
+		t.Errorf("slept %v, want a second for one request at 60/min", clock.slept)
+	}
+}
+
+func TestLimiter_TokensAdjusted(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := clock.limiter(RateLimit{TokensPerMinute: 600})
+	ctx := context.Background()
+
+	if err := l.Wait(ctx, 100); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+
+	// The request took 700 tokens instead of 100: the bucket is 100 in debt
+	l.Adjust(600)
+	if err := l.Wait(ctx, 100); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if want := 20 * time.Second; clock.slept != want {
+		t.Errorf("slept %v, want %v to repay the debt at 10 tokens/s", clock.slept, want)
+	}
+
+	// A request larger than the bucket waits for a full one instead of
+	// forever
+	clock.slept = 0
+	if err := l.Wait(ctx, 10_000); err != nil {
+		t.Fatalf("Wait: %v", err)
+	}
+	if clock.slept != time.Minute {
+		t.Errorf("slept %v, want a minute for a full bucket", clock.slept)
+	}
+}
+
+func TestNewLimiter_Unlimited(t *testing.T) {
+	if l := NewLimiter(RateLimit{}); l != nil {
+		t.Errorf("NewLimiter of no limit = %+v, want nil", l)
+	}
+
+	p := NewGemini("key", nil)
+	if got := WithRateLimit(p, nil); got != Provider(p) {
+		t.Error("WithRateLimit with a nil limiter wrapped the provider")
+	}
+}
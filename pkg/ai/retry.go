@@ -0,0 +1,148 @@
+package ai
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy retries requests failed with a transient error: a network
+// error, a timeout, rate limiting (429) or a server error
+type RetryPolicy struct {
+	// MaxAttempts counts the first attempt too, 1 or less disables retries
+	MaxAttempts int
+
+	// BaseDelay is the delay before the first retry, doubled for every next
+	// one. Delays are jittered: a random duration up to the delay is waited.
+	BaseDelay time.Duration
+
+	// MaxDelay caps the delay. A Retry-After the provider asks for is
+	// honored up to MaxDelay, a longer one fails the request.
+	MaxDelay time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// WithRetries returns the client retrying requests by the policy
+func WithRetries(client HTTPClient, policy RetryPolicy) HTTPClient {
+	if policy.MaxAttempts <= 1 {
+		return client
+	}
+	if policy.BaseDelay <= 0 {
+		policy.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if policy.MaxDelay <= 0 {
+		policy.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	return &retryClient{client: client, policy: policy, sleep: sleep, now: time.Now}
+}
+
+type retryClient struct {
+	client HTTPClient
+	policy RetryPolicy
+	sleep  func(ctx context.Context, d time.Duration) error
+	now    func() time.Time
+}
+
+func (c *retryClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	for attempt := 1; ; attempt++ {
+		r := req
+		if attempt > 1 {
+			r = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				r.Body = body
+			}
+		}
+
+		res, err := c.client.Do(r)
+
+		// A body that can't be sent again can't be retried either
+		canRetry := req.Body == nil || req.GetBody != nil
+		if attempt >= c.policy.MaxAttempts || !canRetry || ctx.Err() != nil || !isTransient(res, err) {
+			return res, err
+		}
+
+		delay := c.policy.backoff(attempt)
+		if res != nil {
+			if after, ok := retryAfter(res.Header.Get("Retry-After"), c.now()); ok {
+				if after > c.policy.MaxDelay {
+					return res, err
+				}
+				delay = after
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+			_ = res.Body.Close()
+		}
+
+		if err = c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the jittered delay before the retry following the attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return rand.N(min(delay, p.MaxDelay)) + 1
+}
+
+// isTransient reports whether the request may succeed if sent again
+func isTransient(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch res.StatusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusInternalServerError,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+		529: // Anthropic's overloaded
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, given in seconds or as a date
+func retryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(at.Sub(now), 0), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
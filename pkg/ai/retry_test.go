@@ -0,0 +1,129 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryClient(t *testing.T) {
+	tests := []struct {
+		name         string
+		responses    []*http.Response
+		wantAttempts int
+		wantStatus   int
+		wantDelays   []time.Duration
+	}{
+		{
+			name:         "success",
+			responses:    []*http.Response{jsonResponse(200, "{}")},
+			wantAttempts: 1, wantStatus: 200,
+		},
+		{
+			name:         "server errors then success",
+			responses:    []*http.Response{jsonResponse(500, ""), jsonResponse(503, ""), jsonResponse(200, "{}")},
+			wantAttempts: 3, wantStatus: 200,
+		},
+		{
+			name:         "gives up after max attempts",
+			responses:    []*http.Response{jsonResponse(429, ""), jsonResponse(429, ""), jsonResponse(429, ""), jsonResponse(200, "{}")},
+			wantAttempts: 3, wantStatus: 429,
+		},
+		{
+			name:         "client error is not retried",
+			responses:    []*http.Response{jsonResponse(400, ""), jsonResponse(200, "{}")},
+			wantAttempts: 1, wantStatus: 400,
+		},
+		{
+			name:         "retry-after honored",
+			responses:    []*http.Response{withHeader(jsonResponse(429, ""), "Retry-After", "2"), jsonResponse(200, "{}")},
+			wantAttempts: 2, wantStatus: 200,
+			wantDelays: []time.Duration{2 * time.Second},
+		},
+		{
+			name:         "retry-after longer than max delay",
+			responses:    []*http.Response{withHeader(jsonResponse(429, ""), "Retry-After", "3600"), jsonResponse(200, "{}")},
+			wantAttempts: 1, wantStatus: 429,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var bodies []string
+			fake := roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				res := tc.responses[len(bodies)-1]
+				return res, nil
+			})
+
+			var delays []time.Duration
+			client := WithRetries(fake, RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}).(*retryClient)
+			client.sleep = func(_ context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			req, _ := http.NewRequest(http.MethodPost, "https://example.com", strings.NewReader("payload"))
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("Do: %v", err)
+			}
+
+			if len(bodies) != tc.wantAttempts || res.StatusCode != tc.wantStatus {
+				t.Errorf("attempts = %d, status %d, want %d, %d", len(bodies), res.StatusCode, tc.wantAttempts, tc.wantStatus)
+			}
+			for i, body := range bodies {
+				if body != "payload" {
+					t.Errorf("attempt %d body = %q, want the payload resent", i+1, body)
+				}
+			}
+			if tc.wantDelays != nil && (len(delays) != len(tc.wantDelays) || delays[0] != tc.wantDelays[0]) {
+				t.Errorf("delays = %v, want %v", delays, tc.wantDelays)
+			}
+		})
+	}
+}
+
+func TestRetryClient_NetworkErrorAndCancel(t *testing.T) {
+	var attempts int
+	fake := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		attempts++
+		return nil, errors.New("connection reset")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := WithRetries(fake, RetryPolicy{MaxAttempts: 5}).(*retryClient)
+	client.sleep = func(context.Context, time.Duration) error {
+		cancel()
+		return context.Canceled
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://example.com", nil)
+	if _, err := client.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do = %v, want canceled while waiting", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	p := RetryPolicy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+	for attempt, limit := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 5 * time.Second} {
+		for range 100 {
+			if d := p.backoff(attempt); d <= 0 || d > limit {
+				t.Fatalf("backoff(%d) = %v, want up to %v", attempt, d, limit)
+			}
+		}
+	}
+}
+
+func withHeader(res *http.Response, name, value string) *http.Response {
+	res.Header = http.Header{name: {value}}
+	return res
+}
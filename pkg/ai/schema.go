@@ -0,0 +1,234 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// NewResponseFormat returns the strict json_schema response format of the
+// name whose schema is derived from the type of v, a struct. Properties are
+// named by the fields' json tags and described by their description tags;
+// an enum tag lists the allowed values of a string, comma separated, and
+// minimum and maximum tags bound a number. Every
+// property is required, as strict mode demands, and a pointer field may be
+// null.
+func NewResponseFormat(name string, v any) (ResponseFormat, error) {
+	s, err := objectSchema(v)
+	if err != nil {
+		return "", fmt.Errorf("response format %s: %w", name, err)
+	}
+
+	format := struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Name   string  `json:"name"`
+			Schema *schema `json:"schema"`
+			Strict bool    `json:"strict"`
+		} `json:"json_schema"`
+	}{Type: "json_schema"}
+	format.JSONSchema.Name = name
+	format.JSONSchema.Schema = s
+	format.JSONSchema.Strict = true
+
+	data, err := json.Marshal(format)
+	if err != nil {
+		return "", fmt.Errorf("response format %s: %w", name, err)
+	}
+	return ResponseFormat(data), nil
+}
+
+// MustResponseFormat is NewResponseFormat panicking on an error, for formats
+// of package variables, so a type the generator can't describe fails at
+// startup
+func MustResponseFormat(name string, v any) ResponseFormat {
+	rf, err := NewResponseFormat(name, v)
+	if err != nil {
+		panic(err)
+	}
+	return rf
+}
+
+// objectSchema returns the schema of the type of v, a struct or a pointer to
+// one
+func objectSchema(v any) (*schema, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("%v is not a struct", t)
+	}
+	return schemaOf(t, nil)
+}
+
+// schema is the subset of JSON Schema supported by structured outputs
+type schema struct {
+	Type                 any        `json:"type"` // a type name, or a name and "null"
+	Description          string     `json:"description,omitempty"`
+	Enum                 []string   `json:"enum,omitempty"`
+	Minimum              *float64   `json:"minimum,omitempty"`
+	Maximum              *float64   `json:"maximum,omitempty"`
+	Items                *schema    `json:"items,omitempty"`
+	Properties           properties `json:"properties,omitempty"`
+	Required             []string   `json:"required,omitempty"`
+	AdditionalProperties *bool      `json:"additionalProperties,omitempty"`
+}
+
+// properties keep the order of the fields, the model fills them in it
+type properties []property
+
+type property struct {
+	name   string
+	schema *schema
+}
+
+func (p properties) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, prop := range p {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		name, err := json.Marshal(prop.name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(prop.schema)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(name)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// schemaOf returns the schema of the type, seen are the structs it's nested
+// in, a recursive type can't be described
+func schemaOf(t reflect.Type, seen []reflect.Type) (*schema, error) {
+	switch t.Kind() {
+	case reflect.Bool:
+		return &schema{Type: "boolean"}, nil
+	case reflect.String:
+		return &schema{Type: "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &schema{Type: "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return &schema{Type: "number"}, nil
+	case reflect.Slice, reflect.Array:
+		items, err := schemaOf(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		return &schema{Type: "array", Items: items}, nil
+	case reflect.Pointer:
+		s, err := schemaOf(t.Elem(), seen)
+		if err != nil {
+			return nil, err
+		}
+		typ, ok := s.Type.(string)
+		if !ok {
+			return nil, fmt.Errorf("pointer to a nullable %v", t.Elem())
+		}
+		s.Type = []string{typ, "null"}
+		return s, nil
+	case reflect.Struct:
+		for _, st := range seen {
+			if st == t {
+				return nil, fmt.Errorf("recursive type %v", t)
+			}
+		}
+		return structSchema(t, append(seen, t))
+	}
+
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+func structSchema(t reflect.Type, seen []reflect.Type) (*schema, error) {
+	closed := false
+	s := &schema{Type: "object", AdditionalProperties: &closed}
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		// Fields of an untagged embedded struct are promoted, as
+		// encoding/json does
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			embedded, err := structSchema(f.Type, seen)
+			if err != nil {
+				return nil, err
+			}
+			s.Properties = append(s.Properties, embedded.Properties...)
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+		fs, err := schemaOf(f.Type, seen)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		fs.Description = f.Tag.Get("description")
+		if enum := f.Tag.Get("enum"); enum != "" {
+			if f.Type.Kind() != reflect.String {
+				return nil, fmt.Errorf("field %s: enum of a %v", f.Name, f.Type)
+			}
+			fs.Enum = strings.Split(enum, ",")
+		}
+		if fs.Minimum, err = bound(f, "minimum"); err != nil {
+			return nil, err
+		}
+		if fs.Maximum, err = bound(f, "maximum"); err != nil {
+			return nil, err
+		}
+
+		s.Properties = append(s.Properties, property{name: name, schema: fs})
+		s.Required = append(s.Required, name)
+	}
+
+	if len(s.Properties) == 0 {
+		return nil, fmt.Errorf("%v has no fields", t)
+	}
+	return s, nil
+}
+
+// bound returns the bound of a number field given by the tag, nil if there
+// is none
+func bound(f reflect.StructField, tag string) (*float64, error) {
+	value := f.Tag.Get(tag)
+	if value == "" {
+		return nil, nil
+	}
+
+	switch f.Type.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+	default:
+		return nil, fmt.Errorf("field %s: %s of a %v", f.Name, tag, f.Type)
+	}
+
+	b, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, fmt.Errorf("field %s: %s: %w", f.Name, tag, err)
+	}
+	return &b, nil
+}
@@ -0,0 +1,171 @@
+package ai
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewResponseFormat_Checks(t *testing.T) {
+	// Changes of the structs must not change what the models are asked for
+	// unnoticed
+	tests := []struct {
+		format ResponseFormat
+		want   string
+	}{
+		{SpamCheckFormat, `{
+		  "type": "json_schema",
+		  "json_schema": {
+		    "name": "spam_check_response",
+		    "schema": {
+		      "type": "object",
+		      "properties": {
+		        "is_spam": {
+		          "type": "boolean",
+		          "description": "true if the message is spam, false otherwise"
+		        },
+		        "category": {
+		          "type": "string",
+		          "enum": [
+		            "none",
+		            "crypto_scam",
+		            "job_scam",
+		            "adult",
+		            "gambling",
+		            "phishing",
+		            "ads",
+		            "flood",
+		            "other"
+		          ],
+		          "description": "spam category if message is spam, none otherwise"
+		        },
+		        "nsfw": {
+		          "type": "boolean",
+		          "description": "true if the attached image is sexually explicit or shows graphic violence, false otherwise or if there is no image"
+		        },
+		        "confidence": {
+		          "type": "number",
+		          "minimum": 0,
+		          "maximum": 1,
+		          "description": "confidence in the is_spam verdict, from 0 (a guess) to 1 (certain)"
+		        },
+		        "note": {
+		          "type": "string",
+		          "description": "if message is spam, this field contains short description of reason why it is spam"
+		        }
+		      },
+		      "required": [
+		        "is_spam",
+		        "category",
+		        "nsfw",
+		        "confidence",
+		        "note"
+		      ],
+		      "additionalProperties": false
+		    },
+		    "strict": true
+		  }
+		}`},
+		{NSFWCheckFormat, `{
+		  "type": "json_schema",
+		  "json_schema": {
+		    "name": "nsfw_check_response",
+		    "schema": {
+		      "type": "object",
+		      "properties": {
+		        "nsfw": {
+		          "type": "boolean",
+		          "description": "true if the image is sexually explicit or shows graphic violence, false otherwise"
+		        },
+		        "note": {
+		          "type": "string",
+		          "description": "if image is nsfw, this field contains short description of what makes it nsfw"
+		        }
+		      },
+		      "required": [
+		        "nsfw",
+		        "note"
+		      ],
+		      "additionalProperties": false
+		    },
+		    "strict": true
+		  }
+		}`},
+	}
+
+	for _, tc := range tests {
+		var got, want any
+		if err := json.Unmarshal([]byte(tc.format), &got); err != nil {
+			t.Fatalf("format isn't json: %v", err)
+		}
+		if err := json.Unmarshal([]byte(tc.want), &want); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("format = %s, want %s", tc.format, tc.want)
+		}
+	}
+
+	// Properties keep the order of the fields
+	if i, j := strings.Index(string(SpamCheckFormat), `"is_spam"`), strings.Index(string(SpamCheckFormat), `"note"`); i > j {
+		t.Errorf("properties out of order: %s", SpamCheckFormat)
+	}
+}
+
+func TestNewResponseFormat(t *testing.T) {
+	type Base struct {
+		ID int `json:"id"`
+	}
+	type Item struct {
+		Tags  []string `json:"tags"`
+		Score *float64 `json:"score" description:"null if unknown"`
+	}
+	type Report struct {
+		Base
+		Items    []Item `json:"items"`
+		Internal string `json:"-"`
+		Kind     string `enum:"a,b"`
+		private  bool
+	}
+
+	rf, err := NewResponseFormat("report", &Report{})
+	if err != nil {
+		t.Fatalf("NewResponseFormat: %v", err)
+	}
+	name, schema, err := rf.jsonSchema()
+	if err != nil || name != "report" {
+		t.Fatalf("jsonSchema = %s, %v", name, err)
+	}
+
+	want := `{"type":"object","properties":{"id":{"type":"integer"},"items":{"type":"array","items":{"type":"object",` +
+		`"properties":{"tags":{"type":"array","items":{"type":"string"}},"score":{"type":["number","null"],"description":"null if unknown"}},` +
+		`"required":["tags","score"],"additionalProperties":false}},"Kind":{"type":"string","enum":["a","b"]}},` +
+		`"required":["id","items","Kind"],"additionalProperties":false}`
+	if string(schema) != want {
+		t.Errorf("schema = %s, want %s", schema, want)
+	}
+}
+
+func TestNewResponseFormat_Unsupported(t *testing.T) {
+	type Node struct {
+		Next *Node `json:"next"`
+	}
+	type Count struct {
+		N int `enum:"1,2"`
+	}
+	tests := map[string]any{
+		"not a struct": "text",
+		"map":          struct{ M map[string]int }{},
+		"interface":    struct{ V any }{},
+		"enum of int":  Count{},
+		"no fields":    struct{ n int }{},
+		"recursive":    Node{},
+	}
+
+	for name, v := range tests {
+		if _, err := NewResponseFormat("x", v); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package ai
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Streamer is a provider streaming text completions as they're generated,
+// for interactive uses where waiting for the whole answer is too slow
+type Streamer interface {
+	StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error)
+}
+
+// ErrStreamingNotSupported is returned by providers without streaming
+var ErrStreamingNotSupported = errors.New("streaming is not supported by the provider")
+
+// streamChunk is a chunk of a streamed chat completion, the last one has
+// the usage and no choices
+type streamChunk struct {
+	Model   string `json:"model"`
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		FinishReason FinishReason `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *Usage `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// StreamCompletion returns a plain text completion, passing its parts to
+// onDelta as they arrive. An error of onDelta stops the stream and is
+// returned. The text is what arrived before an error.
+func (c *OpenAI) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	request := completionRequest(c.model, system, user, nil, "")
+	request.ResponseFormat = nil
+	request.Stream = true
+	request.StreamOptions = &StreamOptions{IncludeUsage: true}
+
+	res, err := c.doCompletion(ctx, request)
+	if err != nil {
+		return "", nil, err
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	if res.StatusCode != http.StatusOK {
+		resBody, _ := io.ReadAll(res.Body)
+		return "", nil, fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
+	}
+
+	var (
+		text   strings.Builder
+		usage  *Usage
+		model  string
+		finish FinishReason
+	)
+	err = readEvents(res.Body, func(data []byte) error {
+		var chunk streamChunk
+		if err := json.Unmarshal(data, &chunk); err != nil {
+			return fmt.Errorf("decoding chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return fmt.Errorf("stream failed: %s", chunk.Error.Message)
+		}
+		if chunk.Model != "" {
+			model = chunk.Model
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+
+		for _, choice := range chunk.Choices {
+			if choice.FinishReason != FinishReasonNull {
+				finish = choice.FinishReason
+			}
+			if choice.Delta.Content == "" {
+				continue
+			}
+			text.WriteString(choice.Delta.Content)
+			if err := onDelta(choice.Delta.Content); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if usage != nil {
+		usage.Model = model
+	}
+	if err != nil {
+		return text.String(), usage, err
+	}
+
+	if finish != FinishReasonStop {
+		return text.String(), usage, fmt.Errorf("unexpected finish reason: %v", finish)
+	}
+	return text.String(), usage, nil
+}
+
+// readEvents passes the data of the server-sent events of the body to
+// onData until the [DONE] event
+func readEvents(body io.Reader, onData func(data []byte) error) error {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		data, ok := bytes.CutPrefix(scanner.Bytes(), []byte("data:"))
+		if !ok {
+			// Blank lines between events, comments and other fields
+			continue
+		}
+		data = bytes.TrimSpace(data)
+		if string(data) == "[DONE]" {
+			return nil
+		}
+		if err := onData(data); err != nil {
+			return err
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading stream: %w", err)
+	}
+	return errors.New("stream ended without [DONE]")
+}
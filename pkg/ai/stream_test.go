@@ -0,0 +1,83 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const streamBody = `data: {"model": "gpt-5-mini", "choices": [{"delta": {"role": "assistant", "content": ""}}]}
+
+data: {"model": "gpt-5-mini", "choices": [{"delta": {"content": "It was "}}]}
+
+: keep-alive
+
+data: {"model": "gpt-5-mini", "choices": [{"delta": {"content": "a crypto offer."}}]}
+
+data: {"model": "gpt-5-mini", "choices": [{"delta": {}, "finish_reason": "stop"}]}
+
+data: {"model": "gpt-5-mini", "choices": [], "usage": {"prompt_tokens": 50, "completion_tokens": 5, "total_tokens": 55}}
+
+data: [DONE]
+
+`
+
+func TestOpenAI_StreamCompletion(t *testing.T) {
+	var reqBody map[string]any
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &reqBody)
+		return jsonResponse(200, streamBody), nil
+	}), OpenAIOptions{})
+
+	var deltas []string
+	text, usage, err := client.StreamCompletion(context.Background(), "sys", "why was it removed?", func(delta string) error {
+		deltas = append(deltas, delta)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("StreamCompletion: %v", err)
+	}
+
+	if text != "It was a crypto offer." || strings.Join(deltas, "|") != "It was |a crypto offer." {
+		t.Errorf("text = %q from %q, want the deltas joined", text, deltas)
+	}
+	if usage == nil || usage.TotalTokens != 55 || usage.Model != "gpt-5-mini" {
+		t.Errorf("usage = %+v, want the last chunk's", usage)
+	}
+	if reqBody["stream"] != true || reqBody["stream_options"] == nil {
+		t.Errorf("request = %v, want a stream with usage", reqBody)
+	}
+	if _, ok := reqBody["response_format"]; ok {
+		t.Errorf("request = %v, want no response format for text", reqBody)
+	}
+}
+
+func TestOpenAI_StreamCompletion_Errors(t *testing.T) {
+	tests := map[string]string{
+		"stopped by the caller": streamBody,
+		"cut off":               strings.Split(streamBody, "data: {\"model\": \"gpt-5-mini\", \"choices\": [{\"delta\": {}")[0],
+		"error event":           `data: {"error": {"message": "overloaded"}}` + "\n\n",
+	}
+
+	stop := errors.New("enough")
+	for name, body := range tests {
+		client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return jsonResponse(200, body), nil
+		}), OpenAIOptions{})
+
+		_, _, err := client.StreamCompletion(context.Background(), "sys", "user", func(delta string) error {
+			if name == "stopped by the caller" {
+				return stop
+			}
+			return nil
+		})
+		if err == nil || (name == "stopped by the caller" && !errors.Is(err, stop)) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
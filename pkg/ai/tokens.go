@@ -0,0 +1,108 @@
+package ai
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// truncationMark replaces the middle of a truncated text
+const truncationMark = "\n[…]\n"
+
+// pieces splits a text the way the pre-tokenizers of GPT models do before
+// their byte pair encoding: words with the space before them, numbers of up
+// to three digits, runs of punctuation and of whitespace
+var pieces = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// CountTokens estimates the tokens of the text for the models of the
+// providers. The text is split as their tokenizers split it, and the pieces
+// are counted by how byte pair encodings merge their script: a frequent
+// English word is a token, Cyrillic and most other alphabets take about a
+// token for three letters, each Chinese, Japanese or Korean character and
+// each emoji about a token. It doesn't know the vocabularies, so it's close
+// for chat messages, not exact.
+func CountTokens(text string) int {
+	var tokens int
+	for _, piece := range pieces.FindAllString(text, -1) {
+		tokens += pieceTokens(piece)
+	}
+	return tokens
+}
+
+// pieceTokens estimates the tokens of a piece of a text
+func pieceTokens(piece string) int {
+	var latin, alphabet, ideographs, symbols int
+	for _, r := range piece {
+		switch {
+		case r < utf8.RuneSelf:
+			latin++
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			ideographs++
+		case unicode.IsLetter(r) || unicode.IsMark(r) || unicode.IsNumber(r):
+			alphabet++
+		default:
+			// Emoji and other symbols are split into their bytes
+			symbols += utf8.RuneLen(r)
+		}
+	}
+
+	tokens := ceilDiv(latin, 6) + ceilDiv(alphabet, 3) + ideographs + ceilDiv(symbols, 2)
+	return max(tokens, 1)
+}
+
+// EstimatePromptTokens estimates the prompt tokens of a request of the texts
+// and the number of images, as the rate limits count them
+func EstimatePromptTokens(images int, texts ...string) int {
+	return estimateTokens(texts...) + images*imageTokens
+}
+
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
+
+// Truncate returns the text cut to about maxTokens tokens, by CountTokens.
+// The beginning and the end of a long text tell the most about it, so its
+// middle is dropped: two thirds of the tokens are kept from the head, the
+// rest from the tail, with a mark in between. A text within the limit, or a
+// limit of zero, returns the text as it is.
+func Truncate(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return text
+	}
+
+	bounds := pieces.FindAllStringIndex(text, -1)
+	counts := make([]int, len(bounds))
+	var total int
+	for i, b := range bounds {
+		counts[i] = pieceTokens(text[b[0]:b[1]])
+		total += counts[i]
+	}
+	if total <= maxTokens {
+		return text
+	}
+
+	budget := max(maxTokens-CountTokens(truncationMark), 2)
+	headBudget := budget * 2 / 3
+
+	head, tokens := 0, 0
+	for head < len(bounds) && tokens+counts[head] <= headBudget {
+		tokens += counts[head]
+		head++
+	}
+	tail := len(bounds)
+	for tail > head && tokens+counts[tail-1] <= budget {
+		tokens += counts[tail-1]
+		tail--
+	}
+
+	var b strings.Builder
+	if head > 0 {
+		b.WriteString(strings.TrimRightFunc(text[:bounds[head-1][1]], unicode.IsSpace))
+	}
+	b.WriteString(truncationMark)
+	if tail < len(bounds) {
+		b.WriteString(strings.TrimLeftFunc(text[bounds[tail][0]:], unicode.IsSpace))
+	}
+	return b.String()
+}
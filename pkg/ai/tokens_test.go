@@ -0,0 +1,53 @@
+package ai
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text     string
+		min, max int
+	}{
+		{"", 0, 0},
+		{"hello world", 2, 2},
+		{"Earn $300 a day working from home, DM me", 10, 14},
+		{"Шукаю людей для віддаленої роботи", 10, 16},
+		{"赚钱很容易", 5, 5},
+		{"🔥🔥🔥", 5, 7},
+	}
+	for _, tc := range tests {
+		if got := CountTokens(tc.text); got < tc.min || got > tc.max {
+			t.Errorf("CountTokens(%q) = %d, want %d to %d", tc.text, got, tc.min, tc.max)
+		}
+	}
+}
+
+func TestEstimatePromptTokens(t *testing.T) {
+	text := CountTokens("be brief") + CountTokens("hello world") + 1
+	if got := EstimatePromptTokens(0, "be brief", "hello world"); got != text {
+		t.Errorf("EstimatePromptTokens = %d, want %d", got, text)
+	}
+	if got := EstimatePromptTokens(2, "be brief", "hello world"); got != text+2*imageTokens {
+		t.Errorf("EstimatePromptTokens with images = %d, want %d", got, text+2*imageTokens)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	if got := Truncate("short text", 10); got != "short text" {
+		t.Errorf("Truncate of a short text = %q", got)
+	}
+
+	text := "HEAD " + strings.Repeat("filler words go here ", 500) + "TAIL"
+	got := Truncate(text, 100)
+	if !strings.HasPrefix(got, "HEAD filler") || !strings.HasSuffix(got, "here TAIL") || !strings.Contains(got, truncationMark) {
+		t.Errorf("Truncate = %q, want the head and the tail around the mark", got)
+	}
+	if n := CountTokens(got); n > 100 || n < 90 {
+		t.Errorf("Truncate left %d tokens, want about 100", n)
+	}
+	if Truncate(text, 0) != text {
+		t.Error("Truncate with no limit changed the text")
+	}
+}
@@ -0,0 +1,170 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// maxToolRounds bounds the rounds of tool calls of a completion, after them
+// the model has to answer with what it has
+const maxToolRounds = 3
+
+// ErrToolsNotSupported is returned by providers without function calling
+var ErrToolsNotSupported = errors.New("tools are not supported by the provider")
+
+// ToolCaller is a provider letting the model call tools for more context
+// before it answers
+type ToolCaller interface {
+	GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error)
+}
+
+// SupportsTools reports whether the provider of the name is a ToolCaller
+func SupportsTools(name string) bool {
+	switch name {
+	case ProviderOpenAI, "", ProviderAnthropic:
+		return true
+	}
+	return false
+}
+
+// Tool is a function the model may call during a completion, such as a
+// lookup of the sender's recent messages. Tools get arguments chosen by the
+// model, which may be steered by the checked text, so they must be safe to
+// call with any.
+type Tool struct {
+	name        string
+	description string
+	params      *schema
+	call        func(ctx context.Context, args json.RawMessage) (string, error)
+}
+
+// NewTool returns the tool of the name calling call with the arguments
+// decoded into P, a struct whose schema is derived like that of a response
+// format
+func NewTool[P any](name, description string, call func(ctx context.Context, params P) (string, error)) (Tool, error) {
+	var params P
+	s, err := objectSchema(params)
+	if err != nil {
+		return Tool{}, fmt.Errorf("tool %s: %w", name, err)
+	}
+
+	return Tool{
+		name:        name,
+		description: description,
+		params:      s,
+		call: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params P
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", fmt.Errorf("decoding arguments: %w", err)
+			}
+			return call(ctx, params)
+		},
+	}, nil
+}
+
+// Name returns the name the model calls the tool by
+func (t Tool) Name() string {
+	return t.name
+}
+
+// Call calls the tool with the arguments in JSON
+func (t Tool) Call(ctx context.Context, args json.RawMessage) (string, error) {
+	return t.call(ctx, args)
+}
+
+// ToolCall is a call of a tool requested by the model
+type ToolCall struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// toolSpec declares a tool in a chat completion request
+type toolSpec struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string  `json:"name"`
+		Description string  `json:"description"`
+		Parameters  *schema `json:"parameters"`
+		Strict      bool    `json:"strict"`
+	} `json:"function"`
+}
+
+func (t Tool) spec() toolSpec {
+	spec := toolSpec{Type: "function"}
+	spec.Function.Name = t.name
+	spec.Function.Description = t.description
+	spec.Function.Parameters = t.params
+	spec.Function.Strict = true
+	return spec
+}
+
+// callTool runs the call and returns what the model gets back, the error of
+// a failed call included so the model can do without
+func callTool(ctx context.Context, tools []Tool, call ToolCall) string {
+	for _, t := range tools {
+		if t.name != call.Function.Name {
+			continue
+		}
+		out, err := t.Call(ctx, json.RawMessage(call.Function.Arguments))
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return out
+	}
+	return fmt.Sprintf("error: no tool %q", call.Function.Name)
+}
+
+// GetJSONCompletionWithTools is GetJSONCompletion letting the model call the
+// tools first, for up to maxToolRounds rounds. The usage sums all requests.
+func (c *OpenAI) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	request := completionRequest(c.model, system, user, nil, rf)
+	callOptions(opts).apply(&request)
+	for _, t := range tools {
+		request.Tools = append(request.Tools, t.spec())
+	}
+
+	var total Usage
+	for round := 0; ; round++ {
+		if round == maxToolRounds {
+			request.ToolChoice = "none"
+		}
+
+		response, err := c.postFormatted(ctx, request, rf, nil)
+		if err != nil {
+			if round == 0 {
+				return nil, err
+			}
+			return &total, err
+		}
+		total.PromptTokens += response.Usage.PromptTokens
+		total.CompletionTokens += response.Usage.CompletionTokens
+		total.TotalTokens += response.Usage.TotalTokens
+		total.PromptTokensDetails.CachedTokens += response.Usage.PromptTokensDetails.CachedTokens
+		total.Model = response.Model
+
+		if len(response.Choices) == 0 || response.Choices[0].FinishReason != FinishReasonToolCalls || round == maxToolRounds {
+			_, err = decodeCompletion(response, result)
+			return &total, err
+		}
+
+		message := response.Choices[0].Message
+		assistant := Message{Role: RoleAssistant, ToolCalls: message.ToolCalls}
+		if message.Content != "" {
+			assistant.Content = message.Content
+		}
+		request.Messages = append(request.Messages, assistant)
+		for _, call := range message.ToolCalls {
+			request.Messages = append(request.Messages, Message{
+				Role:       RoleTool,
+				Content:    callTool(ctx, tools, call),
+				ToolCallID: call.ID,
+			})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type lookupParams struct {
+	User string `json:"user" description:"user to look up"`
+}
+
+func toolCallResponse(calls ...string) string {
+	var toolCalls []string
+	for i, call := range calls {
+		name, args, _ := strings.Cut(call, " ")
+		quoted, _ := json.Marshal(args)
+		toolCalls = append(toolCalls, fmt.Sprintf(`{"id": "call_%d", "type": "function", "function": {"name": %q, "arguments": %s}}`, i, name, quoted))
+	}
+	return `{"model": "gpt-5-mini", "choices": [{"finish_reason": "tool_calls", "message": {"role": "assistant", "tool_calls": [` +
+		strings.Join(toolCalls, ",") + `]}}], "usage": {"prompt_tokens": 100, "completion_tokens": 10, "total_tokens": 110}}`
+}
+
+func TestOpenAI_GetJSONCompletionWithTools(t *testing.T) {
+	var requests []map[string]any
+	responses := []string{
+		toolCallResponse(`lookup {"user": "alice"}`, `lookup {"user": "bob"}`, `missing {}`),
+		`{"model": "gpt-5-mini", "choices": [{"finish_reason": "stop", "message": {"content": "{\"is_spam\": true}"}}],
+		  "usage": {"prompt_tokens": 200, "completion_tokens": 20, "total_tokens": 220}}`,
+	}
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body map[string]any
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		requests = append(requests, body)
+		return jsonResponse(200, responses[len(requests)-1]), nil
+	}), OpenAIOptions{})
+
+	tool, err := NewTool("lookup", "looks a user up", func(_ context.Context, p lookupParams) (string, error) {
+		if p.User == "bob" {
+			return "", errors.New("no such user")
+		}
+		return p.User + " posts about crypto", nil
+	})
+	if err != nil {
+		t.Fatalf("NewTool: %v", err)
+	}
+
+	var result SpamCheck
+	usage, err := client.GetJSONCompletionWithTools(context.Background(), "sys", "user", []Tool{tool}, SpamCheckFormat, &result)
+	if err != nil {
+		t.Fatalf("GetJSONCompletionWithTools: %v", err)
+	}
+	if !result.IsSpam || usage.TotalTokens != 330 || usage.PromptTokens != 300 || usage.Model != "gpt-5-mini" {
+		t.Errorf("result = %+v, usage = %+v, want the answer with the usage of both requests", result, usage)
+	}
+
+	tools := requests[0]["tools"].([]any)
+	function := tools[0].(map[string]any)["function"].(map[string]any)
+	if function["name"] != "lookup" || function["strict"] != true || !strings.Contains(fmt.Sprint(function["parameters"]), "user to look up") {
+		t.Errorf("tool = %v, want it declared with its parameters", function)
+	}
+
+	messages := requests[1]["messages"].([]any)
+	if len(messages) != 6 {
+		t.Fatalf("messages = %v, want the tool calls and their results added", messages)
+	}
+	var contents []string
+	for _, m := range messages[3:] {
+		m := m.(map[string]any)
+		if m["role"] != "tool" {
+			t.Errorf("message = %v, want a tool result", m)
+		}
+		contents = append(contents, fmt.Sprint(m["tool_call_id"], ": ", m["content"]))
+	}
+	want := []string{"call_0: alice posts about crypto", "call_1: error: no such user", `call_2: error: no tool "missing"`}
+	if strings.Join(contents, "\n") != strings.Join(want, "\n") {
+		t.Errorf("tool results = %q, want %q", contents, want)
+	}
+}
+
+func TestOpenAI_GetJSONCompletionWithTools_Rounds(t *testing.T) {
+	var requests []map[string]any
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body map[string]any
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		requests = append(requests, body)
+		if body["tool_choice"] == "none" {
+			return jsonResponse(200, `{"choices": [{"finish_reason": "stop", "message": {"content": "{\"is_spam\": false}"}}]}`), nil
+		}
+		return jsonResponse(200, toolCallResponse(`lookup {"user": "alice"}`)), nil
+	}), OpenAIOptions{})
+
+	tool, _ := NewTool("lookup", "looks a user up", func(context.Context, lookupParams) (string, error) { return "nothing", nil })
+
+	var result SpamCheck
+	if _, err := client.GetJSONCompletionWithTools(context.Background(), "sys", "user", []Tool{tool}, SpamCheckFormat, &result); err != nil {
+		t.Fatalf("GetJSONCompletionWithTools: %v", err)
+	}
+	if len(requests) != maxToolRounds+1 {
+		t.Errorf("%d requests, want the answer forced after %d rounds", len(requests), maxToolRounds)
+	}
+}
+
+func TestWithRateLimit_Tools(t *testing.T) {
+	p := WithRateLimit(NewGemini("key", nil), NewLimiter(RateLimit{RequestsPerMinute: 60}))
+
+	var result SpamCheck
+	_, err := p.(ToolCaller).GetJSONCompletionWithTools(context.Background(), "sys", "user", nil, SpamCheckFormat, &result)
+	if !errors.Is(err, ErrToolsNotSupported) {
+		t.Errorf("err = %v, want ErrToolsNotSupported", err)
+	}
+}
+
+func TestAnthropic_GetJSONCompletionWithTools(t *testing.T) {
+	var requests []map[string]any
+	responses := []string{
+		`{"model": "claude-haiku-4-5", "stop_reason": "tool_use",
+		  "content": [{"type": "text", "text": "let me look"}, {"type": "tool_use", "id": "toolu_1", "name": "lookup", "input": {"user": "alice"}}],
+		  "usage": {"input_tokens": 100, "cache_creation_input_tokens": 50, "output_tokens": 10}}`,
+		`{"model": "claude-haiku-4-5", "stop_reason": "tool_use",
+		  "content": [{"type": "tool_use", "id": "toolu_2", "name": "spam_check_response", "input": {"is_spam": true}}],
+		  "usage": {"input_tokens": 120, "cache_read_input_tokens": 50, "output_tokens": 20}}`,
+	}
+	client := NewAnthropic("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		var body map[string]any
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &body)
+		requests = append(requests, body)
+		return jsonResponse(200, responses[len(requests)-1]), nil
+	}))
+
+	tool, _ := NewTool("lookup", "looks a user up", func(_ context.Context, p lookupParams) (string, error) {
+		return p.User + " posts about crypto", nil
+	})
+
+	var result SpamCheck
+	usage, err := client.GetJSONCompletionWithTools(context.Background(), "sys", "user", []Tool{tool}, SpamCheckFormat, &result)
+	if err != nil {
+		t.Fatalf("GetJSONCompletionWithTools: %v", err)
+	}
+	if !result.IsSpam || usage.TotalTokens != 350 || usage.PromptTokensDetails.CachedTokens != 50 {
+		t.Errorf("result = %+v, usage = %+v, want the answer with the usage of both requests", result, usage)
+	}
+
+	if choice := requests[0]["tool_choice"].(map[string]any); choice["type"] != "any" {
+		t.Errorf("tool_choice = %v, want any tool", choice)
+	}
+	if tools := toJSON(requests[0]["tools"]); !strings.Contains(tools, `"name":"lookup"`) || !strings.Contains(tools, `"name":"spam_check_response"`) {
+		t.Errorf("tools = %s, want the lookup and the report", tools)
+	}
+	if system := toJSON(requests[0]["system"]); !strings.Contains(system, `"cache_control":{"type":"ephemeral"}`) {
+		t.Errorf("system = %s, want it cached", system)
+	}
+
+	messages := requests[1]["messages"].([]any)
+	if len(messages) != 3 {
+		t.Fatalf("messages = %v, want the tool call and its result added", messages)
+	}
+	if results := toJSON(messages[2]); !strings.Contains(results, `"tool_use_id":"toolu_1"`) || !strings.Contains(results, "alice posts about crypto") {
+		t.Errorf("tool results = %s", results)
+	}
+}
+
+func TestSupportsTools(t *testing.T) {
+	var _ ToolCaller = (*OpenAI)(nil)
+	var _ ToolCaller = (*Anthropic)(nil)
+
+	tests := map[string]bool{
+		ProviderOpenAI:    true,
+		"":                true,
+		ProviderAnthropic: true,
+		ProviderGemini:    false,
+		ProviderFake:      false,
+		"llama":           false,
+	}
+	for name, want := range tests {
+		if got := SupportsTools(name); got != want {
+			t.Errorf("SupportsTools(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
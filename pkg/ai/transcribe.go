@@ -0,0 +1,143 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"time"
+)
+
+// Transcriber is a provider turning speech into text, such as voice messages
+// to moderate as texts
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error)
+}
+
+// TranscribeOptions describe the audio to transcribe
+type TranscribeOptions struct {
+	// Filename names the audio with the extension of its format, e.g.
+	// "voice.ogg", which the API tells the format by
+	Filename string
+
+	// Duration is the length of the audio if known, e.g. from Telegram. Audio
+	// longer than the limit of the client is refused without uploading it.
+	Duration time.Duration
+
+	// Language is the ISO-639-1 code of the speech, e.g. "ru", which improves
+	// accuracy and latency, empty detects it
+	Language string
+
+	// Prompt hints at the vocabulary of the speech, e.g. names and terms
+	// common in the chat
+	Prompt string
+}
+
+// MaxAudioSize is the largest audio file the transcription API takes
+const MaxAudioSize = 25 << 20
+
+// audioTokensPerSecond estimates the tokens of audio for rate limiting
+const audioTokensPerSecond = 10
+
+var (
+	// ErrTranscriptionNotSupported is returned by providers without speech
+	// to text
+	ErrTranscriptionNotSupported = errors.New("transcription is not supported by the provider")
+
+	// ErrAudioTooLarge is returned for audio over MaxAudioSize
+	ErrAudioTooLarge = errors.New("audio is too large to transcribe")
+
+	// ErrAudioTooLong is returned for audio over the duration limit
+	ErrAudioTooLong = errors.New("audio is too long to transcribe")
+)
+
+// Transcribe returns the text of the speech of the audio, made by the
+// transcription model
+func (c *OpenAI) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	if len(audio) > MaxAudioSize {
+		return "", nil, fmt.Errorf("%w: %d bytes, the limit is %d", ErrAudioTooLarge, len(audio), MaxAudioSize)
+	}
+	if opts.Duration > c.maxAudioDuration {
+		return "", nil, fmt.Errorf("%w: %s, the limit is %s", ErrAudioTooLong, opts.Duration, c.maxAudioDuration)
+	}
+	if opts.Filename == "" {
+		opts.Filename = "audio.ogg"
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, err := form.CreateFormFile("file", opts.Filename)
+	if err != nil {
+		return "", nil, fmt.Errorf("creating form file: %w", err)
+	}
+	if _, err = file.Write(audio); err != nil {
+		return "", nil, fmt.Errorf("writing form file: %w", err)
+	}
+	fields := [][2]string{
+		{"model", c.transcriptionModel},
+		{"response_format", "json"},
+		{"language", opts.Language},
+		{"prompt", opts.Prompt},
+	}
+	for _, field := range fields {
+		if field[1] == "" {
+			continue
+		}
+		if err = form.WriteField(field[0], field[1]); err != nil {
+			return "", nil, fmt.Errorf("writing form field %s: %w", field[0], err)
+		}
+	}
+	if err = form.Close(); err != nil {
+		return "", nil, fmt.Errorf("closing form: %w", err)
+	}
+
+	endpoint, err := c.endpoint("audio/transcriptions")
+	if err != nil {
+		return "", nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+	if err != nil {
+		return "", nil, fmt.Errorf("creating request: %w", err)
+	}
+	req.Header = c.header()
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", nil, fmt.Errorf("doing request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading response body: %w", err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
+	}
+
+	// Token based models report tokens, whisper-1 the seconds of audio,
+	// which leave it unpriced
+	var response struct {
+		Text  string `json:"text"`
+		Usage struct {
+			InputTokens  int `json:"input_tokens"`
+			OutputTokens int `json:"output_tokens"`
+			TotalTokens  int `json:"total_tokens"`
+		} `json:"usage"`
+	}
+	if err = json.Unmarshal(resBody, &response); err != nil {
+		return "", nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response.Text, &Usage{
+		PromptTokens:     response.Usage.InputTokens,
+		CompletionTokens: response.Usage.OutputTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+		Model:            c.transcriptionModel,
+	}, nil
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOpenAI_Transcribe(t *testing.T) {
+	var (
+		path   string
+		fields = map[string]string{}
+		file   []byte
+	)
+	client := NewOpenAI("key", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		path = r.URL.Path
+		form, err := r.MultipartReader()
+		if err != nil {
+			return nil, err
+		}
+		for {
+			part, err := form.NextPart()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			data, _ := io.ReadAll(part)
+			if part.FormName() == "file" {
+				fields["filename"] = part.FileName()
+				file = data
+				continue
+			}
+			fields[part.FormName()] = string(data)
+		}
+		return jsonResponse(200, `{
+			"text": "Earn 500 dollars a day, write me",
+			"usage": {"type": "tokens", "input_tokens": 40, "output_tokens": 10, "total_tokens": 50}
+		}`), nil
+	}), OpenAIOptions{MaxAudioDuration: time.Minute})
+
+	text, usage, err := client.Transcribe(context.Background(), []byte("OggS"), TranscribeOptions{
+		Filename: "voice.ogg",
+		Duration: 5 * time.Second,
+		Language: "en",
+	})
+	if err != nil {
+		t.Fatalf("Transcribe: %v", err)
+	}
+
+	if text != "Earn 500 dollars a day, write me" {
+		t.Errorf("text = %q", text)
+	}
+	if usage.TotalTokens != 50 || usage.Model != TranscriptionModel {
+		t.Errorf("usage = %+v, want the tokens of the transcription model", usage)
+	}
+	if path != "/v1/audio/transcriptions" || string(file) != "OggS" || fields["filename"] != "voice.ogg" {
+		t.Errorf("request to %s with file %q named %q, want the audio uploaded", path, file, fields["filename"])
+	}
+	if fields["model"] != TranscriptionModel || fields["language"] != "en" {
+		t.Errorf("fields = %v, want the model and the language", fields)
+	}
+	if _, ok := fields["prompt"]; ok {
+		t.Errorf("fields = %v, want no empty prompt", fields)
+	}
+}
+
+func TestOpenAI_Transcribe_Limits(t *testing.T) {
+	client := NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		t.Fatal("audio over the limits uploaded")
+		return nil, nil
+	}), OpenAIOptions{MaxAudioDuration: time.Minute})
+
+	if _, _, err := client.Transcribe(context.Background(), make([]byte, MaxAudioSize+1), TranscribeOptions{}); !errors.Is(err, ErrAudioTooLarge) {
+		t.Errorf("Transcribe = %v, want ErrAudioTooLarge", err)
+	}
+	if _, _, err := client.Transcribe(context.Background(), []byte("OggS"), TranscribeOptions{Duration: 2 * time.Minute}); !errors.Is(err, ErrAudioTooLong) {
+		t.Errorf("Transcribe = %v, want ErrAudioTooLong", err)
+	}
+}
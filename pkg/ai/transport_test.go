@@ -0,0 +1,72 @@
+package ai
+
+import (
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewHTTPClient_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer proxy.Close()
+
+	client, err := NewHTTPClient(TransportOptions{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	res, err := client.Get("http://api.example.invalid/v1/models")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	_ = res.Body.Close()
+
+	if proxied != "http://api.example.invalid/v1/models" {
+		t.Errorf("proxy got %q, want the request", proxied)
+	}
+}
+
+func TestNewHTTPClient_CACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(path, pemCertificate(server.Certificate().Raw), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := NewHTTPClient(TransportOptions{CACertFile: path})
+	if err != nil {
+		t.Fatalf("NewHTTPClient: %v", err)
+	}
+	res, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get with the certificate trusted: %v", err)
+	}
+	_ = res.Body.Close()
+
+	if _, err = NewHTTPClient(TransportOptions{CACertFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("missing certificate file accepted")
+	}
+}
+
+func TestNewHTTPClient_BadProxy(t *testing.T) {
+	for _, proxy := range []string{"proxy:3128", "http://user:secret@"} {
+		if _, err := NewHTTPClient(TransportOptions{Proxy: proxy}); err == nil {
+			t.Errorf("proxy %q accepted", proxy)
+		}
+	}
+}
+
+func pemCertificate(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
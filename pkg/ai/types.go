@@ -9,15 +9,31 @@ type HTTPClient interface {
 }
 
 type Request struct {
-	Model           string          `json:"model"`
-	Messages        []Message       `json:"messages"`
-	ReasoningEffort ReasoningEffort `json:"reasoning_effort,omitempty"`
-	ResponseFormat  any             `json:"response_format"`
+	Model               string          `json:"model"`
+	Messages            []Message       `json:"messages"`
+	ReasoningEffort     ReasoningEffort `json:"reasoning_effort,omitempty"`
+	Temperature         *float64        `json:"temperature,omitempty"`
+	MaxCompletionTokens int             `json:"max_completion_tokens,omitempty"`
+	ResponseFormat      any             `json:"response_format,omitempty"`
+	Tools               []toolSpec      `json:"tools,omitempty"`
+	ToolChoice          string          `json:"tool_choice,omitempty"`
+	Stream              bool            `json:"stream,omitempty"`
+	StreamOptions       *StreamOptions  `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
 }
 
 type Message struct {
 	Role    Role `json:"role"`
 	Content any  `json:"content"` // string or []ContentPart
+
+	// ToolCalls are the calls an assistant message requested
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+
+	// ToolCallID is the call a tool message answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
 // ContentPart represents a part of a multi-modal message (text or image)
@@ -38,6 +54,7 @@ const (
 	RoleSystem    Role = "system"
 	RoleUser      Role = "user"
 	RoleAssistant Role = "assistant"
+	RoleTool      Role = "tool"
 )
 
 type ReasoningEffort string
@@ -60,6 +77,24 @@ type Usage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+
+	// PromptTokensDetails breaks the prompt tokens down
+	PromptTokensDetails PromptTokensDetails `json:"prompt_tokens_details"`
+
+	// Model is the model that served the request
+	Model string `json:"-"`
+
+	// Batch marks usage of the Batch API, billed at a discount
+	Batch bool `json:"-"`
+
+	// Provider is the provider of a fallback chain that served the request
+	Provider string `json:"-"`
+}
+
+// PromptTokensDetails breaks the prompt tokens down
+type PromptTokensDetails struct {
+	// CachedTokens of the prompt were read from the provider's prompt cache
+	CachedTokens int `json:"cached_tokens"`
 }
 
 type Choice struct {
@@ -70,8 +105,9 @@ type Choice struct {
 
 // ResponseMessage is the message format returned by the API (content is always string)
 type ResponseMessage struct {
-	Role    Role   `json:"role"`
-	Content string `json:"content"`
+	Role      Role       `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
 }
 
 type FinishReason string
@@ -80,5 +116,6 @@ const (
 	FinishReasonStop         FinishReason = "stop"
 	FinishReasonLength       FinishReason = "length"
 	FinishReasonFunctionCall FinishReason = "function_call"
+	FinishReasonToolCalls    FinishReason = "tool_calls"
 	FinishReasonNull         FinishReason = ""
 )
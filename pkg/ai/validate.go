@@ -0,0 +1,250 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ErrInvalidResponse is returned for a completion whose result is malformed
+// JSON or doesn't match the schema of the response format
+var ErrInvalidResponse = errors.New("invalid ai response")
+
+// ValidateResponse checks the JSON result of a completion against the schema
+// of the response format: the types, required and unknown properties, enum
+// membership and the bounds of numbers
+func ValidateResponse(rf ResponseFormat, data []byte) error {
+	_, raw, err := rf.jsonSchema()
+	if err != nil {
+		return err
+	}
+	var s responseSchema
+	if err = json.Unmarshal(raw, &s); err != nil {
+		return fmt.Errorf("parsing response schema: %w", err)
+	}
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err = d.Decode(&v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	if err = s.validate("result", v); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidResponse, err)
+	}
+	return nil
+}
+
+// responseSchema is the part of a schema made by NewResponseFormat a result
+// is checked against
+type responseSchema struct {
+	Type                 any                        `json:"type"`
+	Enum                 []string                   `json:"enum"`
+	Minimum              *float64                   `json:"minimum"`
+	Maximum              *float64                   `json:"maximum"`
+	Items                *responseSchema            `json:"items"`
+	Properties           map[string]*responseSchema `json:"properties"`
+	Required             []string                   `json:"required"`
+	AdditionalProperties *bool                      `json:"additionalProperties"`
+}
+
+// types returns the type names of the schema, empty if any type goes
+func (s *responseSchema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []any:
+		types := make([]string, 0, len(t))
+		for _, name := range t {
+			if name, ok := name.(string); ok {
+				types = append(types, name)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func (s *responseSchema) validate(path string, v any) error {
+	if types := s.types(); len(types) > 0 && !slices.Contains(types, typeOf(v)) {
+		if !(typeOf(v) == "integer" && slices.Contains(types, "number")) {
+			return fmt.Errorf("%s is %s, want %s", path, typeOf(v), strings.Join(types, " or "))
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		if len(s.Enum) > 0 && !slices.Contains(s.Enum, v) {
+			return fmt.Errorf("%s is %q, want one of %s", path, v, strings.Join(s.Enum, ", "))
+		}
+	case json.Number:
+		n, err := v.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if s.Minimum != nil && n < *s.Minimum {
+			return fmt.Errorf("%s is %v, less than %v", path, n, *s.Minimum)
+		}
+		if s.Maximum != nil && n > *s.Maximum {
+			return fmt.Errorf("%s is %v, more than %v", path, n, *s.Maximum)
+		}
+	case []any:
+		if s.Items == nil {
+			return nil
+		}
+		for i, item := range v {
+			if err := s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				return fmt.Errorf("%s.%s is missing", path, name)
+			}
+		}
+
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			prop, ok := s.Properties[name]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s.%s is unknown", path, name)
+				}
+				continue
+			}
+			if err := prop.validate(path+"."+name, v[name]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typeOf returns the JSON Schema type name of a decoded JSON value
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case json.Number:
+		if n, err := v.Float64(); err == nil && n == math.Trunc(n) && !strings.ContainsAny(v.String(), ".eE") {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// WithValidation returns the provider checking the results of completions
+// against the schema of their response format. A malformed or invalid result
+// is asked for once more before ErrInvalidResponse is returned; the usage of
+// both requests adds up.
+func WithValidation(p Provider) Provider {
+	return &validatingProvider{Provider: p}
+}
+
+type validatingProvider struct {
+	Provider
+}
+
+func (p *validatingProvider) GetJSONCompletion(ctx context.Context, system, user string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.complete(ctx, rf, result, func(raw *json.RawMessage) (*Usage, error) {
+		return p.Provider.GetJSONCompletion(ctx, system, user, rf, raw, opts...)
+	})
+}
+
+func (p *validatingProvider) GetJSONCompletionWithImage(ctx context.Context, system, user string, image []byte, mimeType string, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.complete(ctx, rf, result, func(raw *json.RawMessage) (*Usage, error) {
+		return p.Provider.GetJSONCompletionWithImage(ctx, system, user, image, mimeType, rf, raw, opts...)
+	})
+}
+
+func (p *validatingProvider) GetJSONCompletionWithImages(ctx context.Context, system, user string, images []ImageData, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	return p.complete(ctx, rf, result, func(raw *json.RawMessage) (*Usage, error) {
+		return p.Provider.GetJSONCompletionWithImages(ctx, system, user, images, rf, raw, opts...)
+	})
+}
+
+func (p *validatingProvider) GetJSONCompletionWithTools(ctx context.Context, system, user string, tools []Tool, rf ResponseFormat, result any, opts ...CallOption) (*Usage, error) {
+	tc, ok := p.Provider.(ToolCaller)
+	if !ok {
+		return nil, ErrToolsNotSupported
+	}
+	return p.complete(ctx, rf, result, func(raw *json.RawMessage) (*Usage, error) {
+		return tc.GetJSONCompletionWithTools(ctx, system, user, tools, rf, raw, opts...)
+	})
+}
+
+func (p *validatingProvider) StreamCompletion(ctx context.Context, system, user string, onDelta func(delta string) error) (string, *Usage, error) {
+	streamer, ok := p.Provider.(Streamer)
+	if !ok {
+		return "", nil, ErrStreamingNotSupported
+	}
+	return streamer.StreamCompletion(ctx, system, user, onDelta)
+}
+
+func (p *validatingProvider) Transcribe(ctx context.Context, audio []byte, opts TranscribeOptions) (string, *Usage, error) {
+	transcriber, ok := p.Provider.(Transcriber)
+	if !ok {
+		return "", nil, ErrTranscriptionNotSupported
+	}
+	return transcriber.Transcribe(ctx, audio, opts)
+}
+
+// complete makes the completion into a raw result, asking once more if it's
+// invalid, and decodes a valid one into result
+func (p *validatingProvider) complete(ctx context.Context, rf ResponseFormat, result any, call func(raw *json.RawMessage) (*Usage, error)) (*Usage, error) {
+	var total *Usage
+	for attempt := 0; ; attempt++ {
+		var raw json.RawMessage
+		usage, err := call(&raw)
+		total = sumUsage(total, usage)
+		if err == nil {
+			err = ValidateResponse(rf, raw)
+		}
+		if errors.Is(err, ErrInvalidResponse) && attempt == 0 && ctx.Err() == nil {
+			continue
+		}
+		if err != nil {
+			return total, err
+		}
+
+		if err = json.Unmarshal(raw, result); err != nil {
+			return total, fmt.Errorf("%w: unmarshal response content: %w", ErrInvalidResponse, err)
+		}
+		return total, nil
+	}
+}
+
+// sumUsage returns the usage of two requests to the same provider
+func sumUsage(a, b *Usage) *Usage {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	sum := *b
+	sum.PromptTokens += a.PromptTokens
+	sum.CompletionTokens += a.CompletionTokens
+	sum.TotalTokens += a.TotalTokens
+	sum.PromptTokensDetails.CachedTokens += a.PromptTokensDetails.CachedTokens
+	return &sum
+}
@@ -0,0 +1,67 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestValidateResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{"valid", `{"is_spam": true, "category": "ads", "nsfw": false, "confidence": 1, "note": "ad"}`, ""},
+		{"malformed", `{"is_spam": true`, "unexpected EOF"},
+		{"missing", `{"is_spam": true, "category": "ads", "nsfw": false, "note": "ad"}`, "result.confidence is missing"},
+		{"unknown", `{"is_spam": true, "category": "ads", "nsfw": false, "confidence": 1, "note": "ad", "extra": 1}`, "result.extra is unknown"},
+		{"type", `{"is_spam": "yes", "category": "ads", "nsfw": false, "confidence": 1, "note": "ad"}`, "result.is_spam is string, want boolean"},
+		{"enum", `{"is_spam": true, "category": "spam", "nsfw": false, "confidence": 1, "note": "ad"}`, `result.category is "spam", want one of`},
+		{"range", `{"is_spam": true, "category": "ads", "nsfw": false, "confidence": 95, "note": "ad"}`, "result.confidence is 95, more than 1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidateResponse(SpamCheckFormat, []byte(tc.data))
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("ValidateResponse = %v, want nil", err)
+			case tc.wantErr != "" && (!errors.Is(err, ErrInvalidResponse) || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("ValidateResponse = %v, want ErrInvalidResponse with %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithValidation(t *testing.T) {
+	contents := []string{
+		`{\"is_spam\": true, \"category\": \"spam\", \"nsfw\": false, \"confidence\": 0.9, \"note\": \"ad\"}`,
+		`{\"is_spam\": true, \"category\": \"ads\", \"nsfw\": false, \"confidence\": 0.9, \"note\": \"ad\"}`,
+	}
+	calls := 0
+	p := WithValidation(NewOpenAI("key", roundTripFunc(func(*http.Request) (*http.Response, error) {
+		content := contents[min(calls, len(contents)-1)]
+		calls++
+		return jsonResponse(200, `{"model": "gpt", "choices": [{"finish_reason": "stop", "message": {"content": "`+content+`"}}], "usage": {"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15}}`), nil
+	}), OpenAIOptions{}))
+
+	var check SpamCheck
+	usage, err := p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &check)
+	if err != nil {
+		t.Fatalf("GetJSONCompletion: %v", err)
+	}
+	if calls != 2 || check.Category != "ads" {
+		t.Errorf("calls = %d, check = %+v, want the valid result of the re-ask", calls, check)
+	}
+	if usage.TotalTokens != 30 {
+		t.Errorf("usage = %+v, want both requests counted", usage)
+	}
+
+	contents = contents[:1]
+	calls = 0
+	if _, err = p.GetJSONCompletion(context.Background(), "sys", "user", SpamCheckFormat, &check); !errors.Is(err, ErrInvalidResponse) || calls != 2 {
+		t.Errorf("GetJSONCompletion = %v after %d calls, want ErrInvalidResponse after 2", err, calls)
+	}
+}
@@ -0,0 +1,75 @@
+// Package blob stores files such as downloaded media by key, in a local
+// directory or in an S3-compatible bucket.
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrNotFound is returned for a key with no blob
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps blobs by key. Keys are slash-separated relative paths such as
+// "media/AgADBAAD.jpg".
+type Store interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// FilePutter is a Store taking a blob from a local file without reading it
+// into memory
+type FilePutter interface {
+	PutFile(ctx context.Context, key, path, contentType string) error
+}
+
+// Open opens the store at the location: a local directory path or file://
+// URL, or an s3://bucket/prefix URL. S3 URLs accept endpoint (for
+// S3-compatible services, path-style addressing is used with it) and region
+// parameters, credentials are read from the AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY environment variables.
+func Open(location string) (Store, error) {
+	scheme, rest, ok := strings.Cut(location, "://")
+	if !ok {
+		return NewDir(location)
+	}
+
+	switch scheme {
+	case "file":
+		return NewDir(rest)
+	case "s3":
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("parsing s3 url: %w", err)
+		}
+		opts := S3Options{
+			Bucket:    u.Host,
+			Prefix:    strings.Trim(u.Path, "/"),
+			Endpoint:  u.Query().Get("endpoint"),
+			Region:    u.Query().Get("region"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+		if opts.Region == "" {
+			opts.Region = os.Getenv("AWS_REGION")
+		}
+		return NewS3(opts, nil)
+	}
+
+	return nil, fmt.Errorf("unknown blob store scheme %q, known: file, s3", scheme)
+}
+
+// checkKey rejects keys escaping the store, e.g. a local directory
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Dir stores blobs as files in a local directory, keys are paths relative
+// to it
+type Dir struct {
+	root string
+}
+
+// NewDir returns the store in the directory, creating it if needed
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	return &Dir{root: root}, nil
+}
+
+// Put writes the blob atomically, so a reader never sees it half-written.
+// The content type is not kept.
+func (d *Dir) Put(_ context.Context, key string, data []byte, _ string) error {
+	return d.write(key, bytes.NewReader(data))
+}
+
+// PutFile moves the local file to the blob, or copies it if it's on another
+// file system. The content type is not kept.
+func (d *Dir) PutFile(_ context.Context, key, path, _ string) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("creating blob directory: %w", err)
+	}
+	if err = os.Chmod(path, 0o644); err != nil {
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err = os.Rename(path, name); err == nil {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening blob file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	return d.write(key, f)
+}
+
+// write streams the blob to a temporary file synced to the disk and renamed
+// to the blob's
+func (d *Dir) write(key string, r io.Reader) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return fmt.Errorf("creating blob directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".blob-*")
+	if err != nil {
+		return fmt.Errorf("creating blob file: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("writing blob: %w", err)
+	}
+	if err = os.Chmod(tmp.Name(), 0o644); err != nil {
+		return fmt.Errorf("writing blob: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (d *Dir) Get(_ context.Context, key string) ([]byte, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (d *Dir) Exists(_ context.Context, key string) (bool, error) {
+	name, err := d.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = os.Stat(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Delete removes the blob, a missing one is not an error
+func (d *Dir) Delete(_ context.Context, key string) error {
+	name, err := d.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (d *Dir) path(key string) (string, error) {
+	if err := checkKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, filepath.FromSlash(key)), nil
+}
@@ -0,0 +1,106 @@
+// Package classifier implements a client for a user-run spam classifier,
+// e.g. a fine-tuned model served next to the bot. The protocol is one JSON
+// POST per message: the bot sends the message and the classifier answers
+// whether it's spam, how confident it is and of which category.
+package classifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Request is the JSON body POSTed to the classifier
+type Request struct {
+	ChatID    string  `json:"chat_id"`
+	UserID    string  `json:"user_id"`
+	MessageID string  `json:"message_id"`
+	Text      string  `json:"text"`
+	MediaType *string `json:"media_type,omitempty"`
+}
+
+// Verdict is the JSON body expected in response. Confidence is in the
+// verdict, from 0 to 1. Category is one of the e.SpamCategory values, empty
+// for ham or if the classifier doesn't tell categories. Model names the
+// classifier's model for decision traces, optional.
+type Verdict struct {
+	IsSpam     bool           `json:"is_spam"`
+	Confidence float64        `json:"confidence"`
+	Category   e.SpamCategory `json:"category,omitempty"`
+	Note       string         `json:"note,omitempty"`
+	Model      string         `json:"model,omitempty"`
+}
+
+type Client struct {
+	url        string
+	token      string
+	httpClient HTTPClient
+}
+
+// NewClient creates a client for the classifier at url. If token is not
+// empty it is sent as a bearer token.
+func NewClient(url, token string, httpClient HTTPClient) *Client {
+	return &Client{
+		url:        url,
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// Classify asks the classifier whether the message is spam
+func (c *Client) Classify(ctx context.Context, request Request) (Verdict, error) {
+	var verdict Verdict
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return verdict, fmt.Errorf("marshaling body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return verdict, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return verdict, fmt.Errorf("doing request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return verdict, fmt.Errorf("reading response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return verdict, fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
+	}
+
+	if err = json.Unmarshal(resBody, &verdict); err != nil {
+		return verdict, fmt.Errorf("decoding response: %w", err)
+	}
+
+	if verdict.Confidence < 0 || verdict.Confidence > 1 {
+		return verdict, fmt.Errorf("confidence %v is not between 0 and 1", verdict.Confidence)
+	}
+	if verdict.Category != "" && !slices.Contains(e.SpamCategories, verdict.Category) {
+		return verdict, fmt.Errorf("unknown category %q", verdict.Category)
+	}
+
+	return verdict, nil
+}
@@ -0,0 +1,211 @@
+// Package dataset reads and writes labeled examples as JSONL (one JSON
+// object per line) or CSV with a header row.
+package dataset
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// Format is a dataset file format
+type Format string
+
+const (
+	FormatJSONL Format = "jsonl"
+	FormatCSV   Format = "csv"
+)
+
+// csvHeader are the CSV columns, the fields of entities.Example
+var csvHeader = []string{
+	"chat_id", "message_id", "created_at", "label", "action", "category", "media_type", "media_file_id", "text",
+}
+
+// Writer writes examples in a format
+type Writer interface {
+	Write(ex e.Example) error
+
+	// Flush writes buffered data, it must be called after the last example
+	Flush() error
+}
+
+// NewWriter returns a writer of examples in the format
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w)}, nil
+	case FormatCSV:
+		return &csvWriter{w: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown dataset format %q", format)
+	}
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (w *jsonlWriter) Write(ex e.Example) error {
+	return w.enc.Encode(ex)
+}
+
+func (w *jsonlWriter) Flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w             *csv.Writer
+	headerWritten bool
+}
+
+func (w *csvWriter) Write(ex e.Example) error {
+	if !w.headerWritten {
+		if err := w.w.Write(csvHeader); err != nil {
+			return err
+		}
+		w.headerWritten = true
+	}
+
+	var createdAt string
+	if !ex.CreatedAt.IsZero() {
+		createdAt = ex.CreatedAt.UTC().Format(time.RFC3339)
+	}
+
+	return w.w.Write([]string{
+		ex.ChatID,
+		ex.MessageID,
+		createdAt,
+		string(ex.Label),
+		string(ex.Action),
+		string(ex.Category),
+		deref(ex.MediaType),
+		deref(ex.MediaFileID),
+		ex.Text,
+	})
+}
+
+func (w *csvWriter) Flush() error {
+	w.w.Flush()
+	return w.w.Error()
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// Reader reads examples in a format. Read returns io.EOF after the last
+// example. An example without a label is labeled by its action, if any.
+type Reader interface {
+	Read() (e.Example, error)
+}
+
+// NewReader returns a reader of examples in the format. CSV input must start
+// with a header row naming the columns as written by Writer, unknown columns
+// are ignored and missing ones are left empty.
+func NewReader(r io.Reader, format Format) (Reader, error) {
+	switch format {
+	case FormatJSONL:
+		return &jsonlReader{dec: json.NewDecoder(r)}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		return &csvReader{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("unknown dataset format %q", format)
+	}
+}
+
+type jsonlReader struct {
+	dec  *json.Decoder
+	line int
+}
+
+func (r *jsonlReader) Read() (e.Example, error) {
+	var ex e.Example
+	if err := r.dec.Decode(&ex); err != nil {
+		if errors.Is(err, io.EOF) {
+			return ex, io.EOF
+		}
+		return ex, fmt.Errorf("decoding example %d: %w", r.line+1, err)
+	}
+	r.line++
+
+	labelByAction(&ex)
+	return ex, nil
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns map[string]int
+}
+
+func (r *csvReader) Read() (e.Example, error) {
+	if r.columns == nil {
+		header, err := r.r.Read()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return e.Example{}, io.EOF
+			}
+			return e.Example{}, fmt.Errorf("reading header: %w", err)
+		}
+		r.columns = make(map[string]int, len(header))
+		for i, name := range header {
+			r.columns[name] = i
+		}
+	}
+
+	record, err := r.r.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return e.Example{}, io.EOF
+		}
+		return e.Example{}, fmt.Errorf("reading record: %w", err)
+	}
+
+	field := func(name string) string {
+		if i, ok := r.columns[name]; ok && i < len(record) {
+			return record[i]
+		}
+		return ""
+	}
+	optional := func(name string) *string {
+		if v := field(name); v != "" {
+			return &v
+		}
+		return nil
+	}
+
+	ex := e.Example{
+		ChatID:      field("chat_id"),
+		MessageID:   field("message_id"),
+		Text:        field("text"),
+		MediaType:   optional("media_type"),
+		MediaFileID: optional("media_file_id"),
+		Action:      e.ActionKind(field("action")),
+		Category:    e.SpamCategory(field("category")),
+		Label:       e.Label(field("label")),
+	}
+	if v := field("created_at"); v != "" {
+		if ex.CreatedAt, err = time.Parse(time.RFC3339, v); err != nil {
+			line, _ := r.r.FieldPos(0)
+			return ex, fmt.Errorf("parsing created_at on line %d: %w", line, err)
+		}
+	}
+
+	labelByAction(&ex)
+	return ex, nil
+}
+
+func labelByAction(ex *e.Example) {
+	if ex.Label == "" && ex.Action != "" {
+		ex.Label = e.LabelOf(ex.Action)
+	}
+}
@@ -0,0 +1,189 @@
+package dataset
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestWriter(t *testing.T) {
+	media := "image/jpeg"
+	examples := []e.Example{
+		{ChatID: "-100", MessageID: "1", Text: "free, \"crypto\"\nsignals", Action: e.ActionKindErase, Category: e.SpamCategoryAds, Label: e.LabelSpam, CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{ChatID: "-100", MessageID: "2", MediaType: &media, Action: e.ActionKindNoop, Label: e.LabelHam},
+	}
+
+	tests := []struct {
+		format Format
+		want   string
+	}{
+		{
+			format: FormatJSONL,
+			want: `{"chat_id":"-100","message_id":"1","text":"free, \"crypto\"\nsignals","action":"erase","category":"ads","label":"spam","created_at":"2025-01-02T03:04:05Z"}` + "\n" +
+				`{"chat_id":"-100","message_id":"2","text":"","media_type":"image/jpeg","action":"noop","label":"ham","created_at":"0001-01-01T00:00:00Z"}` + "\n",
+		},
+		{
+			format: FormatCSV,
+			want: "chat_id,message_id,created_at,label,action,category,media_type,media_file_id,text\n" +
+				"-100,1,2025-01-02T03:04:05Z,spam,erase,ads,,,\"free, \"\"crypto\"\"\nsignals\"\n" +
+				"-100,2,,ham,noop,,image/jpeg,,\n",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewWriter(&buf, tc.format)
+			if err != nil {
+				t.Fatalf("NewWriter: %v", err)
+			}
+			for _, ex := range examples {
+				if err = w.Write(ex); err != nil {
+					t.Fatalf("Write: %v", err)
+				}
+			}
+			if err = w.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+			if buf.String() != tc.want {
+				t.Errorf("got\n%s\nwant\n%s", buf.String(), tc.want)
+			}
+		})
+	}
+
+	if _, err := NewWriter(&bytes.Buffer{}, "xml"); err == nil {
+		t.Error("unknown format must fail")
+	}
+}
+
+func TestReader_RoundTrip(t *testing.T) {
+	media := "image/jpeg"
+	fileID := "file-1"
+	examples := []e.Example{
+		{ChatID: "-100", MessageID: "1", Text: "free, \"crypto\"\nsignals", Action: e.ActionKindErase, Category: e.SpamCategoryAds, Label: e.LabelSpam, CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{ChatID: "-100", MessageID: "2", MediaType: &media, MediaFileID: &fileID, Action: e.ActionKindNoop, Label: e.LabelHam},
+	}
+
+	for _, format := range []Format{FormatJSONL, FormatCSV} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			w, _ := NewWriter(&buf, format)
+			for _, ex := range examples {
+				_ = w.Write(ex)
+			}
+			_ = w.Flush()
+
+			r, err := NewReader(&buf, format)
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			for i, want := range examples {
+				got, err := r.Read()
+				if err != nil {
+					t.Fatalf("Read %d: %v", i, err)
+				}
+				if got.Text != want.Text || got.Label != want.Label || got.Category != want.Category ||
+					!got.CreatedAt.Equal(want.CreatedAt) || deref(got.MediaFileID) != deref(want.MediaFileID) {
+					t.Errorf("example %d = %+v, want %+v", i, got, want)
+				}
+			}
+			if _, err = r.Read(); !errors.Is(err, io.EOF) {
+				t.Errorf("Read after the last example: %v, want EOF", err)
+			}
+		})
+	}
+}
+
+func TestReader_CSVLabelsByAction(t *testing.T) {
+	r, _ := NewReader(strings.NewReader("text,action,extra\nbuy now,ban,x\nhello,noop,y\n"), FormatCSV)
+
+	for _, want := range []e.Label{e.LabelSpam, e.LabelHam} {
+		ex, err := r.Read()
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		if ex.Label != want {
+			t.Errorf("label of %q = %q, want %q", ex.Text, ex.Label, want)
+		}
+	}
+}
+
+func TestFineTuningWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewFineTuningWriter(&buf, "find spam")
+	for _, ex := range []e.Example{
+		{Text: "free crypto signals", Category: e.SpamCategoryCryptoScam, Label: e.LabelSpam},
+		{Text: "hi all", Action: e.ActionKindErase, Category: e.SpamCategoryAds, Label: e.LabelHam},
+		{Text: "join now", Category: "retired", Label: e.LabelSpam},
+	} {
+		if err := w.Write(ex); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Write(e.Example{Label: e.LabelSpam}); !errors.Is(err, ErrNoText) {
+		t.Errorf("Write without text = %v, want ErrNoText", err)
+	}
+
+	answer := func(s string) string {
+		return strings.ReplaceAll(s, `"`, `\"`)
+	}
+	want := `{"messages":[{"role":"system","content":"find spam"},{"role":"user","content":"free crypto signals"},{"role":"assistant","content":"` +
+		answer(`{"is_spam":true,"category":"crypto_scam","nsfw":false,"confidence":1,"note":""}`) + `"}]}` + "\n" +
+		`{"messages":[{"role":"system","content":"find spam"},{"role":"user","content":"hi all"},{"role":"assistant","content":"` +
+		answer(`{"is_spam":false,"category":"none","nsfw":false,"confidence":1,"note":""}`) + `"}]}` + "\n" +
+		`{"messages":[{"role":"system","content":"find spam"},{"role":"user","content":"join now"},{"role":"assistant","content":"` +
+		answer(`{"is_spam":true,"category":"other","nsfw":false,"confidence":1,"note":""}`) + `"}]}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	photo := "AgAD"
+	examples := []e.Example{
+		{MessageID: "1", Text: "Free crypto", Label: e.LabelHam},
+		{MessageID: "2", Text: "free  CRYPTO", Label: e.LabelSpam},
+		{MessageID: "3", Text: "free crypto", MediaFileID: &photo, Label: e.LabelSpam},
+		{MessageID: "4", Text: "hello", Label: e.LabelHam},
+	}
+
+	var ids []string
+	for _, ex := range Dedup(examples) {
+		ids = append(ids, ex.MessageID)
+	}
+	if got := strings.Join(ids, ","); got != "2,3,4" {
+		t.Errorf("Dedup kept %s, want 2,3,4", got)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	var examples []e.Example
+	for i, label := range []e.Label{e.LabelSpam, e.LabelHam, e.LabelSpam, e.LabelSpam, e.LabelHam} {
+		examples = append(examples, e.Example{MessageID: string(rune('1' + i)), Label: label})
+	}
+
+	tests := []struct {
+		perLabel int
+		balance  bool
+		want     string
+	}{
+		{want: "1,2,3,4,5"},
+		{perLabel: 1, want: "4,5"},
+		{balance: true, want: "2,3,4,5"},
+		{perLabel: 1, balance: true, want: "4,5"},
+	}
+	for _, tc := range tests {
+		var ids []string
+		for _, ex := range Limit(examples, tc.perLabel, tc.balance) {
+			ids = append(ids, ex.MessageID)
+		}
+		if got := strings.Join(ids, ","); got != tc.want {
+			t.Errorf("Limit(%d, %v) kept %s, want %s", tc.perLabel, tc.balance, got, tc.want)
+		}
+	}
+}
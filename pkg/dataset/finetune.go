@@ -0,0 +1,80 @@
+package dataset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"nuclight.org/antispam-tg-bot/pkg/ai"
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// ErrNoText is returned by a fine-tuning writer for an example without text,
+// such as a media-only message, which a text model can't be trained on
+var ErrNoText = errors.New("example has no text")
+
+// categories are the categories of the spam check's response format
+var categories = []e.SpamCategory{
+	e.SpamCategoryCryptoScam, e.SpamCategoryJobScam, e.SpamCategoryAdult, e.SpamCategoryGambling,
+	e.SpamCategoryPhishing, e.SpamCategoryAds, e.SpamCategoryFlood, e.SpamCategoryOther,
+}
+
+// fineTuningLine is a training example of the OpenAI chat fine-tuning JSONL
+type fineTuningLine struct {
+	Messages []fineTuningMessage `json:"messages"`
+}
+
+type fineTuningMessage struct {
+	Role    ai.Role `json:"role"`
+	Content string  `json:"content"`
+}
+
+// NewFineTuningWriter returns a writer of examples as OpenAI chat fine-tuning
+// JSONL: each line is a conversation of the system prompt, the text of the
+// message and the spam check the model should answer it with, its verdict
+// and category by the label of the example. Examples without text are
+// rejected with ErrNoText.
+func NewFineTuningWriter(w io.Writer, system string) Writer {
+	return &fineTuningWriter{enc: json.NewEncoder(w), system: system}
+}
+
+type fineTuningWriter struct {
+	enc    *json.Encoder
+	system string
+}
+
+func (w *fineTuningWriter) Write(ex e.Example) error {
+	if ex.Text == "" {
+		return ErrNoText
+	}
+
+	answer, err := json.Marshal(spamCheckOf(ex))
+	if err != nil {
+		return fmt.Errorf("encoding answer: %w", err)
+	}
+
+	return w.enc.Encode(fineTuningLine{Messages: []fineTuningMessage{
+		{Role: ai.RoleSystem, Content: w.system},
+		{Role: ai.RoleUser, Content: ex.Text},
+		{Role: ai.RoleAssistant, Content: string(answer)},
+	}})
+}
+
+func (w *fineTuningWriter) Flush() error {
+	return nil
+}
+
+// spamCheckOf returns the spam check matching the label of the example. The
+// category of spam is its own if the response format knows it, other if not.
+func spamCheckOf(ex e.Example) ai.SpamCheck {
+	check := ai.SpamCheck{IsSpam: ex.Label == e.LabelSpam, Category: "none", Confidence: 1}
+	if check.IsSpam {
+		check.Category = string(e.SpamCategoryOther)
+		if slices.Contains(categories, ex.Category) {
+			check.Category = string(ex.Category)
+		}
+	}
+	return check
+}
@@ -0,0 +1,61 @@
+package dataset
+
+import (
+	"slices"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+	"nuclight.org/antispam-tg-bot/pkg/textnorm"
+)
+
+// Dedup returns the examples without repeated ones: those of the same
+// normalized text and media file as a later example, so a spam wave sent
+// many times counts once, with its latest label. The examples are expected
+// oldest first and keep their order.
+func Dedup(examples []e.Example) []e.Example {
+	seen := make(map[string]struct{}, len(examples))
+	unique := make([]e.Example, 0, len(examples))
+	for _, ex := range slices.Backward(examples) {
+		key := textnorm.Hash(ex.Text)
+		if ex.MediaFileID != nil {
+			key += "/" + *ex.MediaFileID
+		}
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		unique = append(unique, ex)
+	}
+	slices.Reverse(unique)
+	return unique
+}
+
+// Limit returns at most perLabel newest examples of each label, all of them
+// if perLabel is zero. With balance, each label has as many examples as the
+// rarest one. The examples are expected oldest first and keep their order.
+func Limit(examples []e.Example, perLabel int, balance bool) []e.Example {
+	counts := make(map[e.Label]int)
+	for _, ex := range examples {
+		counts[ex.Label]++
+	}
+	if balance {
+		rarest := min(counts[e.LabelSpam], counts[e.LabelHam])
+		if perLabel <= 0 || rarest < perLabel {
+			perLabel = rarest
+		}
+	}
+	if !balance && perLabel <= 0 {
+		return examples
+	}
+
+	kept := make(map[e.Label]int)
+	limited := make([]e.Example, 0, len(examples))
+	for _, ex := range slices.Backward(examples) {
+		if kept[ex.Label] >= perLabel {
+			continue
+		}
+		kept[ex.Label]++
+		limited = append(limited, ex)
+	}
+	slices.Reverse(limited)
+	return limited
+}
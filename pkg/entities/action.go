@@ -1,12 +1,58 @@
 package entities
 
+import "time"
+
 type Action struct {
 	Kind ActionKind
 	Note string
+
+	// Category is the spam category of an erased message, empty if unknown
+	Category SpamCategory
+
+	// ScoreBefore and ScoreAfter are the sender's scores before and after the
+	// message was handled, equal if the score did not change
+	ScoreBefore int
+	ScoreAfter  int
+
+	// Delay postpones the action, zero applies it immediately
+	Delay time.Duration
+
+	// Trace explains the decision
+	Trace Trace
+
+	// Usage is what asking the AI took, nil if it was not asked
+	Usage *AIUsage
 }
 
 type ActionKind string
 
+// SpamCategory classifies spam, so policies and reports can treat kinds of
+// spam differently
+type SpamCategory string
+
+const (
+	SpamCategoryCryptoScam SpamCategory = "crypto_scam"
+	SpamCategoryJobScam    SpamCategory = "job_scam"
+	SpamCategoryAdult      SpamCategory = "adult"
+	SpamCategoryGambling   SpamCategory = "gambling"
+	SpamCategoryPhishing   SpamCategory = "phishing"
+	SpamCategoryAds        SpamCategory = "ads"
+	SpamCategoryFlood      SpamCategory = "flood"
+	SpamCategoryOther      SpamCategory = "other"
+)
+
+// SpamCategories lists all known spam categories
+var SpamCategories = []SpamCategory{
+	SpamCategoryCryptoScam,
+	SpamCategoryJobScam,
+	SpamCategoryAdult,
+	SpamCategoryGambling,
+	SpamCategoryPhishing,
+	SpamCategoryAds,
+	SpamCategoryFlood,
+	SpamCategoryOther,
+}
+
 const (
 	// ActionKindNoop is a noop action meaning nothing has to be done with a message
 	ActionKindNoop = "noop"
@@ -16,4 +62,8 @@ const (
 
 	// ActionKindBan indicates that a user should be banned
 	ActionKindBan = "ban"
+
+	// ActionKindMute indicates that a message should be deleted and the user
+	// temporarily restricted from sending messages
+	ActionKindMute = "mute"
 )
@@ -0,0 +1,50 @@
+package entities
+
+import "time"
+
+// AuditKind is a kind of change recorded in the audit log
+type AuditKind string
+
+const (
+	// AuditKindScore is a change of a user's score
+	AuditKindScore AuditKind = "score"
+
+	// AuditKindSettings is a change of a chat's settings
+	AuditKindSettings AuditKind = "settings"
+
+	// AuditKindOverride is a moderator reversing or replacing a decision
+	AuditKindOverride AuditKind = "override"
+
+	// AuditKindBan and AuditKindUnban are bans and unbans of a user
+	AuditKindBan   AuditKind = "ban"
+	AuditKindUnban AuditKind = "unban"
+
+	// AuditKindErasure is an erasure of a user's data on request
+	AuditKindErasure AuditKind = "erasure"
+)
+
+// AuditActorBot is the actor of automated changes
+const AuditActorBot = "bot"
+
+// AuditEntry is a record of the audit log
+type AuditEntry struct {
+	ID   int64
+	Kind AuditKind
+
+	// Actor who made the change: AuditActorBot, a Telegram user ID or the
+	// name of a command line tool
+	Actor string
+
+	ChatID string
+
+	// UserID is the user affected by the change, empty for chat-wide changes
+	UserID string
+
+	// Before and After are the changed value, e.g. a score or a JSON
+	// document of settings, empty if there is none
+	Before string
+	After  string
+
+	Reason    string
+	CreatedAt time.Time
+}
@@ -0,0 +1,33 @@
+package entities
+
+import "time"
+
+// Ban is a ban of a user in a chat
+type Ban struct {
+	ID     int64
+	ChatID string
+	UserID string
+	Reason string
+
+	// IssuedBy is the actor who banned the user: AuditActorBot, a Telegram
+	// user ID or the name of a command line tool
+	IssuedBy string
+
+	CreatedAt time.Time
+
+	// ExpiresAt is the time the ban ends, nil for a permanent ban
+	ExpiresAt *time.Time
+
+	// RevokedAt is the time the ban was lifted before its expiry, nil if it
+	// was not, RevokedBy is the actor who lifted it
+	RevokedAt *time.Time
+	RevokedBy string
+}
+
+// Active reports whether the ban is in force at the time
+func (b Ban) Active(now time.Time) bool {
+	if b.RevokedAt != nil {
+		return false
+	}
+	return b.ExpiresAt == nil || b.ExpiresAt.After(now)
+}
@@ -0,0 +1,50 @@
+package entities
+
+import "time"
+
+type Chat struct {
+	ID    string
+	Title string
+
+	// Type is the Telegram chat type: group, supergroup or channel, empty
+	// until the chat is refreshed
+	Type     string
+	Username string
+
+	// MemberCount is the number of members at the last refresh, zero if
+	// unknown
+	MemberCount int
+
+	// Language is the language tag of the admin who added the bot, a hint
+	// of the chat's language, empty if unknown
+	Language string
+
+	// JoinedAt is the time the bot joined the chat, zero if it joined before
+	// the time was recorded
+	JoinedAt time.Time
+
+	// RefreshedAt is the time of the last refresh from the Bot API, zero if
+	// the chat was never refreshed
+	RefreshedAt time.Time
+}
+
+// ChatStats are moderation counters of a chat over a period
+type ChatStats struct {
+	// Checked is the number of messages checked for spam
+	Checked int
+
+	// Erased is the number of messages erased as spam without a ban
+	Erased int
+
+	// Banned is the number of users banned
+	Banned int
+
+	// Errors is the number of messages whose check failed
+	Errors int
+
+	// Categories counts removed messages by spam category
+	Categories map[SpamCategory]int
+
+	// Spend is what asking the AI about the messages took
+	Spend Spend
+}
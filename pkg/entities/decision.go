@@ -0,0 +1,17 @@
+package entities
+
+// Decision is the outcome of moderating a message, stored at once: the
+// message, the action taken on it or the error that prevented deciding on
+// one, and the sender's new score
+type Decision struct {
+	Message Message
+
+	// Action is nil if no action was decided on
+	Action *Action
+
+	// Error is why the check of the message failed
+	Error string
+
+	// Score is the sender's new score, nil if it didn't change
+	Score *int
+}
@@ -0,0 +1,9 @@
+package entities
+
+// SimilarMessage is a stored message found by embedding similarity
+type SimilarMessage struct {
+	SavedMessage
+
+	// Similarity is the cosine similarity to the searched vector, from -1 to 1
+	Similarity float64
+}
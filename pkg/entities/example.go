@@ -0,0 +1,49 @@
+package entities
+
+import "time"
+
+// Label is the ground truth class of a message
+type Label string
+
+const (
+	LabelSpam Label = "spam"
+	LabelHam  Label = "ham"
+)
+
+// LabelOf returns the label the action implies: spam for any action removing
+// the message, ham for noop
+func LabelOf(kind ActionKind) Label {
+	if kind == ActionKindNoop {
+		return LabelHam
+	}
+	return LabelSpam
+}
+
+// Example is a labeled message for evaluating prompts or fine-tuning
+type Example struct {
+	ChatID      string       `json:"chat_id"`
+	MessageID   string       `json:"message_id"`
+	Text        string       `json:"text"`
+	MediaType   *string      `json:"media_type,omitempty"`
+	MediaFileID *string      `json:"media_file_id,omitempty"`
+	Action      ActionKind   `json:"action"`
+	Category    SpamCategory `json:"category,omitempty"`
+	Label       Label        `json:"label"`
+	CreatedAt   time.Time    `json:"created_at"`
+}
+
+// GroundTruth is an externally labeled example, e.g. from another bot or
+// manual labeling
+type GroundTruth struct {
+	Example
+
+	// Source names where the example came from
+	Source string `json:"source"`
+}
+
+// ImportResult reports what an import added
+type ImportResult struct {
+	Imported   int
+	Duplicates int
+	Skipped    int
+}
@@ -0,0 +1,25 @@
+package entities
+
+// Features are cheap heuristic signals extracted from a message without any
+// AI call
+type Features struct {
+	// TextHash is a hash of the de-obfuscated text, equal for re-posts that
+	// differ only in obfuscation
+	TextHash string `json:"text_hash"`
+
+	// TextLength is the text length in characters
+	TextLength int `json:"text_length"`
+
+	// LinkCount is the number of links in the text
+	LinkCount int `json:"link_count"`
+
+	// MentionCount is the number of @username mentions in the text
+	MentionCount int `json:"mention_count"`
+
+	// MixedScript is true if a word mixes Latin and Cyrillic letters, a
+	// typical homoglyph obfuscation
+	MixedScript bool `json:"mixed_script"`
+
+	// HasMedia is true if the message has an attachment
+	HasMedia bool `json:"has_media"`
+}
@@ -0,0 +1,27 @@
+package entities
+
+import "time"
+
+// SpamFingerprint is the hash of the normalized text of confirmed spam, so
+// re-posts of it, including obfuscated ones, are recognized without the AI
+type SpamFingerprint struct {
+	// Hash is textnorm.Hash of the text
+	Hash string
+
+	// Sample is the beginning of the text the fingerprint was taken from
+	Sample string
+
+	Category SpamCategory
+
+	// Source tells how the spam was confirmed: "override" by an admin, or
+	// the ground truth source it was imported from
+	Source string
+
+	// Hits is how many re-posts were matched
+	Hits int
+
+	CreatedAt time.Time
+
+	// LastSeenAt is the time of the last match, zero if there was none
+	LastSeenAt time.Time
+}
@@ -0,0 +1,25 @@
+package entities
+
+import "time"
+
+// ScoreChange is a change of a user's score recorded in the audit log
+type ScoreChange struct {
+	Before int
+	After  int
+	Reason string
+	At     time.Time
+}
+
+// UserHistory is a user's recent activity in a chat
+type UserHistory struct {
+	User User
+
+	// Score is the current score, nil if the user has none yet
+	Score *int
+
+	// Messages are the recent messages with their decisions, newest first
+	Messages []SavedMessage
+
+	// ScoreChanges is the trajectory of the score, oldest first
+	ScoreChanges []ScoreChange
+}
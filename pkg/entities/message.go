@@ -13,9 +13,12 @@ type Message struct {
 	Sender      User
 	ID          string
 	Text        string
-	MediaType   *string // MIME type, nil if no attachment
-	MediaFileID *string // Telegram file ID (permanent, used for on-demand download)
-	MediaSize   *int64  // Original size in bytes
+	MediaType   *string  // MIME type, nil if no attachment
+	MediaFileID *string  // Telegram file ID (permanent, used for on-demand download)
+	MediaSize   *int64   // Original size in bytes
+	IsForward   bool     // Message was forwarded from another chat or user
+	Links       []string // URLs hidden behind text links, not visible in Text
+	Mentions    []string // Usernames of @mentions marked by Telegram, without the @
 }
 
 type SavedMessage struct {
@@ -25,10 +28,13 @@ type SavedMessage struct {
 	CreatedAt   time.Time
 	Action      *ActionKind
 	ActionNote  *string
+	Category    *SpamCategory
+	Trace       *Trace
 	Error       *string
 	MediaType   *string
 	MediaFileID *string
 	MediaSize   *int64
+	Usage       *AIUsage
 }
 
 func (m *Message) HasText() bool {
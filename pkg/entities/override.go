@@ -0,0 +1,48 @@
+package entities
+
+import "time"
+
+// OverrideKind is the way an admin overrode the bot's decision
+type OverrideKind string
+
+const (
+	// OverrideKindRestore is a removed message found to be no spam
+	OverrideKindRestore OverrideKind = "restore"
+
+	// OverrideKindUnban is a ban lifted because the message was no spam
+	OverrideKindUnban OverrideKind = "unban"
+
+	// OverrideKindConfirmSpam is a message confirmed to be spam, whatever
+	// the bot decided
+	OverrideKindConfirmSpam OverrideKind = "confirm_spam"
+)
+
+// Label returns the label the override gives to the message
+func (k OverrideKind) Label() Label {
+	if k == OverrideKindConfirmSpam {
+		return LabelSpam
+	}
+	return LabelHam
+}
+
+// Override is an admin's correction of the decision on a message
+type Override struct {
+	ID int64
+
+	// ChatID and MessageID are Telegram IDs of the overridden message
+	ChatID    string
+	MessageID string
+
+	Kind OverrideKind
+
+	// Actor is the admin's Telegram user ID or the name of a command line
+	// tool
+	Actor string
+	Note  string
+
+	// Original is the action the bot took on the message, empty if it made
+	// no decision
+	Original ActionKind
+
+	CreatedAt time.Time
+}
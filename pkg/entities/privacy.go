@@ -0,0 +1,10 @@
+package entities
+
+// ErasureResult counts the user data erased on the user's request
+type ErasureResult struct {
+	// Messages is the number of anonymized messages
+	Messages int64
+
+	// Scores is the number of deleted scores, one per chat
+	Scores int64
+}
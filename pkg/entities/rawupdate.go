@@ -0,0 +1,18 @@
+package entities
+
+import "time"
+
+// RawUpdate is a Telegram update as received, archived for replay
+type RawUpdate struct {
+	UpdateID int64
+
+	// ChatID, MessageID and UserID identify the message of the update
+	ChatID    string
+	MessageID string
+	UserID    string
+
+	// Payload is the update JSON
+	Payload []byte
+
+	CreatedAt time.Time
+}
@@ -0,0 +1,41 @@
+package entities
+
+// RetentionPolicy limits how much message history is kept, zero fields
+// don't limit
+type RetentionPolicy struct {
+	// Days after which message bodies (text and media references) are
+	// erased. The rows stay, so statistics and per-user counts are kept.
+	Days int
+
+	// MaxRowsPerChat is how many most recent messages are kept per chat,
+	// older ones are deleted and folded into daily statistics counters
+	MaxRowsPerChat int
+
+	// RawUpdateDays after which archived raw updates are deleted
+	RawUpdateDays int
+}
+
+// IsZero reports whether the policy keeps everything
+func (p RetentionPolicy) IsZero() bool {
+	return p.Days <= 0 && p.MaxRowsPerChat <= 0 && p.RawUpdateDays <= 0
+}
+
+// PruneResult reports what a retention run removed
+type PruneResult struct {
+	BodiesErased      int64
+	RowsDeleted       int64
+	RawUpdatesDeleted int64
+	VerdictsDeleted   int64
+}
+
+// VacuumResult reports the size of the database before and after it was
+// rebuilt to give the space of deleted data back
+type VacuumResult struct {
+	SizeBefore int64
+	SizeAfter  int64
+}
+
+// Reclaimed returns the bytes given back
+func (r VacuumResult) Reclaimed() int64 {
+	return r.SizeBefore - r.SizeAfter
+}
@@ -0,0 +1,215 @@
+package entities
+
+import (
+	"fmt"
+	"time"
+)
+
+// ChatSettings holds per-chat moderation configuration
+type ChatSettings struct {
+	// TrustedScore and BanScore override the bot-wide score thresholds
+	TrustedScore *int `json:"trusted_score,omitempty"`
+	BanScore     *int `json:"ban_score,omitempty"`
+
+	// DryRun makes the bot only report the actions it would take (to the
+	// logs and the mod log) without applying them
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Language of the messages the bot posts in the chat, "en" if empty
+	Language string `json:"language,omitempty"`
+
+	// Announce sets whether the bot posts a notice in the chat when it
+	// removes a message, silent if empty
+	Announce AnnounceMode `json:"announce,omitempty"`
+
+	// Features turns parts of the moderation pipeline on or off, features
+	// not listed are enabled
+	Features map[Feature]bool `json:"features,omitempty"`
+
+	// ModLogChatID is a chat (or channel) where every moderation action taken
+	// in the chat is reported, empty disables the mod log
+	ModLogChatID string `json:"mod_log_chat_id,omitempty"`
+
+	// Digest is how often a moderation summary is sent, empty disables digests
+	Digest DigestInterval `json:"digest,omitempty"`
+
+	// DigestChatID is where digests are sent, defaults to the mod log chat.
+	// A user ID delivers digests via private messages.
+	DigestChatID string `json:"digest_chat_id,omitempty"`
+
+	// LinkOnlyAction is taken without an AI call on a link-only or forwarded
+	// message among the first messages of a new user, empty disables the rule
+	LinkOnlyAction ActionKind `json:"link_only_action,omitempty"`
+
+	// InviteLinkAction is taken without an AI call on a message from an
+	// untrusted user advertising a Telegram chat or channel: an invite
+	// link, a public t.me link or an @mention. Empty or noop allows them.
+	InviteLinkAction ActionKind `json:"invite_link_action,omitempty"`
+
+	// Probation replaces the first score penalty of a user with a probation
+	// period, optional
+	Probation *Probation `json:"probation,omitempty"`
+
+	// Timezone is the chat's IANA time zone name, UTC if empty
+	Timezone string `json:"timezone,omitempty"`
+
+	// QuietHours is a daily window of stricter moderation, optional
+	QuietHours *QuietHours `json:"quiet_hours,omitempty"`
+
+	// CategoryActions overrides the score system for spam of the given
+	// categories, e.g. always ban phishing or only erase ads
+	CategoryActions map[SpamCategory]ActionKind `json:"category_actions,omitempty"`
+
+	// NSFWAction is taken on images the vision check flags as sexually
+	// explicit or graphic, regardless of the sender's trust. It does not
+	// change the sender's score. Empty disables the check.
+	NSFWAction ActionKind `json:"nsfw_action,omitempty"`
+}
+
+// QuietHours is a daily time window, in the chat's time zone, when admins
+// are not around and moderation is stricter. The window may wrap midnight.
+type QuietHours struct {
+	// From and To are "HH:MM" local times, From inclusive and To exclusive
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// MediaAction is taken without an AI call on any media message from an
+	// untrusted user during quiet hours, empty disables it
+	MediaAction ActionKind `json:"media_action,omitempty"`
+}
+
+// Probation is a period after the first spam verdict of a user. The first
+// offense costs no score; instead the next messages are checked even if the
+// user becomes trusted, earn no score, and actions on them are delayed so
+// the sender can't tell which message tripped the filter.
+type Probation struct {
+	// Messages is how many messages the probation lasts, 0 disables it
+	Messages int `json:"messages"`
+
+	// HoldSeconds delays actions taken on messages sent during probation
+	HoldSeconds int `json:"hold_seconds,omitempty"`
+}
+
+// Enabled reports whether the probation is configured
+func (p *Probation) Enabled() bool {
+	return p != nil && p.Messages > 0
+}
+
+// Hold returns the delay for actions taken during probation
+func (p *Probation) Hold() time.Duration {
+	return time.Duration(p.HoldSeconds) * time.Second
+}
+
+// Location returns the chat's time zone
+func (s *ChatSettings) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(s.Timezone)
+}
+
+// Validate checks the settings for values that would fail at use time
+func (s *ChatSettings) Validate() error {
+	if _, err := s.Location(); err != nil {
+		return fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	if s.QuietHours != nil {
+		if _, err := ParseClock(s.QuietHours.From); err != nil {
+			return fmt.Errorf("invalid quiet hours start: %w", err)
+		}
+		if _, err := ParseClock(s.QuietHours.To); err != nil {
+			return fmt.Errorf("invalid quiet hours end: %w", err)
+		}
+	}
+
+	if s.Probation != nil && (s.Probation.Messages < 0 || s.Probation.HoldSeconds < 0) {
+		return fmt.Errorf("invalid probation: negative values")
+	}
+
+	if s.TrustedScore != nil && s.BanScore != nil && *s.BanScore >= *s.TrustedScore {
+		return fmt.Errorf("ban score %d must be below trusted score %d", *s.BanScore, *s.TrustedScore)
+	}
+
+	switch s.Announce {
+	case "", AnnounceSilent, AnnounceNotice:
+	default:
+		return fmt.Errorf("unknown announce mode %q", s.Announce)
+	}
+
+	return nil
+}
+
+// Contains reports whether the local time t falls into the window
+func (q *QuietHours) Contains(t time.Time) bool {
+	from, err := ParseClock(q.From)
+	if err != nil {
+		return false
+	}
+	to, err := ParseClock(q.To)
+	if err != nil {
+		return false
+	}
+
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if from <= to {
+		return now >= from && now < to
+	}
+	// The window wraps midnight, e.g. 23:00-07:00
+	return now >= from || now < to
+}
+
+// ParseClock parses an "HH:MM" time of day into the duration since midnight
+func ParseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// FeatureEnabled reports whether the feature is on in the chat
+func (s *ChatSettings) FeatureEnabled(f Feature) bool {
+	enabled, ok := s.Features[f]
+	return !ok || enabled
+}
+
+// Feature is a part of the moderation pipeline that can be turned off per chat
+type Feature string
+
+const (
+	// FeatureAI checks messages with the AI (and the decision webhook), with
+	// it off only the zero-cost rules apply
+	FeatureAI Feature = "ai"
+
+	// FeatureMedia analyzes images and other media, with it off only the text
+	// of a message is checked
+	FeatureMedia Feature = "media"
+)
+
+// AnnounceMode defines whether moderation actions are announced in the chat
+type AnnounceMode string
+
+const (
+	AnnounceSilent AnnounceMode = "silent"
+	AnnounceNotice AnnounceMode = "notice"
+)
+
+type DigestInterval string
+
+const (
+	DigestDaily  DigestInterval = "daily"
+	DigestWeekly DigestInterval = "weekly"
+)
+
+func (s *ChatSettings) HasModLog() bool {
+	return s.ModLogChatID != ""
+}
+
+// DigestTarget returns the chat digests are sent to, empty if there is none
+func (s *ChatSettings) DigestTarget() string {
+	if s.DigestChatID != "" {
+		return s.DigestChatID
+	}
+	return s.ModLogChatID
+}
@@ -0,0 +1,65 @@
+package entities
+
+import "time"
+
+// DayStats are moderation counters of a day
+type DayStats struct {
+	Day time.Time
+
+	// Checked is the number of messages checked for spam
+	Checked int
+
+	// Removed is the number of messages erased, including with a ban or mute
+	Removed int
+}
+
+// Confidence is the average confidence of AI verdicts over a number of them
+type Confidence struct {
+	Average float64
+	Count   int
+}
+
+// UserOffenses is the number of a user's messages removed as spam
+type UserOffenses struct {
+	User     User
+	Offenses int
+}
+
+// PromptVersionStats are counters of decisions made by an AI model with a
+// prompt version, for comparing prompts
+type PromptVersionStats struct {
+	Model         string
+	PromptVersion string
+
+	Checked int
+	Removed int
+
+	// First and Last are the times of the first and the last decision
+	First time.Time
+	Last  time.Time
+}
+
+// ChatDayStats are moderation counters of a chat on a day
+type ChatDayStats struct {
+	ChatID string
+	Day    time.Time
+
+	// Checked is the number of messages checked for spam
+	Checked int
+
+	// Erased, Banned and Muted are the numbers of messages removed by each
+	// action, Erased without a ban or mute
+	Erased int
+	Banned int
+	Muted  int
+
+	// Errors is the number of messages whose check failed
+	Errors int
+
+	// Overridden is the number of messages whose latest admin override found
+	// them not to be spam, the bot's false positives
+	Overridden int
+
+	// Spend is the AI usage of the checks
+	Spend Spend
+}
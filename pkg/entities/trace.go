@@ -0,0 +1,57 @@
+package entities
+
+// DecisionStage is the part of the moderation pipeline that decided on a
+// message
+type DecisionStage string
+
+const (
+	// DecisionStageRule is a zero-cost heuristic rule (spam fingerprints,
+	// link-only messages, invite links, quiet hours)
+	DecisionStageRule DecisionStage = "rule"
+
+	// DecisionStageWebhook is the external decision webhook
+	DecisionStageWebhook DecisionStage = "webhook"
+
+	// DecisionStageClassifier is the operator's own spam classifier
+	DecisionStageClassifier DecisionStage = "classifier"
+
+	// DecisionStageModeration is the moderation model screening texts for
+	// harmful content before the AI spam check
+	DecisionStageModeration DecisionStage = "moderation"
+
+	// DecisionStageAI is the AI spam (or NSFW) check
+	DecisionStageAI DecisionStage = "ai"
+)
+
+// Trace explains how a decision on a message was made
+type Trace struct {
+	Stage DecisionStage `json:"stage"`
+
+	// Rule names the heuristic rule for the rule stage
+	Rule string `json:"rule,omitempty"`
+
+	// Model and PromptVersion identify the AI call for the ai stage, Model
+	// the moderation model for the moderation stage and the classifier's
+	// model, if it tells one, for the classifier stage
+	Model         string `json:"model,omitempty"`
+	PromptVersion string `json:"prompt_version,omitempty"`
+
+	// Language is the detected language of the message the AI prompt was
+	// picked for, empty if the prompt isn't localized
+	Language string `json:"language,omitempty"`
+
+	// Experiment is the arm of the prompt experiment the message was in,
+	// control or candidate, empty if there was none
+	Experiment string `json:"experiment,omitempty"`
+
+	// Cached marks an AI verdict reused from an earlier check of the same
+	// text
+	Cached bool `json:"cached,omitempty"`
+
+	// Confidence is the decider's confidence in the verdict from 0 to 1, nil
+	// if it didn't report one
+	Confidence *float64 `json:"confidence,omitempty"`
+
+	ScoreBefore int `json:"score_before"`
+	ScoreAfter  int `json:"score_after"`
+}
@@ -0,0 +1,26 @@
+package entities
+
+import "time"
+
+// AIUsage is what asking the AI about a message took
+type AIUsage struct {
+	PromptTokens     int
+	CompletionTokens int
+
+	// Cost is in USD, zero if the price of the model is unknown
+	Cost float64
+}
+
+// Spend sums AI usage over a number of requests
+type Spend struct {
+	Requests         int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// DaySpend is the AI spend of a day
+type DaySpend struct {
+	Day time.Time
+	Spend
+}
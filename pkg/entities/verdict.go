@@ -0,0 +1,25 @@
+package entities
+
+import "time"
+
+// CachedVerdict is the AI's verdict on a text, reused for texts with the same
+// normalized form until it expires
+type CachedVerdict struct {
+	// TextHash is textnorm.Hash of the text
+	TextHash string
+
+	// PromptVersion is the version of the prompt the verdict was made with,
+	// a new prompt doesn't reuse verdicts of older ones
+	PromptVersion string
+
+	// Model is the model that made the verdict
+	Model string
+
+	IsSpam     bool
+	Category   SpamCategory
+	Confidence float64
+	Note       string
+
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -0,0 +1,43 @@
+package entities
+
+import "time"
+
+// VerificationStatus is the state of a captcha challenge
+type VerificationStatus string
+
+const (
+	// VerificationPending is a challenge waiting for the user's answer
+	VerificationPending VerificationStatus = "pending"
+
+	// VerificationPassed is a challenge the user answered correctly
+	VerificationPassed VerificationStatus = "passed"
+
+	// VerificationFailed is a challenge the user answered wrong
+	VerificationFailed VerificationStatus = "failed"
+
+	// VerificationExpired is a challenge left unanswered past its deadline,
+	// or superseded by a new one
+	VerificationExpired VerificationStatus = "expired"
+)
+
+// Verification is a captcha challenge issued to a user joining a chat
+type Verification struct {
+	ID     int64
+	ChatID string
+	UserID string
+
+	// Challenge is what the user has to answer, e.g. the expected answer or
+	// the ID of the challenge message, opaque to the store
+	Challenge string
+
+	IssuedAt time.Time
+
+	// Deadline is the time the user has to answer by
+	Deadline time.Time
+
+	Status VerificationStatus
+
+	// ResolvedAt is the time the challenge stopped being pending, nil while
+	// it is
+	ResolvedAt *time.Time
+}
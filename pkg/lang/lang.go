@@ -0,0 +1,207 @@
+// Package lang tells the language of short texts such as chat messages
+// locally, without a model: by the script of the letters, then by the
+// letters particular to a language and by frequent short words. It tells
+// apart the languages common in the chats the bot moderates and is meant
+// for picking a prompt, not for linguistics.
+package lang
+
+import (
+	"strings"
+	"unicode"
+)
+
+// minLetters is the fewest letters a text needs for its language to be told
+const minLetters = 3
+
+// names of the languages Detect returns, in English
+var names = map[string]string{
+	"ar": "Arabic", "be": "Belarusian", "de": "German", "el": "Greek",
+	"en": "English", "es": "Spanish", "fa": "Persian", "fr": "French",
+	"he": "Hebrew", "hi": "Hindi", "hy": "Armenian", "it": "Italian",
+	"ja": "Japanese", "ka": "Georgian", "kk": "Kazakh", "ko": "Korean",
+	"pl": "Polish", "pt": "Portuguese", "ru": "Russian", "sr": "Serbian",
+	"th": "Thai", "tr": "Turkish", "uk": "Ukrainian", "zh": "Chinese",
+}
+
+// Name returns the English name of the language, the code if it's unknown
+func Name(code string) string {
+	if name, ok := names[code]; ok {
+		return name
+	}
+	return code
+}
+
+// scripts map the scripts of a single language to it
+var scripts = []struct {
+	table *unicode.RangeTable
+	code  string
+}{
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Hangul, "ko"},
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Han, "zh"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+	{unicode.Armenian, "hy"},
+	{unicode.Georgian, "ka"},
+}
+
+// Letters particular to a language among those sharing its script
+var (
+	cyrillicMarkers = map[string]string{
+		"uk": "іїєґ",
+		"be": "ўі",
+		"kk": "әғқңөұүһі",
+		"sr": "ђјљњћџ",
+		"ru": "ыэёъ",
+	}
+	latinMarkers = map[string]string{
+		"de": "äöüß",
+		"es": "ñ¿¡",
+		"fr": "çàèêëîïôœùû",
+		"it": "àèìòù",
+		"pl": "ąęłśżźćń",
+		"pt": "ãõçâêô",
+		"tr": "ğışçöü",
+	}
+	persianLetters = "پچژگ"
+)
+
+// stopwords are frequent short words of the languages of the Latin script
+var stopwords = map[string][]string{
+	"en": {"the", "and", "you", "for", "is", "are", "with", "this", "that", "to", "of", "in", "it", "me", "my", "your", "have", "will", "can", "get"},
+	"es": {"el", "la", "los", "las", "que", "de", "y", "en", "es", "por", "para", "con", "un", "una", "mi", "tu", "te"},
+	"pt": {"o", "os", "que", "de", "e", "em", "não", "para", "com", "um", "uma", "você", "meu", "por"},
+	"fr": {"le", "la", "les", "et", "est", "des", "une", "un", "pour", "avec", "vous", "je", "pas", "que", "du"},
+	"de": {"der", "die", "das", "und", "ist", "ich", "du", "nicht", "mit", "für", "ein", "eine", "zu", "sie", "wir"},
+	"it": {"il", "di", "che", "e", "è", "per", "non", "un", "una", "con", "sono", "mi", "ti", "lo", "gli"},
+	"pl": {"i", "w", "na", "nie", "się", "że", "jest", "to", "z", "do", "jak", "mnie", "dla"},
+	"tr": {"ve", "bir", "bu", "için", "ile", "ben", "sen", "bana", "sana", "gibi", "çok", "ne", "mi", "da", "de", "var"},
+}
+
+// Detect returns the ISO 639-1 code of the language of the text, empty if
+// it can't be told, e.g. for a text of a few letters or of emoji only. Texts
+// in the Latin script without telling words are left undetected too.
+func Detect(text string) string {
+	text = strings.ToLower(text)
+
+	var latin, cyrillic, arabic, letters int
+	byScript := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		default:
+			for _, s := range scripts {
+				if unicode.Is(s.table, r) {
+					byScript[s.code]++
+					break
+				}
+			}
+		}
+	}
+	if letters < minLetters {
+		return ""
+	}
+
+	// Japanese is written with Han characters too, any kana tells it
+	if byScript["ja"] > 0 && byScript["zh"] > 0 {
+		byScript["ja"] += byScript["zh"]
+		delete(byScript, "zh")
+	}
+	best, count := "", 0
+	for code, n := range byScript {
+		if n > count || (n == count && code < best) {
+			best, count = code, n
+		}
+	}
+
+	switch {
+	case count > latin && count > cyrillic && count > arabic:
+		return best
+	case arabic > latin && arabic > cyrillic:
+		if strings.ContainsAny(text, persianLetters) {
+			return "fa"
+		}
+		return "ar"
+	case cyrillic >= latin:
+		return cyrillicLanguage(text)
+	default:
+		return latinLanguage(text)
+	}
+}
+
+// cyrillicLanguage tells the language of a text in the Cyrillic script by
+// its particular letters, Russian if there are none
+func cyrillicLanguage(text string) string {
+	scores := markerScores(text, cyrillicMarkers)
+	// і is shared by Ukrainian, Belarusian and Kazakh, the others tell them
+	switch {
+	case scores["sr"] > 0:
+		return "sr"
+	case scores["kk"] > scores["uk"] && strings.ContainsAny(text, "әғқңөұүһ"):
+		return "kk"
+	case strings.ContainsRune(text, 'ў'):
+		return "be"
+	case scores["uk"] > 0 && scores["uk"] >= scores["ru"]:
+		return "uk"
+	default:
+		return "ru"
+	}
+}
+
+// latinLanguage tells the language of a text in the Latin script by its
+// frequent words, its particular letters breaking ties
+func latinLanguage(text string) string {
+	scores := markerScores(text, latinMarkers)
+	for code := range scores {
+		// A particular letter counts for less than a word
+		scores[code] = min(scores[code], 2)
+	}
+
+	words := strings.FieldsFunc(text, func(r rune) bool { return !unicode.IsLetter(r) && r != '\'' })
+	for _, word := range words {
+		for code, list := range stopwords {
+			for _, stopword := range list {
+				if word == stopword {
+					scores[code] += 3
+					break
+				}
+			}
+		}
+	}
+
+	best, score := "", 0
+	for code, s := range scores {
+		if s > score || (s == score && code < best) {
+			best, score = code, s
+		}
+	}
+	if score < 3 {
+		return ""
+	}
+	return best
+}
+
+// markerScores counts the letters of the text particular to each language
+func markerScores(text string, markers map[string]string) map[string]int {
+	scores := make(map[string]int, len(markers))
+	for _, r := range text {
+		for code, letters := range markers {
+			if strings.ContainsRune(letters, r) {
+				scores[code]++
+			}
+		}
+	}
+	return scores
+}
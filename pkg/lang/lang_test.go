@@ -0,0 +1,39 @@
+package lang
+
+import "testing"
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "Нужен лёгкий заработок от 200$ в день? Пишите мне в л.с.", want: "ru"},
+		{text: "Привіт! Шукаємо людей для роботи з дому, пишіть в особисті", want: "uk"},
+		{text: "Earn money from home, write me for the details", want: "en"},
+		{text: "Gana dinero desde casa, escríbeme para más información", want: "es"},
+		{text: "Verdiene Geld von zu Hause, schreib mir für Details", want: "de"},
+		{text: "Evden para kazan, detaylar için bana yaz", want: "tr"},
+		{text: "Zarabiaj w domu, napisz do mnie po szczegóły", want: "pl"},
+		{text: "اربح المال من المنزل", want: "ar"},
+		{text: "在家赚钱，私信我", want: "zh"},
+		{text: "家でお金を稼ぐ、メッセージください", want: "ja"},
+		{text: "Κερδίστε χρήματα από το σπίτι", want: "el"},
+		// Homoglyphs don't make a Russian text Latin
+		{text: "Привeт, нyжно буквально 2 человека", want: "ru"},
+		{text: "ok", want: ""},
+		{text: "👍👍👍 100500", want: ""},
+		{text: "Buy followers cheap", want: ""},
+	}
+
+	for _, tc := range tests {
+		if got := Detect(tc.text); got != tc.want {
+			t.Errorf("Detect(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	if Name("uk") != "Ukrainian" || Name("xx") != "xx" {
+		t.Errorf("Name = %q, %q", Name("uk"), Name("xx"))
+	}
+}
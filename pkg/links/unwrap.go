@@ -0,0 +1,137 @@
+// Package links resolves where links in messages lead
+package links
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultMaxHops is the number of redirects followed by default
+const DefaultMaxHops = 5
+
+// Unwrapper follows the redirects of links, e.g. of URL shorteners, to where
+// they lead. It sends HEAD requests to public addresses only, so links in
+// untrusted messages can't make it reach into the bot's own network.
+type Unwrapper struct {
+	client  *http.Client
+	maxHops int
+}
+
+// NewUnwrapper returns an unwrapper following up to DefaultMaxHops
+// redirects, each request bounded by the timeout
+func NewUnwrapper(timeout time.Duration) *Unwrapper {
+	return newUnwrapper(&net.Dialer{Timeout: timeout, Control: publicOnly}, timeout)
+}
+
+func newUnwrapper(dialer *net.Dialer, timeout time.Duration) *Unwrapper {
+	return &Unwrapper{
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: timeout,
+				DisableKeepAlives:   true,
+			},
+			Timeout: timeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxHops: DefaultMaxHops,
+	}
+}
+
+// Unwrap returns the URL the link leads to. A link without a scheme, such as
+// "t.me/channel", is taken as https. On an error the URL reached so far is
+// returned with it.
+func (u *Unwrapper) Unwrap(ctx context.Context, link string) (string, error) {
+	current, err := Parse(link)
+	if err != nil {
+		return "", err
+	}
+
+	for range u.maxHops {
+		next, err := u.next(ctx, current)
+		if err != nil || next == nil {
+			return current.String(), err
+		}
+		current = next
+	}
+
+	return current.String(), fmt.Errorf("more than %d redirects", u.maxHops)
+}
+
+// next returns where the URL redirects to, nil if it doesn't
+func (u *Unwrapper) next(ctx context.Context, current *url.URL) (*url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, current.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	res, err := u.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting %s: %w", current.Host, err)
+	}
+	_ = res.Body.Close()
+
+	location := res.Header.Get("Location")
+	if res.StatusCode < 300 || res.StatusCode >= 400 || location == "" {
+		return nil, nil
+	}
+
+	next, err := current.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("parsing redirect: %w", err)
+	}
+	if next.Scheme != "http" && next.Scheme != "https" {
+		return nil, fmt.Errorf("redirect to a %s url", next.Scheme)
+	}
+	return next, nil
+}
+
+// Parse parses a link as written in a message, taking one without a scheme
+// as https
+func Parse(link string) (*url.URL, error) {
+	link = strings.TrimSpace(link)
+	if !strings.Contains(link, "://") {
+		link = "https://" + link
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return nil, fmt.Errorf("parsing link: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("not a web link: %s", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("link without a host")
+	}
+	return u, nil
+}
+
+// Domain returns the host of the URL in lower case without "www."
+func Domain(u *url.URL) string {
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// publicOnly refuses connections to addresses other than public unicast
+// ones. It's checked on the resolved address, so a name resolving to a
+// private address is refused too.
+func publicOnly(_, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return fmt.Errorf("address %s is not public", host)
+	}
+	return nil
+}
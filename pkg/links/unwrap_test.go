@@ -0,0 +1,89 @@
+package links
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUnwrapper_Unwrap(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("method = %s, want HEAD", r.Method)
+		}
+		http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/landing?ref=spam", http.StatusFound)
+	})
+	mux.HandleFunc("/landing", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	u := newUnwrapper(&net.Dialer{}, time.Second)
+
+	got, err := u.Unwrap(context.Background(), srv.URL+"/short")
+	if err != nil || got != srv.URL+"/landing?ref=spam" {
+		t.Errorf("Unwrap = %q, %v, want the landing page", got, err)
+	}
+
+	if got, err = u.Unwrap(context.Background(), srv.URL+"/loop"); err == nil || got != srv.URL+"/loop" {
+		t.Errorf("Unwrap = %q, %v, want an error after too many redirects", got, err)
+	}
+
+	if _, err = u.Unwrap(context.Background(), srv.URL+"/ftp"); err == nil {
+		t.Error("followed a redirect to an ftp url")
+	}
+}
+
+func TestUnwrapper_PublicOnly(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer srv.Close()
+
+	if _, err := NewUnwrapper(time.Second).Unwrap(context.Background(), srv.URL); err == nil {
+		t.Error("no error for a loopback address")
+	}
+
+	for _, address := range []string{"10.0.0.1:80", "192.168.1.1:443", "169.254.169.254:80", "[::1]:80", "0.0.0.0:80"} {
+		if publicOnly("tcp", address, nil) == nil {
+			t.Errorf("%s allowed", address)
+		}
+	}
+	if err := publicOnly("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := map[string]string{
+		"t.me/channel":            "t.me",
+		"https://WWW.Example.com": "example.com",
+		" http://bit.ly/x ":       "bit.ly",
+	}
+	for link, want := range tests {
+		u, err := Parse(link)
+		if err != nil || Domain(u) != want {
+			t.Errorf("Parse(%q) = %v, %v, want the domain %s", link, u, err, want)
+		}
+	}
+
+	for _, link := range []string{"javascript:alert(1)", "ftp://example.com", "https://"} {
+		if _, err := Parse(link); err == nil {
+			t.Errorf("Parse(%q): no error", link)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 // Package media converts media the OpenAI vision API cannot decode directly
 // (e.g. Telegram video stickers, which are VP9/WEBM) into a still image the
-// pipeline can analyze.
+// pipeline can analyze, and shrinks images larger than the model needs.
 package media
 
 import (
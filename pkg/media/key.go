@@ -0,0 +1,55 @@
+package media
+
+import (
+	"path"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+// Layouts of stored media files
+const (
+	// LayoutChatDate puts the files into directories of their chat and the
+	// day of their message, e.g. -1001234567890/2025-01-31/AgADBAAD.jpg
+	LayoutChatDate = "chat-date"
+
+	// LayoutFlat puts all the files into the store's root
+	LayoutFlat = "flat"
+)
+
+// Key returns the blob key of the media of the message in the layout, the
+// message must have a media file ID and type
+func Key(layout string, msg e.SavedMessage) string {
+	name := *msg.MediaFileID + Extension(*msg.MediaType)
+	if layout == LayoutFlat {
+		return name
+	}
+	return path.Join(msg.Sender.ChatID, msg.CreatedAt.UTC().Format(time.DateOnly), name)
+}
+
+// Extension returns the file extension of the mime type, empty for an
+// unknown one
+func Extension(mimeType string) string {
+	switch mimeType {
+	case "image/jpeg":
+		return ".jpg"
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "video/mp4":
+		return ".mp4"
+	case "video/webm":
+		return ".webm"
+	case "audio/mpeg":
+		return ".mp3"
+	case "audio/ogg":
+		return ".ogg"
+	case "application/pdf":
+		return ".pdf"
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,25 @@
+package media
+
+import (
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestKey(t *testing.T) {
+	fileID, mimeType := "AgADBAAD", "image/jpeg"
+	msg := e.SavedMessage{
+		Sender:      e.User{ChatID: "-1001234567890"},
+		MediaFileID: &fileID,
+		MediaType:   &mimeType,
+		CreatedAt:   time.Date(2025, 1, 31, 23, 30, 0, 0, time.FixedZone("", -2*60*60)),
+	}
+
+	if got, want := Key(LayoutChatDate, msg), "-1001234567890/2025-02-01/AgADBAAD.jpg"; got != want {
+		t.Errorf("Key(chat-date) = %q, want %q", got, want)
+	}
+	if got, want := Key(LayoutFlat, msg), "AgADBAAD.jpg"; got != want {
+		t.Errorf("Key(flat) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,111 @@
+package media
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif" // decoded for downscaling, the first frame
+	"image/jpeg"
+	_ "image/png" // decoded for downscaling
+)
+
+// DefaultJPEGQuality is the quality of downscaled images by default
+const DefaultJPEGQuality = 85
+
+// Downscaler shrinks images larger than the vision model needs, which cost
+// tokens and upload time for detail the model doesn't use. Images are
+// re-encoded as JPEG only when they're shrunk, formats the standard library
+// can't decode, such as WebP, are kept as they are.
+type Downscaler struct {
+	// MaxDimension is the longest side of a downscaled image in pixels
+	MaxDimension int
+
+	// Quality is the JPEG quality of downscaled images, defaults to
+	// DefaultJPEGQuality
+	Quality int
+}
+
+// NewDownscaler returns a downscaler fitting images into a square of the
+// side, nil if the side is zero
+func NewDownscaler(maxDimension, quality int) *Downscaler {
+	if maxDimension <= 0 {
+		return nil
+	}
+	if quality <= 0 || quality > 100 {
+		quality = DefaultJPEGQuality
+	}
+	return &Downscaler{MaxDimension: maxDimension, Quality: quality}
+}
+
+// Downscale returns the image shrunk to fit MaxDimension as a JPEG, or the
+// image as is if it fits or can't be decoded
+func (d *Downscaler) Downscale(content []byte, mimeType string) ([]byte, string, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		// Not a format we decode, the vision API may still take it
+		return content, mimeType, nil
+	}
+	if config.Width <= d.MaxDimension && config.Height <= d.MaxDimension {
+		return content, mimeType, nil
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(content))
+	if err != nil {
+		return nil, "", fmt.Errorf("decoding image: %w", err)
+	}
+
+	width, height := fit(config.Width, config.Height, d.MaxDimension)
+	var out bytes.Buffer
+	if err = jpeg.Encode(&out, boxScale(src, width, height), &jpeg.Options{Quality: d.Quality}); err != nil {
+		return nil, "", fmt.Errorf("encoding image: %w", err)
+	}
+	return out.Bytes(), "image/jpeg", nil
+}
+
+// fit returns the size of the image scaled to fit into a square of the side,
+// keeping its aspect ratio
+func fit(width, height, side int) (int, int) {
+	if width >= height {
+		return side, max(1, height*side/width)
+	}
+	return max(1, width*side/height), side
+}
+
+// boxScale shrinks the image to the size, each pixel averaging the box of
+// the source it covers. Transparency is flattened onto white, as JPEG has
+// none.
+func boxScale(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	for y := range height {
+		y0, y1 := y*srcH/height, max((y+1)*srcH/height, y*srcH/height+1)
+		for x := range width {
+			x0, x1 := x*srcW/width, max((x+1)*srcW/width, x*srcW/width+1)
+
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := flat.Pix[sy*flat.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					r += int(row[sx*4])
+					g += int(row[sx*4+1])
+					b += int(row[sx*4+2])
+					n++
+				}
+			}
+
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = 0xFF
+		}
+	}
+	return dst
+}
@@ -0,0 +1,73 @@
+package media
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func pngImage(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			// Left half opaque red, right half transparent
+			if x < width/2 {
+				img.Set(x, y, color.NRGBA{R: 0xFF, A: 0xFF})
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("encoding png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestDownscaler_Downscale(t *testing.T) {
+	d := NewDownscaler(100, 0)
+
+	out, mimeType, err := d.Downscale(pngImage(t, 400, 200), "image/png")
+	if err != nil {
+		t.Fatalf("Downscale: %v", err)
+	}
+	if mimeType != "image/jpeg" || !bytes.HasPrefix(out, jpegMagic) {
+		t.Fatalf("mime type = %s, want a jpeg", mimeType)
+	}
+	img, _, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decoding the result: %v", err)
+	}
+	if size := img.Bounds().Size(); size != image.Pt(100, 50) {
+		t.Errorf("size = %v, want 100x50", size)
+	}
+
+	// Transparency is flattened onto white
+	if r, g, b, _ := img.At(90, 25).RGBA(); r>>8 < 0xF0 || g>>8 < 0xF0 || b>>8 < 0xF0 {
+		t.Errorf("transparent pixel = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+	if r, g, _, _ := img.At(10, 25).RGBA(); r>>8 < 0xF0 || g>>8 > 0x20 {
+		t.Errorf("red pixel = %d,%d, want red", r>>8, g>>8)
+	}
+
+	// Images that fit and formats that can't be decoded are kept
+	small := pngImage(t, 100, 80)
+	for _, tc := range []struct {
+		content  []byte
+		mimeType string
+	}{
+		{small, "image/png"},
+		{[]byte("RIFF....WEBPVP8 "), "image/webp"},
+	} {
+		out, mimeType, err = d.Downscale(tc.content, tc.mimeType)
+		if err != nil || mimeType != tc.mimeType || !bytes.Equal(out, tc.content) {
+			t.Errorf("Downscale(%s) = %s, %v, want it kept", tc.mimeType, mimeType, err)
+		}
+	}
+
+	if NewDownscaler(0, 85) != nil {
+		t.Error("NewDownscaler(0) isn't nil")
+	}
+}
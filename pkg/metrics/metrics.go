@@ -0,0 +1,240 @@
+// Package metrics keeps counters and summaries of the bot's subsystems and
+// serves them in the Prometheus text exposition format. It covers the few
+// metric kinds the bot needs without pulling in a client library.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default is the registry the bot's subsystems report to
+var Default = NewRegistry()
+
+const (
+	kindCounter = "counter"
+	kindGauge   = "gauge"
+	kindSummary = "summary"
+)
+
+// Registry holds metrics by name
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]*vec
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*vec)}
+}
+
+// Counter is a metric which only goes up, e.g. a number of calls
+type Counter struct{ v *vec }
+
+// Add adds the value to the series with the label values, given in the order
+// of the label names of the counter
+func (c Counter) Add(value float64, labelValues ...string) {
+	c.v.update(labelValues, func(s *series) { s.value += value })
+}
+
+// Inc adds one to the series with the label values
+func (c Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Gauge is a metric which goes up and down, e.g. a queue length
+type Gauge struct{ v *vec }
+
+// Set sets the value of the series with the label values
+func (g Gauge) Set(value float64, labelValues ...string) {
+	g.v.update(labelValues, func(s *series) { s.value = value })
+}
+
+// Summary tracks the sum and the count of observations, e.g. of durations,
+// from which the average is computed at query time
+type Summary struct{ v *vec }
+
+// Observe records the value in the series with the label values
+func (s Summary) Observe(value float64, labelValues ...string) {
+	s.v.update(labelValues, func(s *series) {
+		s.value += value
+		s.count++
+	})
+}
+
+// Counter returns the counter with the name, registering it on first use
+func (r *Registry) Counter(name, help string, labelNames ...string) Counter {
+	return Counter{r.register(name, help, kindCounter, labelNames)}
+}
+
+// Gauge returns the gauge with the name, registering it on first use
+func (r *Registry) Gauge(name, help string, labelNames ...string) Gauge {
+	return Gauge{r.register(name, help, kindGauge, labelNames)}
+}
+
+// Summary returns the summary with the name, registering it on first use
+func (r *Registry) Summary(name, help string, labelNames ...string) Summary {
+	return Summary{r.register(name, help, kindSummary, labelNames)}
+}
+
+func (r *Registry) register(name, help, kind string, labelNames []string) *vec {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if v, ok := r.metrics[name]; ok {
+		if v.kind != kind || strings.Join(v.labelNames, ",") != strings.Join(labelNames, ",") {
+			panic(fmt.Sprintf("metric %s is already registered as a %s with labels %v", name, v.kind, v.labelNames))
+		}
+		return v
+	}
+
+	v := &vec{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		series:     make(map[string]*series),
+	}
+	r.metrics[name] = v
+	return v
+}
+
+// WriteTo writes all metrics in the Prometheus text format, ordered by name
+// and labels
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	vecs := make([]*vec, 0, len(r.metrics))
+	for _, v := range r.metrics {
+		vecs = append(vecs, v)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(vecs, func(i, j int) bool { return vecs[i].name < vecs[j].name })
+
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+	for _, v := range vecs {
+		v.write(cw)
+	}
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+// Handler serves the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		_, _ = r.WriteTo(w)
+	})
+}
+
+type vec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels string
+	value  float64
+	count  uint64
+}
+
+func (v *vec) update(labelValues []string, fn func(s *series)) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labelNames), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: formatLabels(v.labelNames, labelValues)}
+		v.series[key] = s
+	}
+	fn(s)
+}
+
+func (v *vec) write(w io.Writer) {
+	v.mu.Lock()
+	all := make([]series, 0, len(v.series))
+	for _, s := range v.series {
+		all = append(all, *s)
+	}
+	v.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].labels < all[j].labels })
+
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+	for _, s := range all {
+		if v.kind == kindSummary {
+			_, _ = fmt.Fprintf(w, "%s_sum%s %s\n", v.name, s.labels, formatValue(s.value))
+			_, _ = fmt.Fprintf(w, "%s_count%s %d\n", v.name, s.labels, s.count)
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s%s %s\n", v.name, s.labels, formatValue(s.value))
+	}
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		sb.WriteString(name)
+		sb.WriteString(`="`)
+		sb.WriteString(labelEscaper.Replace(values[i]))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// countingWriter counts written bytes and keeps the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
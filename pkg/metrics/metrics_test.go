@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	r := NewRegistry()
+
+	calls := r.Counter("calls_total", "Number of calls.", "method", "status")
+	calls.Inc("GetScore", "ok")
+	calls.Inc("GetScore", "ok")
+	calls.Inc("SaveDecision", "error")
+
+	duration := r.Summary("call_duration_seconds", "Call duration.", "method")
+	duration.Observe(0.25, "GetScore")
+	duration.Observe(0.5, "GetScore")
+
+	r.Gauge("queue_length", "Queued items.").Set(3)
+	r.Counter("odd_total", "Odd labels.", "name").Inc("a \"quoted\"\nname")
+
+	var sb strings.Builder
+	if _, err := r.WriteTo(&sb); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+
+	want := `# HELP call_duration_seconds Call duration.
+# TYPE call_duration_seconds summary
+call_duration_seconds_sum{method="GetScore"} 0.75
+call_duration_seconds_count{method="GetScore"} 2
+# HELP calls_total Number of calls.
+# TYPE calls_total counter
+calls_total{method="GetScore",status="ok"} 2
+calls_total{method="SaveDecision",status="error"} 1
+# HELP odd_total Odd labels.
+# TYPE odd_total counter
+odd_total{name="a \"quoted\"\nname"} 1
+# HELP queue_length Queued items.
+# TYPE queue_length gauge
+queue_length 3
+`
+	if sb.String() != want {
+		t.Errorf("WriteTo =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestRegistry_ReturnsRegisteredMetric(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("calls_total", "Number of calls.", "method").Inc("a")
+	r.Counter("calls_total", "Number of calls.", "method").Inc("a")
+
+	var sb strings.Builder
+	_, _ = r.WriteTo(&sb)
+	if !strings.Contains(sb.String(), `calls_total{method="a"} 2`) {
+		t.Errorf("counter registered twice is not shared:\n%s", sb.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a counter as a gauge did not panic")
+		}
+	}()
+	r.Gauge("calls_total", "Number of calls.", "method")
+}
@@ -0,0 +1,160 @@
+// Package redact masks personal data in texts before they are sent to a
+// third party, such as an AI provider. Every value is replaced with a
+// numbered placeholder, e.g. [PHONE_1], and the mapping back to the values
+// stays with the caller, so the answer can be read with the values restored.
+// The same value gets the same placeholder, which keeps a text repeating a
+// phone number recognizable as doing so.
+package redact
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Kind is a kind of personal data
+type Kind string
+
+const (
+	// KindEmail is an email address
+	KindEmail Kind = "email"
+
+	// KindPhone is a phone number: ten to fifteen digits, or seven and more
+	// after a plus sign, possibly separated by spaces, dashes, dots and
+	// parentheses
+	KindPhone Kind = "phone"
+
+	// KindUser is a Telegram user: an @username or the ID of a tg://user link
+	KindUser Kind = "user"
+)
+
+// Kinds lists the kinds in the order they're masked in
+var Kinds = []Kind{KindEmail, KindUser, KindPhone}
+
+var patterns = map[Kind]*regexp.Regexp{
+	KindEmail: regexp.MustCompile(`[\p{L}\p{N}._%+-]+@[\p{L}\p{N}-]+(?:\.[\p{L}\p{N}-]+)+`),
+	KindUser:  regexp.MustCompile(`(?i)tg://user\?id=\d+|(?:^|[^\p{L}\p{N}_])@[a-z][a-z0-9_]{3,31}`),
+	KindPhone: regexp.MustCompile(`\+?\(?\d[\d\s().-]{5,}\d`),
+}
+
+// Redactor masks the kinds of personal data it's made for
+type Redactor struct {
+	kinds []Kind
+}
+
+// NewRedactor returns a redactor of the kinds, all of them if none are given
+func NewRedactor(kinds ...Kind) (*Redactor, error) {
+	if len(kinds) == 0 {
+		return &Redactor{kinds: Kinds}, nil
+	}
+
+	for _, k := range kinds {
+		if _, ok := patterns[k]; !ok {
+			return nil, fmt.Errorf("unknown kind of personal data %q", k)
+		}
+	}
+
+	r := &Redactor{}
+	for _, kind := range Kinds {
+		if slices.Contains(kinds, kind) {
+			r.kinds = append(r.kinds, kind)
+		}
+	}
+	return r, nil
+}
+
+// NewMapping returns an empty mapping of the redactor, texts redacted with
+// one mapping share placeholders
+func (r *Redactor) NewMapping() *Mapping {
+	return &Mapping{
+		kinds:        r.kinds,
+		values:       make(map[string]string),
+		placeholders: make(map[string]string),
+		counts:       make(map[Kind]int),
+	}
+}
+
+// Mapping keeps the values masked by placeholders. A nil mapping leaves
+// texts as they are.
+type Mapping struct {
+	kinds        []Kind
+	values       map[string]string // by placeholder
+	placeholders map[string]string // by kind and value
+	counts       map[Kind]int
+}
+
+// Redact returns the text with the personal data masked
+func (m *Mapping) Redact(text string) string {
+	if m == nil {
+		return text
+	}
+
+	for _, kind := range m.kinds {
+		text = patterns[kind].ReplaceAllStringFunc(text, func(match string) string {
+			value, prefix := match, ""
+			if kind == KindUser && !strings.HasPrefix(strings.ToLower(match), "tg:") {
+				// The character before a mention is matched to tell it from
+				// an email
+				at := strings.IndexByte(match, '@')
+				prefix, value = match[:at], match[at:]
+			}
+			if kind == KindPhone && !isPhone(value) {
+				return match
+			}
+			return prefix + m.placeholder(kind, value)
+		})
+	}
+	return text
+}
+
+// Restore returns the text with the placeholders replaced with the values
+// they mask, e.g. in an explanation the AI gave
+func (m *Mapping) Restore(text string) string {
+	if m == nil || len(m.values) == 0 || !strings.Contains(text, "[") {
+		return text
+	}
+
+	pairs := make([]string, 0, 2*len(m.values))
+	for placeholder, value := range m.values {
+		pairs = append(pairs, placeholder, value)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// Len returns the number of values masked
+func (m *Mapping) Len() int {
+	if m == nil {
+		return 0
+	}
+	return len(m.values)
+}
+
+func (m *Mapping) placeholder(kind Kind, value string) string {
+	key := string(kind) + "\x00" + value
+	if placeholder, ok := m.placeholders[key]; ok {
+		return placeholder
+	}
+
+	m.counts[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", strings.ToUpper(string(kind)), m.counts[kind])
+	m.placeholders[key] = placeholder
+	m.values[placeholder] = value
+	return placeholder
+}
+
+// isPhone reports whether the match of the phone pattern has the digits of a
+// phone number
+func isPhone(match string) bool {
+	digits := 0
+	for _, r := range match {
+		if unicode.IsDigit(r) {
+			digits++
+		}
+	}
+	if strings.HasPrefix(match, "+") {
+		return digits >= 7 && digits <= 15
+	}
+	return digits >= 10 && digits <= 15
+}
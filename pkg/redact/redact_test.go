@@ -0,0 +1,83 @@
+package redact
+
+import (
+	"testing"
+)
+
+func TestMapping_Redact(t *testing.T) {
+	r, err := NewRedactor()
+	if err != nil {
+		t.Fatalf("NewRedactor: %v", err)
+	}
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{
+			text: "Write to john.doe@example.com or call +7 (900) 123-45-67",
+			want: "Write to [EMAIL_1] or call [PHONE_1]",
+		},
+		{
+			text: "DM @crypto_guru, 89001234567, again 89001234567",
+			want: "DM [USER_1], [PHONE_1], again [PHONE_1]",
+		},
+		{
+			text: "profile tg://user?id=123456789",
+			want: "profile [USER_1]",
+		},
+		{
+			// Prices, years and short numbers aren't phones
+			text: "Earn 500000 rub in 2025, code 1234",
+			want: "Earn 500000 rub in 2025, code 1234",
+		},
+		{
+			text: "Привет, пишите @Manager_Bot",
+			want: "Привет, пишите [USER_1]",
+		},
+	}
+
+	for _, tc := range tests {
+		m := r.NewMapping()
+		got := m.Redact(tc.text)
+		if got != tc.want {
+			t.Errorf("Redact(%q) = %q, want %q", tc.text, got, tc.want)
+		}
+		if restored := m.Restore(got); restored != tc.text {
+			t.Errorf("Restore(%q) = %q, want the original", got, restored)
+		}
+	}
+}
+
+func TestMapping_SharedAcrossTexts(t *testing.T) {
+	r, _ := NewRedactor(KindEmail)
+	m := r.NewMapping()
+
+	first := m.Redact("a@example.com and b@example.com")
+	second := m.Redact("b@example.com, call +79001234567")
+	if first != "[EMAIL_1] and [EMAIL_2]" || second != "[EMAIL_2], call +79001234567" {
+		t.Errorf("redacted %q, %q, want shared placeholders and phones kept", first, second)
+	}
+	if m.Len() != 2 {
+		t.Errorf("Len = %d, want 2", m.Len())
+	}
+	if note := m.Restore("asks to write to [EMAIL_2]"); note != "asks to write to b@example.com" {
+		t.Errorf("Restore = %q", note)
+	}
+}
+
+func TestNilMapping(t *testing.T) {
+	var m *Mapping
+	if got := m.Redact("a@example.com"); got != "a@example.com" {
+		t.Errorf("Redact = %q, want the text as is", got)
+	}
+	if got := m.Restore("[EMAIL_1]"); got != "[EMAIL_1]" {
+		t.Errorf("Restore = %q, want the text as is", got)
+	}
+}
+
+func TestNewRedactor_UnknownKind(t *testing.T) {
+	if _, err := NewRedactor(KindPhone, "passport"); err == nil {
+		t.Error("unknown kind accepted")
+	}
+}
@@ -0,0 +1,48 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// lockRetryInterval is how often Lock retries a held lock
+const lockRetryInterval = 20 * time.Millisecond
+
+// unlockScript deletes the lock only if it is still held by the token, so a
+// holder whose lock expired doesn't release the next holder's one
+const unlockScript = `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`
+
+// Lock acquires the lock with the key, waiting for it to be released until
+// the context is done. The lock expires after ttl if not released, so a
+// crashed holder doesn't keep it forever. The returned function releases it.
+func (c *Client) Lock(ctx context.Context, key string, ttl time.Duration) (func(ctx context.Context) error, error) {
+	var raw [16]byte
+	if _, err := rand.Read(raw[:]); err != nil {
+		return nil, fmt.Errorf("generating lock token: %w", err)
+	}
+	token := hex.EncodeToString(raw[:])
+
+	for {
+		acquired, err := c.SetNX(ctx, key, token, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("acquiring lock %s: %w", key, err)
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for lock %s: %w", key, ctx.Err())
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	return func(ctx context.Context) error {
+		_, err := c.Eval(ctx, unlockScript, []string{key}, token)
+		return err
+	}, nil
+}
@@ -0,0 +1,362 @@
+// Package redis is a minimal Redis client speaking RESP2 over TCP, enough
+// for sharing state between replicas of the bot: string keys with expiry and
+// scripts for compare-and-delete locks.
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNil is returned for a missing key
+var ErrNil = errors.New("redis: nil")
+
+// Error is an error reply of the server
+type Error string
+
+func (e Error) Error() string {
+	return "redis: " + string(e)
+}
+
+// Options of a client connection
+type Options struct {
+	Addr     string
+	Password string
+	DB       int
+
+	// PoolSize is the maximum number of idle connections kept open
+	PoolSize int
+
+	// DialTimeout bounds connecting, zero means no limit
+	DialTimeout time.Duration
+}
+
+// ParseURL parses a redis://[:password@]host[:port][/db] URL into Options
+func ParseURL(rawURL string) (Options, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Options{}, fmt.Errorf("parsing redis url: %w", err)
+	}
+	if u.Scheme != "redis" {
+		return Options{}, fmt.Errorf("unsupported redis url scheme %q", u.Scheme)
+	}
+
+	opts := Options{Addr: u.Host, PoolSize: 10, DialTimeout: 5 * time.Second}
+	if u.Port() == "" {
+		opts.Addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		opts.Password, _ = u.User.Password()
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		if opts.DB, err = strconv.Atoi(db); err != nil {
+			return Options{}, fmt.Errorf("parsing redis db %q: %w", db, err)
+		}
+	}
+
+	return opts, nil
+}
+
+// Client is a Redis client safe for concurrent use, it keeps a pool of
+// connections
+type Client struct {
+	opts Options
+	idle chan *conn
+}
+
+// NewClient returns a client connecting on demand
+func NewClient(opts Options) *Client {
+	return &Client{opts: opts, idle: make(chan *conn, max(opts.PoolSize, 1))}
+}
+
+// Close closes idle connections
+func (c *Client) Close() error {
+	for {
+		select {
+		case cn := <-c.idle:
+			_ = cn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Do sends the command and returns its reply: a string, an int64, nil for a
+// null reply, or a []any for an array. An error reply is returned as Error.
+func (c *Client) Do(ctx context.Context, args ...any) (any, error) {
+	cn, err := c.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := cn.do(ctx, args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		// The connection state is unknown after a network error
+		_ = cn.Close()
+		return nil, err
+	}
+
+	c.put(cn)
+	return reply, err
+}
+
+// Get returns the value of the key, ErrNil if it is missing
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	reply, err := c.Do(ctx, "GET", key)
+	if err != nil {
+		return "", err
+	}
+	if reply == nil {
+		return "", ErrNil
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", fmt.Errorf("redis: unexpected GET reply %T", reply)
+	}
+	return s, nil
+}
+
+// MGet returns values of the keys, missing ones are nil
+func (c *Client) MGet(ctx context.Context, keys ...string) ([]*string, error) {
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "MGET")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != len(keys) {
+		return nil, fmt.Errorf("redis: unexpected MGET reply %T", reply)
+	}
+
+	values := make([]*string, len(items))
+	for i, item := range items {
+		if s, ok := item.(string); ok {
+			values[i] = &s
+		}
+	}
+	return values, nil
+}
+
+// Set sets the value of the key expiring after ttl, zero ttl never expires
+func (c *Client) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	args := []any{"SET", key, value}
+	if ttl > 0 {
+		args = append(args, "PX", ttl.Milliseconds())
+	}
+	_, err := c.Do(ctx, args...)
+	return err
+}
+
+// SetNX sets the value of the key expiring after ttl unless the key exists,
+// set is false if it does
+func (c *Client) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	reply, err := c.Do(ctx, "SET", key, value, "NX", "PX", ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+// Del deletes the keys and returns the number of keys deleted
+func (c *Client) Del(ctx context.Context, keys ...string) (int64, error) {
+	args := make([]any, 0, len(keys)+1)
+	args = append(args, "DEL")
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	reply, err := c.Do(ctx, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, _ := reply.(int64)
+	return n, nil
+}
+
+// Scan returns a batch of keys matching the pattern from the cursor on, "0"
+// to start, and the cursor of the next batch, "0" after the last one. count
+// hints at the size of a batch. Unlike KEYS it doesn't block the server for
+// the whole keyspace, but keys changed during the iteration may be missed.
+func (c *Client) Scan(ctx context.Context, cursor, match string, count int) (string, []string, error) {
+	reply, err := c.Do(ctx, "SCAN", cursor, "MATCH", match, "COUNT", count)
+	if err != nil {
+		return "", nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok || len(items) != 2 {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN reply %T", reply)
+	}
+	next, ok := items[0].(string)
+	batch, ok2 := items[1].([]any)
+	if !ok || !ok2 {
+		return "", nil, fmt.Errorf("redis: unexpected SCAN reply %v", items)
+	}
+
+	keys := make([]string, 0, len(batch))
+	for _, item := range batch {
+		if key, ok := item.(string); ok {
+			keys = append(keys, key)
+		}
+	}
+	return next, keys, nil
+}
+
+// Eval runs the Lua script with the keys and arguments
+func (c *Client) Eval(ctx context.Context, script string, keys []string, args ...any) (any, error) {
+	cmd := make([]any, 0, 3+len(keys)+len(args))
+	cmd = append(cmd, "EVAL", script, len(keys))
+	for _, key := range keys {
+		cmd = append(cmd, key)
+	}
+	cmd = append(cmd, args...)
+	return c.Do(ctx, cmd...)
+}
+
+func (c *Client) conn(ctx context.Context) (*conn, error) {
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	dialer := net.Dialer{Timeout: c.opts.DialTimeout}
+	netConn, err := dialer.DialContext(ctx, "tcp", c.opts.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("redis: connecting: %w", err)
+	}
+	cn := &conn{Conn: netConn, r: bufio.NewReader(netConn)}
+
+	if c.opts.Password != "" {
+		if _, err = cn.do(ctx, []any{"AUTH", c.opts.Password}); err != nil {
+			_ = cn.Close()
+			return nil, fmt.Errorf("redis: authenticating: %w", err)
+		}
+	}
+	if c.opts.DB != 0 {
+		if _, err = cn.do(ctx, []any{"SELECT", c.opts.DB}); err != nil {
+			_ = cn.Close()
+			return nil, fmt.Errorf("redis: selecting db: %w", err)
+		}
+	}
+
+	return cn, nil
+}
+
+func (c *Client) put(cn *conn) {
+	select {
+	case c.idle <- cn:
+	default:
+		_ = cn.Close()
+	}
+}
+
+type conn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (cn *conn) do(ctx context.Context, args []any) (any, error) {
+	deadline, _ := ctx.Deadline()
+	if err := cn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	if _, err := cn.Write(encodeCommand(args)); err != nil {
+		return nil, fmt.Errorf("redis: writing command: %w", err)
+	}
+
+	return readReply(cn.r)
+}
+
+// encodeCommand encodes the command as a RESP array of bulk strings
+func encodeCommand(args []any) []byte {
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		var s string
+		switch v := arg.(type) {
+		case string:
+			s = v
+		case []byte:
+			s = string(v)
+		case int:
+			s = strconv.Itoa(v)
+		case int64:
+			s = strconv.FormatInt(v, 10)
+		default:
+			s = fmt.Sprint(v)
+		}
+		buf = append(buf, "$"+strconv.Itoa(len(s))+"\r\n"+s+"\r\n"...)
+	}
+	return buf
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: reading reply: %w", err)
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, Error(payload)
+	case ':':
+		return strconv.ParseInt(payload, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("redis: reading bulk string: %w", err)
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]any, count)
+		for i := range items {
+			// An error reply inside an array, e.g. of a script, is an item
+			item, err := readReply(r)
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				item, err = replyErr, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	}
+
+	return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+}
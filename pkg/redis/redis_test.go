@@ -0,0 +1,265 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"maps"
+	"net"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers the commands used by the client from a map, ignoring
+// expiry
+type fakeServer struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	data map[string]string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	s := &fakeServer{ln: ln, data: make(map[string]string)}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+
+	return s
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	r := bufio.NewReader(conn)
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = item.(string)
+		}
+
+		if _, err = conn.Write([]byte(s.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+func (s *fakeServer) exec(args []string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bulk := func(key string) string {
+		v, ok := s.data[key]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+	}
+
+	switch strings.ToUpper(args[0]) {
+	case "GET":
+		return bulk(args[1])
+	case "MGET":
+		out := "*" + strconv.Itoa(len(args)-1) + "\r\n"
+		for _, key := range args[1:] {
+			out += bulk(key)
+		}
+		return out
+	case "SET":
+		if len(args) > 3 && args[3] == "NX" {
+			if _, ok := s.data[args[1]]; ok {
+				return "$-1\r\n"
+			}
+		}
+		s.data[args[1]] = args[2]
+		return "+OK\r\n"
+	case "DEL":
+		var n int
+		for _, key := range args[1:] {
+			if _, ok := s.data[key]; ok {
+				delete(s.data, key)
+				n++
+			}
+		}
+		return ":" + strconv.Itoa(n) + "\r\n"
+	case "SCAN":
+		// SCAN cursor MATCH pattern COUNT n over the sorted keys, the cursor
+		// being the index of the next key
+		cursor, _ := strconv.Atoi(args[1])
+		count, _ := strconv.Atoi(args[5])
+		keys := slices.Sorted(maps.Keys(s.data))
+		end := min(cursor+count, len(keys))
+		var batch []string
+		for _, key := range keys[cursor:end] {
+			if ok, _ := path.Match(args[3], key); ok {
+				batch = append(batch, key)
+			}
+		}
+		next := strconv.Itoa(end)
+		if end == len(keys) {
+			next = "0"
+		}
+		out := "*2\r\n$" + strconv.Itoa(len(next)) + "\r\n" + next + "\r\n*" + strconv.Itoa(len(batch)) + "\r\n"
+		for _, key := range batch {
+			out += "$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n"
+		}
+		return out
+	case "EVAL":
+		// Only the unlock script is supported
+		if args[2] == "1" && s.data[args[3]] == args[4] {
+			delete(s.data, args[3])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+
+	return "-ERR unknown command '" + args[0] + "'\r\n"
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	client := NewClient(Options{Addr: server.ln.Addr().String(), PoolSize: 2})
+	defer func() { _ = client.Close() }()
+
+	if _, err := client.Get(ctx, "missing"); !errors.Is(err, ErrNil) {
+		t.Errorf("Get missing = %v, want ErrNil", err)
+	}
+
+	if err := client.Set(ctx, "a", "1", time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if v, err := client.Get(ctx, "a"); err != nil || v != "1" {
+		t.Errorf("Get = %q, %v, want 1", v, err)
+	}
+
+	values, err := client.MGet(ctx, "a", "b")
+	if err != nil || len(values) != 2 || values[0] == nil || *values[0] != "1" || values[1] != nil {
+		t.Errorf("MGet = %v, %v, want 1 and nil", values, err)
+	}
+
+	if set, err := client.SetNX(ctx, "a", "2", time.Minute); err != nil || set {
+		t.Errorf("SetNX existing = %v, %v, want false", set, err)
+	}
+
+	if n, err := client.Del(ctx, "a", "b"); err != nil || n != 1 {
+		t.Errorf("Del = %d, %v, want 1", n, err)
+	}
+
+	var replyErr Error
+	if _, err = client.Do(ctx, "FLUSHALL"); !errors.As(err, &replyErr) {
+		t.Errorf("Do unknown = %v, want an error reply", err)
+	}
+	if _, err = client.Get(ctx, "a"); !errors.Is(err, ErrNil) {
+		t.Errorf("Get after an error reply = %v, want the connection usable", err)
+	}
+}
+
+func TestClient_Scan(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	client := NewClient(Options{Addr: server.ln.Addr().String()})
+	defer func() { _ = client.Close() }()
+
+	for _, key := range []string{"score:1", "score:2", "lock:1", "score:3", "other"} {
+		if err := client.Set(ctx, key, "1", 0); err != nil {
+			t.Fatalf("Set: %v", err)
+		}
+	}
+
+	var keys []string
+	cursor, batches := "0", 0
+	for {
+		next, batch, err := client.Scan(ctx, cursor, "score:*", 2)
+		if err != nil {
+			t.Fatalf("Scan: %v", err)
+		}
+		keys = append(keys, batch...)
+		batches++
+		if cursor = next; cursor == "0" {
+			break
+		}
+	}
+
+	slices.Sort(keys)
+	if !slices.Equal(keys, []string{"score:1", "score:2", "score:3"}) {
+		t.Errorf("Scan = %v, want the score keys", keys)
+	}
+	if batches != 3 {
+		t.Errorf("scanned in %d batches, want 3 of 2 keys", batches)
+	}
+}
+
+func TestClient_Lock(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeServer(t)
+	client := NewClient(Options{Addr: server.ln.Addr().String(), PoolSize: 4})
+
+	unlock, err := client.Lock(ctx, "lock", time.Minute)
+	if err != nil {
+		t.Fatalf("Lock: %v", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err = client.Lock(waitCtx, "lock", time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Lock held = %v, want a timeout", err)
+	}
+
+	if err = unlock(ctx); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+	if _, err = client.Lock(ctx, "lock", time.Minute); err != nil {
+		t.Errorf("Lock released = %v", err)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    Options
+		wantErr bool
+	}{
+		{url: "redis://localhost", want: Options{Addr: "localhost:6379"}},
+		{url: "redis://:secret@redis:6380/2", want: Options{Addr: "redis:6380", Password: "secret", DB: 2}},
+		{url: "http://localhost", wantErr: true},
+		{url: "redis://localhost/db", wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.url, func(t *testing.T) {
+			got, err := ParseURL(tc.url)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseURL error = %v, want error %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.Addr != tc.want.Addr || got.Password != tc.want.Password || got.DB != tc.want.DB {
+				t.Errorf("ParseURL = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
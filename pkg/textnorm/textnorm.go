@@ -0,0 +1,159 @@
+// Package textnorm undoes the cheap obfuscation tricks spammers use to slip
+// past filters: compatibility characters (fullwidth letters, math
+// alphanumerics), invisible zero-width characters, Cyrillic/Greek letters
+// posing as Latin ones and words spelled out letter by letter.
+package textnorm
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalize applies NFKC normalization and strips invisible characters. It
+// keeps the text readable (case, scripts and spacing are preserved), so the
+// result is suitable for showing to the AI model or to humans.
+func Normalize(text string) string {
+	text = norm.NFKC.String(text)
+	if !strings.ContainsFunc(text, isInvisible) {
+		return text
+	}
+	return strings.Map(func(r rune) rune {
+		if isInvisible(r) {
+			return -1
+		}
+		return r
+	}, text)
+}
+
+// Fold returns a comparison key for text: the normalized text lowercased,
+// with lookalike letters folded to their Latin counterparts, spaced-out
+// words joined back together and whitespace collapsed. The key is meant for
+// heuristics and deduplication, not for display - an ordinary Cyrillic word
+// folds into a mix of scripts, but it always folds the same way.
+func Fold(text string) string {
+	text = strings.ToLower(Normalize(text))
+	text = strings.Map(func(r rune) rune {
+		if l, ok := homoglyphs[r]; ok {
+			return l
+		}
+		return r
+	}, text)
+	return joinSpacedLetters(strings.Fields(text))
+}
+
+// Hash returns a stable hex-encoded SHA-256 of the folded text, so re-posts
+// that differ only in obfuscation share the same hash.
+func Hash(text string) string {
+	sum := sha256.Sum256([]byte(Fold(text)))
+	return hex.EncodeToString(sum[:])
+}
+
+// minSpacedRun is the number of consecutive single-letter words treated as a
+// spaced-out word ("f r e e" -> "free"). Shorter runs occur in normal text
+// ("a b" in a list, Russian "и в").
+const minSpacedRun = 3
+
+// joinSpacedLetters joins runs of single-letter words and glues the words
+// back together with single spaces.
+func joinSpacedLetters(words []string) string {
+	var sb strings.Builder
+	for i := 0; i < len(words); {
+		j := i
+		for j < len(words) && isSingleLetter(words[j]) {
+			j++
+		}
+
+		if sb.Len() > 0 {
+			sb.WriteByte(' ')
+		}
+
+		if j-i >= minSpacedRun {
+			for _, w := range words[i:j] {
+				sb.WriteString(w)
+			}
+			i = j
+			continue
+		}
+
+		sb.WriteString(words[i])
+		i++
+	}
+	return sb.String()
+}
+
+func isSingleLetter(word string) bool {
+	r, size := utf8.DecodeRuneInString(word)
+	return size == len(word) && unicode.IsLetter(r)
+}
+
+// isInvisible reports whether r renders as nothing: zero-width spaces and
+// joiners, word joiners, the BOM, soft hyphens and similar format characters
+// spammers insert between letters to break up keywords.
+func isInvisible(r rune) bool {
+	switch r {
+	case '\u00AD', // soft hyphen
+		'\u034F',           // combining grapheme joiner
+		'\u061C',           // arabic letter mark
+		'\u115F', '\u1160', // hangul choseong/jungseong fillers
+		'\u180E',                     // mongolian vowel separator
+		'\u200B', '\u200C', '\u200D', // zero-width space, non-joiner, joiner
+		'\u200E', '\u200F', // left-to-right and right-to-left marks
+		'\u2060', '\u2061', '\u2062', '\u2063', '\u2064', // word joiner, invisible operators
+		'\u3164', // hangul filler
+		'\uFEFF': // BOM / zero-width no-break space
+		return true
+	}
+	return false
+}
+
+// homoglyphs maps lowercase Cyrillic and Greek letters that look like Latin
+// letters to those Latin letters. Only lowercase entries are needed because
+// Fold lowercases first.
+var homoglyphs = map[rune]rune{
+	// Cyrillic
+	'а': 'a',
+	'в': 'b',
+	'г': 'r',
+	'е': 'e',
+	'ё': 'e',
+	'з': '3',
+	'к': 'k',
+	'м': 'm',
+	'н': 'h',
+	'о': 'o',
+	'п': 'n',
+	'р': 'p',
+	'с': 'c',
+	'т': 't',
+	'у': 'y',
+	'х': 'x',
+	'ь': 'b',
+	'і': 'i',
+	'ї': 'i',
+	'ј': 'j',
+	'ѕ': 's',
+	'ԁ': 'd',
+	'ԛ': 'q',
+	'ԝ': 'w',
+	'һ': 'h',
+	'ӏ': 'l',
+	// Greek
+	'α': 'a',
+	'β': 'b',
+	'γ': 'y',
+	'ε': 'e',
+	'η': 'n',
+	'ι': 'i',
+	'κ': 'k',
+	'ν': 'v',
+	'ο': 'o',
+	'ρ': 'p',
+	'τ': 't',
+	'υ': 'u',
+	'χ': 'x',
+}
@@ -0,0 +1,65 @@
+package textnorm
+
+import "testing"
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "plain text untouched", in: "Hello, мир!", want: "Hello, мир!"},
+		{name: "zero-width characters stripped", in: "fr\u200Bee mo\u200Dney\uFEFF", want: "free money"},
+		{name: "soft hyphen stripped", in: "cas\u00ADino", want: "casino"},
+		{name: "fullwidth folded by NFKC", in: "ＣＡＳＩＮＯ", want: "CASINO"},
+		{name: "math alphanumerics folded by NFKC", in: "𝐜𝐫𝐲𝐩𝐭𝐨", want: "crypto"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Normalize(tc.in); got != tc.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{name: "lowercased and whitespace collapsed", in: "  Free \n\t Money ", want: "free money"},
+		{name: "cyrillic homoglyphs folded", in: "саsіnо", want: "casino"},
+		{name: "greek homoglyphs folded", in: "κοin", want: "koin"},
+		{name: "spaced-out letters joined", in: "earn f r e e money", want: "earn free money"},
+		{name: "short single-letter runs kept", in: "a b testing", want: "a b testing"},
+		{name: "invisible characters stripped", in: "b\u200Bet", want: "bet"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Fold(tc.in); got != tc.want {
+				t.Errorf("Fold(%q) = %q, want %q", tc.in, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestHash_ObfuscatedVariantsCollide(t *testing.T) {
+	base := Hash("Free crypto signals")
+	for _, variant := range []string{
+		"free   CRYPTO signals",
+		"Frее сrурtо signals", // cyrillic е, с, р, у, о
+		"F r e e crypto sig\u200Bnals",
+	} {
+		if got := Hash(variant); got != base {
+			t.Errorf("Hash(%q) differs from the plain variant", variant)
+		}
+	}
+
+	if Hash("free crypto signals") == Hash("free crypto courses") {
+		t.Error("different texts must not share a hash")
+	}
+}
@@ -1,6 +1,7 @@
 package tg
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -10,6 +11,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const apiBase = "https://api.telegram.org"
@@ -64,6 +66,29 @@ func (c *Client) BanChatMember(ctx context.Context, chatID int64, userID int64)
 	return c.call(ctx, "banChatMember", params, nil)
 }
 
+// UnbanChatMember lifts a ban of a user in a chat. With onlyIfBanned a user
+// who is not banned is left alone, otherwise they are removed from the chat.
+func (c *Client) UnbanChatMember(ctx context.Context, chatID int64, userID int64, onlyIfBanned bool) error {
+	params := url.Values{
+		"chat_id":        {strconv.FormatInt(chatID, 10)},
+		"user_id":        {strconv.FormatInt(userID, 10)},
+		"only_if_banned": {strconv.FormatBool(onlyIfBanned)},
+	}
+	return c.call(ctx, "unbanChatMember", params, nil)
+}
+
+// RestrictChatMember forbids a user to send messages in a chat until the
+// given time.
+func (c *Client) RestrictChatMember(ctx context.Context, chatID int64, userID int64, until time.Time) error {
+	params := url.Values{
+		"chat_id":     {strconv.FormatInt(chatID, 10)},
+		"user_id":     {strconv.FormatInt(userID, 10)},
+		"permissions": {`{"can_send_messages":false}`},
+		"until_date":  {strconv.FormatInt(until.Unix(), 10)},
+	}
+	return c.call(ctx, "restrictChatMember", params, nil)
+}
+
 // SendMessage sends a text message.
 func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) error {
 	params := url.Values{
@@ -75,6 +100,47 @@ func (c *Client) SendMessage(ctx context.Context, chatID int64, text string) err
 	return c.call(ctx, "sendMessage", params, nil)
 }
 
+// GetChatAdministrators returns the administrators of a chat.
+func (c *Client) GetChatAdministrators(ctx context.Context, chatID int64) ([]ChatMember, error) {
+	params := url.Values{
+		"chat_id": {strconv.FormatInt(chatID, 10)},
+	}
+	var members []ChatMember
+	err := c.call(ctx, "getChatAdministrators", params, &members)
+	return members, err
+}
+
+// GetChat returns up-to-date information about a chat.
+func (c *Client) GetChat(ctx context.Context, chatID int64) (ChatFullInfo, error) {
+	params := url.Values{
+		"chat_id": {strconv.FormatInt(chatID, 10)},
+	}
+	var chat ChatFullInfo
+	err := c.call(ctx, "getChat", params, &chat)
+	return chat, err
+}
+
+// GetChatByUsername returns information about a public chat or channel by
+// its username, without the @.
+func (c *Client) GetChatByUsername(ctx context.Context, username string) (ChatFullInfo, error) {
+	params := url.Values{
+		"chat_id": {"@" + username},
+	}
+	var chat ChatFullInfo
+	err := c.call(ctx, "getChat", params, &chat)
+	return chat, err
+}
+
+// GetChatMemberCount returns the number of members in a chat.
+func (c *Client) GetChatMemberCount(ctx context.Context, chatID int64) (int, error) {
+	params := url.Values{
+		"chat_id": {strconv.FormatInt(chatID, 10)},
+	}
+	var count int
+	err := c.call(ctx, "getChatMemberCount", params, &count)
+	return count, err
+}
+
 // GetFile gets basic info about a file and prepares it for download.
 func (c *Client) GetFile(ctx context.Context, fileID string) (File, error) {
 	params := url.Values{
@@ -85,31 +151,75 @@ func (c *Client) GetFile(ctx context.Context, fileID string) (File, error) {
 	return file, err
 }
 
+// APIError is an error response of the Bot API.
+type APIError struct {
+	Code        int
+	Description string
+
+	// RetryAfter is how long to wait before repeating a request refused for
+	// flood control (code 429), zero for other errors
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("telegram api error %d: %s", e.Code, e.Description)
+}
+
+// ErrFileTooLarge is returned by DownloadFileTo for a file over the size
+// limit
+var ErrFileTooLarge = errors.New("file is too large")
+
 // DownloadFile downloads a file by its file ID.
 func (c *Client) DownloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := c.DownloadFileTo(ctx, fileID, &buf, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DownloadFileTo streams a file by its file ID to w and returns the number
+// of bytes written. A maxSize above zero fails a larger file with
+// ErrFileTooLarge, before downloading it if getFile tells its size.
+func (c *Client) DownloadFileTo(ctx context.Context, fileID string, w io.Writer, maxSize int64) (int64, error) {
 	file, err := c.GetFile(ctx, fileID)
 	if err != nil {
-		return nil, fmt.Errorf("getting file info: %w", err)
+		return 0, fmt.Errorf("getting file info: %w", err)
+	}
+	if maxSize > 0 && int64(file.FileSize) > maxSize {
+		return 0, fmt.Errorf("%w: %d bytes", ErrFileTooLarge, file.FileSize)
 	}
 
 	fileURL := fmt.Sprintf("%s/file/bot%s/%s", apiBase, c.token, file.FilePath)
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", c.redact(err))
+		return 0, fmt.Errorf("creating request: %w", c.redact(err))
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("downloading file: %w", c.redact(err))
+		return 0, fmt.Errorf("downloading file: %w", c.redact(err))
 	}
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	return io.ReadAll(resp.Body)
+	body := io.Reader(resp.Body)
+	if maxSize > 0 {
+		// One byte over the limit tells a larger file from one of the limit
+		body = io.LimitReader(resp.Body, maxSize+1)
+	}
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return n, fmt.Errorf("downloading file: %w", c.redact(err))
+	}
+	if maxSize > 0 && n > maxSize {
+		return n, fmt.Errorf("%w: over %d bytes", ErrFileTooLarge, maxSize)
+	}
+	return n, nil
 }
 
 // FileSize returns the file size from getFile, without downloading content.
@@ -148,7 +258,11 @@ func (c *Client) call(ctx context.Context, method string, params url.Values, res
 		return fmt.Errorf("decoding response: %w", err)
 	}
 	if !raw.OK {
-		return fmt.Errorf("telegram api error %d: %s", raw.ErrorCode, raw.Description)
+		apiErr := &APIError{Code: raw.ErrorCode, Description: raw.Description}
+		if raw.Parameters != nil {
+			apiErr.RetryAfter = time.Duration(raw.Parameters.RetryAfter) * time.Second
+		}
+		return apiErr
 	}
 
 	if result != nil {
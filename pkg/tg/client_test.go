@@ -1,11 +1,15 @@
 package tg
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 )
 
 const fakeToken = "8022662935:AAHoQWmMT_eIs-kyDQsecretsecretsecret"
@@ -36,3 +40,71 @@ func TestGetUpdatesRedactsTokenOnTransportError(t *testing.T) {
 		t.Fatalf("error message was not redacted: %q", msg)
 	}
 }
+
+type responseRoundTripper string
+
+func (r responseRoundTripper) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(string(r)))}, nil
+}
+
+// TestGetUpdatesKeepsRawJSON verifies that updates keep their JSON as
+// received, including fields the client doesn't decode.
+func TestGetUpdatesKeepsRawJSON(t *testing.T) {
+	raw := `{"update_id":7,"message":{"message_id":1,"text":"hi"},"message_reaction":{"x":1}}`
+	c := NewClient(fakeToken, &http.Client{Transport: responseRoundTripper(`{"ok":true,"result":[` + raw + `]}`)})
+
+	updates, err := c.GetUpdates(context.Background(), 0, 1)
+	if err != nil {
+		t.Fatalf("GetUpdates: %v", err)
+	}
+	if len(updates) != 1 || updates[0].UpdateID != 7 || string(updates[0].Raw) != raw {
+		t.Errorf("updates = %+v, want the update with its raw JSON", updates)
+	}
+}
+
+// fileRoundTripper serves getFile with the size and the file's content
+type fileRoundTripper struct {
+	size    int
+	content string
+}
+
+func (f fileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := f.content
+	if strings.HasSuffix(req.URL.Path, "/getFile") {
+		body = fmt.Sprintf(`{"ok":true,"result":{"file_id":"f","file_size":%d,"file_path":"videos/f.mp4"}}`, f.size)
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(body))}, nil
+}
+
+func TestDownloadFileTo(t *testing.T) {
+	ctx := context.Background()
+
+	c := NewClient(fakeToken, &http.Client{Transport: fileRoundTripper{size: 5, content: "video"}})
+	var buf bytes.Buffer
+	n, err := c.DownloadFileTo(ctx, "f", &buf, 5)
+	if err != nil || n != 5 || buf.String() != "video" {
+		t.Fatalf("DownloadFileTo = %d, %v, %q, want the file", n, err, buf.String())
+	}
+
+	if _, err = c.DownloadFileTo(ctx, "f", io.Discard, 4); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("DownloadFileTo over the limit = %v, want ErrFileTooLarge", err)
+	}
+
+	// getFile may not tell the size, the content is limited then
+	c = NewClient(fakeToken, &http.Client{Transport: fileRoundTripper{content: "a longer video"}})
+	if _, err = c.DownloadFileTo(ctx, "f", io.Discard, 5); !errors.Is(err, ErrFileTooLarge) {
+		t.Errorf("DownloadFileTo of unknown size = %v, want ErrFileTooLarge", err)
+	}
+}
+
+func TestAPIErrorRetryAfter(t *testing.T) {
+	c := NewClient(fakeToken, &http.Client{Transport: responseRoundTripper(
+		`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 7","parameters":{"retry_after":7}}`,
+	)})
+
+	_, err := c.GetFile(context.Background(), "f")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Code != 429 || apiErr.RetryAfter != 7*time.Second {
+		t.Fatalf("GetFile = %v, want an APIError to retry after 7s", err)
+	}
+}
@@ -1,11 +1,23 @@
 package tg
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // Response wraps all Telegram Bot API responses.
 type Response[T any] struct {
 	OK          bool   `json:"ok"`
 	Result      T      `json:"result"`
 	Description string `json:"description,omitempty"`
 	ErrorCode   int    `json:"error_code,omitempty"`
+
+	Parameters *ResponseParameters `json:"parameters,omitempty"`
+}
+
+// ResponseParameters tell why a request failed and how to go on.
+type ResponseParameters struct {
+	RetryAfter int `json:"retry_after,omitempty"`
 }
 
 // Update represents an incoming update from Telegram.
@@ -15,6 +27,45 @@ type Update struct {
 	EditedMessage     *Message `json:"edited_message,omitempty"`
 	ChannelPost       *Message `json:"channel_post,omitempty"`
 	EditedChannelPost *Message `json:"edited_channel_post,omitempty"`
+
+	MyChatMember *ChatMemberUpdated `json:"my_chat_member,omitempty"`
+
+	// Raw is the update JSON as received
+	Raw json.RawMessage `json:"-"`
+}
+
+// UnmarshalJSON decodes the update keeping its raw JSON.
+func (u *Update) UnmarshalJSON(data []byte) error {
+	type update Update
+	if err := json.Unmarshal(data, (*update)(u)); err != nil {
+		return err
+	}
+	u.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+// ChatMemberUpdated represents changes in the status of a chat member.
+type ChatMemberUpdated struct {
+	Chat          Chat       `json:"chat"`
+	From          User       `json:"from"`
+	Date          int        `json:"date"`
+	OldChatMember ChatMember `json:"old_chat_member"`
+	NewChatMember ChatMember `json:"new_chat_member"`
+}
+
+// ChatMember contains information about one member of a chat.
+type ChatMember struct {
+	Status string `json:"status"` // "creator", "administrator", "member", "restricted", "left" or "kicked"
+	User   *User  `json:"user"`
+}
+
+// IsPresent returns true if the member is in the chat.
+func (m *ChatMember) IsPresent() bool {
+	switch m.Status {
+	case "creator", "administrator", "member", "restricted":
+		return true
+	}
+	return false
 }
 
 // User represents a Telegram user or bot.
@@ -24,6 +75,9 @@ type User struct {
 	LastName  string `json:"last_name,omitempty"`
 	UserName  string `json:"username,omitempty"`
 	IsBot     bool   `json:"is_bot,omitempty"`
+
+	// LanguageCode is the IETF language tag of the user's client
+	LanguageCode string `json:"language_code,omitempty"`
 }
 
 // Chat represents a Telegram chat.
@@ -33,6 +87,14 @@ type Chat struct {
 	Title string `json:"title,omitempty"`
 }
 
+// ChatFullInfo contains full information about a chat, returned by getChat.
+type ChatFullInfo struct {
+	ID       int64  `json:"id"`
+	Type     string `json:"type"`
+	Title    string `json:"title,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
 // IsPrivate returns true if the chat is a private chat.
 func (c *Chat) IsPrivate() bool {
 	return c.Type == "private"
@@ -50,6 +112,9 @@ type Message struct {
 	Entities        []MessageEntity `json:"entities,omitempty"`
 	CaptionEntities []MessageEntity `json:"caption_entities,omitempty"`
 
+	// Forwarded messages
+	ForwardOrigin *MessageOrigin `json:"forward_origin,omitempty"`
+
 	// Reply and quote
 	ReplyToMessage *Message   `json:"reply_to_message,omitempty"`
 	Quote          *TextQuote `json:"quote,omitempty"`
@@ -88,6 +153,29 @@ func (m *Message) Command() string {
 	return cmd
 }
 
+// CommandArgs returns the text after the command, trimmed.
+func (m *Message) CommandArgs() string {
+	if !m.IsCommand() {
+		return ""
+	}
+	return strings.TrimSpace(m.Text[m.Entities[0].Length:])
+}
+
+// IsForward returns true if the message was forwarded.
+func (m *Message) IsForward() bool {
+	return m.ForwardOrigin != nil
+}
+
+// MessageOrigin describes the origin of a forwarded message (Bot API 7.0+).
+type MessageOrigin struct {
+	Type           string `json:"type"` // "user", "hidden_user", "chat" or "channel"
+	Date           int    `json:"date"`
+	SenderUser     *User  `json:"sender_user,omitempty"`
+	SenderUserName string `json:"sender_user_name,omitempty"`
+	SenderChat     *Chat  `json:"sender_chat,omitempty"`
+	Chat           *Chat  `json:"chat,omitempty"`
+}
+
 // TextQuote contains the quoted part of a replied-to message (Bot API 7.0+).
 type TextQuote struct {
 	Text     string          `json:"text"`
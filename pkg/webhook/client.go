@@ -0,0 +1,109 @@
+// Package webhook implements a client for a user-supplied decision service:
+// the bot POSTs a message with its heuristic features and the service
+// answers with the action to take.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Request is the JSON body POSTed to the decision endpoint
+type Request struct {
+	ChatID    string     `json:"chat_id"`
+	ChatTitle string     `json:"chat_title"`
+	UserID    string     `json:"user_id"`
+	UserName  string     `json:"user_name"`
+	MessageID string     `json:"message_id"`
+	Text      string     `json:"text"`
+	MediaType *string    `json:"media_type,omitempty"`
+	Score     int        `json:"score"`
+	Features  e.Features `json:"features"`
+}
+
+// Decision is the JSON body expected in response. Action is one of the
+// e.ActionKind values, or empty if the service has no opinion on the message.
+type Decision struct {
+	Action   e.ActionKind   `json:"action"`
+	Category e.SpamCategory `json:"category,omitempty"`
+	Note     string         `json:"note"`
+}
+
+// IsAbstain reports whether the service declined to decide
+func (d Decision) IsAbstain() bool {
+	return d.Action == ""
+}
+
+type Client struct {
+	url        string
+	token      string
+	httpClient HTTPClient
+}
+
+// NewClient creates a client for the decision endpoint at url. If token is
+// not empty it is sent as a bearer token so the endpoint can authenticate
+// the bot.
+func NewClient(url, token string, httpClient HTTPClient) *Client {
+	return &Client{
+		url:        url,
+		token:      token,
+		httpClient: httpClient,
+	}
+}
+
+// Decide asks the decision service what to do with a message
+func (c *Client) Decide(ctx context.Context, request Request) (Decision, error) {
+	var decision Decision
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return decision, fmt.Errorf("marshaling body: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return decision, fmt.Errorf("creating request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return decision, fmt.Errorf("doing request: %w", err)
+	}
+	defer func() { _ = res.Body.Close() }()
+
+	resBody, err := io.ReadAll(res.Body)
+	if err != nil {
+		return decision, fmt.Errorf("reading response body: %w", err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return decision, fmt.Errorf("unexpected status code: %d: %s", res.StatusCode, resBody)
+	}
+
+	if err = json.Unmarshal(resBody, &decision); err != nil {
+		return decision, fmt.Errorf("decoding response: %w", err)
+	}
+
+	switch decision.Action {
+	case "", e.ActionKindNoop, e.ActionKindErase, e.ActionKindBan:
+	default:
+		return decision, fmt.Errorf("unknown action %q", decision.Action)
+	}
+
+	return decision, nil
+}
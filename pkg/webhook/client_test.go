@@ -0,0 +1,126 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	e "nuclight.org/antispam-tg-bot/pkg/entities"
+)
+
+func TestClient_Decide(t *testing.T) {
+	media := "photo"
+	request := Request{
+		ChatID:    "-100",
+		ChatTitle: "Chat",
+		UserID:    "42",
+		UserName:  "spammer",
+		MessageID: "7",
+		Text:      "buy now https://example.com",
+		MediaType: &media,
+		Score:     3,
+		Features:  e.Features{TextLength: 27, LinkCount: 1},
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", got)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the bearer token", got)
+		}
+
+		var got Request
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("decoding request: %v", err)
+		}
+		if got.ChatID != request.ChatID || got.UserID != request.UserID || got.MessageID != request.MessageID ||
+			got.Text != request.Text || got.MediaType == nil || *got.MediaType != media || got.Score != request.Score ||
+			got.Features != request.Features {
+			t.Errorf("request = %+v, want %+v", got, request)
+		}
+
+		_, _ = w.Write([]byte(`{"action":"erase","category":"crypto_scam","note":"known scam"}`))
+	}))
+	defer server.Close()
+
+	decision, err := NewClient(server.URL, "secret", server.Client()).Decide(context.Background(), request)
+	if err != nil {
+		t.Fatalf("Decide: %v", err)
+	}
+	want := Decision{Action: e.ActionKindErase, Category: e.SpamCategoryCryptoScam, Note: "known scam"}
+	if decision != want {
+		t.Errorf("decision = %+v, want %+v", decision, want)
+	}
+}
+
+func TestClient_Decide_Responses(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		abstain bool
+		wantErr string
+	}{
+		{name: "abstain", status: http.StatusOK, body: `{}`, abstain: true},
+		{name: "noop", status: http.StatusOK, body: `{"action":"noop"}`},
+		{name: "error status", status: http.StatusInternalServerError, body: "overloaded", wantErr: "500: overloaded"},
+		{name: "invalid json", status: http.StatusOK, body: "not json", wantErr: "decoding response"},
+		{name: "unknown action", status: http.StatusOK, body: `{"action":"kick"}`, wantErr: `unknown action "kick"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := r.Header.Get("Authorization"); got != "" {
+					t.Errorf("Authorization = %q, want none without a token", got)
+				}
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			decision, err := NewClient(server.URL, "", server.Client()).Decide(context.Background(), Request{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Decide error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Decide: %v", err)
+			}
+			if decision.IsAbstain() != tt.abstain {
+				t.Errorf("IsAbstain = %v, want %v", decision.IsAbstain(), tt.abstain)
+			}
+		})
+	}
+}
+
+func TestClient_Decide_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := NewClient(server.URL, "", server.Client()).Decide(ctx, Request{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Decide error = %v, want a deadline exceeded error", err)
+	}
+}